@@ -0,0 +1,441 @@
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Action is what de-identification does with an attribute, see P3.15
+// Table E.1-1.
+type Action int
+
+const (
+	// Keep keeps the attribute. Sequences are kept with their items
+	// de-identified.
+	Keep Action = iota
+	// Remove removes the attribute (X).
+	Remove
+	// Zero replaces the value with an empty value (Z).
+	Zero
+	// Dummy replaces the value with a dummy value of the same VR (D).
+	// In a sequence, every attribute of every item gets a dummy value.
+	Dummy
+	// ReplaceUID replaces each UID with a new UID (U), see Options.UIDMapper.
+	ReplaceUID
+	// Replace replaces the value with a fixed value, see
+	// Options.Replacements.
+	Replace
+	// Hash replaces each value with the first hex digits of its
+	// HMAC-SHA256, see Options.HashKey, so that equal values stay equal,
+	// e.g., for AccessionNumber. UIDs are replaced as with ReplaceUID.
+	Hash
+)
+
+// DeidentificationMethod is the value of DeidentificationMethod (0012,0063)
+// set by Anonymize.
+const DeidentificationMethod = "DICOM PS3.15 E.1 Basic Application Level Confidentiality Profile"
+
+// Options controls Anonymize.
+type Options struct {
+	// KeepPrivateTags keeps the private attributes (odd groups). By
+	// default they are removed.
+	KeepPrivateTags bool
+
+	// UIDMapper 把原来的UID换成新的UID. 同一个UIDMapper总是把同一个UID换成同一个新UID,
+	// 所以同一个study的instances去标识化之后仍然在同一个study和series里, 引用也仍然有效.
+	// nil时使用NewHashUIDMapper(UIDKey)
+	UIDMapper UIDMapper
+
+	// UIDKey 是UIDMapper为nil时NewHashUIDMapper的key. UIDMapper和UIDKey都是nil时,
+	// 每次调用Anonymize都生成一个新的随机key: 分开去标识化的同一个study的instances会
+	// 得到不同的StudyInstanceUID和SeriesInstanceUID, 它们之间的引用也会失效.
+	// 去标识化多个instances时, 所有调用要用同一个UIDMapper (NewUIDTable或者
+	// NewHashUIDMapper) 或者同一个UIDKey, 或者用ExportStudy
+	UIDKey []byte
+
+	// Actions overrides the Basic Profile action for individual tags,
+	// e.g., Keep for StudyDescription to retain it.
+	Actions map[dicomtag.Tag]Action
+
+	// Replacements 是Replace action的value
+	Replacements map[dicomtag.Tag]string
+
+	// HashKey 是Hash action的HMAC key. 与UIDKey相同, nil表示每次调用Anonymize时生成一个随机key,
+	// 所以不同调用的同一个value得到不同的hash
+	HashKey []byte
+
+	// PrivateActions are the actions of private attributes, identified by
+	// their private creator, since the element numbers of a creator's
+	// block differ between files. They take precedence over
+	// KeepPrivateTags. The creator element of a block is kept if one of
+	// its attributes is.
+	PrivateActions []PrivateAction
+
+	// DateShiftDays 不为0时, Basic Profile要清空或换成dummy value的DA和DT attributes被保留,
+	// 但是往前移DateShiftDays天 (P3.15 E.3.6 Retain Longitudinal Temporal Information
+	// with Modified Dates Option). 同一个病人应该用同样的天数, 见Pseudonymizer.DateShiftDays.
+	// 要删除的dates (X) 仍然被删除
+	DateShiftDays int
+}
+
+// PrivateAction is the action of a private attribute (gggg,xxee) in the
+// block reserved by private creator "Creator", e.g., {"SIEMENS CSA HEADER",
+// 0x0029, 0x10, Keep} for (0029,xx10).
+type PrivateAction struct {
+	Creator string
+	Group   uint16
+	// Element 是element number的低8位, 即(gggg,xxee)中的ee
+	Element uint8
+	Action  Action
+	// Value 是Replace action的value
+	Value string
+}
+
+// Anonymize de-identifies "ds" following the Basic Application
+// Level Confidentiality Profile of P3.15 E.1: each attribute listed in
+// Table E.1-1 is removed, emptied, given a dummy value or, for UIDs,
+// replaced, also inside sequences. Private attributes, curves (group 50xx),
+// overlay data and comments, and the Results IE (group 4008) are removed.
+// PatientIdentityRemoved is set to "YES" and DeidentificationMethod to
+// DeidentificationMethod. If an error is returned, "ds" is unchanged.
+//
+// The file meta group is de-identified too, so MediaStorageSOPInstanceUID
+// still matches SOPInstanceUID. The changes are not recorded in
+// ds.ChangeLog, since the old values are what is being removed.
+//
+// With the zero Options, UIDs are replaced using a random key chosen for
+// this call only: each call puts its data set in a study and series of its
+// own. To de-identify the instances of a study or series one call at a
+// time and keep them together, pass the same Options.UIDMapper (e.g., one
+// NewUIDTable) or Options.UIDKey to every call, or use ExportStudy.
+func Anonymize(ds *dicom.DataSet, opts Options) error {
+	a := &anonymizer{opts: opts, uids: opts.UIDMapper, hashKey: opts.HashKey}
+	if a.uids == nil {
+		key := opts.UIDKey
+		if key == nil {
+			var err error
+			if key, err = randomKey(); err != nil {
+				return err
+			}
+		}
+		a.uids = NewHashUIDMapper(key)
+	}
+	if a.hashKey == nil {
+		var err error
+		if a.hashKey, err = randomKey(); err != nil {
+			return err
+		}
+	}
+	elems, err := a.elements(ds.Elements, false)
+	if err != nil {
+		return err
+	}
+	for _, elem := range []*dicom.Element{
+		dicom.MustNewElement(dicomtag.PatientIdentityRemoved, "YES"),
+		dicom.MustNewElement(dicomtag.DeidentificationMethod, DeidentificationMethod),
+	} {
+		elems = setElement(elems, elem)
+	}
+	ds.Elements = elems
+	return nil
+}
+
+func randomKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("anonymize.Anonymize: %v", err)
+	}
+	return key, nil
+}
+
+type anonymizer struct {
+	opts    Options
+	uids    UIDMapper
+	hashKey []byte
+}
+
+// privateBlock 是一个private creator保留的block, 例如(0029,10xx)的block是{0x0029, 0x10}
+type privateBlock struct {
+	group uint16
+	block uint8
+}
+
+// privateCreators 返回同一层elements中的private creators
+func privateCreators(elems []*dicom.Element) map[privateBlock]string {
+	creators := map[privateBlock]string{}
+	for _, elem := range elems {
+		if elem.Tag.Group%2 == 1 && elem.Tag.Element >= 0x0010 && elem.Tag.Element <= 0x00ff {
+			if creator, err := elem.GetString(); err == nil {
+				creators[privateBlock{elem.Tag.Group, uint8(elem.Tag.Element)}] = strings.TrimSpace(creator)
+			}
+		}
+	}
+	return creators
+}
+
+// privateAction 返回private tag在Options.PrivateActions中的action. creators是同一层的private creators
+func (a *anonymizer) privateAction(tag dicomtag.Tag, creators map[privateBlock]string) (PrivateAction, bool) {
+	if tag.Element >= 0x0010 && tag.Element <= 0x00ff {
+		// private creator: 它的block中有attribute被保留时保留
+		creator := creators[privateBlock{tag.Group, uint8(tag.Element)}]
+		for _, pa := range a.opts.PrivateActions {
+			if pa.Creator == creator && pa.Group == tag.Group && pa.Action != Remove {
+				return PrivateAction{Action: Keep}, true
+			}
+		}
+		return PrivateAction{}, false
+	}
+	creator, ok := creators[privateBlock{tag.Group, uint8(tag.Element >> 8)}]
+	if !ok {
+		return PrivateAction{}, false
+	}
+	for _, pa := range a.opts.PrivateActions {
+		if pa.Creator == creator && pa.Group == tag.Group && pa.Element == uint8(tag.Element) {
+			return pa, true
+		}
+	}
+	return PrivateAction{}, false
+}
+
+// action 返回elem的action和Replace的value. Options.Actions优先于Options.PrivateActions和Basic Profile
+func (a *anonymizer) action(tag dicomtag.Tag, creators map[privateBlock]string) (Action, string) {
+	if action, ok := a.opts.Actions[tag]; ok {
+		return action, a.opts.Replacements[tag]
+	}
+	switch {
+	case tag.Group%2 == 1 && tag.Element == 0x0000:
+		// private group length: 去掉或修改private attributes之后就不再正确
+		return Remove, ""
+	case tag.Group%2 == 1:
+		if pa, ok := a.privateAction(tag, creators); ok {
+			return pa.Action, pa.Value
+		}
+		if a.opts.KeepPrivateTags {
+			return Keep, ""
+		}
+		return Remove, ""
+	case tag.Group&0xff00 == 0x5000, // Curve Data
+		tag.Group&0xff00 == 0x6000 && (tag.Element == 0x3000 || tag.Element == 0x4000), // Overlay Data, Overlay Comments
+		tag.Group == 0x4008: // Results IE
+		return Remove, ""
+	}
+	return basicProfile[tag], ""
+}
+
+// elements 返回去标识化之后的elements. dummy为true时(在一个Dummy的sequence里)
+// 所有的elements都替换成dummy value. 修改都在copy上做, elems本身不变, 所以出错时
+// data set不会只被去标识化了一半
+func (a *anonymizer) elements(elems []*dicom.Element, dummy bool) ([]*dicom.Element, error) {
+	var result []*dicom.Element
+	creators := privateCreators(elems)
+	for _, elem := range elems {
+		action, value := a.action(elem.Tag, creators)
+		if dummy && action != Remove {
+			action = Dummy
+		}
+		if action == Remove {
+			continue
+		}
+		c := *elem
+		c.Value = append([]interface{}(nil), elem.Value...)
+		if err := a.apply(&c, action, value); err != nil {
+			return nil, err
+		}
+		result = append(result, &c)
+	}
+	return result, nil
+}
+
+func (a *anonymizer) apply(elem *dicom.Element, action Action, value string) error {
+	if a.opts.DateShiftDays != 0 && (action == Zero || action == Dummy) && (elem.VR == "DA" || elem.VR == "DT") {
+		return a.shiftDates(elem)
+	}
+	if elem.VR == "SQ" {
+		switch action {
+		case Replace, Hash:
+			return fmt.Errorf("anonymize.Anonymize: %v: can't replace or hash a sequence", dicomtag.DebugString(elem.Tag))
+		case Zero:
+			elem.Value = nil
+			return nil
+		case Keep, Dummy, ReplaceUID:
+			for i, value := range elem.Value {
+				item, ok := value.(*dicom.Element)
+				if !ok {
+					return fmt.Errorf("anonymize.Anonymize: %v: found non-item value %v", dicomtag.DebugString(elem.Tag), value)
+				}
+				var children []*dicom.Element
+				for _, v := range item.Value {
+					if child, ok := v.(*dicom.Element); ok {
+						children = append(children, child)
+					}
+				}
+				children, err := a.elements(children, action == Dummy)
+				if err != nil {
+					return err
+				}
+				newItem := *item
+				newItem.Value = make([]interface{}, len(children))
+				for j, child := range children {
+					newItem.Value[j] = child
+				}
+				elem.Value[i] = &newItem
+			}
+		}
+		return nil
+	}
+
+	switch action {
+	case Zero:
+		elem.Value = nil
+	case Dummy:
+		if elem.VR == "UI" {
+			return a.replaceUIDs(elem)
+		}
+		dummy := dummyValue(elem)
+		if dummy == nil {
+			elem.Value = nil
+			return nil
+		}
+		if len(elem.Value) == 0 {
+			elem.Value = []interface{}{dummy}
+		}
+		for i := range elem.Value {
+			elem.Value[i] = dummy
+		}
+	case ReplaceUID:
+		return a.replaceUIDs(elem)
+	case Replace:
+		if dicomtag.GetVRKind(elem.Tag, elem.VR) != dicomtag.VRStringList {
+			return fmt.Errorf("anonymize.Anonymize: %v: can't replace a value of VR %s", dicomtag.DebugString(elem.Tag), elem.VR)
+		}
+		elem.Value = []interface{}{value}
+	case Hash:
+		return a.hash(elem)
+	}
+	return nil
+}
+
+// hashVRs 是Hash可以用在的VR. 其他string VR (例如DA, IS) 的value有格式要求
+var hashVRs = map[string]bool{
+	"AE": true, "CS": true, "LO": true, "LT": true, "PN": true, "SH": true, "ST": true, "UC": true, "UT": true,
+}
+
+// hash 把elem的每个value换成它的HMAC-SHA256的大写hex, 截到VR的最大长度
+func (a *anonymizer) hash(elem *dicom.Element) error {
+	if elem.VR == "UI" {
+		return a.replaceUIDs(elem)
+	}
+	if !hashVRs[elem.VR] {
+		return fmt.Errorf("anonymize.Anonymize: %v: can't hash a value of VR %s", dicomtag.DebugString(elem.Tag), elem.VR)
+	}
+	for i, value := range elem.Value {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("anonymize.Anonymize: %v: found non-string value %v", dicomtag.DebugString(elem.Tag), value)
+		}
+		if s == "" {
+			continue
+		}
+		mac := hmac.New(sha256.New, a.hashKey)
+		mac.Write([]byte(s))
+		digest := strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+		if n := dicomtag.MaxValueLength(elem.VR); n > 0 && n < len(digest) {
+			digest = digest[:n]
+		}
+		elem.Value[i] = digest
+	}
+	return nil
+}
+
+// shiftDates 把elem的DA或DT values往前移Options.DateShiftDays天
+func (a *anonymizer) shiftDates(elem *dicom.Element) error {
+	for i, value := range elem.Value {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("anonymize.Anonymize: %v: found non-string value %v", dicomtag.DebugString(elem.Tag), value)
+		}
+		shifted, err := shiftDate(s, a.opts.DateShiftDays)
+		if err != nil {
+			return fmt.Errorf("anonymize.Anonymize: %v: %v", dicomtag.DebugString(elem.Tag), err)
+		}
+		elem.Value[i] = shifted
+	}
+	return nil
+}
+
+func (a *anonymizer) replaceUIDs(elem *dicom.Element) error {
+	for i, value := range elem.Value {
+		uid, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("anonymize.Anonymize: %v: found non-string value %v", dicomtag.DebugString(elem.Tag), value)
+		}
+		if uid == "" {
+			continue
+		}
+		newUID, err := a.uids.MapUID(uid)
+		if err != nil {
+			return fmt.Errorf("anonymize.Anonymize: %v: %v", dicomtag.DebugString(elem.Tag), err)
+		}
+		elem.Value[i] = newUID
+	}
+	return nil
+}
+
+// dummyValues 是string VR的dummy value
+var dummyValues = map[string]string{
+	"AS": "000Y",
+	"DA": "19000101",
+	"DS": "0",
+	"DT": "19000101000000",
+	"IS": "0",
+	"TM": "000000",
+}
+
+// dummyValue 返回和elem的VR相符的dummy value. 没有合适的dummy value (例如OB) 时返回nil
+func dummyValue(elem *dicom.Element) interface{} {
+	switch dicomtag.GetVRKind(elem.Tag, elem.VR) {
+	case dicomtag.VRStringList, dicomtag.VRString, dicomtag.VRDate:
+		if v, ok := dummyValues[elem.VR]; ok {
+			return v
+		}
+		return "ANONYMIZED"
+	case dicomtag.VRUInt16List:
+		return uint16(0)
+	case dicomtag.VRInt16List:
+		return int16(0)
+	case dicomtag.VRUInt32List:
+		return uint32(0)
+	case dicomtag.VRInt32List:
+		return int32(0)
+	case dicomtag.VRUInt64List:
+		return uint64(0)
+	case dicomtag.VRInt64List:
+		return int64(0)
+	case dicomtag.VRFloat32List:
+		return float32(0)
+	case dicomtag.VRFloat64List:
+		return float64(0)
+	}
+	return nil
+}
+
+// setElement 替换elems中tag相同的element, 没有的话按tag顺序插入
+func setElement(elems []*dicom.Element, elem *dicom.Element) []*dicom.Element {
+	for i, e := range elems {
+		if e.Tag == elem.Tag {
+			elems[i] = elem
+			return elems
+		}
+	}
+	elems = append(elems, elem)
+	sort.SliceStable(elems, func(i, j int) bool { return elems[i].Tag.Compare(elems[j].Tag) < 0 })
+	return elems
+}
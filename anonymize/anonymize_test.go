@@ -0,0 +1,223 @@
+package anonymize_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/anonymize"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymize(t *testing.T) {
+	newDataSet := func() *dicom.DataSet {
+		return &dicom.DataSet{Elements: []*dicom.Element{
+			dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, "1.2.840.10008.5.1.4.1.1.7"),
+			dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, "1.2.3.4.5"),
+			dicom.MustNewElement(dicomtag.TransferSyntaxUID, dicomuid.ExplicitVRLittleEndian),
+			dicom.MustNewElement(dicomtag.SOPClassUID, "1.2.840.10008.5.1.4.1.1.7"),
+			dicom.MustNewElement(dicomtag.SOPInstanceUID, "1.2.3.4.5"),
+			dicom.MustNewElement(dicomtag.StudyDate, "20200102"),
+			dicom.MustNewElement(dicomtag.Modality, "OT"),
+			dicom.MustNewElement(dicomtag.InstitutionName, "Some Hospital"),
+			dicom.MustNewElement(dicomtag.StudyDescription, "Chest"),
+			dicom.MustNewSequence(dicomtag.ReferencedImageSequence, []*dicom.Element{
+				dicom.MustNewElement(dicomtag.ReferencedSOPClassUID, "1.2.840.10008.5.1.4.1.1.7"),
+				dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, "1.2.3.4.6"),
+				dicom.MustNewElement(dicomtag.PatientName, "Zhang^San"),
+			}),
+			dicom.MustNewElement(dicomtag.PatientName, "Zhang^San"),
+			dicom.MustNewElement(dicomtag.PatientID, "P0001"),
+			dicom.MustNewElement(dicomtag.OtherPatientIDs, "P0002"),
+			dicom.MustNewElement(dicomtag.PatientAge, "040Y"),
+			dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3.4"),
+			dicom.MustNewElement(dicomtag.SeriesInstanceUID, "1.2.3.4.1"),
+			{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x0010}, VR: "LO", Value: []interface{}{"ACME"}},
+			{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1001}, VR: "LO", Value: []interface{}{"Zhang^San"}},
+		}}
+	}
+	find := func(ds *dicom.DataSet, tag dicomtag.Tag) *dicom.Element {
+		elem, err := ds.FindElementByTag(tag)
+		require.NoError(t, err, dicomtag.DebugString(tag))
+		return elem
+	}
+
+	ds := newDataSet()
+	key := []byte("uid key")
+	require.NoError(t, anonymize.Anonymize(ds, anonymize.Options{UIDKey: key}))
+
+	for _, tag := range []dicomtag.Tag{
+		dicomtag.StudyDescription, dicomtag.OtherPatientIDs, dicomtag.PatientAge,
+		{Group: 0x0009, Element: 0x0010}, {Group: 0x0009, Element: 0x1001},
+	} {
+		_, err := ds.FindElementByTag(tag)
+		assert.Error(t, err, "%s not removed", dicomtag.DebugString(tag))
+	}
+	for _, tag := range []dicomtag.Tag{dicomtag.PatientName, dicomtag.PatientID, dicomtag.StudyDate} {
+		assert.Empty(t, find(ds, tag).Value, dicomtag.DebugString(tag))
+	}
+	assert.Equal(t, "ANONYMIZED", find(ds, dicomtag.InstitutionName).MustGetString())
+	assert.Equal(t, "OT", find(ds, dicomtag.Modality).MustGetString())
+	assert.Equal(t, "YES", find(ds, dicomtag.PatientIdentityRemoved).MustGetString())
+	assert.Equal(t, anonymize.DeidentificationMethod, find(ds, dicomtag.DeidentificationMethod).MustGetString())
+
+	// UIDs are replaced consistently; SOP classes are kept.
+	uid := find(ds, dicomtag.SOPInstanceUID).MustGetString()
+	assert.True(t, strings.HasPrefix(uid, "2.25."), uid)
+	assert.True(t, len(uid) <= 64, uid)
+	assert.Equal(t, uid, find(ds, dicomtag.MediaStorageSOPInstanceUID).MustGetString())
+	assert.Equal(t, "1.2.840.10008.5.1.4.1.1.7", find(ds, dicomtag.SOPClassUID).MustGetString())
+	assert.NotEqual(t, "1.2.3.4", find(ds, dicomtag.StudyInstanceUID).MustGetString())
+
+	// Sequences are de-identified too.
+	item := find(ds, dicomtag.ReferencedImageSequence).Value[0].(*dicom.Element)
+	require.Len(t, item.Value, 3)
+	assert.Equal(t, "1.2.840.10008.5.1.4.1.1.7", item.Value[0].(*dicom.Element).MustGetString())
+	ref := item.Value[1].(*dicom.Element).MustGetString()
+	assert.True(t, strings.HasPrefix(ref, "2.25."), ref)
+	assert.Empty(t, item.Value[2].(*dicom.Element).Value)
+
+	// The same key gives the same UIDs, e.g., for another instance of the
+	// same study; the result can be written.
+	ds2 := newDataSet()
+	require.NoError(t, anonymize.Anonymize(ds2, anonymize.Options{UIDKey: key}))
+	assert.Equal(t, ds, ds2)
+	var out bytes.Buffer
+	require.NoError(t, dicom.WriteDataSet(&out, ds2))
+
+	// Without a key, UIDs differ between calls.
+	ds2 = newDataSet()
+	require.NoError(t, anonymize.Anonymize(ds2, anonymize.Options{}))
+	assert.NotEqual(t, uid, find(ds2, dicomtag.SOPInstanceUID).MustGetString())
+
+	// Options.
+	ds = newDataSet()
+	require.NoError(t, anonymize.Anonymize(ds, anonymize.Options{
+		KeepPrivateTags: true,
+		Actions:         map[dicomtag.Tag]anonymize.Action{dicomtag.StudyDescription: anonymize.Keep},
+	}))
+	assert.Equal(t, "Chest", find(ds, dicomtag.StudyDescription).MustGetString())
+	assert.Equal(t, "ACME", find(ds, dicomtag.Tag{Group: 0x0009, Element: 0x0010}).MustGetString())
+
+	// An error, here after the UIDs, PatientName and the sequence were
+	// de-identified, leaves the data set unchanged.
+	ds = newDataSet()
+	require.Error(t, anonymize.Anonymize(ds, anonymize.Options{
+		Actions: map[dicomtag.Tag]anonymize.Action{dicomtag.PatientAge: anonymize.Hash},
+	}))
+	assert.Equal(t, newDataSet(), ds)
+}
+
+func TestUIDMapper(t *testing.T) {
+	m := anonymize.NewHashUIDMapper([]byte("key"))
+	uid, err := m.MapUID("1.2.3")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(uid, "2.25."), uid)
+	uid2, err := anonymize.NewHashUIDMapper([]byte("key")).MapUID("1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, uid, uid2)
+	uid2, err = m.MapUID("1.2.4")
+	require.NoError(t, err)
+	assert.NotEqual(t, uid, uid2)
+
+	table := anonymize.NewUIDTable()
+	uid, err = table.MapUID("1.2.3")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(uid, "2.25."), uid)
+	uid2, err = table.MapUID("1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, uid, uid2)
+	uid2, err = table.MapUID("1.2.4")
+	require.NoError(t, err)
+	assert.NotEqual(t, uid, uid2)
+
+	// A saved table maps the UIDs of a second file of the same study the
+	// same way.
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.SOPInstanceUID, "1.2.3.9"),
+		dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3"),
+	}}
+	var saved bytes.Buffer
+	require.NoError(t, table.Save(&saved))
+	table, err = anonymize.LoadUIDTable(&saved)
+	require.NoError(t, err)
+	require.NoError(t, anonymize.Anonymize(ds, anonymize.Options{UIDMapper: table}))
+	elem, err := ds.FindElementByTag(dicomtag.StudyInstanceUID)
+	require.NoError(t, err)
+	assert.Equal(t, uid, elem.MustGetString())
+	newUID, ok := table.Lookup("1.2.3.9")
+	assert.True(t, ok)
+	elem, err = ds.FindElementByTag(dicomtag.SOPInstanceUID)
+	require.NoError(t, err)
+	assert.Equal(t, newUID, elem.MustGetString())
+
+	_, err = anonymize.LoadUIDTable(strings.NewReader("not json"))
+	assert.Error(t, err)
+}
+
+func TestAnonymizeRules(t *testing.T) {
+	newDataSet := func() *dicom.DataSet {
+		return &dicom.DataSet{Elements: []*dicom.Element{
+			dicom.MustNewElement(dicomtag.SOPInstanceUID, "1.2.3.4.5"),
+			dicom.MustNewElement(dicomtag.StudyDate, "20200102"),
+			dicom.MustNewElement(dicomtag.SeriesDate, "20200102"),
+			dicom.MustNewElement(dicomtag.AccessionNumber, "A123"),
+			dicom.MustNewElement(dicomtag.InstitutionName, "Some Hospital"),
+			dicom.MustNewElement(dicomtag.PatientName, "Zhang^San"),
+			dicom.MustNewElement(dicomtag.PatientBirthDate, "19800301"),
+			dicom.MustNewElement(dicomtag.Tag{Group: 0x0029, Element: 0x0000}, uint32(80)),
+			{Tag: dicomtag.Tag{Group: 0x0029, Element: 0x0010}, VR: "LO", Value: []interface{}{"ACME 1"}},
+			{Tag: dicomtag.Tag{Group: 0x0029, Element: 0x0011}, VR: "LO", Value: []interface{}{"ACME 2"}},
+			{Tag: dicomtag.Tag{Group: 0x0029, Element: 0x1001}, VR: "LO", Value: []interface{}{"keep me"}},
+			{Tag: dicomtag.Tag{Group: 0x0029, Element: 0x1002}, VR: "LO", Value: []interface{}{"Zhang^San"}},
+			{Tag: dicomtag.Tag{Group: 0x0029, Element: 0x1101}, VR: "LO", Value: []interface{}{"Zhang^San"}},
+		}}
+	}
+	opts := anonymize.Options{
+		HashKey: []byte("hash key"),
+		Actions: map[dicomtag.Tag]anonymize.Action{
+			dicomtag.AccessionNumber: anonymize.Hash,
+			dicomtag.InstitutionName: anonymize.Replace,
+		},
+		Replacements: map[dicomtag.Tag]string{dicomtag.InstitutionName: "Research"},
+		PrivateActions: []anonymize.PrivateAction{
+			{Creator: "ACME 1", Group: 0x0029, Element: 0x01, Action: anonymize.Keep},
+			{Creator: "ACME 1", Group: 0x0029, Element: 0x02, Action: anonymize.Replace, Value: "X"},
+		},
+		DateShiftDays: 10,
+	}
+	ds := newDataSet()
+	require.NoError(t, anonymize.Anonymize(ds, opts))
+	values := map[dicomtag.Tag]string{}
+	for _, elem := range ds.Elements {
+		values[elem.Tag], _ = elem.GetString()
+	}
+	accession := values[dicomtag.AccessionNumber]
+	assert.Len(t, accession, 16) // SH
+	assert.NotEqual(t, "A123", accession)
+	assert.Equal(t, "Research", values[dicomtag.InstitutionName])
+	assert.Equal(t, "20191223", values[dicomtag.StudyDate])
+	assert.Equal(t, "19800220", values[dicomtag.PatientBirthDate])
+	assert.NotContains(t, values, dicomtag.SeriesDate)
+	assert.Equal(t, "ACME 1", values[dicomtag.Tag{Group: 0x0029, Element: 0x0010}])
+	assert.Equal(t, "keep me", values[dicomtag.Tag{Group: 0x0029, Element: 0x1001}])
+	assert.Equal(t, "X", values[dicomtag.Tag{Group: 0x0029, Element: 0x1002}])
+	assert.NotContains(t, values, dicomtag.Tag{Group: 0x0029, Element: 0x0011})
+	assert.NotContains(t, values, dicomtag.Tag{Group: 0x0029, Element: 0x1101})
+	assert.NotContains(t, values, dicomtag.Tag{Group: 0x0029, Element: 0x0000})
+
+	// 同一个HashKey得到同样的hash
+	ds = newDataSet()
+	require.NoError(t, anonymize.Anonymize(ds, opts))
+	elem, err := ds.FindElementByTag(dicomtag.AccessionNumber)
+	require.NoError(t, err)
+	assert.Equal(t, accession, elem.MustGetString())
+
+	opts.Actions = map[dicomtag.Tag]anonymize.Action{dicomtag.StudyDate: anonymize.Hash}
+	opts.DateShiftDays = 0
+	assert.Error(t, anonymize.Anonymize(newDataSet(), opts))
+}
@@ -0,0 +1,175 @@
+package anonymize
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomdir"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// ExportManifestName 是ExportStudy的zip中manifest的文件名
+const ExportManifestName = "manifest.json"
+
+// ExportOptions controls ExportStudy.
+type ExportOptions struct {
+	// Options 是每个instance的Anonymize options. UIDMapper为nil时使用一个新的UIDTable,
+	// HashKey为nil时使用一个随机key, 整个export共用, 所以study里的UIDs, 引用和hash
+	// 仍然一致
+	Options Options
+
+	// Pseudonymizer 如果不为nil, PatientID和PatientName被换成原PatientID的pseudonym,
+	// 日期按它的DateShiftDays移动 (覆盖Options.DateShiftDays), 这样同一个病人不同次
+	// 的export仍然能关联. nil时PatientID和PatientName按Options处理, 默认被清空
+	Pseudonymizer *Pseudonymizer
+
+	// FileSetID 是DICOMDIR的FileSetID, 最多16个字符, 可以为空
+	FileSetID string
+}
+
+// ExportStudy returns a zip file, for the "download anonymized study"
+// feature of a portal, with the instances of one study de-identified with
+// Anonymize and, optionally, pseudonymized:
+//
+//	DICOMDIR
+//	DICOM/IM000001, DICOM/IM000002, ...
+//	manifest.json
+//
+// The DICOMDIR references the instances as dicomdir.Export does, and
+// manifest.json is the dicom.Manifest of the de-identified instances, with
+// the sizes and digests of the files in the zip. The zip is built in memory.
+// "datasets" are not modified. It returns an error if they are not all of
+// the same study.
+func ExportStudy(datasets []*dicom.DataSet, opts ExportOptions) (io.Reader, error) {
+	if len(datasets) == 0 {
+		return nil, fmt.Errorf("anonymize.ExportStudy: no data sets")
+	}
+	var studyUID string
+	for i, ds := range datasets {
+		uid, err := stringValue(ds, dicomtag.StudyInstanceUID)
+		if err != nil {
+			return nil, fmt.Errorf("anonymize.ExportStudy: data set #%d: %v", i, err)
+		}
+		if i == 0 {
+			studyUID = uid
+		} else if uid != studyUID {
+			return nil, fmt.Errorf("anonymize.ExportStudy: data set #%d is of study %s, not %s", i, uid, studyUID)
+		}
+	}
+
+	if opts.Pseudonymizer != nil {
+		if err := opts.Pseudonymizer.validate(); err != nil {
+			return nil, fmt.Errorf("anonymize.ExportStudy: %v", err)
+		}
+	}
+
+	anonOpts := opts.Options
+	if anonOpts.UIDMapper == nil {
+		anonOpts.UIDMapper = NewUIDTable()
+	}
+	if anonOpts.HashKey == nil {
+		var err error
+		if anonOpts.HashKey, err = randomKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	var exported []*dicom.DataSet
+	var files []dicomdir.File
+	for i, ds := range datasets {
+		out, err := exportDataSet(ds, anonOpts, opts.Pseudonymizer)
+		if err != nil {
+			return nil, fmt.Errorf("anonymize.ExportStudy: data set #%d: %v", i, err)
+		}
+		exported = append(exported, out)
+		files = append(files, dicomdir.File{ID: []string{"DICOM", fmt.Sprintf("IM%06d", i+1)}, DataSet: out})
+	}
+	dir, err := dicomdir.Build(opts.FileSetID, files)
+	if err != nil {
+		return nil, fmt.Errorf("anonymize.ExportStudy: %v", err)
+	}
+	manifest, err := dicom.BuildManifest(exported)
+	if err != nil {
+		return nil, fmt.Errorf("anonymize.ExportStudy: %v", err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := writeZipDataSet(zw, "DICOMDIR", dir); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := writeZipDataSet(zw, f.ID[0]+"/"+f.ID[1], f.DataSet); err != nil {
+			return nil, err
+		}
+	}
+	w, err := zw.Create(ExportManifestName)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// exportDataSet 返回ds去标识化之后的副本. 副本是写出再读回来的, 所以ds不被修改
+func exportDataSet(ds *dicom.DataSet, opts Options, p *Pseudonymizer) (*dicom.DataSet, error) {
+	var buf bytes.Buffer
+	if err := dicom.WriteDataSet(&buf, ds); err != nil {
+		return nil, err
+	}
+	out, err := dicom.ReadDataSetInBytes(buf.Bytes(), dicom.ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var patientID string
+	if p != nil {
+		if patientID, err = stringValue(out, dicomtag.PatientID); err != nil {
+			return nil, err
+		}
+		opts.DateShiftDays = p.DateShiftDays(patientID)
+	}
+	if err := Anonymize(out, opts); err != nil {
+		return nil, err
+	}
+	if p != nil {
+		pseudonym := p.PatientID(patientID)
+		out.Replace(dicom.MustNewElement(dicomtag.PatientID, pseudonym), "")
+		out.Replace(dicom.MustNewElement(dicomtag.PatientName, pseudonym), "")
+	}
+	return out, nil
+}
+
+func writeZipDataSet(zw *zip.Writer, name string, ds *dicom.DataSet) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	return dicom.WriteDataSet(w, ds)
+}
+
+// stringValue 返回tag的string value, 没有或者为空时返回错误
+func stringValue(ds *dicom.DataSet, tag dicomtag.Tag) (string, error) {
+	elem, err := ds.FindElementByTag(tag)
+	if err != nil {
+		return "", err
+	}
+	s, err := elem.GetString()
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", fmt.Errorf("%v is empty", dicomtag.DebugString(tag))
+	}
+	return s, nil
+}
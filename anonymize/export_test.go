@@ -0,0 +1,96 @@
+package anonymize_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/anonymize"
+	"github.com/odincare/odicom/dicomdir"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportStudy(t *testing.T) {
+	var datasets []*dicom.DataSet
+	for _, uid := range []string{"1.2.3.4.5.1", "1.2.3.4.5.2"} {
+		ds, err := dicomtest.NewDataSet(dicomtest.Spec{})
+		require.NoError(t, err)
+		ds.Replace(dicom.MustNewElement(dicomtag.SOPInstanceUID, uid), "")
+		ds.Replace(dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, uid), "")
+		datasets = append(datasets, ds)
+	}
+	p := anonymize.NewPseudonymizer([]byte("site key"))
+	r, err := anonymize.ExportStudy(datasets, anonymize.ExportOptions{Pseudonymizer: p, FileSetID: "STUDY1"})
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	// 原来的data sets没有被修改
+	elem, err := datasets[0].FindElementByTag(dicomtag.PatientID)
+	require.NoError(t, err)
+	assert.Equal(t, "DICOMTEST-1", elem.MustGetString())
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		files[f.Name], err = ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close() // nolint: errcheck
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"DICOMDIR", "DICOM/IM000001", "DICOM/IM000002", anonymize.ExportManifestName}, names)
+
+	var studyUID string
+	for _, name := range []string{"DICOM/IM000001", "DICOM/IM000002"} {
+		ds, err := dicom.ReadDataSetInBytes(files[name], dicom.ReadOptions{})
+		require.NoError(t, err)
+		elem, err := ds.FindElementByTag(dicomtag.PatientID)
+		require.NoError(t, err)
+		assert.Equal(t, p.PatientID("DICOMTEST-1"), elem.MustGetString())
+		elem, err = ds.FindElementByTag(dicomtag.StudyInstanceUID)
+		require.NoError(t, err)
+		assert.NotEqual(t, "1.2.826.0.1.3680043.2.1143.1.2", elem.MustGetString())
+		if studyUID == "" {
+			studyUID = elem.MustGetString()
+		}
+		assert.Equal(t, studyUID, elem.MustGetString(), "same study after de-identification")
+		_, err = ds.FindElementByTag(dicomtag.InstitutionAddress)
+		assert.Error(t, err)
+	}
+
+	dir, err := dicomdir.Read(bytes.NewReader(files["DICOMDIR"]))
+	require.NoError(t, err)
+	var ids [][]string
+	require.NoError(t, dir.Walk(func(path []*dicomdir.Record) error {
+		if id := path[len(path)-1].FileID(); id != nil {
+			ids = append(ids, id)
+		}
+		return nil
+	}))
+	assert.Equal(t, [][]string{{"DICOM", "IM000001"}, {"DICOM", "IM000002"}}, ids)
+
+	var manifest dicom.Manifest
+	require.NoError(t, json.Unmarshal(files[anonymize.ExportManifestName], &manifest))
+	require.Len(t, manifest.Studies, 1)
+	assert.Equal(t, studyUID, manifest.Studies[0].StudyInstanceUID)
+	assert.Equal(t, 2, manifest.Studies[0].NumberOfInstances)
+	assert.Equal(t, int64(len(files["DICOM/IM000001"])), manifest.Studies[0].Series[0].Instances[0].Size)
+
+	other, err := dicomtest.NewDataSet(dicomtest.Spec{})
+	require.NoError(t, err)
+	other.Replace(dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3"), "")
+	_, err = anonymize.ExportStudy(append(datasets, other), anonymize.ExportOptions{})
+	assert.Error(t, err, "two studies")
+	_, err = anonymize.ExportStudy(nil, anonymize.ExportOptions{})
+	assert.Error(t, err)
+}
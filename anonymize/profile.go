@@ -0,0 +1,223 @@
+package anonymize
+
+import "github.com/odincare/odicom/dicomtag"
+
+// basicProfile 是P3.15 Table E.1-1 Basic Profile列出的attributes和它们的action.
+// 组合的action (例如X/Z) 取能保持IOD conformance的那一个: X/Z是Z, X/D, Z/D和X/Z/D是D,
+// X/Z/U*的sequence保留, 里面的UID按U替换. Keep的attribute不需要列出.
+// 字典里没有的retired attributes用tag值列出
+var basicProfile = map[dicomtag.Tag]Action{
+	dicomtag.AffectedSOPInstanceUID:                                       Remove,     // X
+	dicomtag.RequestedSOPInstanceUID:                                      ReplaceUID, // U
+	dicomtag.MediaStorageSOPInstanceUID:                                   ReplaceUID, // U
+	dicomtag.ReferencedSOPInstanceUIDInFile:                               ReplaceUID, // U
+	dicomtag.InstanceCreatorUID:                                           ReplaceUID, // U
+	dicomtag.SOPInstanceUID:                                               ReplaceUID, // U
+	dicomtag.StudyDate:                                                    Zero,       // Z
+	dicomtag.SeriesDate:                                                   Remove,     // X
+	dicomtag.AcquisitionDate:                                              Zero,       // X/Z
+	dicomtag.ContentDate:                                                  Dummy,      // Z/D
+	{Group: 0x0008, Element: 0x0024}:                                      Remove,     // OverlayDate (retired), X
+	{Group: 0x0008, Element: 0x0025}:                                      Remove,     // CurveDate (retired), X
+	dicomtag.AcquisitionDateTime:                                          Zero,       // X/Z
+	dicomtag.StudyTime:                                                    Zero,       // Z
+	dicomtag.SeriesTime:                                                   Remove,     // X
+	dicomtag.AcquisitionTime:                                              Zero,       // X/Z
+	dicomtag.ContentTime:                                                  Dummy,      // Z/D
+	{Group: 0x0008, Element: 0x0034}:                                      Remove,     // OverlayTime (retired), X
+	{Group: 0x0008, Element: 0x0035}:                                      Remove,     // CurveTime (retired), X
+	dicomtag.AccessionNumber:                                              Zero,       // Z
+	dicomtag.FailedSOPInstanceUIDList:                                     ReplaceUID, // U
+	dicomtag.InstitutionName:                                              Dummy,      // X/Z/D
+	dicomtag.InstitutionAddress:                                           Remove,     // X
+	dicomtag.InstitutionCodeSequence:                                      Dummy,      // X/Z/D
+	dicomtag.ReferringPhysicianName:                                       Zero,       // Z
+	dicomtag.ReferringPhysicianAddress:                                    Remove,     // X
+	dicomtag.ReferringPhysicianTelephoneNumbers:                           Remove,     // X
+	dicomtag.ReferringPhysicianIdentificationSequence:                     Remove,     // X
+	dicomtag.ContextGroupExtensionCreatorUID:                              ReplaceUID, // U
+	dicomtag.TimezoneOffsetFromUTC:                                        Remove,     // X
+	dicomtag.StationName:                                                  Dummy,      // X/Z/D
+	dicomtag.StudyDescription:                                             Remove,     // X
+	dicomtag.SeriesDescription:                                            Remove,     // X
+	dicomtag.InstitutionalDepartmentName:                                  Remove,     // X
+	dicomtag.PhysiciansOfRecord:                                           Remove,     // X
+	dicomtag.PhysiciansOfRecordIdentificationSequence:                     Remove,     // X
+	dicomtag.PerformingPhysicianName:                                      Remove,     // X
+	dicomtag.PerformingPhysicianIdentificationSequence:                    Remove,     // X
+	dicomtag.NameOfPhysiciansReadingStudy:                                 Remove,     // X
+	dicomtag.PhysiciansReadingStudyIdentificationSequence:                 Remove,     // X
+	dicomtag.OperatorsName:                                                Dummy,      // X/Z/D
+	dicomtag.OperatorIdentificationSequence:                               Remove,     // X
+	dicomtag.AdmittingDiagnosesDescription:                                Remove,     // X
+	dicomtag.AdmittingDiagnosesCodeSequence:                               Remove,     // X
+	dicomtag.ReferencedStudySequence:                                      Zero,       // X/Z
+	dicomtag.ReferencedPerformedProcedureStepSequence:                     Dummy,      // X/Z/D
+	dicomtag.ReferencedPatientSequence:                                    Remove,     // X
+	dicomtag.ReferencedImageSequence:                                      Keep,       // X/Z/U*
+	dicomtag.ReferencedSOPInstanceUID:                                     ReplaceUID, // U
+	dicomtag.TransactionUID:                                               ReplaceUID, // U
+	dicomtag.DerivationDescription:                                        Remove,     // X
+	dicomtag.SourceImageSequence:                                          Keep,       // X/Z/U*
+	dicomtag.IrradiationEventUID:                                          ReplaceUID, // U
+	{Group: 0x0008, Element: 0x4000}:                                      Remove,     // IdentifyingComments (retired), X
+	dicomtag.CreatorVersionUID:                                            ReplaceUID, // U
+	dicomtag.PatientName:                                                  Zero,       // Z
+	dicomtag.PatientID:                                                    Zero,       // Z
+	dicomtag.IssuerOfPatientID:                                            Remove,     // X
+	dicomtag.PatientBirthDate:                                             Zero,       // Z
+	dicomtag.PatientBirthTime:                                             Remove,     // X
+	dicomtag.PatientSex:                                                   Zero,       // Z
+	dicomtag.PatientInsurancePlanCodeSequence:                             Remove,     // X
+	dicomtag.PatientPrimaryLanguageCodeSequence:                           Remove,     // X
+	dicomtag.PatientPrimaryLanguageModifierCodeSequence:                   Remove,     // X
+	dicomtag.OtherPatientIDs:                                              Remove,     // X
+	dicomtag.OtherPatientNames:                                            Remove,     // X
+	dicomtag.OtherPatientIDsSequence:                                      Remove,     // X
+	dicomtag.PatientBirthName:                                             Remove,     // X
+	dicomtag.PatientAge:                                                   Remove,     // X
+	dicomtag.PatientSize:                                                  Remove,     // X
+	dicomtag.PatientWeight:                                                Remove,     // X
+	dicomtag.PatientAddress:                                               Remove,     // X
+	{Group: 0x0010, Element: 0x1050}:                                      Remove,     // InsurancePlanIdentification (retired), X
+	dicomtag.PatientMotherBirthName:                                       Remove,     // X
+	dicomtag.MilitaryRank:                                                 Remove,     // X
+	dicomtag.BranchOfService:                                              Remove,     // X
+	dicomtag.MedicalRecordLocator:                                         Remove,     // X
+	dicomtag.MedicalAlerts:                                                Remove,     // X
+	dicomtag.Allergies:                                                    Remove,     // X
+	dicomtag.CountryOfResidence:                                           Remove,     // X
+	dicomtag.RegionOfResidence:                                            Remove,     // X
+	dicomtag.PatientTelephoneNumbers:                                      Remove,     // X
+	dicomtag.EthnicGroup:                                                  Remove,     // X
+	dicomtag.Occupation:                                                   Remove,     // X
+	dicomtag.SmokingStatus:                                                Remove,     // X
+	dicomtag.AdditionalPatientHistory:                                     Remove,     // X
+	dicomtag.PregnancyStatus:                                              Remove,     // X
+	dicomtag.PatientReligiousPreference:                                   Remove,     // X
+	dicomtag.PatientSexNeutered:                                           Zero,       // X/Z
+	dicomtag.ResponsiblePerson:                                            Remove,     // X
+	dicomtag.ResponsibleOrganization:                                      Remove,     // X
+	dicomtag.PatientComments:                                              Remove,     // X
+	dicomtag.ContrastBolusAgent:                                           Dummy,      // Z/D
+	dicomtag.DeviceSerialNumber:                                           Dummy,      // X/Z/D
+	dicomtag.DeviceUID:                                                    ReplaceUID, // U
+	dicomtag.PlateID:                                                      Remove,     // X
+	dicomtag.GeneratorID:                                                  Remove,     // X
+	dicomtag.CassetteID:                                                   Remove,     // X
+	dicomtag.GantryID:                                                     Remove,     // X
+	dicomtag.ProtocolName:                                                 Dummy,      // X/D
+	dicomtag.AcquisitionDeviceProcessingDescription:                       Dummy,      // X/D
+	{Group: 0x0018, Element: 0x4000}:                                      Remove,     // AcquisitionComments (retired), X
+	dicomtag.DetectorID:                                                   Remove,     // X
+	dicomtag.AcquisitionProtocolDescription:                               Remove,     // X
+	dicomtag.EndAcquisitionDateTime:                                       Remove,     // X
+	dicomtag.ContributionDescription:                                      Remove,     // X
+	dicomtag.StudyInstanceUID:                                             ReplaceUID, // U
+	dicomtag.SeriesInstanceUID:                                            ReplaceUID, // U
+	dicomtag.StudyID:                                                      Zero,       // Z
+	dicomtag.FrameOfReferenceUID:                                          ReplaceUID, // U
+	dicomtag.SynchronizationFrameOfReferenceUID:                           ReplaceUID, // U
+	dicomtag.ImageComments:                                                Remove,     // X
+	dicomtag.FrameComments:                                                Remove,     // X
+	dicomtag.ConcatenationUID:                                             ReplaceUID, // U
+	dicomtag.DimensionOrganizationUID:                                     ReplaceUID, // U
+	dicomtag.PaletteColorLookupTableUID:                                   ReplaceUID, // U
+	{Group: 0x0028, Element: 0x4000}:                                      Remove,     // ImagePresentationComments (retired), X
+	{Group: 0x0032, Element: 0x0012}:                                      Remove,     // StudyIDIssuer (retired), X
+	{Group: 0x0032, Element: 0x1030}:                                      Remove,     // ReasonForStudy (retired), X
+	dicomtag.RequestingPhysician:                                          Remove,     // X
+	dicomtag.RequestingService:                                            Remove,     // X
+	dicomtag.RequestedProcedureDescription:                                Zero,       // X/Z
+	dicomtag.RequestedContrastAgent:                                       Remove,     // X
+	{Group: 0x0032, Element: 0x4000}:                                      Remove,     // StudyComments (retired), X
+	dicomtag.ReferencedPatientAliasSequence:                               Remove,     // X
+	dicomtag.AdmissionID:                                                  Remove,     // X
+	{Group: 0x0038, Element: 0x0011}:                                      Remove,     // IssuerOfAdmissionID (retired), X
+	dicomtag.AdmittingDate:                                                Remove,     // X
+	dicomtag.AdmittingTime:                                                Remove,     // X
+	{Group: 0x0038, Element: 0x0040}:                                      Remove,     // DischargeDiagnosisDescription (retired), X
+	dicomtag.SpecialNeeds:                                                 Remove,     // X
+	dicomtag.ServiceEpisodeID:                                             Remove,     // X
+	dicomtag.ServiceEpisodeDescription:                                    Remove,     // X
+	dicomtag.CurrentPatientLocation:                                       Remove,     // X
+	dicomtag.PatientState:                                                 Remove,     // X
+	dicomtag.VisitComments:                                                Remove,     // X
+	dicomtag.ScheduledStationAETitle:                                      Remove,     // X
+	dicomtag.ScheduledProcedureStepStartDate:                              Remove,     // X
+	dicomtag.ScheduledProcedureStepStartTime:                              Remove,     // X
+	dicomtag.ScheduledProcedureStepEndDate:                                Remove,     // X
+	dicomtag.ScheduledProcedureStepEndTime:                                Remove,     // X
+	dicomtag.ScheduledPerformingPhysicianName:                             Remove,     // X
+	dicomtag.ScheduledProcedureStepDescription:                            Remove,     // X
+	dicomtag.ScheduledPerformingPhysicianIdentificationSequence:           Remove,     // X
+	dicomtag.ScheduledStationName:                                         Remove,     // X
+	dicomtag.ScheduledProcedureStepLocation:                               Remove,     // X
+	dicomtag.PreMedication:                                                Remove,     // X
+	dicomtag.PerformedStationAETitle:                                      Remove,     // X
+	dicomtag.PerformedStationName:                                         Remove,     // X
+	dicomtag.PerformedLocation:                                            Remove,     // X
+	dicomtag.PerformedProcedureStepStartDate:                              Remove,     // X
+	dicomtag.PerformedProcedureStepStartTime:                              Remove,     // X
+	dicomtag.PerformedProcedureStepEndDate:                                Remove,     // X
+	dicomtag.PerformedProcedureStepEndTime:                                Remove,     // X
+	dicomtag.PerformedProcedureStepID:                                     Remove,     // X
+	dicomtag.PerformedProcedureStepDescription:                            Remove,     // X
+	dicomtag.RequestAttributesSequence:                                    Remove,     // X
+	dicomtag.CommentsOnThePerformedProcedureStep:                          Remove,     // X
+	dicomtag.AcquisitionContextSequence:                                   Remove,     // X
+	dicomtag.RequestedProcedureID:                                         Remove,     // X
+	dicomtag.PatientTransportArrangements:                                 Remove,     // X
+	dicomtag.RequestedProcedureLocation:                                   Remove,     // X
+	dicomtag.NamesOfIntendedRecipientsOfResults:                           Remove,     // X
+	dicomtag.IntendedRecipientsOfResultsIdentificationSequence:            Remove,     // X
+	dicomtag.PersonIdentificationCodeSequence:                             Dummy,      // D
+	dicomtag.PersonAddress:                                                Remove,     // X
+	dicomtag.PersonTelephoneNumbers:                                       Remove,     // X
+	dicomtag.RequestedProcedureComments:                                   Remove,     // X
+	dicomtag.OrderEnteredBy:                                               Remove,     // X
+	dicomtag.OrderEntererLocation:                                         Remove,     // X
+	dicomtag.OrderCallbackPhoneNumber:                                     Remove,     // X
+	dicomtag.PlacerOrderNumberImagingServiceRequest:                       Zero,       // Z
+	dicomtag.FillerOrderNumberImagingServiceRequest:                       Zero,       // Z
+	dicomtag.ImagingServiceRequestComments:                                Remove,     // X
+	dicomtag.ConfidentialityConstraintOnPatientDataDescription:            Remove,     // X
+	dicomtag.ReferencedGeneralPurposeScheduledProcedureStepTransactionUID: ReplaceUID, // U
+	dicomtag.ScheduledStationNameCodeSequence:                             Remove,     // X
+	dicomtag.ScheduledStationGeographicLocationCodeSequence:               Remove,     // X
+	dicomtag.PerformedStationNameCodeSequence:                             Remove,     // X
+	dicomtag.PerformedStationGeographicLocationCodeSequence:               Remove,     // X
+	dicomtag.ScheduledHumanPerformersSequence:                             Remove,     // X
+	dicomtag.ActualHumanPerformersSequence:                                Remove,     // X
+	dicomtag.HumanPerformerOrganization:                                   Remove,     // X
+	dicomtag.HumanPerformerName:                                           Remove,     // X
+	dicomtag.VerifyingOrganization:                                        Remove,     // X
+	dicomtag.VerifyingObserverSequence:                                    Dummy,      // D
+	dicomtag.VerifyingObserverName:                                        Dummy,      // D
+	dicomtag.AuthorObserverSequence:                                       Remove,     // X
+	dicomtag.ParticipantSequence:                                          Remove,     // X
+	dicomtag.CustodialOrganizationSequence:                                Remove,     // X
+	dicomtag.VerifyingObserverIdentificationCodeSequence:                  Zero,       // Z
+	dicomtag.PersonName:                                                   Dummy,      // D
+	dicomtag.UID:                                                          ReplaceUID, // U
+	dicomtag.ContentSequence:                                              Remove,     // X
+	dicomtag.GraphicAnnotationSequence:                                    Dummy,      // D
+	dicomtag.ContentCreatorName:                                           Zero,       // Z
+	dicomtag.ContentCreatorIdentificationCodeSequence:                     Remove,     // X
+	dicomtag.FiducialUID:                                                  ReplaceUID, // U
+	dicomtag.StorageMediaFileSetUID:                                       ReplaceUID, // U
+	dicomtag.IconImageSequence:                                            Remove,     // X
+	dicomtag.DigitalSignatureUID:                                          ReplaceUID, // U
+	dicomtag.ReferencedDigitalSignatureSequence:                           Remove,     // X
+	dicomtag.ReferencedSOPInstanceMACSequence:                             Remove,     // X
+	dicomtag.MAC:                                                          Remove,     // X
+	dicomtag.ModifiedAttributesSequence:                                   Remove,     // X
+	dicomtag.OriginalAttributesSequence:                                   Remove,     // X
+	dicomtag.TextString:                                                   Remove,     // X
+	dicomtag.ReferencedFrameOfReferenceUID:                                ReplaceUID, // U
+	dicomtag.RelatedFrameOfReferenceUID:                                   ReplaceUID, // U
+	dicomtag.DoseReferenceUID:                                             ReplaceUID, // U
+	dicomtag.ReviewerName:                                                 Zero,       // X/Z
+	dicomtag.DigitalSignaturesSequence:                                    Remove,     // X
+	dicomtag.DataSetTrailingPadding:                                       Remove,     // X
+}
@@ -0,0 +1,212 @@
+// Package anonymize de-identifies DICOM data sets: Anonymize implements the
+// Basic Application Level Confidentiality Profile of P3.15, and
+// Pseudonymizer replaces patient identities with consistent pseudonyms.
+// ExportStudy bundles a de-identified study as a zip with a DICOMDIR and a
+// manifest.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Pseudonymizer 生成确定的(deterministic) pseudonym: 同样的Key和PatientID总是
+// 得到同样的pseudonym和同样的日期偏移, 所以不同批次导出的数据仍然能关联到同一个病人.
+//
+// Key是site key, 必须保密: 知道Key的人可以对已知的PatientID算出pseudonym.
+type Pseudonymizer struct {
+	// Key 是HMAC-SHA256的key
+	Key []byte
+
+	// Prefix 加在每个pseudonym前面, 例如 "SITE1-"
+	Prefix string
+
+	// Length 是pseudonym中保留的hex字符数(不包括Prefix), 最多64.
+	// PatientID是LO, Prefix加上Length不能超过64个字符
+	Length int
+
+	// MaxDateShiftDays 是日期偏移的最大天数. 每个病人的日期会被往前移
+	// [1, MaxDateShiftDays]天. 0表示不移动日期
+	MaxDateShiftDays int
+}
+
+// NewPseudonymizer creates a Pseudonymizer with the site key "key",
+// 16-character pseudonyms and a date shift of up to a year.
+func NewPseudonymizer(key []byte) *Pseudonymizer {
+	return &Pseudonymizer{Key: key, Length: 16, MaxDateShiftDays: 365}
+}
+
+// 不同用途的HMAC用不同的label, 这样pseudonym和日期偏移之间没有关联
+const (
+	patientIDLabel = "patient-id"
+	dateShiftLabel = "date-shift"
+)
+
+func (p *Pseudonymizer) digest(label, id string) []byte {
+	mac := hmac.New(sha256.New, p.Key)
+	mac.Write([]byte(label))
+	mac.Write([]byte{0})
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+// PatientID returns the pseudonym for "id": Prefix followed by the first
+// Length hex digits of HMAC-SHA256(Key, id).
+func (p *Pseudonymizer) PatientID(id string) string {
+	digest := hex.EncodeToString(p.digest(patientIDLabel, id))
+	if p.Length > 0 && p.Length < len(digest) {
+		digest = digest[:p.Length]
+	}
+	return p.Prefix + digest
+}
+
+// DateShiftDays returns the number of days, in [1, MaxDateShiftDays], by
+// which the dates of patient "id" are moved into the past. It returns 0 if
+// MaxDateShiftDays is 0.
+func (p *Pseudonymizer) DateShiftDays(id string) int {
+	if p.MaxDateShiftDays <= 0 {
+		return 0
+	}
+	v := binary.BigEndian.Uint64(p.digest(dateShiftLabel, id))
+	return int(v%uint64(p.MaxDateShiftDays)) + 1
+}
+
+// ShiftDate shifts a DA ("YYYYMMDD") or DT ("YYYYMMDDHHMMSS.FFFFFF&ZZXX")
+// value of patient "id" by DateShiftDays(id). Only the date part of a DT
+// value changes. Empty values, and DT values of only a year or a year and a
+// month ("YYYY", "YYYYMM"), whose precision is coarser than the shift, are
+// returned as is.
+func (p *Pseudonymizer) ShiftDate(id, value string) (string, error) {
+	shifted, err := shiftDate(value, p.DateShiftDays(id))
+	if err != nil {
+		return "", fmt.Errorf("anonymize.ShiftDate: %v", err)
+	}
+	return shifted, nil
+}
+
+// shiftDate 把DA或DT value往前移days天, 只改变日期部分. 空value不变.
+// DT可以只有年或者年月, 后面可以有时区 (P3.5 6.2); 这样的value也不变
+func shiftDate(value string, days int) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	n := 0
+	for n < len(value) && n < 8 && value[n] >= '0' && value[n] <= '9' {
+		n++
+	}
+	if n == 4 || n == 6 {
+		rest := value[n:]
+		if _, err := time.Parse("200601"[:n], value[:n]); err != nil || rest != "" && (len(rest) != 5 || rest[0] != '+' && rest[0] != '-') {
+			return "", fmt.Errorf("invalid date '%s'", value)
+		}
+		return value, nil
+	}
+	if n < 8 {
+		return "", fmt.Errorf("invalid date '%s'", value)
+	}
+	date, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return "", fmt.Errorf("invalid date '%s': %v", value, err)
+	}
+	return date.AddDate(0, 0, -days).Format("20060102") + value[8:], nil
+}
+
+// maxPatientIDLength 是PatientID (LO) 最多的字符数
+const maxPatientIDLength = 64
+
+// validate 检查pseudonyms不超过PatientID的长度
+func (p *Pseudonymizer) validate() error {
+	n := 2 * sha256.Size
+	if p.Length > 0 && p.Length < n {
+		n = p.Length
+	}
+	if len(p.Prefix)+n > maxPatientIDLength {
+		return fmt.Errorf("Prefix '%s' and %d hex digits don't fit in the %d characters of a PatientID",
+			p.Prefix, n, maxPatientIDLength)
+	}
+	return nil
+}
+
+// Apply pseudonymizes "ds" in place. PatientID and PatientName are replaced
+// by PatientID(id), and every DA and DT value, including those in
+// sequences, is shifted by ShiftDate. It returns an error, without changing
+// ds, if ds has no PatientID, if a date can't be shifted, or if Prefix and
+// Length make pseudonyms longer than the 64 characters of a PatientID.
+// Other identifying attributes are left as they are.
+func (p *Pseudonymizer) Apply(ds *dicom.DataSet) error {
+	if err := p.validate(); err != nil {
+		return fmt.Errorf("anonymize.Apply: %v", err)
+	}
+	elem, err := ds.FindElementByTag(dicomtag.PatientID)
+	if err != nil {
+		return fmt.Errorf("anonymize.Apply: %v", err)
+	}
+	id, err := elem.GetString()
+	if err != nil {
+		return fmt.Errorf("anonymize.Apply: %v", err)
+	}
+	// 先找出所有的修改, 都没有问题才改ds
+	var changes pendingChanges
+	for _, elem := range ds.Elements {
+		if err := p.collectChanges(id, elem, &changes); err != nil {
+			return err
+		}
+	}
+	pseudonym := p.PatientID(id)
+	for _, elem := range changes.identities {
+		elem.Value = []interface{}{pseudonym}
+	}
+	for _, date := range changes.dates {
+		date.elem.Value[date.i] = date.shifted
+	}
+	return nil
+}
+
+// pendingChanges 是Apply要对data set做的修改
+type pendingChanges struct {
+	// identities 是要换成pseudonym的PatientID和PatientName
+	identities []*dicom.Element
+	dates      []shiftedDate
+}
+
+// shiftedDate 是elem.Value[i]移动之后的日期
+type shiftedDate struct {
+	elem    *dicom.Element
+	i       int
+	shifted string
+}
+
+func (p *Pseudonymizer) collectChanges(id string, elem *dicom.Element, changes *pendingChanges) error {
+	switch {
+	case elem.Tag == dicomtag.PatientID || elem.Tag == dicomtag.PatientName:
+		changes.identities = append(changes.identities, elem)
+	case elem.VR == "DA" || elem.VR == "DT":
+		for i, value := range elem.Value {
+			s, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("anonymize.Apply: %v: found non-string value %v", dicomtag.DebugString(elem.Tag), value)
+			}
+			shifted, err := p.ShiftDate(id, s)
+			if err != nil {
+				return fmt.Errorf("anonymize.Apply: %v: %v", dicomtag.DebugString(elem.Tag), err)
+			}
+			changes.dates = append(changes.dates, shiftedDate{elem: elem, i: i, shifted: shifted})
+		}
+	case elem.VR == "SQ" || elem.Tag == dicomtag.Item:
+		for _, value := range elem.Value {
+			if child, ok := value.(*dicom.Element); ok {
+				if err := p.collectChanges(id, child, changes); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package anonymize_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/anonymize"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPseudonymizer(t *testing.T) {
+	p := anonymize.NewPseudonymizer([]byte("site key"))
+	p.Prefix = "S1-"
+
+	id := p.PatientID("12345")
+	assert.Len(t, id, len("S1-")+16)
+	assert.Equal(t, "S1-", id[:3])
+	assert.Equal(t, id[3:], anonymize.NewPseudonymizer([]byte("site key")).PatientID("12345"),
+		"same key and ID give the same pseudonym")
+	assert.NotEqual(t, id, p.PatientID("12346"))
+	assert.NotEqual(t, id, anonymize.NewPseudonymizer([]byte("other key")).PatientID("12345"))
+
+	days := p.DateShiftDays("12345")
+	assert.True(t, days >= 1 && days <= 365, days)
+	assert.Equal(t, days, p.DateShiftDays("12345"))
+
+	p.MaxDateShiftDays = 1
+	date, err := p.ShiftDate("12345", "20200301")
+	require.NoError(t, err)
+	assert.Equal(t, "20200229", date)
+	date, err = p.ShiftDate("12345", "20200101120000.5+0800")
+	require.NoError(t, err)
+	assert.Equal(t, "20191231120000.5+0800", date)
+	// DT values of a year or a month are not shifted.
+	for _, dt := range []string{"2020", "202003", "202003+0800"} {
+		date, err = p.ShiftDate("12345", dt)
+		require.NoError(t, err)
+		assert.Equal(t, dt, date)
+	}
+	for _, bad := range []string{"2020.01.01", "20", "202013", "2020030"} {
+		_, err = p.ShiftDate("12345", bad)
+		assert.Error(t, err, bad)
+	}
+
+	p.MaxDateShiftDays = 0
+	date, err = p.ShiftDate("12345", "20200301")
+	require.NoError(t, err)
+	assert.Equal(t, "20200301", date)
+}
+
+func TestPseudonymizerApply(t *testing.T) {
+	newDataSet := func() *dicom.DataSet {
+		return &dicom.DataSet{Elements: []*dicom.Element{
+			dicom.MustNewElement(dicomtag.StudyDate, "20200102"),
+			dicom.MustNewElement(dicomtag.PatientName, "Doe^John"),
+			dicom.MustNewElement(dicomtag.PatientID, "12345"),
+			dicom.MustNewElement(dicomtag.PatientBirthDate, "19800101"),
+			dicom.MustNewSequence(dicomtag.RequestAttributesSequence, []*dicom.Element{
+				dicom.MustNewElement(dicomtag.ScheduledProcedureStepStartDate, "20200101"),
+			}),
+		}}
+	}
+	p := anonymize.NewPseudonymizer([]byte("site key"))
+	ds := newDataSet()
+	require.NoError(t, p.Apply(ds))
+
+	days := p.DateShiftDays("12345")
+	shift := func(date string) string {
+		s, err := p.ShiftDate("12345", date)
+		require.NoError(t, err)
+		return s
+	}
+	assert.Equal(t, p.PatientID("12345"), ds.Elements[1].MustGetString())
+	assert.Equal(t, p.PatientID("12345"), ds.Elements[2].MustGetString())
+	assert.Equal(t, shift("20200102"), ds.Elements[0].MustGetString())
+	assert.Equal(t, shift("19800101"), ds.Elements[3].MustGetString())
+	assert.NotEqual(t, "20200102", ds.Elements[0].MustGetString(), days)
+	item := ds.Elements[4].Value[0].(*dicom.Element)
+	assert.Equal(t, shift("20200101"), item.Value[0].(*dicom.Element).MustGetString())
+
+	// A second run over the same input gives the same output.
+	ds2 := newDataSet()
+	require.NoError(t, p.Apply(ds2))
+	assert.Equal(t, ds, ds2)
+
+	assert.Error(t, p.Apply(&dicom.DataSet{}))
+
+	// Nothing is changed if a date can't be shifted.
+	ds = newDataSet()
+	ds.Elements = append(ds.Elements, dicom.MustNewElement(dicomtag.AcquisitionDateTime, "2020-01-02"))
+	assert.Error(t, p.Apply(ds))
+	assert.Equal(t, newDataSet().Elements, ds.Elements[:5])
+
+	// Pseudonyms must fit in a PatientID.
+	p.Prefix = strings.Repeat("P", 50)
+	ds = newDataSet()
+	assert.Error(t, p.Apply(ds))
+	assert.Equal(t, "12345", ds.Elements[2].MustGetString())
+	p.Length = 14
+	assert.NoError(t, p.Apply(ds))
+	assert.Len(t, ds.Elements[2].MustGetString(), 64)
+}
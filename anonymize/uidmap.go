@@ -0,0 +1,97 @@
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// UIDMapper maps original UIDs to new UIDs during de-identification. A
+// UIDMapper must always map the same UID to the same new UID, so that
+// references between the files of a study stay valid, and different UIDs
+// to different new UIDs.
+type UIDMapper interface {
+	MapUID(uid string) (string, error)
+}
+
+// uuidUID 把128 bits变成"2.25."开头的UID (P3.5 B.2)
+func uuidUID(b []byte) string {
+	return "2.25." + new(big.Int).SetBytes(b[:16]).String()
+}
+
+type hashUIDMapper struct {
+	key []byte
+}
+
+// NewHashUIDMapper returns a UIDMapper that maps each UID to "2.25."
+// followed by the first 128 bits of HMAC-SHA256(key, uid). Nothing needs to
+// be stored: de-identifying with the same key, e.g., another batch of the
+// same study, gives the same UIDs. The key must be kept secret.
+func NewHashUIDMapper(key []byte) UIDMapper {
+	return &hashUIDMapper{key: key}
+}
+
+func (m *hashUIDMapper) MapUID(uid string) (string, error) {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(uid))
+	return uuidUID(mac.Sum(nil)), nil
+}
+
+// UIDTable is a UIDMapper that maps each new original UID to a UID from
+// dicomuid.New, with the site's root if set, and remembers the mapping. The table can be saved and
+// loaded, so that it also works across runs, and it is the only record of
+// the mapping. It is safe for concurrent use.
+type UIDTable struct {
+	mu   sync.Mutex
+	uids map[string]string
+}
+
+// NewUIDTable creates an empty UIDTable.
+func NewUIDTable() *UIDTable {
+	return &UIDTable{uids: map[string]string{}}
+}
+
+// LoadUIDTable reads a UIDTable saved by UIDTable.Save.
+func LoadUIDTable(in io.Reader) (*UIDTable, error) {
+	t := NewUIDTable()
+	if err := json.NewDecoder(in).Decode(&t.uids); err != nil {
+		return nil, fmt.Errorf("anonymize.LoadUIDTable: %v", err)
+	}
+	if t.uids == nil {
+		t.uids = map[string]string{}
+	}
+	return t, nil
+}
+
+// Save writes the mapping as a JSON object from original to new UIDs.
+func (t *UIDTable) Save(out io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return json.NewEncoder(out).Encode(t.uids)
+}
+
+// Lookup returns the new UID for "uid", if it has been mapped.
+func (t *UIDTable) Lookup(uid string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	newUID, ok := t.uids[uid]
+	return newUID, ok
+}
+
+// MapUID returns the new UID for "uid", creating it if needed.
+func (t *UIDTable) MapUID(uid string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if newUID, ok := t.uids[uid]; ok {
+		return newUID, nil
+	}
+	newUID := dicomuid.New()
+	t.uids[uid] = newUID
+	return newUID, nil
+}
@@ -0,0 +1,72 @@
+package dicom_test
+
+// This file pins the public API. It only needs to compile: if a signature
+// below changes, downstream users that vendor this module break too, so the
+// change must wait for the next major version.
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomlog"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+var (
+	_ func(io.Reader, dicom.ReadOptions) (*dicom.DataSet, error)         = dicom.ReadDataSet
+	_ func([]byte, dicom.ReadOptions) (*dicom.DataSet, error)            = dicom.ReadDataSetInBytes
+	_ func(string, dicom.ReadOptions) (*dicom.DataSet, error)            = dicom.ReadDataSetFromFile
+	_ func(*dicomio.Decoder, dicom.ReadOptions) *dicom.Element           = dicom.ReadElement
+	_ func(*dicomio.Decoder) []*dicom.Element                            = dicom.ParseFileHeader
+	_ func(io.Writer, *dicom.DataSet) error                              = dicom.WriteDataSet
+	_ func(*dicomio.Encoder, *dicom.DataSet) error                       = dicom.WriteDataSetToBytes
+	_ func(string, *dicom.DataSet) error                                 = dicom.WriteDataSetToFile
+	_ func(*dicomio.Encoder, *dicom.Element)                             = dicom.WriteElement
+	_ func(*dicomio.Encoder, []*dicom.Element)                           = dicom.WriteFileHeader
+	_ func(io.Reader, ...dicom.ReadOption) (*dicom.DataSet, error)       = dicom.Read
+	_ func(string, ...dicom.ReadOption) (*dicom.DataSet, error)          = dicom.ReadFile
+	_ func(io.Writer, *dicom.DataSet, ...dicom.WriteOption) error        = dicom.Write
+	_ func(string, *dicom.DataSet, ...dicom.WriteOption) error           = dicom.WriteFile
+	_ func() dicom.ReadOption                                            = dicom.WithDropPixelData
+	_ func(dicomtag.Tag) dicom.ReadOption                                = dicom.WithStopAtTag
+	_ func(dicomtag.Tag, ...interface{}) (*dicom.Element, error)         = dicom.NewElement
+	_ func(dicomtag.Tag, ...interface{}) *dicom.Element                  = dicom.MustNewElement
+	_ func([]*dicom.Element, dicomtag.Tag) (*dicom.Element, error)       = dicom.FindElementByTag
+	_ func([]*dicom.Element, string) (*dicom.Element, error)             = dicom.FindElementByName
+	_ func(*dicom.DataSet, *dicom.Element) (bool, *dicom.Element, error) = dicom.Query
+
+	_ func(*dicom.DataSet, dicomtag.Tag) (*dicom.Element, error) = (*dicom.DataSet).FindElementByTag
+	_ func(*dicom.DataSet, string) (*dicom.Element, error)       = (*dicom.DataSet).FindElementByName
+	_ func(*dicom.Element) (string, error)                       = (*dicom.Element).GetString
+	_ func(*dicom.Element) ([]string, error)                     = (*dicom.Element).GetStrings
+	_ func(*dicom.Element) (uint16, error)                       = (*dicom.Element).GetUInt16
+	_ func(*dicom.Element) (uint32, error)                       = (*dicom.Element).GetUInt32
+	_ func(*dicom.Element) ([]uint16, error)                     = (*dicom.Element).GetUint16s
+	_ func(*dicom.Element) ([]uint32, error)                     = (*dicom.Element).GetUint32s
+
+	_ = dicom.Element{Tag: dicomtag.Tag{}, VR: "", Value: nil, UndefinedLength: false}
+	_ = dicom.DataSet{Elements: nil}
+	_ = dicom.ReadOptions{DropPixelData: false, ReturnTags: nil, StopAtTag: nil}
+	_ = dicom.PixelDataInfo{Offsets: nil, Frames: nil}
+
+	_ func(io.Reader, binary.ByteOrder, dicomio.IsImplicitVR) *dicomio.Decoder = dicomio.NewDecoder
+	_ func([]byte, binary.ByteOrder, dicomio.IsImplicitVR) *dicomio.Decoder    = dicomio.NewBytesDecoder
+	_ func(io.Writer, binary.ByteOrder, dicomio.IsImplicitVR) *dicomio.Encoder = dicomio.NewEncoder
+	_ func(binary.ByteOrder, dicomio.IsImplicitVR) *dicomio.Encoder            = dicomio.NewBytesEncoder
+	_ func(string) (binary.ByteOrder, dicomio.IsImplicitVR, error)             = dicomio.ParseTransferSyntaxUID
+	_ func([]string) (dicomio.CodingSystem, error)                             = dicomio.ParseSpecificCharacterSet
+
+	_ func(dicomtag.Tag) (dicomtag.TagInfo, error) = dicomtag.Find
+	_ func(string) (dicomtag.TagInfo, error)       = dicomtag.FindByName
+	_ func(dicomtag.Tag, string) dicomtag.VRKind   = dicomtag.GetVRKind
+	_ func(dicomtag.Tag) string                    = dicomtag.DebugString
+
+	_ func(string) (dicomuid.UIDInfo, error) = dicomuid.Lookup
+	_ func(string) string                    = dicomuid.UIDString
+
+	_ func(int)  = dicomlog.SetLevel
+	_ func() int = dicomlog.Level
+)
@@ -0,0 +1,145 @@
+package dicom
+
+import (
+	"sort"
+	"time"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Change is one modification of a DataSet recorded in a ChangeLog.
+type Change struct {
+	Tag dicomtag.Tag
+
+	// Old is the element before the change, or nil if the element was added.
+	Old *Element
+
+	// New is the element after the change, or nil if the element was deleted.
+	New *Element
+
+	Time time.Time
+
+	// Reason 是修改的原因. 写进OriginalAttributesSequence时它是
+	// ReasonForTheAttributeModification (0400,0565), 定义的值有"COERCE"和"CORRECT".
+	// 空字符串表示"COERCE"
+	Reason string
+}
+
+// ChangeLog records the modifications made to a DataSet through its
+// methods, e.g., DataSet.Replace. Set DataSet.ChangeLog to start recording.
+// Modifying DataSet.Elements directly is not recorded.
+type ChangeLog struct {
+	// ModifyingSystem (0400,0563) 和 SourceOfPreviousValues (0400,0564)
+	// 会被写进OriginalAttributesSequence的每一个item. 可以为空
+	ModifyingSystem        string
+	SourceOfPreviousValues string
+
+	Changes []Change
+}
+
+// Replace replaces the element with the same tag as "elem", or inserts elem
+// in tag order if there is none. If f.ChangeLog is set, the change is
+// recorded with "reason".
+func (f *DataSet) Replace(elem *Element, reason string) {
+	old := f.setElement(elem)
+	f.recordChange(elem.Tag, old, elem, reason)
+}
+
+// recordChange 在f.ChangeLog不为nil时记录一次修改
+func (f *DataSet) recordChange(tag dicomtag.Tag, oldElem, newElem *Element, reason string) {
+	if f.ChangeLog == nil {
+		return
+	}
+	f.ChangeLog.Changes = append(f.ChangeLog.Changes, Change{
+		Tag:    tag,
+		Old:    oldElem,
+		New:    newElem,
+		Time:   time.Now(),
+		Reason: reason,
+	})
+}
+
+// AddOriginalAttributes serializes f.ChangeLog into OriginalAttributesSequence
+// (0400,0561), as described in P3.3 C.12.1.1.9, and clears the log. Changes
+// with the same reason go in one item, whose ModifiedAttributesSequence holds
+// the old value of each modified attribute; attributes that were added are
+// included with an empty value. Items are appended to the existing
+// OriginalAttributesSequence, if any. Adding the sequence is not recorded.
+func (f *DataSet) AddOriginalAttributes() error {
+	if f.ChangeLog == nil || len(f.ChangeLog.Changes) == 0 {
+		return nil
+	}
+	var reasons []string
+	byReason := map[string][]Change{}
+	for _, change := range f.ChangeLog.Changes {
+		reason := change.Reason
+		if reason == "" {
+			reason = "COERCE"
+		}
+		if _, ok := byReason[reason]; !ok {
+			reasons = append(reasons, reason)
+		}
+		byReason[reason] = append(byReason[reason], change)
+	}
+
+	seq, err := f.FindElementByTag(dicomtag.OriginalAttributesSequence)
+	if err != nil {
+		if seq, err = NewSequence(dicomtag.OriginalAttributesSequence); err != nil {
+			return err
+		}
+	} else {
+		// 不修改原来的element, 它可能在ChangeLog里
+		seq = &Element{Tag: seq.Tag, VR: seq.VR, Value: append([]interface{}(nil), seq.Value...)}
+	}
+	for _, reason := range reasons {
+		item, err := f.ChangeLog.originalAttributesItem(reason, byReason[reason])
+		if err != nil {
+			return err
+		}
+		seq.Value = append(seq.Value, item)
+	}
+	f.setElement(seq)
+	f.ChangeLog.Changes = nil
+	return nil
+}
+
+// originalAttributesItem 返回OriginalAttributesSequence的一个item
+func (l *ChangeLog) originalAttributesItem(reason string, changes []Change) (*Element, error) {
+	// 同一个tag只保留最早的值
+	var modified []*Element
+	seen := map[dicomtag.Tag]bool{}
+	var last time.Time
+	for _, change := range changes {
+		if change.Time.After(last) {
+			last = change.Time
+		}
+		if seen[change.Tag] {
+			continue
+		}
+		seen[change.Tag] = true
+		old := change.Old
+		if old == nil {
+			old = &Element{Tag: change.Tag, VR: change.New.VR}
+		}
+		modified = append(modified, old)
+	}
+	sort.Slice(modified, func(i, j int) bool { return modified[i].Tag.Compare(modified[j].Tag) < 0 })
+
+	modifiedSeq, err := NewSequence(dicomtag.ModifiedAttributesSequence, modified)
+	if err != nil {
+		return nil, err
+	}
+	// 按tag顺序: (0400,0550), (0400,0562..0565)
+	elems := []*Element{
+		modifiedSeq,
+		MustNewElement(dicomtag.AttributeModificationDateTime, last.Format("20060102150405.000000-0700")),
+	}
+	if l.ModifyingSystem != "" {
+		elems = append(elems, MustNewElement(dicomtag.ModifyingSystem, l.ModifyingSystem))
+	}
+	if l.SourceOfPreviousValues != "" {
+		elems = append(elems, MustNewElement(dicomtag.SourceOfPreviousValues, l.SourceOfPreviousValues))
+	}
+	elems = append(elems, MustNewElement(dicomtag.ReasonForTheAttributeModification, reason))
+	return NewItem(elems...), nil
+}
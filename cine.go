@@ -0,0 +1,239 @@
+package dicom
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// 多帧图像(cine)播放用的helpers. DS/IS/DT的字符串在这里统一解析,
+// 调用者拿到的是time.Duration和time.Time
+
+// FrameTime returns FrameTime (0018,1063), the nominal time between frames.
+func (f *DataSet) FrameTime() (time.Duration, error) {
+	elem, err := f.FindElementByTag(dicomtag.FrameTime)
+	if err != nil {
+		return 0, err
+	}
+	ms, err := parseDecimalString(elem)
+	if err != nil {
+		return 0, err
+	}
+	return milliseconds(ms), nil
+}
+
+// FrameTimeVector returns FrameTimeVector (0018,1065), the time increment
+// from the previous frame for each frame. The first value is normally 0.
+func (f *DataSet) FrameTimeVector() ([]time.Duration, error) {
+	elem, err := f.FindElementByTag(dicomtag.FrameTimeVector)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseDecimalStrings(elem)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]time.Duration, len(values))
+	for i, ms := range values {
+		result[i] = milliseconds(ms)
+	}
+	return result, nil
+}
+
+// RecommendedDisplayFrameRate returns the recommended playback rate in
+// frames per second. It uses RecommendedDisplayFrameRate (0008,2144), or
+// CineRate (0018,0040) if the former is missing.
+func (f *DataSet) RecommendedDisplayFrameRate() (float64, error) {
+	elem, err := f.FindElementByTag(dicomtag.RecommendedDisplayFrameRate)
+	if err != nil {
+		if elem, err = f.FindElementByTag(dicomtag.CineRate); err != nil {
+			return 0, err
+		}
+	}
+	return parseDecimalString(elem)
+}
+
+// FrameAcquisitionTimes returns FrameAcquisitionDateTime (0018,9074) of each
+// frame of an enhanced multi-frame image, taken from the FrameContentSequence
+// in PerFrameFunctionalGroupsSequence (5200,9230). Times without a UTC
+// offset are returned in UTC.
+func (f *DataSet) FrameAcquisitionTimes() ([]time.Time, error) {
+	seq, err := f.FindElementByTag(dicomtag.PerFrameFunctionalGroupsSequence)
+	if err != nil {
+		return nil, err
+	}
+	var result []time.Time
+	for i, value := range seq.Value {
+		item, ok := value.(*Element)
+		if !ok {
+			return nil, fmt.Errorf("dicom.FrameAcquisitionTimes: frame %d: not an item", i)
+		}
+		content, err := FindElementByTag(itemElements(item), dicomtag.FrameContentSequence)
+		if err != nil || len(content.Value) == 0 {
+			return nil, fmt.Errorf("dicom.FrameAcquisitionTimes: frame %d: no FrameContentSequence", i)
+		}
+		contentItem, ok := content.Value[0].(*Element)
+		if !ok {
+			return nil, fmt.Errorf("dicom.FrameAcquisitionTimes: frame %d: FrameContentSequence: not an item", i)
+		}
+		elem, err := FindElementByTag(itemElements(contentItem), dicomtag.FrameAcquisitionDateTime)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.FrameAcquisitionTimes: frame %d: %v", i, err)
+		}
+		s, err := elem.GetString()
+		if err != nil {
+			return nil, err
+		}
+		t, err := ParseDateTime(s)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.FrameAcquisitionTimes: frame %d: %v", i, err)
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// FrameOffsets returns the display time of each frame relative to the first
+// one, so that result[0] is always 0. The first of these that is present is
+// used:
+//
+//	FrameTimeVector
+//	FrameTime, for NumberOfFrames (0028,0008) frames
+//	per-frame acquisition times, see FrameAcquisitionTimes
+func (f *DataSet) FrameOffsets() ([]time.Duration, error) {
+	if vector, err := f.FrameTimeVector(); err == nil {
+		result := make([]time.Duration, len(vector))
+		for i := 1; i < len(vector); i++ {
+			result[i] = result[i-1] + vector[i]
+		}
+		return result, nil
+	}
+	if frameTime, err := f.FrameTime(); err == nil {
+		n := 1
+		if elem, err := f.FindElementByTag(dicomtag.NumberOfFrames); err == nil {
+			v, err := parseDecimalString(elem)
+			if err != nil {
+				return nil, err
+			}
+			// NumberOfFrames是IS, 至少是1
+			if v < 1 || v > math.MaxInt32 || v != math.Trunc(v) {
+				return nil, fmt.Errorf("dicom.FrameOffsets: invalid NumberOfFrames %v", v)
+			}
+			n = int(v)
+		}
+		result := make([]time.Duration, n)
+		for i := range result {
+			result[i] = time.Duration(i) * frameTime
+		}
+		return result, nil
+	}
+	times, err := f.FrameAcquisitionTimes()
+	if err != nil {
+		return nil, fmt.Errorf("dicom.FrameOffsets: no FrameTimeVector, FrameTime or per-frame acquisition times")
+	}
+	result := make([]time.Duration, len(times))
+	for i, t := range times {
+		result[i] = t.Sub(times[0])
+	}
+	return result, nil
+}
+
+// ParseDateTime parses a DT value, "YYYYMMDDHHMMSS.FFFFFF&ZZXX". Every
+// component after the year is optional. Without the "&ZZXX" UTC offset, the
+// time is returned in UTC. P3.5 6.2.
+func ParseDateTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	loc := time.UTC
+	if i := strings.IndexAny(s, "+-"); i >= 0 {
+		offset := s[i:]
+		s = s[:i]
+		if len(offset) != 5 {
+			return time.Time{}, fmt.Errorf("dicom.ParseDateTime: invalid UTC offset '%s'", offset)
+		}
+		hours, err0 := strconv.Atoi(offset[1:3])
+		minutes, err1 := strconv.Atoi(offset[3:5])
+		if err0 != nil || err1 != nil {
+			return time.Time{}, fmt.Errorf("dicom.ParseDateTime: invalid UTC offset '%s'", offset)
+		}
+		seconds := hours*3600 + minutes*60
+		if offset[0] == '-' {
+			seconds = -seconds
+		}
+		loc = time.FixedZone(offset, seconds)
+	}
+	fraction := ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s, fraction = s[:i], s[i+1:]
+	}
+	// YYYY, MM, DD, HH, MM, SS
+	sizes := []int{4, 2, 2, 2, 2, 2}
+	fields := []int{0, 1, 1, 0, 0, 0}
+	if len(s) < 4 || len(s)%2 != 0 || len(s) > 14 {
+		return time.Time{}, fmt.Errorf("dicom.ParseDateTime: invalid DT '%s'", s)
+	}
+	pos := 0
+	for i, size := range sizes {
+		if pos >= len(s) {
+			break
+		}
+		v, err := strconv.Atoi(s[pos : pos+size])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("dicom.ParseDateTime: invalid DT '%s'", s)
+		}
+		fields[i] = v
+		pos += size
+	}
+	nsec := 0
+	if fraction != "" {
+		if len(fraction) > 6 {
+			return time.Time{}, fmt.Errorf("dicom.ParseDateTime: invalid fraction '%s'", fraction)
+		}
+		v, err := strconv.Atoi(fraction)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("dicom.ParseDateTime: invalid fraction '%s'", fraction)
+		}
+		nsec = v
+		for i := len(fraction); i < 9; i++ {
+			nsec *= 10
+		}
+	}
+	return time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], nsec, loc), nil
+}
+
+// parseDecimalString parses a single-valued DS or IS element.
+func parseDecimalString(elem *Element) (float64, error) {
+	values, err := parseDecimalStrings(elem)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) != 1 {
+		return 0, fmt.Errorf("%v: found %d values, expect 1", dicomtag.DebugString(elem.Tag), len(values))
+	}
+	return values[0], nil
+}
+
+// parseDecimalStrings parses a DS or IS element.
+func parseDecimalStrings(elem *Element) ([]float64, error) {
+	var result []float64
+	for _, value := range elem.Value {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v: found non-string value %v", dicomtag.DebugString(elem.Tag), value)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", dicomtag.DebugString(elem.Tag), err)
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// milliseconds 把DS的毫秒值转换成time.Duration, 四舍五入到纳秒
+func milliseconds(ms float64) time.Duration {
+	return time.Duration(math.Round(ms * float64(time.Millisecond)))
+}
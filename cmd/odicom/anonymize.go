@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/anonymize"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+func anonymizeCommand(args []string) int {
+	flags := flag.NewFlagSet("anonymize", flag.ContinueOnError)
+	profilePath := flags.String("profile", "", "YAML profile with the de-identification rules (required)")
+	workers := flags.Int("j", runtime.NumCPU(), "number of files processed in parallel")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *profilePath == "" || flags.NArg() != 2 || *workers < 1 {
+		usage()
+		return 2
+	}
+	config, err := loadProfile(*profilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "odicom anonymize: %v\n", err)
+		return 1
+	}
+	stats, err := anonymizeTree(config, flags.Arg(0), flags.Arg(1), *workers, func(path string, err error) {
+		fmt.Fprintf(os.Stderr, "odicom anonymize: %s: %v\n", path, err)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "odicom anonymize: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%d files anonymized, %d skipped, %d failed\n", stats.done, stats.skipped, stats.failed)
+	if stats.failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// anonymizeStats 是anonymizeTree处理的文件数
+type anonymizeStats struct {
+	done, skipped, failed int
+}
+
+// anonymizeTree 用"workers"个goroutines把inDir下的每个文件去标识化, 写到outDir下同样的相对路径.
+// 每个失败或跳过的文件调用report
+func anonymizeTree(config *anonymizeConfig, inDir, outDir string, workers int, report func(path string, err error)) (anonymizeStats, error) {
+	var stats anonymizeStats
+	inAbs, err := filepath.Abs(inDir)
+	if err != nil {
+		return stats, err
+	}
+	outAbs, err := filepath.Abs(outDir)
+	if err != nil {
+		return stats, err
+	}
+	if outAbs == inAbs || strings.HasPrefix(outAbs, inAbs+string(filepath.Separator)) {
+		return stats, fmt.Errorf("output directory %s must not be inside %s", outDir, inDir)
+	}
+
+	var mu sync.Mutex
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				rel, _ := filepath.Rel(inDir, path)
+				skipped, err := anonymizeFile(config, path, filepath.Join(outDir, rel))
+				mu.Lock()
+				switch {
+				case err != nil:
+					stats.failed++
+					report(path, err)
+				case skipped:
+					stats.skipped++
+					report(path, fmt.Errorf("skipped, DICOMDIR files are not anonymized"))
+				default:
+					stats.done++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	walkErr := filepath.Walk(inDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths <- path
+		}
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+	return stats, walkErr
+}
+
+// anonymizeFile 去标识化一个文件. DICOMDIR引用的是原来的文件和UIDs, 所以不复制, 返回skipped
+func anonymizeFile(config *anonymizeConfig, inPath, outPath string) (skipped bool, err error) {
+	ds, err := dicom.ReadDataSetFromFile(inPath, dicom.ReadOptions{})
+	if err != nil {
+		return false, err
+	}
+	if elem, err := ds.FindElementByTag(dicomtag.MediaStorageSOPClassUID); err == nil {
+		if uid, _ := elem.GetString(); uid == dicomuid.MediaStorageDirectoryStorage {
+			return true, nil
+		}
+	}
+	options := config.options
+	if config.pseudonymizer != nil {
+		patientID := ""
+		if elem, err := ds.FindElementByTag(dicomtag.PatientID); err == nil {
+			patientID, _ = elem.GetString()
+		}
+		options.DateShiftDays = config.pseudonymizer.DateShiftDays(strings.TrimSpace(patientID))
+	}
+	if err := anonymize.Anonymize(ds, options); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return false, err
+	}
+	return false, dicom.WriteDataSetToFile(outPath, ds)
+}
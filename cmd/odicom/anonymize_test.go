@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/anonymize"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProfile = `
+key: site secret
+rules:
+  - tag: StudyDescription
+    action: keep
+  - tag: (0008,0080)
+    action: replace
+    value: Research Office
+  - tag: "00100020"
+    action: hash
+  - tag: "(0029,xx10)"
+    privateCreator: ACME
+    action: keep
+dateShift:
+  maxDays: 30
+`
+
+func TestLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "odicom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "profile.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testProfile), 0644))
+
+	config, err := loadProfile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("site secret"), config.options.UIDKey)
+	assert.Equal(t, map[dicomtag.Tag]anonymize.Action{
+		dicomtag.StudyDescription: anonymize.Keep,
+		dicomtag.InstitutionName:  anonymize.Replace,
+		dicomtag.PatientID:        anonymize.Hash,
+	}, config.options.Actions)
+	assert.Equal(t, map[dicomtag.Tag]string{dicomtag.InstitutionName: "Research Office"}, config.options.Replacements)
+	assert.Equal(t, []anonymize.PrivateAction{{Creator: "ACME", Group: 0x0029, Element: 0x10, Action: anonymize.Keep}},
+		config.options.PrivateActions)
+	assert.Equal(t, 30, config.pseudonymizer.MaxDateShiftDays)
+
+	for _, bad := range []string{
+		"rules: [{tag: PatientName, action: scramble}]",
+		"rules: [{tag: NoSuchKeyword, action: keep}]",
+		"rules: [{tag: (0029,1010), action: keep}]",
+		"rules: [{tag: (0028,xx10), privateCreator: ACME, action: keep}]",
+		"rules: [{tag: PatientName, action: keep, value: x}]",
+		"typo: true",
+	} {
+		require.NoError(t, ioutil.WriteFile(path, []byte(bad), 0644))
+		_, err := loadProfile(path)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestAnonymizeTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "odicom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	for _, name := range []string{"a/1.dcm", "a/2.dcm", "b/3.dcm"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(in, name)), 0755))
+		require.NoError(t, dicomtest.WriteFile(filepath.Join(in, name), dicomtest.Spec{PatientName: "Zhang^San"}))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(in, "notes.txt"), []byte("not dicom"), 0644))
+
+	config := &anonymizeConfig{options: anonymize.Options{UIDKey: []byte("k"), HashKey: []byte("k")}}
+	var reported []string
+	stats, err := anonymizeTree(config, in, out, 2, func(path string, err error) {
+		reported = append(reported, filepath.Base(path))
+	})
+	require.NoError(t, err)
+	assert.Equal(t, anonymizeStats{done: 3, failed: 1}, stats)
+	assert.Equal(t, []string{"notes.txt"}, reported)
+
+	var uids []string
+	for _, name := range []string{"a/1.dcm", "a/2.dcm", "b/3.dcm"} {
+		ds, err := dicom.ReadDataSetFromFile(filepath.Join(out, name), dicom.ReadOptions{})
+		require.NoError(t, err)
+		elem, err := ds.FindElementByTag(dicomtag.PatientName)
+		require.NoError(t, err)
+		assert.Empty(t, elem.Value)
+		elem, err = ds.FindElementByTag(dicomtag.StudyInstanceUID)
+		require.NoError(t, err)
+		uids = append(uids, elem.MustGetString())
+	}
+	// 同一个key, 同一个study的文件仍然在同一个study里
+	assert.Equal(t, uids[0], uids[1])
+	assert.Equal(t, uids[0], uids[2])
+
+	_, err = anonymizeTree(config, in, filepath.Join(in, "out"), 1, nil)
+	assert.Error(t, err)
+}
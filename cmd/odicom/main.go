@@ -0,0 +1,38 @@
+// Command odicom is a command-line tool for DICOM files.
+//
+// Usage:
+//
+//	odicom anonymize --profile profile.yaml [-j N] in/ out/
+//
+// anonymize de-identifies every DICOM file under in/ into the same relative
+// path under out/, following the P3.15 Basic Profile as adjusted by the
+// profile, see profile.go for its format.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commands 是odicom的子命令. 每个子命令解析自己的flags, 返回exit status
+var commands = map[string]func(args []string) int{
+	"anonymize": anonymizeCommand,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: odicom anonymize --profile profile.yaml [-j N] in/ out/\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "odicom: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(command(os.Args[2:]))
+}
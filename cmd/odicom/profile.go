@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom/anonymize"
+	"github.com/odincare/odicom/dicomtag"
+	"gopkg.in/yaml.v2"
+)
+
+// profile is the YAML configuration of "odicom anonymize". Attributes
+// without a rule are handled as in the P3.15 Basic Profile. For example:
+//
+//	# HMAC key of new UIDs, hashes and date shifts. Files anonymized with
+//	# the same key keep their study/series relations and pseudonyms across
+//	# runs. Without key or keyFile a random key is used for the run.
+//	keyFile: /secure/site.key
+//	keepPrivateTags: false
+//	rules:
+//	  - tag: StudyDescription          # keyword, "(0008,1030)" or "00081030"
+//	    action: keep                   # keep, remove, zero, dummy, uid, replace, hash
+//	  - tag: InstitutionName
+//	    action: replace
+//	    value: Research Office
+//	  - tag: PatientID
+//	    action: hash
+//	  - tag: "(0029,xx10)"             # private attribute of a creator's block
+//	    privateCreator: SIEMENS CSA HEADER
+//	    action: keep
+//	dateShift:
+//	  maxDays: 365                     # per patient, in [1, maxDays] days back
+type profile struct {
+	Key             string        `yaml:"key"`
+	KeyFile         string        `yaml:"keyFile"`
+	KeepPrivateTags bool          `yaml:"keepPrivateTags"`
+	Rules           []profileRule `yaml:"rules"`
+	DateShift       struct {
+		MaxDays int `yaml:"maxDays"`
+	} `yaml:"dateShift"`
+}
+
+type profileRule struct {
+	Tag            string `yaml:"tag"`
+	PrivateCreator string `yaml:"privateCreator"`
+	Action         string `yaml:"action"`
+	Value          string `yaml:"value"`
+}
+
+var profileActions = map[string]anonymize.Action{
+	"keep":    anonymize.Keep,
+	"remove":  anonymize.Remove,
+	"zero":    anonymize.Zero,
+	"dummy":   anonymize.Dummy,
+	"uid":     anonymize.ReplaceUID,
+	"replace": anonymize.Replace,
+	"hash":    anonymize.Hash,
+}
+
+// anonymizeConfig 是从profile得到的设置. options.DateShiftDays由每个文件的PatientID决定
+type anonymizeConfig struct {
+	options anonymize.Options
+	// pseudonymizer 不为nil时按PatientID移动日期
+	pseudonymizer *anonymize.Pseudonymizer
+}
+
+// loadProfile 读取并检查YAML profile
+func loadProfile(path string) (*anonymizeConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p profile
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	config, err := p.config()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+func (p *profile) config() (*anonymizeConfig, error) {
+	key := []byte(p.Key)
+	switch {
+	case p.Key != "" && p.KeyFile != "":
+		return nil, fmt.Errorf("both key and keyFile are set")
+	case p.KeyFile != "":
+		data, err := ioutil.ReadFile(p.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = []byte(strings.TrimSpace(string(data)))
+	case p.Key == "":
+		// 整个run用同一个key, 这样同一个study的文件仍然一致
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	config := &anonymizeConfig{options: anonymize.Options{
+		KeepPrivateTags: p.KeepPrivateTags,
+		UIDKey:          key,
+		HashKey:         key,
+		Actions:         map[dicomtag.Tag]anonymize.Action{},
+		Replacements:    map[dicomtag.Tag]string{},
+	}}
+	for i, rule := range p.Rules {
+		if err := config.addRule(rule); err != nil {
+			return nil, fmt.Errorf("rules[%d]: %v", i, err)
+		}
+	}
+	if p.DateShift.MaxDays < 0 {
+		return nil, fmt.Errorf("dateShift.maxDays must not be negative")
+	}
+	if p.DateShift.MaxDays > 0 {
+		config.pseudonymizer = &anonymize.Pseudonymizer{Key: key, MaxDateShiftDays: p.DateShift.MaxDays}
+	}
+	return config, nil
+}
+
+func (c *anonymizeConfig) addRule(rule profileRule) error {
+	action, ok := profileActions[rule.Action]
+	if !ok {
+		return fmt.Errorf("unknown action %q", rule.Action)
+	}
+	if rule.Value != "" && action != anonymize.Replace {
+		return fmt.Errorf("value is only used by replace")
+	}
+	if rule.PrivateCreator != "" {
+		group, element, err := parsePrivateTag(rule.Tag)
+		if err != nil {
+			return err
+		}
+		c.options.PrivateActions = append(c.options.PrivateActions, anonymize.PrivateAction{
+			Creator: rule.PrivateCreator, Group: group, Element: element, Action: action, Value: rule.Value})
+		return nil
+	}
+	tag, err := parseTag(rule.Tag)
+	if err != nil {
+		return err
+	}
+	if tag.Group%2 == 1 {
+		return fmt.Errorf("%s: private attributes need a privateCreator", rule.Tag)
+	}
+	c.options.Actions[tag] = action
+	if action == anonymize.Replace {
+		c.options.Replacements[tag] = rule.Value
+	}
+	return nil
+}
+
+// parseTag 解析keyword, "(gggg,eeee)"或"ggggeeee"
+func parseTag(s string) (dicomtag.Tag, error) {
+	if info, err := dicomtag.FindByName(s); err == nil {
+		return info.Tag, nil
+	}
+	hex := strings.NewReplacer("(", "", ")", "", ",", "").Replace(s)
+	if len(hex) != 8 {
+		return dicomtag.Tag{}, fmt.Errorf("invalid tag %q", s)
+	}
+	group, err1 := strconv.ParseUint(hex[:4], 16, 16)
+	element, err2 := strconv.ParseUint(hex[4:], 16, 16)
+	if err1 != nil || err2 != nil {
+		return dicomtag.Tag{}, fmt.Errorf("invalid tag %q", s)
+	}
+	return dicomtag.Tag{Group: uint16(group), Element: uint16(element)}, nil
+}
+
+// parsePrivateTag 解析"(gggg,xxee)", 返回group和ee
+func parsePrivateTag(s string) (uint16, uint8, error) {
+	hex := strings.NewReplacer("(", "", ")", "", ",", "").Replace(strings.ToLower(s))
+	if len(hex) != 8 || hex[4:6] != "xx" {
+		return 0, 0, fmt.Errorf("invalid private tag %q, expect (gggg,xxee)", s)
+	}
+	group, err1 := strconv.ParseUint(hex[:4], 16, 16)
+	element, err2 := strconv.ParseUint(hex[6:], 16, 8)
+	if err1 != nil || err2 != nil || group%2 == 0 {
+		return 0, 0, fmt.Errorf("invalid private tag %q, expect (gggg,xxee)", s)
+	}
+	return uint16(group), uint8(element), nil
+}
@@ -0,0 +1,216 @@
+package dicom
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Code is a coded concept, the content of an item of a code sequence such as
+// ConceptNameCodeSequence (the Code Sequence Macro, P3.3 8.8). Codes are
+// written as in PS3.16, e.g., (CT, DCM, "Computed Tomography").
+type Code struct {
+	// Value 是CodeValue, 长于16个字符时是LongCodeValue, 或者是URNCodeValue
+	Value string
+
+	// Designator 是CodingSchemeDesignator, 例如"DCM", "SCT", "LN".
+	// URNCodeValue没有designator
+	Designator string
+
+	// Meaning 是CodeMeaning, 给人看的, 比较codes时不用
+	Meaning string
+
+	// Version 是CodingSchemeVersion, 通常为空
+	Version string
+}
+
+// String returns the code as (value, designator, "meaning").
+func (c Code) String() string {
+	return fmt.Sprintf("(%s, %s, %q)", c.Value, c.Designator, c.Meaning)
+}
+
+// Equal reports whether "c" and "other" are the same concept: the same value
+// and coding scheme. Meanings may differ, e.g., between languages.
+func (c Code) Equal(other Code) bool {
+	return c.Value == other.Value && c.Designator == other.Designator
+}
+
+// isURN 检查code value是不是URN或URL (P3.3 8.8, URNCodeValue)
+func (c Code) isURN() bool {
+	return c.Designator == "" && (strings.HasPrefix(c.Value, "urn:") || strings.Contains(c.Value, "://"))
+}
+
+// ToItem returns the elements of a code sequence item for "c". Pass them to
+// NewSequence, e.g.,
+//
+//	dicom.MustNewSequence(dicomtag.ConceptNameCodeSequence,
+//	    dicom.Code{Value: "121071", Designator: "DCM", Meaning: "Finding"}.ToItem())
+//
+// Values longer than 16 characters are written as LongCodeValue, URNs and
+// URLs without a designator as URNCodeValue.
+func (c Code) ToItem() []*Element {
+	var elems []*Element
+	switch {
+	case c.isURN():
+		elems = append(elems, MustNewElement(dicomtag.URNCodeValue, c.Value))
+	case len(c.Value) > dicomtag.MaxValueLength("SH"):
+		elems = append(elems, MustNewElement(dicomtag.LongCodeValue, c.Value))
+	default:
+		elems = append(elems, MustNewElement(dicomtag.CodeValue, c.Value))
+	}
+	if c.Designator != "" {
+		elems = append(elems, MustNewElement(dicomtag.CodingSchemeDesignator, c.Designator))
+	}
+	if c.Version != "" {
+		elems = append(elems, MustNewElement(dicomtag.CodingSchemeVersion, c.Version))
+	}
+	elems = append(elems, MustNewElement(dicomtag.CodeMeaning, c.Meaning))
+	// LongCodeValue和URNCodeValue的tag在CodeMeaning之后
+	sort.Slice(elems, func(i, j int) bool { return elems[i].Tag.Compare(elems[j].Tag) < 0 })
+	return elems
+}
+
+// CodeFromItem returns the code of an item of a code sequence. It returns an
+// error if the item has no CodeValue, LongCodeValue or URNCodeValue, or a
+// CodeValue without a CodingSchemeDesignator.
+func CodeFromItem(item *Element) (Code, error) {
+	if item.Tag != dicomtag.Item {
+		return Code{}, fmt.Errorf("dicom.CodeFromItem: %v is not an item", dicomtag.DebugString(item.Tag))
+	}
+	elems := itemElements(item)
+	str := func(tag dicomtag.Tag) string {
+		elem, err := FindElementByTag(elems, tag)
+		if err != nil {
+			return ""
+		}
+		s, _ := elem.GetString()
+		return strings.TrimSpace(s)
+	}
+	c := Code{
+		Designator: str(dicomtag.CodingSchemeDesignator),
+		Meaning:    str(dicomtag.CodeMeaning),
+		Version:    str(dicomtag.CodingSchemeVersion),
+	}
+	for _, tag := range []dicomtag.Tag{dicomtag.CodeValue, dicomtag.LongCodeValue, dicomtag.URNCodeValue} {
+		if c.Value = str(tag); c.Value != "" {
+			if c.Designator == "" && tag != dicomtag.URNCodeValue {
+				return Code{}, fmt.Errorf("dicom.CodeFromItem: code %q has no CodingSchemeDesignator", c.Value)
+			}
+			return c, nil
+		}
+	}
+	return Code{}, fmt.Errorf("dicom.CodeFromItem: item has no code value")
+}
+
+// NewCodeSequence returns a code sequence "tag" with one item per code.
+func NewCodeSequence(tag dicomtag.Tag, codes ...Code) (*Element, error) {
+	items := make([][]*Element, len(codes))
+	for i, c := range codes {
+		items[i] = c.ToItem()
+	}
+	return NewSequence(tag, items...)
+}
+
+// Codes returns the codes of the items of the code sequence "tag" of the
+// data set, or nil if there is no such sequence.
+func (f *DataSet) Codes(tag dicomtag.Tag) ([]Code, error) {
+	elem, err := f.FindElementByTag(tag)
+	if err != nil {
+		return nil, nil
+	}
+	if elem.VR != "SQ" {
+		return nil, fmt.Errorf("dicom.Codes: %v is not a sequence", dicomtag.DebugString(tag))
+	}
+	var codes []Code
+	for _, value := range elem.Value {
+		item, ok := value.(*Element)
+		if !ok {
+			return nil, fmt.Errorf("dicom.Codes: %v: found non-item value %v", dicomtag.DebugString(tag), value)
+		}
+		c, err := CodeFromItem(item)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.Codes: %v: %v", dicomtag.DebugString(tag), err)
+		}
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
+
+// ContextGroup is a context group of PS3.16, the codes allowed for an
+// attribute or a content item, e.g., CID 244 Laterality.
+type ContextGroup struct {
+	CID   int
+	Name  string
+	Codes []Code
+}
+
+// Find returns the code of the group with the given value and designator.
+func (g ContextGroup) Find(value, designator string) (Code, bool) {
+	for _, c := range g.Codes {
+		if c.Equal(Code{Value: value, Designator: designator}) {
+			return c, true
+		}
+	}
+	return Code{}, false
+}
+
+// FindByMeaning returns the code of the group with the given meaning,
+// ignoring case.
+func (g ContextGroup) FindByMeaning(meaning string) (Code, bool) {
+	for _, c := range g.Codes {
+		if strings.EqualFold(c.Meaning, meaning) {
+			return c, true
+		}
+	}
+	return Code{}, false
+}
+
+// Contains reports whether "c" is a code of the group.
+func (g ContextGroup) Contains(c Code) bool {
+	_, ok := g.Find(c.Value, c.Designator)
+	return ok
+}
+
+// contextGroups 是常用的context groups, 不是PS3.16的全部
+var contextGroups = map[int]ContextGroup{
+	29: {CID: 29, Name: "Acquisition Modality", Codes: []Code{
+		{Value: "CR", Designator: "DCM", Meaning: "Computed Radiography"},
+		{Value: "CT", Designator: "DCM", Meaning: "Computed Tomography"},
+		{Value: "DX", Designator: "DCM", Meaning: "Digital Radiography"},
+		{Value: "MG", Designator: "DCM", Meaning: "Mammography"},
+		{Value: "MR", Designator: "DCM", Meaning: "Magnetic Resonance"},
+		{Value: "NM", Designator: "DCM", Meaning: "Nuclear Medicine"},
+		{Value: "PT", Designator: "DCM", Meaning: "Positron emission tomography"},
+		{Value: "US", Designator: "DCM", Meaning: "Ultrasound"},
+		{Value: "XA", Designator: "DCM", Meaning: "X-Ray Angiography"},
+	}},
+	244: {CID: 244, Name: "Laterality", Codes: []Code{
+		{Value: "24028007", Designator: "SCT", Meaning: "Right"},
+		{Value: "7771000", Designator: "SCT", Meaning: "Left"},
+		{Value: "51440002", Designator: "SCT", Meaning: "Right and left"},
+		{Value: "66459002", Designator: "SCT", Meaning: "Unilateral"},
+	}},
+	7007: {CID: 7007, Name: "Signature Purpose", Codes: []Code{
+		{Value: "1", Designator: "ASTM-sigpurpose", Meaning: "Author's Signature"},
+		{Value: "2", Designator: "ASTM-sigpurpose", Meaning: "Coauthor's Signature"},
+		{Value: "3", Designator: "ASTM-sigpurpose", Meaning: "Co-participant's Signature"},
+		{Value: "4", Designator: "ASTM-sigpurpose", Meaning: "Transcriptionist/Recorder Signature"},
+		{Value: "5", Designator: "ASTM-sigpurpose", Meaning: "Verification Signature"},
+		{Value: "6", Designator: "ASTM-sigpurpose", Meaning: "Validation Signature"},
+		{Value: "7", Designator: "ASTM-sigpurpose", Meaning: "Consent Signature"},
+		{Value: "8", Designator: "ASTM-sigpurpose", Meaning: "Signature Witness Signature"},
+	}},
+}
+
+// LookupContextGroup returns the context group "cid", e.g., 244 for
+// Laterality. Only a few common groups are built in: CID 29 (Acquisition
+// Modality), 244 (Laterality) and 7007 (Signature Purpose).
+func LookupContextGroup(cid int) (ContextGroup, error) {
+	g, ok := contextGroups[cid]
+	if !ok {
+		return ContextGroup{}, fmt.Errorf("dicom.LookupContextGroup: unknown context group CID %d", cid)
+	}
+	return g, nil
+}
@@ -0,0 +1,316 @@
+package dicom
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strconv"
+	"sync"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Codec decodes the frames of an encapsulated (compressed) transfer syntax.
+// Package dicom has codecs for JPEG Baseline, JPEG-LS and RLE Lossless;
+// others, e.g., JPEG 2000 Lossless (1.2.840.10008.1.2.4.90) and JPEG 2000
+// (1.2.840.10008.1.2.4.91) through an OpenJPEG binding, are plugged in with
+// RegisterCodec.
+type Codec interface {
+	// DecodeFrame decodes one frame. "ds" is the data set the frame belongs
+	// to, for attributes such as BitsStored and PhotometricInterpretation.
+	DecodeFrame(ds *DataSet, frame []byte) (image.Image, error)
+}
+
+// FrameEncoder is implemented by Codecs that can also encode frames, for
+// DataSet.EncodeFrames.
+type FrameEncoder interface {
+	EncodeFrame(ds *DataSet, img image.Image) ([]byte, error)
+}
+
+// RegionDecoder is implemented by Codecs that can decode part of a frame
+// without decoding all of it, e.g., JPEG 2000 and HTJ2K codecs that decode
+// only the tiles and resolution levels needed, for DataSet.DecodeFrameRegion.
+type RegionDecoder interface {
+	// DecodeFrameRegion decodes the pixels of "frame" in "rect", at 1/2^reduce
+	// of the full resolution. The bounds of the image are rect with each
+	// coordinate divided by 2^reduce, rounded up.
+	DecodeFrameRegion(ds *DataSet, frame []byte, rect image.Rectangle, reduce int) (image.Image, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"1.2.840.10008.1.2.4.50": jpegBaselineCodec{}, // JPEG Baseline
+		"1.2.840.10008.1.2.4.80": jpegLSCodec{},       // JPEG-LS Lossless
+		"1.2.840.10008.1.2.4.81": jpegLSCodec{},       // JPEG-LS Near-Lossless
+		"1.2.840.10008.1.2.5":    rleCodec{},          // RLE Lossless
+	}
+)
+
+// RegisterCodec registers "c" for the transfer syntax "transferSyntaxUID",
+// replacing the codec registered for it, if any. Thread safe.
+func RegisterCodec(transferSyntaxUID string, c Codec) {
+	codecsMu.Lock()
+	codecs[transferSyntaxUID] = c
+	codecsMu.Unlock()
+}
+
+// LookupCodec returns the codec registered for "transferSyntaxUID". Thread
+// safe.
+func LookupCodec(transferSyntaxUID string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[transferSyntaxUID]
+	return c, ok
+}
+
+// jpegBaselineCodec 用image/jpeg编码和解码JPEG Baseline (Process 1)
+type jpegBaselineCodec struct{}
+
+func (jpegBaselineCodec) DecodeFrame(ds *DataSet, frame []byte) (image.Image, error) {
+	if len(frame) < 2 || frame[0] != 0xff || frame[1] != 0xd8 {
+		return nil, fmt.Errorf("not a JPEG image (no SOI marker)")
+	}
+	return jpeg.Decode(bytes.NewReader(frame))
+}
+
+// jpegQuality 是EncodeFrame使用的JPEG质量. JPEG Baseline是有损的
+const jpegQuality = 90
+
+func (jpegBaselineCodec) EncodeFrame(ds *DataSet, img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// nativeImage 把native pixel data (little endian, 每个pixel的samples交错排列)转换成image.Image:
+// 8 bit或16 bit的灰度图像是*image.Gray或*image.Gray16, 8 bit的三个sample(RGB)是*image.RGBA
+func nativeImage(native []byte, rows, columns, samplesPerPixel, bitsAllocated int) (image.Image, error) {
+	rect := image.Rect(0, 0, columns, rows)
+	switch {
+	case samplesPerPixel == 1 && bitsAllocated == 8:
+		return &image.Gray{Pix: native, Stride: columns, Rect: rect}, nil
+	case samplesPerPixel == 1 && bitsAllocated == 16:
+		img := image.NewGray16(rect)
+		for i := 0; i < len(native); i += 2 {
+			// image.Gray16是big endian
+			img.Pix[i], img.Pix[i+1] = native[i+1], native[i]
+		}
+		return img, nil
+	case samplesPerPixel == 3 && bitsAllocated == 8:
+		img := image.NewRGBA(rect)
+		for i := 0; i < rows*columns; i++ {
+			copy(img.Pix[4*i:], native[3*i:3*i+3])
+			img.Pix[4*i+3] = 0xff
+		}
+		return img, nil
+	}
+	return nil, fmt.Errorf("%d samples of %d bits can't be converted to an image.Image", samplesPerPixel, bitsAllocated)
+}
+
+// imageNative 是nativeImage的反向转换: *image.Gray是8 bit, *image.Gray16是16 bit,
+// 其他图像被转换成8 bit RGB
+func imageNative(img image.Image) (native []byte, samplesPerPixel, bitsAllocated int) {
+	b := img.Bounds()
+	switch img := img.(type) {
+	case *image.Gray:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			native = append(native, img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]...)
+		}
+		return native, 1, 8
+	case *image.Gray16:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				v := img.Gray16At(x, y).Y
+				native = append(native, byte(v), byte(v>>8))
+			}
+		}
+		return native, 1, 16
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			native = append(native, c.R, c.G, c.B)
+		}
+	}
+	return native, 3, 8
+}
+
+// transferSyntaxUID 返回f的TransferSyntaxUID
+func (f *DataSet) transferSyntaxUID() (string, error) {
+	elem, err := f.FindElementByTag(dicomtag.TransferSyntaxUID)
+	if err != nil {
+		return "", err
+	}
+	return elem.GetString()
+}
+
+// DecodeFrame decodes the i-th frame (starting at 0) of the encapsulated
+// PixelData of "f" with the codec registered for its transfer syntax.
+func (f *DataSet) DecodeFrame(i int) (img image.Image, err error) {
+	defer dicomio.Recover(&err)
+	codec, frame, err := f.frameCodec(i)
+	if err != nil {
+		return nil, err
+	}
+	img, err = codec.DecodeFrame(f, frame)
+	if err != nil {
+		return nil, fmt.Errorf("dicom.DecodeFrame: frame %d: %v", i, err)
+	}
+	return img, nil
+}
+
+// frameCodec 返回第i个frame的数据和f的transfer syntax的codec
+func (f *DataSet) frameCodec(i int) (Codec, []byte, error) {
+	uid, err := f.transferSyntaxUID()
+	if err != nil {
+		return nil, nil, err
+	}
+	codec, ok := LookupCodec(uid)
+	if !ok {
+		return nil, nil, fmt.Errorf("dicom.DecodeFrame: no codec registered for transfer syntax %s", uid)
+	}
+	pixelData, err := f.FindElementByTag(dicomtag.PixelData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pixelData.Value) != 1 || !pixelData.UndefinedLength {
+		return nil, nil, fmt.Errorf("dicom.DecodeFrame: PixelData is not encapsulated")
+	}
+	image, ok := pixelData.Value[0].(PixelDataInfo)
+	if !ok {
+		return nil, nil, fmt.Errorf("dicom.DecodeFrame: PixelData must have one value of type PixelDataInfo")
+	}
+	numFrames, err := intValue(f, dicomtag.NumberOfFrames, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	frame, err := image.frameData(i, numFrames)
+	if err != nil {
+		return nil, nil, err
+	}
+	return codec, frame, nil
+}
+
+// maxReduce 是DecodeFrameRegion的reduce的上限. JPEG 2000最多有32个resolution level
+const maxReduce = 32
+
+// DecodeFrameRegion decodes the pixels in "rect" of the i-th frame of the
+// encapsulated PixelData of "f", at 1/2^reduce of the full resolution, so
+// that tile-based viewers can fetch a viewport at the zoom level they
+// display. rect is in full-resolution pixel coordinates, (0, 0) being the
+// top-left pixel of the frame; it is clipped to the frame. The returned
+// image's bounds are rect with each coordinate divided by 2^reduce, rounded
+// up, as JPEG 2000 resolution levels are.
+//
+// If the codec is a RegionDecoder, only the region is decoded. Otherwise the
+// whole frame is decoded, then cropped and subsampled: each pixel of the
+// result is the top-left pixel of its 2^reduce x 2^reduce block, without the
+// low-pass filtering of JPEG 2000.
+func (f *DataSet) DecodeFrameRegion(i int, rect image.Rectangle, reduce int) (img image.Image, err error) {
+	defer dicomio.Recover(&err)
+	if reduce < 0 || reduce > maxReduce {
+		return nil, fmt.Errorf("dicom.DecodeFrameRegion: invalid reduce %d", reduce)
+	}
+	var dims [2]int64
+	for j, tag := range []dicomtag.Tag{dicomtag.Rows, dicomtag.Columns} {
+		if dims[j], err = intValue(f, tag, 0); err != nil {
+			return nil, err
+		}
+	}
+	rect = rect.Intersect(image.Rect(0, 0, int(dims[1]), int(dims[0])))
+	if rect.Empty() {
+		return nil, fmt.Errorf("dicom.DecodeFrameRegion: region is outside of the %dx%d frame", dims[1], dims[0])
+	}
+	codec, frame, err := f.frameCodec(i)
+	if err != nil {
+		return nil, err
+	}
+	if rd, ok := codec.(RegionDecoder); ok {
+		img, err = rd.DecodeFrameRegion(f, frame, rect, reduce)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.DecodeFrameRegion: frame %d: %v", i, err)
+		}
+		return img, nil
+	}
+	img, err = codec.DecodeFrame(f, frame)
+	if err != nil {
+		return nil, fmt.Errorf("dicom.DecodeFrameRegion: frame %d: %v", i, err)
+	}
+	return reduceRegion(img, rect, reduce), nil
+}
+
+// regionBounds 返回rect在1/2^reduce分辨率下的范围, 坐标向上取整
+func regionBounds(rect image.Rectangle, reduce int) image.Rectangle {
+	scale := func(v int) int { return (v + 1<<uint(reduce) - 1) >> uint(reduce) }
+	return image.Rect(scale(rect.Min.X), scale(rect.Min.Y), scale(rect.Max.X), scale(rect.Max.Y))
+}
+
+// reduceRegion 返回img在rect中的pixels, 每2^reduce x 2^reduce个pixel取左上角的一个.
+// rect的坐标相对于img.Bounds().Min. *image.Gray, *image.Gray16和*image.RGBA保持类型,
+// 其他图像被转换成*image.RGBA64
+func reduceRegion(img image.Image, rect image.Rectangle, reduce int) image.Image {
+	origin := img.Bounds().Min
+	bounds := regionBounds(rect, reduce)
+	var out interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch img.(type) {
+	case *image.Gray:
+		out = image.NewGray(bounds)
+	case *image.Gray16:
+		out = image.NewGray16(bounds)
+	case *image.RGBA:
+		out = image.NewRGBA(bounds)
+	default:
+		out = image.NewRGBA64(bounds)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			out.Set(x, y, img.At(origin.X+x<<uint(reduce), origin.Y+y<<uint(reduce)))
+		}
+	}
+	return out
+}
+
+// EncodeFrames replaces the PixelData of "f" with "frames" encoded by the
+// codec registered for "transferSyntaxUID", which must implement
+// FrameEncoder. It sets TransferSyntaxUID, and NumberOfFrames if there are
+// several frames. The other Image Pixel attributes (Rows, Columns,
+// PhotometricInterpretation, ...) must be set by the caller to match the
+// encoded frames; for a lossy syntax, see SetLossyImageCompression.
+func (f *DataSet) EncodeFrames(transferSyntaxUID string, frames []image.Image) (err error) {
+	defer dicomio.Recover(&err)
+	codec, ok := LookupCodec(transferSyntaxUID)
+	if !ok {
+		return fmt.Errorf("dicom.EncodeFrames: no codec registered for transfer syntax %s", transferSyntaxUID)
+	}
+	encoder, ok := codec.(FrameEncoder)
+	if !ok {
+		return fmt.Errorf("dicom.EncodeFrames: the codec for transfer syntax %s can't encode", transferSyntaxUID)
+	}
+	var image PixelDataInfo
+	var offset uint32
+	for i, img := range frames {
+		frame, err := encoder.EncodeFrame(f, img)
+		if err != nil {
+			return fmt.Errorf("dicom.EncodeFrames: frame %d: %v", i, err)
+		}
+		image.Offsets = append(image.Offsets, offset)
+		image.Frames = append(image.Frames, frame)
+		// 每个fragment有8 bytes的item header, 并且被补齐成偶数长度
+		offset += 8 + paddedLength(frame)
+	}
+	f.Replace(&Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true, Value: []interface{}{image}}, "")
+	f.Replace(MustNewElement(dicomtag.TransferSyntaxUID, transferSyntaxUID), "")
+	if len(frames) > 1 {
+		f.Replace(MustNewElement(dicomtag.NumberOfFrames, strconv.Itoa(len(frames))), "")
+	}
+	return nil
+}
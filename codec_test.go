@@ -0,0 +1,121 @@
+package dicom_test
+
+import (
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJ2KCodec "encodes" a gray image as a J2K SOC marker, the width and the
+// pixels.
+type fakeJ2KCodec struct{}
+
+func (fakeJ2KCodec) DecodeFrame(ds *dicom.DataSet, frame []byte) (image.Image, error) {
+	if len(frame) < 3 || frame[0] != 0xff || frame[1] != 0x4f {
+		return nil, fmt.Errorf("not a J2K codestream")
+	}
+	width := int(frame[2])
+	img := image.NewGray(image.Rect(0, 0, width, (len(frame)-3)/width))
+	copy(img.Pix, frame[3:])
+	return img, nil
+}
+
+func (fakeJ2KCodec) EncodeFrame(ds *dicom.DataSet, img image.Image) ([]byte, error) {
+	gray := img.(*image.Gray)
+	return append([]byte{0xff, 0x4f, byte(gray.Rect.Dx())}, gray.Pix...), nil
+}
+
+func TestCodecs(t *testing.T) {
+	newImage := func(level uint8) *image.Gray {
+		img := image.NewGray(image.Rect(0, 0, 4, 2))
+		for i := range img.Pix {
+			img.Pix[i] = level
+		}
+		return img
+	}
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	require.Error(t, ds.EncodeFrames(dicomtest.JPEG2000, []image.Image{newImage(1)}))
+
+	dicom.RegisterCodec(dicomtest.JPEG2000, fakeJ2KCodec{})
+	codec, ok := dicom.LookupCodec(dicomtest.JPEG2000)
+	require.True(t, ok)
+	require.Equal(t, fakeJ2KCodec{}, codec)
+
+	for _, uid := range []string{dicomtest.JPEG2000, dicomtest.JPEGBaseline} {
+		ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+		require.NoError(t, ds.EncodeFrames(uid, []image.Image{newImage(10), newImage(200)}))
+		ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+		elem, err := ds.FindElementByTag(dicomtag.TransferSyntaxUID)
+		require.NoError(t, err)
+		require.Equal(t, uid, elem.MustGetString())
+		elem, err = ds.FindElementByTag(dicomtag.NumberOfFrames)
+		require.NoError(t, err)
+		require.Equal(t, "2", elem.MustGetString())
+		for i, level := range []uint8{10, 200} {
+			img, err := ds.DecodeFrame(i)
+			require.NoError(t, err, uid)
+			require.Equal(t, image.Rect(0, 0, 4, 2), img.Bounds())
+			require.InDelta(t, level, img.(*image.Gray).GrayAt(1, 1).Y, 2)
+		}
+	}
+
+	// Without a codec, decoding fails.
+	ds = mustReadBytes(dicomtest.MustBytes(dicomtest.Spec{TransferSyntaxUID: dicomtest.JPEGLossless}), dicom.ReadOptions{})
+	_, err := ds.DecodeFrame(0)
+	require.Error(t, err)
+}
+
+// fakeTiledCodec is a fakeJ2KCodec that decodes regions itself.
+type fakeTiledCodec struct {
+	fakeJ2KCodec
+	regions *[]image.Rectangle
+}
+
+func (c fakeTiledCodec) DecodeFrameRegion(ds *dicom.DataSet, frame []byte, rect image.Rectangle, reduce int) (image.Image, error) {
+	*c.regions = append(*c.regions, rect)
+	return image.NewGray(image.Rect(rect.Min.X>>uint(reduce), rect.Min.Y>>uint(reduce), rect.Max.X>>uint(reduce), rect.Max.Y>>uint(reduce))), nil
+}
+
+func TestDecodeFrameRegion(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 4))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	require.NoError(t, ds.PutUInt16(dicomtag.Rows, 4))
+	require.NoError(t, ds.PutUInt16(dicomtag.Columns, 8))
+	require.NoError(t, ds.PutUInt16(dicomtag.SamplesPerPixel, 1))
+	require.NoError(t, ds.PutUInt16(dicomtag.BitsAllocated, 8))
+	require.NoError(t, ds.EncodeFrames(dicomtest.RLELossless, []image.Image{img}))
+
+	// RLE decodes the whole frame, then crops and subsamples.
+	region, err := ds.DecodeFrameRegion(0, image.Rect(2, 1, 6, 3), 0)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(2, 1, 6, 3), region.Bounds())
+	require.Equal(t, uint8(8+2), region.(*image.Gray).GrayAt(2, 1).Y)
+	region, err = ds.DecodeFrameRegion(0, image.Rect(3, 0, 100, 100), 1)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(2, 0, 4, 2), region.Bounds())
+	require.Equal(t, uint8(2*8+6), region.(*image.Gray).GrayAt(3, 1).Y)
+	_, err = ds.DecodeFrameRegion(0, image.Rect(8, 0, 10, 4), 0)
+	require.Error(t, err)
+
+	// A RegionDecoder decodes the region itself.
+	const htj2k = "1.2.840.10008.1.2.4.201"
+	var regions []image.Rectangle
+	dicom.RegisterCodec(htj2k, fakeTiledCodec{regions: &regions})
+	ds = newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	require.NoError(t, ds.PutUInt16(dicomtag.Rows, 4))
+	require.NoError(t, ds.PutUInt16(dicomtag.Columns, 8))
+	require.NoError(t, ds.EncodeFrames(htj2k, []image.Image{img}))
+	region, err = ds.DecodeFrameRegion(0, image.Rect(4, 0, 8, 4), 2)
+	require.NoError(t, err)
+	require.Equal(t, []image.Rectangle{image.Rect(4, 0, 8, 4)}, regions)
+	require.Equal(t, image.Rect(1, 0, 2, 1), region.Bounds())
+}
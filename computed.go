@@ -0,0 +1,199 @@
+package dicom
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// ComputedElement derives an attribute from other attributes of a DataSet,
+// e.g., PatientAge from PatientBirthDate and StudyDate. Register it with
+// RegisterComputedElement; it is then used by DataSet.FindOrComputeElement
+// and DataSet.AddComputedElements, and by WriteDataSet if OnWrite is set.
+// A computed element never replaces an element that is in the DataSet.
+type ComputedElement struct {
+	Tag dicomtag.Tag
+
+	// Compute 返回计算出的element. 缺少需要的element时返回(nil, nil), 表示不能计算
+	Compute func(ds *DataSet) (*Element, error)
+
+	// OnWrite 使WriteDataSet在DataSet中没有Tag时写入计算出的element.
+	// 被写的DataSet本身不会被修改
+	OnWrite bool
+}
+
+var (
+	computedMu       sync.RWMutex
+	computedElements = map[dicomtag.Tag]ComputedElement{}
+)
+
+// RegisterComputedElement registers "c", replacing the ComputedElement
+// registered for the same tag, if any. Thread safe.
+func RegisterComputedElement(c ComputedElement) {
+	computedMu.Lock()
+	computedElements[c.Tag] = c
+	computedMu.Unlock()
+}
+
+// UnregisterComputedElement removes the ComputedElement registered for
+// "tag". Thread safe.
+func UnregisterComputedElement(tag dicomtag.Tag) {
+	computedMu.Lock()
+	delete(computedElements, tag)
+	computedMu.Unlock()
+}
+
+// registeredComputedElements 返回按tag排序的ComputedElements
+func registeredComputedElements() []ComputedElement {
+	computedMu.RLock()
+	defer computedMu.RUnlock()
+	list := make([]ComputedElement, 0, len(computedElements))
+	for _, c := range computedElements {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tag.Compare(list[j].Tag) < 0 })
+	return list
+}
+
+// FindOrComputeElement is similar to FindElementByTag, but if "f" has no
+// element with "tag", it computes one with the ComputedElement registered
+// for the tag. The computed element is not added to f.
+func (f *DataSet) FindOrComputeElement(tag dicomtag.Tag) (*Element, error) {
+	if elem, err := f.FindElementByTag(tag); err == nil {
+		return elem, nil
+	}
+	computedMu.RLock()
+	c, ok := computedElements[tag]
+	computedMu.RUnlock()
+	if ok {
+		elem, err := c.Compute(f)
+		if err != nil {
+			return nil, fmt.Errorf("dicom: computing %s: %v", dicomtag.DebugString(tag), err)
+		}
+		if elem != nil {
+			return elem, nil
+		}
+	}
+	return nil, fmt.Errorf("dicom: element %s not found and can't be computed", dicomtag.DebugString(tag))
+}
+
+// AddComputedElements adds every registered ComputedElement that "f" lacks
+// and that can be computed, in tag order, so that an element can be computed
+// from one with a smaller tag. Additions are recorded in f.ChangeLog.
+func (f *DataSet) AddComputedElements() error {
+	return f.addComputedElements(false)
+}
+
+func (f *DataSet) addComputedElements(onWriteOnly bool) error {
+	for _, c := range registeredComputedElements() {
+		if onWriteOnly && !c.OnWrite {
+			continue
+		}
+		if _, err := f.FindElementByTag(c.Tag); err == nil {
+			continue
+		}
+		elem, err := c.Compute(f)
+		if err != nil {
+			return fmt.Errorf("dicom: computing %s: %v", dicomtag.DebugString(c.Tag), err)
+		}
+		if elem != nil {
+			f.Replace(elem, "")
+		}
+	}
+	return nil
+}
+
+// ComputedPatientAge computes PatientAge (0010,1010) from PatientBirthDate
+// and StudyDate, in years, or in months, weeks or days for patients younger
+// than a year, a month or a week, as the AS VR requires.
+var ComputedPatientAge = ComputedElement{
+	Tag: dicomtag.PatientAge,
+	Compute: func(ds *DataSet) (*Element, error) {
+		birth, ok, err := dateValue(ds, dicomtag.PatientBirthDate)
+		if !ok || err != nil {
+			return nil, err
+		}
+		study, ok, err := dateValue(ds, dicomtag.StudyDate)
+		if !ok || err != nil {
+			return nil, err
+		}
+		if study.Before(birth) {
+			return nil, fmt.Errorf("StudyDate %s is before PatientBirthDate %s", study.Format("20060102"), birth.Format("20060102"))
+		}
+		age, err := ageString(birth, study)
+		if err != nil {
+			return nil, err
+		}
+		return NewElement(dicomtag.PatientAge, age)
+	},
+}
+
+// ComputedNumberOfFrames computes NumberOfFrames (0028,0008) from the
+// frames in PixelData: the number of fragments of encapsulated pixel data,
+// or of PixelDataInfo.Frames of native pixel data.
+var ComputedNumberOfFrames = ComputedElement{
+	Tag: dicomtag.NumberOfFrames,
+	Compute: func(ds *DataSet) (*Element, error) {
+		pixelData, err := ds.FindElementByTag(dicomtag.PixelData)
+		if err != nil || len(pixelData.Value) != 1 {
+			return nil, nil
+		}
+		image, ok := pixelData.Value[0].(PixelDataInfo)
+		if !ok || len(image.Frames) == 0 {
+			return nil, nil
+		}
+		return NewElement(dicomtag.NumberOfFrames, strconv.Itoa(len(image.Frames)))
+	},
+}
+
+// dateValue 返回DA element的值. element不存在或者为空时ok为false.
+// 也接受ACR-NEMA的"YYYY.MM.DD"格式
+func dateValue(ds *DataSet, tag dicomtag.Tag) (t time.Time, ok bool, err error) {
+	elem, err := ds.FindElementByTag(tag)
+	if err != nil {
+		return t, false, nil
+	}
+	s, err := elem.GetString()
+	if err != nil {
+		return t, false, nil
+	}
+	s = strings.Replace(strings.TrimSpace(s), ".", "", -1)
+	if s == "" {
+		return t, false, nil
+	}
+	t, err = time.Parse("20060102", s)
+	if err != nil {
+		return t, false, fmt.Errorf("%s: %v", dicomtag.DebugString(tag), err)
+	}
+	return t, true, nil
+}
+
+// ageString 返回从birth到date的AS格式的年龄, 例如"045Y", "011M", "003W", "006D"
+func ageString(birth, date time.Time) (string, error) {
+	months := (date.Year()-birth.Year())*12 + int(date.Month()) - int(birth.Month())
+	if date.Day() < birth.Day() {
+		months--
+	}
+	days := int(date.Sub(birth).Hours() / 24)
+	var n int
+	var unit string
+	switch {
+	case months >= 12:
+		n, unit = months/12, "Y"
+	case months >= 1:
+		n, unit = months, "M"
+	case days >= 7:
+		n, unit = days/7, "W"
+	default:
+		n, unit = days, "D"
+	}
+	if n > 999 {
+		return "", fmt.Errorf("age %d%s doesn't fit in AS", n, unit)
+	}
+	return fmt.Sprintf("%03d%s", n, unit), nil
+}
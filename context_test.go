@@ -0,0 +1,41 @@
+package dicom_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContext(t *testing.T) {
+	data := dicomtest.MustBytes(dicomtest.Spec{TransferSyntaxUID: dicomuid.ImplicitVRLittleEndian, BitsAllocated: 16, NumberOfFrames: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	ds, err := dicom.ReadDataSetWithContext(ctx, bytes.NewReader(data), dicom.ReadOptions{})
+	require.NoError(t, err)
+
+	cancel()
+	partial, err := dicom.ReadDataSetWithContext(ctx, bytes.NewReader(data), dicom.ReadOptions{})
+	require.Equal(t, context.Canceled, err)
+	// 只有meta elements在取消之前被读了
+	for _, elem := range partial.Elements {
+		require.Equal(t, uint16(dicomtag.MetadataGroup), elem.Tag.Group, elem.String())
+	}
+	_, err = dicom.Read(bytes.NewReader(data), dicom.WithContext(ctx))
+	require.Equal(t, context.Canceled, err)
+
+	require.Equal(t, context.Canceled, dicom.WriteDataSetWithContext(ctx, ioutil.Discard, ds))
+	require.Equal(t, context.Canceled, dicom.Write(ioutil.Discard, ds, dicom.WithContext(ctx)))
+	require.NoError(t, dicom.WriteDataSetWithContext(context.Background(), ioutil.Discard, ds))
+
+	require.Equal(t, context.Canceled, dicom.TranscodeWithContext(ctx, ds, dicomtest.RLELossless))
+	uid, err := ds.FindElementByTag(dicomtag.TransferSyntaxUID)
+	require.NoError(t, err)
+	require.Equal(t, dicomuid.ImplicitVRLittleEndian, uid.MustGetString())
+	require.NoError(t, dicom.TranscodeWithContext(context.Background(), ds, dicomtest.RLELossless))
+}
@@ -0,0 +1,263 @@
+package dicom
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// FlatElement is one leaf element returned by DataSet.Flatten.
+type FlatElement struct {
+	// Path identifies the element inside the dataset, e.g.,
+	// "0040,0275[0]/0032,1060" is the RequestedProcedureDescription in the
+	// first item of RequestAttributesSequence. A top-level element's path is
+	// just its tag, e.g., "0010,0010".
+	Path string
+
+	Element *Element
+}
+
+// Flatten returns every leaf (i.e., non-SQ) element in the dataset, including
+// the ones nested in sequences, in the order they appear in the dataset.
+// Sequence elements themselves are not returned; their items only show up as
+// part of the paths.
+func (f *DataSet) Flatten() []FlatElement {
+	var result []FlatElement
+	for _, elem := range f.Elements {
+		result = flattenElement(result, "", elem)
+	}
+	return result
+}
+
+func flattenElement(result []FlatElement, prefix string, elem *Element) []FlatElement {
+	path := prefix + tagPathString(elem.Tag)
+	if elem.VR != "SQ" {
+		return append(result, FlatElement{Path: path, Element: elem})
+	}
+	for i, value := range elem.Value {
+		item, ok := value.(*Element)
+		if !ok {
+			continue
+		}
+		itemPrefix := fmt.Sprintf("%s[%d]/", path, i)
+		for _, v := range item.Value {
+			if subelem, ok := v.(*Element); ok {
+				result = flattenElement(result, itemPrefix, subelem)
+			}
+		}
+	}
+	return result
+}
+
+// SkipSequence can be returned by the function passed to DataSet.Walk for
+// an SQ element, to skip the elements in its items.
+var SkipSequence = errors.New("skip this sequence")
+
+// Walk calls "fn" for every element in the dataset in the order they appear,
+// descending into the items of sequences: an SQ element is visited before
+// the elements of its items. "path" holds the tags from the top-level
+// element down to "e", so path[len(path)-1] == e.Tag and len(path) == 1 for
+// top-level elements. The path slice is reused between calls; copy it to
+// keep it.
+//
+// If fn returns SkipSequence for an SQ element, its items are skipped. Any
+// other non-nil error stops the walk and is returned by Walk.
+func (f *DataSet) Walk(fn func(path []dicomtag.Tag, e *Element) error) error {
+	return walkElements(nil, f.Elements, fn)
+}
+
+func walkElements(path []dicomtag.Tag, elems []*Element, fn func(path []dicomtag.Tag, e *Element) error) error {
+	for _, elem := range elems {
+		elemPath := append(path, elem.Tag)
+		err := fn(elemPath, elem)
+		if err == SkipSequence && elem.VR == "SQ" {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if elem.VR != "SQ" {
+			continue
+		}
+		for _, value := range elem.Value {
+			if item, ok := value.(*Element); ok {
+				if err := walkElements(elemPath, itemElements(item), fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// CheckStructure checks the structural invariants that WriteDataSet and
+// other readers of the dataset rely on:
+//
+//   - at each level (the dataset, and each item), tags are strictly
+//     increasing, so there are no duplicates;
+//   - Items appear only as values of SQ elements, and SQ values are Items;
+//   - delimitation items are not stored as elements;
+//   - PixelData is the last element of its level, except for
+//     DigitalSignaturesSequence and DataSetTrailingPadding.
+//
+// It returns an error describing the first violation found, with the path
+// of the offending element in the format of FlatElement.Path. It doesn't
+// check values against VRs.
+func (f *DataSet) CheckStructure() error {
+	return checkStructure(f.Elements, "")
+}
+
+func checkStructure(elems []*Element, prefix string) error {
+	var last *Element
+	for i, elem := range elems {
+		if elem == nil {
+			return fmt.Errorf("dicom.CheckStructure: %selement #%d is nil", prefix, i)
+		}
+		path := prefix + tagPathString(elem.Tag)
+		switch elem.Tag {
+		case dicomtag.Item:
+			return fmt.Errorf("dicom.CheckStructure: %s: Item outside of a sequence", path)
+		case dicomtag.ItemDelimitationItem, dicomtag.SequenceDelimitationItem:
+			return fmt.Errorf("dicom.CheckStructure: %s: delimitation item stored as an element", path)
+		}
+		if last != nil {
+			if elem.Tag.Compare(last.Tag) <= 0 {
+				return fmt.Errorf("dicom.CheckStructure: %s: tag is not greater than the previous tag %s",
+					path, tagPathString(last.Tag))
+			}
+			if last.Tag == dicomtag.PixelData && elem.Tag != dicomtag.DigitalSignaturesSequence && elem.Tag != dicomtag.DataSetTrailingPadding {
+				return fmt.Errorf("dicom.CheckStructure: %s: element after PixelData", path)
+			}
+		}
+		last = elem
+
+		if elem.VR != "SQ" {
+			continue
+		}
+		for j, value := range elem.Value {
+			item, ok := value.(*Element)
+			if !ok || item.Tag != dicomtag.Item {
+				return fmt.Errorf("dicom.CheckStructure: %s[%d]: sequence value is not an Item: %v", path, j, value)
+			}
+			subelems := make([]*Element, len(item.Value))
+			for k, v := range item.Value {
+				subelem, ok := v.(*Element)
+				if !ok {
+					return fmt.Errorf("dicom.CheckStructure: %s[%d]: item value is not an element: %v", path, j, v)
+				}
+				subelems[k] = subelem
+			}
+			if err := checkStructure(subelems, fmt.Sprintf("%s[%d]/", path, j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tagPathString 返回 "gggg,eeee" 格式的tag, 用于element path
+func tagPathString(tag dicomtag.Tag) string {
+	return fmt.Sprintf("%04X,%04X", tag.Group, tag.Element)
+}
+
+// Put replaces the element with the same tag as "elem", or inserts elem in
+// tag order if there is none, keeping f.Elements sorted without duplicates.
+// It is Replace without a reason; the change is recorded if f.ChangeLog is
+// set.
+func (f *DataSet) Put(elem *Element) {
+	f.Replace(elem, "")
+}
+
+// PutString puts an element with the given string values, e.g.,
+//
+//  err := ds.PutString(dicomtag.PatientName, "Zhang^San")
+//
+// It returns an error if the VR of "tag" isn't a string VR.
+func (f *DataSet) PutString(tag dicomtag.Tag, values ...string) error {
+	v := make([]interface{}, len(values))
+	for i, value := range values {
+		v[i] = value
+	}
+	return f.put(tag, v)
+}
+
+// PutUInt16 puts an element with the given uint16 values, e.g., Rows. It
+// returns an error if the VR of "tag" isn't US.
+func (f *DataSet) PutUInt16(tag dicomtag.Tag, values ...uint16) error {
+	v := make([]interface{}, len(values))
+	for i, value := range values {
+		v[i] = value
+	}
+	return f.put(tag, v)
+}
+
+// PutUInt32 puts an element with the given uint32 values. It returns an
+// error if the VR of "tag" isn't UL.
+func (f *DataSet) PutUInt32(tag dicomtag.Tag, values ...uint32) error {
+	v := make([]interface{}, len(values))
+	for i, value := range values {
+		v[i] = value
+	}
+	return f.put(tag, v)
+}
+
+// Delete removes the top-level element with "tag". It returns false if
+// there is none. If f.ChangeLog is set, the deletion is recorded.
+func (f *DataSet) Delete(tag dicomtag.Tag) bool {
+	for i, elem := range f.Elements {
+		if elem.Tag == tag {
+			f.Elements = append(f.Elements[:i], f.Elements[i+1:]...)
+			f.recordChange(tag, elem, nil, "")
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteGroup removes every top-level element in "group", e.g., a private
+// group, and returns the number of elements removed. Deletions are recorded
+// as with Delete. Removing the file meta group (2) makes the dataset
+// unwritable.
+func (f *DataSet) DeleteGroup(group uint16) int {
+	kept := f.Elements[:0]
+	n := 0
+	for _, elem := range f.Elements {
+		if elem.Tag.Group == group {
+			f.recordChange(elem.Tag, elem, nil, "")
+			n++
+			continue
+		}
+		kept = append(kept, elem)
+	}
+	f.Elements = kept
+	return n
+}
+
+func (f *DataSet) put(tag dicomtag.Tag, values []interface{}) error {
+	elem, err := NewElement(tag, values...)
+	if err != nil {
+		return err
+	}
+	f.Put(elem)
+	return nil
+}
+
+// setElement 用elem替换f中相同tag的element并返回被替换的element.
+// 如果不存在, 按tag顺序插入elem并返回nil
+func (f *DataSet) setElement(elem *Element) *Element {
+	for i, e := range f.Elements {
+		switch c := e.Tag.Compare(elem.Tag); {
+		case c == 0:
+			f.Elements[i] = elem
+			return e
+		case c > 0:
+			f.Elements = append(f.Elements, nil)
+			copy(f.Elements[i+1:], f.Elements[i:])
+			f.Elements[i] = elem
+			return nil
+		}
+	}
+	f.Elements = append(f.Elements, elem)
+	return nil
+}
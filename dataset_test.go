@@ -0,0 +1,580 @@
+package dicom_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatten(t *testing.T) {
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.PatientName, "Zhang^San"),
+		dicom.MustNewElement(dicomtag.RequestAttributesSequence,
+			dicom.MustNewElement(dicomtag.Item,
+				dicom.MustNewElement(dicomtag.RequestedProcedureDescription, "CT HEAD"),
+				dicom.MustNewElement(dicomtag.ScheduledProcedureStepID, "S1")),
+			dicom.MustNewElement(dicomtag.Item,
+				dicom.MustNewElement(dicomtag.RequestedProcedureDescription, "CT CHEST"))),
+		dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3"),
+	}}
+	flat := ds.Flatten()
+	var paths []string
+	for _, f := range flat {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{
+		"0010,0010",
+		"0040,0275[0]/0032,1060",
+		"0040,0275[0]/0040,0009",
+		"0040,0275[1]/0032,1060",
+		"0020,000D",
+	}, paths)
+	assert.Equal(t, "CT CHEST", flat[3].Element.MustGetString())
+}
+
+func TestWalk(t *testing.T) {
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.PatientName, "Zhang^San"),
+		dicom.MustNewElement(dicomtag.RequestAttributesSequence,
+			dicom.MustNewElement(dicomtag.Item,
+				dicom.MustNewElement(dicomtag.RequestedProcedureDescription, "CT HEAD")),
+			dicom.MustNewElement(dicomtag.Item,
+				dicom.MustNewElement(dicomtag.RequestedProcedureDescription, "CT CHEST"))),
+		dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3"),
+	}}
+	var paths [][]dicomtag.Tag
+	require.NoError(t, ds.Walk(func(path []dicomtag.Tag, e *dicom.Element) error {
+		assert.Equal(t, e.Tag, path[len(path)-1])
+		paths = append(paths, append([]dicomtag.Tag(nil), path...))
+		return nil
+	}))
+	assert.Equal(t, [][]dicomtag.Tag{
+		{dicomtag.PatientName},
+		{dicomtag.RequestAttributesSequence},
+		{dicomtag.RequestAttributesSequence, dicomtag.RequestedProcedureDescription},
+		{dicomtag.RequestAttributesSequence, dicomtag.RequestedProcedureDescription},
+		{dicomtag.StudyInstanceUID},
+	}, paths)
+
+	n := 0
+	require.NoError(t, ds.Walk(func(path []dicomtag.Tag, e *dicom.Element) error {
+		n++
+		if e.VR == "SQ" {
+			return dicom.SkipSequence
+		}
+		return nil
+	}))
+	assert.Equal(t, 3, n)
+
+	errStop := errors.New("stop")
+	n = 0
+	assert.Equal(t, errStop, ds.Walk(func(path []dicomtag.Tag, e *dicom.Element) error {
+		n++
+		if len(path) > 1 {
+			return errStop
+		}
+		return nil
+	}))
+	assert.Equal(t, 3, n)
+}
+
+func TestCineHelpers(t *testing.T) {
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.RecommendedDisplayFrameRate, "30"),
+		dicom.MustNewElement(dicomtag.FrameTime, "33.3"),
+		dicom.MustNewElement(dicomtag.NumberOfFrames, "3"),
+	}}
+	frameTime, err := ds.FrameTime()
+	require.NoError(t, err)
+	assert.Equal(t, 33300*time.Microsecond, frameTime)
+	rate, err := ds.RecommendedDisplayFrameRate()
+	require.NoError(t, err)
+	assert.Equal(t, 30.0, rate)
+	offsets, err := ds.FrameOffsets()
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{0, 33300 * time.Microsecond, 66600 * time.Microsecond}, offsets)
+
+	for _, n := range []string{"-1", "0", "1.5"} {
+		ds.Put(dicom.MustNewElement(dicomtag.NumberOfFrames, n))
+		_, err = ds.FrameOffsets()
+		assert.Error(t, err, n)
+	}
+
+	// FrameTimeVector wins over FrameTime.
+	ds.Elements = append(ds.Elements, dicom.MustNewElement(dicomtag.FrameTimeVector, "0", "40", " 20.5"))
+	offsets, err = ds.FrameOffsets()
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{0, 40 * time.Millisecond, 60500 * time.Microsecond}, offsets)
+
+	frame := func(dt string) []*dicom.Element {
+		return []*dicom.Element{dicom.MustNewSequence(dicomtag.FrameContentSequence, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.FrameAcquisitionDateTime, dt),
+		})}
+	}
+	ds = &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewSequence(dicomtag.PerFrameFunctionalGroupsSequence,
+			frame("20200102030405.1"), frame("20200102030405.35"), frame("20200102030406+0100")),
+	}}
+	times, err := ds.FrameAcquisitionTimes()
+	require.NoError(t, err)
+	require.Len(t, times, 3)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 100000000, time.UTC), times[0])
+	assert.Equal(t, time.Date(2020, 1, 2, 2, 4, 6, 0, time.UTC), times[2].UTC())
+	offsets, err = ds.FrameOffsets()
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{0, 250 * time.Millisecond, -59*time.Minute - 59*time.Second - 100*time.Millisecond}, offsets)
+
+	_, err = ds.FrameTime()
+	assert.Error(t, err)
+	_, err = dicom.ParseDateTime("2020010")
+	assert.Error(t, err)
+	dt, err := dicom.ParseDateTime("2020")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), dt)
+}
+
+func TestPixelSpacing(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	_, err := ds.PixelSpacing()
+	assert.Equal(t, dicom.ErrNoPixelSpacing, err)
+
+	require.NoError(t, ds.PutString(dicomtag.ImagerPixelSpacing, "0.2", "0.2"))
+	spacing, err := ds.PixelSpacing()
+	require.NoError(t, err)
+	assert.Equal(t, dicom.PixelSpacing{Row: 0.2, Column: 0.2, Source: dicom.PixelSpacingDetector}, spacing)
+
+	require.NoError(t, ds.PutString(dicomtag.DistanceSourceToDetector, "1000"))
+	require.NoError(t, ds.PutString(dicomtag.DistanceSourceToPatient, "800"))
+	spacing, err = ds.PixelSpacing()
+	require.NoError(t, err)
+	assert.Equal(t, dicom.PixelSpacingEstimated, spacing.Source)
+	assert.InDelta(t, 0.16, spacing.Row, 1e-9)
+	require.NoError(t, ds.PutString(dicomtag.EstimatedRadiographicMagnificationFactor, "1.6"))
+	spacing, err = ds.PixelSpacing()
+	require.NoError(t, err)
+	assert.InDelta(t, 0.125, spacing.Column, 1e-9)
+
+	// PixelSpacing of an enhanced multi-frame image.
+	measures := dicom.MustNewElement(dicomtag.PixelSpacing, "0.5", "0.25")
+	pixelMeasures, err := dicom.NewSequence(dicomtag.PixelMeasuresSequence, []*dicom.Element{measures})
+	require.NoError(t, err)
+	shared, err := dicom.NewSequence(dicomtag.SharedFunctionalGroupsSequence, []*dicom.Element{pixelMeasures})
+	require.NoError(t, err)
+	ds.Put(shared)
+	spacing, err = ds.PixelSpacing()
+	require.NoError(t, err)
+	assert.Equal(t, dicom.PixelSpacing{Row: 0.5, Column: 0.25, Source: dicom.PixelSpacingPatient}, spacing)
+
+	require.NoError(t, ds.PutString(dicomtag.PixelSpacing, "0.3", "0.3"))
+	spacing, err = ds.PixelSpacing()
+	require.NoError(t, err)
+	assert.Equal(t, dicom.PixelSpacing{Row: 0.3, Column: 0.3, Source: dicom.PixelSpacingPatient}, spacing)
+
+	require.NoError(t, ds.PutString(dicomtag.PixelSpacing, "0.3"))
+	_, err = ds.PixelSpacing()
+	assert.Error(t, err)
+}
+
+func TestModels(t *testing.T) {
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.SOPClassUID, "1.2.840.10008.5.1.4.1.1.2"),
+		dicom.MustNewElement(dicomtag.SOPInstanceUID, "1.2.3.4.5"),
+		dicom.MustNewElement(dicomtag.StudyDate, "20200102"),
+		dicom.MustNewElement(dicomtag.Modality, "CT"),
+		dicom.MustNewElement(dicomtag.PatientName, "Zhang^San"),
+		dicom.MustNewElement(dicomtag.PatientID, "P0001"),
+		dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3"),
+		dicom.MustNewElement(dicomtag.SeriesInstanceUID, "1.2.3.4"),
+		dicom.MustNewElement(dicomtag.SeriesNumber, " 12"),
+		dicom.MustNewElement(dicomtag.InstanceNumber, "3"),
+	}}
+
+	var patient dicom.Patient
+	require.NoError(t, patient.FromDataSet(ds))
+	assert.Equal(t, dicom.Patient{ID: "P0001", Name: "Zhang^San"}, patient)
+	var study dicom.Study
+	require.NoError(t, study.FromDataSet(ds))
+	assert.Equal(t, dicom.Study{InstanceUID: "1.2.3", Date: "20200102"}, study)
+	var series dicom.Series
+	require.NoError(t, series.FromDataSet(ds))
+	assert.Equal(t, dicom.Series{InstanceUID: "1.2.3.4", Number: 12, Modality: "CT"}, series)
+	var instance dicom.Instance
+	require.NoError(t, instance.FromDataSet(ds))
+	assert.Equal(t, dicom.Instance{SOPClassUID: "1.2.840.10008.5.1.4.1.1.2", SOPInstanceUID: "1.2.3.4.5", Number: 3}, instance)
+
+	// ApplyTo replaces existing elements and inserts new ones in tag order.
+	patient.Name = "Li^Si"
+	patient.Sex = "M"
+	require.NoError(t, patient.ApplyTo(ds))
+	series.Description = "AXIAL"
+	require.NoError(t, series.ApplyTo(ds))
+	var tags []dicomtag.Tag
+	for _, elem := range ds.Elements {
+		tags = append(tags, elem.Tag)
+	}
+	assert.Equal(t, []dicomtag.Tag{
+		dicomtag.SOPClassUID, dicomtag.SOPInstanceUID, dicomtag.StudyDate, dicomtag.Modality,
+		dicomtag.SeriesDescription, dicomtag.PatientName, dicomtag.PatientID, dicomtag.PatientSex,
+		dicomtag.StudyInstanceUID, dicomtag.SeriesInstanceUID, dicomtag.SeriesNumber, dicomtag.InstanceNumber,
+	}, tags)
+	elem, err := ds.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Li^Si", elem.MustGetString())
+
+	ds.Elements = append(ds.Elements, dicom.MustNewElement(dicomtag.NumberOfFrames, "many"))
+	assert.Error(t, instance.FromDataSet(ds))
+}
+
+func TestCheckStructure(t *testing.T) {
+	valid := func() []*dicom.Element {
+		return []*dicom.Element{
+			dicom.MustNewElement(dicomtag.PatientName, "Zhang^San"),
+			dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3"),
+			dicom.MustNewSequence(dicomtag.RequestAttributesSequence, []*dicom.Element{
+				dicom.MustNewElement(dicomtag.RequestedProcedureDescription, "CT HEAD"),
+				dicom.MustNewElement(dicomtag.ScheduledProcedureStepID, "S1"),
+			}),
+			dicom.MustNewElement(dicomtag.PixelData, dicom.PixelDataInfo{Frames: [][]byte{{0, 0}}}),
+			dicom.MustNewElement(dicomtag.DataSetTrailingPadding, []byte{0, 0}),
+		}
+	}
+	ds := &dicom.DataSet{Elements: valid()}
+	require.NoError(t, ds.CheckStructure())
+
+	tests := []struct {
+		modify func(elems []*dicom.Element) []*dicom.Element
+		err    string
+	}{
+		{func(elems []*dicom.Element) []*dicom.Element {
+			elems[0], elems[1] = elems[1], elems[0]
+			return elems
+		}, "0010,0010: tag is not greater than the previous tag 0020,000D"},
+		{func(elems []*dicom.Element) []*dicom.Element {
+			return append(elems[:1], elems[0:]...)
+		}, "0010,0010: tag is not greater"},
+		{func(elems []*dicom.Element) []*dicom.Element {
+			item := elems[2].Value[0].(*dicom.Element)
+			item.Value[0], item.Value[1] = item.Value[1], item.Value[0]
+			return elems
+		}, "0040,0275[0]/0032,1060: tag is not greater than the previous tag 0040,0009"},
+		{func(elems []*dicom.Element) []*dicom.Element {
+			return append([]*dicom.Element{dicom.NewItem()}, elems...)
+		}, "FFFE,E000: Item outside of a sequence"},
+		{func(elems []*dicom.Element) []*dicom.Element {
+			elems[2].Value = append(elems[2].Value, dicom.MustNewElement(dicomtag.PatientID, "P1"))
+			return elems
+		}, "0040,0275[1]: sequence value is not an Item"},
+		{func(elems []*dicom.Element) []*dicom.Element {
+			return append(elems, &dicom.Element{Tag: dicomtag.SequenceDelimitationItem})
+		}, "FFFE,E0DD: delimitation item stored as an element"},
+		{func(elems []*dicom.Element) []*dicom.Element {
+			return append(elems[:4], &dicom.Element{Tag: dicomtag.Tag{Group: 0x7FE1, Element: 0x0010}, VR: "OB"})
+		}, "7FE1,0010: element after PixelData"},
+	}
+	for _, test := range tests {
+		ds := &dicom.DataSet{Elements: test.modify(valid())}
+		err := ds.CheckStructure()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), test.err)
+	}
+}
+
+func TestChangeLog(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Replace(dicom.MustNewElement(dicomtag.PatientID, "NOT-LOGGED"), "")
+
+	ds.ChangeLog = &dicom.ChangeLog{ModifyingSystem: "GATEWAY"}
+	oldID, err := ds.FindElementByTag(dicomtag.PatientID)
+	require.NoError(t, err)
+	ds.Replace(dicom.MustNewElement(dicomtag.PatientID, "P0002"), "CORRECT")
+	ds.Replace(dicom.MustNewElement(dicomtag.PatientID, "P0003"), "CORRECT")
+	ds.Replace(dicom.MustNewElement(dicomtag.StudyDescription, "CT HEAD"), "")
+	require.Len(t, ds.ChangeLog.Changes, 3)
+	change := ds.ChangeLog.Changes[0]
+	assert.Equal(t, dicomtag.PatientID, change.Tag)
+	assert.Equal(t, oldID, change.Old)
+	assert.Equal(t, "P0002", change.New.MustGetString())
+	assert.Equal(t, "CORRECT", change.Reason)
+	assert.False(t, change.Time.IsZero())
+	assert.Nil(t, ds.ChangeLog.Changes[2].Old)
+
+	require.NoError(t, ds.AddOriginalAttributes())
+	assert.Empty(t, ds.ChangeLog.Changes)
+	require.NoError(t, ds.CheckStructure())
+
+	ds2, err := dicom.ReadDataSetInBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	require.NoError(t, err)
+	seq, err := ds2.FindElementByTag(dicomtag.OriginalAttributesSequence)
+	require.NoError(t, err)
+	require.Len(t, seq.Value, 2)
+	values := map[string]string{}
+	for _, flat := range ds2.Flatten() {
+		if s, err := flat.Element.GetString(); err == nil {
+			values[flat.Path] = s
+		} else if len(flat.Element.Value) == 0 {
+			values[flat.Path] = "<empty>"
+		}
+	}
+	assert.Equal(t, "NOT-LOGGED", values["0400,0561[0]/0400,0550[0]/0010,0020"])
+	assert.Equal(t, "GATEWAY", values["0400,0561[0]/0400,0563"])
+	assert.Equal(t, "CORRECT", values["0400,0561[0]/0400,0565"])
+	assert.Equal(t, "<empty>", values["0400,0561[1]/0400,0550[0]/0008,1030"])
+	assert.Equal(t, "COERCE", values["0400,0561[1]/0400,0565"])
+	_, err = dicom.ParseDateTime(values["0400,0561[1]/0400,0562"])
+	assert.NoError(t, err)
+}
+
+func TestPut(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	n := len(ds.Elements)
+	require.NoError(t, ds.PutString(dicomtag.PatientName, "Li^Si"))
+	require.NoError(t, ds.PutString(dicomtag.Modality, "MR"))
+	require.NoError(t, ds.PutUInt16(dicomtag.Rows, 512))
+	require.NoError(t, ds.PutUInt32(dicomtag.SimpleFrameList, 1, 3))
+	ds.Put(dicom.MustNewElement(dicomtag.PatientID, "P0002"))
+	require.Len(t, ds.Elements, n+3)
+	require.NoError(t, ds.CheckStructure())
+	elem, err := ds.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Li^Si", elem.MustGetString())
+	elem, err = ds.FindElementByTag(dicomtag.Rows)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(512), elem.MustGetUInt16())
+
+	// Wrong VR.
+	assert.Error(t, ds.PutString(dicomtag.Rows, "512"))
+	assert.Error(t, ds.PutUInt16(dicomtag.PatientName, 1))
+	assert.Len(t, ds.Elements, n+3)
+}
+
+func TestDelete(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Put(&dicom.Element{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x0010}, VR: "LO", Value: []interface{}{"ACME"}})
+	ds.Put(&dicom.Element{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1001}, VR: "LO", Value: []interface{}{"secret"}})
+	n := len(ds.Elements)
+
+	ds.ChangeLog = &dicom.ChangeLog{}
+	assert.True(t, ds.Delete(dicomtag.PatientName))
+	assert.False(t, ds.Delete(dicomtag.PatientName))
+	assert.Equal(t, 2, ds.DeleteGroup(0x0009))
+	assert.Equal(t, 0, ds.DeleteGroup(0x0009))
+	assert.Len(t, ds.Elements, n-3)
+	_, err := ds.FindElementByTag(dicomtag.PatientName)
+	assert.Error(t, err)
+	require.NoError(t, ds.CheckStructure())
+
+	require.Len(t, ds.ChangeLog.Changes, 3)
+	assert.Equal(t, dicomtag.PatientName, ds.ChangeLog.Changes[0].Tag)
+	assert.Equal(t, "Zhang^San", ds.ChangeLog.Changes[0].Old.MustGetString())
+	assert.Nil(t, ds.ChangeLog.Changes[0].New)
+}
+
+func TestFileNameSanitizer(t *testing.T) {
+	s := dicom.DefaultFileNameSanitizer
+	for _, test := range []struct{ value, want string }{
+		{"Zhang^San^^^", "Zhang_San___"},
+		{"1.2.840.10008.5.1.4.1.1.2 ", "1.2.840.10008.5.1.4.1.1.2"},
+		{"CT HEAD/NECK", "CT_HEAD-NECK"},
+		{`a:b*c?"d"<e>|f\g`, "a_b_c__d__e__f_g"},
+		{"tab\there", "tab_here"},
+		{"张^三", "张_三"},
+		{"..", "_"},
+		{"", "_"},
+		{"...hidden.", "hidden"},
+		{"con", "con_"},
+		{"LPT1.txt", "LPT1.txt_"},
+		{"bad\xffutf8", "bad_utf8"},
+	} {
+		assert.Equal(t, test.want, s.Sanitize(test.value), test.value)
+	}
+
+	custom := &dicom.FileNameSanitizer{Replacements: map[rune]string{'^': ""}, Default: "-", MaxLength: 4}
+	assert.Equal(t, "Zhan", custom.Sanitize("Zhang^San"))
+	assert.Equal(t, "张", custom.Sanitize("张三"), "must not split a UTF-8 sequence")
+
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.PatientName, "Doe^John"),
+		dicom.MustNewElement(dicomtag.ImageType, "ORIGINAL", "PRIMARY"),
+	}}
+	name, err := s.SanitizeElement(ds, dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Doe_John", name)
+	name, err = s.SanitizeElement(ds, dicomtag.ImageType)
+	require.NoError(t, err)
+	assert.Equal(t, "ORIGINAL_PRIMARY", name)
+	_, err = s.SanitizeElement(ds, dicomtag.StudyDescription)
+	assert.Error(t, err)
+}
+
+func TestComputedElements(t *testing.T) {
+	dicom.RegisterComputedElement(dicom.ComputedPatientAge)
+	defer dicom.UnregisterComputedElement(dicomtag.PatientAge)
+
+	for _, test := range []struct {
+		birth, study, age string
+	}{
+		{"19800615", "20240614", "043Y"},
+		{"19800615", "20240615", "044Y"},
+		{"20240101", "20240615", "005M"},
+		{"20240601", "20240615", "002W"},
+		{"20240612", "20240615", "003D"},
+		{"2024.06.12", "2024.06.15", "003D"},
+	} {
+		ds := &dicom.DataSet{Elements: []*dicom.Element{
+			dicom.MustNewElement(dicomtag.StudyDate, test.study),
+			dicom.MustNewElement(dicomtag.PatientBirthDate, test.birth),
+		}}
+		elem, err := ds.FindOrComputeElement(dicomtag.PatientAge)
+		require.NoError(t, err)
+		assert.Equal(t, test.age, elem.MustGetString(), test)
+		_, err = ds.FindElementByTag(dicomtag.PatientAge)
+		assert.Error(t, err, "not added")
+	}
+
+	// Elements in the data set take precedence; missing inputs can't be computed.
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.StudyDate, "20240615"),
+		dicom.MustNewElement(dicomtag.PatientAge, "030Y"),
+	}}
+	elem, err := ds.FindOrComputeElement(dicomtag.PatientAge)
+	require.NoError(t, err)
+	assert.Equal(t, "030Y", elem.MustGetString())
+	_, err = (&dicom.DataSet{}).FindOrComputeElement(dicomtag.PatientAge)
+	assert.Error(t, err)
+	_, err = (&dicom.DataSet{}).FindOrComputeElement(dicomtag.NumberOfFrames)
+	assert.Error(t, err, "not registered")
+
+	// AddComputedElements adds them to the data set, and OnWrite elements
+	// are added when writing.
+	numberOfFrames := dicom.ComputedNumberOfFrames
+	numberOfFrames.OnWrite = true
+	dicom.RegisterComputedElement(numberOfFrames)
+	defer dicom.UnregisterComputedElement(dicomtag.NumberOfFrames)
+	ds = newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	for _, elem := range []*dicom.Element{
+		dicom.MustNewElement(dicomtag.PatientBirthDate, "19800615"),
+		dicom.MustNewElement(dicomtag.StudyDate, "20240615"),
+		{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+			Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{{1, 2}, {3, 4}}}}},
+	} {
+		ds.Replace(elem, "")
+	}
+	ds2 := mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	_, err = ds.FindElementByTag(dicomtag.NumberOfFrames)
+	assert.Error(t, err, "ds must not be modified")
+	elem, err = ds2.FindElementByTag(dicomtag.NumberOfFrames)
+	require.NoError(t, err)
+	assert.Equal(t, "2", elem.MustGetString())
+	_, err = ds2.FindElementByTag(dicomtag.PatientAge)
+	assert.Error(t, err, "not OnWrite")
+
+	require.NoError(t, ds.AddComputedElements())
+	elem, err = ds.FindElementByTag(dicomtag.PatientAge)
+	require.NoError(t, err)
+	assert.Equal(t, "044Y", elem.MustGetString())
+}
+
+func TestDecodeLUT(t *testing.T) {
+	// Modality LUT: 4个entry, 第一个输入值-1000; implicit VR时LUTData被读成OW
+	ds := newTestDataSet(dicomuid.ImplicitVRLittleEndian)
+	ds.Replace(dicom.MustNewElement(dicomtag.PixelRepresentation, uint16(1)), "")
+	ds.Replace(dicom.MustNewSequence(dicomtag.ModalityLUTSequence, []*dicom.Element{
+		dicom.MustNewElement(dicomtag.LUTDescriptor, uint16(4), uint16(0xfc18), uint16(16)),
+		dicom.MustNewElement(dicomtag.LUTData, []byte{0, 0, 0x10, 0, 0x20, 0, 0xff, 0xff}),
+	}), "")
+	ds, err := dicom.ReadDataSetInBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	require.NoError(t, err)
+	seq, err := ds.FindElementByTag(dicomtag.ModalityLUTSequence)
+	require.NoError(t, err)
+	item := seq.Value[0].(*dicom.Element)
+	var elems []*dicom.Element
+	for _, v := range item.Value {
+		elems = append(elems, v.(*dicom.Element))
+	}
+	lut, err := dicom.DecodeLUT(elems, dicomtag.LUTDescriptor, dicomtag.LUTData, true)
+	require.NoError(t, err)
+	assert.Equal(t, &dicom.LUT{FirstMapped: -1000, Bits: 16, Data: []uint16{0, 0x10, 0x20, 0xffff}}, lut)
+	assert.Equal(t, uint16(0), lut.Lookup(-2000))
+	assert.Equal(t, uint16(0x20), lut.Lookup(-998))
+	assert.Equal(t, uint16(0xffff), lut.Lookup(0))
+	lut, err = dicom.DecodeLUT(elems, dicomtag.LUTDescriptor, dicomtag.LUTData, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0xfc18, lut.FirstMapped)
+
+	// 8-bit palette, 两个entry一个word
+	palette := []*dicom.Element{
+		dicom.MustNewElement(dicomtag.RedPaletteColorLookupTableDescriptor, uint16(3), uint16(0), uint16(8)),
+		dicom.MustNewElement(dicomtag.RedPaletteColorLookupTableData, []byte{1, 2, 3, 0}),
+	}
+	lut, err = dicom.DecodeLUT(palette, dicomtag.RedPaletteColorLookupTableDescriptor, dicomtag.RedPaletteColorLookupTableData, false)
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{1, 2, 3}, lut.Data)
+	palette[0] = dicom.MustNewElement(dicomtag.RedPaletteColorLookupTableDescriptor, uint16(5), uint16(0), uint16(16))
+	_, err = dicom.DecodeLUT(palette, dicomtag.RedPaletteColorLookupTableDescriptor, dicomtag.RedPaletteColorLookupTableData, false)
+	assert.Error(t, err)
+
+	// Pixel padding: OW的padding value和SS的range limit
+	padding := &dicom.Element{Tag: dicomtag.PixelPaddingValue, VR: "OW", Value: []interface{}{[]byte{0x18, 0xfc}}}
+	values, err := padding.DecodeInt16s()
+	require.NoError(t, err)
+	assert.Equal(t, []int16{-1000}, values)
+	ds = &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.PixelRepresentation, uint16(1)),
+		padding,
+		{Tag: dicomtag.PixelPaddingRangeLimit, VR: "SS", Value: []interface{}{int16(-1024)}},
+	}}
+	low, high, ok, err := ds.PixelPaddingRange()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []int{-1024, -1000}, []int{low, high})
+	ds.Elements[0] = dicom.MustNewElement(dicomtag.PixelRepresentation, uint16(0))
+	ds.Elements = ds.Elements[:2]
+	low, high, ok, err = ds.PixelPaddingRange()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []int{0xfc18, 0xfc18}, []int{low, high})
+	_, _, ok, err = (&dicom.DataSet{}).PixelPaddingRange()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCodes(t *testing.T) {
+	finding := dicom.Code{Value: "121071", Designator: "DCM", Meaning: "Finding"}
+	long := dicom.Code{Value: "1234567890.1234567890", Designator: "99LOCAL", Meaning: "Long"}
+	urn := dicom.Code{Value: "urn:oid:1.2.3", Meaning: "URN"}
+	seq, err := dicom.NewCodeSequence(dicomtag.ConceptNameCodeSequence, finding, long, urn)
+	require.NoError(t, err)
+	ds := &dicom.DataSet{Elements: []*dicom.Element{seq}}
+	require.NoError(t, ds.CheckStructure())
+	codes, err := ds.Codes(dicomtag.ConceptNameCodeSequence)
+	require.NoError(t, err)
+	assert.Equal(t, []dicom.Code{finding, long, urn}, codes)
+	item := seq.Value[1].(*dicom.Element).Value
+	assert.Equal(t, dicomtag.LongCodeValue, item[len(item)-1].(*dicom.Element).Tag)
+	assert.Equal(t, `(121071, DCM, "Finding")`, finding.String())
+
+	codes, err = ds.Codes(dicomtag.AnatomicRegionSequence)
+	assert.NoError(t, err)
+	assert.Nil(t, codes)
+	_, err = dicom.CodeFromItem(dicom.NewItem(dicom.MustNewElement(dicomtag.CodeValue, "121071")))
+	assert.Error(t, err)
+	_, err = dicom.CodeFromItem(dicom.NewItem(dicom.MustNewElement(dicomtag.CodeMeaning, "Finding")))
+	assert.Error(t, err)
+
+	laterality, err := dicom.LookupContextGroup(244)
+	require.NoError(t, err)
+	left, ok := laterality.FindByMeaning("left")
+	require.True(t, ok)
+	assert.True(t, left.Equal(dicom.Code{Value: "7771000", Designator: "SCT", Meaning: "Left side"}))
+	assert.True(t, laterality.Contains(left))
+	_, ok = laterality.Find("7771000", "SRT")
+	assert.False(t, ok)
+	_, err = dicom.LookupContextGroup(1)
+	assert.Error(t, err)
+}
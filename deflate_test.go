@@ -0,0 +1,70 @@
+package dicom_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeflated(t *testing.T) {
+	ds := newTestDataSet(dicomuid.DeflatedExplicitVRLittleEndian)
+	ds.Elements = append(ds.Elements, dicom.MustNewElement(dicomtag.StudyDescription, strings.Repeat("deflate me ", 100)+"please"))
+	data := mustWriteDataSet(ds)
+	require.False(t, bytes.Contains(data, []byte("Zhang^San")), "data set not deflated")
+	require.True(t, bytes.Contains(data, []byte(dicomuid.DeflatedExplicitVRLittleEndian)), "file meta deflated")
+
+	check := func(read *dicom.DataSet) {
+		for _, tag := range []dicomtag.Tag{dicomtag.PatientName, dicomtag.StudyDescription, dicomtag.SeriesInstanceUID} {
+			expected, err := ds.FindElementByTag(tag)
+			require.NoError(t, err)
+			elem, err := read.FindElementByTag(tag)
+			require.NoError(t, err)
+			require.Equal(t, expected.Value, elem.Value)
+		}
+	}
+	check(mustReadBytes(data, dicom.ReadOptions{}))
+
+	// After the file meta group, which ends after its group length, is the
+	// raw deflated Explicit VR Little Endian data set.
+	require.Equal(t, []byte{2, 0, 0, 0, 'U', 'L'}, data[132:138])
+	metaEnd := 144 + int(binary.LittleEndian.Uint32(data[140:]))
+	inflated, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data[metaEnd:])))
+	require.NoError(t, err)
+	explicit := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	explicit.Elements = append(explicit.Elements, ds.Elements[len(explicit.Elements):]...)
+	require.True(t, bytes.HasSuffix(mustWriteDataSet(explicit), inflated))
+
+	salvaged, err := dicom.Salvage(bytes.NewReader(data))
+	require.NoError(t, err)
+	check(salvaged)
+
+	// Salvage stops inflating at 64 times the size of the deflated data,
+	// and keeps what it inflated so far.
+	bomb := newTestDataSet(dicomuid.DeflatedExplicitVRLittleEndian)
+	bomb.Elements = append(bomb.Elements, dicom.MustNewElement(dicomtag.EncapsulatedDocument, make([]byte, 1<<20)))
+	salvaged, err = dicom.Salvage(bytes.NewReader(mustWriteDataSet(bomb)))
+	salvageErr, ok := err.(*dicom.SalvageError)
+	require.True(t, ok, "error: %v", err)
+	require.Contains(t, salvageErr.Problems[0].Message, "inflates to more than")
+	elem, err := salvaged.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	require.Equal(t, "Zhang^San", elem.MustGetString())
+
+	// Transcode to and from Deflated.
+	ds = mustReadBytes(dicomtest.MustBytes(dicomtest.Spec{}), dicom.ReadOptions{})
+	require.NoError(t, dicom.Transcode(ds, dicomuid.DeflatedExplicitVRLittleEndian))
+	ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	require.NoError(t, dicom.Transcode(ds, dicomuid.ExplicitVRLittleEndian))
+	elem, err = ds.FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	require.Equal(t, dicomtest.FramePixels(dicomtest.Spec{}, 0), elem.Value[0].(dicom.PixelDataInfo).Frames[0])
+}
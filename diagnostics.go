@@ -0,0 +1,143 @@
+package dicom
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Kinds of Diagnostic.
+const (
+	// DiagnosticWarning is a problem found while reading that was left as
+	// is, e.g., trailing garbage after the last element.
+	DiagnosticWarning = "warning"
+	// DiagnosticRepair is a problem found while reading that the reader
+	// worked around, e.g., elements out of tag order, which were sorted.
+	DiagnosticRepair = "repair"
+	// DiagnosticFinding is a problem found by a check of the data set, e.g.,
+	// CheckValueLengths.
+	DiagnosticFinding = "finding"
+)
+
+// Diagnostic is one problem of a data set, see DataSet.Diagnostics.
+type Diagnostic struct {
+	// Kind 是DiagnosticWarning, DiagnosticRepair或DiagnosticFinding
+	Kind string
+
+	// Tag 是有问题的element, 与整个data set有关时为nil
+	Tag *dicomtag.Tag
+
+	// Offset 是问题在文件中的位置(bytes), 不知道时为-1
+	Offset int64
+
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	s := d.Kind + ": "
+	if d.Tag != nil {
+		s += dicomtag.DebugString(*d.Tag) + ": "
+	}
+	return s + d.Message
+}
+
+// Diagnostics is the report of DataSet.Diagnostics. It is meant to be
+// stored as JSON next to the instance, e.g.:
+//
+//	{"sopInstanceUID": "1.2.3", "warnings": 0, "repairs": 1, "findings": 1,
+//	 "diagnostics": [
+//	   {"kind": "repair", "offset": 1234, "message": "..."},
+//	   {"kind": "finding", "tag": "00080050", "message": "..."}]}
+//
+// Tags are in the format of the DICOM JSON model (P3.18 F.2.1.1).
+type Diagnostics struct {
+	SOPInstanceUID string
+	Diagnostics    []Diagnostic
+}
+
+// Count returns the number of diagnostics of "kind".
+func (d Diagnostics) Count(kind string) int {
+	n := 0
+	for _, diag := range d.Diagnostics {
+		if diag.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// MarshalJSON encodes the report as described for Diagnostics.
+func (d Diagnostics) MarshalJSON() ([]byte, error) {
+	type jsonDiagnostic struct {
+		Kind    string `json:"kind"`
+		Tag     string `json:"tag,omitempty"`
+		Offset  *int64 `json:"offset,omitempty"`
+		Message string `json:"message"`
+	}
+	report := struct {
+		SOPInstanceUID string           `json:"sopInstanceUID,omitempty"`
+		Warnings       int              `json:"warnings"`
+		Repairs        int              `json:"repairs"`
+		Findings       int              `json:"findings"`
+		Diagnostics    []jsonDiagnostic `json:"diagnostics"`
+	}{
+		SOPInstanceUID: d.SOPInstanceUID,
+		Warnings:       d.Count(DiagnosticWarning),
+		Repairs:        d.Count(DiagnosticRepair),
+		Findings:       d.Count(DiagnosticFinding),
+		Diagnostics:    []jsonDiagnostic{},
+	}
+	for _, diag := range d.Diagnostics {
+		j := jsonDiagnostic{Kind: diag.Kind, Message: diag.Message}
+		if diag.Tag != nil {
+			j.Tag = fmt.Sprintf("%04X%04X", diag.Tag.Group, diag.Tag.Element)
+		}
+		if diag.Offset >= 0 {
+			offset := diag.Offset
+			j.Offset = &offset
+		}
+		report.Diagnostics = append(report.Diagnostics, j)
+	}
+	return json.Marshal(report)
+}
+
+// Diagnostics returns the problems that ReadDataSet or Salvage found while
+// reading "f", followed by the findings of CheckStructure,
+// CheckValueLengths (of every element, including nested ones) and
+// CheckFrameCount. The checks run on the current elements, so the report
+// reflects modifications made after reading; the reading problems don't
+// change.
+func (f *DataSet) Diagnostics() Diagnostics {
+	d := Diagnostics{}
+	if elem, err := f.FindElementByTag(dicomtag.SOPInstanceUID); err == nil {
+		d.SOPInstanceUID, _ = elem.GetString()
+	}
+	d.Diagnostics = append(d.Diagnostics, f.diagnostics...)
+	finding := func(tag *dicomtag.Tag, err error) {
+		d.Diagnostics = append(d.Diagnostics, Diagnostic{Kind: DiagnosticFinding, Tag: tag, Offset: -1, Message: err.Error()})
+	}
+	if err := f.CheckStructure(); err != nil {
+		finding(nil, err)
+	}
+	for _, flat := range f.Flatten() {
+		if err := flat.Element.CheckValueLengths(); err != nil {
+			tag := flat.Element.Tag
+			finding(&tag, fmt.Errorf("%s: %v", flat.Path, err))
+		}
+	}
+	if err := f.CheckFrameCount(); err != nil {
+		tag := dicomtag.PixelData
+		finding(&tag, err)
+	}
+	return d
+}
+
+// newDiagnostic 创建一个读取时发现的问题. tag为零值表示与整个data set有关, offset为-1表示不知道位置
+func newDiagnostic(kind string, tag dicomtag.Tag, offset int64, format string, args ...interface{}) Diagnostic {
+	d := Diagnostic{Kind: kind, Offset: offset, Message: fmt.Sprintf(format, args...)}
+	if tag != (dicomtag.Tag{}) {
+		d.Tag = &tag
+	}
+	return d
+}
@@ -1,23 +1,32 @@
 package dicom_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
 	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
 	"github.com/stretchr/testify/require"
+	"image"
+	"io"
+	"io/ioutil"
 	"log"
+	"sync"
 	"testing"
 )
 
-func mustReadFile(path string, options dicom.ReadOptions) *dicom.DataSet {
-	data, err := dicom.ReadDataSetFromFile(path, options)
+func mustReadBytes(data []byte, options dicom.ReadOptions) *dicom.DataSet {
+	ds, err := dicom.ReadDataSetInBytes(data, options)
 	if err != nil {
 		log.Panic(err)
 	}
-	return data
+	return ds
 }
 func Example_read() {
-	ds, err := dicom.ReadDataSetFromFile("examples/IM-0001-0003.dcm", dicom.ReadOptions{})
+	ds, err := dicom.ReadDataSetInBytes(dicomtest.MustBytes(dicomtest.Spec{}), dicom.ReadOptions{})
 	if err != nil {
 		panic(err)
 	}
@@ -25,7 +34,7 @@ func Example_read() {
 	if err != nil {
 		panic(err)
 	}
-	patientBirthDate, err := ds.FindElementByTag(dicomtag.PatientBirthDate)
+	studyDate, err := ds.FindElementByTag(dicomtag.StudyDate)
 	if err != nil {
 		panic(err)
 	}
@@ -34,15 +43,15 @@ func Example_read() {
 		panic(err)
 	}
 	fmt.Println("ID: " + patientID.String())
-	fmt.Println("BirthDate: " + patientBirthDate.String())
+	fmt.Println("StudyDate: " + studyDate.String())
 	fmt.Println("InstitutionName: " + institutionName.String())
 	// Output:
-	// ID:  (0010,0020)[PatientID] LO  [7DkT2Tp]
-	// BirthDate:  (0010,0030)[PatientBirthDate] DA  [19530828]
-	//InstitutionName:  (0008,0080)[InstitutionName] LO  [UCLA  Medical Center]
+	// ID:  (0010,0020)[PatientID] LO  [DICOMTEST-1]
+	// StudyDate:  (0008,0020)[StudyDate] DA  [20200102]
+	// InstitutionName:  (0008,0080)[InstitutionName] LO  [Test Hospital]
 }
 func Example_updateExistingFile() {
-	ds, err := dicom.ReadDataSetFromFile("examples/IM-0001-0003.dcm", dicom.ReadOptions{})
+	ds, err := dicom.ReadDataSetInBytes(dicomtest.MustBytes(dicomtest.Spec{}), dicom.ReadOptions{})
 	if err != nil {
 		panic(err)
 	}
@@ -52,19 +61,16 @@ func Example_updateExistingFile() {
 	}
 	patientID.Value = []interface{}{"Zhang San"}
 
-	//buf := bytes.Buffer{}
-	//if err := dicom.WriteDataSet(&buf, ds); err != nil {
-	//	panic(err)
-	//}
-	//
-	//ds2, err := dicom.ReadDataSet(&buf, dicom.ReadOptions{})
-	//if err != nil {
-	//	panic(err)
-	//}
-	if err := dicom.WriteDataSetToFile("examples/test_write.dcm", ds); err != nil {
+	buf := bytes.Buffer{}
+	if err := dicom.WriteDataSet(&buf, ds); err != nil {
 		panic(err)
 	}
-	patientID, err = ds.FindElementByTag(dicomtag.PatientID)
+
+	ds2, err := dicom.ReadDataSet(&buf, dicom.ReadOptions{})
+	if err != nil {
+		panic(err)
+	}
+	patientID, err = ds2.FindElementByTag(dicomtag.PatientID)
 	if err != nil {
 		panic(err)
 	}
@@ -75,15 +81,17 @@ func Example_updateExistingFile() {
 
 // Test ReadOptions
 func TestReadOptions(t *testing.T) {
+	file := dicomtest.MustBytes(dicomtest.Spec{})
+
 	// Test Drop Pixel Data
-	data := mustReadFile("examples/IM-0001-0001.dcm", dicom.ReadOptions{DropPixelData: true})
+	data := mustReadBytes(file, dicom.ReadOptions{DropPixelData: true})
 	_, err := data.FindElementByTag(dicomtag.PatientName)
 	require.NoError(t, err)
 	_, err = data.FindElementByTag(dicomtag.PixelData)
 	require.Error(t, err)
 
 	// Test Return Tags
-	data = mustReadFile("examples/IM-0001-0001.dcm", dicom.ReadOptions{DropPixelData: true, ReturnTags: []dicomtag.Tag{dicomtag.StudyInstanceUID}})
+	data = mustReadBytes(file, dicom.ReadOptions{DropPixelData: true, ReturnTags: []dicomtag.Tag{dicomtag.StudyInstanceUID}})
 	_, err = data.FindElementByTag(dicomtag.StudyInstanceUID)
 	if err != nil {
 		t.Error(err)
@@ -94,7 +102,7 @@ func TestReadOptions(t *testing.T) {
 	}
 
 	// Test Stop at Tag
-	data = mustReadFile("examples/IM-0001-0001.dcm",
+	data = mustReadBytes(file,
 		dicom.ReadOptions{
 			DropPixelData: true,
 			// Study Instance UID Element tag is Tag{0x0020, 0x000D}
@@ -108,3 +116,229 @@ func TestReadOptions(t *testing.T) {
 		t.Errorf("PatientName should not be present")
 	}
 }
+
+func mustWriteDataSet(ds *dicom.DataSet) []byte {
+	buf := bytes.Buffer{}
+	if err := dicom.WriteDataSet(&buf, ds); err != nil {
+		log.Panic(err)
+	}
+	return buf.Bytes()
+}
+
+func newTestDataSet(transferSyntaxUID string) *dicom.DataSet {
+	return &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, "1.2.840.10008.5.1.4.1.1.7"),
+		dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, "1.2.3.4.5"),
+		dicom.MustNewElement(dicomtag.TransferSyntaxUID, transferSyntaxUID),
+		dicom.MustNewElement(dicomtag.SOPInstanceUID, "1.2.3.4.5"),
+		dicom.MustNewElement(dicomtag.PatientName, "Zhang^San"),
+		dicom.MustNewElement(dicomtag.PatientID, "P0001"),
+		dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3.4"),
+		dicom.MustNewElement(dicomtag.SeriesInstanceUID, "1.2.3.4.1"),
+	}}
+}
+
+// TestConcurrentRead reads and writes files from many goroutines, which
+// share the dictionaries and codecs. Run it with -race.
+func TestConcurrentRead(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Elements = append(ds.Elements, dicom.MustNewElement(dicomtag.SpecificCharacterSet, "ISO_IR 192"))
+	data := mustWriteDataSet(ds)
+	jpegData := dicomtest.MustBytes(dicomtest.Spec{TransferSyntaxUID: dicomtest.JPEGBaseline})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, d := range [][]byte{data, jpegData} {
+				read, err := dicom.ReadDataSetInBytes(d, dicom.ReadOptions{})
+				if err == nil {
+					err = dicom.WriteDataSet(ioutil.Discard, read)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				for _, elem := range read.Elements {
+					dicomtag.DebugString(elem.Tag)
+				}
+			}
+			dicomuid.UIDString(dicomuid.ExplicitVRLittleEndian)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestReadTrailingData(t *testing.T) {
+	for _, junk := range [][]byte{make([]byte, 12), []byte("vendor junk!"), {1, 2, 3}} {
+		data := append(mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian)), junk...)
+		ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{AllowTrailingData: true})
+		require.NoError(t, err)
+		require.Equal(t, junk, ds.TrailingData)
+		elem, err := ds.FindElementByTag(dicomtag.SeriesInstanceUID)
+		require.NoError(t, err)
+		require.Equal(t, "1.2.3.4.1", elem.MustGetString())
+		require.Len(t, ds.Elements, 12)
+	}
+
+	// Out of order and duplicate elements are elements, not trailing data.
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.AccessionNumber, "A1"))
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.PatientName, "Li^Si"))
+	require.NoError(t, e.Error())
+	junk := []byte("vendor junk!")
+	data := append(append(mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian)), e.Bytes()...), junk...)
+	ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{AllowTrailingData: true})
+	require.NoError(t, err)
+	require.Equal(t, junk, ds.TrailingData)
+	elem, err := ds.FindElementByTag(dicomtag.AccessionNumber)
+	require.NoError(t, err)
+	require.Equal(t, "A1", elem.MustGetString())
+	elem, err = ds.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	require.Equal(t, "Zhang^San", elem.MustGetString())
+	require.Len(t, ds.Elements, 13)
+
+	// Tags that are not in the dictionary and OV elements are read, up to the junk.
+	ds = newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	acquisitionUID := dicomtag.Tag{Group: 0x0008, Element: 0x0017}
+	ds.Put(&dicom.Element{Tag: acquisitionUID, VR: "UI", Value: []interface{}{"1.2.3.4.5.7"}})
+	ds.Put(&dicom.Element{Tag: dicomtag.ExtendedOffsetTable, VR: "OV", Value: []interface{}{make([]byte, 8)}})
+	ds.Put(&dicom.Element{Tag: dicomtag.PixelData, VR: "OW",
+		Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{make([]byte, 16)}}}})
+	data = append(mustWriteDataSet(ds), junk...)
+	for _, in := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+		ds, err := dicom.Read(in, dicom.WithAllowTrailingData())
+		require.NoError(t, err)
+		require.Equal(t, junk, ds.TrailingData)
+		for _, tag := range []dicomtag.Tag{acquisitionUID, dicomtag.PatientName, dicomtag.ExtendedOffsetTable, dicomtag.PixelData} {
+			_, err := ds.FindElementByTag(tag)
+			require.NoError(t, err, dicomtag.DebugString(tag))
+		}
+	}
+
+	// Without trailing data, nothing is reported.
+	ds, err = dicom.ReadDataSetInBytes(mustWriteDataSet(newTestDataSet(dicomuid.ImplicitVRLittleEndian)),
+		dicom.ReadOptions{AllowTrailingData: true})
+	require.NoError(t, err)
+	require.Nil(t, ds.TrailingData)
+	require.Len(t, ds.Elements, 12)
+}
+
+func TestReadSkipTags(t *testing.T) {
+	data := mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian))
+	ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{SkipTags: []dicomtag.Tag{dicomtag.PatientName}})
+	require.NoError(t, err)
+	_, err = ds.FindElementByTag(dicomtag.PatientName)
+	require.Error(t, err)
+	_, err = ds.FindElementByTag(dicomtag.PatientID)
+	require.NoError(t, err)
+
+	ds, err = dicom.ReadDataSetInBytes(data, dicom.ReadOptions{ElementFilter: func(tag dicomtag.Tag) bool {
+		return tag.Group == 0x0020
+	}})
+	require.NoError(t, err)
+	_, err = ds.FindElementByTag(dicomtag.PatientID)
+	require.Error(t, err)
+	elem, err := ds.FindElementByTag(dicomtag.SeriesInstanceUID)
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3.4.1", elem.MustGetString())
+}
+
+func TestReadWriteSVUVOL(t *testing.T) {
+	// Values that are empty or contain '\', ' ' or NUL bytes when read as
+	// strings.
+	elems := []*dicom.Element{
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x0010}, VR: "LO", Value: []interface{}{"TEST"}},
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1001}, VR: "SV", Value: []interface{}{int64(0), int64(-1), int64(0x5c20)}},
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1002}, VR: "UV", Value: []interface{}{uint64(0), uint64(0x2020202020205c00)}},
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1003}, VR: "OL", Value: []interface{}{uint32(0), uint32(0x5c5c2020)}},
+	}
+	for _, ts := range []string{dicomuid.ExplicitVRLittleEndian, dicomuid.ExplicitVRBigEndian} {
+		ds := newTestDataSet(ts)
+		ds.Elements = append(ds.Elements, elems...)
+		data := mustWriteDataSet(ds)
+		salvaged, err := dicom.Salvage(bytes.NewReader(data))
+		require.NoError(t, err)
+		for _, read := range []*dicom.DataSet{mustReadBytes(data, dicom.ReadOptions{}), salvaged} {
+			for _, expected := range elems[1:] {
+				elem, err := read.FindElementByTag(expected.Tag)
+				require.NoError(t, err)
+				require.Equal(t, expected.VR, elem.VR)
+				require.Equal(t, expected.Value, elem.Value, ts)
+			}
+		}
+	}
+
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Elements = append(ds.Elements, elems...)
+	data, err := dicom.MarshalJSON(ds)
+	require.NoError(t, err)
+	parsed, err := dicom.UnmarshalJSON(data)
+	require.NoError(t, err)
+	for _, expected := range elems[1:] {
+		elem, err := parsed.FindElementByTag(expected.Tag)
+		require.NoError(t, err)
+		require.Equal(t, expected.Value, elem.Value)
+	}
+}
+
+// panickingCodec is a codec with a bug.
+type panickingCodec struct{}
+
+func (panickingCodec) DecodeFrame(ds *dicom.DataSet, frame []byte) (image.Image, error) {
+	var img *image.Gray
+	return img.SubImage(image.Rect(0, 0, 1, 1)), nil
+}
+
+func TestPanicFree(t *testing.T) {
+	const uid = "1.2.840.10008.1.2.4.202"
+	dicom.RegisterCodec(uid, panickingCodec{})
+	ds := newTestDataSet(uid)
+	ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+		Value: []interface{}{dicom.PixelDataInfo{Offsets: []uint32{0}, Frames: [][]byte{{1, 2}}}}})
+	require.Panics(t, func() { ds.DecodeFrame(0) }) // nolint: errcheck
+
+	dicomio.SetPanicFree(true)
+	defer dicomio.SetPanicFree(false)
+	_, err := ds.DecodeFrame(0)
+	require.Error(t, err)
+
+	// Invalid input is an error in either mode.
+	ds = newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.StudyDescription, VR: "LONG", Value: []interface{}{"x"}})
+	require.Error(t, dicom.WriteDataSet(ioutil.Discard, ds))
+	dicomio.SetPanicFree(false)
+	require.Error(t, dicom.WriteDataSet(ioutil.Discard, ds))
+
+	elem := dicom.MustNewElement(dicomtag.PatientName, "Zhang^San")
+	for i := 0; i < 20; i++ {
+		elem = dicom.MustNewSequence(dicomtag.ReferencedImageSequence, []*dicom.Element{elem})
+	}
+	require.Contains(t, elem.String(), "...")
+}
+
+func TestUnknownTransferSyntax(t *testing.T) {
+	// panic-free mode关着: 不认识的transfer syntax是error, 不是panic
+	require.False(t, dicomio.PanicFree())
+	err := dicom.WriteDataSet(ioutil.Discard, newTestDataSet("1.2.3.4"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown transfer syntax")
+
+	// 被破坏的TransferSyntaxUID, 长度不变
+	data := mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian))
+	i := bytes.Index(data, []byte(dicomuid.ExplicitVRLittleEndian))
+	require.True(t, i > 0)
+	copy(data[i:], "1.2.3.4.5.6.7.8.9.0")
+	_, err = dicom.ReadDataSetInBytes(data, dicom.ReadOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown transfer syntax")
+	_, err = dicom.Read(bytes.NewReader(data))
+	require.Error(t, err)
+}
@@ -0,0 +1,371 @@
+package dicomdir
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// File is a file of a File-set, referenced by a record of the DICOMDIR.
+type File struct {
+	// ID 是ReferencedFileID的components, 相对于DICOMDIR所在的目录,
+	// 例如["DICOM", "IM000001"]. 见ValidateFileID
+	ID []string
+
+	// DataSet 是文件的data set. Build只用到meta elements和records的keys,
+	// 所以读的时候可以去掉PixelData
+	DataSet *dicom.DataSet
+}
+
+// ValidateFileID checks that "id" is a valid File ID (P3.10 8.5, 8.2): 1 to
+// 8 components of 1 to 8 characters among "A"-"Z", "0"-"9" and "_".
+func ValidateFileID(id []string) error {
+	if len(id) == 0 || len(id) > 8 {
+		return fmt.Errorf("file ID %v must have 1 to 8 components", id)
+	}
+	for _, c := range id {
+		if len(c) == 0 || len(c) > 8 {
+			return fmt.Errorf("file ID %v: component %q must have 1 to 8 characters", id, c)
+		}
+		for _, r := range c {
+			if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+				return fmt.Errorf("file ID %v: invalid character %q in %q", id, r, c)
+			}
+		}
+	}
+	return nil
+}
+
+// recordTypes 是不是IMAGE的SOP classes的DirectoryRecordType (P3.3 F.5). 其他的SOP classes用IMAGE
+var recordTypes = map[string]string{
+	dicomuid.BasicTextSRStorage:                        "SR DOCUMENT",
+	dicomuid.EnhancedSRStorage:                         "SR DOCUMENT",
+	dicomuid.ComprehensiveSRStorage:                    "SR DOCUMENT",
+	dicomuid.KeyObjectSelectionDocumentStorage:         "KEY OBJECT DOC",
+	dicomuid.GrayscaleSoftcopyPresentationStateStorage: "PRESENTATION",
+	dicomuid.EncapsulatedPDFStorage:                    "ENCAP DOC",
+	dicomuid.RTDoseStorage:                             "RT DOSE",
+	dicomuid.RTStructureSetStorage:                     "RT STRUCTURE SET",
+	dicomuid.RTPlanStorage:                             "RT PLAN",
+}
+
+// 每一层records从文件中复制的keys (P3.3 F.5). 文件中没有的keys写成空的element
+var (
+	patientKeys = []dicomtag.Tag{dicomtag.PatientName, dicomtag.PatientID}
+	studyKeys   = []dicomtag.Tag{dicomtag.StudyDate, dicomtag.StudyTime, dicomtag.AccessionNumber,
+		dicomtag.StudyDescription, dicomtag.StudyInstanceUID, dicomtag.StudyID}
+	seriesKeys   = []dicomtag.Tag{dicomtag.Modality, dicomtag.SeriesInstanceUID, dicomtag.SeriesNumber}
+	instanceKeys = []dicomtag.Tag{dicomtag.InstanceNumber}
+)
+
+// entity 是Build中的一个patient, study, series或instance. children按第一次出现的顺序
+type entity struct {
+	elems    []*dicom.Element
+	children []*entity
+	index    map[string]*entity
+}
+
+// child 返回key对应的child, 没有时用newElems()新建一个
+func (e *entity) child(key string, newElems func() []*dicom.Element) *entity {
+	if c, ok := e.index[key]; ok {
+		return c
+	}
+	if e.index == nil {
+		e.index = map[string]*entity{}
+	}
+	c := &entity{elems: newElems()}
+	e.index[key] = c
+	e.children = append(e.children, c)
+	return c
+}
+
+// Build returns the DICOMDIR data set of a File-set made of "files", with a
+// PATIENT, STUDY and SERIES record for each patient, study and series, and
+// an IMAGE (or SR DOCUMENT, PRESENTATION, ...) record for each file, in the
+// order the files are given. The offsets of the records assume that the
+// data set is written as is by dicom.WriteDataSet, e.g., by WriteFile.
+func Build(fileSetID string, files []File) (*dicom.DataSet, error) {
+	if len(fileSetID) > dicomtag.MaxValueLength("CS") {
+		return nil, fmt.Errorf("dicomdir.Build: file-set ID %q is longer than 16 characters", fileSetID)
+	}
+	root := &entity{}
+	instances := map[string]bool{}
+	for _, f := range files {
+		if err := ValidateFileID(f.ID); err != nil {
+			return nil, fmt.Errorf("dicomdir.Build: %v", err)
+		}
+		uids := map[dicomtag.Tag]string{}
+		for _, tag := range []dicomtag.Tag{dicomtag.StudyInstanceUID, dicomtag.SeriesInstanceUID,
+			dicomtag.SOPInstanceUID, dicomtag.SOPClassUID, dicomtag.TransferSyntaxUID} {
+			uid, err := stringValue(f.DataSet, tag)
+			if err != nil || uid == "" {
+				return nil, fmt.Errorf("dicomdir.Build: %s: missing %s", strings.Join(f.ID, "/"), dicomtag.DebugString(tag))
+			}
+			uids[tag] = uid
+		}
+		if instances[uids[dicomtag.SOPInstanceUID]] {
+			return nil, fmt.Errorf("dicomdir.Build: %s: SOPInstanceUID %s is in more than one file",
+				strings.Join(f.ID, "/"), uids[dicomtag.SOPInstanceUID])
+		}
+		instances[uids[dicomtag.SOPInstanceUID]] = true
+
+		patientID, _ := stringValue(f.DataSet, dicomtag.PatientID)
+		patient := root.child(patientID, func() []*dicom.Element {
+			return recordElements(f.DataSet, "PATIENT", patientKeys)
+		})
+		study := patient.child(uids[dicomtag.StudyInstanceUID], func() []*dicom.Element {
+			return recordElements(f.DataSet, "STUDY", studyKeys)
+		})
+		series := study.child(uids[dicomtag.SeriesInstanceUID], func() []*dicom.Element {
+			return recordElements(f.DataSet, "SERIES", seriesKeys)
+		})
+		recordType, ok := recordTypes[uids[dicomtag.SOPClassUID]]
+		if !ok {
+			recordType = "IMAGE"
+		}
+		elems := recordElements(f.DataSet, recordType, instanceKeys)
+		elems = append(elems,
+			dicom.MustNewElement(dicomtag.ReferencedFileID, stringValues(f.ID)...),
+			dicom.MustNewElement(dicomtag.ReferencedSOPClassUIDInFile, uids[dicomtag.SOPClassUID]),
+			dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUIDInFile, uids[dicomtag.SOPInstanceUID]),
+			dicom.MustNewElement(dicomtag.ReferencedTransferSyntaxUIDInFile, uids[dicomtag.TransferSyntaxUID]))
+		series.children = append(series.children, &entity{elems: elems})
+	}
+
+	// records按深度优先的顺序排在DirectoryRecordSequence中
+	var records []flatRecord
+	first, last := flatten(root.children, &records)
+	offsets := make([]uint32, len(records))
+	sopInstanceUID := dicomuid.New()
+	newDataSet := func(withSequence bool) *dicom.DataSet {
+		at := func(i int) uint32 {
+			if i < 0 {
+				return 0
+			}
+			return offsets[i]
+		}
+		ds := &dicom.DataSet{Elements: []*dicom.Element{
+			dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, dicomuid.MediaStorageDirectoryStorage),
+			dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, sopInstanceUID),
+			dicom.MustNewElement(dicomtag.TransferSyntaxUID, dicomuid.ExplicitVRLittleEndian),
+			dicom.MustNewElement(dicomtag.FileSetID, fileSetID),
+			dicom.MustNewElement(dicomtag.OffsetOfTheFirstDirectoryRecordOfTheRootDirectoryEntity, at(first)),
+			dicom.MustNewElement(dicomtag.OffsetOfTheLastDirectoryRecordOfTheRootDirectoryEntity, at(last)),
+			dicom.MustNewElement(dicomtag.FileSetConsistencyFlag, uint16(0)),
+		}}
+		if withSequence {
+			items := make([][]*dicom.Element, len(records))
+			for i, r := range records {
+				items[i] = append([]*dicom.Element{
+					dicom.MustNewElement(dicomtag.OffsetOfTheNextDirectoryRecord, at(r.next)),
+					dicom.MustNewElement(dicomtag.RecordInUseFlag, uint16(0xffff)),
+					dicom.MustNewElement(dicomtag.OffsetOfReferencedLowerLevelDirectoryEntity, at(r.lower)),
+				}, r.elems...)
+				sort.SliceStable(items[i], func(a, b int) bool { return items[i][a].Tag.Compare(items[i][b].Tag) < 0 })
+			}
+			ds.Elements = append(ds.Elements, dicom.MustNewSequence(dicomtag.DirectoryRecordSequence, items...))
+		}
+		return ds
+	}
+
+	// 第一个item在DirectoryRecordSequence的header之后, offsets的长度是固定的, 所以先用0算出item的长度
+	var buf bytes.Buffer
+	if err := dicom.WriteDataSet(&buf, newDataSet(false)); err != nil {
+		return nil, fmt.Errorf("dicomdir.Build: %v", err)
+	}
+	pos := uint32(buf.Len()) + 12 // tag, VR, reserved, length
+	for i, item := range newDataSet(true).Elements[7].Value {
+		offsets[i] = pos
+		e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+		dicom.WriteElement(e, item.(*dicom.Element))
+		if err := e.Error(); err != nil {
+			return nil, fmt.Errorf("dicomdir.Build: %v", err)
+		}
+		pos += uint32(len(e.Bytes()))
+	}
+	return newDataSet(true), nil
+}
+
+// flatRecord 是Build排好的一个record. next和lower是被链接的record的index, -1表示没有
+type flatRecord struct {
+	elems       []*dicom.Element
+	next, lower int
+}
+
+// flatten 把entities和它们的children按深度优先的顺序加进records, 返回第一个和最后一个entity的index
+func flatten(entities []*entity, records *[]flatRecord) (first, last int) {
+	first, last = -1, -1
+	for _, e := range entities {
+		i := len(*records)
+		*records = append(*records, flatRecord{elems: e.elems, next: -1})
+		if last >= 0 {
+			(*records)[last].next = i
+		}
+		lower, _ := flatten(e.children, records)
+		(*records)[i].lower = lower
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	return first, last
+}
+
+// recordElements 返回一个record的DirectoryRecordType和从ds复制的keys
+func recordElements(ds *dicom.DataSet, recordType string, keys []dicomtag.Tag) []*dicom.Element {
+	elems := []*dicom.Element{dicom.MustNewElement(dicomtag.DirectoryRecordType, recordType)}
+	// 每个record有自己的SpecificCharacterSet, 和它的string values一起
+	if elem, err := ds.FindElementByTag(dicomtag.SpecificCharacterSet); err == nil {
+		elems = append(elems, elem)
+	}
+	for _, tag := range keys {
+		elem, err := ds.FindElementByTag(tag)
+		if err != nil {
+			elem = dicom.MustNewElement(tag)
+		}
+		elems = append(elems, elem)
+	}
+	return elems
+}
+
+func stringValue(ds *dicom.DataSet, tag dicomtag.Tag) (string, error) {
+	elem, err := ds.FindElementByTag(tag)
+	if err != nil {
+		return "", err
+	}
+	s, err := elem.GetString()
+	return strings.TrimSpace(s), err
+}
+
+func stringValues(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+// WriteFile writes the DICOMDIR of "files", see Build, to "path".
+func WriteFile(path, fileSetID string, files []File) error {
+	ds, err := Build(fileSetID, files)
+	if err != nil {
+		return err
+	}
+	return dicom.WriteDataSetToFile(path, ds)
+}
+
+// exportFileID 返回Export的第i个文件的File ID
+func exportFileID(i int) ([]string, error) {
+	if i >= 999999 {
+		return nil, fmt.Errorf("more than 999999 files")
+	}
+	return []string{"DICOM", fmt.Sprintf("IM%06d", i+1)}, nil
+}
+
+// Export writes "datasets" as the File-set "fileSetID" in "dir", e.g., the
+// root of a CD or USB drive: the files as DICOM/IM000001, DICOM/IM000002,
+// ..., and the DICOMDIR referencing them.
+func Export(dir, fileSetID string, datasets []*dicom.DataSet) error {
+	if err := os.MkdirAll(filepath.Join(dir, "DICOM"), 0755); err != nil {
+		return err
+	}
+	var files []File
+	for i, ds := range datasets {
+		id, err := exportFileID(i)
+		if err != nil {
+			return fmt.Errorf("dicomdir.Export: %v", err)
+		}
+		if err := dicom.WriteDataSetToFile(filepath.Join(append([]string{dir}, id...)...), ds); err != nil {
+			return err
+		}
+		files = append(files, File{ID: id, DataSet: ds})
+	}
+	return WriteFile(filepath.Join(dir, "DICOMDIR"), fileSetID, files)
+}
+
+// Create is Export for the DICOM files under "srcDir", e.g., a directory of
+// .dcm files whose names aren't valid File IDs. The files are copied as
+// they are; other files, and DICOMDIRs, are skipped.
+func Create(srcDir, dir, fileSetID string) error {
+	var paths []string
+	if err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "DICOM"), 0755); err != nil {
+		return err
+	}
+	var files []File
+	for _, path := range paths {
+		if ok, err := isDICOM(path); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		ds, err := dicom.ReadDataSetFromFile(path, dicom.ReadOptions{DropPixelData: true})
+		if err != nil {
+			return fmt.Errorf("dicomdir.Create: %v", err)
+		}
+		if uid, _ := stringValue(ds, dicomtag.MediaStorageSOPClassUID); uid == dicomuid.MediaStorageDirectoryStorage {
+			continue
+		}
+		id, err := exportFileID(len(files))
+		if err != nil {
+			return fmt.Errorf("dicomdir.Create: %v", err)
+		}
+		if err := copyFile(path, filepath.Join(append([]string{dir}, id...)...)); err != nil {
+			return err
+		}
+		files = append(files, File{ID: id, DataSet: ds})
+	}
+	return WriteFile(filepath.Join(dir, "DICOMDIR"), fileSetID, files)
+}
+
+// isDICOM 检查文件有没有128 bytes的preamble和"DICM"
+func isDICOM(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, 132)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false, nil
+	}
+	return string(header[128:]) == "DICM", nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,301 @@
+// Package dicomdir reads the DICOMDIR of a File-set (P3.10 8, P3.3 F): the
+// directory records of the Basic Directory IOD, linked by byte offsets into
+// a hierarchy of PATIENT, STUDY, SERIES and IMAGE (or other) records. Build,
+// Export and Create write the DICOMDIR of new File-sets, e.g., for CD or USB
+// media.
+package dicomdir
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Record is a directory record, an item of DirectoryRecordSequence.
+type Record struct {
+	// Type 是DirectoryRecordType, 例如"PATIENT", "STUDY", "SERIES", "IMAGE"
+	Type string
+
+	// Offset 是record在DICOMDIR中的位置(bytes, 从文件开头即preamble算起),
+	// 其他record用它引用这个record
+	Offset int64
+
+	// Elements are the attributes of the record, including the offsets and
+	// the keys of its type, e.g., PatientID for PATIENT.
+	Elements []*dicom.Element
+
+	// Children 是lower-level directory entity的records, 按链接的顺序
+	Children []*Record
+}
+
+// String returns the value of "tag" in the record, without padding, or ""
+// if the record doesn't have it.
+func (r *Record) String(tag dicomtag.Tag) string {
+	elem, err := dicom.FindElementByTag(r.Elements, tag)
+	if err != nil {
+		return ""
+	}
+	s, err := elem.GetStrings()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Join(s, "\\"))
+}
+
+// FileID returns the components of ReferencedFileID (0004,1500), e.g.,
+// ["DICOM", "ST000001", "IM000001"], or nil if the record doesn't
+// reference a file.
+func (r *Record) FileID() []string {
+	elem, err := dicom.FindElementByTag(r.Elements, dicomtag.ReferencedFileID)
+	if err != nil {
+		return nil
+	}
+	components, err := elem.GetStrings()
+	if err != nil {
+		return nil
+	}
+	var result []string
+	for _, c := range components {
+		if c = strings.TrimSpace(c); c != "" {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Directory is a parsed DICOMDIR.
+type Directory struct {
+	// DataSet 是DICOMDIR的data set, DirectoryRecordSequence也在里面
+	DataSet *dicom.DataSet
+
+	// Roots 是root directory entity的records, 通常是PATIENT records
+	Roots []*Record
+
+	// dir 是DICOMDIR所在的目录, ReadFile设置
+	dir string
+}
+
+// FilePath returns the path of the file referenced by "r", relative to the
+// directory of the DICOMDIR, or joined to it if the DICOMDIR was read with
+// ReadFile. It returns "" if "r" doesn't reference a file.
+func (d *Directory) FilePath(r *Record) string {
+	id := r.FileID()
+	if id == nil {
+		return ""
+	}
+	return filepath.Join(append([]string{d.dir}, id...)...)
+}
+
+// Walk calls fn for each record, parents before children, with the path
+// of records from a root to it (path[len(path)-1] is the record). It stops
+// at the first error, which it returns.
+func (d *Directory) Walk(fn func(path []*Record) error) error {
+	var walk func(path []*Record, records []*Record) error
+	walk = func(path []*Record, records []*Record) error {
+		for _, r := range records {
+			p := append(path[:len(path):len(path)], r)
+			if err := fn(p); err != nil {
+				return err
+			}
+			if err := walk(p, r.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(nil, d.Roots)
+}
+
+// ReadFile reads the DICOMDIR at "path"; FilePath then returns paths
+// joined to its directory.
+func ReadFile(path string) (*Directory, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("dicomdir.ReadFile: %s: %v", path, err)
+	}
+	d.dir = filepath.Dir(path)
+	return d, nil
+}
+
+// Read reads a DICOMDIR from "in", which is held in memory; DICOMDIRs are
+// small compared to the files they reference.
+func Read(in io.Reader) (*Directory, error) {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("dicomdir.Read: %v", err)
+	}
+	return d, nil
+}
+
+// parse 读取DICOMDIR并链接records. DirectoryRecordSequence的items自己读, 以知道每个item的offset
+func parse(data []byte) (d *Directory, err error) {
+	defer dicomio.Recover(&err)
+	dec := dicomio.NewBytesDecoder(data, binary.LittleEndian, dicomio.ExplicitVR)
+	ds := &dicom.DataSet{Elements: dicom.ParseFileHeader(dec)}
+	if dec.Error() != nil {
+		return nil, dec.Error()
+	}
+	elem, err := ds.FindElementByTag(dicomtag.TransferSyntaxUID)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := elem.GetString()
+	if err != nil {
+		return nil, err
+	}
+	dec.PushTransferSyntaxByUID(uid)
+	byteOrder, implicit := dec.TransferSyntax()
+
+	records := map[int64]*Record{}
+	for !dec.EOF() {
+		header := dec.Peek(4)
+		if len(header) == 4 && (dicomtag.Tag{Group: byteOrder.Uint16(header[0:]), Element: byteOrder.Uint16(header[2:])}) == dicomtag.DirectoryRecordSequence {
+			seq, err := readRecords(dec, implicit, records)
+			if err != nil {
+				return nil, err
+			}
+			ds.Elements = append(ds.Elements, seq)
+			continue
+		}
+		elem := dicom.ReadElement(dec, dicom.ReadOptions{})
+		if dec.Error() != nil {
+			break
+		}
+		if elem.Tag == dicomtag.SpecificCharacterSet {
+			names, err := elem.GetStrings()
+			if err != nil {
+				return nil, err
+			}
+			cs, err := dicomio.ParseSpecificCharacterSet(names)
+			if err != nil {
+				return nil, err
+			}
+			dec.SetCodingSystem(cs)
+		}
+		ds.Elements = append(ds.Elements, elem)
+	}
+	if err := dec.Error(); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	d = &Directory{DataSet: ds}
+	first, err := offset(ds.Elements, dicomtag.OffsetOfTheFirstDirectoryRecordOfTheRootDirectoryEntity)
+	if err != nil {
+		return nil, err
+	}
+	visited := map[int64]bool{}
+	if d.Roots, err = linkRecords(first, records, visited); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// readRecords 读取DirectoryRecordSequence, 把每个item按它的offset放进records
+func readRecords(d *dicomio.Decoder, implicit dicomio.IsImplicitVR, records map[int64]*Record) (*dicom.Element, error) {
+	d.ReadUInt16() // group
+	d.ReadUInt16() // element
+	if implicit == dicomio.ExplicitVR {
+		if vr := d.ReadString(2); vr != "SQ" {
+			return nil, fmt.Errorf("DirectoryRecordSequence has VR %s", vr)
+		}
+		d.Skip(2)
+	}
+	vl := d.ReadUInt32()
+	seq := &dicom.Element{Tag: dicomtag.DirectoryRecordSequence, VR: "SQ", UndefinedLength: vl == dicom.UndefinedLength}
+	if vl != dicom.UndefinedLength {
+		d.PushLimit(int64(vl))
+		defer d.PopLimit()
+	}
+	for !d.EOF() {
+		offset := d.BytesRead()
+		item := dicom.ReadElement(d, dicom.ReadOptions{})
+		if d.Error() != nil {
+			return nil, d.Error()
+		}
+		if item.Tag == dicomtag.SequenceDelimitationItem {
+			break
+		}
+		if item.Tag != dicomtag.Item {
+			return nil, fmt.Errorf("found %s in DirectoryRecordSequence", dicomtag.DebugString(item.Tag))
+		}
+		seq.Value = append(seq.Value, item)
+		record := &Record{Offset: offset}
+		for _, v := range item.Value {
+			if elem, ok := v.(*dicom.Element); ok {
+				record.Elements = append(record.Elements, elem)
+			}
+		}
+		record.Type = record.String(dicomtag.DirectoryRecordType)
+		records[offset] = record
+	}
+	return seq, nil
+}
+
+// offset 返回elems中的offset element, 没有时返回0
+func offset(elems []*dicom.Element, tag dicomtag.Tag) (int64, error) {
+	elem, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return 0, nil
+	}
+	v, err := elem.GetUInt32()
+	if err != nil {
+		return 0, err
+	}
+	return int64(v), nil
+}
+
+// linkRecords 返回从first开始, 通过OffsetOfTheNextDirectoryRecord链接的records, 并递归链接它们的children.
+// 不在使用中的records (RecordInUseFlag为0) 被跳过
+func linkRecords(first int64, records map[int64]*Record, visited map[int64]bool) ([]*Record, error) {
+	var result []*Record
+	for next := first; next != 0; {
+		r, ok := records[next]
+		if !ok {
+			return nil, fmt.Errorf("no directory record at offset %d", next)
+		}
+		if visited[next] {
+			return nil, fmt.Errorf("directory record at offset %d is linked twice", next)
+		}
+		visited[next] = true
+		var err error
+		if next, err = offset(r.Elements, dicomtag.OffsetOfTheNextDirectoryRecord); err != nil {
+			return nil, err
+		}
+		if inUse(r) {
+			lower, err := offset(r.Elements, dicomtag.OffsetOfReferencedLowerLevelDirectoryEntity)
+			if err != nil {
+				return nil, err
+			}
+			if r.Children, err = linkRecords(lower, records, visited); err != nil {
+				return nil, fmt.Errorf("%s record at offset %d: %v", r.Type, r.Offset, err)
+			}
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// inUse 检查RecordInUseFlag (retired), 0x0000表示record不在使用中
+func inUse(r *Record) bool {
+	elem, err := dicom.FindElementByTag(r.Elements, dicomtag.RecordInUseFlag)
+	if err != nil {
+		return true
+	}
+	v, err := elem.GetUInt16()
+	return err != nil || v != 0
+}
@@ -0,0 +1,231 @@
+package dicomdir_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomdir"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRecord 是测试用的directory record. next和lower是被链接的record的index, -1表示没有
+type testRecord struct {
+	elems       []*dicom.Element
+	next, lower int
+	inactive    bool
+}
+
+// writeDICOMDIR 按P3.10写一个DICOMDIR: 先算出每个item的offset, 再填进offset elements
+func writeDICOMDIR(t *testing.T, root int, records []testRecord) []byte {
+	offsetElem := func(tag dicomtag.Tag, v uint32) *dicom.Element {
+		return dicom.MustNewElement(tag, v)
+	}
+	newDataSet := func(offsets []uint32, withSequence bool) *dicom.DataSet {
+		at := func(i int) uint32 {
+			if i < 0 {
+				return 0
+			}
+			return offsets[i]
+		}
+		ds := &dicom.DataSet{Elements: []*dicom.Element{
+			dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, dicomuid.MediaStorageDirectoryStorage),
+			dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, "1.2.3.100"),
+			dicom.MustNewElement(dicomtag.TransferSyntaxUID, dicomuid.ExplicitVRLittleEndian),
+			dicom.MustNewElement(dicomtag.FileSetID, "TEST"),
+			offsetElem(dicomtag.OffsetOfTheFirstDirectoryRecordOfTheRootDirectoryEntity, at(root)),
+			offsetElem(dicomtag.OffsetOfTheLastDirectoryRecordOfTheRootDirectoryEntity, 0),
+			dicom.MustNewElement(dicomtag.FileSetConsistencyFlag, uint16(0)),
+		}}
+		if withSequence {
+			var items [][]*dicom.Element
+			for _, r := range records {
+				inUse := uint16(0xffff)
+				if r.inactive {
+					inUse = 0
+				}
+				items = append(items, append([]*dicom.Element{
+					offsetElem(dicomtag.OffsetOfTheNextDirectoryRecord, at(r.next)),
+					dicom.MustNewElement(dicomtag.RecordInUseFlag, inUse),
+					offsetElem(dicomtag.OffsetOfReferencedLowerLevelDirectoryEntity, at(r.lower)),
+				}, r.elems...))
+			}
+			ds.Elements = append(ds.Elements, dicom.MustNewSequence(dicomtag.DirectoryRecordSequence, items...))
+		}
+		return ds
+	}
+	write := func(ds *dicom.DataSet) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, dicom.WriteDataSet(&buf, ds))
+		return buf.Bytes()
+	}
+
+	offsets := make([]uint32, len(records))
+	seq := newDataSet(offsets, true).Elements[7]
+	pos := uint32(len(write(newDataSet(offsets, false)))) + 12 // SQ header
+	for i, item := range seq.Value {
+		offsets[i] = pos
+		e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+		dicom.WriteElement(e, item.(*dicom.Element))
+		require.NoError(t, e.Error())
+		pos += uint32(len(e.Bytes()))
+	}
+	return write(newDataSet(offsets, true))
+}
+
+func TestRead(t *testing.T) {
+	typ := func(s string) *dicom.Element { return dicom.MustNewElement(dicomtag.DirectoryRecordType, s) }
+	records := []testRecord{
+		// 0: 第二个病人, 在文件中排在前面
+		{elems: []*dicom.Element{typ("PATIENT"), dicom.MustNewElement(dicomtag.PatientID, "P2")}, next: -1, lower: -1},
+		// 1
+		{elems: []*dicom.Element{typ("PATIENT"), dicom.MustNewElement(dicomtag.PatientID, "P1")}, next: 0, lower: 2},
+		// 2
+		{elems: []*dicom.Element{typ("STUDY"), dicom.MustNewElement(dicomtag.StudyInstanceUID, "1.2.3")}, next: -1, lower: 3},
+		// 3
+		{elems: []*dicom.Element{typ("SERIES"), dicom.MustNewElement(dicomtag.SeriesInstanceUID, "1.2.3.4")}, next: -1, lower: 4},
+		// 4
+		{elems: []*dicom.Element{typ("IMAGE"), dicom.MustNewElement(dicomtag.ReferencedFileID, "DICOM", "IM000001")}, next: 5, lower: -1},
+		// 5: 不在使用中
+		{elems: []*dicom.Element{typ("IMAGE"), dicom.MustNewElement(dicomtag.ReferencedFileID, "DICOM", "IM000002")}, next: 6, lower: -1, inactive: true},
+		// 6
+		{elems: []*dicom.Element{typ("IMAGE"), dicom.MustNewElement(dicomtag.ReferencedFileID, "DICOM", "IM000003")}, next: -1, lower: -1},
+	}
+	data := writeDICOMDIR(t, 1, records)
+
+	d, err := dicomdir.Read(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, d.Roots, 2)
+	assert.Equal(t, "P1", d.Roots[0].String(dicomtag.PatientID))
+	assert.Equal(t, "P2", d.Roots[1].String(dicomtag.PatientID))
+	assert.Empty(t, d.Roots[1].Children)
+
+	var types, paths []string
+	require.NoError(t, d.Walk(func(path []*dicomdir.Record) error {
+		r := path[len(path)-1]
+		types = append(types, r.Type)
+		if p := d.FilePath(r); p != "" {
+			assert.Len(t, path, 4)
+			assert.Equal(t, "1.2.3", path[1].String(dicomtag.StudyInstanceUID))
+			paths = append(paths, p)
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{"PATIENT", "STUDY", "SERIES", "IMAGE", "IMAGE", "PATIENT"}, types)
+	assert.Equal(t, []string{filepath.Join("DICOM", "IM000001"), filepath.Join("DICOM", "IM000003")}, paths)
+	_, err = d.DataSet.FindElementByTag(dicomtag.FileSetID)
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "dicomdir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "DICOMDIR"), data, 0644))
+	d, err = dicomdir.ReadFile(filepath.Join(dir, "DICOMDIR"))
+	require.NoError(t, err)
+	image := d.Roots[0].Children[0].Children[0].Children[0]
+	assert.Equal(t, filepath.Join(dir, "DICOM", "IM000001"), d.FilePath(image))
+	assert.Equal(t, []string{"DICOM", "IM000001"}, image.FileID())
+
+	// 循环是错误
+	records[6].next = 1
+	_, err = dicomdir.Read(bytes.NewReader(writeDICOMDIR(t, 1, records)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "linked twice")
+}
+
+// newDataSets 返回两个病人的三个instances, 病人P1有一个study和两个series
+func newDataSets(t *testing.T) []*dicom.DataSet {
+	var result []*dicom.DataSet
+	for i, uids := range [][4]string{
+		{"P1", "1.2.1", "1.2.1.1", "1.2.1.1.1"},
+		{"P2", "1.2.2", "1.2.2.1", "1.2.2.1.1"},
+		{"P1", "1.2.1", "1.2.1.2", "1.2.1.2.1"},
+	} {
+		ds, err := dicomtest.NewDataSet(dicomtest.Spec{PatientName: "病人", SpecificCharacterSet: "ISO_IR 192"})
+		require.NoError(t, err)
+		require.NoError(t, ds.PutString(dicomtag.PatientID, uids[0]))
+		require.NoError(t, ds.PutString(dicomtag.StudyInstanceUID, uids[1]))
+		require.NoError(t, ds.PutString(dicomtag.SeriesInstanceUID, uids[2]))
+		require.NoError(t, ds.PutString(dicomtag.SOPInstanceUID, uids[3]))
+		require.NoError(t, ds.PutString(dicomtag.MediaStorageSOPInstanceUID, uids[3]))
+		require.NoError(t, ds.PutString(dicomtag.InstanceNumber, fmt.Sprint(i+1)))
+		result = append(result, ds)
+	}
+	return result
+}
+
+// checkFileSet 检查newDataSets的File-set
+func checkFileSet(t *testing.T, dir string) {
+	d, err := dicomdir.ReadFile(filepath.Join(dir, "DICOMDIR"))
+	require.NoError(t, err)
+	require.Len(t, d.Roots, 2)
+	p1 := d.Roots[0]
+	assert.Equal(t, "P1", p1.String(dicomtag.PatientID))
+	assert.Equal(t, "病人", p1.String(dicomtag.PatientName))
+	require.Len(t, p1.Children, 1)
+	require.Len(t, p1.Children[0].Children, 2)
+	assert.Equal(t, "1.2.1.2", p1.Children[0].Children[1].String(dicomtag.SeriesInstanceUID))
+
+	var instances []string
+	require.NoError(t, d.Walk(func(path []*dicomdir.Record) error {
+		r := path[len(path)-1]
+		if r.Type != "IMAGE" {
+			return nil
+		}
+		ds, err := dicom.ReadDataSetFromFile(d.FilePath(r), dicom.ReadOptions{DropPixelData: true})
+		require.NoError(t, err)
+		uid, err := ds.FindElementByTag(dicomtag.SOPInstanceUID)
+		require.NoError(t, err)
+		assert.Equal(t, r.String(dicomtag.ReferencedSOPInstanceUIDInFile), uid.MustGetString())
+		instances = append(instances, uid.MustGetString())
+		return nil
+	}))
+	assert.Equal(t, []string{"1.2.1.1.1", "1.2.1.2.1", "1.2.2.1.1"}, instances)
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dicomdir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, dicomdir.Export(dir, "EXPORT", newDataSets(t)))
+	checkFileSet(t, dir)
+	_, err = os.Stat(filepath.Join(dir, "DICOM", "IM000003"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, dicomdir.ValidateFileID([]string{"DICOM", "IM_00001"}))
+	for _, id := range [][]string{nil, {"dicom"}, {"IM1.DCM"}, {"TOOLONGNAME"}, {""}} {
+		assert.Error(t, dicomdir.ValidateFileID(id), "%v", id)
+	}
+	_, err = dicomdir.Build("", []dicomdir.File{{ID: []string{"A"}, DataSet: &dicom.DataSet{}}})
+	assert.Error(t, err)
+}
+
+func TestCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dicomdir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src, out := filepath.Join(dir, "src"), filepath.Join(dir, "out")
+	for i, ds := range newDataSets(t) {
+		path := filepath.Join(src, fmt.Sprintf("s%d", i), "image.dcm")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, dicom.WriteDataSetToFile(path, ds))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "README.txt"), []byte("not dicom"), 0644))
+
+	require.NoError(t, dicomdir.Create(src, out, "CREATE"))
+	checkFileSet(t, out)
+
+	// 已有的DICOMDIR不算一个文件
+	require.NoError(t, dicomdir.Create(out, filepath.Join(dir, "again"), "CREATE"))
+	checkFileSet(t, filepath.Join(dir, "again"))
+}
@@ -0,0 +1,414 @@
+// Package dicomimage contains helpers for images derived from DICOM pixel
+// data, e.g., secondary captures exported for referring physicians.
+package dicomimage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// AnnotationType is the kind of an Annotation. Except for AnnotationText,
+// the types match GraphicType (0070,0023) of a presentation state. P3.3
+// C.10.5.1.2.
+type AnnotationType int
+
+const (
+	// AnnotationText draws Text with its top-left corner at Points[0].
+	AnnotationText AnnotationType = iota
+	// AnnotationPoint draws a small cross at each point.
+	AnnotationPoint
+	// AnnotationPolyline connects the points. It is closed (and can be
+	// filled) if the first and last points are the same.
+	AnnotationPolyline
+	// AnnotationCircle is centered at Points[0] and goes through Points[1].
+	AnnotationCircle
+	// AnnotationEllipse has its major axis from Points[0] to Points[1] and
+	// its minor axis from Points[2] to Points[3].
+	AnnotationEllipse
+)
+
+func (t AnnotationType) String() string {
+	switch t {
+	case AnnotationText:
+		return "TEXT"
+	case AnnotationPoint:
+		return "POINT"
+	case AnnotationPolyline:
+		return "POLYLINE"
+	case AnnotationCircle:
+		return "CIRCLE"
+	case AnnotationEllipse:
+		return "ELLIPSE"
+	}
+	return fmt.Sprintf("AnnotationType(%d)", int(t))
+}
+
+// Point is a position on the image. With pixel units, (0,0) is the top-left
+// corner of the top-left pixel and X is the column.
+type Point struct {
+	X, Y float64
+}
+
+// Annotation is one text or graphic object to burn into an image.
+type Annotation struct {
+	Type AnnotationType
+
+	// Points are in pixel units, or, if Display is true, in fractions of the
+	// image width and height (GSPS "DISPLAY" units).
+	Points  []Point
+	Display bool
+
+	// Text is drawn for AnnotationText; lines are separated by '\n'. Only
+	// printable ASCII is supported, other characters are drawn as '?'.
+	Text string
+
+	// Filled fills closed polylines, circles and ellipses.
+	Filled bool
+
+	// Color defaults to white.
+	Color color.Color
+
+	// Scale magnifies text; the font is 5x7 pixels at scale 1. Defaults to 1.
+	Scale int
+}
+
+// BurnAnnotations draws "items" onto "frame". Graphics outside of the frame
+// are clipped. It returns an error, without drawing anything, if an item
+// doesn't have the points its type requires.
+func BurnAnnotations(frame draw.Image, items []Annotation) error {
+	for i, item := range items {
+		if err := checkAnnotation(item); err != nil {
+			return fmt.Errorf("dicomimage.BurnAnnotations: item %d: %v", i, err)
+		}
+	}
+	for _, item := range items {
+		burnAnnotation(frame, item)
+	}
+	return nil
+}
+
+func checkAnnotation(item Annotation) error {
+	required := map[AnnotationType]int{
+		AnnotationText:     1,
+		AnnotationPoint:    1,
+		AnnotationPolyline: 2,
+		AnnotationCircle:   2,
+		AnnotationEllipse:  4,
+	}
+	n, ok := required[item.Type]
+	if !ok {
+		return fmt.Errorf("unknown annotation type %v", item.Type)
+	}
+	if len(item.Points) < n {
+		return fmt.Errorf("%v requires %d points, but found %d", item.Type, n, len(item.Points))
+	}
+	return nil
+}
+
+func burnAnnotation(frame draw.Image, item Annotation) {
+	c := item.Color
+	if c == nil {
+		c = color.White
+	}
+	bounds := frame.Bounds()
+	points := make([]Point, len(item.Points))
+	for i, p := range item.Points {
+		if item.Display {
+			p = Point{p.X * float64(bounds.Dx()), p.Y * float64(bounds.Dy())}
+		}
+		points[i] = Point{p.X + float64(bounds.Min.X), p.Y + float64(bounds.Min.Y)}
+	}
+
+	switch item.Type {
+	case AnnotationText:
+		scale := item.Scale
+		if scale < 1 {
+			scale = 1
+		}
+		drawText(frame, toPixel(points[0].X), toPixel(points[0].Y), item.Text, scale, c)
+	case AnnotationPoint:
+		for _, p := range points {
+			x, y := toPixel(p.X), toPixel(p.Y)
+			drawLine(frame, x-2, y, x+2, y, c)
+			drawLine(frame, x, y-2, x, y+2, c)
+		}
+	case AnnotationPolyline:
+		closed := points[0] == points[len(points)-1]
+		drawShape(frame, points, closed, closed && item.Filled, c)
+	case AnnotationCircle:
+		center := points[0]
+		r := math.Hypot(points[1].X-center.X, points[1].Y-center.Y)
+		drawShape(frame, ellipsePoints(center, r, r, 0), true, item.Filled, c)
+	case AnnotationEllipse:
+		center := Point{(points[0].X + points[1].X) / 2, (points[0].Y + points[1].Y) / 2}
+		a := math.Hypot(points[1].X-points[0].X, points[1].Y-points[0].Y) / 2
+		b := math.Hypot(points[3].X-points[2].X, points[3].Y-points[2].Y) / 2
+		angle := math.Atan2(points[1].Y-points[0].Y, points[1].X-points[0].X)
+		drawShape(frame, ellipsePoints(center, a, b, angle), true, item.Filled, c)
+	}
+}
+
+// ellipsePoints approximates an ellipse by a closed polygon.
+func ellipsePoints(center Point, a, b, angle float64) []Point {
+	const n = 72
+	sin, cos := math.Sincos(angle)
+	points := make([]Point, n+1)
+	for i := 0; i < n; i++ {
+		t := 2 * math.Pi * float64(i) / n
+		x, y := a*math.Cos(t), b*math.Sin(t)
+		points[i] = Point{center.X + x*cos - y*sin, center.Y + x*sin + y*cos}
+	}
+	points[n] = points[0]
+	return points
+}
+
+func drawShape(frame draw.Image, points []Point, closed, filled bool, c color.Color) {
+	if filled {
+		fillPolygon(frame, points, c)
+	}
+	for i := 1; i < len(points); i++ {
+		drawLine(frame, toPixel(points[i-1].X), toPixel(points[i-1].Y), toPixel(points[i].X), toPixel(points[i].Y), c)
+	}
+	if closed && points[0] != points[len(points)-1] {
+		last := points[len(points)-1]
+		drawLine(frame, toPixel(last.X), toPixel(last.Y), toPixel(points[0].X), toPixel(points[0].Y), c)
+	}
+}
+
+// drawLine draws a line using Bresenham's algorithm.
+func drawLine(frame draw.Image, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		setPixel(frame, x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// fillPolygon fills the polygon with the even-odd rule, sampling each row at
+// the pixel centers.
+func fillPolygon(frame draw.Image, points []Point, c color.Color) {
+	bounds := frame.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cy := float64(y) + 0.5
+		var xs []float64
+		for i := range points {
+			p, q := points[i], points[(i+1)%len(points)]
+			if (p.Y <= cy) == (q.Y <= cy) {
+				continue
+			}
+			xs = append(xs, p.X+(cy-p.Y)*(q.X-p.X)/(q.Y-p.Y))
+		}
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			for x := int(math.Ceil(xs[i] - 0.5)); float64(x)+0.5 <= xs[i+1]; x++ {
+				setPixel(frame, x, y, c)
+			}
+		}
+	}
+}
+
+func drawText(frame draw.Image, x, y int, text string, scale int, c color.Color) {
+	for lineNo, line := range strings.Split(text, "\n") {
+		top := y + lineNo*lineAdvance*scale
+		for i, r := range []rune(line) {
+			left := x + i*glyphAdvance*scale
+			g := glyph(r)
+			for col := 0; col < glyphWidth; col++ {
+				for row := 0; row < glyphHeight; row++ {
+					if g[col]&(1<<uint(row)) == 0 {
+						continue
+					}
+					for dy := 0; dy < scale; dy++ {
+						for dx := 0; dx < scale; dx++ {
+							setPixel(frame, left+col*scale+dx, top+row*scale+dy, c)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func setPixel(frame draw.Image, x, y int, c color.Color) {
+	if (image.Point{x, y}).In(frame.Bounds()) {
+		frame.Set(x, y, c)
+	}
+}
+
+// toPixel returns the pixel that contains coordinate v.
+func toPixel(v float64) int {
+	return int(math.Floor(v))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// AnnotationsFromGSPS extracts the text and graphic objects of
+// GraphicAnnotationSequence (0070,0001) in a Grayscale Softcopy Presentation
+// State. Layers and ReferencedImageSequence are ignored; every object found
+// is returned. Text objects are placed at their bounding box's top-left
+// corner, or else at their anchor point.
+func AnnotationsFromGSPS(ds *dicom.DataSet) ([]Annotation, error) {
+	seq, err := ds.FindElementByTag(dicomtag.GraphicAnnotationSequence)
+	if err != nil {
+		return nil, err
+	}
+	var result []Annotation
+	for _, v := range seq.Value {
+		item, ok := v.(*dicom.Element)
+		if !ok {
+			continue
+		}
+		elems := itemElements(item)
+		for _, text := range sequenceItems(elems, dicomtag.TextObjectSequence) {
+			annotation, err := textAnnotation(text)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, annotation)
+		}
+		for _, graphic := range sequenceItems(elems, dicomtag.GraphicObjectSequence) {
+			annotation, err := graphicAnnotation(graphic)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, annotation)
+		}
+	}
+	return result, nil
+}
+
+func itemElements(item *dicom.Element) []*dicom.Element {
+	var elems []*dicom.Element
+	for _, v := range item.Value {
+		if elem, ok := v.(*dicom.Element); ok {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+func sequenceItems(elems []*dicom.Element, tag dicomtag.Tag) [][]*dicom.Element {
+	seq, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return nil
+	}
+	var items [][]*dicom.Element
+	for _, v := range seq.Value {
+		if item, ok := v.(*dicom.Element); ok {
+			items = append(items, itemElements(item))
+		}
+	}
+	return items
+}
+
+func textAnnotation(elems []*dicom.Element) (Annotation, error) {
+	annotation := Annotation{Type: AnnotationText}
+	if elem, err := dicom.FindElementByTag(elems, dicomtag.UnformattedTextValue); err == nil {
+		annotation.Text, _ = elem.GetString()
+		annotation.Text = strings.Replace(annotation.Text, "\r\n", "\n", -1)
+	}
+	pointTag, unitsTag := dicomtag.BoundingBoxTopLeftHandCorner, dicomtag.BoundingBoxAnnotationUnits
+	if _, err := dicom.FindElementByTag(elems, pointTag); err != nil {
+		pointTag, unitsTag = dicomtag.AnchorPoint, dicomtag.AnchorPointAnnotationUnits
+	}
+	points, err := graphicPoints(elems, pointTag)
+	if err != nil {
+		return annotation, err
+	}
+	annotation.Points = points
+	annotation.Display = isDisplayUnits(elems, unitsTag)
+	return annotation, nil
+}
+
+func graphicAnnotation(elems []*dicom.Element) (Annotation, error) {
+	annotation := Annotation{Display: isDisplayUnits(elems, dicomtag.GraphicAnnotationUnits)}
+	elem, err := dicom.FindElementByTag(elems, dicomtag.GraphicType)
+	if err != nil {
+		return annotation, err
+	}
+	graphicType, err := elem.GetString()
+	if err != nil {
+		return annotation, err
+	}
+	switch graphicType {
+	case "POINT":
+		annotation.Type = AnnotationPoint
+	case "POLYLINE", "INTERPOLATED":
+		// INTERPOLATED is drawn as straight segments.
+		annotation.Type = AnnotationPolyline
+	case "CIRCLE":
+		annotation.Type = AnnotationCircle
+	case "ELLIPSE":
+		annotation.Type = AnnotationEllipse
+	default:
+		return annotation, fmt.Errorf("dicomimage: unknown GraphicType '%s'", graphicType)
+	}
+	if elem, err := dicom.FindElementByTag(elems, dicomtag.GraphicFilled); err == nil {
+		filled, _ := elem.GetString()
+		annotation.Filled = filled == "Y"
+	}
+	annotation.Points, err = graphicPoints(elems, dicomtag.GraphicData)
+	return annotation, err
+}
+
+// graphicPoints parses an FL element holding (column, row) pairs.
+func graphicPoints(elems []*dicom.Element, tag dicomtag.Tag) ([]Point, error) {
+	elem, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(elem.Value)%2 != 0 {
+		return nil, fmt.Errorf("dicomimage: %v: odd number of coordinates", dicomtag.DebugString(tag))
+	}
+	var points []Point
+	for i := 0; i < len(elem.Value); i += 2 {
+		x, ok0 := elem.Value[i].(float32)
+		y, ok1 := elem.Value[i+1].(float32)
+		if !ok0 || !ok1 {
+			return nil, fmt.Errorf("dicomimage: %v: coordinates must be float32", dicomtag.DebugString(tag))
+		}
+		points = append(points, Point{float64(x), float64(y)})
+	}
+	return points, nil
+}
+
+func isDisplayUnits(elems []*dicom.Element, tag dicomtag.Tag) bool {
+	elem, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return false
+	}
+	units, _ := elem.GetString()
+	return units == "DISPLAY"
+}
@@ -0,0 +1,99 @@
+package dicomimage_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomimage"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countSet(img *image.Gray) int {
+	n := 0
+	for _, v := range img.Pix {
+		if v != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestBurnAnnotations(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 40, 40))
+	require.NoError(t, dicomimage.BurnAnnotations(img, []dicomimage.Annotation{
+		{Type: dicomimage.AnnotationPolyline, Points: []dicomimage.Point{{X: 0, Y: 5}, {X: 39, Y: 5}}},
+	}))
+	for x := 0; x < 40; x++ {
+		assert.Equal(t, uint8(0xff), img.GrayAt(x, 5).Y)
+	}
+	assert.Equal(t, 40, countSet(img))
+
+	// Filled rectangle; the part outside of the image is clipped.
+	img = image.NewGray(image.Rect(0, 0, 40, 40))
+	require.NoError(t, dicomimage.BurnAnnotations(img, []dicomimage.Annotation{
+		{Type: dicomimage.AnnotationPolyline, Filled: true, Color: color.Gray{Y: 100},
+			Points: []dicomimage.Point{{X: 30, Y: 30}, {X: 50, Y: 30}, {X: 50, Y: 50}, {X: 30, Y: 50}, {X: 30, Y: 30}}},
+	}))
+	assert.Equal(t, uint8(100), img.GrayAt(35, 35).Y)
+	assert.Equal(t, 100, countSet(img))
+
+	// Circle in display units: centered, radius 10 pixels.
+	img = image.NewGray(image.Rect(0, 0, 40, 40))
+	require.NoError(t, dicomimage.BurnAnnotations(img, []dicomimage.Annotation{
+		{Type: dicomimage.AnnotationCircle, Display: true, Points: []dicomimage.Point{{X: 0.5, Y: 0.5}, {X: 0.75, Y: 0.5}}},
+	}))
+	assert.Equal(t, uint8(0xff), img.GrayAt(30, 20).Y)
+	assert.Equal(t, uint8(0xff), img.GrayAt(20, 10).Y)
+	assert.Equal(t, uint8(0), img.GrayAt(20, 20).Y)
+
+	img = image.NewGray(image.Rect(0, 0, 40, 40))
+	require.NoError(t, dicomimage.BurnAnnotations(img, []dicomimage.Annotation{
+		{Type: dicomimage.AnnotationText, Text: "L", Points: []dicomimage.Point{{X: 2, Y: 3}}, Scale: 2},
+	}))
+	// 'L' is a vertical bar of 7 rows plus a bottom bar of 5 columns.
+	assert.Equal(t, (7+4)*4, countSet(img))
+	assert.Equal(t, uint8(0xff), img.GrayAt(2, 3).Y)
+	assert.Equal(t, uint8(0xff), img.GrayAt(11, 16).Y)
+
+	err := dicomimage.BurnAnnotations(img, []dicomimage.Annotation{
+		{Type: dicomimage.AnnotationEllipse, Points: []dicomimage.Point{{X: 1, Y: 1}}},
+	})
+	require.Error(t, err)
+}
+
+func TestAnnotationsFromGSPS(t *testing.T) {
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewSequence(dicomtag.GraphicAnnotationSequence, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.GraphicLayer, "LAYER1"),
+			dicom.MustNewSequence(dicomtag.TextObjectSequence, []*dicom.Element{
+				dicom.MustNewElement(dicomtag.AnchorPointAnnotationUnits, "PIXEL"),
+				dicom.MustNewElement(dicomtag.UnformattedTextValue, "Lesion"),
+				dicom.MustNewElement(dicomtag.AnchorPoint, float32(10), float32(12)),
+			}),
+			dicom.MustNewSequence(dicomtag.GraphicObjectSequence, []*dicom.Element{
+				dicom.MustNewElement(dicomtag.GraphicAnnotationUnits, "DISPLAY"),
+				dicom.MustNewElement(dicomtag.GraphicData, float32(0.5), float32(0.5), float32(0.75), float32(0.5)),
+				dicom.MustNewElement(dicomtag.GraphicType, "CIRCLE"),
+				dicom.MustNewElement(dicomtag.GraphicFilled, "Y"),
+			}),
+		}),
+	}}
+	annotations, err := dicomimage.AnnotationsFromGSPS(ds)
+	require.NoError(t, err)
+	require.Len(t, annotations, 2)
+	assert.Equal(t, dicomimage.Annotation{
+		Type: dicomimage.AnnotationText, Text: "Lesion", Points: []dicomimage.Point{{X: 10, Y: 12}},
+	}, annotations[0])
+	assert.Equal(t, dicomimage.Annotation{
+		Type: dicomimage.AnnotationCircle, Display: true, Filled: true,
+		Points: []dicomimage.Point{{X: 0.5, Y: 0.5}, {X: 0.75, Y: 0.5}},
+	}, annotations[1])
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	require.NoError(t, dicomimage.BurnAnnotations(img, annotations))
+	assert.Equal(t, color.RGBA{0xff, 0xff, 0xff, 0xff}, img.RGBAAt(32, 32))
+}
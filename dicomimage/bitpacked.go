@@ -0,0 +1,52 @@
+package dicomimage
+
+import "fmt"
+
+// 1-bit pixel data (BitsAllocated=1, e.g., SEG and overlays in 60xx,3000) is
+// packed into bytes with the first pixel in the least significant bit.
+// Pixels are contiguous: rows and frames are not padded to byte boundaries,
+// only the whole value is padded to an even length. P3.5 8.1.1, 8.2.
+
+// PackBits packs a mask, one byte per pixel where any non-zero value is 1,
+// into 1-bit pixel data. The result is padded with zero bits to an even
+// number of bytes, ready to be stored as an OB/OW value.
+func PackBits(mask []uint8) []byte {
+	n := (len(mask) + 7) / 8
+	data := make([]byte, n+n%2)
+	for i, v := range mask {
+		if v != 0 {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+// UnpackBits returns the first "numPixels" pixels of 1-bit pixel data as a
+// mask with one byte (0 or 1) per pixel.
+func UnpackBits(data []byte, numPixels int) ([]uint8, error) {
+	return unpackBits(data, 0, numPixels)
+}
+
+// UnpackBitFrame returns frame number "frame" (0-based) of multi-frame 1-bit
+// pixel data as a mask of rows*columns bytes. Frames need not start at a
+// byte boundary.
+func UnpackBitFrame(data []byte, rows, columns, frame int) ([]uint8, error) {
+	if rows < 0 || columns < 0 || frame < 0 {
+		return nil, fmt.Errorf("dicomimage.UnpackBitFrame: invalid rows %d, columns %d or frame %d", rows, columns, frame)
+	}
+	n := rows * columns
+	return unpackBits(data, frame*n, n)
+}
+
+func unpackBits(data []byte, offset, numPixels int) ([]uint8, error) {
+	if numPixels < 0 || offset+numPixels > len(data)*8 {
+		return nil, fmt.Errorf("dicomimage: %d bytes of 1-bit pixel data are too short for pixels [%d, %d)",
+			len(data), offset, offset+numPixels)
+	}
+	mask := make([]uint8, numPixels)
+	for i := range mask {
+		bit := offset + i
+		mask[i] = (data[bit/8] >> uint(bit%8)) & 1
+	}
+	return mask, nil
+}
@@ -0,0 +1,38 @@
+package dicomimage_test
+
+import (
+	"testing"
+
+	"github.com/odincare/odicom/dicomimage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackBits(t *testing.T) {
+	// Two 3x3 frames; the second frame starts at bit 9.
+	mask := []uint8{
+		1, 0, 0,
+		0, 1, 0,
+		0, 0, 1,
+
+		0, 0, 255,
+		0, 1, 0,
+		1, 0, 0,
+	}
+	data := dicomimage.PackBits(mask)
+	assert.Equal(t, []byte{0x11, 0xa9, 0x00, 0x00}, data)
+
+	unpacked, err := dicomimage.UnpackBits(data, len(mask))
+	require.NoError(t, err)
+	assert.Equal(t, []uint8{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 1, 0, 1, 0, 1, 0, 0}, unpacked)
+
+	frame, err := dicomimage.UnpackBitFrame(data, 3, 3, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint8{0, 0, 1, 0, 1, 0, 1, 0, 0}, frame)
+
+	_, err = dicomimage.UnpackBitFrame(data, 3, 3, 4)
+	assert.Error(t, err)
+
+	assert.Equal(t, []byte{}, dicomimage.PackBits(nil))
+	assert.Equal(t, []byte{0x01, 0x00}, dicomimage.PackBits([]uint8{1}))
+}
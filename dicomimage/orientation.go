@@ -0,0 +1,221 @@
+package dicomimage
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Transform rotates and then mirrors an image. The zero value leaves the
+// image unchanged.
+type Transform struct {
+	// Rotation is clockwise, in degrees: a multiple of 90, e.g., 0, 90, 180
+	// or 270. Negative values rotate counterclockwise.
+	Rotation int
+	// FlipHorizontal mirrors the rotated image left to right.
+	FlipHorizontal bool
+}
+
+func (t Transform) String() string {
+	if t.FlipHorizontal {
+		return fmt.Sprintf("rotate %d, flip", t.Rotation)
+	}
+	return fmt.Sprintf("rotate %d", t.Rotation)
+}
+
+// matrix 返回t对像素坐标(x向右, y向下)的作用
+func (t Transform) matrix() [2][2]int {
+	m := [2][2]int{{1, 0}, {0, 1}}
+	for r := 0; r < (t.Rotation/90%4+4)%4; r++ {
+		// 顺时针90度: (x, y) -> (-y, x)
+		m = [2][2]int{{-m[1][0], -m[1][1]}, {m[0][0], m[0][1]}}
+	}
+	if t.FlipHorizontal {
+		m[0][0], m[0][1] = -m[0][0], -m[0][1]
+	}
+	return m
+}
+
+// Apply returns the transformed copy of "img", with its bounds starting at
+// (0,0). *image.Gray, *image.Gray16, *image.RGBA and *image.NRGBA keep their
+// type; other images are converted to *image.RGBA64. It returns an error if
+// Rotation is not a multiple of 90.
+func (t Transform) Apply(img image.Image) (image.Image, error) {
+	if t.Rotation%90 != 0 {
+		return nil, fmt.Errorf("dicomimage.Transform.Apply: rotation %d is not a multiple of 90", t.Rotation)
+	}
+	m := t.matrix()
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if m[0][0] == 0 {
+		w, h = h, w
+	}
+	var dst draw.Image
+	rect := image.Rect(0, 0, w, h)
+	switch img.(type) {
+	case *image.Gray:
+		dst = image.NewGray(rect)
+	case *image.Gray16:
+		dst = image.NewGray16(rect)
+	case *image.RGBA:
+		dst = image.NewRGBA(rect)
+	case *image.NRGBA:
+		dst = image.NewNRGBA(rect)
+	default:
+		dst = image.NewRGBA64(rect)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// 像素中心相对图像中心的坐标乘以2, 这样都是整数
+			cx, cy := 2*(x-b.Min.X)+1-b.Dx(), 2*(y-b.Min.Y)+1-b.Dy()
+			dx := m[0][0]*cx + m[0][1]*cy
+			dy := m[1][0]*cx + m[1][1]*cy
+			dst.Set((dx+w-1)/2, (dy+h-1)/2, img.At(x, y))
+		}
+	}
+	return dst, nil
+}
+
+// patientAxis 是病人坐标系(LPS)的一个方向: axis 0, 1, 2 分别是x (L), y (P), z (H),
+// sign是+1或-1
+type patientAxis struct {
+	axis, sign int
+}
+
+// mainAxis 返回方向向量v的主要(绝对值最大的分量)方向
+func mainAxis(v [3]float64) patientAxis {
+	a := 0
+	for i := 1; i < 3; i++ {
+		if math.Abs(v[i]) > math.Abs(v[a]) {
+			a = i
+		}
+	}
+	if v[a] < 0 {
+		return patientAxis{a, -1}
+	}
+	return patientAxis{a, 1}
+}
+
+// 病人方向的字母. P3.3 C.7.6.1.1.1
+var orientationLetters = map[byte]patientAxis{
+	'L': {0, 1}, 'R': {0, -1},
+	'P': {1, 1}, 'A': {1, -1},
+	'H': {2, 1}, 'F': {2, -1},
+}
+
+// displayAxes 是PACS viewers显示的方向, 按图像的平面(法线的axis): 行方向和列方向.
+// Axial: 病人的右边在屏幕左边, 前面在上面. Coronal: 右边在左边, 头在上面.
+// Sagittal: 前面在左边, 头在上面
+var displayAxes = [3][2]patientAxis{
+	{{1, 1}, {2, -1}}, // sagittal
+	{{0, 1}, {2, -1}}, // coronal
+	{{0, 1}, {1, 1}},  // axial
+}
+
+// DisplayTransform returns the transform that turns a frame of "ds" into
+// the orientation PACS viewers display it in: axial images with the
+// patient's right on the left of the screen and anterior at the top,
+// coronal images with the head at the top, and sagittal images with
+// anterior on the left and the head at the top. The plane of an oblique
+// image is the one closest to it.
+//
+// The row and column directions come from ImageOrientationPatient
+// (0020,0037), at the top level or in the PlaneOrientationSequence of
+// SharedFunctionalGroupsSequence, or else from the first letter of each
+// PatientOrientation (0020,0020) value, e.g., for CR and DX.
+// ImageOrientationPatient is in patient coordinates, so it already includes
+// PatientPosition (0018,5100): feet first (FFS) and prone (HFP) images get
+// the flip or rotation that brings them to the same display as head first
+// supine (HFS).
+//
+// It returns an error if the data set has neither attribute or if they
+// don't describe two perpendicular directions.
+func DisplayTransform(ds *dicom.DataSet) (Transform, error) {
+	row, column, err := imageAxes(ds)
+	if err != nil {
+		return Transform{}, fmt.Errorf("dicomimage.DisplayTransform: %v", err)
+	}
+	if row.axis == column.axis {
+		return Transform{}, fmt.Errorf("dicomimage.DisplayTransform: row and column are both along axis %d", row.axis)
+	}
+	target := displayAxes[3-row.axis-column.axis]
+	for _, t := range transforms {
+		m := t.matrix()
+		// 变换后的行方向是原来的m[0][0]*row + m[0][1]*column, 列方向类似
+		if transformedAxis(m[0], row, column) == target[0] && transformedAxis(m[1], row, column) == target[1] {
+			return t, nil
+		}
+	}
+	return Transform{}, fmt.Errorf("dicomimage.DisplayTransform: no transform from row %v and column %v", row, column)
+}
+
+// transforms 是所有8个rotation和flip的组合
+var transforms = []Transform{
+	{0, false}, {90, false}, {180, false}, {270, false},
+	{0, true}, {90, true}, {180, true}, {270, true},
+}
+
+func transformedAxis(m [2]int, row, column patientAxis) patientAxis {
+	if m[0] != 0 {
+		return patientAxis{row.axis, row.sign * m[0]}
+	}
+	return patientAxis{column.axis, column.sign * m[1]}
+}
+
+// imageAxes 返回图像行方向和列方向的主要病人方向
+func imageAxes(ds *dicom.DataSet) (row, column patientAxis, err error) {
+	elem, err := ds.FindElementByTag(dicomtag.ImageOrientationPatient)
+	if err != nil {
+		elems := ds.Elements
+		for _, tag := range []dicomtag.Tag{dicomtag.SharedFunctionalGroupsSequence, dicomtag.PlaneOrientationSequence} {
+			if items := sequenceItems(elems, tag); len(items) > 0 {
+				elems = items[0]
+			} else {
+				elems = nil
+			}
+		}
+		elem, err = dicom.FindElementByTag(elems, dicomtag.ImageOrientationPatient)
+	}
+	if err == nil {
+		values, err := elem.GetStrings()
+		if err != nil {
+			return row, column, err
+		}
+		if len(values) != 6 {
+			return row, column, fmt.Errorf("ImageOrientationPatient has %d values, expect 6", len(values))
+		}
+		var cosines [6]float64
+		for i, s := range values {
+			if cosines[i], err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+				return row, column, fmt.Errorf("ImageOrientationPatient: %v", err)
+			}
+		}
+		return mainAxis([3]float64{cosines[0], cosines[1], cosines[2]}),
+			mainAxis([3]float64{cosines[3], cosines[4], cosines[5]}), nil
+	}
+
+	elem, err = ds.FindElementByTag(dicomtag.PatientOrientation)
+	if err != nil {
+		return row, column, fmt.Errorf("no ImageOrientationPatient or PatientOrientation")
+	}
+	values, err := elem.GetStrings()
+	if err != nil {
+		return row, column, err
+	}
+	if len(values) != 2 || values[0] == "" || values[1] == "" {
+		return row, column, fmt.Errorf("invalid PatientOrientation %q", values)
+	}
+	var ok0, ok1 bool
+	row, ok0 = orientationLetters[values[0][0]]
+	column, ok1 = orientationLetters[values[1][0]]
+	if !ok0 || !ok1 {
+		return row, column, fmt.Errorf("invalid PatientOrientation %q", values)
+	}
+	return row, column, nil
+}
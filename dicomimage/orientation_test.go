@@ -0,0 +1,108 @@
+package dicomimage_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomimage"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformApply(t *testing.T) {
+	// 3x2, 像素值是 10*y + x
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(10*y + x)})
+		}
+	}
+	pixels := func(transform dicomimage.Transform) [][]uint8 {
+		img, err := transform.Apply(img)
+		require.NoError(t, err)
+		b := img.Bounds()
+		var rows [][]uint8
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			var row []uint8
+			for x := b.Min.X; x < b.Max.X; x++ {
+				row = append(row, img.(*image.Gray).GrayAt(x, y).Y)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+	assert.Equal(t, [][]uint8{{0, 1, 2}, {10, 11, 12}}, pixels(dicomimage.Transform{}))
+	assert.Equal(t, [][]uint8{{10, 0}, {11, 1}, {12, 2}}, pixels(dicomimage.Transform{Rotation: 90}))
+	assert.Equal(t, [][]uint8{{12, 11, 10}, {2, 1, 0}}, pixels(dicomimage.Transform{Rotation: 180}))
+	assert.Equal(t, [][]uint8{{2, 12}, {1, 11}, {0, 10}}, pixels(dicomimage.Transform{Rotation: 270}))
+	assert.Equal(t, [][]uint8{{2, 1, 0}, {12, 11, 10}}, pixels(dicomimage.Transform{FlipHorizontal: true}))
+	assert.Equal(t, [][]uint8{{0, 10}, {1, 11}, {2, 12}}, pixels(dicomimage.Transform{Rotation: 90, FlipHorizontal: true}))
+	assert.Equal(t, [][]uint8{{2, 12}, {1, 11}, {0, 10}}, pixels(dicomimage.Transform{Rotation: -90}))
+
+	rgba := image.NewRGBA(image.Rect(5, 5, 8, 7))
+	out, err := dicomimage.Transform{Rotation: 90}.Apply(rgba)
+	require.NoError(t, err)
+	assert.IsType(t, &image.RGBA{}, out)
+	assert.Equal(t, image.Rect(0, 0, 2, 3), out.Bounds())
+
+	_, err = dicomimage.Transform{Rotation: 45}.Apply(rgba)
+	assert.Error(t, err)
+}
+
+func TestDisplayTransform(t *testing.T) {
+	withOrientation := func(tag dicomtag.Tag, values ...string) *dicom.DataSet {
+		var v []interface{}
+		for _, s := range values {
+			v = append(v, s)
+		}
+		return &dicom.DataSet{Elements: []*dicom.Element{dicom.MustNewElement(tag, v...)}}
+	}
+	iop := func(values ...string) *dicom.DataSet {
+		return withOrientation(dicomtag.ImageOrientationPatient, values...)
+	}
+	tests := []struct {
+		name string
+		ds   *dicom.DataSet
+		want dicomimage.Transform
+	}{
+		{"axial HFS", iop("1", "0", "0", "0", "1", "0"), dicomimage.Transform{}},
+		{"axial FFS", iop("-1", "0", "0", "0", "1", "0"), dicomimage.Transform{FlipHorizontal: true}},
+		{"axial HFP", iop("-1", "0", "0", "0", "-1", "0"), dicomimage.Transform{Rotation: 180}},
+		{"axial oblique", iop("0.96", "0.28", "0", "-0.28", "0.96", "0"), dicomimage.Transform{}},
+		{"coronal", iop("1", "0", "0", "0", "0", "-1"), dicomimage.Transform{}},
+		{"sagittal", iop("0", "1", "0", "0", "0", "-1"), dicomimage.Transform{}},
+		// 行方向向脚, 列方向向后: 需要交换行和列
+		{"sagittal transposed", iop("0", "0", "-1", "0", "1", "0"), dicomimage.Transform{Rotation: 90, FlipHorizontal: true}},
+		{"coronal rotated", iop("0", "0", "1", "1", "0", "0"), dicomimage.Transform{Rotation: 270}},
+		{"DX PA", withOrientation(dicomtag.PatientOrientation, "L", "F"), dicomimage.Transform{}},
+		{"DX flipped", withOrientation(dicomtag.PatientOrientation, "R", "F"), dicomimage.Transform{FlipHorizontal: true}},
+		{"DX oblique letters", withOrientation(dicomtag.PatientOrientation, "AL", "FP"), dicomimage.Transform{FlipHorizontal: true}},
+	}
+	for _, test := range tests {
+		got, err := dicomimage.DisplayTransform(test.ds)
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.want, got, test.name)
+	}
+
+	// Enhanced multi-frame: SharedFunctionalGroupsSequence > PlaneOrientationSequence
+	plane := dicom.MustNewSequence(dicomtag.PlaneOrientationSequence, []*dicom.Element{
+		dicom.MustNewElement(dicomtag.ImageOrientationPatient, "-1", "0", "0", "0", "1", "0")})
+	ds := &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewSequence(dicomtag.SharedFunctionalGroupsSequence, []*dicom.Element{plane})}}
+	got, err := dicomimage.DisplayTransform(ds)
+	require.NoError(t, err)
+	assert.Equal(t, dicomimage.Transform{FlipHorizontal: true}, got)
+
+	for _, ds := range []*dicom.DataSet{
+		{},
+		iop("1", "0", "0"),
+		iop("1", "0", "0", "1", "0", "0"),
+		withOrientation(dicomtag.PatientOrientation, "X", "F"),
+	} {
+		_, err := dicomimage.DisplayTransform(ds)
+		assert.Error(t, err)
+	}
+}
@@ -5,12 +5,13 @@ package dicomio
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
 
-	"github.com/sirupsen/logrus"
+	"github.com/odincare/odicom/dicomuid"
 	"golang.org/x/text/encoding"
 )
 
@@ -22,6 +23,9 @@ var NativeByteOrder = binary.LittleEndian
 type transferSyntaxStackEntry struct {
 	byteorder binary.ByteOrder
 	implicit  IsImplicitVR
+
+	// deflatedOut 是Encoder开始deflate之前的out, 不deflate时为nil
+	deflatedOut io.Writer
 }
 
 type stackEntry struct {
@@ -35,6 +39,13 @@ type Encoder struct {
 
 	out io.Writer
 
+	// NewBufferedEncoder把out包装在bufio.Writer里, 避免每个element都产生一次syscall.
+	// Flush()会flush它. 没有buffer时是nil
+	buf *bufio.Writer
+
+	// 编码数字时用的临时buffer, 避免binary.Write的内存分配
+	scratch [8]byte
+
 	byteorder binary.ByteOrder
 
 	// implicit不是内部方法 而是给user查看当前是implicit的transfer syntax
@@ -42,6 +53,9 @@ type Encoder struct {
 
 	// Stack of old transfer syntaxes. {Push, Pop} TransferSyntax使用.
 	oldTransferSyntaxes []transferSyntaxStackEntry
+
+	// codingSystem 是写string values时使用的character set, 见SetCodingSystem
+	codingSystem CodingSystem
 }
 
 // NewBytesEncoder创建一个新的encoder，数据会写入缓冲区
@@ -86,15 +100,58 @@ func NewEncoderWithTransferSyntax(out io.Writer, transferSyntaxUID string) *Enco
 	return e
 }
 
-// NewEncoder creates a new encoder that writes to "out"
+// NewEncoder creates a new encoder that writes to "out". Each write goes
+// to "out" right away; see NewBufferedEncoder.
 func NewEncoder(out io.Writer, byteorder binary.ByteOrder, implicit IsImplicitVR) *Encoder {
-
-	return &Encoder{
+	e := &Encoder{
 		err:       nil,
 		out:       out,
 		byteorder: byteorder,
 		implicit:  implicit,
 	}
+	if w, ok := out.(*bufio.Writer); ok {
+		e.buf = w
+	}
+	return e
+}
+
+// NewBufferedEncoder is similar to NewEncoder, but buffers the output,
+// e.g., to avoid a syscall per element when "out" is a file or a network
+// connection. Call Flush after the last write.
+func NewBufferedEncoder(out io.Writer, byteorder binary.ByteOrder, implicit IsImplicitVR) *Encoder {
+	e := NewEncoder(out, byteorder, implicit)
+	switch out.(type) {
+	case *bytes.Buffer, *bufio.Writer:
+	default:
+		e.buf = bufio.NewWriter(out)
+		e.out = e.buf
+	}
+	return e
+}
+
+// Flush writes any buffered data to the underlying io.Writer, and returns
+// the first error found while encoding or writing, same as Error(). It is
+// needed only for encoders created with NewBufferedEncoder, or writing to a
+// *bufio.Writer.
+func (e *Encoder) Flush() error {
+	if e.buf != nil {
+		if err := e.buf.Flush(); err != nil {
+			e.SetError(err)
+		}
+	}
+	return e.err
+}
+
+// SetCodingSystem sets the character set of string values written after
+// this call, see CodingSystem.Encode. The default is to write UTF-8 strings
+// as they are.
+func (e *Encoder) SetCodingSystem(cs CodingSystem) {
+	e.codingSystem = cs
+}
+
+// CodingSystem returns the character set set by SetCodingSystem.
+func (e *Encoder) CodingSystem() CodingSystem {
+	return e.codingSystem
 }
 
 // TransferSyntax returns the current transfer syntax
@@ -106,17 +163,44 @@ func (e *Encoder) TransferSyntax() (binary.ByteOrder, IsImplicitVR) {
 // PopTransferSyntax() 来恢复
 func (e *Encoder) PushTransferSyntax(byteorder binary.ByteOrder, implicit IsImplicitVR) {
 	e.oldTransferSyntaxes = append(e.oldTransferSyntaxes,
-		transferSyntaxStackEntry{e.byteorder, e.implicit})
+		transferSyntaxStackEntry{byteorder: e.byteorder, implicit: e.implicit})
 
 	e.byteorder = byteorder
 	e.implicit = implicit
 }
 
+// PushTransferSyntaxByUID is similar to PushTransferSyntax, but it takes a
+// transfer syntax UID. For Deflated Explicit VR Little Endian, the output is
+// deflated until the matching PopTransferSyntax, which ends the deflated
+// stream.
+func (e *Encoder) PushTransferSyntaxByUID(uid string) {
+	endian, implicit, err := ParseTransferSyntaxUID(uid)
+	if err != nil {
+		e.SetError(err)
+	}
+	e.PushTransferSyntax(endian, implicit)
+	if uid == dicomuid.DeflatedExplicitVRLittleEndian {
+		w, err := flate.NewWriter(e.out, flate.DefaultCompression)
+		if err != nil {
+			e.SetError(err)
+			return
+		}
+		e.oldTransferSyntaxes[len(e.oldTransferSyntaxes)-1].deflatedOut = e.out
+		e.out = w
+	}
+}
+
 // 与PushTransferSyntax对应
 func (e *Encoder) PopTransferSyntax() {
 	ts := e.oldTransferSyntaxes[len(e.oldTransferSyntaxes)-1]
 	e.byteorder = ts.byteorder
 	e.implicit = ts.implicit
+	if ts.deflatedOut != nil {
+		if err := e.out.(*flate.Writer).Close(); err != nil {
+			e.SetError(err)
+		}
+		e.out = ts.deflatedOut
+	}
 	e.oldTransferSyntaxes = e.oldTransferSyntaxes[:len(e.oldTransferSyntaxes)-1]
 }
 
@@ -149,71 +233,81 @@ func (e *Encoder) Error() error {
 func (e *Encoder) Bytes() []byte {
 	DoAssert(len(e.oldTransferSyntaxes) == 0)
 	if e.err != nil {
-		logrus.Panic(e.err)
+		panic(&AssertionError{Message: e.err.Error()})
 	}
 	return e.out.(*bytes.Buffer).Bytes()
 }
 
 func (e *Encoder) WriteByte(v byte) {
-	// TODO warning？
-	if err := binary.Write(e.out, e.byteorder, &v); err != nil {
+	e.scratch[0] = v
+	e.writeRaw(e.scratch[:1])
+}
+
+func (e *Encoder) writeRaw(data []byte) {
+	if _, err := e.out.Write(data); err != nil {
 		e.SetError(err)
 	}
 }
 
 func (e *Encoder) WriteUInt16(v uint16) {
-	if err := binary.Write(e.out, e.byteorder, &v); err != nil {
-		e.SetError(err)
-	}
+	e.byteorder.PutUint16(e.scratch[:], v)
+	e.writeRaw(e.scratch[:2])
 }
 
 func (e *Encoder) WriteUInt32(v uint32) {
-	if err := binary.Write(e.out, e.byteorder, &v); err != nil {
-		e.SetError(err)
-	}
+	e.byteorder.PutUint32(e.scratch[:], v)
+	e.writeRaw(e.scratch[:4])
 }
 
 func (e *Encoder) WriteInt16(v int16) {
-	if err := binary.Write(e.out, e.byteorder, &v); err != nil {
-		e.SetError(err)
-	}
+	e.WriteUInt16(uint16(v))
 }
 
 func (e *Encoder) WriteInt32(v int32) {
-	if err := binary.Write(e.out, e.byteorder, &v); err != nil {
-		e.SetError(err)
-	}
+	e.WriteUInt32(uint32(v))
+}
+
+func (e *Encoder) WriteUInt64(v uint64) {
+	e.byteorder.PutUint64(e.scratch[:], v)
+	e.writeRaw(e.scratch[:8])
+}
+
+func (e *Encoder) WriteInt64(v int64) {
+	e.WriteUInt64(uint64(v))
 }
 
 func (e *Encoder) WriteFloat32(v float32) {
-	if err := binary.Write(e.out, e.byteorder, &v); err != nil {
-		e.SetError(err)
-	}
+	e.WriteUInt32(math.Float32bits(v))
 }
 
 func (e *Encoder) WriteFloat64(v float64) {
-	if err := binary.Write(e.out, e.byteorder, &v); err != nil {
-		e.SetError(err)
-	}
+	e.byteorder.PutUint64(e.scratch[:], math.Float64bits(v))
+	e.writeRaw(e.scratch[:8])
 }
 
 // WriteString writes the string, withoutout any length prefix or padding.
 func (e *Encoder) WriteString(v string) {
-	if _, err := e.out.Write([]byte(v)); err != nil {
+	if _, err := io.WriteString(e.out, v); err != nil {
 		e.SetError(err)
 	}
 }
 
 // WriteZeros encodes an array of zero bytes.
 func (e *Encoder) WriteZeros(len int) {
-	// TODO 重用缓存
-	zeros := make([]byte, len)
-	e.out.Write(zeros)
+	var zeros [64]byte
+	for len > 0 {
+		n := len
+		if n > cap(zeros) {
+			n = cap(zeros)
+		}
+		e.writeRaw(zeros[:n])
+		len -= n
+	}
 }
 
 // Copy the given data to output.
 func (e *Encoder) WriteBytes(v []byte) {
-	e.out.Write(v)
+	e.writeRaw(v)
 }
 
 // IsImplicitVR defines whether a 2-character VR tag
@@ -262,14 +356,62 @@ type Decoder struct {
 	stateStack []stackEntry
 }
 
+// DefaultDecoderBufferSize 是NewDecoder的bufio.Reader的大小, 和bufio的默认值相同
+const DefaultDecoderBufferSize = 4096
+
+// minDecoderBufferSize 是bufio.Reader的最小大小(bufio不接受更小的)
+const minDecoderBufferSize = 16
+
 // NewDecoder创建一个decoder对象从"in"读取“limit”
 // 不要随便传一个大数来作为"limit"，如下代码会认为"limit"绑定在data最后
+//
+// If "in" reports its remaining length (e.g., *bytes.Reader, *bytes.Buffer,
+// *strings.Reader), the internal buffer is no larger than that.
 func NewDecoder(
 	in io.Reader,
 	byteorder binary.ByteOrder,
 	implicit IsImplicitVR) *Decoder {
+	size := DefaultDecoderBufferSize
+	if r, ok := in.(interface{ Len() int }); ok && r.Len() < size {
+		size = r.Len()
+	}
+	return NewDecoderSize(in, size, byteorder, implicit)
+}
+
+// NewDecoderSize is similar to NewDecoder, but the internal buffer has
+// "size" bytes (at least 16). Decoder.Peek can't return more than size bytes.
+func NewDecoderSize(in io.Reader, size int, byteorder binary.ByteOrder, implicit IsImplicitVR) *Decoder {
+	if size < minDecoderBufferSize {
+		size = minDecoderBufferSize
+	}
+	return newDecoder(bufio.NewReaderSize(in, size), byteorder, implicit)
+}
+
+// NewDecoderWithBuffer is similar to NewDecoder, but reads through "buf"
+// instead of allocating a buffer: buf is reset to read from "in". Use it
+// with buffers from a sync.Pool when parsing many small inputs, e.g., only
+// the file meta header. buf must not be used otherwise, or returned to the
+// pool, while the Decoder is in use.
+func NewDecoderWithBuffer(in io.Reader, buf *bufio.Reader, byteorder binary.ByteOrder, implicit IsImplicitVR) *Decoder {
+	buf.Reset(in)
+	return newDecoder(buf, byteorder, implicit)
+}
+
+// NewDecoderTee is similar to NewDecoder, but everything read from "in"
+// is also written to "tee", e.g., a hash.Hash, so that the digest of the
+// original input is computed in the same pass as the parsing. The bytes
+// are the raw input, also for a deflated transfer syntax. Because the
+// Decoder reads ahead, tee can be ahead of BytesRead; it has received the
+// whole input once the Decoder has read "in" to the end, e.g., when EOF
+// returns true at the end of the input. An error from tee is returned as a
+// read error.
+func NewDecoderTee(in io.Reader, tee io.Writer, byteorder binary.ByteOrder, implicit IsImplicitVR) *Decoder {
+	return NewDecoderSize(io.TeeReader(in, tee), DefaultDecoderBufferSize, byteorder, implicit)
+}
+
+func newDecoder(in *bufio.Reader, byteorder binary.ByteOrder, implicit IsImplicitVR) *Decoder {
 	return &Decoder{
-		in:        bufio.NewReader(in),
+		in:        in,
 		err:       nil,
 		byteorder: byteorder,
 		implicit:  implicit,
@@ -324,19 +466,34 @@ func (d *Decoder) TransferSyntax() (byteorder binary.ByteOrder, implicit IsImpli
 // PopTransferSyntax() 恢复旧的编码格式
 func (d *Decoder) PushTransferSyntax(byteorder binary.ByteOrder, implicit IsImplicitVR) {
 
-	d.oldTransferSyntaxes = append(d.oldTransferSyntaxes, transferSyntaxStackEntry{d.byteorder, d.implicit})
+	d.oldTransferSyntaxes = append(d.oldTransferSyntaxes, transferSyntaxStackEntry{byteorder: d.byteorder, implicit: d.implicit})
 	d.byteorder = byteorder
 	d.implicit = implicit
 }
 
 // PushTransferSyntaxByUID is similar to PushTransferSyntax, but it takes a
-// transfer syntax UID.
+// transfer syntax UID. For Deflated Explicit VR Little Endian, the rest of
+// the input is inflated; PopTransferSyntax doesn't undo that. BytesRead
+// then counts inflated bytes.
 func (d *Decoder) PushTransferSyntaxByUID(uid string) {
 	endian, implicit, err := ParseTransferSyntaxUID(uid)
 	if err != nil {
 		d.SetError(err)
 	}
 	d.PushTransferSyntax(endian, implicit)
+	if uid == dicomuid.DeflatedExplicitVRLittleEndian {
+		d.inflate()
+	}
+}
+
+// inflate 把剩余的input换成inflate之后的stream. bufio.Reader实现了io.ByteReader,
+// 所以flate不会读到deflated stream之后的数据
+func (d *Decoder) inflate() {
+	if len(d.stateStack) > 0 {
+		d.SetErrorf("dicomio.Decoder: can't inflate inside a limit")
+		return
+	}
+	d.in = bufio.NewReaderSize(flate.NewReader(d.in), DefaultDecoderBufferSize)
 }
 
 // SetCodingSystem overrides the default (7bit ASCII) decoder used when
@@ -439,6 +596,24 @@ func (d *Decoder) EOF() bool {
 	return len(data) == 0
 }
 
+// Peek returns the next n bytes without consuming them. The result is shorter
+// than n if fewer bytes remain before the end of input or the current limit,
+// or if n is larger than the decoder's buffer, see NewDecoderSize.
+// Peek doesn't set d.Error().
+func (d *Decoder) Peek(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if remaining := d.len(); remaining < int64(n) {
+		n = int(remaining)
+	}
+	if n <= 0 {
+		return nil
+	}
+	data, _ := d.in.Peek(n)
+	return data
+}
+
 // BytesRead returns the cumulative # of bytes read so far.
 func (d *Decoder) BytesRead() int64 { return d.pos }
 
@@ -475,6 +650,22 @@ func (d *Decoder) ReadInt32() (v int32) {
 	return v
 }
 
+func (d *Decoder) ReadUInt64() (v uint64) {
+	err := binary.Read(d, d.byteorder, &v)
+	if err != nil {
+		d.SetError(err)
+	}
+	return v
+}
+
+func (d *Decoder) ReadInt64() (v int64) {
+	err := binary.Read(d, d.byteorder, &v)
+	if err != nil {
+		d.SetError(err)
+	}
+	return v
+}
+
 func (d *Decoder) ReadUInt16() (v uint16) {
 	err := binary.Read(d, d.byteorder, &v)
 	if err != nil {
@@ -550,11 +741,30 @@ func (d *Decoder) ReadString(length int) string {
 }
 
 func (d *Decoder) ReadBytes(length int) []byte {
+	return d.ReadBytesAlloc(length, nil)
+}
+
+// maxEagerAlloc 是ReadBytes在读之前最多分配的bytes. 从stream读的时候不知道还剩多少输入,
+// 更长的value边读边分配, 这样损坏的VL只会导致读错误, 而不是几个GB的分配
+const maxEagerAlloc = 1 << 24
+
+// ReadBytesAlloc is ReadBytes, but reads into the buffer returned by
+// alloc(length), e.g., one to reuse. "alloc" is only called if that many
+// bytes are available; nil means make.
+func (d *Decoder) ReadBytesAlloc(length int, alloc func(size int) []byte) []byte {
 	if d.len() < int64(length) {
 		d.SetError(fmt.Errorf("ReadBytes: requested %d, available %d", length, d.len()))
 		return nil
 	}
-	v := make([]byte, length)
+	if alloc == nil && length > maxEagerAlloc {
+		return d.readBytesGrowing(length)
+	}
+	var v []byte
+	if alloc != nil {
+		v = alloc(length)
+	} else {
+		v = make([]byte, length)
+	}
 	remaining := v
 	for len(remaining) > 0 {
 		n, err := d.Read(remaining)
@@ -571,6 +781,28 @@ func (d *Decoder) ReadBytes(length int) []byte {
 	return v
 }
 
+// readBytesGrowing 读length bytes, buffer随着读到的数据增长. 输入不够时设置错误,
+// 返回读到的bytes
+func (d *Decoder) readBytesGrowing(length int) []byte {
+	v := make([]byte, 0, maxEagerAlloc)
+	for len(v) < length {
+		if len(v) == cap(v) {
+			newCap := 2 * cap(v)
+			if newCap > length {
+				newCap = length
+			}
+			v = append(make([]byte, 0, newCap), v...)
+		}
+		n, err := io.ReadFull(d, v[len(v):cap(v)])
+		v = v[:len(v)+n]
+		if err != nil {
+			d.SetError(fmt.Errorf("ReadBytes: requested %d, read %d: %v", length, len(v), err))
+			break
+		}
+	}
+	return v
+}
+
 func (d *Decoder) Skip(length int) {
 
 	if d.len() < int64(length) {
@@ -579,34 +811,16 @@ func (d *Decoder) Skip(length int) {
 		return
 	}
 
-	// 位运算
-	junkSize := 1 << 16
-	if length < junkSize {
-		junkSize = length
-	}
-
-	junk := make([]byte, junkSize)
-
-	remaining := length
-	for remaining > 0 {
-		tempLength := len(junk)
-		if remaining < tempLength {
-			tempLength = remaining
-		}
-
-		tempBuf := junk[:tempLength]
-		n, err := d.Read(tempBuf)
-		if err != nil {
-			d.SetError(err)
-			break
-		}
-		DoAssert(n > 0)
-		remaining -= n
+	// bufio.Reader.Discard 不需要分配缓存
+	n, err := d.in.Discard(length)
+	d.pos += int64(n)
+	if err != nil {
+		d.SetError(err)
 	}
-
-	DoAssert(d.err != nil || remaining == 0)
 }
 
+// DoAssert panics with an *AssertionError if "condition" is false. The
+// message is made of "values".
 func DoAssert(condition bool, values ...interface{}) {
 
 	if !condition {
@@ -615,6 +829,6 @@ func DoAssert(condition bool, values ...interface{}) {
 			s += fmt.Sprintf("%v", value)
 		}
 
-		logrus.Panic(s)
+		panic(&AssertionError{Message: s})
 	}
 }
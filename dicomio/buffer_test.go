@@ -1,9 +1,14 @@
 package dicomio_test
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"io"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/odincare/odicom/dicomio"
@@ -58,6 +63,19 @@ func TestPartialData(t *testing.T) {
 	}
 }
 
+func TestReadBytesLarge(t *testing.T) {
+	// A length larger than the input is an error, without allocating it.
+	d := dicomio.NewBytesDecoder([]byte{1, 2, 3, 4}, binary.LittleEndian, dicomio.ExplicitVR)
+	require.Len(t, d.ReadBytes(0x7ffffff0), 4)
+	require.Error(t, d.Error())
+
+	data := make([]byte, 40<<20+3)
+	data[len(data)-1] = 7
+	d = dicomio.NewBytesDecoder(data, binary.LittleEndian, dicomio.ExplicitVR)
+	require.Equal(t, data, d.ReadBytes(len(data)))
+	require.NoError(t, d.Error())
+}
+
 func TestLimit(t *testing.T) {
 	e := dicomio.NewBytesEncoder(binary.BigEndian, dicomio.UnknownVR)
 	e.WriteByte(10)
@@ -77,3 +95,117 @@ func TestLimit(t *testing.T) {
 		t.Errorf("Limit: %v %v %v", v0, v1, d.Error())
 	}
 }
+
+// countingWriter counts the Write calls it receives.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestEncoderFlush(t *testing.T) {
+	// NewEncoder doesn't buffer, so callers that never Flush get the output.
+	out := &countingWriter{}
+	e := dicomio.NewEncoder(out, binary.LittleEndian, dicomio.ExplicitVR)
+	e.WriteUInt16(1)
+	e.WriteFloat64(2)
+	require.Equal(t, 2, out.writes)
+	require.Equal(t, 10, out.Len())
+	require.NoError(t, e.Flush())
+
+	out = &countingWriter{}
+	e = dicomio.NewBufferedEncoder(out, binary.LittleEndian, dicomio.ExplicitVR)
+	for i := 0; i < 100; i++ {
+		e.WriteUInt16(uint16(i))
+		e.WriteFloat64(float64(i))
+	}
+	e.WriteString("ab")
+	e.WriteZeros(100)
+	require.Equal(t, 0, out.writes)
+	require.NoError(t, e.Flush())
+	require.Equal(t, 1, out.writes)
+	require.Equal(t, 100*10+2+100, out.Len())
+
+	d := dicomio.NewBytesDecoder(out.Bytes(), binary.LittleEndian, dicomio.ExplicitVR)
+	for i := 0; i < 100; i++ {
+		require.Equal(t, uint16(i), d.ReadUInt16())
+		require.Equal(t, float64(i), d.ReadFloat64())
+	}
+	require.Equal(t, "ab", d.ReadString(2))
+	require.NoError(t, d.Error())
+}
+
+func TestDecoderBuffer(t *testing.T) {
+	pool := sync.Pool{New: func() interface{} { return bufio.NewReaderSize(nil, 64) }}
+	for _, s := range []string{"first input", "second"} {
+		buf := pool.Get().(*bufio.Reader)
+		d := dicomio.NewDecoderWithBuffer(strings.NewReader(s), buf, binary.LittleEndian, dicomio.ExplicitVR)
+		require.Equal(t, s, d.ReadString(len(s)))
+		require.True(t, d.EOF())
+		require.NoError(t, d.Finish())
+		pool.Put(buf)
+	}
+
+	// Peek is limited by the buffer size.
+	data := make([]byte, 100)
+	d := dicomio.NewDecoderSize(bytes.NewReader(data), 32, binary.LittleEndian, dicomio.ExplicitVR)
+	require.Len(t, d.Peek(8), 8)
+	require.Len(t, d.Peek(64), 32)
+	d.Skip(100)
+	require.NoError(t, d.Finish())
+}
+
+func TestDecoderTee(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	h := sha256.New()
+	d := dicomio.NewDecoderTee(bytes.NewReader(data), h, binary.LittleEndian, dicomio.ExplicitVR)
+	require.Equal(t, uint16(0x0700), d.ReadUInt16())
+	d.Skip(5000)
+	d.ReadBytes(len(data) - 5002)
+	require.True(t, d.EOF())
+	require.NoError(t, d.Finish())
+	expected := sha256.Sum256(data)
+	require.Equal(t, expected[:], h.Sum(nil))
+
+	// An error from the tee is a read error.
+	d = dicomio.NewDecoderTee(bytes.NewReader(data), failingWriter{}, binary.LittleEndian, dicomio.ExplicitVR)
+	d.ReadUInt16()
+	require.Error(t, d.Error())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestPanicFree(t *testing.T) {
+	f := func() (err error) {
+		defer dicomio.Recover(&err)
+		dicomio.DoAssert(false, "bad ", 42)
+		return nil
+	}
+	require.Panics(t, func() { f() }) // nolint: errcheck
+
+	dicomio.SetPanicFree(true)
+	defer dicomio.SetPanicFree(false)
+	err := f()
+	require.Error(t, err)
+	require.IsType(t, &dicomio.AssertionError{}, err)
+	require.Equal(t, "bad 42", err.(*dicomio.AssertionError).Message)
+
+	// Bytes of an encoder with an error.
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+	e.SetErrorf("failed")
+	g := func() (err error) {
+		defer dicomio.Recover(&err)
+		e.Bytes()
+		return nil
+	}
+	require.Error(t, g())
+}
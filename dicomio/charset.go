@@ -2,8 +2,9 @@ package dicomio
 
 import (
 	"fmt"
+	"strings"
 
-	"github.com/sirupsen/logrus"
+	"github.com/odincare/odicom/dicomlog"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/htmlindex"
 )
@@ -19,6 +20,22 @@ type CodingSystem struct {
 	Alphabetic  *encoding.Decoder
 	Ideographic *encoding.Decoder
 	Phonetic    *encoding.Decoder
+
+	// encode 把utf-8转换成这个coding system的bytes, nil表示不转换 (ASCII或utf-8)
+	encode func(s string, personName bool) ([]byte, error)
+}
+
+// Encode converts the UTF-8 string "s" to the character set of the coding
+// system, for writing a value of a VR affected by SpecificCharacterSet (SH,
+// LO, ST, LT, UT, UC and PN). If personName is true, "s" is a PN value, and
+// the code extensions are reset at the '^' and '=' delimiters, P3.5
+// 6.1.2.5.3. It returns an error if "s" has a character that the character
+// set can't represent.
+func (cs CodingSystem) Encode(s string, personName bool) ([]byte, error) {
+	if cs.encode == nil {
+		return []byte(s), nil
+	}
+	return cs.encode(s, personName)
 }
 
 // CodingSystemType定义了哪一个coding system将会被使用，这个区别在日语中好用，但在其他语言不好用 = =
@@ -35,41 +52,30 @@ const (
 )
 
 // Mapping DICOM charset name to golang encoding/htmlindex name.  "" 为 7bit ascii.
+// 使用code extensions的"ISO 2022 ..."见iso2022Charsets
 var htmlEncodingNames = map[string]string{
-	"ISO 2022 IR 6":   "iso-8859-1",
-	"ISO_IR 13":       "shift_jis",
-	"ISO 2022 IR 13":  "shift_jis",
-	"ISO_IR 100":      "iso-8859-1",
-	"ISO 2022 IR 100": "iso-8859-1",
-	"ISO_IR 101":      "iso-8859-2",
-	"ISO 2022 IR 101": "iso-8859-2",
-	"ISO_IR 109":      "iso-8859-3",
-	"ISO 2022 IR 109": "iso-8859-3",
-	"ISO_IR 110":      "iso-8859-4",
-	"ISO 2022 IR 110": "iso-8859-4",
-	"ISO_IR 126":      "iso-ir-126",
-	"ISO 2022 IR 126": "iso-ir-126",
-	"ISO_IR 127":      "iso-ir-127",
-	"ISO 2022 IR 127": "iso-ir-127",
-	"ISO_IR 138":      "iso-ir-138",
-	"ISO 2022 IR 138": "iso-ir-138",
-	"ISO_IR 144":      "iso-ir-144",
-	"ISO 2022 IR 144": "iso-ir-144",
-	"ISO_IR 148":      "iso-ir-148",
-	"ISO 2022 IR 148": "iso-ir-148",
-	"ISO 2022 IR 149": "euc-kr",
-	"ISO 2022 IR 159": "iso-2022-jp",
-	"ISO_IR 166":      "iso-ir-166",
-	"ISO 2022 IR 166": "iso-ir-166",
-	"ISO 2022 IR 87":  "iso-2022-jp",
-	"ISO_IR 192":      "utf-8",
-	"GB18030":         "utf-8",
+	"ISO_IR 13":  "shift_jis",
+	"ISO_IR 100": "iso-8859-1",
+	"ISO_IR 101": "iso-8859-2",
+	"ISO_IR 109": "iso-8859-3",
+	"ISO_IR 110": "iso-8859-4",
+	"ISO_IR 126": "iso-ir-126",
+	"ISO_IR 127": "iso-ir-127",
+	"ISO_IR 138": "iso-ir-138",
+	"ISO_IR 144": "iso-ir-144",
+	"ISO_IR 148": "iso-ir-148",
+	"ISO_IR 166": "tis-620",
+	"ISO_IR 192": "utf-8",
+	"GB18030":    "gb18030",
 }
 
 // ParseSpecificCharacterSet 覆盖DICOM character的编码名，
 // 如”ISO-IR 100“ 用golang的解码器解码会为nil， nil是（7比特ASCII解码的）默认值
 // 详情见 Cf. p3.2
 // D.6.2  http://dicom.nema.org/medical/dicom/2016d/output/chtml/part02/sect_D.6.2.html
+//
+// 多个值或者"ISO 2022 ..."表示使用code extensions, 例如"\ISO 2022 IR 149" (韩文) 和
+// "\ISO 2022 IR 58" (GB 2312): 字符串里的escape sequences切换character set, P3.5 6.1.2.5
 func ParseSpecificCharacterSet(encodingNames []string) (CodingSystem, error) {
 	// 将剩余文件设为[]byte->string decoder
 	// It's sad that SpecificCharacterSet isn't part
@@ -81,40 +87,45 @@ func ParseSpecificCharacterSet(encodingNames []string) (CodingSystem, error) {
 	// if err != nil {
 	// return CodingSystem{}, err
 	// }
-	var decoders []*encoding.Decoder
-
-	for _, name := range encodingNames {
-		var c *encoding.Decoder
-		logrus.Warnf("io.ParseSpecificCharacterSet: Using coding system %s", name)
-
-		if htmlName, ok := htmlEncodingNames[name]; !ok {
-			// TODO 支持更多encodings
-			return CodingSystem{}, fmt.Errorf("io.ParseSpecificCharacterSet: Unknown character set '%s'. Assuming utf-8", encodingNames[0])
-		} else {
-			if htmlName != "" {
-				d, err := htmlindex.Get(htmlName)
-				if err != nil {
-					logrus.Panic(fmt.Sprintf("Encoding name %s (for %s) not found", name, htmlName))
-				}
-
-				c = d.NewDecoder()
-			}
+	if len(encodingNames) > 1 || (len(encodingNames) == 1 && strings.HasPrefix(encodingNames[0], "ISO 2022")) {
+		// 使用code extensions (ISO 2022 escape sequences) 的character sets, P3.5 6.1.2.5
+		iso2022, err := newISO2022CodingSystem(encodingNames)
+		if err != nil {
+			return CodingSystem{}, err
 		}
-
-		decoders = append(decoders, c)
+		dicomlog.V(1, "io.ParseSpecificCharacterSet: using code extensions", dicomlog.Fields{"charset": iso2022.String()})
+		d := &encoding.Decoder{Transformer: iso2022Transformer{iso2022}}
+		return CodingSystem{Alphabetic: d, Ideographic: d, Phonetic: d, encode: iso2022.encode}, nil
 	}
 
-	if len(decoders) == 0 {
-		return CodingSystem{nil, nil, nil}, nil
+	if len(encodingNames) == 0 || strings.TrimSpace(encodingNames[0]) == "" {
+		return CodingSystem{}, nil
 	}
-
-	if len(decoders) == 1 {
-		return CodingSystem{decoders[0], decoders[0], decoders[0]}, nil
+	name := encodingNames[0]
+	dicomlog.V(1, "io.ParseSpecificCharacterSet: using coding system", dicomlog.Fields{"charset": name})
+	htmlName, ok := htmlEncodingNames[name]
+	if !ok {
+		// TODO 支持更多encodings
+		return CodingSystem{}, fmt.Errorf("io.ParseSpecificCharacterSet: Unknown character set '%s'. Assuming utf-8", name)
 	}
-
-	if len(decoders) == 2 {
-		return CodingSystem{decoders[0], decoders[1], decoders[1]}, nil
+	enc, err := htmlindex.Get(htmlName)
+	if err != nil {
+		return CodingSystem{}, fmt.Errorf("io.ParseSpecificCharacterSet: encoding name %s (for %s) not found", name, htmlName)
 	}
-
-	return CodingSystem{decoders[0], decoders[1], decoders[2]}, nil
+	d := enc.NewDecoder()
+	if htmlName == "utf-8" {
+		return CodingSystem{Alphabetic: d, Ideographic: d, Phonetic: d}, nil
+	}
+	return CodingSystem{
+		Alphabetic:  d,
+		Ideographic: d,
+		Phonetic:    d,
+		encode: func(s string, personName bool) ([]byte, error) {
+			data, err := enc.NewEncoder().Bytes([]byte(s))
+			if err != nil {
+				return nil, fmt.Errorf("%q can't be encoded in %s: %v", s, name, err)
+			}
+			return data, nil
+		},
+	}, nil
 }
@@ -0,0 +1,76 @@
+package dicomio_test
+
+import (
+	"testing"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeExtensions(t *testing.T) {
+	// P3.5 Annex H, I, K的例子
+	for _, test := range []struct {
+		charset []string
+		encoded string
+		decoded string
+	}{
+		{[]string{"", "ISO 2022 IR 149"},
+			"Hong^Gildong=\x1b$)C\xfb\xf3^\x1b$)C\xd1\xce\xd4\xd7=\x1b$)C\xc8\xab^\x1b$)C\xb1\xe6\xb5\xbf",
+			"Hong^Gildong=洪^吉洞=홍^길동"},
+		{[]string{"", "ISO 2022 IR 58"},
+			"Zhang^XiaoDong=\x1b$)A\xd5\xc5^\x1b$)A\xd0\xa1\xb6\xab=",
+			"Zhang^XiaoDong=张^小东="},
+		{[]string{"", "ISO 2022 IR 87"},
+			"Yamada^Tarou=\x1b$B;3ED\x1b(B^\x1b$BB@O:\x1b(B=\x1b$B$d$^$@\x1b(B^\x1b$B$?$m$&\x1b(B",
+			"Yamada^Tarou=山田^太郎=やまだ^たろう"},
+		{[]string{"ISO 2022 IR 13", "ISO 2022 IR 87"},
+			"\xd4\xcf\xc0\xde^\xc0\xdb\xb3=\x1b$B;3ED\x1b(J^\x1b$BB@O:\x1b(J=\x1b$B$d$^$@\x1b(J^\x1b$B$?$m$&\x1b(J",
+			"ﾔﾏﾀﾞ^ﾀﾛｳ=山田^太郎=やまだ^たろう"},
+		{[]string{"ISO 2022 IR 100"}, "Buc^J\xe9r\xf4me", "Buc^Jérôme"},
+	} {
+		cs, err := dicomio.ParseSpecificCharacterSet(test.charset)
+		require.NoError(t, err, test.charset)
+		decoded, err := cs.Ideographic.String(test.encoded)
+		require.NoError(t, err, test.charset)
+		require.Equal(t, test.decoded, decoded, test.charset)
+
+		encoded, err := cs.Encode(test.decoded, true)
+		require.NoError(t, err, test.charset)
+		require.Equal(t, test.encoded, string(encoded), test.charset)
+	}
+
+	// 不是PN的时候'^'和'='不是分隔符
+	cs, err := dicomio.ParseSpecificCharacterSet([]string{"", "ISO 2022 IR 149"})
+	require.NoError(t, err)
+	encoded, err := cs.Encode("홍^길동", false)
+	require.NoError(t, err)
+	require.Equal(t, "\x1b$)C\xc8\xab^\xb1\xe6\xb5\xbf", string(encoded))
+
+	// GB 2312没有的字符
+	cs, err = dicomio.ParseSpecificCharacterSet([]string{"", "ISO 2022 IR 58"})
+	require.NoError(t, err)
+	_, err = cs.Encode("홍", true)
+	require.Error(t, err)
+
+	_, err = dicomio.ParseSpecificCharacterSet([]string{"", "ISO_IR 192"})
+	require.Error(t, err)
+}
+
+func TestSingleCharacterSet(t *testing.T) {
+	cs, err := dicomio.ParseSpecificCharacterSet([]string{"ISO_IR 100"})
+	require.NoError(t, err)
+	encoded, err := cs.Encode("Buc^Jérôme", true)
+	require.NoError(t, err)
+	require.Equal(t, "Buc^J\xe9r\xf4me", string(encoded))
+	_, err = cs.Encode("张", true)
+	require.Error(t, err)
+
+	// 没有SpecificCharacterSet和utf-8时不转换
+	for _, charset := range [][]string{nil, {""}, {"ISO_IR 192"}} {
+		cs, err := dicomio.ParseSpecificCharacterSet(charset)
+		require.NoError(t, err)
+		encoded, err := cs.Encode("张^三", true)
+		require.NoError(t, err)
+		require.Equal(t, "张^三", string(encoded))
+	}
+}
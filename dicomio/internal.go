@@ -1,7 +1,98 @@
 package dicomio
 
+import (
+	"fmt"
+	"sync/atomic"
+)
+
 func doassert(x bool) {
 	if !x {
 		panic("doassert")
 	}
 }
+
+// AssertionError is the panic value of a failed internal assertion, see
+// DoAssert. In panic-free mode it is returned as an error instead, see
+// SetPanicFree.
+type AssertionError struct {
+	Message string
+}
+
+func (e *AssertionError) Error() string {
+	return "dicomio: assertion failed: " + e.Message
+}
+
+// panicFree 不为0时, Recover把panic变成error
+var panicFree = int32(0)
+
+// SetPanicFree turns panic-free mode on or off. It is off by default, so
+// that internal errors panic with a full stack trace. In panic-free mode,
+// the reading and writing functions of package dicom (ReadDataSet,
+// NewParser, Parser.Next, ReadElement, ParseFileHeader, WriteDataSet,
+// WriteFileHeader, WriteElement, ...), Transcode, and the DataSet methods
+// that decode and encode frames recover from panics, including failed
+// assertions and panics in registered codecs, and return them as errors,
+// possibly with a partial result. Use it in services that must not crash
+// on a bad input. The Must* functions, which panic by design, still panic.
+// Thread safe.
+func SetPanicFree(on bool) {
+	v := int32(0)
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&panicFree, v)
+}
+
+// PanicFree reports whether panic-free mode is on, see SetPanicFree.
+// Thread safe.
+func PanicFree() bool {
+	return atomic.LoadInt32(&panicFree) != 0
+}
+
+// Recover must be deferred directly by a function that returns an error:
+//
+//  func F() (err error) {
+//    defer dicomio.Recover(&err)
+//    ...
+//  }
+//
+// In panic-free mode, it recovers from a panic and stores it in *err; a
+// failed assertion is stored as its *AssertionError. Otherwise it does
+// nothing, and the panic continues.
+func Recover(err *error) {
+	if !PanicFree() {
+		return
+	}
+	if r := recover(); r != nil {
+		*err = panicError(r)
+	}
+}
+
+// RecoverEncoder is similar to Recover, but reports the panic through
+// e.SetError.
+func RecoverEncoder(e *Encoder) {
+	if !PanicFree() {
+		return
+	}
+	if r := recover(); r != nil {
+		e.SetError(panicError(r))
+	}
+}
+
+// RecoverDecoder is similar to Recover, but reports the panic through
+// d.SetError.
+func RecoverDecoder(d *Decoder) {
+	if !PanicFree() {
+		return
+	}
+	if r := recover(); r != nil {
+		d.SetError(panicError(r))
+	}
+}
+
+func panicError(r interface{}) error {
+	if err, ok := r.(*AssertionError); ok {
+		return err
+	}
+	return fmt.Errorf("dicomio: recovered from panic: %v", r)
+}
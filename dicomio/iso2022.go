@@ -0,0 +1,277 @@
+package dicomio
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+// iso2022Charset 是一个可以用ISO 2022 escape sequence切换的character set.
+// 详情见 P3.3 C.12.1.1.2 和 P3.5 6.1.2.5
+type iso2022Charset struct {
+	// name 是SpecificCharacterSet里的defined term
+	name string
+	// escape 是designate这个character set的escape sequence
+	escape string
+	// g1 为true时字符在GR (0xa0-0xff) 里, 否则在G0 (0x21-0x7e) 里
+	g1 bool
+	// width 是每个字符的bytes数
+	width int
+	// enc 为nil表示ASCII
+	enc encoding.Encoding
+}
+
+var (
+	iso2022ASCII  = &iso2022Charset{"ISO 2022 IR 6", "\x1b(B", false, 1, nil}
+	iso2022Romaji = &iso2022Charset{"ISO 2022 IR 13", "\x1b(J", false, 1, nil}
+)
+
+// iso2022Charsets 按SpecificCharacterSet的defined term列出character sets.
+// ISO 2022 IR 13 designate两个: G0的JIS X 0201 romaji和G1的katakana
+var iso2022Charsets = []*iso2022Charset{
+	iso2022ASCII,
+	iso2022Romaji,
+	{"ISO 2022 IR 13", "\x1b)I", true, 1, japanese.ShiftJIS},
+	{"ISO 2022 IR 100", "\x1b-A", true, 1, charmap.ISO8859_1},
+	{"ISO 2022 IR 101", "\x1b-B", true, 1, charmap.ISO8859_2},
+	{"ISO 2022 IR 109", "\x1b-C", true, 1, charmap.ISO8859_3},
+	{"ISO 2022 IR 110", "\x1b-D", true, 1, charmap.ISO8859_4},
+	{"ISO 2022 IR 144", "\x1b-L", true, 1, charmap.ISO8859_5},
+	{"ISO 2022 IR 127", "\x1b-G", true, 1, charmap.ISO8859_6},
+	{"ISO 2022 IR 126", "\x1b-F", true, 1, charmap.ISO8859_7},
+	{"ISO 2022 IR 138", "\x1b-H", true, 1, charmap.ISO8859_8},
+	{"ISO 2022 IR 148", "\x1b-M", true, 1, charmap.ISO8859_9},
+	{"ISO 2022 IR 166", "\x1b-T", true, 1, charmap.Windows874},
+	{"ISO 2022 IR 87", "\x1b$B", false, 2, japanese.ISO2022JP},
+	{"ISO 2022 IR 159", "\x1b$(D", false, 2, japanese.ISO2022JP},
+	// KS X 1001和GB 2312在GR里的编码就是EUC-KR和EUC-CN (GBK的子集)
+	{"ISO 2022 IR 149", "\x1b$)C", true, 2, korean.EUCKR},
+	{"ISO 2022 IR 58", "\x1b$)A", true, 2, simplifiedchinese.GBK},
+}
+
+// iso2022CodingSystem 处理使用code extensions的SpecificCharacterSet,
+// 例如韩国的"\ISO 2022 IR 149"和中国的"\ISO 2022 IR 58"
+type iso2022CodingSystem struct {
+	// initialG0, initialG1 是value 1 designate的character sets. 每个值
+	// 和每个PN component都从这个状态开始
+	initialG0, initialG1 *iso2022Charset
+	// charsets 是SpecificCharacterSet里所有的character sets, 编码时按这个顺序尝试
+	charsets []*iso2022Charset
+}
+
+// newISO2022CodingSystem 解析使用code extensions的SpecificCharacterSet values.
+// value 1为空表示默认的ASCII
+func newISO2022CodingSystem(names []string) (*iso2022CodingSystem, error) {
+	cs := &iso2022CodingSystem{initialG0: iso2022ASCII}
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("io.ParseSpecificCharacterSet: empty value %d in '%s'", i+1, strings.Join(names, "\\"))
+		}
+		// 有些文件在code extensions里用"ISO_IR 100"代替"ISO 2022 IR 100"
+		name = strings.Replace(name, "ISO_IR ", "ISO 2022 IR ", 1)
+		found := false
+		for _, c := range iso2022Charsets {
+			if c.name != name {
+				continue
+			}
+			found = true
+			cs.charsets = append(cs.charsets, c)
+			if i == 0 {
+				// value 1不能是G0的multi-byte character set, P3.3 C.12.1.1.2
+				if c.g1 {
+					cs.initialG1 = c
+				} else if c.width == 1 {
+					cs.initialG0 = c
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("io.ParseSpecificCharacterSet: character set '%s' can't be used with code extensions", name)
+		}
+	}
+	return cs, nil
+}
+
+// findEscape 返回data开头的escape sequence designate的character set
+func findEscape(data []byte) *iso2022Charset {
+	for _, c := range iso2022Charsets {
+		if strings.HasPrefix(string(data), c.escape) {
+			return c
+		}
+	}
+	return nil
+}
+
+// decode 把data转换成utf-8. 不认识的escape sequence和解码失败的bytes原样保留
+func (cs *iso2022CodingSystem) decode(data []byte) []byte {
+	g0, g1 := cs.initialG0, cs.initialG1
+	var out []byte
+	for len(data) > 0 {
+		if data[0] == 0x1b {
+			if c := findEscape(data); c != nil {
+				if c.g1 {
+					g1 = c
+				} else {
+					g0 = c
+				}
+				data = data[len(c.escape):]
+				continue
+			}
+		}
+		// 到下一个escape sequence或者GL/GR切换之前的bytes用同一个character set
+		high := data[0] >= 0x80
+		n := 1
+		for n < len(data) && data[n] != 0x1b && (data[n] >= 0x80) == high {
+			n++
+		}
+		run := data[:n]
+		data = data[n:]
+
+		c := g0
+		if high {
+			c = g1
+		}
+		if c == nil || c.enc == nil {
+			out = append(out, run...)
+			continue
+		}
+		if !c.g1 {
+			// ISO2022JP的decoder自己处理escape sequence
+			run = append([]byte(c.escape), run...)
+		}
+		decoded, err := c.enc.NewDecoder().Bytes(run)
+		if err != nil {
+			decoded = run
+		}
+		out = append(out, decoded...)
+	}
+	return out
+}
+
+// encodeChar 把一个字符编码成c的bytes (不含escape sequence). 不能编码时返回nil
+func (c *iso2022Charset) encodeChar(r rune) []byte {
+	if c.enc == nil {
+		if r < 0x80 {
+			return []byte{byte(r)}
+		}
+		return nil
+	}
+	encoded, err := c.enc.NewEncoder().Bytes([]byte(string(r)))
+	if err != nil {
+		return nil
+	}
+	if !c.g1 {
+		// ISO2022JP输出"ESC $ B xx xx ESC ( B"
+		if !strings.HasPrefix(string(encoded), c.escape) {
+			return nil
+		}
+		encoded = []byte(strings.TrimSuffix(strings.TrimPrefix(string(encoded), c.escape), iso2022ASCII.escape))
+	}
+	if len(encoded) != c.width {
+		return nil
+	}
+	for _, b := range encoded {
+		// EUC-KR和GBK的encoder也会输出KS X 1001和GB 2312以外的扩展字符
+		if c.g1 && (b < 0xa0 || c.width == 2 && b == 0xa0) || !c.g1 && (b < 0x21 || b > 0x7e) {
+			return nil
+		}
+	}
+	return encoded
+}
+
+// isDelimiter 返回r是否是需要回到初始character set的分隔符, P3.5 6.1.2.5.3
+func isDelimiter(r rune, personName bool) bool {
+	switch r {
+	case '\\', '\r', '\n', '\f', '\t':
+		return true
+	case '^', '=':
+		return personName
+	}
+	return false
+}
+
+// encode 把utf-8的s转换成bytes, 需要时插入escape sequences. ASCII字符, 每个
+// 分隔符前和s的结尾都回到初始的G0 (value 1一定是ASCII或JIS X 0201 romaji)
+func (cs *iso2022CodingSystem) encode(s string, personName bool) ([]byte, error) {
+	g0, g1 := cs.initialG0, cs.initialG1
+	var out []byte
+	for _, r := range s {
+		if r < 0x80 {
+			if g0 != cs.initialG0 {
+				out = append(out, cs.initialG0.escape...)
+				g0 = cs.initialG0
+			}
+			if isDelimiter(r, personName) {
+				g1 = cs.initialG1
+			}
+			out = append(out, byte(r))
+			continue
+		}
+		// 先试当前的character sets, 再按SpecificCharacterSet的顺序试
+		var encoded []byte
+		var charset *iso2022Charset
+		for _, c := range append([]*iso2022Charset{g0, g1}, cs.charsets...) {
+			if c == nil {
+				continue
+			}
+			if encoded = c.encodeChar(r); encoded != nil {
+				charset = c
+				break
+			}
+		}
+		if charset == nil {
+			return nil, fmt.Errorf("character %q can't be encoded in %s", r, cs.String())
+		}
+		if charset.g1 && charset != g1 {
+			out = append(out, charset.escape...)
+			g1 = charset
+		} else if !charset.g1 && charset != g0 {
+			out = append(out, charset.escape...)
+			g0 = charset
+		}
+		out = append(out, encoded...)
+	}
+	if g0 != cs.initialG0 {
+		out = append(out, cs.initialG0.escape...)
+	}
+	return out, nil
+}
+
+func (cs *iso2022CodingSystem) String() string {
+	var names []string
+	for _, c := range cs.charsets {
+		if len(names) == 0 || names[len(names)-1] != c.name {
+			names = append(names, c.name)
+		}
+	}
+	return strings.Join(names, "\\")
+}
+
+// iso2022Transformer 是iso2022CodingSystem.decode的transform.Transformer.
+// 它需要一次得到整个值, encoding.Decoder.Bytes和String就是这样调用的
+type iso2022Transformer struct {
+	cs *iso2022CodingSystem
+}
+
+func (t iso2022Transformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if !atEOF {
+		return 0, 0, transform.ErrShortSrc
+	}
+	out := t.cs.decode(src)
+	if len(dst) < len(out) {
+		return 0, 0, transform.ErrShortDst
+	}
+	return copy(dst, out), len(src), nil
+}
+
+func (t iso2022Transformer) Reset() {}
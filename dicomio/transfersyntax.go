@@ -19,8 +19,6 @@ var StandardTransferSyntaxes = []string{
 // given an UID that represents any transfer syntax. Returns an error if
 // the uid is not defined in DICOM standard, or if the uid does not represent
 // a transfer syntax
-// TODO check the standard to see if we need to accept unknown UIDS
-// as explicit little endian.
 func CanonicalTransferSyntaxUID(uid string) (string, error) {
 
 	// defaults are explicit VR, little endian
@@ -33,7 +31,7 @@ func CanonicalTransferSyntaxUID(uid string) (string, error) {
 	default:
 		e, err := dicomuid.Lookup(uid)
 		if err != nil {
-			return "", nil
+			return "", fmt.Errorf("dicom.CanonicalTransferSyntaxUID: unknown transfer syntax '%s'", uid)
 		}
 
 		if e.Type != dicomuid.TypeTransferSyntax {
@@ -50,6 +48,9 @@ func CanonicalTransferSyntaxUID(uid string) (string, error) {
 // a transfer syntax. It can be, e.g.
 // 1.2.840.1008.1.2(it will return (LittleEndian, ImplicitVR))
 // or 1.2.840.1008.1.2.4.54(it will return (LittleEndian, ExplicitVR))
+// Deflated Explicit VR Little Endian returns (LittleEndian, ExplicitVR) too;
+// Decoder.PushTransferSyntaxByUID and Encoder.PushTransferSyntaxByUID also
+// inflate and deflate the data.
 func ParseTransferSyntaxUID(uid string) (byteorder binary.ByteOrder, implicit IsImplicitVR, err error) {
 
 	canonical, err := CanonicalTransferSyntaxUID(uid)
@@ -60,13 +61,10 @@ func ParseTransferSyntaxUID(uid string) (byteorder binary.ByteOrder, implicit Is
 	switch canonical {
 	case dicomuid.ImplicitVRLittleEndian:
 		return binary.LittleEndian, ImplicitVR, nil
-	case dicomuid.DeflatedExplicitVRLittleEndian:
-		fallthrough
-	case dicomuid.ExplicitVRLittleEndian:
-		return binary.LittleEndian, ExplicitVR, nil
 	case dicomuid.ExplicitVRBigEndian:
 		return binary.BigEndian, ExplicitVR, nil
 	default:
-		panic(fmt.Sprintf("Invalid transfer syntax: %v, %v", canonical, uid))
+		// ExplicitVRLittleEndian和DeflatedExplicitVRLittleEndian
+		return binary.LittleEndian, ExplicitVR, nil
 	}
 }
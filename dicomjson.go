@@ -0,0 +1,496 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// JSONOptions controls MarshalJSONWithOptions and UnmarshalJSONWithOptions.
+// The zero value is the same as MarshalJSON and UnmarshalJSON.
+type JSONOptions struct {
+	// BulkDataURI 如果不为nil, 对binary VR (OB, OD, OF, OL, OV, OW, UN) 的element和
+	// PixelData调用, 返回的URI写成BulkDataURI. 返回""时value写成InlineBinary.
+	// path与DataSet.Walk的path相同
+	BulkDataURI func(path []dicomtag.Tag, elem *Element) string
+
+	// BulkData 如果不为nil, UnmarshalJSONWithOptions用它读取BulkDataURI指向的value,
+	// 例如STOW-RS multipart请求中的一个part. 为nil时BulkDataURI是错误
+	BulkData func(path []dicomtag.Tag, uri string) ([]byte, error)
+}
+
+// jsonAttribute 是DICOM JSON model中的一个attribute (P3.18 F.2.2)
+type jsonAttribute struct {
+	VR           string        `json:"vr"`
+	Value        []interface{} `json:"Value,omitempty"`
+	InlineBinary string        `json:"InlineBinary,omitempty"`
+	BulkDataURI  string        `json:"BulkDataURI,omitempty"`
+}
+
+// jsonBinaryVRs 的value写成InlineBinary或BulkDataURI
+var jsonBinaryVRs = map[string]bool{
+	"OB": true, "OD": true, "OF": true, "OL": true, "OV": true, "OW": true, "UN": true,
+}
+
+// jsonPNGroups 是PN的三个component group在JSON中的名称
+var jsonPNGroups = [...]string{"Alphabetic", "Ideographic", "Phonetic"}
+
+// MarshalJSON encodes "ds" in the DICOM JSON model (P3.18 Annex F), as
+// DICOMweb services expect. Attributes are keyed by their tag as 8 hex
+// digits, e.g., "00100010". The file meta group and group lengths are
+// omitted. Binary values are inlined in base64; encapsulated PixelData can't
+// be, see MarshalJSONWithOptions.
+func MarshalJSON(ds *DataSet) ([]byte, error) {
+	return MarshalJSONWithOptions(ds, JSONOptions{})
+}
+
+// MarshalJSONWithOptions is the same as MarshalJSON, but binary values may
+// be replaced with URIs, as configured by "options".
+func MarshalJSONWithOptions(ds *DataSet, options JSONOptions) ([]byte, error) {
+	m := &jsonMarshaler{options: options, byteOrder: dataSetByteOrder(ds)}
+	var elems []*Element
+	for _, elem := range ds.Elements {
+		if elem.Tag.Group != dicomtag.MetadataGroup {
+			elems = append(elems, elem)
+		}
+	}
+	obj, err := m.object(nil, elems)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
+
+type jsonMarshaler struct {
+	options   JSONOptions
+	byteOrder binary.ByteOrder // PixelData的byte order
+}
+
+// object 返回一个data set或item的JSON object. json.Marshal按key排序, 也就是按tag排序
+func (m *jsonMarshaler) object(path []dicomtag.Tag, elems []*Element) (map[string]*jsonAttribute, error) {
+	obj := map[string]*jsonAttribute{}
+	for _, elem := range elems {
+		if elem.Tag.Element == 0 {
+			continue // group length
+		}
+		attr, err := m.attribute(append(path, elem.Tag), elem)
+		if err != nil {
+			return nil, err
+		}
+		obj[fmt.Sprintf("%04X%04X", elem.Tag.Group, elem.Tag.Element)] = attr
+	}
+	return obj, nil
+}
+
+func (m *jsonMarshaler) attribute(path []dicomtag.Tag, elem *Element) (*jsonAttribute, error) {
+	attr := &jsonAttribute{VR: elem.VR}
+	if elem.Tag == dicomtag.PixelData || jsonBinaryVRs[elem.VR] {
+		if m.options.BulkDataURI != nil {
+			if uri := m.options.BulkDataURI(path, elem); uri != "" {
+				attr.BulkDataURI = uri
+				return attr, nil
+			}
+		}
+		data, err := littleEndianBytes(elem, m.byteOrder)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.MarshalJSON: %s: %v", dicomtag.DebugString(elem.Tag), err)
+		}
+		attr.InlineBinary = base64.StdEncoding.EncodeToString(data)
+		return attr, nil
+	}
+	if elem.VR == "SQ" {
+		attr.Value = []interface{}{}
+		for _, value := range elem.Value {
+			item, ok := value.(*Element)
+			if !ok {
+				return nil, fmt.Errorf("dicom.MarshalJSON: %s: sequence value is not an item: %v", dicomtag.DebugString(elem.Tag), value)
+			}
+			obj, err := m.object(path, itemElements(item))
+			if err != nil {
+				return nil, err
+			}
+			attr.Value = append(attr.Value, obj)
+		}
+		return attr, nil
+	}
+	if len(elem.Value) == 1 && elem.Value[0] == "" {
+		return attr, nil // 空value没有"Value" (P3.18 F.2.5)
+	}
+	for _, value := range elem.Value {
+		v, err := jsonValue(elem.VR, value)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.MarshalJSON: %s: %v", dicomtag.DebugString(elem.Tag), err)
+		}
+		attr.Value = append(attr.Value, v)
+	}
+	return attr, nil
+}
+
+// jsonValue 返回一个非binary, 非SQ的value在JSON中的表示. 空字符串是null
+func jsonValue(vr string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		switch vr {
+		case "DS":
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, err
+			}
+			return f, nil
+		case "IS":
+			return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		case "PN":
+			// component groups: Alphabetic=Ideographic=Phonetic
+			name := map[string]string{}
+			for i, group := range strings.SplitN(v, "=", 3) {
+				if group != "" {
+					name[jsonPNGroups[i]] = group
+				}
+			}
+			return name, nil
+		}
+		return v, nil
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, fmt.Errorf("%v can't be encoded in JSON", v)
+		}
+		return v, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%v can't be encoded in JSON", v)
+		}
+		return v, nil
+	case uint16, int16, uint32, int32, uint64, int64:
+		return v, nil
+	case dicomtag.Tag:
+		return fmt.Sprintf("%04X%04X", v.Group, v.Element), nil
+	}
+	return nil, fmt.Errorf("unexpected value %v for VR %s", value, vr)
+}
+
+// jsonInputAttribute 是UnmarshalJSON读到的attribute. Value的每个元素按VR解析
+type jsonInputAttribute struct {
+	VR           string            `json:"vr"`
+	Value        []json.RawMessage `json:"Value"`
+	InlineBinary *string           `json:"InlineBinary"`
+	BulkDataURI  *string           `json:"BulkDataURI"`
+}
+
+// UnmarshalJSON parses a data set in the DICOM JSON model (P3.18 Annex F),
+// e.g., a QIDO-RS result or STOW-RS metadata. Binary values must be
+// InlineBinary; see UnmarshalJSONWithOptions for BulkDataURI.
+//
+// Binary values are taken as Explicit VR Little Endian, and PixelData as
+// native. If the data set has SOPClassUID and SOPInstanceUID, the file meta
+// elements needed by WriteDataSet are added, with the Explicit VR Little
+// Endian transfer syntax.
+func UnmarshalJSON(data []byte) (*DataSet, error) {
+	return UnmarshalJSONWithOptions(data, JSONOptions{})
+}
+
+// UnmarshalJSONWithOptions is the same as UnmarshalJSON, but BulkDataURI
+// values are read with options.BulkData.
+func UnmarshalJSONWithOptions(data []byte, options JSONOptions) (*DataSet, error) {
+	var obj map[string]jsonInputAttribute
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("dicom.UnmarshalJSON: %v", err)
+	}
+	u := &jsonUnmarshaler{options: options}
+	elems, err := u.elements(nil, obj)
+	if err != nil {
+		return nil, err
+	}
+	ds := &DataSet{Elements: elems}
+	if err := addModelFileMeta(ds); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// addModelFileMeta 根据SOPClassUID和SOPInstanceUID加上file meta elements.
+// 用于JSON和XML model, 它们不包括file meta group
+func addModelFileMeta(ds *DataSet) error {
+	sopClass, err := ds.FindElementByTag(dicomtag.SOPClassUID)
+	if err != nil {
+		return nil
+	}
+	sopInstance, err := ds.FindElementByTag(dicomtag.SOPInstanceUID)
+	if err != nil {
+		return nil
+	}
+	for _, elem := range []*Element{
+		{Tag: dicomtag.MediaStorageSOPClassUID, VR: "UI", Value: sopClass.Value},
+		{Tag: dicomtag.MediaStorageSOPInstanceUID, VR: "UI", Value: sopInstance.Value},
+		MustNewElement(dicomtag.TransferSyntaxUID, dicomuid.ExplicitVRLittleEndian),
+	} {
+		if _, err := ds.FindElementByTag(elem.Tag); err != nil {
+			ds.setElement(elem)
+		}
+	}
+	return nil
+}
+
+type jsonUnmarshaler struct {
+	options JSONOptions
+}
+
+// elements 返回一个JSON object (data set或item) 的elements, 按tag排序
+func (u *jsonUnmarshaler) elements(path []dicomtag.Tag, obj map[string]jsonInputAttribute) ([]*Element, error) {
+	var elems []*Element
+	for key, attr := range obj {
+		tag, err := parseJSONTag(key)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.UnmarshalJSON: %v", err)
+		}
+		elem, err := u.element(append(path, tag), tag, attr)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	sort.Slice(elems, func(i, j int) bool { return elems[i].Tag.Compare(elems[j].Tag) < 0 })
+	return elems, nil
+}
+
+// parseJSONTag 解析"GGGGEEEE"格式的tag
+func parseJSONTag(s string) (dicomtag.Tag, error) {
+	if len(s) != 8 {
+		return dicomtag.Tag{}, fmt.Errorf("invalid tag %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return dicomtag.Tag{}, fmt.Errorf("invalid tag %q", s)
+	}
+	return dicomtag.Tag{Group: uint16(v >> 16), Element: uint16(v)}, nil
+}
+
+func (u *jsonUnmarshaler) element(path []dicomtag.Tag, tag dicomtag.Tag, attr jsonInputAttribute) (*Element, error) {
+	elem := &Element{Tag: tag, VR: attr.VR}
+	if elem.VR == "" {
+		if info, err := dicomtag.Find(tag); err == nil {
+			elem.VR = info.VR
+		} else {
+			elem.VR = "UN"
+		}
+	}
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("dicom.UnmarshalJSON: %s: %s", dicomtag.DebugString(tag), fmt.Sprintf(format, args...))
+	}
+
+	if attr.InlineBinary != nil || attr.BulkDataURI != nil {
+		var data []byte
+		var err error
+		if attr.InlineBinary != nil {
+			data, err = base64.StdEncoding.DecodeString(*attr.InlineBinary)
+		} else if u.options.BulkData == nil {
+			err = fmt.Errorf("BulkDataURI %s, but JSONOptions.BulkData is nil", *attr.BulkDataURI)
+		} else {
+			data, err = u.options.BulkData(path, *attr.BulkDataURI)
+		}
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		if err := setBinaryValue(elem, data); err != nil {
+			return nil, errorf("%v", err)
+		}
+		return elem, nil
+	}
+
+	for _, raw := range attr.Value {
+		var v interface{}
+		var err error
+		switch elem.VR {
+		case "SQ":
+			var obj map[string]jsonInputAttribute
+			if err = json.Unmarshal(raw, &obj); err == nil {
+				var elems []*Element
+				if elems, err = u.elements(path, obj); err != nil {
+					return nil, err
+				}
+				v = NewItem(elems...)
+			}
+		case "PN":
+			v, err = jsonPersonName(raw)
+		case "AT":
+			var s string
+			if err = json.Unmarshal(raw, &s); err == nil {
+				v, err = parseJSONTag(s)
+			}
+		case "US", "SS", "UL", "SL", "UV", "SV", "FL", "FD", "DS", "IS":
+			v, err = jsonNumber(elem.VR, raw)
+		default:
+			var s *string
+			if err = json.Unmarshal(raw, &s); err == nil {
+				v = ""
+				if s != nil {
+					v = *s
+				}
+			}
+		}
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		elem.Value = append(elem.Value, v)
+	}
+	return elem, nil
+}
+
+// jsonPersonName 把 {"Alphabetic": ..., "Ideographic": ..., "Phonetic": ...} 转换成
+// "="分隔的PN value
+func jsonPersonName(raw json.RawMessage) (string, error) {
+	var name map[string]string
+	if err := json.Unmarshal(raw, &name); err != nil {
+		return "", err
+	}
+	groups := make([]string, len(jsonPNGroups))
+	for i, key := range jsonPNGroups {
+		groups[i] = name[key]
+	}
+	return strings.TrimRight(strings.Join(groups, "="), "="), nil
+}
+
+// jsonNumber 解析数值VR的一个value. DS和IS返回字符串, 也接受JSON字符串形式的数字
+func jsonNumber(vr string, raw json.RawMessage) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = strings.TrimSpace(v)
+	case nil:
+		if vr == "DS" || vr == "IS" {
+			return "", nil
+		}
+		return nil, fmt.Errorf("null value for VR %s", vr)
+	default:
+		return nil, fmt.Errorf("invalid value %s for VR %s", raw, vr)
+	}
+	switch vr {
+	case "DS":
+		_, err := strconv.ParseFloat(s, 64)
+		return s, err
+	case "IS":
+		_, err := strconv.ParseInt(s, 10, 32)
+		return s, err
+	case "US":
+		n, err := strconv.ParseUint(s, 10, 16)
+		return uint16(n), err
+	case "SS":
+		n, err := strconv.ParseInt(s, 10, 16)
+		return int16(n), err
+	case "UL":
+		n, err := strconv.ParseUint(s, 10, 32)
+		return uint32(n), err
+	case "SL":
+		n, err := strconv.ParseInt(s, 10, 32)
+		return int32(n), err
+	case "UV":
+		return strconv.ParseUint(s, 10, 64)
+	case "SV":
+		return strconv.ParseInt(s, 10, 64)
+	case "FL":
+		f, err := strconv.ParseFloat(s, 32)
+		return float32(f), err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// setBinaryValue 把little endian的data设为elem的value, 与ReadElement读到的value相同
+func setBinaryValue(elem *Element, data []byte) error {
+	if elem.Tag == dicomtag.PixelData {
+		elem.Value = []interface{}{PixelDataInfo{Frames: [][]byte{data}}}
+		return nil
+	}
+	switch elem.VR {
+	case "OF", "OD":
+		size := 4
+		if elem.VR == "OD" {
+			size = 8
+		}
+		if len(data)%size != 0 {
+			return fmt.Errorf("%d bytes is not a multiple of %d", len(data), size)
+		}
+		for i := 0; i < len(data); i += size {
+			if size == 4 {
+				elem.Value = append(elem.Value, math.Float32frombits(binary.LittleEndian.Uint32(data[i:])))
+			} else {
+				elem.Value = append(elem.Value, math.Float64frombits(binary.LittleEndian.Uint64(data[i:])))
+			}
+		}
+	case "OB", "OV", "OW", "UN":
+		elem.Value = []interface{}{data}
+	case "OL":
+		if len(data)%4 != 0 {
+			return fmt.Errorf("%d bytes is not a multiple of 4", len(data))
+		}
+		for i := 0; i < len(data); i += 4 {
+			elem.Value = append(elem.Value, binary.LittleEndian.Uint32(data[i:]))
+		}
+	default:
+		return fmt.Errorf("binary value for VR %s", elem.VR)
+	}
+	return nil
+}
+
+// dataSetByteOrder 返回ds的transfer syntax的byte order, 没有TransferSyntaxUID时是little endian
+func dataSetByteOrder(ds *DataSet) binary.ByteOrder {
+	if bo, _, err := getTransferSyntax(ds); err == nil {
+		return bo
+	}
+	return binary.LittleEndian
+}
+
+// littleEndianBytes 返回binary element (包括PixelData) 的little endian bytes.
+// byteOrder是native PixelData的byte order, 也就是文件的byte order
+func littleEndianBytes(elem *Element, byteOrder binary.ByteOrder) ([]byte, error) {
+	var data []byte
+	for _, value := range elem.Value {
+		switch v := value.(type) {
+		case []byte:
+			data = append(data, v...)
+		case string:
+			data = append(data, v...)
+		case uint32:
+			data = append(data, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(data[len(data)-4:], v)
+		case float32:
+			data = append(data, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(data[len(data)-4:], math.Float32bits(v))
+		case float64:
+			data = append(data, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint64(data[len(data)-8:], math.Float64bits(v))
+		case PixelDataInfo:
+			if elem.UndefinedLength {
+				return nil, fmt.Errorf("encapsulated pixel data can't be inlined, use the BulkDataURI option")
+			}
+			for _, frame := range v.Frames {
+				data = append(data, frame...)
+			}
+			// native PixelData是文件的byte order
+			if elem.VR == "OW" && byteOrder == binary.BigEndian {
+				data = swapBytes(data, 2)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected value %v for VR %s", value, elem.VR)
+		}
+	}
+	return data, nil
+}
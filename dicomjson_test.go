@@ -0,0 +1,105 @@
+package dicom_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Put(dicom.MustNewElement(dicomtag.PatientName, "Zhang^San=张^三"))
+	require.NoError(t, ds.PutString(dicomtag.PixelSpacing, "0.5", "0.25"))
+	require.NoError(t, ds.PutString(dicomtag.SeriesNumber, "3"))
+	require.NoError(t, ds.PutString(dicomtag.StudyDescription, ""))
+	require.NoError(t, ds.PutUInt16(dicomtag.Rows, 2))
+	ds.Put(dicom.MustNewElement(dicomtag.RequestAttributesSequence,
+		dicom.MustNewElement(dicomtag.Item, dicom.MustNewElement(dicomtag.RequestedProcedureID, "R1"))))
+	ds.Put(&dicom.Element{Tag: dicomtag.PixelData, VR: "OW",
+		Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{{1, 2, 3, 4}}}}})
+
+	data, err := dicom.MarshalJSON(ds)
+	require.NoError(t, err)
+	var obj map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &obj))
+	require.NotContains(t, obj, "00020010", "file meta group")
+	require.Equal(t, map[string]interface{}{"vr": "PN", "Value": []interface{}{
+		map[string]interface{}{"Alphabetic": "Zhang^San", "Ideographic": "张^三"}}}, obj["00100010"])
+	require.Equal(t, []interface{}{0.5, 0.25}, obj["00280030"]["Value"])
+	require.Equal(t, []interface{}{3.0}, obj["00200011"]["Value"])
+	require.Equal(t, map[string]interface{}{"vr": "LO"}, obj["00081030"])
+	require.Equal(t, []interface{}{2.0}, obj["00280010"]["Value"])
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"00401001": map[string]interface{}{"vr": "SH", "Value": []interface{}{"R1"}}}}, obj["00400275"]["Value"])
+	require.Equal(t, "AQIDBA==", obj["7FE00010"]["InlineBinary"])
+
+	data, err = dicom.MarshalJSONWithOptions(ds, dicom.JSONOptions{BulkDataURI: func(path []dicomtag.Tag, elem *dicom.Element) string {
+		return "https://example.com/bulk/" + elem.Tag.String()
+	}})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &obj))
+	require.Equal(t, map[string]interface{}{"vr": "OW", "BulkDataURI": "https://example.com/bulk/" + dicomtag.PixelData.String()}, obj["7FE00010"])
+
+	// Encapsulated pixel data must be referenced.
+	_, err = dicom.MarshalJSON(mustReadBytes(dicomtest.MustBytes(dicomtest.Spec{TransferSyntaxUID: dicomtest.JPEGBaseline}), dicom.ReadOptions{}))
+	require.Error(t, err)
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Put(dicom.MustNewElement(dicomtag.PatientName, "Zhang^San=张^三"))
+	ds.Put(dicom.MustNewElement(dicomtag.SOPClassUID, "1.2.840.10008.5.1.4.1.1.7"))
+	require.NoError(t, ds.PutString(dicomtag.PixelSpacing, "0.5", "0.25"))
+	require.NoError(t, ds.PutString(dicomtag.SeriesNumber, "3"))
+	require.NoError(t, ds.PutUInt16(dicomtag.Rows, 2))
+	ds.Put(dicom.MustNewElement(dicomtag.RequestAttributesSequence,
+		dicom.MustNewElement(dicomtag.Item, dicom.MustNewElement(dicomtag.RequestedProcedureID, "R1"))))
+	ds.Put(&dicom.Element{Tag: dicomtag.PixelData, VR: "OW",
+		Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{{1, 2, 3, 4}}}}})
+	data, err := dicom.MarshalJSON(ds)
+	require.NoError(t, err)
+
+	// The parsed data set can be written, and has the same elements.
+	parsed, err := dicom.UnmarshalJSON(data)
+	require.NoError(t, err)
+	require.NoError(t, parsed.CheckStructure())
+	parsed = mustReadBytes(mustWriteDataSet(parsed), dicom.ReadOptions{})
+	for _, tag := range []dicomtag.Tag{dicomtag.MediaStorageSOPInstanceUID, dicomtag.PatientName, dicomtag.PixelSpacing,
+		dicomtag.SeriesNumber, dicomtag.Rows, dicomtag.PixelData} {
+		expected, err := ds.FindElementByTag(tag)
+		require.NoError(t, err)
+		elem, err := parsed.FindElementByTag(tag)
+		require.NoError(t, err)
+		require.Equal(t, expected.Value, elem.Value, dicomtag.DebugString(tag))
+	}
+	var found []string
+	for _, flat := range parsed.Flatten() {
+		if flat.Path == "0040,0275[0]/0040,1001" {
+			found = append(found, flat.Element.MustGetString())
+		}
+	}
+	require.Equal(t, []string{"R1"}, found)
+
+	// BulkDataURIs are read with JSONOptions.BulkData.
+	data = []byte(`{"7FE00010": {"vr": "OW", "BulkDataURI": "part2"}, "00280010": {"vr": "US", "Value": [2]}}`)
+	_, err = dicom.UnmarshalJSON(data)
+	require.Error(t, err)
+	parsed, err = dicom.UnmarshalJSONWithOptions(data, dicom.JSONOptions{BulkData: func(path []dicomtag.Tag, uri string) ([]byte, error) {
+		require.Equal(t, []dicomtag.Tag{dicomtag.PixelData}, path)
+		require.Equal(t, "part2", uri)
+		return []byte{5, 6}, nil
+	}})
+	require.NoError(t, err)
+	require.Equal(t, dicomtag.Rows, parsed.Elements[0].Tag)
+	require.Equal(t, []interface{}{dicom.PixelDataInfo{Frames: [][]byte{{5, 6}}}}, parsed.Elements[1].Value)
+
+	for _, bad := range []string{`{"0010": {"vr": "PN"}}`, `{"00280010": {"vr": "US", "Value": [70000]}}`, `[]`} {
+		_, err = dicom.UnmarshalJSON([]byte(bad))
+		require.Error(t, err, bad)
+	}
+}
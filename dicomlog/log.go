@@ -1,8 +1,11 @@
 package dicomlog
 
 import (
-	"github.com/sirupsen/logrus"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // level sets log verbosity. The larger the value, the more verbose.  Setting it
@@ -21,9 +24,187 @@ func Level() int {
 	return int(atomic.LoadInt32(&level))
 }
 
+// Fields are the key/value pairs attached to a log message. Use the *Key
+// constants for the common ones, so that logs can be filtered by them.
+type Fields map[string]interface{}
+
+// 常用的field key
+const (
+	// TagKey 的value是tag, 例如dicomtag.DebugString(tag)
+	TagKey = "tag"
+	// OffsetKey 的value是在文件中的位置(bytes)
+	OffsetKey = "offset"
+	// FileKey 的value是文件路径
+	FileKey = "file"
+	// SuppressedKey 的value是上一次输出之后被sampling丢掉的同一message的数量
+	SuppressedKey = "suppressed"
+)
+
+var (
+	loggerMu sync.RWMutex
+	logger   logrus.FieldLogger = logrus.StandardLogger()
+)
+
+// SetLogger sets where messages are written. The default is
+// logrus.StandardLogger(). Thread safe.
+func SetLogger(l logrus.FieldLogger) {
+	loggerMu.Lock()
+	logger = l
+	loggerMu.Unlock()
+}
+
+func currentLogger() logrus.FieldLogger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+// Sampling limits how often the same message is logged: in each Period, the
+// first First occurrences of a message are logged, then every Thereafter-th.
+// Messages are compared without their fields, so a warning repeated for
+// every element of a file counts as one message. When a message is logged
+// after some were dropped, the number dropped is in the SuppressedKey field.
+type Sampling struct {
+	Period time.Duration
+	First  int
+	// Thereafter 为0时, Period中超过First的message都被丢掉
+	Thereafter int
+}
+
+// DefaultSampling is the sampling used until SetSampling is called.
+var DefaultSampling = Sampling{Period: time.Second, First: 10, Thereafter: 100}
+
+// maxSampledMessages 限制sampler记录的不同message的数量
+const maxSampledMessages = 1000
+
+type sampleCount struct {
+	start      time.Time
+	n          int
+	suppressed int
+}
+
+var sampler = struct {
+	mu       sync.Mutex
+	sampling Sampling
+	counts   map[string]*sampleCount
+}{sampling: DefaultSampling, counts: map[string]*sampleCount{}}
+
+// SetSampling changes the sampling of messages. Sampling{} disables sampling,
+// so that every message is logged. Thread safe.
+func SetSampling(s Sampling) {
+	sampler.mu.Lock()
+	sampler.sampling = s
+	sampler.counts = map[string]*sampleCount{}
+	sampler.mu.Unlock()
+}
+
+// sample 决定msg是否要输出. 输出时返回之前被丢掉的数量
+func sample(msg string, now time.Time) (ok bool, suppressed int) {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	s := sampler.sampling
+	if s.First <= 0 || s.Period <= 0 {
+		return true, 0
+	}
+	c := sampler.counts[msg]
+	if c == nil || now.Sub(c.start) >= s.Period {
+		if c == nil && len(sampler.counts) >= maxSampledMessages {
+			sampler.counts = map[string]*sampleCount{}
+		}
+		prev := 0
+		if c != nil {
+			prev = c.suppressed
+		}
+		c = &sampleCount{start: now, suppressed: prev}
+		sampler.counts[msg] = c
+	}
+	c.n++
+	if c.n > s.First && (s.Thereafter <= 0 || (c.n-s.First)%s.Thereafter != 0) {
+		c.suppressed++
+		return false, 0
+	}
+	suppressed, c.suppressed = c.suppressed, 0
+	return true, suppressed
+}
+
+// Logger writes messages to a given logrus.FieldLogger instead of the one
+// set by SetLogger, e.g., to tag the messages of one request. The level and
+// sampling still apply. The zero value writes to the current logger.
+type Logger struct {
+	l logrus.FieldLogger
+}
+
+// To returns a Logger that writes to "l". nil means the current logger.
+func To(l logrus.FieldLogger) Logger {
+	return Logger{l: l}
+}
+
+func (lg Logger) logf(l int, logrusLevel logrus.Level, msg string, fields Fields) {
+	if Level() < l {
+		return
+	}
+	ok, suppressed := sample(msg, time.Now())
+	if !ok {
+		return
+	}
+	entryFields := make(logrus.Fields, len(fields)+1)
+	for k, v := range fields {
+		entryFields[k] = v
+	}
+	if suppressed > 0 {
+		entryFields[SuppressedKey] = suppressed
+	}
+	logger := lg.l
+	if logger == nil {
+		logger = currentLogger()
+	}
+	entry := logger.WithFields(entryFields)
+	switch logrusLevel {
+	case logrus.WarnLevel:
+		entry.Warn(msg)
+	default:
+		entry.Info(msg)
+	}
+}
+
+// Warn is the same as the package-level Warn, but writes to lg.
+func (lg Logger) Warn(msg string, fields Fields) {
+	lg.logf(0, logrus.WarnLevel, msg, fields)
+}
+
+// Info is the same as the package-level Info, but writes to lg.
+func (lg Logger) Info(msg string, fields Fields) {
+	lg.logf(0, logrus.InfoLevel, msg, fields)
+}
+
+// V is the same as the package-level V, but writes to lg.
+func (lg Logger) V(l int, msg string, fields Fields) {
+	lg.logf(l, logrus.InfoLevel, msg, fields)
+}
+
+// Warn logs a warning with "fields" unless logging is disabled (level -1).
+// "msg" should be constant, with the details in fields, for sampling to work.
+func Warn(msg string, fields Fields) {
+	Logger{}.Warn(msg, fields)
+}
+
+// Info logs an informational message with "fields" unless logging is
+// disabled (level -1).
+func Info(msg string, fields Fields) {
+	Logger{}.Info(msg, fields)
+}
+
+// V logs an informational message with "fields" if the log level is at least
+// "l".
+func V(l int, msg string, fields Fields) {
+	Logger{}.V(l, msg, fields)
+}
+
 // Vprintf is shorthand for "if level > Level { log.Printf(...) }".
+//
+// Deprecated: use V, which adds fields and is subject to sampling.
 func Vprintf(l int, format string, args ...interface{}) {
 	if Level() >= l {
-		logrus.Printf(format, args...)
+		currentLogger().Printf(format, args...)
 	}
 }
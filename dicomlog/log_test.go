@@ -0,0 +1,52 @@
+package dicomlog_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom/dicomlog"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampling(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	dicomlog.SetLogger(logger)
+	defer dicomlog.SetLogger(logrus.StandardLogger())
+	dicomlog.SetSampling(dicomlog.Sampling{Period: time.Hour, First: 2, Thereafter: 3})
+	defer dicomlog.SetSampling(dicomlog.DefaultSampling)
+
+	for i := 0; i < 8; i++ {
+		dicomlog.Warn("odd length", dicomlog.Fields{dicomlog.OffsetKey: i})
+	}
+	dicomlog.Info("other message", nil)
+
+	// Logged: #0, #1, then every third after First: #4, #7.
+	entries := hook.AllEntries()
+	require.Len(t, entries, 5)
+	var offsets []interface{}
+	for _, e := range entries[:4] {
+		assert.Equal(t, logrus.WarnLevel, e.Level)
+		assert.Equal(t, "odd length", e.Message)
+		offsets = append(offsets, e.Data[dicomlog.OffsetKey])
+	}
+	assert.Equal(t, []interface{}{0, 1, 4, 7}, offsets)
+	assert.Equal(t, 2, entries[2].Data[dicomlog.SuppressedKey])
+	assert.Equal(t, 2, entries[3].Data[dicomlog.SuppressedKey])
+	assert.Equal(t, "other message", entries[4].Message)
+
+	// Without sampling everything is logged; V honors the level.
+	hook.Reset()
+	dicomlog.SetSampling(dicomlog.Sampling{})
+	for i := 0; i < 20; i++ {
+		dicomlog.Warn("odd length", nil)
+	}
+	dicomlog.V(1, "verbose", nil)
+	assert.Len(t, hook.AllEntries(), 20)
+	dicomlog.SetLevel(-1)
+	defer dicomlog.SetLevel(0)
+	dicomlog.Warn("disabled", nil)
+	assert.Len(t, hook.AllEntries(), 20)
+}
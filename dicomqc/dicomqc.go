@@ -0,0 +1,84 @@
+// Package dicomqc contains quality-control checks that look for likely
+// mislabeled data sets, e.g., an image whose laterality contradicts its
+// series, before they reach a reading physician.
+package dicomqc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Finding is one problem reported by a Check.
+type Finding struct {
+	// Check is the name of the check that reported the finding, e.g.,
+	// "laterality".
+	Check string
+
+	// Tags are the attributes involved.
+	Tags []dicomtag.Tag
+
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Check, f.Message)
+}
+
+// Check inspects a data set and returns the problems found. Attributes that
+// are missing are not reported unless the check is about their presence.
+type Check func(ds *dicom.DataSet) []Finding
+
+// OrientationChecks are the orientation and laterality checks.
+var OrientationChecks = []Check{CheckPatientOrientation, CheckLaterality, CheckViewPosition}
+
+// Run runs "checks" on "ds" and returns all the findings, in order.
+func Run(ds *dicom.DataSet, checks ...Check) []Finding {
+	var findings []Finding
+	for _, check := range checks {
+		findings = append(findings, check(ds)...)
+	}
+	return findings
+}
+
+// getString 返回tag的第一个值, 去掉空格. 不存在或者没有值时返回""
+func getString(ds *dicom.DataSet, tag dicomtag.Tag) string {
+	values := getStrings(ds, tag)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// getStrings 返回tag的所有值, 去掉空格
+func getStrings(ds *dicom.DataSet, tag dicomtag.Tag) []string {
+	elem, err := ds.FindElementByTag(tag)
+	if err != nil {
+		return nil
+	}
+	values, err := elem.GetStrings()
+	if err != nil {
+		return nil
+	}
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// getFloats 解析一个DS element. 不存在时返回nil, nil
+func getFloats(ds *dicom.DataSet, tag dicomtag.Tag) ([]float64, error) {
+	values := getStrings(ds, tag)
+	result := make([]float64, 0, len(values))
+	for _, s := range values {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", dicomtag.DebugString(tag), err)
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
@@ -0,0 +1,24 @@
+package dicomqc
+
+import (
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// PixelDataChecks are the checks for pixel data that viewers may fail to
+// display correctly.
+var PixelDataChecks = []Check{CheckFrameCount}
+
+// CheckFrameCount reports encapsulated PixelData whose frames don't match
+// NumberOfFrames (0028,0008) or the offset tables, e.g., ultrasound cine
+// loops that lost frames. See dicom.DataSet.CheckFrameCount.
+func CheckFrameCount(ds *dicom.DataSet) []Finding {
+	if err := ds.CheckFrameCount(); err != nil {
+		return []Finding{{
+			Check:   "frame-count",
+			Tags:    []dicomtag.Tag{dicomtag.NumberOfFrames, dicomtag.PixelData},
+			Message: err.Error(),
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package dicomqc_test
+
+import (
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomqc"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFrameCount(t *testing.T) {
+	ds, err := dicomtest.NewDataSet(dicomtest.Spec{TransferSyntaxUID: dicomtest.JPEGBaseline, NumberOfFrames: 2})
+	require.NoError(t, err)
+	assert.Empty(t, dicomqc.Run(ds, dicomqc.PixelDataChecks...))
+
+	ds.Replace(dicom.MustNewElement(dicomtag.NumberOfFrames, "3"), "")
+	findings := dicomqc.CheckFrameCount(ds)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "frame-count", findings[0].Check)
+	assert.Contains(t, findings[0].Message, "NumberOfFrames is 3")
+}
@@ -0,0 +1,124 @@
+package dicomqc
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// 病人坐标系(LPS)各个轴正方向和负方向的字母. P3.3 C.7.6.1.1.1
+var axisLetters = [3][2]byte{
+	{'L', 'R'}, // x: 病人的左边
+	{'P', 'A'}, // y: 病人的后面
+	{'H', 'F'}, // z: 病人的头
+}
+
+// orientationLetter 返回方向向量v的主要(绝对值最大的分量)方向的字母
+func orientationLetter(v []float64) byte {
+	axis := 0
+	for i := 1; i < 3; i++ {
+		if math.Abs(v[i]) > math.Abs(v[axis]) {
+			axis = i
+		}
+	}
+	if v[axis] < 0 {
+		return axisLetters[axis][1]
+	}
+	return axisLetters[axis][0]
+}
+
+// CheckPatientOrientation checks that PatientOrientation (0020,0020) agrees
+// with ImageOrientationPatient (0020,0037): the first letter of each
+// PatientOrientation value must be the main direction of the row and column
+// direction cosines, respectively.
+func CheckPatientOrientation(ds *dicom.DataSet) []Finding {
+	const check = "patient-orientation"
+	tags := []dicomtag.Tag{dicomtag.PatientOrientation, dicomtag.ImageOrientationPatient}
+	orientation := getStrings(ds, dicomtag.PatientOrientation)
+	cosines, err := getFloats(ds, dicomtag.ImageOrientationPatient)
+	if err != nil {
+		return []Finding{{Check: check, Tags: tags, Message: err.Error()}}
+	}
+	if len(cosines) == 0 || len(orientation) == 0 || (len(orientation) == 1 && orientation[0] == "") {
+		return nil
+	}
+	if len(cosines) != 6 {
+		return []Finding{{Check: check, Tags: tags,
+			Message: fmt.Sprintf("ImageOrientationPatient has %d values, expect 6", len(cosines))}}
+	}
+	if len(orientation) != 2 {
+		return []Finding{{Check: check, Tags: tags,
+			Message: fmt.Sprintf("PatientOrientation has %d values, expect 2", len(orientation))}}
+	}
+	var findings []Finding
+	for i, name := range []string{"row", "column"} {
+		want := orientationLetter(cosines[i*3 : i*3+3])
+		if orientation[i] == "" || orientation[i][0] != want {
+			findings = append(findings, Finding{Check: check, Tags: tags,
+				Message: fmt.Sprintf("PatientOrientation %s direction is '%s', but ImageOrientationPatient %v points to '%c'",
+					name, orientation[i], cosines[i*3:i*3+3], want)})
+		}
+	}
+	return findings
+}
+
+// CheckLaterality checks that ImageLaterality (0020,0062) and the series'
+// Laterality (0020,0060) don't name opposite sides. "B" (both) and "U"
+// (unpaired) are consistent with anything.
+func CheckLaterality(ds *dicom.DataSet) []Finding {
+	laterality := getString(ds, dicomtag.Laterality)
+	imageLaterality := getString(ds, dicomtag.ImageLaterality)
+	if (laterality == "L" && imageLaterality == "R") || (laterality == "R" && imageLaterality == "L") {
+		return []Finding{{
+			Check:   "laterality",
+			Tags:    []dicomtag.Tag{dicomtag.Laterality, dicomtag.ImageLaterality},
+			Message: fmt.Sprintf("ImageLaterality is '%s', but the series Laterality is '%s'", imageLaterality, laterality),
+		}}
+	}
+	return nil
+}
+
+// viewPositions 是CR/DX的ViewPosition (0018,5101) 定义的值. P3.3 C.8.11.5.1.1.
+// value是PatientOrientation的行方向应该有的字母: 正位(AP/PA)是左右, 侧位是前后
+var viewPositions = map[string]string{
+	"AP":  "LR",
+	"PA":  "LR",
+	"LL":  "AP",
+	"RL":  "AP",
+	"RLD": "",
+	"LLD": "",
+	"RLO": "",
+	"LLO": "",
+}
+
+// CheckViewPosition checks ViewPosition (0018,5101) of CR and DX images: it
+// must be one of the defined terms, and for frontal (AP, PA) and lateral
+// (LL, RL) views the row direction of PatientOrientation must be left/right
+// and anterior/posterior, respectively. Other modalities are not checked.
+func CheckViewPosition(ds *dicom.DataSet) []Finding {
+	const check = "view-position"
+	if modality := getString(ds, dicomtag.Modality); modality != "CR" && modality != "DX" {
+		return nil
+	}
+	view := getString(ds, dicomtag.ViewPosition)
+	if view == "" {
+		return nil
+	}
+	rowLetters, ok := viewPositions[view]
+	if !ok {
+		return []Finding{{Check: check, Tags: []dicomtag.Tag{dicomtag.ViewPosition},
+			Message: fmt.Sprintf("unknown ViewPosition '%s'", view)}}
+	}
+	orientation := getStrings(ds, dicomtag.PatientOrientation)
+	if rowLetters == "" || len(orientation) == 0 || orientation[0] == "" {
+		return nil
+	}
+	if !strings.ContainsRune(rowLetters, rune(orientation[0][0])) {
+		return []Finding{{Check: check, Tags: []dicomtag.Tag{dicomtag.ViewPosition, dicomtag.PatientOrientation},
+			Message: fmt.Sprintf("ViewPosition is '%s', but the PatientOrientation row direction is '%s'", view, orientation[0])}}
+	}
+	return nil
+}
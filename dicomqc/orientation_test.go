@@ -0,0 +1,79 @@
+package dicomqc_test
+
+import (
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomqc"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDataSet(elems ...*dicom.Element) *dicom.DataSet {
+	return &dicom.DataSet{Elements: elems}
+}
+
+func TestCheckPatientOrientation(t *testing.T) {
+	axial := dicom.MustNewElement(dicomtag.ImageOrientationPatient, "1", "0", "0", "0", "1", "0")
+	ds := newDataSet(dicom.MustNewElement(dicomtag.PatientOrientation, "L", "P"), axial)
+	assert.Empty(t, dicomqc.CheckPatientOrientation(ds))
+
+	// Oblique; only the main direction counts.
+	ds = newDataSet(dicom.MustNewElement(dicomtag.PatientOrientation, "LP", "FL"),
+		dicom.MustNewElement(dicomtag.ImageOrientationPatient, "0.9", "0.1", "0", "0.2", "0", "-0.95"))
+	assert.Empty(t, dicomqc.CheckPatientOrientation(ds))
+
+	ds = newDataSet(dicom.MustNewElement(dicomtag.PatientOrientation, "R", "P"), axial)
+	findings := dicomqc.CheckPatientOrientation(ds)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "patient-orientation", findings[0].Check)
+	assert.Contains(t, findings[0].Message, "row direction is 'R'")
+
+	assert.Empty(t, dicomqc.CheckPatientOrientation(newDataSet(axial)))
+	assert.Len(t, dicomqc.CheckPatientOrientation(newDataSet(dicom.MustNewElement(dicomtag.PatientOrientation, "L", "P"),
+		dicom.MustNewElement(dicomtag.ImageOrientationPatient, "1", "0", "0"))), 1)
+}
+
+func TestCheckLaterality(t *testing.T) {
+	for _, test := range []struct {
+		laterality, imageLaterality string
+		ok                          bool
+	}{
+		{"L", "L", true},
+		{"L", "B", true},
+		{"", "R", true},
+		{"L", "R", false},
+		{"R", "L", false},
+	} {
+		ds := newDataSet(dicom.MustNewElement(dicomtag.Laterality, test.laterality),
+			dicom.MustNewElement(dicomtag.ImageLaterality, test.imageLaterality))
+		assert.Equal(t, test.ok, len(dicomqc.CheckLaterality(ds)) == 0, test)
+	}
+}
+
+func TestCheckViewPosition(t *testing.T) {
+	newChest := func(modality, view, row, column string) *dicom.DataSet {
+		return newDataSet(
+			dicom.MustNewElement(dicomtag.Modality, modality),
+			dicom.MustNewElement(dicomtag.ViewPosition, view),
+			dicom.MustNewElement(dicomtag.PatientOrientation, row, column))
+	}
+	assert.Empty(t, dicomqc.CheckViewPosition(newChest("DX", "PA", "L", "F")))
+	assert.Empty(t, dicomqc.CheckViewPosition(newChest("CR", "LL", "A", "F")))
+	assert.Empty(t, dicomqc.CheckViewPosition(newChest("CR", "RLO", "L", "F")))
+	assert.Empty(t, dicomqc.CheckViewPosition(newChest("MG", "CC", "A", "R")))
+	assert.Len(t, dicomqc.CheckViewPosition(newChest("DX", "LL", "L", "F")), 1)
+	assert.Len(t, dicomqc.CheckViewPosition(newChest("DX", "XX", "L", "F")), 1)
+
+	findings := dicomqc.Run(newDataSet(
+		dicom.MustNewElement(dicomtag.Modality, "DX"),
+		dicom.MustNewElement(dicomtag.ViewPosition, "AP"),
+		dicom.MustNewElement(dicomtag.PatientOrientation, "P", "F"),
+		dicom.MustNewElement(dicomtag.Laterality, "L"),
+		dicom.MustNewElement(dicomtag.ImageLaterality, "R"),
+	), dicomqc.OrientationChecks...)
+	require.Len(t, findings, 2)
+	assert.Equal(t, "laterality", findings[0].Check)
+	assert.Equal(t, "view-position", findings[1].Check)
+}
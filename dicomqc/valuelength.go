@@ -0,0 +1,27 @@
+package dicomqc
+
+import (
+	"fmt"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// ConformanceChecks are the checks for values that remote archives are
+// likely to reject.
+var ConformanceChecks = []Check{CheckValueLengths}
+
+// CheckValueLengths reports every value, including those nested in
+// sequences, that is longer than its VR allows, e.g., an SH value of more
+// than 16 characters. See dicom.Element.CheckValueLengths.
+func CheckValueLengths(ds *dicom.DataSet) []Finding {
+	const check = "value-length"
+	var findings []Finding
+	for _, flat := range ds.Flatten() {
+		if err := flat.Element.CheckValueLengths(); err != nil {
+			findings = append(findings, Finding{Check: check, Tags: []dicomtag.Tag{flat.Element.Tag},
+				Message: fmt.Sprintf("%s: %v", flat.Path, err)})
+		}
+	}
+	return findings
+}
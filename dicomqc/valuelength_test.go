@@ -0,0 +1,38 @@
+package dicomqc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomqc"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckValueLengths(t *testing.T) {
+	ds := newDataSet(
+		dicom.MustNewElement(dicomtag.StudyDate, "20200101-20201231"),
+		dicom.MustNewElement(dicomtag.PatientName, strings.Repeat("A", 60)+"="+strings.Repeat("B", 60)),
+		dicom.MustNewElement(dicomtag.PatientID, strings.Repeat("9", 64)),
+		dicom.MustNewElement(dicomtag.SliceThickness, "1.25 "),
+	)
+	assert.Empty(t, dicomqc.CheckValueLengths(ds))
+
+	ds = newDataSet(
+		dicom.MustNewElement(dicomtag.StudyDate, "202001011"),
+		dicom.MustNewElement(dicomtag.AccessionNumber, "12345678901234567"),
+		dicom.MustNewSequence(dicomtag.ReferencedImageSequence, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, "1.2."+strings.Repeat("3", 61)),
+		}),
+		dicom.MustNewElement(dicomtag.PatientName, "张^"+strings.Repeat("三", 63)),
+	)
+	findings := dicomqc.CheckValueLengths(ds)
+	require.Len(t, findings, 4)
+	assert.Equal(t, "value-length", findings[0].Check)
+	assert.Equal(t, []dicomtag.Tag{dicomtag.StudyDate}, findings[0].Tags)
+	assert.Contains(t, findings[1].Message, "SH allows at most 16")
+	assert.Contains(t, findings[2].Message, "0008,1140[0]/0008,1155")
+	assert.Contains(t, findings[3].Message, "65 characters")
+}
@@ -0,0 +1,237 @@
+// Package dicomref builds and parses the sequences that reference other
+// instances: the SOP Instance Reference Macro (P3.3 10.8), e.g., in
+// ReferencedImageSequence, and the Hierarchical SOP Instance Reference Macro
+// (P3.3 C.17.2.1) of key object selections, SR evidence, segmentations and
+// presentation states.
+package dicomref
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// SOP is a reference to an instance, and optionally to some of its frames.
+type SOP struct {
+	ClassUID    string
+	InstanceUID string
+	// Frames 是ReferencedFrameNumber, 从1开始. 空表示整个instance
+	Frames []int
+}
+
+// Series is a reference to instances of one series.
+type Series struct {
+	SeriesInstanceUID string
+	Instances         []SOP
+}
+
+// Study is a reference to instances of one study.
+type Study struct {
+	StudyInstanceUID string
+	Series           []Series
+}
+
+// NewReferencedSOP returns the elements of a SOP Instance Reference Macro
+// item that references the instance, and "frames" (starting at 1) of it if
+// given. Pass it to dicom.NewSequence, e.g.,
+//
+//	dicom.MustNewSequence(dicomtag.ReferencedImageSequence,
+//	    dicomref.NewReferencedSOP(classUID, instanceUID))
+func NewReferencedSOP(classUID, instanceUID string, frames ...int) []*dicom.Element {
+	elems := []*dicom.Element{
+		dicom.MustNewElement(dicomtag.ReferencedSOPClassUID, classUID),
+		dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, instanceUID),
+	}
+	if len(frames) > 0 {
+		values := make([]interface{}, len(frames))
+		for i, frame := range frames {
+			values[i] = strconv.Itoa(frame)
+		}
+		elems = append(elems, dicom.MustNewElement(dicomtag.ReferencedFrameNumber, values...))
+	}
+	return elems
+}
+
+// NewReferencedSOPSequence returns a sequence "tag", e.g.,
+// ReferencedImageSequence, with one item per instance.
+func NewReferencedSOPSequence(tag dicomtag.Tag, instances ...SOP) (*dicom.Element, error) {
+	items := make([][]*dicom.Element, len(instances))
+	for i, sop := range instances {
+		items[i] = NewReferencedSOP(sop.ClassUID, sop.InstanceUID, sop.Frames...)
+	}
+	return dicom.NewSequence(tag, items...)
+}
+
+// NewReferencedSeriesSequence returns a ReferencedSeriesSequence
+// (0008,1115) with one item per series, which lists the instances in
+// "instanceTag": ReferencedSOPSequence (0008,1199) in the Hierarchical SOP
+// Instance Reference Macro, ReferencedImageSequence (0008,1140) in
+// presentation states.
+func NewReferencedSeriesSequence(instanceTag dicomtag.Tag, series ...Series) (*dicom.Element, error) {
+	items := make([][]*dicom.Element, len(series))
+	for i, s := range series {
+		instances, err := NewReferencedSOPSequence(instanceTag, s.Instances...)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = []*dicom.Element{
+			dicom.MustNewElement(dicomtag.SeriesInstanceUID, s.SeriesInstanceUID),
+			instances,
+		}
+	}
+	return dicom.NewSequence(dicomtag.ReferencedSeriesSequence, items...)
+}
+
+// NewHierarchicalSequence returns a sequence "tag" of Hierarchical SOP
+// Instance Reference Macro items, one per study, e.g.,
+// CurrentRequestedProcedureEvidenceSequence (0040,A375).
+func NewHierarchicalSequence(tag dicomtag.Tag, studies ...Study) (*dicom.Element, error) {
+	items := make([][]*dicom.Element, len(studies))
+	for i, study := range studies {
+		series, err := NewReferencedSeriesSequence(dicomtag.ReferencedSOPSequence, study.Series...)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = []*dicom.Element{
+			dicom.MustNewElement(dicomtag.StudyInstanceUID, study.StudyInstanceUID),
+			series,
+		}
+	}
+	return dicom.NewSequence(tag, items...)
+}
+
+// ParseReferencedSOPSequence parses the top-level sequence "tag" of "ds",
+// e.g., ReferencedImageSequence. It returns nil if the sequence is absent.
+func ParseReferencedSOPSequence(ds *dicom.DataSet, tag dicomtag.Tag) ([]SOP, error) {
+	instances, err := parseSOPs(ds.Elements, tag)
+	if err != nil {
+		return nil, fmt.Errorf("dicomref.ParseReferencedSOPSequence: %v", err)
+	}
+	return instances, nil
+}
+
+// ParseReferencedSeriesSequence parses the top-level ReferencedSeriesSequence
+// (0008,1115) of "ds". The instances of a series may be in
+// ReferencedSOPSequence, ReferencedImageSequence or
+// ReferencedInstanceSequence. It returns nil if the sequence is absent.
+func ParseReferencedSeriesSequence(ds *dicom.DataSet) ([]Series, error) {
+	series, err := parseSeries(ds.Elements)
+	if err != nil {
+		return nil, fmt.Errorf("dicomref.ParseReferencedSeriesSequence: %v", err)
+	}
+	return series, nil
+}
+
+// ParseHierarchicalSequence parses the top-level sequence "tag" of "ds",
+// e.g., CurrentRequestedProcedureEvidenceSequence, whose items are
+// Hierarchical SOP Instance Reference Macros. It returns nil if the
+// sequence is absent.
+func ParseHierarchicalSequence(ds *dicom.DataSet, tag dicomtag.Tag) ([]Study, error) {
+	var studies []Study
+	for i, item := range sequenceItems(ds.Elements, tag) {
+		study := Study{StudyInstanceUID: getString(item, dicomtag.StudyInstanceUID)}
+		if study.StudyInstanceUID == "" {
+			return nil, fmt.Errorf("dicomref.ParseHierarchicalSequence: %s[%d]: no StudyInstanceUID", dicomtag.DebugString(tag), i)
+		}
+		series, err := parseSeries(item)
+		if err != nil {
+			return nil, fmt.Errorf("dicomref.ParseHierarchicalSequence: %s[%d]/%v", dicomtag.DebugString(tag), i, err)
+		}
+		study.Series = series
+		studies = append(studies, study)
+	}
+	return studies, nil
+}
+
+// instanceSequenceTags 是ReferencedSeriesSequence的item里可能列出instances的sequences
+var instanceSequenceTags = []dicomtag.Tag{
+	dicomtag.ReferencedSOPSequence,
+	dicomtag.ReferencedImageSequence,
+	dicomtag.ReferencedInstanceSequence,
+}
+
+func parseSeries(elems []*dicom.Element) ([]Series, error) {
+	var result []Series
+	for i, item := range sequenceItems(elems, dicomtag.ReferencedSeriesSequence) {
+		series := Series{SeriesInstanceUID: getString(item, dicomtag.SeriesInstanceUID)}
+		if series.SeriesInstanceUID == "" {
+			return nil, fmt.Errorf("%s[%d]: no SeriesInstanceUID", dicomtag.DebugString(dicomtag.ReferencedSeriesSequence), i)
+		}
+		for _, tag := range instanceSequenceTags {
+			instances, err := parseSOPs(item, tag)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]/%v", dicomtag.DebugString(dicomtag.ReferencedSeriesSequence), i, err)
+			}
+			series.Instances = append(series.Instances, instances...)
+		}
+		result = append(result, series)
+	}
+	return result, nil
+}
+
+func parseSOPs(elems []*dicom.Element, tag dicomtag.Tag) ([]SOP, error) {
+	var result []SOP
+	for i, item := range sequenceItems(elems, tag) {
+		sop := SOP{
+			ClassUID:    getString(item, dicomtag.ReferencedSOPClassUID),
+			InstanceUID: getString(item, dicomtag.ReferencedSOPInstanceUID),
+		}
+		if sop.ClassUID == "" || sop.InstanceUID == "" {
+			return nil, fmt.Errorf("%s[%d]: no ReferencedSOPClassUID or ReferencedSOPInstanceUID", dicomtag.DebugString(tag), i)
+		}
+		if elem, err := dicom.FindElementByTag(item, dicomtag.ReferencedFrameNumber); err == nil {
+			values, err := elem.GetStrings()
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %v", dicomtag.DebugString(tag), i, err)
+			}
+			for _, s := range values {
+				frame, err := strconv.Atoi(strings.TrimSpace(s))
+				if err != nil {
+					return nil, fmt.Errorf("%s[%d]: ReferencedFrameNumber: %v", dicomtag.DebugString(tag), i, err)
+				}
+				sop.Frames = append(sop.Frames, frame)
+			}
+		}
+		result = append(result, sop)
+	}
+	return result, nil
+}
+
+// sequenceItems 返回sequence "tag"的每个item的elements. 不存在时返回nil
+func sequenceItems(elems []*dicom.Element, tag dicomtag.Tag) [][]*dicom.Element {
+	seq, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return nil
+	}
+	var items [][]*dicom.Element
+	for _, v := range seq.Value {
+		item, ok := v.(*dicom.Element)
+		if !ok {
+			continue
+		}
+		var sub []*dicom.Element
+		for _, v := range item.Value {
+			if elem, ok := v.(*dicom.Element); ok {
+				sub = append(sub, elem)
+			}
+		}
+		items = append(items, sub)
+	}
+	return items
+}
+
+// getString 返回tag的值, 去掉空格. 不存在时返回""
+func getString(elems []*dicom.Element, tag dicomtag.Tag) string {
+	elem, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return ""
+	}
+	s, err := elem.GetString()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(s)
+}
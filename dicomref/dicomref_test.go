@@ -0,0 +1,86 @@
+package dicomref_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomref"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip 写出再读回ds, 确认生成的sequences是合法的
+func roundTrip(t *testing.T, elems ...*dicom.Element) *dicom.DataSet {
+	ds := &dicom.DataSet{Elements: append([]*dicom.Element{
+		dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, dicomuid.KeyObjectSelectionDocumentStorage),
+		dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, "1.2.3.100"),
+		dicom.MustNewElement(dicomtag.TransferSyntaxUID, dicomuid.ExplicitVRLittleEndian),
+	}, elems...)}
+	var buf bytes.Buffer
+	require.NoError(t, dicom.WriteDataSet(&buf, ds))
+	ds, err := dicom.ReadDataSetInBytes(buf.Bytes(), dicom.ReadOptions{})
+	require.NoError(t, err)
+	return ds
+}
+
+func TestReferencedSOPSequence(t *testing.T) {
+	ds := roundTrip(t, dicom.MustNewSequence(dicomtag.ReferencedImageSequence,
+		dicomref.NewReferencedSOP(dicomuid.CTImageStorage, "1.2.3.4"),
+		dicomref.NewReferencedSOP(dicomuid.EnhancedCTImageStorage, "1.2.3.5", 2, 7)))
+	instances, err := dicomref.ParseReferencedSOPSequence(ds, dicomtag.ReferencedImageSequence)
+	require.NoError(t, err)
+	assert.Equal(t, []dicomref.SOP{
+		{ClassUID: dicomuid.CTImageStorage, InstanceUID: "1.2.3.4"},
+		{ClassUID: dicomuid.EnhancedCTImageStorage, InstanceUID: "1.2.3.5", Frames: []int{2, 7}},
+	}, instances)
+
+	instances, err = dicomref.ParseReferencedSOPSequence(ds, dicomtag.ReferencedInstanceSequence)
+	require.NoError(t, err)
+	assert.Nil(t, instances)
+
+	ds = &dicom.DataSet{Elements: []*dicom.Element{dicom.MustNewSequence(dicomtag.ReferencedImageSequence,
+		[]*dicom.Element{dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, "1.2.3.4")})}}
+	_, err = dicomref.ParseReferencedSOPSequence(ds, dicomtag.ReferencedImageSequence)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "[0]: no ReferencedSOPClassUID")
+}
+
+func TestReferencedSeriesSequence(t *testing.T) {
+	series := []dicomref.Series{
+		{SeriesInstanceUID: "1.2.3", Instances: []dicomref.SOP{
+			{ClassUID: dicomuid.CTImageStorage, InstanceUID: "1.2.3.1"},
+			{ClassUID: dicomuid.CTImageStorage, InstanceUID: "1.2.3.2"},
+		}},
+		{SeriesInstanceUID: "1.2.4", Instances: []dicomref.SOP{
+			{ClassUID: dicomuid.EnhancedCTImageStorage, InstanceUID: "1.2.4.1", Frames: []int{1}},
+		}},
+	}
+	// presentation state的ReferencedSeriesSequence用ReferencedImageSequence
+	elem, err := dicomref.NewReferencedSeriesSequence(dicomtag.ReferencedImageSequence, series...)
+	require.NoError(t, err)
+	parsed, err := dicomref.ParseReferencedSeriesSequence(roundTrip(t, elem))
+	require.NoError(t, err)
+	assert.Equal(t, series, parsed)
+
+	studies := []dicomref.Study{{StudyInstanceUID: "1.2", Series: series}}
+	elem, err = dicomref.NewHierarchicalSequence(dicomtag.CurrentRequestedProcedureEvidenceSequence, studies...)
+	require.NoError(t, err)
+	ds := roundTrip(t, elem)
+	parsedStudies, err := dicomref.ParseHierarchicalSequence(ds, dicomtag.CurrentRequestedProcedureEvidenceSequence)
+	require.NoError(t, err)
+	assert.Equal(t, studies, parsedStudies)
+	// Hierarchical SOP Instance Reference Macro用ReferencedSOPSequence
+	seriesItem := elem.Value[0].(*dicom.Element).Value[1].(*dicom.Element).Value[0].(*dicom.Element)
+	assert.Equal(t, dicomtag.ReferencedSOPSequence, seriesItem.Value[1].(*dicom.Element).Tag)
+
+	_, err = dicomref.NewReferencedSeriesSequence(dicomtag.PatientName, series...)
+	assert.Error(t, err)
+	elem, err = dicomref.NewReferencedSeriesSequence(dicomtag.ReferencedSOPSequence, dicomref.Series{Instances: series[0].Instances})
+	require.NoError(t, err)
+	_, err = dicomref.ParseReferencedSeriesSequence(&dicom.DataSet{Elements: []*dicom.Element{elem}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no SeriesInstanceUID")
+}
@@ -0,0 +1,221 @@
+// Package dicomsig reads the Digital Signatures (P3.15 C, P3.3 C.12.1.1.3)
+// of a data set: who signed it, when, and which attributes with which MAC
+// parameters. It doesn't verify the signatures; it is meant for inventories
+// of the signed instances in an archive.
+package dicomsig
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// MACParameters is an item of MACParametersSequence (4FFE,0001): how the
+// MAC of the signatures with the same MACIDNumber was computed.
+type MACParameters struct {
+	IDNumber uint16
+
+	// TransferSyntaxUID 是计算MAC时的transfer syntax
+	TransferSyntaxUID string
+
+	// Algorithm 例如"SHA256", "RIPEMD160"
+	Algorithm string
+
+	// DataElementsSigned 是被签名的attributes. 空表示没有这个element
+	DataElementsSigned []dicomtag.Tag
+}
+
+// Signature is an item of a DigitalSignaturesSequence (FFFA,FFFA).
+type Signature struct {
+	// Path 是签名所在的level: nil表示top-level data set, 否则是item所在的SQ elements,
+	// 例如[ReferencedImageSequence]表示ReferencedImageSequence的一个item被签名
+	Path []dicomtag.Tag
+
+	MACIDNumber uint16
+	UID         string
+
+	// DateTime 是DigitalSignatureDateTime. 没有或者不能解析时是zero time
+	DateTime time.Time
+
+	// CertificateType 例如"X509_1993_SIG"
+	CertificateType string
+
+	// Certificate 是CertificateOfSigner, 格式由CertificateType决定
+	Certificate []byte
+
+	Signature []byte
+
+	// CertifiedTimestampType 和 CertifiedTimestamp, 例如"CMS_TSP"
+	CertifiedTimestampType string
+	CertifiedTimestamp     []byte
+
+	// Purposes 是DigitalSignaturePurposeCodeSequence的codes, 例如
+	// (1, ASTM-sigpurpose, "Author's Signature"), 见dicom.LookupContextGroup(7007)
+	Purposes []dicom.Code
+
+	// MAC 是同一个level中MACIDNumber相同的MACParameters, 没有时为nil
+	MAC *MACParameters
+}
+
+// X509Certificate parses Certificate, if CertificateType is "X509_1993_SIG".
+// The certificate identifies the signer, e.g., its Subject.
+func (s *Signature) X509Certificate() (*x509.Certificate, error) {
+	if s.CertificateType != "X509_1993_SIG" {
+		return nil, fmt.Errorf("dicomsig: certificate type is %q, not X509_1993_SIG", s.CertificateType)
+	}
+	// OB values有偶数长度, 所以DER之后可能有一个padding byte
+	var der asn1.RawValue
+	if _, err := asn1.Unmarshal(s.Certificate, &der); err != nil {
+		return nil, fmt.Errorf("dicomsig: CertificateOfSigner: %v", err)
+	}
+	return x509.ParseCertificate(der.FullBytes)
+}
+
+// Signatures returns the digital signatures of "ds", of the data set itself
+// and of the items of its sequences, in the order they appear. It returns
+// an error if a signature or MAC parameters item is malformed.
+func Signatures(ds *dicom.DataSet) ([]Signature, error) {
+	return signatures(nil, ds.Elements, nil)
+}
+
+func signatures(path []dicomtag.Tag, elems []*dicom.Element, result []Signature) ([]Signature, error) {
+	macs := map[uint16]*MACParameters{}
+	if elem, err := dicom.FindElementByTag(elems, dicomtag.MACParametersSequence); err == nil {
+		for _, item := range items(elem) {
+			mac, err := macParameters(item)
+			if err != nil {
+				return nil, fmt.Errorf("dicomsig.Signatures: %v: %v", pathString(path, elem.Tag), err)
+			}
+			macs[mac.IDNumber] = mac
+		}
+	}
+	for _, elem := range elems {
+		if elem.VR != "SQ" || elem.Tag == dicomtag.MACParametersSequence {
+			continue
+		}
+		if elem.Tag == dicomtag.DigitalSignaturesSequence {
+			for _, item := range items(elem) {
+				sig, err := signature(item)
+				if err != nil {
+					return nil, fmt.Errorf("dicomsig.Signatures: %v: %v", pathString(path, elem.Tag), err)
+				}
+				sig.Path = append([]dicomtag.Tag(nil), path...)
+				sig.MAC = macs[sig.MACIDNumber]
+				result = append(result, sig)
+			}
+			continue
+		}
+		var err error
+		for _, item := range items(elem) {
+			if result, err = signatures(append(path[:len(path):len(path)], elem.Tag), item, result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// items 返回SQ element每个item的elements
+func items(elem *dicom.Element) [][]*dicom.Element {
+	var result [][]*dicom.Element
+	for _, value := range elem.Value {
+		item, ok := value.(*dicom.Element)
+		if !ok {
+			continue
+		}
+		var elems []*dicom.Element
+		for _, v := range item.Value {
+			if e, ok := v.(*dicom.Element); ok {
+				elems = append(elems, e)
+			}
+		}
+		result = append(result, elems)
+	}
+	return result
+}
+
+func macParameters(elems []*dicom.Element) (*MACParameters, error) {
+	mac := &MACParameters{}
+	var err error
+	if mac.IDNumber, err = uint16Value(elems, dicomtag.MACIDNumber); err != nil {
+		return nil, err
+	}
+	mac.TransferSyntaxUID = stringValue(elems, dicomtag.MACCalculationTransferSyntaxUID)
+	mac.Algorithm = stringValue(elems, dicomtag.MACAlgorithm)
+	if elem, err := dicom.FindElementByTag(elems, dicomtag.DataElementsSigned); err == nil {
+		for _, v := range elem.Value {
+			tag, ok := v.(dicomtag.Tag)
+			if !ok {
+				return nil, fmt.Errorf("%v: value %v is not a tag", dicomtag.DebugString(elem.Tag), v)
+			}
+			mac.DataElementsSigned = append(mac.DataElementsSigned, tag)
+		}
+	}
+	return mac, nil
+}
+
+func signature(elems []*dicom.Element) (Signature, error) {
+	var sig Signature
+	var err error
+	if sig.MACIDNumber, err = uint16Value(elems, dicomtag.MACIDNumber); err != nil {
+		return sig, err
+	}
+	sig.UID = stringValue(elems, dicomtag.DigitalSignatureUID)
+	if dt := stringValue(elems, dicomtag.DigitalSignatureDateTime); dt != "" {
+		sig.DateTime, _ = dicom.ParseDateTime(dt)
+	}
+	sig.CertificateType = stringValue(elems, dicomtag.CertificateType)
+	sig.Certificate = bytesValue(elems, dicomtag.CertificateOfSigner)
+	sig.Signature = bytesValue(elems, dicomtag.Signature)
+	sig.CertifiedTimestampType = stringValue(elems, dicomtag.CertifiedTimestampType)
+	sig.CertifiedTimestamp = bytesValue(elems, dicomtag.CertifiedTimestamp)
+	purposes := &dicom.DataSet{Elements: elems}
+	if sig.Purposes, err = purposes.Codes(dicomtag.DigitalSignaturePurposeCodeSequence); err != nil {
+		return sig, err
+	}
+	return sig, nil
+}
+
+func uint16Value(elems []*dicom.Element, tag dicomtag.Tag) (uint16, error) {
+	elem, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return 0, err
+	}
+	return elem.GetUInt16()
+}
+
+// stringValue 返回去掉padding的string value, 没有时返回""
+func stringValue(elems []*dicom.Element, tag dicomtag.Tag) string {
+	elem, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return ""
+	}
+	s, err := elem.GetString()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(s)
+}
+
+// bytesValue 返回OB element的value, 没有时返回nil
+func bytesValue(elems []*dicom.Element, tag dicomtag.Tag) []byte {
+	elem, err := dicom.FindElementByTag(elems, tag)
+	if err != nil || len(elem.Value) != 1 {
+		return nil
+	}
+	b, _ := elem.Value[0].([]byte)
+	return b
+}
+
+func pathString(path []dicomtag.Tag, tag dicomtag.Tag) string {
+	var names []string
+	for _, t := range append(path[:len(path):len(path)], tag) {
+		names = append(names, dicomtag.DebugString(t))
+	}
+	return strings.Join(names, ".")
+}
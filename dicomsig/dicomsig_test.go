@@ -0,0 +1,109 @@
+package dicomsig_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomsig"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Dr. Li Si"},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der
+}
+
+var authorSignature = dicom.Code{Value: "1", Designator: "ASTM-sigpurpose", Meaning: "Author's Signature"}
+
+func newSignature(id uint16, uid string, cert []byte) []*dicom.Element {
+	return []*dicom.Element{
+		dicom.MustNewElement(dicomtag.MACIDNumber, id),
+		dicom.MustNewElement(dicomtag.DigitalSignatureUID, uid),
+		dicom.MustNewElement(dicomtag.DigitalSignatureDateTime, "20200102030405+0800"),
+		dicom.MustNewElement(dicomtag.CertificateType, "X509_1993_SIG"),
+		dicom.MustNewElement(dicomtag.CertificateOfSigner, cert),
+		dicom.MustNewElement(dicomtag.Signature, []byte{1, 2, 3, 4}),
+		dicom.MustNewSequence(dicomtag.DigitalSignaturePurposeCodeSequence, authorSignature.ToItem()),
+	}
+}
+
+func newMACParameters(id uint16) []*dicom.Element {
+	return []*dicom.Element{
+		dicom.MustNewElement(dicomtag.MACIDNumber, id),
+		dicom.MustNewElement(dicomtag.MACCalculationTransferSyntaxUID, dicomuid.ExplicitVRLittleEndian),
+		dicom.MustNewElement(dicomtag.MACAlgorithm, "SHA256"),
+		dicom.MustNewElement(dicomtag.DataElementsSigned, dicomtag.PatientName, dicomtag.StudyInstanceUID),
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	cert := newCertificate(t)
+	ds, err := dicomtest.NewDataSet(dicomtest.Spec{})
+	require.NoError(t, err)
+	ds.Put(dicom.MustNewSequence(dicomtag.ReferencedImageSequence, []*dicom.Element{
+		dicom.MustNewElement(dicomtag.ReferencedSOPClassUID, dicomuid.CTImageStorage),
+		dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, "1.2.3.4"),
+		dicom.MustNewSequence(dicomtag.MACParametersSequence, newMACParameters(2)),
+		dicom.MustNewSequence(dicomtag.DigitalSignaturesSequence, newSignature(2, "1.2.3.100.2", cert)),
+	}))
+	ds.Put(dicom.MustNewSequence(dicomtag.MACParametersSequence, newMACParameters(1)))
+	ds.Put(dicom.MustNewSequence(dicomtag.DigitalSignaturesSequence, newSignature(1, "1.2.3.100.1", cert)))
+
+	// 写出去再读回来
+	var buf bytes.Buffer
+	require.NoError(t, dicom.WriteDataSet(&buf, ds))
+	ds, err = dicom.ReadDataSetInBytes(buf.Bytes(), dicom.ReadOptions{})
+	require.NoError(t, err)
+
+	sigs, err := dicomsig.Signatures(ds)
+	require.NoError(t, err)
+	require.Len(t, sigs, 2)
+	item, top := sigs[0], sigs[1]
+	assert.Equal(t, []dicomtag.Tag{dicomtag.ReferencedImageSequence}, item.Path)
+	assert.Equal(t, "1.2.3.100.2", item.UID)
+	assert.Equal(t, uint16(2), item.MAC.IDNumber)
+
+	assert.Nil(t, top.Path)
+	assert.Equal(t, "1.2.3.100.1", top.UID)
+	assert.True(t, time.Date(2020, 1, 1, 19, 4, 5, 0, time.UTC).Equal(top.DateTime))
+	assert.Equal(t, []byte{1, 2, 3, 4}, top.Signature)
+	assert.Equal(t, []dicom.Code{authorSignature}, top.Purposes)
+	require.NotNil(t, top.MAC)
+	assert.Equal(t, "SHA256", top.MAC.Algorithm)
+	assert.Equal(t, dicomuid.ExplicitVRLittleEndian, top.MAC.TransferSyntaxUID)
+	assert.Equal(t, []dicomtag.Tag{dicomtag.PatientName, dicomtag.StudyInstanceUID}, top.MAC.DataElementsSigned)
+	signer, err := top.X509Certificate()
+	require.NoError(t, err)
+	assert.Equal(t, "Dr. Li Si", signer.Subject.CommonName)
+
+	top.CertificateType = "X509_1993_SIG_OTHER"
+	_, err = top.X509Certificate()
+	assert.Error(t, err)
+
+	unsigned, err := dicomtest.NewDataSet(dicomtest.Spec{})
+	require.NoError(t, err)
+	sigs, err = dicomsig.Signatures(unsigned)
+	require.NoError(t, err)
+	assert.Empty(t, sigs)
+}
@@ -0,0 +1,368 @@
+package dicomstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// IndexedTags are the attributes that FileStore keeps in its index, and so
+// the ones FileStore.Query can filter on. They are the usual patient, study,
+// series and instance level query keys.
+var IndexedTags = []dicomtag.Tag{
+	dicomtag.PatientID,
+	dicomtag.PatientName,
+	dicomtag.PatientBirthDate,
+	dicomtag.PatientSex,
+	dicomtag.StudyInstanceUID,
+	dicomtag.StudyDate,
+	dicomtag.StudyTime,
+	dicomtag.AccessionNumber,
+	dicomtag.StudyID,
+	dicomtag.StudyDescription,
+	dicomtag.ReferringPhysicianName,
+	dicomtag.SeriesInstanceUID,
+	dicomtag.Modality,
+	dicomtag.SeriesNumber,
+	dicomtag.SeriesDescription,
+	dicomtag.SOPClassUID,
+	dicomtag.SOPInstanceUID,
+	dicomtag.InstanceNumber,
+}
+
+// indexFileName 是FileStore的index文件, 在FileStore的根目录下
+const indexFileName = "index.json"
+
+// indexEntry 是index中的一个instance
+type indexEntry struct {
+	// Path 是相对于根目录的路径, 用"/"分隔
+	Path string `json:"path"`
+	// Attributes 是IndexedTags的值, key是tag的名称, 例如"PatientName"
+	Attributes map[string][]string `json:"attributes"`
+}
+
+// FileStore is a Store that keeps each instance in a file named
+// <StudyInstanceUID>/<SeriesInstanceUID>/<SOPInstanceUID>.dcm under its
+// directory, and the IndexedTags of every instance in index.json, which
+// Query reads instead of the files. The index is rewritten after each Put
+// and Delete, so FileStore suits archives of up to a few hundred thousand
+// instances; larger ones should implement Store on a database.
+//
+// Files must not be modified except through the FileStore; call Reindex
+// after doing so.
+type FileStore struct {
+	dir string
+
+	mu    sync.Mutex
+	index map[string]indexEntry // SOPInstanceUID -> entry
+}
+
+var _ Store = (*FileStore)(nil)
+
+// OpenFileStore opens the FileStore in directory "dir", creating it if
+// needed. If dir has no index, e.g., it was filled by another tool, the
+// index is built by reading the .dcm files in dir; files that can't be
+// read are left out of the index, see Reindex.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileStore{dir: dir, index: map[string]indexEntry{}}
+	data, err := ioutil.ReadFile(filepath.Join(dir, indexFileName))
+	if os.IsNotExist(err) {
+		if err := s.Reindex(); err != nil {
+			if _, ok := err.(*ReindexError); !ok {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.index); err != nil {
+		return nil, fmt.Errorf("dicomstore: %s: %v", indexFileName, err)
+	}
+	return s, nil
+}
+
+// ReindexError lists the files that Reindex left out of the index.
+type ReindexError struct {
+	// Files 是不能读的文件, 按路径排序. Path相对于FileStore的目录
+	Files []FileError
+}
+
+// FileError is a file that Reindex couldn't read, and why.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *ReindexError) Error() string {
+	return fmt.Sprintf("dicomstore.Reindex: %d file(s) not indexed, first %s: %v", len(e.Files), e.Files[0].Path, e.Files[0].Err)
+}
+
+// Reindex rebuilds the index from the .dcm files under the directory.
+// Files that can't be read, or have no Study, Series or SOP Instance UID,
+// are skipped: the index is rebuilt from the other files, and Reindex
+// returns a *ReindexError listing the skipped ones.
+func (s *FileStore) Reindex() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := map[string]indexEntry{}
+	var skipped []FileError
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		rel, relErr := filepath.Rel(s.dir, path)
+		if relErr != nil {
+			return relErr
+		}
+		if err != nil {
+			// 不能读的目录或文件: 跳过
+			skipped = append(skipped, FileError{Path: filepath.ToSlash(rel), Err: err})
+			return nil
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".dcm") {
+			return nil
+		}
+		ds, err := dicom.ReadDataSetFromFile(path, dicom.ReadOptions{DropPixelData: true})
+		if err == nil {
+			var key Key
+			if key, err = instanceKey(ds); err == nil {
+				index[key.SOPInstanceUID] = newIndexEntry(filepath.ToSlash(rel), ds)
+				return nil
+			}
+		}
+		skipped = append(skipped, FileError{Path: filepath.ToSlash(rel), Err: err})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.index = index
+	if err := s.saveIndex(); err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		return &ReindexError{Files: skipped}
+	}
+	return nil
+}
+
+// instanceKey 返回ds的Key. 三个UID都必须存在
+func instanceKey(ds *dicom.DataSet) (Key, error) {
+	var key Key
+	for _, field := range []struct {
+		tag   dicomtag.Tag
+		value *string
+	}{
+		{dicomtag.StudyInstanceUID, &key.StudyInstanceUID},
+		{dicomtag.SeriesInstanceUID, &key.SeriesInstanceUID},
+		{dicomtag.SOPInstanceUID, &key.SOPInstanceUID},
+	} {
+		if elem, err := ds.FindElementByTag(field.tag); err == nil {
+			*field.value, _ = elem.GetString()
+		}
+		if *field.value == "" {
+			return Key{}, fmt.Errorf("data set has no %s", dicomtag.DebugString(field.tag))
+		}
+	}
+	return key, nil
+}
+
+func newIndexEntry(path string, ds *dicom.DataSet) indexEntry {
+	entry := indexEntry{Path: path, Attributes: map[string][]string{}}
+	for _, tag := range IndexedTags {
+		elem, err := ds.FindElementByTag(tag)
+		if err != nil {
+			continue
+		}
+		if values, err := elem.GetStrings(); err == nil {
+			entry.Attributes[dicomtag.MustFind(tag).Name] = values
+		}
+	}
+	return entry
+}
+
+func (e indexEntry) key() Key {
+	first := func(tag dicomtag.Tag) string {
+		if values := e.Attributes[dicomtag.MustFind(tag).Name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	return Key{
+		StudyInstanceUID:  first(dicomtag.StudyInstanceUID),
+		SeriesInstanceUID: first(dicomtag.SeriesInstanceUID),
+		SOPInstanceUID:    first(dicomtag.SOPInstanceUID),
+	}
+}
+
+// dataSet 返回只包含indexed attributes的DataSet, 用于dicom.Query
+func (e indexEntry) dataSet() *dicom.DataSet {
+	ds := &dicom.DataSet{}
+	for _, tag := range IndexedTags {
+		values, ok := e.Attributes[dicomtag.MustFind(tag).Name]
+		if !ok {
+			continue
+		}
+		v := make([]interface{}, len(values))
+		for i, value := range values {
+			v[i] = value
+		}
+		ds.Put(dicom.MustNewElement(tag, v...))
+	}
+	return ds
+}
+
+// saveIndex 写入index. 先写临时文件再rename, 所以index总是完整的. 调用时必须持有s.mu
+func (s *FileStore) saveIndex() error {
+	data, err := json.Marshal(s.index)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, indexFileName), data)
+}
+
+// writeFileAtomic 把data写到path旁边的临时文件, 再rename成path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if e := tmp.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Put implements Store. The file is written with dicom.WriteDataSet.
+func (s *FileStore) Put(ds *dicom.DataSet) (Key, error) {
+	key, err := instanceKey(ds)
+	if err != nil {
+		return Key{}, fmt.Errorf("dicomstore.Put: %v", err)
+	}
+	var data bytes.Buffer
+	if err := dicom.WriteDataSet(&data, ds); err != nil {
+		return Key{}, fmt.Errorf("dicomstore.Put: %v", err)
+	}
+	sanitize := dicom.DefaultFileNameSanitizer.Sanitize
+	rel := sanitize(key.StudyInstanceUID) + "/" + sanitize(key.SeriesInstanceUID) + "/" + sanitize(key.SOPInstanceUID) + ".dcm"
+	path := filepath.Join(s.dir, filepath.FromSlash(rel))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Key{}, err
+	}
+	if err := writeFileAtomic(path, data.Bytes()); err != nil {
+		return Key{}, err
+	}
+	// 同一个SOPInstanceUID之前在另一个study或series里
+	if old, ok := s.index[key.SOPInstanceUID]; ok && old.Path != rel {
+		s.removeFile(old.Path)
+	}
+	s.index[key.SOPInstanceUID] = newIndexEntry(rel, ds)
+	return key, s.saveIndex()
+}
+
+// removeFile 删除文件, 以及因此变空的series和study目录. 调用时必须持有s.mu
+func (s *FileStore) removeFile(rel string) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(rel))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != filepath.Clean(s.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil { // 目录不为空
+			break
+		}
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *FileStore) Get(key Key) (*dicom.DataSet, error) {
+	s.mu.Lock()
+	entry, ok := s.index[key.SOPInstanceUID]
+	s.mu.Unlock()
+	if !ok || !key.matches(entry.key()) {
+		return nil, ErrNotFound
+	}
+	return dicom.ReadDataSetFromFile(filepath.Join(s.dir, filepath.FromSlash(entry.Path)), dicom.ReadOptions{})
+}
+
+// Query implements Store. Filters on attributes that are not in
+// IndexedTags are errors, unless they are universal matches.
+func (s *FileStore) Query(filters []*dicom.Element) ([]Key, error) {
+	indexed := map[dicomtag.Tag]bool{dicomtag.QueryRetrieveLevel: true, dicomtag.SpecificCharacterSet: true}
+	for _, tag := range IndexedTags {
+		indexed[tag] = true
+	}
+	for _, f := range filters {
+		if !indexed[f.Tag] && len(f.Value) > 0 {
+			return nil, fmt.Errorf("dicomstore.Query: %s is not indexed", dicomtag.DebugString(f.Tag))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []Key
+	for _, entry := range s.index {
+		ds := entry.dataSet()
+		match := true
+		for _, f := range filters {
+			ok, _, err := dicom.Query(ds, f)
+			if err != nil {
+				return nil, fmt.Errorf("dicomstore.Query: %v", err)
+			}
+			if !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			keys = append(keys, entry.key())
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.StudyInstanceUID != b.StudyInstanceUID {
+			return a.StudyInstanceUID < b.StudyInstanceUID
+		}
+		if a.SeriesInstanceUID != b.SeriesInstanceUID {
+			return a.SeriesInstanceUID < b.SeriesInstanceUID
+		}
+		return a.SOPInstanceUID < b.SOPInstanceUID
+	})
+	return keys, nil
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.index[key.SOPInstanceUID]
+	if !ok || !key.matches(entry.key()) {
+		return ErrNotFound
+	}
+	if err := s.removeFile(entry.Path); err != nil {
+		return err
+	}
+	delete(s.index, key.SOPInstanceUID)
+	return s.saveIndex()
+}
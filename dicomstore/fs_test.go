@@ -0,0 +1,114 @@
+package dicomstore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomstore"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInstance(patientName, studyUID, seriesUID, sopUID, modality string) *dicom.DataSet {
+	return &dicom.DataSet{Elements: []*dicom.Element{
+		dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, "1.2.840.10008.5.1.4.1.1.7"),
+		dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, sopUID),
+		dicom.MustNewElement(dicomtag.TransferSyntaxUID, dicomuid.ExplicitVRLittleEndian),
+		dicom.MustNewElement(dicomtag.SOPClassUID, "1.2.840.10008.5.1.4.1.1.7"),
+		dicom.MustNewElement(dicomtag.SOPInstanceUID, sopUID),
+		dicom.MustNewElement(dicomtag.Modality, modality),
+		dicom.MustNewElement(dicomtag.PatientName, patientName),
+		dicom.MustNewElement(dicomtag.StudyInstanceUID, studyUID),
+		dicom.MustNewElement(dicomtag.SeriesInstanceUID, seriesUID),
+	}}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dicomstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := dicomstore.OpenFileStore(dir)
+	require.NoError(t, err)
+	for _, ds := range []*dicom.DataSet{
+		newInstance("Zhang^San", "1.2.3", "1.2.3.1", "1.2.3.1.1", "CT"),
+		newInstance("Zhang^San", "1.2.3", "1.2.3.1", "1.2.3.1.2", "CT"),
+		newInstance("Li^Si", "1.2.4", "1.2.4.1", "1.2.4.1.1", "MR"),
+	} {
+		_, err := s.Put(ds)
+		require.NoError(t, err)
+	}
+	_, err = s.Put(&dicom.DataSet{})
+	assert.Error(t, err)
+
+	key := dicomstore.Key{StudyInstanceUID: "1.2.3", SeriesInstanceUID: "1.2.3.1", SOPInstanceUID: "1.2.3.1.2"}
+	ds, err := s.Get(key)
+	require.NoError(t, err)
+	elem, err := ds.FindElementByTag(dicomtag.SOPInstanceUID)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.1.2", elem.MustGetString())
+	_, err = s.Get(dicomstore.Key{StudyInstanceUID: "1.2.4", SOPInstanceUID: "1.2.3.1.2"})
+	assert.Equal(t, dicomstore.ErrNotFound, err)
+
+	keys, err := s.Query([]*dicom.Element{dicom.MustNewElement(dicomtag.PatientName, "Zhang*")})
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "1.2.3.1.1", keys[0].SOPInstanceUID)
+	assert.Equal(t, key, keys[1])
+	keys, err = s.Query([]*dicom.Element{dicom.MustNewElement(dicomtag.Modality, "MR")})
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+	keys, err = s.Query(nil)
+	require.NoError(t, err)
+	assert.Len(t, keys, 3)
+	_, err = s.Query([]*dicom.Element{dicom.MustNewElement(dicomtag.BodyPartExamined, "HEAD")})
+	assert.Error(t, err)
+
+	// Moving an instance to another series removes the old file.
+	_, err = s.Put(newInstance("Li^Si", "1.2.4", "1.2.4.2", "1.2.4.1.1", "MR"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "1.2.4", "1.2.4.1"))
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, s.Delete(key))
+	assert.Equal(t, dicomstore.ErrNotFound, s.Delete(key))
+	_, err = s.Get(key)
+	assert.Equal(t, dicomstore.ErrNotFound, err)
+
+	// The index is persisted, and can be rebuilt from the files.
+	for _, rebuild := range []bool{false, true} {
+		if rebuild {
+			require.NoError(t, os.Remove(filepath.Join(dir, "index.json")))
+		}
+		s, err = dicomstore.OpenFileStore(dir)
+		require.NoError(t, err)
+		keys, err = s.Query(nil)
+		require.NoError(t, err)
+		assert.Equal(t, []dicomstore.Key{
+			{StudyInstanceUID: "1.2.3", SeriesInstanceUID: "1.2.3.1", SOPInstanceUID: "1.2.3.1.1"},
+			{StudyInstanceUID: "1.2.4", SeriesInstanceUID: "1.2.4.2", SOPInstanceUID: "1.2.4.1.1"},
+		}, keys)
+	}
+
+	// Unreadable files are left out of the index.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1.2.3", "broken.dcm"), []byte("not DICOM"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(dir, "index.json")))
+	s, err = dicomstore.OpenFileStore(dir)
+	require.NoError(t, err)
+	keys, err = s.Query(nil)
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+	err = s.Reindex()
+	reindexErr, ok := err.(*dicomstore.ReindexError)
+	require.True(t, ok, "error: %v", err)
+	require.Len(t, reindexErr.Files, 1)
+	assert.Equal(t, "1.2.3/broken.dcm", reindexErr.Files[0].Path)
+	keys, err = s.Query(nil)
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
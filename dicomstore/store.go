@@ -0,0 +1,48 @@
+// Package dicomstore defines Store, the storage backend of DICOM instances,
+// and FileStore, a Store on the local file system.
+package dicomstore
+
+import (
+	"errors"
+
+	"github.com/odincare/odicom"
+)
+
+// ErrNotFound is returned by Store.Get and Store.Delete when the instance
+// doesn't exist.
+var ErrNotFound = errors.New("dicomstore: instance not found")
+
+// Key identifies an instance. SOPInstanceUID alone is unique; Get and
+// Delete also check StudyInstanceUID and SeriesInstanceUID if they are set.
+type Key struct {
+	StudyInstanceUID  string
+	SeriesInstanceUID string
+	SOPInstanceUID    string
+}
+
+// Store stores DICOM instances, keyed by their UIDs. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Put stores "ds", replacing the instance with the same SOPInstanceUID
+	// if any. ds must have StudyInstanceUID, SeriesInstanceUID and
+	// SOPInstanceUID.
+	Put(ds *dicom.DataSet) (Key, error)
+
+	// Get returns the instance with "key", or ErrNotFound.
+	Get(key Key) (*dicom.DataSet, error)
+
+	// Query returns the keys of the instances that match every filter in
+	// "filters", as dicom.Query does. An empty filter list matches every
+	// instance.
+	Query(filters []*dicom.Element) ([]Key, error)
+
+	// Delete removes the instance with "key", or returns ErrNotFound.
+	Delete(key Key) error
+}
+
+// matches 检查key是否符合pattern: SOPInstanceUID必须相同, 其他UID为空时不检查
+func (pattern Key) matches(key Key) bool {
+	return pattern.SOPInstanceUID == key.SOPInstanceUID &&
+		(pattern.StudyInstanceUID == "" || pattern.StudyInstanceUID == key.StudyInstanceUID) &&
+		(pattern.SeriesInstanceUID == "" || pattern.SeriesInstanceUID == key.SeriesInstanceUID)
+}
@@ -2,6 +2,7 @@ package dicomtag
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -94,6 +95,10 @@ const (
 	VRDate
 	// VRPixelData means the element stores a PixelDataInfo
 	VRPixelData
+	// VRInt64List means the element stores a list of int64s
+	VRInt64List
+	// VRUInt64List means the element stores a list of uint64s
+	VRUInt64List
 )
 
 // GetVRKind 返回 go语言的 value encoding of an element with <tag, vr>.
@@ -108,12 +113,16 @@ func GetVRKind(tag Tag, vr string) VRKind {
 		return VRDate
 	case "AT":
 		return VRTagList
-	case "OW", "OB":
+	case "OW", "OB", "OV", "UN":
 		return VRBytes
 	case "LT", "UT":
 		return VRString
-	case "UL":
+	case "UL", "OL":
 		return VRUInt32List
+	case "SV":
+		return VRInt64List
+	case "UV":
+		return VRUInt64List
 	case "SL":
 		return VRInt32List
 	case "US":
@@ -131,6 +140,33 @@ func GetVRKind(tag Tag, vr string) VRKind {
 	}
 }
 
+// maxValueLengths 是每个VR的一个value的最大长度(字符数), P3.5 Table 6.2-1.
+// PN是每个component group的最大长度. 没有列出的VR没有限制
+var maxValueLengths = map[string]int{
+	"AE": 16,
+	"AS": 4,
+	"CS": 16,
+	"DA": 8,
+	"DS": 16,
+	"DT": 26,
+	"IS": 12,
+	"LO": 64,
+	"LT": 10240,
+	"PN": 64,
+	"SH": 16,
+	"ST": 1024,
+	"TM": 14,
+	"UI": 64,
+}
+
+// MaxValueLength returns the maximum length, in characters, of one value of
+// the given string VR, e.g., 64 for LO, or 0 if the VR has no limit (UC, UT,
+// UR, binary VRs). For PN, the limit applies to each component group. In
+// queries, DA, DT and TM values may be ranges, up to twice as long.
+func MaxValueLength(vr string) int {
+	return maxValueLengths[vr]
+}
+
 // 找到给与的tag中的信息
 // 如果tag不是dicom standard的一部分或已经不再在dicom standard中 会返回错误
 func Find(tag Tag) (TagInfo, error) {
@@ -138,7 +174,8 @@ func Find(tag Tag) (TagInfo, error) {
 	entry, ok := tagDict[tag]
 	if !ok {
 		// (0000-u-ffff,0000)	UL	GenericGroupLength	1	GENERIC
-		if tag.Group%2 == 0 && tag.Element == 0x0000 {
+		// private groups (奇数group) 的group length也是UL (P3.5 7.2)
+		if tag.Element == 0x0000 {
 			entry = TagInfo{tag, "UL", "GenericGroupLength", "1"}
 		} else {
 			return TagInfo{}, fmt.Errorf("Could not find tag (0x%x, 0x%x) in dictionary", tag.Group, tag.Element)
@@ -169,6 +206,34 @@ func FindByName(name string) (TagInfo, error) {
 	return TagInfo{}, fmt.Errorf("could not find tag with name %s", name)
 }
 
+// All 返回dictionary中所有的entries, 按tag排序. 返回的slice可以被caller修改
+func All() []TagInfo {
+	return Select(func(TagInfo) bool { return true })
+}
+
+// Select 返回dictionary中满足"match"的entries, 按tag排序
+func Select(match func(TagInfo) bool) []TagInfo {
+	maybeInitTagDict()
+	var entries []TagInfo
+	for _, ent := range tagDict {
+		if match(ent) {
+			entries = append(entries, ent)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Tag.Compare(entries[j].Tag) < 0 })
+	return entries
+}
+
+// ByVR 返回dictionary中VR为"vr"的entries, 按tag排序
+func ByVR(vr string) []TagInfo {
+	return Select(func(ent TagInfo) bool { return ent.VR == vr })
+}
+
+// ByGroup 返回dictionary中group为"group"的entries, 按tag排序
+func ByGroup(group uint16) []TagInfo {
+	return Select(func(ent TagInfo) bool { return ent.Tag.Group == group })
+}
+
 // DebugString 返回一个人类可读的tag的诊断字符串，格式如 "(group, element)[name]"
 func DebugString(tag Tag) string {
 	e, err := Find(tag)
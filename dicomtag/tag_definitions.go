@@ -1,5 +1,7 @@
 package dicomtag
 
+import "sync"
+
 var CommandGroupLength = Tag{0x0000, 0x0000}
 var AffectedSOPClassUID = Tag{0x0000, 0x0002}
 var RequestedSOPClassUID = Tag{0x0000, 0x0003}
@@ -108,6 +110,8 @@ var CodingSchemeExternalID = Tag{0x0008, 0x0114}
 var CodingSchemeName = Tag{0x0008, 0x0115}
 var CodingSchemeResponsibleOrganization = Tag{0x0008, 0x0116}
 var ContextUID = Tag{0x0008, 0x0117}
+var LongCodeValue = Tag{0x0008, 0x0119}
+var URNCodeValue = Tag{0x0008, 0x0120}
 var TimezoneOffsetFromUTC = Tag{0x0008, 0x0201}
 var StationName = Tag{0x0008, 0x1010}
 var StudyDescription = Tag{0x0008, 0x1030}
@@ -145,10 +149,13 @@ var CalculatedFrameList = Tag{0x0008, 0x1162}
 var TimeRange = Tag{0x0008, 0x1163}
 var FrameExtractionSequence = Tag{0x0008, 0x1164}
 var MultiFrameSourceSOPInstanceUID = Tag{0x0008, 0x1167}
+var RetrieveURL = Tag{0x0008, 0x1190}
 var TransactionUID = Tag{0x0008, 0x1195}
+var WarningReason = Tag{0x0008, 0x1196}
 var FailureReason = Tag{0x0008, 0x1197}
 var FailedSOPSequence = Tag{0x0008, 0x1198}
 var ReferencedSOPSequence = Tag{0x0008, 0x1199}
+var OtherFailuresSequence = Tag{0x0008, 0x119A}
 var StudiesContainingOtherReferencedInstancesSequence = Tag{0x0008, 0x1200}
 var RelatedSeriesSequence = Tag{0x0008, 0x1250}
 var DerivationDescription = Tag{0x0008, 0x2111}
@@ -3206,6 +3213,8 @@ var WaveformPaddingValue = Tag{0x5400, 0x100A}
 var WaveformData = Tag{0x5400, 0x1010}
 var FirstOrderPhaseCorrectionAngle = Tag{0x5600, 0x0010}
 var SpectroscopyData = Tag{0x5600, 0x0020}
+var ExtendedOffsetTable = Tag{0x7FE0, 0x0001}
+var ExtendedOffsetTableLengths = Tag{0x7FE0, 0x0002}
 var PixelData = Tag{0x7FE0, 0x0010}
 var DigitalSignaturesSequence = Tag{0xFFFA, 0xFFFA}
 var DataSetTrailingPadding = Tag{0xFFFC, 0xFFFC}
@@ -3363,15 +3372,23 @@ var ACR_NEMA_2C_ZonalMap = Tag{0x1010, 0x0004}
 var ACR_NEMA_2C_CoefficientsSDVN = Tag{0x7FE0, 0x0020}
 var ACR_NEMA_2C_CoefficientsSDHN = Tag{0x7FE0, 0x0030}
 var ACR_NEMA_2C_CoefficientsSDDN = Tag{0x7FE0, 0x0040}
-var tagDict map[Tag]TagInfo
+
+// tagDict 在initTagDict之后不再被修改, 所以可以被多个goroutine同时读
+var (
+	tagDict     map[Tag]TagInfo
+	tagDictOnce sync.Once
+)
 
 func init() {
 	maybeInitTagDict()
 }
+
+// maybeInitTagDict 初始化tagDict. 可以被多个goroutine同时调用
 func maybeInitTagDict() {
-	if len(tagDict) > 0 {
-		return
-	}
+	tagDictOnce.Do(initTagDict)
+}
+
+func initTagDict() {
 	tagDict = make(map[Tag]TagInfo)
 	tagDict[Tag{0x0000, 0x0000}] = TagInfo{Tag{0x0000, 0x0000}, "UL", "CommandGroupLength", "1"}
 	tagDict[Tag{0x0000, 0x0002}] = TagInfo{Tag{0x0000, 0x0002}, "UI", "AffectedSOPClassUID", "1"}
@@ -3410,13 +3427,13 @@ func maybeInitTagDict() {
 	tagDict[Tag{0x0004, 0x1130}] = TagInfo{Tag{0x0004, 0x1130}, "CS", "FileSetID", "1"}
 	tagDict[Tag{0x0004, 0x1141}] = TagInfo{Tag{0x0004, 0x1141}, "CS", "FileSetDescriptorFileID", "1-8"}
 	tagDict[Tag{0x0004, 0x1142}] = TagInfo{Tag{0x0004, 0x1142}, "CS", "SpecificCharacterSetOfFileSetDescriptorFile", "1"}
-	tagDict[Tag{0x0004, 0x1200}] = TagInfo{Tag{0x0004, 0x1200}, "UP", "OffsetOfTheFirstDirectoryRecordOfTheRootDirectoryEntity", "1"}
-	tagDict[Tag{0x0004, 0x1202}] = TagInfo{Tag{0x0004, 0x1202}, "UP", "OffsetOfTheLastDirectoryRecordOfTheRootDirectoryEntity", "1"}
+	tagDict[Tag{0x0004, 0x1200}] = TagInfo{Tag{0x0004, 0x1200}, "UL", "OffsetOfTheFirstDirectoryRecordOfTheRootDirectoryEntity", "1"}
+	tagDict[Tag{0x0004, 0x1202}] = TagInfo{Tag{0x0004, 0x1202}, "UL", "OffsetOfTheLastDirectoryRecordOfTheRootDirectoryEntity", "1"}
 	tagDict[Tag{0x0004, 0x1212}] = TagInfo{Tag{0x0004, 0x1212}, "US", "FileSetConsistencyFlag", "1"}
 	tagDict[Tag{0x0004, 0x1220}] = TagInfo{Tag{0x0004, 0x1220}, "SQ", "DirectoryRecordSequence", "1"}
-	tagDict[Tag{0x0004, 0x1400}] = TagInfo{Tag{0x0004, 0x1400}, "UP", "OffsetOfTheNextDirectoryRecord", "1"}
+	tagDict[Tag{0x0004, 0x1400}] = TagInfo{Tag{0x0004, 0x1400}, "UL", "OffsetOfTheNextDirectoryRecord", "1"}
 	tagDict[Tag{0x0004, 0x1410}] = TagInfo{Tag{0x0004, 0x1410}, "US", "RecordInUseFlag", "1"}
-	tagDict[Tag{0x0004, 0x1420}] = TagInfo{Tag{0x0004, 0x1420}, "UP", "OffsetOfReferencedLowerLevelDirectoryEntity", "1"}
+	tagDict[Tag{0x0004, 0x1420}] = TagInfo{Tag{0x0004, 0x1420}, "UL", "OffsetOfReferencedLowerLevelDirectoryEntity", "1"}
 	tagDict[Tag{0x0004, 0x1430}] = TagInfo{Tag{0x0004, 0x1430}, "CS", "DirectoryRecordType", "1"}
 	tagDict[Tag{0x0004, 0x1432}] = TagInfo{Tag{0x0004, 0x1432}, "UI", "PrivateRecordUID", "1"}
 	tagDict[Tag{0x0004, 0x1500}] = TagInfo{Tag{0x0004, 0x1500}, "CS", "ReferencedFileID", "1-8"}
@@ -3479,6 +3496,8 @@ func maybeInitTagDict() {
 	tagDict[Tag{0x0008, 0x0115}] = TagInfo{Tag{0x0008, 0x0115}, "ST", "CodingSchemeName", "1"}
 	tagDict[Tag{0x0008, 0x0116}] = TagInfo{Tag{0x0008, 0x0116}, "ST", "CodingSchemeResponsibleOrganization", "1"}
 	tagDict[Tag{0x0008, 0x0117}] = TagInfo{Tag{0x0008, 0x0117}, "UI", "ContextUID", "1"}
+	tagDict[Tag{0x0008, 0x0119}] = TagInfo{Tag{0x0008, 0x0119}, "UC", "LongCodeValue", "1"}
+	tagDict[Tag{0x0008, 0x0120}] = TagInfo{Tag{0x0008, 0x0120}, "UR", "URNCodeValue", "1"}
 	tagDict[Tag{0x0008, 0x0201}] = TagInfo{Tag{0x0008, 0x0201}, "SH", "TimezoneOffsetFromUTC", "1"}
 	tagDict[Tag{0x0008, 0x1010}] = TagInfo{Tag{0x0008, 0x1010}, "SH", "StationName", "1"}
 	tagDict[Tag{0x0008, 0x1030}] = TagInfo{Tag{0x0008, 0x1030}, "LO", "StudyDescription", "1"}
@@ -3516,10 +3535,13 @@ func maybeInitTagDict() {
 	tagDict[Tag{0x0008, 0x1163}] = TagInfo{Tag{0x0008, 0x1163}, "FD", "TimeRange", "2"}
 	tagDict[Tag{0x0008, 0x1164}] = TagInfo{Tag{0x0008, 0x1164}, "SQ", "FrameExtractionSequence", "1"}
 	tagDict[Tag{0x0008, 0x1167}] = TagInfo{Tag{0x0008, 0x1167}, "UI", "MultiFrameSourceSOPInstanceUID", "1"}
+	tagDict[Tag{0x0008, 0x1190}] = TagInfo{Tag{0x0008, 0x1190}, "UR", "RetrieveURL", "1"}
 	tagDict[Tag{0x0008, 0x1195}] = TagInfo{Tag{0x0008, 0x1195}, "UI", "TransactionUID", "1"}
+	tagDict[Tag{0x0008, 0x1196}] = TagInfo{Tag{0x0008, 0x1196}, "US", "WarningReason", "1"}
 	tagDict[Tag{0x0008, 0x1197}] = TagInfo{Tag{0x0008, 0x1197}, "US", "FailureReason", "1"}
 	tagDict[Tag{0x0008, 0x1198}] = TagInfo{Tag{0x0008, 0x1198}, "SQ", "FailedSOPSequence", "1"}
 	tagDict[Tag{0x0008, 0x1199}] = TagInfo{Tag{0x0008, 0x1199}, "SQ", "ReferencedSOPSequence", "1"}
+	tagDict[Tag{0x0008, 0x119A}] = TagInfo{Tag{0x0008, 0x119A}, "SQ", "OtherFailuresSequence", "1"}
 	tagDict[Tag{0x0008, 0x1200}] = TagInfo{Tag{0x0008, 0x1200}, "SQ", "StudiesContainingOtherReferencedInstancesSequence", "1"}
 	tagDict[Tag{0x0008, 0x1250}] = TagInfo{Tag{0x0008, 0x1250}, "SQ", "RelatedSeriesSequence", "1"}
 	tagDict[Tag{0x0008, 0x2111}] = TagInfo{Tag{0x0008, 0x2111}, "ST", "DerivationDescription", "1"}
@@ -4815,7 +4837,7 @@ func maybeInitTagDict() {
 	tagDict[Tag{0x0028, 0x3002}] = TagInfo{Tag{0x0028, 0x3002}, "US", "LUTDescriptor", "3"}
 	tagDict[Tag{0x0028, 0x3003}] = TagInfo{Tag{0x0028, 0x3003}, "LO", "LUTExplanation", "1"}
 	tagDict[Tag{0x0028, 0x3004}] = TagInfo{Tag{0x0028, 0x3004}, "LO", "ModalityLUTType", "1"}
-	tagDict[Tag{0x0028, 0x3006}] = TagInfo{Tag{0x0028, 0x3006}, "LT", "LUTData", "1-n"}
+	tagDict[Tag{0x0028, 0x3006}] = TagInfo{Tag{0x0028, 0x3006}, "OW", "LUTData", "1-n"}
 	tagDict[Tag{0x0028, 0x3010}] = TagInfo{Tag{0x0028, 0x3010}, "SQ", "VOILUTSequence", "1"}
 	tagDict[Tag{0x0028, 0x3110}] = TagInfo{Tag{0x0028, 0x3110}, "SQ", "SoftcopyVOILUTSequence", "1"}
 	tagDict[Tag{0x0028, 0x6010}] = TagInfo{Tag{0x0028, 0x6010}, "US", "RepresentativeFrameNumber", "1"}
@@ -6571,6 +6593,8 @@ func maybeInitTagDict() {
 	tagDict[Tag{0x5400, 0x1010}] = TagInfo{Tag{0x5400, 0x1010}, "OW", "WaveformData", "1"}
 	tagDict[Tag{0x5600, 0x0010}] = TagInfo{Tag{0x5600, 0x0010}, "OF", "FirstOrderPhaseCorrectionAngle", "1"}
 	tagDict[Tag{0x5600, 0x0020}] = TagInfo{Tag{0x5600, 0x0020}, "OF", "SpectroscopyData", "1"}
+	tagDict[Tag{0x7FE0, 0x0001}] = TagInfo{Tag{0x7FE0, 0x0001}, "OV", "ExtendedOffsetTable", "1"}
+	tagDict[Tag{0x7FE0, 0x0002}] = TagInfo{Tag{0x7FE0, 0x0002}, "OV", "ExtendedOffsetTableLengths", "1"}
 	tagDict[Tag{0x7FE0, 0x0010}] = TagInfo{Tag{0x7FE0, 0x0010}, "OW", "PixelData", "1"}
 	tagDict[Tag{0xFFFA, 0xFFFA}] = TagInfo{Tag{0xFFFA, 0xFFFA}, "SQ", "DigitalSignaturesSequence", "1"}
 	tagDict[Tag{0xFFFC, 0xFFFC}] = TagInfo{Tag{0xFFFC, 0xFFFC}, "OB", "DataSetTrailingPadding", "1"}
@@ -6764,7 +6788,7 @@ func maybeInitTagDict() {
 	tagDict[Tag{0x0000, 0x5190}] = TagInfo{Tag{0x0000, 0x5190}, "CS", "RETIRED_Erase", "1"}
 	tagDict[Tag{0x0000, 0x51A0}] = TagInfo{Tag{0x0000, 0x51A0}, "CS", "RETIRED_Print", "1"}
 	tagDict[Tag{0x0000, 0x51B0}] = TagInfo{Tag{0x0000, 0x51B0}, "US", "RETIRED_Overlays", "1-n"}
-	tagDict[Tag{0x0004, 0x1504}] = TagInfo{Tag{0x0004, 0x1504}, "UP", "RETIRED_MRDRDirectoryRecordOffset", "1"}
+	tagDict[Tag{0x0004, 0x1504}] = TagInfo{Tag{0x0004, 0x1504}, "UL", "RETIRED_MRDRDirectoryRecordOffset", "1"}
 	tagDict[Tag{0x0004, 0x1600}] = TagInfo{Tag{0x0004, 0x1600}, "UL", "RETIRED_NumberOfReferences", "1"}
 	tagDict[Tag{0x0008, 0x0001}] = TagInfo{Tag{0x0008, 0x0001}, "UL", "RETIRED_LengthToEnd", "1"}
 	tagDict[Tag{0x0008, 0x0010}] = TagInfo{Tag{0x0008, 0x0010}, "SH", "RETIRED_RecognitionCode", "1"}
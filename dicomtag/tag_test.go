@@ -62,3 +62,36 @@ func BenchmarkFindPixelDataTag(b *testing.B) {
 
 	}
 }
+
+func TestAll(t *testing.T) {
+	all := All()
+	if len(all) != len(tagDict) {
+		t.Errorf("All returned %d entries, expect %d", len(all), len(tagDict))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].Tag.Compare(all[i].Tag) >= 0 {
+			t.Fatalf("All is not sorted: %v before %v", all[i-1].Tag, all[i].Tag)
+		}
+	}
+
+	found := false
+	for _, ent := range ByVR("UI") {
+		if ent.VR != "UI" {
+			t.Errorf("ByVR(UI) returned %v", ent)
+		}
+		found = found || ent.Name == "TransferSyntaxUID"
+	}
+	if !found {
+		t.Error("ByVR(UI) misses TransferSyntaxUID")
+	}
+
+	meta := ByGroup(MetadataGroup)
+	if len(meta) == 0 || meta[0].Tag != FileMetaInformationGroupLength {
+		t.Errorf("Wrong metadata group entries: %v", meta)
+	}
+	for _, ent := range meta {
+		if ent.Tag.Group != MetadataGroup {
+			t.Errorf("ByGroup(2) returned %v", ent)
+		}
+	}
+}
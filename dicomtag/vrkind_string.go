@@ -4,9 +4,9 @@ package dicomtag
 
 import "fmt"
 
-const _VRKind_name = "VRStringListVRBytesVRStringVRUInt16ListVRUInt32ListVRInt16ListVRInt32ListVRFloat32ListVRFloat64ListVRSequenceVRItemVRTagListVRDateVRPixelData"
+const _VRKind_name = "VRStringListVRBytesVRStringVRUInt16ListVRUInt32ListVRInt16ListVRInt32ListVRFloat32ListVRFloat64ListVRSequenceVRItemVRTagListVRDateVRPixelDataVRInt64ListVRUInt64List"
 
-var _VRKind_index = [...]uint8{0, 12, 19, 27, 39, 51, 62, 73, 86, 99, 109, 115, 124, 130, 141}
+var _VRKind_index = [...]uint8{0, 12, 19, 27, 39, 51, 62, 73, 86, 99, 109, 115, 124, 130, 141, 152, 164}
 
 func (i VRKind) String() string {
 	if i < 0 || i >= VRKind(len(_VRKind_index)-1) {
@@ -0,0 +1,182 @@
+package dicomtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/odincare/odicom"
+)
+
+// ReferenceTool dumps DICOM files with a third-party toolkit, for checking
+// package dicom against it. See CheckConformance.
+type ReferenceTool struct {
+	// Name 是工具的名字, 例如"dcmtk"
+	Name string
+
+	available func() bool
+	command   func(path string) *exec.Cmd
+
+	// ignoreLine 返回true的行在比较时被忽略, 例如会被WriteDataSet重新计算的group length
+	ignoreLine func(line string) bool
+}
+
+// pydicomDumpScript 打印每个element的tag, VR和value. bulk data只打印长度和SHA-1,
+// SQ递归打印
+const pydicomDumpScript = `
+import hashlib, sys
+import pydicom
+
+def dump(ds, indent):
+    for elem in ds:
+        if elem.VR == "SQ":
+            print("%s%s SQ items=%d" % (indent, elem.tag, len(elem.value)))
+            for item in elem.value:
+                print("%s  item" % indent)
+                dump(item, indent + "    ")
+        elif isinstance(elem.value, bytes):
+            print("%s%s %s len=%d sha1=%s" % (indent, elem.tag, elem.VR, len(elem.value), hashlib.sha1(elem.value).hexdigest()))
+        else:
+            print("%s%s %s %r" % (indent, elem.tag, elem.VR, elem.value))
+
+ds = pydicom.dcmread(sys.argv[1])
+dump(ds.file_meta, "")
+dump(ds, "")
+`
+
+// DCMTK dumps files with dcmdump from DCMTK (https://dicom.offis.de/dcmtk).
+var DCMTK = ReferenceTool{
+	Name: "dcmtk",
+	available: func() bool {
+		_, err := exec.LookPath("dcmdump")
+		return err == nil
+	},
+	command: func(path string) *exec.Cmd {
+		// +L: 打印完整的长value
+		return exec.Command("dcmdump", "-q", "+L", path)
+	},
+	ignoreLine: func(line string) bool {
+		return strings.HasPrefix(line, "#") || strings.HasPrefix(line, "(0002,0000)")
+	},
+}
+
+// Pydicom dumps files with pydicom (https://pydicom.github.io), run by
+// python3.
+var Pydicom = ReferenceTool{
+	Name: "pydicom",
+	available: func() bool {
+		return exec.Command("python3", "-c", "import pydicom").Run() == nil
+	},
+	command: func(path string) *exec.Cmd {
+		return exec.Command("python3", "-c", pydicomDumpScript, path)
+	},
+	ignoreLine: func(line string) bool {
+		return strings.HasPrefix(line, "(0002, 0000)")
+	},
+}
+
+// ReferenceTools returns the reference tools that are installed.
+func ReferenceTools() []ReferenceTool {
+	var tools []ReferenceTool
+	for _, tool := range []ReferenceTool{DCMTK, Pydicom} {
+		if tool.available() {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// Dump returns the tool's dump of the DICOM file at "path", and what it
+// printed to stderr (warnings about the encoding). It returns an error if
+// the tool fails.
+func (t ReferenceTool) Dump(path string) (dump string, warnings string, err error) {
+	var stdout, stderr bytes.Buffer
+	cmd := t.command(path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("%s: %s: %v: %s", t.Name, path, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), strings.TrimSpace(stderr.String()), nil
+}
+
+// CheckConformance reads the DICOM file at "path" with package dicom, writes
+// it back, and checks that "tool" sees the same data set in both files and
+// has no warnings about the rewritten one. A difference means that package
+// dicom reads or writes some element differently from the tool.
+func CheckConformance(tool ReferenceTool, path string) error {
+	ds, err := dicom.ReadDataSetFromFile(path, dicom.ReadOptions{})
+	if err != nil {
+		return fmt.Errorf("dicomtest.CheckConformance: %s: %v", path, err)
+	}
+	out, err := ioutil.TempFile("", "conformance-*.dcm")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if err := dicom.WriteDataSet(out, ds); err != nil {
+		out.Close()
+		return fmt.Errorf("dicomtest.CheckConformance: %s: %v", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	want, _, err := tool.Dump(path)
+	if err != nil {
+		return err
+	}
+	got, warnings, err := tool.Dump(out.Name())
+	if err != nil {
+		return err
+	}
+	if warnings != "" {
+		return fmt.Errorf("dicomtest.CheckConformance: %s: %s warns about the rewritten file: %s", path, tool.Name, warnings)
+	}
+	if diff := diffLines(tool.dumpLines(want), tool.dumpLines(got)); diff != "" {
+		return fmt.Errorf("dicomtest.CheckConformance: %s: %s dumps differ after a round trip (-original +rewritten):\n%s", path, tool.Name, diff)
+	}
+	return nil
+}
+
+func (t ReferenceTool) dumpLines(dump string) []string {
+	var lines []string
+	for _, line := range strings.Split(dump, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || t.ignoreLine(strings.TrimSpace(line)) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// maxDiffLines 限制diffLines报告的不同的行数
+const maxDiffLines = 10
+
+// diffLines 逐行比较a和b, 返回不同的行. 这里不需要最小的diff: element是按tag排序的,
+// 出现一个不同的element通常就足以找到问题
+func diffLines(a, b []string) string {
+	var diff []string
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var lineA, lineB string
+		if i < len(a) {
+			lineA = a[i]
+		}
+		if i < len(b) {
+			lineB = b[i]
+		}
+		if lineA == lineB {
+			continue
+		}
+		if len(diff) >= 2*maxDiffLines {
+			diff = append(diff, "...")
+			break
+		}
+		diff = append(diff, "- "+lineA, "+ "+lineB)
+	}
+	return strings.Join(diff, "\n")
+}
@@ -0,0 +1,64 @@
+package dicomtest_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConformance round-trips the corpus, plus the *.dcm files in the
+// directory named by $ODICOM_CONFORMANCE_CORPUS, through each installed
+// reference tool. It is skipped if neither dcmdump nor pydicom is installed.
+func TestConformance(t *testing.T) {
+	tools := dicomtest.ReferenceTools()
+	if len(tools) == 0 {
+		t.Skip("no reference tool (dcmdump, pydicom) installed")
+	}
+	dir, err := ioutil.TempDir("", "conformance")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	paths, err := dicomtest.WriteCorpus(dir)
+	require.NoError(t, err)
+	if corpus := os.Getenv("ODICOM_CONFORMANCE_CORPUS"); corpus != "" {
+		extra, err := filepath.Glob(filepath.Join(corpus, "*.dcm"))
+		require.NoError(t, err)
+		paths = append(paths, extra...)
+	}
+
+	for _, tool := range tools {
+		for _, path := range paths {
+			tool, path := tool, path
+			t.Run(tool.Name+"/"+filepath.Base(path), func(t *testing.T) {
+				require.NoError(t, dicomtest.CheckConformance(tool, path))
+			})
+		}
+	}
+}
+
+// TestCheckConformance runs CheckConformance with a fake dcmdump that dumps
+// the file bytes, so it doesn't depend on DCMTK.
+func TestCheckConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conformance")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "dcmdump"),
+		[]byte("#!/bin/sh\nshift 2\necho \"$FAKE_DCMDUMP_WARNING\" >&2\nod -An -tx1 -v \"$1\"\n"), 0755))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	path := filepath.Join(dir, "test.dcm")
+	require.NoError(t, dicomtest.WriteFile(path, dicomtest.Spec{}))
+	require.NoError(t, dicomtest.CheckConformance(dicomtest.DCMTK, path))
+
+	// Warnings about the rewritten file fail the check.
+	defer os.Unsetenv("FAKE_DCMDUMP_WARNING")
+	os.Setenv("FAKE_DCMDUMP_WARNING", "W: DcmElement: PatientName has odd length")
+	err = dicomtest.CheckConformance(dicomtest.DCMTK, path)
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "odd length"), err.Error())
+}
@@ -0,0 +1,348 @@
+// Package dicomtest synthesizes small, valid DICOM files for unit tests.
+//
+// Every file built by this package contains one element of each VR that
+// package dicom can write, a PatientName encoded in the requested
+// SpecificCharacterSet, and pixel data laid out as requested (native or
+// encapsulated, one or more frames). Pixel values follow a fixed pattern, see
+// FramePixels, so tests can check what they read back.
+//
+// Encapsulated frames hold the native pixel bytes as-is; they are not valid
+// JPEG or RLE bitstreams, and only the DICOM structure around them is.
+package dicomtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// Encapsulated transfer syntaxes covered by Corpus.
+const (
+	JPEGBaseline = "1.2.840.10008.1.2.4.50"
+	JPEGLossless = "1.2.840.10008.1.2.4.70"
+	JPEG2000     = "1.2.840.10008.1.2.4.90"
+	RLELossless  = "1.2.840.10008.1.2.5"
+)
+
+// sopInstanceID is the SOPInstanceUID of every synthesized file. Other UIDs
+// are derived from it.
+const sopInstanceID = "1.2.826.0.1.3680043.2.1143.1"
+
+// Spec describes one synthesized file.
+type Spec struct {
+	// Name is the file name used by WriteCorpus, e.g., "explicit_le_native_8bit.dcm".
+	Name string
+
+	// TransferSyntaxUID of the dataset. Defaults to ExplicitVRLittleEndian.
+	TransferSyntaxUID string
+
+	// SpecificCharacterSet, with values separated by '\\', e.g., "ISO_IR 100"
+	// or "\\ISO 2022 IR 149". PatientName is encoded in it.
+	SpecificCharacterSet string
+
+	// PatientName in UTF-8. Defaults to "Test^Patient".
+	PatientName string
+
+	// Image geometry. Defaults are 4x4, 8 bits, 1 sample, 1 frame.
+	Rows, Columns   uint16
+	BitsAllocated   uint16 // 8 or 16
+	SamplesPerPixel uint16 // 1 or 3
+	NumberOfFrames  int
+}
+
+// Encapsulated returns true if the transfer syntax of the spec stores pixel
+// data as a sequence of fragments.
+func (s Spec) Encapsulated() bool {
+	switch s.withDefaults().TransferSyntaxUID {
+	case dicomuid.ImplicitVRLittleEndian, dicomuid.ExplicitVRLittleEndian, dicomuid.ExplicitVRBigEndian:
+		return false
+	}
+	return true
+}
+
+func (s Spec) withDefaults() Spec {
+	if s.TransferSyntaxUID == "" {
+		s.TransferSyntaxUID = dicomuid.ExplicitVRLittleEndian
+	}
+	if s.PatientName == "" {
+		s.PatientName = "Test^Patient"
+	}
+	if s.Rows == 0 {
+		s.Rows = 4
+	}
+	if s.Columns == 0 {
+		s.Columns = 4
+	}
+	if s.BitsAllocated == 0 {
+		s.BitsAllocated = 8
+	}
+	if s.SamplesPerPixel == 0 {
+		s.SamplesPerPixel = 1
+	}
+	if s.NumberOfFrames == 0 {
+		s.NumberOfFrames = 1
+	}
+	return s
+}
+
+// Corpus returns specs covering each transfer syntax, character set and
+// pixel data layout that package dicom supports.
+func Corpus() []Spec {
+	var specs []Spec
+	native := []struct {
+		prefix string
+		uid    string
+	}{
+		{"implicit_le", dicomuid.ImplicitVRLittleEndian},
+		{"explicit_le", dicomuid.ExplicitVRLittleEndian},
+		{"explicit_be", dicomuid.ExplicitVRBigEndian},
+	}
+	for _, ts := range native {
+		specs = append(specs,
+			Spec{Name: ts.prefix + "_native_8bit.dcm", TransferSyntaxUID: ts.uid},
+			Spec{Name: ts.prefix + "_native_16bit.dcm", TransferSyntaxUID: ts.uid, BitsAllocated: 16},
+			Spec{Name: ts.prefix + "_native_rgb.dcm", TransferSyntaxUID: ts.uid, SamplesPerPixel: 3},
+			Spec{Name: ts.prefix + "_native_16bit_3frames.dcm", TransferSyntaxUID: ts.uid, BitsAllocated: 16, NumberOfFrames: 3})
+	}
+	encapsulated := []struct {
+		prefix string
+		uid    string
+	}{
+		{"jpeg_baseline", JPEGBaseline},
+		{"jpeg_lossless", JPEGLossless},
+		{"jpeg2000", JPEG2000},
+		{"rle", RLELossless},
+	}
+	for _, ts := range encapsulated {
+		specs = append(specs,
+			Spec{Name: ts.prefix + "_1frame.dcm", TransferSyntaxUID: ts.uid},
+			Spec{Name: ts.prefix + "_3frames.dcm", TransferSyntaxUID: ts.uid, NumberOfFrames: 3})
+	}
+	specs = append(specs,
+		Spec{Name: "charset_latin1.dcm", TransferSyntaxUID: dicomuid.ExplicitVRLittleEndian, SpecificCharacterSet: "ISO_IR 100", PatientName: "Buc^Jérôme"},
+		Spec{Name: "charset_latin2.dcm", TransferSyntaxUID: dicomuid.ExplicitVRLittleEndian, SpecificCharacterSet: "ISO_IR 101", PatientName: "Dvořák^Antonín"},
+		Spec{Name: "charset_cyrillic.dcm", TransferSyntaxUID: dicomuid.ExplicitVRLittleEndian, SpecificCharacterSet: "ISO_IR 144", PatientName: "Люксембург"},
+		Spec{Name: "charset_utf8.dcm", TransferSyntaxUID: dicomuid.ExplicitVRLittleEndian, SpecificCharacterSet: "ISO_IR 192", PatientName: "张^三"},
+		Spec{Name: "charset_korean.dcm", TransferSyntaxUID: dicomuid.ExplicitVRLittleEndian, SpecificCharacterSet: "\\ISO 2022 IR 149", PatientName: "Hong^Gildong=洪^吉洞=홍^길동"},
+		Spec{Name: "charset_chinese.dcm", TransferSyntaxUID: dicomuid.ExplicitVRLittleEndian, SpecificCharacterSet: "\\ISO 2022 IR 58", PatientName: "Zhang^XiaoDong=张^小东="})
+	return specs
+}
+
+// FramePixels returns the pixel values of the given frame, in native
+// little-endian byte order with interleaved samples.
+func FramePixels(spec Spec, frame int) []byte {
+	spec = spec.withDefaults()
+	n := int(spec.Rows) * int(spec.Columns) * int(spec.SamplesPerPixel)
+	if spec.BitsAllocated == 8 {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(frame*31 + i*7)
+		}
+		return data
+	}
+	data := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(frame*1009+i*257))
+	}
+	return data
+}
+
+// NewDataSet builds the dataset described by "spec". The result can be
+// written with dicom.WriteDataSet.
+func NewDataSet(spec Spec) (*dicom.DataSet, error) {
+	spec = spec.withDefaults()
+	if spec.BitsAllocated != 8 && spec.BitsAllocated != 16 {
+		return nil, fmt.Errorf("dicomtest.NewDataSet: BitsAllocated must be 8 or 16, not %d", spec.BitsAllocated)
+	}
+	if spec.SamplesPerPixel != 1 && spec.SamplesPerPixel != 3 {
+		return nil, fmt.Errorf("dicomtest.NewDataSet: SamplesPerPixel must be 1 or 3, not %d", spec.SamplesPerPixel)
+	}
+	byteOrder, _, err := dicomio.ParseTransferSyntaxUID(spec.TransferSyntaxUID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dicomuid.Lookup(spec.TransferSyntaxUID); err != nil {
+		return nil, err
+	}
+	// PatientName保持utf-8, dicom.WriteElement用SpecificCharacterSet编码
+	charsets := strings.Split(spec.SpecificCharacterSet, "\\")
+	if _, err := dicomio.ParseSpecificCharacterSet(charsets); err != nil {
+		return nil, err
+	}
+
+	photometric := "MONOCHROME2"
+	if spec.SamplesPerPixel == 3 {
+		photometric = "RGB"
+	}
+	sopClassUID := dicomuid.SecondaryCaptureImageStorage
+	if spec.NumberOfFrames > 1 {
+		sopClassUID = dicomuid.MultiFrameGrayscaleWordSecondaryCaptureImageStorage
+		if spec.BitsAllocated == 8 {
+			sopClassUID = dicomuid.MultiFrameGrayscaleByteSecondaryCaptureImageStorage
+		}
+	}
+
+	elems := []*dicom.Element{
+		dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, sopClassUID),
+		dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, sopInstanceID),
+		dicom.MustNewElement(dicomtag.TransferSyntaxUID, spec.TransferSyntaxUID),
+
+		dicom.MustNewElement(dicomtag.SOPClassUID, sopClassUID),
+		dicom.MustNewElement(dicomtag.SOPInstanceUID, sopInstanceID),
+		dicom.MustNewElement(dicomtag.StudyDate, "20200102"),
+		dicom.MustNewElement(dicomtag.AcquisitionDateTime, "20200102030405.000000"),
+		dicom.MustNewElement(dicomtag.StudyTime, "030405"),
+		dicom.MustNewElement(dicomtag.Modality, "OT"),
+		dicom.MustNewElement(dicomtag.RetrieveAETitle, "DICOMTEST"),
+		dicom.MustNewElement(dicomtag.InstitutionName, "Test Hospital"),
+		dicom.MustNewElement(dicomtag.InstitutionAddress, "1 Test Street"),
+		dicom.MustNewSequence(dicomtag.ReferencedStudySequence, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.ReferencedSOPClassUID, dicomuid.CTImageStorage),
+			dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, sopInstanceID+".1"),
+		}),
+		dicom.MustNewElement(dicomtag.SimpleFrameList, uint32(1)),
+		dicom.MustNewElement(dicomtag.RecommendedDisplayFrameRateInFloat, float32(25)),
+		dicom.MustNewElement(dicomtag.PatientName, spec.PatientName),
+		dicom.MustNewElement(dicomtag.PatientID, "DICOMTEST-1"),
+		dicom.MustNewElement(dicomtag.PatientAge, "042Y"),
+		rawElement(dicomtag.AdditionalPatientHistory, "LT", "None."),
+		dicom.MustNewElement(dicomtag.ReferencePixelX0, int32(-1)),
+		dicom.MustNewElement(dicomtag.ReferencePixelPhysicalValueX, float64(0.5)),
+		dicom.MustNewElement(dicomtag.TagAngleSecondAxis, int16(-90)),
+		dicom.MustNewElement(dicomtag.StudyInstanceUID, sopInstanceID+".2"),
+		dicom.MustNewElement(dicomtag.SeriesInstanceUID, sopInstanceID+".3"),
+		dicom.MustNewElement(dicomtag.StudyID, "1"),
+		dicom.MustNewElement(dicomtag.SeriesNumber, "1"),
+		dicom.MustNewElement(dicomtag.InstanceNumber, "1"),
+		dicom.MustNewElement(dicomtag.SamplesPerPixel, spec.SamplesPerPixel),
+		dicom.MustNewElement(dicomtag.PhotometricInterpretation, photometric),
+		dicom.MustNewElement(dicomtag.Rows, spec.Rows),
+		dicom.MustNewElement(dicomtag.Columns, spec.Columns),
+		dicom.MustNewElement(dicomtag.PixelSpacing, "0.5", "0.5"),
+		dicom.MustNewElement(dicomtag.BitsAllocated, spec.BitsAllocated),
+		dicom.MustNewElement(dicomtag.BitsStored, spec.BitsAllocated),
+		dicom.MustNewElement(dicomtag.HighBit, spec.BitsAllocated-1),
+		dicom.MustNewElement(dicomtag.PixelRepresentation, uint16(0)),
+		dicom.MustNewElement(dicomtag.RedPaletteColorLookupTableData, []byte{1, 0, 2, 0}),
+		rawElement(dicomtag.UniversalEntityID, "UT", "urn:oid:"+sopInstanceID),
+		dicom.MustNewElement(dicomtag.EncapsulatedDocument, []byte("%PDF")),
+		rawElement(dicomtag.VectorGridData, "OF", float32(1), float32(2)),
+	}
+	if spec.SpecificCharacterSet != "" {
+		elems = append(elems, dicom.MustNewElement(dicomtag.SpecificCharacterSet, stringValues(charsets)...))
+	}
+	if spec.SamplesPerPixel == 3 {
+		elems = append(elems, dicom.MustNewElement(dicomtag.PlanarConfiguration, uint16(0)))
+	}
+	if spec.NumberOfFrames > 1 {
+		elems = append(elems, dicom.MustNewElement(dicomtag.NumberOfFrames, fmt.Sprint(spec.NumberOfFrames)))
+	}
+	elems = append(elems, newPixelData(spec, byteOrder))
+
+	sort.Slice(elems, func(i, j int) bool {
+		return elems[i].Tag.Compare(elems[j].Tag) < 0
+	})
+	return &dicom.DataSet{Elements: elems}, nil
+}
+
+func stringValues(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+// rawElement creates an element without dicom.NewElement's type check, for
+// the VRs (LT, UT, OF) whose Go types NewElement doesn't know about.
+func rawElement(tag dicomtag.Tag, vr string, values ...interface{}) *dicom.Element {
+	return &dicom.Element{Tag: tag, VR: vr, Value: values}
+}
+
+func newPixelData(spec Spec, byteOrder binary.ByteOrder) *dicom.Element {
+	var image dicom.PixelDataInfo
+	elem := dicom.MustNewElement(dicomtag.PixelData)
+	if spec.Encapsulated() {
+		var offset uint32
+		for frame := 0; frame < spec.NumberOfFrames; frame++ {
+			data := FramePixels(spec, frame)
+			image.Offsets = append(image.Offsets, offset)
+			image.Frames = append(image.Frames, data)
+			offset += 8 + uint32(len(data)+len(data)%2) // item header + padded fragment
+		}
+		elem.VR = "OB"
+		elem.UndefinedLength = true
+	} else {
+		var data []byte
+		for frame := 0; frame < spec.NumberOfFrames; frame++ {
+			pixels := FramePixels(spec, frame)
+			if spec.BitsAllocated == 16 && byteOrder != binary.LittleEndian {
+				for i := 0; i < len(pixels); i += 2 {
+					pixels[i], pixels[i+1] = pixels[i+1], pixels[i]
+				}
+			}
+			data = append(data, pixels...)
+		}
+		// Native multi-frame pixel data is a single blob of all frames.
+		image.Frames = [][]byte{data}
+		if spec.BitsAllocated == 8 {
+			elem.VR = "OB"
+		}
+	}
+	elem.Value = []interface{}{image}
+	return elem
+}
+
+// Bytes returns the DICOM file described by "spec".
+func Bytes(spec Spec) ([]byte, error) {
+	ds, err := NewDataSet(spec)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := dicom.WriteDataSet(&buf, ds); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MustBytes is similar to Bytes, but panics on error.
+func MustBytes(spec Spec) []byte {
+	data, err := Bytes(spec)
+	if err != nil {
+		panic(fmt.Sprintf("dicomtest.Bytes(%s): %v", spec.Name, err))
+	}
+	return data
+}
+
+// WriteFile writes the DICOM file described by "spec" to "path".
+func WriteFile(path string, spec Spec) error {
+	data, err := Bytes(spec)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// WriteCorpus writes every spec in Corpus() to directory "dir", which must
+// exist, and returns the paths of the files written.
+func WriteCorpus(dir string) ([]string, error) {
+	var paths []string
+	for _, spec := range Corpus() {
+		path := filepath.Join(dir, spec.Name)
+		if err := WriteFile(path, spec); err != nil {
+			return paths, fmt.Errorf("dicomtest.WriteCorpus: %s: %v", spec.Name, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,79 @@
+package dicomtest_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorpusRoundTrip(t *testing.T) {
+	for _, spec := range dicomtest.Corpus() {
+		t.Run(spec.Name, func(t *testing.T) {
+			ds, err := dicom.ReadDataSetInBytes(dicomtest.MustBytes(spec), dicom.ReadOptions{})
+			require.NoError(t, err)
+
+			elem, err := ds.FindElementByTag(dicomtag.TransferSyntaxUID)
+			require.NoError(t, err)
+			require.Equal(t, spec.TransferSyntaxUID, elem.MustGetString())
+
+			name := spec.PatientName
+			if name == "" {
+				name = "Test^Patient"
+			}
+			elem, err = ds.FindElementByTag(dicomtag.PatientName)
+			require.NoError(t, err)
+			require.Equal(t, name, elem.MustGetString())
+
+			for _, tag := range []dicomtag.Tag{
+				dicomtag.ReferencedStudySequence, dicomtag.SimpleFrameList,
+				dicomtag.RecommendedDisplayFrameRateInFloat, dicomtag.ReferencePixelX0,
+				dicomtag.ReferencePixelPhysicalValueX, dicomtag.TagAngleSecondAxis,
+				dicomtag.RedPaletteColorLookupTableData, dicomtag.VectorGridData,
+			} {
+				_, err := ds.FindElementByTag(tag)
+				require.NoError(t, err, dicomtag.DebugString(tag))
+			}
+
+			elem, err = ds.FindElementByTag(dicomtag.PixelData)
+			require.NoError(t, err)
+			image := elem.Value[0].(dicom.PixelDataInfo)
+			frames := spec.NumberOfFrames
+			if frames == 0 {
+				frames = 1
+			}
+			require.Len(t, image.Frames, frames)
+			for i, frame := range image.Frames {
+				expected := dicomtest.FramePixels(spec, i)
+				// Native 16-bit frames keep the byte order of the transfer syntax.
+				if !spec.Encapsulated() && spec.BitsAllocated == 16 && spec.TransferSyntaxUID == dicomuid.ExplicitVRBigEndian {
+					for j := 0; j < len(expected); j += 2 {
+						expected[j], expected[j+1] = expected[j+1], expected[j]
+					}
+				}
+				require.Equal(t, expected, frame, "frame %d", i)
+			}
+		})
+	}
+}
+
+func TestWriteCorpus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dicomtest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	paths, err := dicomtest.WriteCorpus(dir)
+	require.NoError(t, err)
+	require.Len(t, paths, len(dicomtest.Corpus()))
+	for i, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(dicomtest.MustBytes(dicomtest.Corpus()[i]), data), path)
+	}
+}
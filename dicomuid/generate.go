@@ -0,0 +1,92 @@
+package dicomuid
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// MaxLength 是UID的最大长度 (P3.5 9.1)
+const MaxLength = 64
+
+// minRandomDigits 是GenerateWithRoot在root之后至少加上的随机数字个数, 约60 bits
+const minRandomDigits = 18
+
+// Validate checks that "uid" is a valid UID (P3.5 9.1): at most 64
+// characters, and components of digits separated by dots, without leading
+// zeros.
+func Validate(uid string) error {
+	if uid == "" {
+		return fmt.Errorf("dicomuid: empty UID")
+	}
+	if len(uid) > MaxLength {
+		return fmt.Errorf("dicomuid: UID '%s' is longer than %d characters", uid, MaxLength)
+	}
+	for _, c := range strings.Split(uid, ".") {
+		if c == "" {
+			return fmt.Errorf("dicomuid: UID '%s' has an empty component", uid)
+		}
+		if len(c) > 1 && c[0] == '0' {
+			return fmt.Errorf("dicomuid: UID '%s' has a component with a leading zero", uid)
+		}
+		for _, d := range c {
+			if d < '0' || d > '9' {
+				return fmt.Errorf("dicomuid: UID '%s' has a non-digit character '%c'", uid, d)
+			}
+		}
+	}
+	return nil
+}
+
+// Generate returns a new UID derived from a random (version 4) UUID, as
+// "2.25." followed by the UUID as a decimal number (P3.5 B.2). It needs no
+// registered root. It panics if the system's secure random number
+// generator fails.
+func Generate() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("dicomuid.Generate: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return "2.25." + new(big.Int).SetBytes(b).String()
+}
+
+// GenerateWithRoot returns a new UID made of "root", e.g., the UID root
+// registered by the organization, and a random component filling the rest
+// of the 64 characters, up to 39 digits. It returns an error if root isn't
+// a valid UID, or is too long to leave room for 18 random digits.
+func GenerateWithRoot(root string) (string, error) {
+	if err := Validate(root); err != nil {
+		return "", fmt.Errorf("dicomuid.GenerateWithRoot: invalid root: %v", err)
+	}
+	n := MaxLength - len(root) - 1
+	if n > 39 {
+		n = 39
+	}
+	if n < minRandomDigits {
+		return "", fmt.Errorf("dicomuid.GenerateWithRoot: root '%s' is too long", root)
+	}
+	// [10^(n-1), 10^n) 中的随机数: 正好n个数字, 没有leading zero
+	low := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n-1)), nil)
+	r, err := rand.Int(rand.Reader, new(big.Int).Mul(low, big.NewInt(9)))
+	if err != nil {
+		return "", fmt.Errorf("dicomuid.GenerateWithRoot: %v", err)
+	}
+	return root + "." + r.Add(r, low).String(), nil
+}
+
+// Generator creates UIDs with GenerateWithRoot(Root), or New, i.e., with the
+// root set by SetRoot, if Root is empty. It implements dicom.UIDGenerator.
+type Generator struct {
+	Root string
+}
+
+// NewUID returns a new UID.
+func (g Generator) NewUID() (string, error) {
+	if g.Root == "" {
+		return New(), nil
+	}
+	return GenerateWithRoot(g.Root)
+}
@@ -0,0 +1,56 @@
+package dicomuid_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		uid := dicomuid.Generate()
+		assert.True(t, strings.HasPrefix(uid, "2.25."), uid)
+		assert.NoError(t, dicomuid.Validate(uid))
+		assert.False(t, seen[uid], uid)
+		seen[uid] = true
+	}
+
+	root := "1.2.826.0.1.3680043.9.7"
+	for i := 0; i < 100; i++ {
+		uid, err := dicomuid.GenerateWithRoot(root)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(uid, root+"."), uid)
+		assert.Len(t, uid, len(root)+1+39)
+		assert.NoError(t, dicomuid.Validate(uid))
+		assert.False(t, seen[uid], uid)
+		seen[uid] = true
+	}
+	longRoot := "1.2.3.4.5.6.7.8.9.10.11.12.13.14.15.16.17"
+	uid, err := dicomuid.GenerateWithRoot(longRoot)
+	require.NoError(t, err)
+	assert.Len(t, uid, 64)
+	assert.NoError(t, dicomuid.Validate(uid))
+	_, err = dicomuid.GenerateWithRoot(longRoot + ".18.19")
+	assert.Error(t, err, "no room")
+	_, err = dicomuid.GenerateWithRoot("1.02.3")
+	assert.Error(t, err)
+
+	uid, err = dicomuid.Generator{}.NewUID()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(uid, "2.25."), uid)
+	uid, err = dicomuid.Generator{Root: root}.NewUID()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(uid, root+"."), uid)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, dicomuid.Validate("1.2.840.10008.1.2"))
+	assert.NoError(t, dicomuid.Validate("1.0.2"))
+	for _, uid := range []string{"", "1..2", "1.2.", "1.02", "1.2a", strings.Repeat("1.", 32) + "1"} {
+		assert.Error(t, dicomuid.Validate(uid), uid)
+	}
+}
@@ -0,0 +1,85 @@
+package dicomuid
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ValidateRoot checks that "root" is a valid UID root for UIDs made by
+// appending "." and a suffix of up to "suffixLength" characters to it, i.e.,
+// a valid UID that leaves room for the suffix within 64 characters. For the
+// random suffixes of GenerateWithRoot, suffixLength is 18; a scheme like
+// root.<device>.<date>.<counter> must pass its own maximum length.
+func ValidateRoot(root string, suffixLength int) error {
+	if err := Validate(root); err != nil {
+		return err
+	}
+	if len(root)+1+suffixLength > MaxLength {
+		return fmt.Errorf("dicomuid: root '%s' (%d characters) leaves no room for a %d character suffix",
+			root, len(root), suffixLength)
+	}
+	return nil
+}
+
+// SubRoot returns the root "root.c1.c2...", e.g., the root of a device or
+// an application under the organization's root, SubRoot(orgRoot, 3, 1) for
+// application 1 of device 3. The result is checked with ValidateRoot for
+// the random suffixes of GenerateWithRoot.
+func SubRoot(root string, components ...uint32) (string, error) {
+	sub := root
+	for _, c := range components {
+		sub += "." + strconv.FormatUint(uint64(c), 10)
+	}
+	if err := ValidateRoot(sub, minRandomDigits); err != nil {
+		return "", fmt.Errorf("dicomuid.SubRoot: %v", err)
+	}
+	return sub, nil
+}
+
+// 站点配置的UID root, 见SetRoot
+var (
+	rootMu sync.RWMutex
+	root   string
+)
+
+// SetRoot sets the UID root of the site, e.g., the root registered by the
+// organization or a SubRoot of it, used by New and so by everything that
+// creates UIDs in this module: anonymize.UIDTable, dicomdir, and a zero
+// Generator, e.g., for dicom.SplitFrames. An empty root restores the
+// default, "2.25." UUID UIDs. It returns an error, and keeps the current
+// root, if "r" isn't valid for GenerateWithRoot.
+func SetRoot(r string) error {
+	if r != "" {
+		if err := ValidateRoot(r, minRandomDigits); err != nil {
+			return fmt.Errorf("dicomuid.SetRoot: %v", err)
+		}
+	}
+	rootMu.Lock()
+	defer rootMu.Unlock()
+	root = r
+	return nil
+}
+
+// Root returns the root set by SetRoot, or "" if none.
+func Root() string {
+	rootMu.RLock()
+	defer rootMu.RUnlock()
+	return root
+}
+
+// New returns a new UID with the root set by SetRoot, or from Generate if
+// none. Like Generate, it panics if the system's secure random number
+// generator fails.
+func New() string {
+	r := Root()
+	if r == "" {
+		return Generate()
+	}
+	uid, err := GenerateWithRoot(r)
+	if err != nil {
+		// SetRoot已经检查过root, 只有随机数生成失败
+		panic(fmt.Sprintf("dicomuid.New: %v", err))
+	}
+	return uid
+}
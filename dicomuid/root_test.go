@@ -0,0 +1,50 @@
+package dicomuid_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRoot(t *testing.T) {
+	root := "1.2.826.0.1.3680043.9.7"
+	assert.NoError(t, dicomuid.ValidateRoot(root, 18))
+	assert.NoError(t, dicomuid.ValidateRoot(root, dicomuid.MaxLength-len(root)-1))
+	assert.Error(t, dicomuid.ValidateRoot(root, dicomuid.MaxLength-len(root)))
+	assert.Error(t, dicomuid.ValidateRoot("1.2.3a", 18))
+	assert.Error(t, dicomuid.ValidateRoot("1.2.", 18))
+
+	sub, err := dicomuid.SubRoot(root, 3, 0, 12)
+	require.NoError(t, err)
+	assert.Equal(t, root+".3.0.12", sub)
+	_, err = dicomuid.SubRoot(root, 1000000000, 1000000000, 1000000000)
+	assert.Error(t, err, "no room")
+	_, err = dicomuid.SubRoot("1.02", 1)
+	assert.Error(t, err)
+}
+
+func TestSetRoot(t *testing.T) {
+	defer dicomuid.SetRoot("") // nolint: errcheck
+	assert.Equal(t, "", dicomuid.Root())
+	assert.True(t, strings.HasPrefix(dicomuid.New(), "2.25."))
+
+	root := "1.2.826.0.1.3680043.9.7.3"
+	require.NoError(t, dicomuid.SetRoot(root))
+	assert.Equal(t, root, dicomuid.Root())
+	uid := dicomuid.New()
+	assert.True(t, strings.HasPrefix(uid, root+"."), uid)
+	assert.NoError(t, dicomuid.Validate(uid))
+	uid, err := dicomuid.Generator{}.NewUID()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(uid, root+"."), uid)
+
+	// 无效的root不改变当前的root
+	assert.Error(t, dicomuid.SetRoot(strings.Repeat("1.", 25)+"1"))
+	assert.Equal(t, root, dicomuid.Root())
+
+	require.NoError(t, dicomuid.SetRoot(""))
+	assert.True(t, strings.HasPrefix(dicomuid.New(), "2.25."))
+}
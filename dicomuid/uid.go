@@ -7,6 +7,7 @@ package dicomuid
 
 import (
 	"fmt"
+	"sync"
 )
 
 type UIDType string
@@ -38,6 +39,50 @@ var (
 	DeflatedExplicitVRLittleEndian = standardUID("1.2.840.10008.1.2.1.99")
 )
 
+// 常用IOD的Storage SOP Class UID, P3.4 B.5
+var (
+	MediaStorageDirectoryStorage = standardUID("1.2.840.10008.1.3.10")
+
+	ComputedRadiographyImageStorage                     = standardUID("1.2.840.10008.5.1.4.1.1.1")
+	DigitalXRayImageStorageForPresentation              = standardUID("1.2.840.10008.5.1.4.1.1.1.1")
+	DigitalXRayImageStorageForProcessing                = standardUID("1.2.840.10008.5.1.4.1.1.1.1.1")
+	DigitalMammographyXRayImageStorageForPresentation   = standardUID("1.2.840.10008.5.1.4.1.1.1.2")
+	DigitalMammographyXRayImageStorageForProcessing     = standardUID("1.2.840.10008.5.1.4.1.1.1.2.1")
+	CTImageStorage                                      = standardUID("1.2.840.10008.5.1.4.1.1.2")
+	EnhancedCTImageStorage                              = standardUID("1.2.840.10008.5.1.4.1.1.2.1")
+	UltrasoundMultiFrameImageStorage                    = standardUID("1.2.840.10008.5.1.4.1.1.3.1")
+	MRImageStorage                                      = standardUID("1.2.840.10008.5.1.4.1.1.4")
+	EnhancedMRImageStorage                              = standardUID("1.2.840.10008.5.1.4.1.1.4.1")
+	UltrasoundImageStorage                              = standardUID("1.2.840.10008.5.1.4.1.1.6.1")
+	SecondaryCaptureImageStorage                        = standardUID("1.2.840.10008.5.1.4.1.1.7")
+	MultiFrameSingleBitSecondaryCaptureImageStorage     = standardUID("1.2.840.10008.5.1.4.1.1.7.1")
+	MultiFrameGrayscaleByteSecondaryCaptureImageStorage = standardUID("1.2.840.10008.5.1.4.1.1.7.2")
+	MultiFrameGrayscaleWordSecondaryCaptureImageStorage = standardUID("1.2.840.10008.5.1.4.1.1.7.3")
+	MultiFrameTrueColorSecondaryCaptureImageStorage     = standardUID("1.2.840.10008.5.1.4.1.1.7.4")
+	TwelveLeadECGWaveformStorage                        = standardUID("1.2.840.10008.5.1.4.1.1.9.1.1")
+	GrayscaleSoftcopyPresentationStateStorage           = standardUID("1.2.840.10008.5.1.4.1.1.11.1")
+	XRayAngiographicImageStorage                        = standardUID("1.2.840.10008.5.1.4.1.1.12.1")
+	XRayRadiofluoroscopicImageStorage                   = standardUID("1.2.840.10008.5.1.4.1.1.12.2")
+	NuclearMedicineImageStorage                         = standardUID("1.2.840.10008.5.1.4.1.1.20")
+	RawDataStorage                                      = standardUID("1.2.840.10008.5.1.4.1.1.66")
+	SpatialRegistrationStorage                          = standardUID("1.2.840.10008.5.1.4.1.1.66.1")
+	SegmentationStorage                                 = standardUID("1.2.840.10008.5.1.4.1.1.66.4")
+	VLPhotographicImageStorage                          = standardUID("1.2.840.10008.5.1.4.1.1.77.1.4")
+	VLWholeSlideMicroscopyImageStorage                  = standardUID("1.2.840.10008.5.1.4.1.1.77.1.6")
+	BasicTextSRStorage                                  = standardUID("1.2.840.10008.5.1.4.1.1.88.11")
+	EnhancedSRStorage                                   = standardUID("1.2.840.10008.5.1.4.1.1.88.22")
+	ComprehensiveSRStorage                              = standardUID("1.2.840.10008.5.1.4.1.1.88.33")
+	KeyObjectSelectionDocumentStorage                   = standardUID("1.2.840.10008.5.1.4.1.1.88.59")
+	EncapsulatedPDFStorage                              = standardUID("1.2.840.10008.5.1.4.1.1.104.1")
+	EncapsulatedCDAStorage                              = standardUID("1.2.840.10008.5.1.4.1.1.104.2")
+	PositronEmissionTomographyImageStorage              = standardUID("1.2.840.10008.5.1.4.1.1.128")
+	EnhancedPETImageStorage                             = standardUID("1.2.840.10008.5.1.4.1.1.130")
+	RTImageStorage                                      = standardUID("1.2.840.10008.5.1.4.1.1.481.1")
+	RTDoseStorage                                       = standardUID("1.2.840.10008.5.1.4.1.1.481.2")
+	RTStructureSetStorage                               = standardUID("1.2.840.10008.5.1.4.1.1.481.3")
+	RTPlanStorage                                       = standardUID("1.2.840.10008.5.1.4.1.1.481.5")
+)
+
 type UIDInfo struct {
 	UID    string  // "1.2.840.10008.x.y.z"
 	Name   string  // The UID string, e.g.,"1.2.840.10008.1.2.1".
@@ -46,12 +91,18 @@ type UIDInfo struct {
 	Status string  // "" if active. "Retired", if netired.
 }
 
-var uidDict map[string]UIDInfo
+// uidDict 在initUIDDict之后不再被修改, 所以可以被多个goroutine同时读
+var (
+	uidDict     map[string]UIDInfo
+	uidDictOnce sync.Once
+)
 
+// maybeInitUIDDict 初始化uidDict. 可以被多个goroutine同时调用
 func maybeInitUIDDict() {
-	if len(uidDict) > 0 {
-		return
-	}
+	uidDictOnce.Do(initUIDDict)
+}
+
+func initUIDDict() {
 	uidDict = make(map[string]UIDInfo)
 	var add = func(uid, name string, uidType UIDType, part, status string) {
 		uidDict[uid] = UIDInfo{uid, name, uidType, part, status}
@@ -466,6 +517,7 @@ func standardUID(uid string) string {
 // 根据传来的uid来找到相关信息(1.2.840开头的)
 // 除非uid是dicom standard定义的时，会返回一个错误
 func Lookup(uid string) (UIDInfo, error) {
+	maybeInitUIDDict()
 	e, ok := uidDict[uid]
 	if !ok {
 		return UIDInfo{}, fmt.Errorf("UID '%s' not found in dictionary", uid)
@@ -482,8 +534,15 @@ func MustLookup(uid string) UIDInfo {
 	return e
 }
 
+// MustLookupName 返回uid在字典中的名字, 如 "CT Image Storage", 用于显示.
+// 与MustLookup相似, uid不在字典中时会panic
+func MustLookupName(uid string) string {
+	return MustLookup(uid).Name
+}
+
 // UIDString 返回一个DICOM UID的人类可读的诊断字符串
 func UIDString(uid string) string {
+	maybeInitUIDDict()
 	e, ok := uidDict[uid]
 	if !ok {
 		return fmt.Sprintf("%s[??]", uid)
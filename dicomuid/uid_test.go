@@ -16,3 +16,11 @@ func TestLookupUID(t *testing.T) {
 	assert.Equal(t, u.Name, "dicomTransferCapability")
 	assert.Equal(t, string(u.Type), "LDAP OID")
 }
+
+func TestSOPClassUIDs(t *testing.T) {
+	assert.Equal(t, "1.2.840.10008.5.1.4.1.1.2", dicomuid.CTImageStorage)
+	assert.Equal(t, "CT Image Storage", dicomuid.MustLookupName(dicomuid.CTImageStorage))
+	assert.Equal(t, "Secondary Capture Image Storage", dicomuid.MustLookupName(dicomuid.SecondaryCaptureImageStorage))
+	assert.Equal(t, "MR Image Storage", dicomuid.MustLookupName(dicomuid.MRImageStorage))
+	assert.Panics(t, func() { dicomuid.MustLookupName("1.2.3.4") })
+}
@@ -0,0 +1,138 @@
+package dicomweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// MediaTypeDICOMJSON is the media type of QIDO-RS results (P3.18 F.1).
+const MediaTypeDICOMJSON = "application/dicom+json"
+
+// Query is a QIDO-RS search (P3.18 10.6.1.2). Zero values leave the
+// parameter out, so that the server uses its default.
+type Query struct {
+	// Filters are matching attributes, e.g., {PatientID: "123",
+	// StudyDate: "20200101-20200131"}, with the matching rules of C-FIND
+	// (P3.4 C.2.2.2): wildcards, date ranges, UID lists.
+	Filters map[dicomtag.Tag]string
+
+	// IncludeFields 是除了server默认返回的attributes以外还要返回的attributes
+	IncludeFields []dicomtag.Tag
+
+	// IncludeAll asks for all the available attributes
+	// ("includefield=all").
+	IncludeAll bool
+
+	// FuzzyMatching enables fuzzy matching of person names, if the server
+	// supports it.
+	FuzzyMatching bool
+
+	// Limit 是最多返回的结果个数, Offset是跳过的结果个数, 用于分页
+	Limit  int
+	Offset int
+}
+
+// queryTag 返回tag在query参数中的写法, 即8个hex数字, 例如"00100020"
+func queryTag(tag dicomtag.Tag) string {
+	return fmt.Sprintf("%04X%04X", tag.Group, tag.Element)
+}
+
+// Values returns the query parameters of "q".
+func (q Query) Values() url.Values {
+	values := url.Values{}
+	for tag, value := range q.Filters {
+		values.Set(queryTag(tag), value)
+	}
+	if q.IncludeAll {
+		values.Add("includefield", "all")
+	}
+	for _, tag := range q.IncludeFields {
+		values.Add("includefield", queryTag(tag))
+	}
+	if q.FuzzyMatching {
+		values.Set("fuzzymatching", "true")
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		values.Set("offset", strconv.Itoa(q.Offset))
+	}
+	return values
+}
+
+// search 发送QIDO-RS request, 把JSON结果解析成data sets. 204 (No Content) 或空body表示没有结果
+func (c *Client) search(ctx context.Context, name string, q Query, parts ...string) ([]*dicom.DataSet, error) {
+	u := c.resourceURL(parts...)
+	if values := q.Values(); len(values) > 0 {
+		u += "?" + values.Encode()
+	}
+	resp, err := send(ctx, c.HTTPClient, c.Header, http.MethodGet, u, MediaTypeDICOMJSON, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if resp.StatusCode == http.StatusNoContent || len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	switch mediaType, _ := responseMediaType(resp); mediaType {
+	case MediaTypeDICOMJSON, "application/json", "":
+	default:
+		return nil, fmt.Errorf("%s: expected %s, got %s", name, MediaTypeDICOMJSON, mediaType)
+	}
+	var results []json.RawMessage
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	datasets := make([]*dicom.DataSet, len(results))
+	for i, result := range results {
+		if datasets[i], err = dicom.UnmarshalJSON(result); err != nil {
+			return nil, fmt.Errorf("%s: result #%d: %v", name, i, err)
+		}
+	}
+	return datasets, nil
+}
+
+// SearchStudies searches for studies (P3.18 10.6). Each result has the
+// study attributes that matched, e.g., StudyInstanceUID, and those the
+// server returns by default or as requested by q.IncludeFields.
+func (c *Client) SearchStudies(ctx context.Context, q Query) ([]*dicom.DataSet, error) {
+	return c.search(ctx, "dicomweb.SearchStudies", q, "studies")
+}
+
+// SearchSeries searches for the series of a study, or of all studies if
+// "studyUID" is empty, see SearchStudies.
+func (c *Client) SearchSeries(ctx context.Context, studyUID string, q Query) ([]*dicom.DataSet, error) {
+	if studyUID == "" {
+		return c.search(ctx, "dicomweb.SearchSeries", q, "series")
+	}
+	return c.search(ctx, "dicomweb.SearchSeries", q, "studies", studyUID, "series")
+}
+
+// SearchInstances searches for the instances of a series, of a study if
+// "seriesUID" is empty, or of all studies if both are empty, see
+// SearchStudies.
+func (c *Client) SearchInstances(ctx context.Context, studyUID, seriesUID string, q Query) ([]*dicom.DataSet, error) {
+	switch {
+	case studyUID == "" && seriesUID != "":
+		return nil, fmt.Errorf("dicomweb.SearchInstances: series %s without study", seriesUID)
+	case studyUID == "":
+		return c.search(ctx, "dicomweb.SearchInstances", q, "instances")
+	case seriesUID == "":
+		return c.search(ctx, "dicomweb.SearchInstances", q, "studies", studyUID, "instances")
+	}
+	return c.search(ctx, "dicomweb.SearchInstances", q, "studies", studyUID, "series", seriesUID, "instances")
+}
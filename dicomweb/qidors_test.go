@@ -0,0 +1,90 @@
+package dicomweb_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomweb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQIDORS(t *testing.T) {
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, dicomweb.MediaTypeDICOMJSON, r.Header.Get("Accept"))
+		queries = append(queries, r.URL.Query())
+		switch r.URL.Path {
+		case "/dicom-web/studies":
+			w.Header().Set("Content-Type", dicomweb.MediaTypeDICOMJSON)
+			w.Write([]byte(`[
+				{"0020000D": {"vr": "UI", "Value": ["1.2.3"]},
+				 "00100010": {"vr": "PN", "Value": [{"Alphabetic": "Qido^One"}]},
+				 "00201206": {"vr": "IS", "Value": [2]}},
+				{"0020000D": {"vr": "UI", "Value": ["1.2.4"]},
+				 "00100010": {"vr": "PN"}}]`)) // nolint: errcheck
+		case "/dicom-web/studies/1.2.3/series":
+			w.WriteHeader(http.StatusNoContent)
+		case "/dicom-web/studies/1.2.3/series/1.2.3.4/instances":
+			w.Header().Set("Content-Type", dicomweb.MediaTypeDICOMJSON)
+			w.Write([]byte(`[{"00080018": {"vr": "UI", "Value": ["1.2.3.4.5"]}}]`)) // nolint: errcheck
+		case "/dicom-web/instances":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>login</html>")) // nolint: errcheck
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := &dicomweb.Client{BaseURL: server.URL + "/dicom-web"}
+	studies, err := c.SearchStudies(ctx, dicomweb.Query{
+		Filters:       map[dicomtag.Tag]string{dicomtag.PatientName: "Qido*", dicomtag.StudyDate: "20200101-20200131"},
+		IncludeFields: []dicomtag.Tag{dicomtag.NumberOfStudyRelatedInstances},
+		FuzzyMatching: true,
+		Limit:         10,
+		Offset:        20,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"00100010":      {"Qido*"},
+		"00080020":      {"20200101-20200131"},
+		"includefield":  {"00201208"},
+		"fuzzymatching": {"true"},
+		"limit":         {"10"},
+		"offset":        {"20"},
+	}, queries[0])
+	require.Len(t, studies, 2)
+	elem, err := studies[0].FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Qido^One", elem.MustGetString())
+	elem, err = studies[1].FindElementByTag(dicomtag.StudyInstanceUID)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.4", elem.MustGetString())
+
+	series, err := c.SearchSeries(ctx, "1.2.3", dicomweb.Query{IncludeAll: true})
+	require.NoError(t, err)
+	assert.Empty(t, series)
+	assert.Equal(t, url.Values{"includefield": {"all"}}, queries[1])
+
+	instances, err := c.SearchInstances(ctx, "1.2.3", "1.2.3.4", dicomweb.Query{})
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	elem, err = instances[0].FindElementByTag(dicomtag.SOPInstanceUID)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4.5", elem.MustGetString())
+
+	_, err = c.SearchInstances(ctx, "", "", dicomweb.Query{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "text/html")
+	_, err = c.SearchInstances(ctx, "", "1.2.3.4", dicomweb.Query{})
+	assert.Error(t, err)
+	_, err = c.SearchSeries(ctx, "missing", dicomweb.Query{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
@@ -0,0 +1,164 @@
+package dicomweb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// StoreResult is the outcome of one instance in a STOW-RS response
+// (P3.18 10.5.3): an item of ReferencedSOPSequence or FailedSOPSequence.
+type StoreResult struct {
+	SOPClassUID    string
+	SOPInstanceUID string
+
+	// RetrieveURL 是stored instance的WADO-RS URL, server可以不提供
+	RetrieveURL string
+
+	// Reason 是stored instance的WarningReason或failed instance的FailureReason
+	// (P3.18 Table I.2-2 and I.2-3), 没有时为0
+	Reason uint16
+}
+
+// StoreResponse is a parsed STOW-RS response.
+type StoreResponse struct {
+	// StatusCode 是HTTP status, 例如200 (全部成功), 202 (有warnings或部分失败), 409 (全部失败)
+	StatusCode int
+
+	// RetrieveURL 是study的WADO-RS URL, server可以不提供
+	RetrieveURL string
+
+	// Stored 是存储了的instances (ReferencedSOPSequence), Failed是失败的 (FailedSOPSequence)
+	Stored []StoreResult
+	Failed []StoreResult
+
+	// DataSet is the whole response, e.g., for OtherFailuresSequence. nil if
+	// the server returned no body.
+	DataSet *dicom.DataSet
+}
+
+// Store stores "datasets" with a STOW-RS request (P3.18 10.5) to "u", e.g.,
+// "https://pacs.example.com/dicom-web/studies", using http.DefaultClient.
+// See Client.Store.
+func Store(ctx context.Context, u string, datasets ...*dicom.DataSet) (*StoreResponse, error) {
+	return store(ctx, nil, nil, u, datasets)
+}
+
+// Store stores "datasets" in the study "studyUID", or in any study if
+// "studyUID" is empty (P3.18 10.5). The instances are written with
+// dicom.WriteDataSet into one multipart/related request, which is held in
+// memory.
+//
+// The response is returned if the server sent one, even with an error: the
+// error reports an HTTP failure or the instances in StoreResponse.Failed.
+// Warnings, e.g., coerced attributes, are in the Reason of Stored.
+func (c *Client) Store(ctx context.Context, studyUID string, datasets ...*dicom.DataSet) (*StoreResponse, error) {
+	u := c.resourceURL("studies")
+	if studyUID != "" {
+		u = c.resourceURL("studies", studyUID)
+	}
+	return store(ctx, c.HTTPClient, c.Header, u, datasets)
+}
+
+func store(ctx context.Context, client *http.Client, header http.Header, u string, datasets []*dicom.DataSet) (*StoreResponse, error) {
+	if len(datasets) == 0 {
+		return nil, fmt.Errorf("dicomweb.Store: no instances")
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, ds := range datasets {
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {MediaTypeDICOM}})
+		if err != nil {
+			return nil, fmt.Errorf("dicomweb.Store: %v", err)
+		}
+		if err := dicom.WriteDataSet(part, ds); err != nil {
+			return nil, fmt.Errorf("dicomweb.Store: instance #%d: %v", i, err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("dicomweb.Store: %v", err)
+	}
+	contentType := fmt.Sprintf("multipart/related; type=%q; boundary=%s", MediaTypeDICOM, mw.Boundary())
+	resp, err := do(ctx, client, header, http.MethodPost, u, MediaTypeDICOMJSON, &body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	// 409 (Conflict) 的body也是store response, 列出失败的instances
+	if (resp.StatusCode < 200 || resp.StatusCode >= 300) && resp.StatusCode != http.StatusConflict {
+		return nil, statusError(u, resp)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("dicomweb.Store: %v", err)
+	}
+	result := &StoreResponse{StatusCode: resp.StatusCode}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if mediaType, _ := responseMediaType(resp); mediaType != MediaTypeDICOMJSON && mediaType != "application/json" {
+			return nil, fmt.Errorf("dicomweb.Store: %s: %s: expected %s, got %s", u, resp.Status, MediaTypeDICOMJSON, mediaType)
+		}
+		if result.DataSet, err = dicom.UnmarshalJSON(data); err != nil {
+			return nil, fmt.Errorf("dicomweb.Store: %v", err)
+		}
+		result.RetrieveURL = storeString(result.DataSet.Elements, dicomtag.RetrieveURL)
+		result.Stored = storeResults(result.DataSet.Elements, dicomtag.ReferencedSOPSequence, dicomtag.WarningReason)
+		result.Failed = storeResults(result.DataSet.Elements, dicomtag.FailedSOPSequence, dicomtag.FailureReason)
+	}
+	switch {
+	case len(result.Failed) > 0:
+		return result, fmt.Errorf("dicomweb.Store: %s: %s: %d of %d instances failed, first %s (reason 0x%04x)",
+			u, resp.Status, len(result.Failed), len(datasets), result.Failed[0].SOPInstanceUID, result.Failed[0].Reason)
+	case resp.StatusCode == http.StatusConflict:
+		return result, fmt.Errorf("dicomweb.Store: %s: %s", u, resp.Status)
+	}
+	return result, nil
+}
+
+// storeResults 返回sequence "tag"的items, reason是WarningReason或FailureReason
+func storeResults(elems []*dicom.Element, tag, reasonTag dicomtag.Tag) []StoreResult {
+	seq, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return nil
+	}
+	var results []StoreResult
+	for _, v := range seq.Value {
+		item, ok := v.(*dicom.Element)
+		if !ok {
+			continue
+		}
+		var sub []*dicom.Element
+		for _, v := range item.Value {
+			if elem, ok := v.(*dicom.Element); ok {
+				sub = append(sub, elem)
+			}
+		}
+		r := StoreResult{
+			SOPClassUID:    storeString(sub, dicomtag.ReferencedSOPClassUID),
+			SOPInstanceUID: storeString(sub, dicomtag.ReferencedSOPInstanceUID),
+			RetrieveURL:    storeString(sub, dicomtag.RetrieveURL),
+		}
+		if elem, err := dicom.FindElementByTag(sub, reasonTag); err == nil {
+			r.Reason, _ = elem.GetUInt16()
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// storeString 返回elems中tag的string value, 没有时返回""
+func storeString(elems []*dicom.Element, tag dicomtag.Tag) string {
+	elem, err := dicom.FindElementByTag(elems, tag)
+	if err != nil {
+		return ""
+	}
+	s, _ := elem.GetString()
+	return strings.TrimSpace(s)
+}
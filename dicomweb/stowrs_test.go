@@ -0,0 +1,99 @@
+package dicomweb_test
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomweb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSTOWRS(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/related", mediaType)
+		assert.Equal(t, dicomweb.MediaTypeDICOM, params["type"])
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		received = nil
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			assert.Equal(t, dicomweb.MediaTypeDICOM, part.Header.Get("Content-Type"))
+			ds, err := dicom.ReadDataSet(part, dicom.ReadOptions{})
+			require.NoError(t, err)
+			elem, err := ds.FindElementByTag(dicomtag.PatientName)
+			require.NoError(t, err)
+			received = append(received, elem.MustGetString())
+		}
+		w.Header().Set("Content-Type", dicomweb.MediaTypeDICOMJSON)
+		switch r.URL.Path {
+		case "/dicom-web/studies":
+			w.Write([]byte(`{
+				"00081190": {"vr": "UR", "Value": ["http://pacs/studies/1.2.3"]},
+				"00081199": {"vr": "SQ", "Value": [
+					{"00081150": {"vr": "UI", "Value": ["1.2.840.10008.5.1.4.1.1.2"]},
+					 "00081155": {"vr": "UI", "Value": ["1.2.3.4.5"]},
+					 "00081190": {"vr": "UR", "Value": ["http://pacs/studies/1.2.3/series/4/instances/5"]},
+					 "00081196": {"vr": "US", "Value": [45056]}}]}}`)) // nolint: errcheck
+		case "/dicom-web/studies/1.2.3":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{
+				"00081198": {"vr": "SQ", "Value": [
+					{"00081155": {"vr": "UI", "Value": ["1.2.3.4.6"]},
+					 "00081197": {"vr": "US", "Value": [43264]}}]}}`)) // nolint: errcheck
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	var datasets []*dicom.DataSet
+	for _, name := range []string{"Stow^One", "Stow^Two"} {
+		ds, err := dicomtest.NewDataSet(dicomtest.Spec{PatientName: name})
+		require.NoError(t, err)
+		datasets = append(datasets, ds)
+	}
+	resp, err := dicomweb.Store(ctx, server.URL+"/dicom-web/studies", datasets[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Stow^One"}, received)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "http://pacs/studies/1.2.3", resp.RetrieveURL)
+	assert.Equal(t, []dicomweb.StoreResult{{
+		SOPClassUID:    "1.2.840.10008.5.1.4.1.1.2",
+		SOPInstanceUID: "1.2.3.4.5",
+		RetrieveURL:    "http://pacs/studies/1.2.3/series/4/instances/5",
+		Reason:         0xB000,
+	}}, resp.Stored)
+	assert.Empty(t, resp.Failed)
+
+	c := &dicomweb.Client{BaseURL: server.URL + "/dicom-web"}
+	resp, err = c.Store(ctx, "1.2.3", datasets...)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 2 instances failed")
+	assert.Equal(t, []string{"Stow^One", "Stow^Two"}, received)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, []dicomweb.StoreResult{{SOPInstanceUID: "1.2.3.4.6", Reason: 0xA900}}, resp.Failed)
+
+	_, err = c.Store(ctx, "other", datasets...)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "forbidden")
+	_, err = c.Store(ctx, "")
+	assert.Error(t, err)
+}
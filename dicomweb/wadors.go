@@ -0,0 +1,178 @@
+package dicomweb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom"
+)
+
+// MediaTypeOctetStream is the media type of uncompressed frames in WADO-RS
+// responses.
+const MediaTypeOctetStream = "application/octet-stream"
+
+// Client is a client of the RESTful DICOMweb services (P3.18 10) of one
+// origin server: WADO-RS, QIDO-RS and STOW-RS.
+type Client struct {
+	// BaseURL is the service root, e.g.,
+	// "https://pacs.example.com/dicom-web". Resource paths such as
+	// "/studies/{uid}" are appended to it.
+	BaseURL string
+
+	// HTTPClient sends the requests. nil means http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Header is added to every request, e.g., for authorization.
+	Header http.Header
+
+	// TransferSyntax 是请求的instances和frames的transfer syntax UID, "*"表示保持存储时的
+	// transfer syntax. 为空时server按标准使用Explicit VR Little Endian (frames不压缩)
+	TransferSyntax string
+}
+
+// resourceURL 返回BaseURL下的resource, 例如 .../studies/1.2.3/series/1.2.3.4
+func (c *Client) resourceURL(parts ...string) string {
+	u := strings.TrimSuffix(c.BaseURL, "/")
+	for _, part := range parts {
+		u += "/" + url.PathEscape(part)
+	}
+	return u
+}
+
+// accept 返回WADO-RS request的Accept header
+func (c *Client) accept(partType string) string {
+	accept := fmt.Sprintf("multipart/related; type=%q", partType)
+	if c.TransferSyntax != "" {
+		accept += "; transfer-syntax=" + c.TransferSyntax
+	}
+	return accept
+}
+
+// retrieve 发送WADO-RS request, 对multipart/related response的每个part调用fn.
+// 不是multipart的response (有些server这样返回单个instance) 被当作一个part
+func (c *Client) retrieve(ctx context.Context, u, partType string, fn func(mediaType string, part io.Reader) error) error {
+	resp, err := send(ctx, c.HTTPClient, c.Header, http.MethodGet, u, c.accept(partType), nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	mediaType, params := responseMediaType(resp)
+	if mediaType != "multipart/related" {
+		return fn(mediaType, resp.Body)
+	}
+	if params["boundary"] == "" {
+		return fmt.Errorf("dicomweb: %s: multipart response without boundary", u)
+	}
+	if t := params["type"]; t != "" {
+		partType = t
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("dicomweb: %s: %v", u, err)
+		}
+		partMediaType := partType
+		if t, _, err := mime.ParseMediaType(part.Header.Get("Content-Type")); err == nil {
+			partMediaType = t
+		}
+		if err := fn(partMediaType, part); err != nil {
+			return err
+		}
+	}
+}
+
+// retrieveDataSets 读取response里的所有instances
+func (c *Client) retrieveDataSets(ctx context.Context, name string, options dicom.ReadOptions, parts ...string) ([]*dicom.DataSet, error) {
+	var result []*dicom.DataSet
+	err := c.retrieve(ctx, c.resourceURL(parts...), MediaTypeDICOM, func(mediaType string, part io.Reader) error {
+		switch mediaType {
+		case MediaTypeDICOM, MediaTypeOctetStream, "":
+		default:
+			return fmt.Errorf("%s: part #%d: expected %s, got %s", name, len(result), MediaTypeDICOM, mediaType)
+		}
+		ds, err := dicom.ReadDataSet(part, options)
+		if err != nil {
+			return fmt.Errorf("%s: part #%d: %v", name, len(result), err)
+		}
+		result = append(result, ds)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RetrieveStudy retrieves all the instances of a study (P3.18 10.4) and
+// parses them with "options", e.g., DropPixelData. The whole study is held
+// in memory.
+func (c *Client) RetrieveStudy(ctx context.Context, studyUID string, options dicom.ReadOptions) ([]*dicom.DataSet, error) {
+	return c.retrieveDataSets(ctx, "dicomweb.RetrieveStudy", options, "studies", studyUID)
+}
+
+// RetrieveSeries retrieves all the instances of a series, see RetrieveStudy.
+func (c *Client) RetrieveSeries(ctx context.Context, studyUID, seriesUID string, options dicom.ReadOptions) ([]*dicom.DataSet, error) {
+	return c.retrieveDataSets(ctx, "dicomweb.RetrieveSeries", options, "studies", studyUID, "series", seriesUID)
+}
+
+// RetrieveInstance retrieves one instance, see RetrieveStudy.
+func (c *Client) RetrieveInstance(ctx context.Context, studyUID, seriesUID, instanceUID string, options dicom.ReadOptions) (*dicom.DataSet, error) {
+	datasets, err := c.retrieveDataSets(ctx, "dicomweb.RetrieveInstance", options,
+		"studies", studyUID, "series", seriesUID, "instances", instanceUID)
+	if err != nil {
+		return nil, err
+	}
+	if len(datasets) != 1 {
+		return nil, fmt.Errorf("dicomweb.RetrieveInstance: %s: expected one instance, got %d", instanceUID, len(datasets))
+	}
+	return datasets[0], nil
+}
+
+// RetrieveFrames retrieves "frames" (starting at 1) of an instance
+// (P3.18 10.4.1.1.4) and returns them in the order requested, one per
+// PixelDataInfo.Frames entry. With the default TransferSyntax the frames
+// are uncompressed, in the layout of native PixelData; otherwise they are
+// in the requested transfer syntax, e.g., one JPEG bitstream per frame. It
+// is an error if the server returns a different number of frames.
+func (c *Client) RetrieveFrames(ctx context.Context, studyUID, seriesUID, instanceUID string, frames []int) (dicom.PixelDataInfo, error) {
+	if len(frames) == 0 {
+		return dicom.PixelDataInfo{}, fmt.Errorf("dicomweb.RetrieveFrames: no frames requested")
+	}
+	list := make([]string, len(frames))
+	for i, frame := range frames {
+		if frame < 1 {
+			return dicom.PixelDataInfo{}, fmt.Errorf("dicomweb.RetrieveFrames: invalid frame number %d", frame)
+		}
+		list[i] = strconv.Itoa(frame)
+	}
+	// frame list的逗号不能被escape
+	u := c.resourceURL("studies", studyUID, "series", seriesUID, "instances", instanceUID, "frames") + "/" + strings.Join(list, ",")
+	var image dicom.PixelDataInfo
+	err := c.retrieve(ctx, u, MediaTypeOctetStream, func(mediaType string, part io.Reader) error {
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			return fmt.Errorf("dicomweb.RetrieveFrames: %s: frame #%d: %v", instanceUID, len(image.Frames), err)
+		}
+		image.Frames = append(image.Frames, data)
+		return nil
+	})
+	if err != nil {
+		return dicom.PixelDataInfo{}, err
+	}
+	if len(image.Frames) != len(frames) {
+		return dicom.PixelDataInfo{}, fmt.Errorf("dicomweb.RetrieveFrames: %s: requested %d frames, got %d", instanceUID, len(frames), len(image.Frames))
+	}
+	return image, nil
+}
@@ -0,0 +1,106 @@
+package dicomweb_test
+
+import (
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomweb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeMultipart 把parts写成multipart/related response
+func writeMultipart(t *testing.T, w http.ResponseWriter, partType string, parts ...[]byte) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", `multipart/related; type="`+partType+`"; boundary=`+mw.Boundary())
+	for _, data := range parts {
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {partType}})
+		require.NoError(t, err)
+		_, err = part.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+}
+
+func TestWADORS(t *testing.T) {
+	specs := []dicomtest.Spec{
+		{PatientName: "Wado^One", Rows: 4, Columns: 4, BitsAllocated: 16, NumberOfFrames: 3},
+		{PatientName: "Wado^Two"},
+	}
+	var accepts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		accepts = append(accepts, r.Header.Get("Accept"))
+		switch r.URL.Path {
+		case "/dicom-web/studies/1.2.3", "/dicom-web/studies/1.2.3/series/1.2.3.4":
+			writeMultipart(t, w, dicomweb.MediaTypeDICOM, dicomtest.MustBytes(specs[0]), dicomtest.MustBytes(specs[1]))
+		case "/dicom-web/studies/1.2.3/series/1.2.3.4/instances/1.2.3.4.5":
+			// 有些server对单个instance不用multipart
+			w.Header().Set("Content-Type", dicomweb.MediaTypeDICOM)
+			w.Write(dicomtest.MustBytes(specs[1])) // nolint: errcheck
+		case "/dicom-web/studies/1.2.3/series/1.2.3.4/instances/1.2.3.4.5/frames/3,1":
+			writeMultipart(t, w, dicomweb.MediaTypeOctetStream, dicomtest.FramePixels(specs[0], 2), dicomtest.FramePixels(specs[0], 0))
+		case "/dicom-web/studies/1.2.3/series/1.2.3.4/instances/1.2.3.4.5/frames/2":
+			writeMultipart(t, w, dicomweb.MediaTypeOctetStream)
+		case "/dicom-web/studies/html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>login</html>")) // nolint: errcheck
+		default:
+			http.Error(w, "no such study", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := &dicomweb.Client{BaseURL: server.URL + "/dicom-web/", Header: http.Header{"Authorization": {"secret"}}}
+	datasets, err := c.RetrieveStudy(ctx, "1.2.3", dicom.ReadOptions{})
+	require.NoError(t, err)
+	require.Len(t, datasets, 2)
+	for i, ds := range datasets {
+		elem, err := ds.FindElementByTag(dicomtag.PatientName)
+		require.NoError(t, err)
+		assert.Equal(t, specs[i].PatientName, elem.MustGetString())
+	}
+	pixelData, err := datasets[0].FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	assert.Len(t, pixelData.Value[0].(dicom.PixelDataInfo).Frames, 3)
+	assert.Equal(t, `multipart/related; type="application/dicom"`, accepts[0])
+
+	c.TransferSyntax = "*"
+	datasets, err = c.RetrieveSeries(ctx, "1.2.3", "1.2.3.4", dicom.ReadOptions{DropPixelData: true})
+	require.NoError(t, err)
+	assert.Len(t, datasets, 2)
+	assert.Equal(t, `multipart/related; type="application/dicom"; transfer-syntax=*`, accepts[1])
+	c.TransferSyntax = ""
+
+	ds, err := c.RetrieveInstance(ctx, "1.2.3", "1.2.3.4", "1.2.3.4.5", dicom.ReadOptions{})
+	require.NoError(t, err)
+	elem, err := ds.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Wado^Two", elem.MustGetString())
+
+	image, err := c.RetrieveFrames(ctx, "1.2.3", "1.2.3.4", "1.2.3.4.5", []int{3, 1})
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{dicomtest.FramePixels(specs[0], 2), dicomtest.FramePixels(specs[0], 0)}, image.Frames)
+	assert.Equal(t, `multipart/related; type="application/octet-stream"`, accepts[len(accepts)-1])
+
+	_, err = c.RetrieveFrames(ctx, "1.2.3", "1.2.3.4", "1.2.3.4.5", []int{2})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requested 1 frames, got 0")
+	_, err = c.RetrieveFrames(ctx, "1.2.3", "1.2.3.4", "1.2.3.4.5", []int{0})
+	require.Error(t, err)
+
+	_, err = c.RetrieveStudy(ctx, "missing", dicom.ReadOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such study")
+	_, err = c.RetrieveStudy(ctx, "html", dicom.ReadOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "text/html")
+}
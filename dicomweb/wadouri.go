@@ -0,0 +1,237 @@
+// Package dicomweb implements clients of the DICOM web services (P3.18):
+// URIClient for WADO-URI, the legacy URI based retrieval (P3.18 9), which
+// many PACS still expose, and Client for the RESTful services: WADO-RS
+// retrieval, QIDO-RS search and STOW-RS storage.
+package dicomweb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom"
+)
+
+// Media types of WADO-URI responses.
+const (
+	MediaTypeDICOM = "application/dicom"
+	MediaTypeJPEG  = "image/jpeg"
+	MediaTypeGIF   = "image/gif"
+	MediaTypePNG   = "image/png"
+)
+
+// URIRequest is a WADO-URI request for one instance, see P3.18 9.1.2. Zero
+// values leave the parameter out, so that the server uses its default.
+type URIRequest struct {
+	// StudyUID, SeriesUID and ObjectUID identify the instance. Required.
+	StudyUID  string
+	SeriesUID string
+	ObjectUID string
+
+	// ContentType is the media type of the response, e.g.,
+	// MediaTypeDICOM. The default of the server is MediaTypeJPEG for
+	// images.
+	ContentType string
+
+	// TransferSyntax 是ContentType为application/dicom时的transfer syntax UID
+	TransferSyntax string
+
+	// Anonymize asks the server to remove the patient identification
+	// (anonymize=yes). Only with application/dicom.
+	Anonymize bool
+
+	// 下面的参数只用于rendered images (image/jpeg等)
+	Rows, Columns             int
+	FrameNumber               int // 从1开始
+	WindowCenter, WindowWidth string
+	ImageQuality              int    // 1-100, 只用于lossy压缩
+	Annotation                string // "patient", "technique" 或 "patient,technique"
+}
+
+// Query returns the query string of the request, without "?".
+func (r URIRequest) Query() (string, error) {
+	if r.StudyUID == "" || r.SeriesUID == "" || r.ObjectUID == "" {
+		return "", fmt.Errorf("dicomweb: studyUID, seriesUID and objectUID are required")
+	}
+	v := url.Values{}
+	v.Set("requestType", "WADO")
+	v.Set("studyUID", r.StudyUID)
+	v.Set("seriesUID", r.SeriesUID)
+	v.Set("objectUID", r.ObjectUID)
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	setInt := func(key string, value int) {
+		if value != 0 {
+			v.Set(key, strconv.Itoa(value))
+		}
+	}
+	set("contentType", r.ContentType)
+	set("transferSyntax", r.TransferSyntax)
+	if r.Anonymize {
+		v.Set("anonymize", "yes")
+	}
+	setInt("rows", r.Rows)
+	setInt("columns", r.Columns)
+	setInt("frameNumber", r.FrameNumber)
+	set("windowCenter", r.WindowCenter)
+	set("windowWidth", r.WindowWidth)
+	setInt("imageQuality", r.ImageQuality)
+	set("annotation", r.Annotation)
+	return v.Encode(), nil
+}
+
+// URIClient retrieves instances from a WADO-URI service.
+type URIClient struct {
+	// BaseURL is the URL of the service, e.g.,
+	// "https://pacs.example.com/wado". The request parameters are added as
+	// its query.
+	BaseURL string
+
+	// HTTPClient sends the requests. nil means http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Header is added to every request, e.g., for authorization.
+	Header http.Header
+}
+
+// URL returns the URL of the request.
+func (c *URIClient) URL(r URIRequest) (string, error) {
+	query, err := r.Query()
+	if err != nil {
+		return "", err
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("dicomweb: invalid base URL: %v", err)
+	}
+	if base.RawQuery != "" {
+		query = base.RawQuery + "&" + query
+	}
+	base.RawQuery = query
+	return base.String(), nil
+}
+
+// Get sends the request, and returns the response body and its media type
+// (without parameters) if the status is 200 OK. The caller must close the
+// body.
+func (c *URIClient) Get(ctx context.Context, r URIRequest) (body io.ReadCloser, mediaType string, err error) {
+	u, err := c.URL(r)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := send(ctx, c.HTTPClient, c.Header, http.MethodGet, u, r.ContentType, nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType, _ = responseMediaType(resp)
+	return resp.Body, mediaType, nil
+}
+
+// send 发送一个request, status不是2xx时返回错误. "accept"和"contentType"为空时不设置
+func send(ctx context.Context, client *http.Client, header http.Header, method, u, accept string, body io.Reader, contentType string) (*http.Response, error) {
+	resp, err := do(ctx, client, header, method, u, accept, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, statusError(u, resp)
+	}
+	return resp, nil
+}
+
+// do 是send, 但不检查status
+func do(ctx context.Context, client *http.Client, header http.Header, method, u, accept string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, fmt.Errorf("dicomweb: %v", err)
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("dicomweb: %v", err)
+	}
+	return resp, nil
+}
+
+// statusError 返回描述失败的response的错误, 并关闭resp.Body
+func statusError(u string, resp *http.Response) error {
+	// 错误信息通常在body里, 只取开头
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close() // nolint: errcheck
+	return fmt.Errorf("dicomweb: %s: %s: %s", u, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// responseMediaType 返回response的media type和参数. 没有或不能解析Content-Type时返回""
+func responseMediaType(resp *http.Response) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return "", nil
+	}
+	return mediaType, params
+}
+
+// Retrieve requests the instance as application/dicom (r.ContentType is
+// ignored) and parses the response with "options". It is an error if the
+// server responds with another media type, e.g., an HTML error page; a
+// missing Content-Type or application/octet-stream is accepted.
+func (c *URIClient) Retrieve(ctx context.Context, r URIRequest, options dicom.ReadOptions) (*dicom.DataSet, error) {
+	r.ContentType = MediaTypeDICOM
+	body, mediaType, err := c.Get(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close() // nolint: errcheck
+	switch mediaType {
+	case MediaTypeDICOM, "application/octet-stream", "":
+	default:
+		return nil, fmt.Errorf("dicomweb.Retrieve: %s: expected %s, got %s", r.ObjectUID, MediaTypeDICOM, mediaType)
+	}
+	ds, err := dicom.ReadDataSet(body, options)
+	if err != nil {
+		return nil, fmt.Errorf("dicomweb.Retrieve: %s: %v", r.ObjectUID, err)
+	}
+	return ds, nil
+}
+
+// RetrieveRendered requests a rendered image, by default image/jpeg, and
+// returns its bytes and media type.
+func (c *URIClient) RetrieveRendered(ctx context.Context, r URIRequest) (data []byte, mediaType string, err error) {
+	if r.ContentType == "" {
+		r.ContentType = MediaTypeJPEG
+	}
+	body, mediaType, err := c.Get(ctx, r)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close() // nolint: errcheck
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, "", fmt.Errorf("dicomweb.RetrieveRendered: %s: expected an image, got %s", r.ObjectUID, mediaType)
+	}
+	data, err = ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("dicomweb.RetrieveRendered: %s: %v", r.ObjectUID, err)
+	}
+	return data, mediaType, nil
+}
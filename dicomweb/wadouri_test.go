@@ -0,0 +1,109 @@
+package dicomweb_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomweb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURIRequest(t *testing.T) {
+	c := &dicomweb.URIClient{BaseURL: "https://pacs.example.com/wado?site=1"}
+	u, err := c.URL(dicomweb.URIRequest{
+		StudyUID: "1.2.3", SeriesUID: "1.2.3.4", ObjectUID: "1.2.3.4.5",
+		ContentType: dicomweb.MediaTypeJPEG, Rows: 256, FrameNumber: 2, WindowCenter: "40", WindowWidth: "400",
+	})
+	require.NoError(t, err)
+	parsed, err := url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "/wado", parsed.Path)
+	assert.Equal(t, url.Values{
+		"site":         {"1"},
+		"requestType":  {"WADO"},
+		"studyUID":     {"1.2.3"},
+		"seriesUID":    {"1.2.3.4"},
+		"objectUID":    {"1.2.3.4.5"},
+		"contentType":  {"image/jpeg"},
+		"rows":         {"256"},
+		"frameNumber":  {"2"},
+		"windowCenter": {"40"},
+		"windowWidth":  {"400"},
+	}, parsed.Query())
+
+	_, err = c.URL(dicomweb.URIRequest{StudyUID: "1.2.3", SeriesUID: "1.2.3.4"})
+	require.Error(t, err)
+}
+
+func TestURIRetrieve(t *testing.T) {
+	spec := dicomtest.Spec{PatientName: "Wado^Test"}
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		assert.Equal(t, "WADO", q.Get("requestType"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		switch q.Get("objectUID") {
+		case "missing":
+			http.Error(w, "no such object", http.StatusNotFound)
+		case "html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>login</html>"))
+		case "endless":
+			// A response that never ends: reading its body blocks.
+			w.Header().Set("Content-Type", dicomweb.MediaTypeDICOM)
+			w.Write(dicomtest.MustBytes(spec))
+			w.(http.Flusher).Flush()
+			<-unblock
+		default:
+			if q.Get("contentType") == dicomweb.MediaTypeDICOM {
+				w.Header().Set("Content-Type", dicomweb.MediaTypeDICOM)
+				w.Write(dicomtest.MustBytes(spec))
+			} else {
+				w.Header().Set("Content-Type", dicomweb.MediaTypeJPEG)
+				w.Write([]byte{0xff, 0xd8, 0xff, 0xd9})
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	ctx := context.Background()
+	c := &dicomweb.URIClient{BaseURL: server.URL, Header: http.Header{"Authorization": {"secret"}}}
+	r := dicomweb.URIRequest{StudyUID: "1.2.3", SeriesUID: "1.2.3.4", ObjectUID: "1.2.3.4.5"}
+	ds, err := c.Retrieve(ctx, r, dicom.ReadOptions{DropPixelData: true})
+	require.NoError(t, err)
+	elem, err := ds.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Wado^Test", elem.MustGetString())
+
+	data, mediaType, err := c.RetrieveRendered(ctx, r)
+	require.NoError(t, err)
+	assert.Equal(t, dicomweb.MediaTypeJPEG, mediaType)
+	assert.Equal(t, []byte{0xff, 0xd8, 0xff, 0xd9}, data)
+
+	r.ObjectUID = "missing"
+	_, err = c.Retrieve(ctx, r, dicom.ReadOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such object")
+
+	r.ObjectUID = "html"
+	_, err = c.Retrieve(ctx, r, dicom.ReadOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "text/html")
+
+	// RetrieveRendered checks the media type before reading the body.
+	r.ObjectUID = "endless"
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, _, err = c.RetrieveRendered(timeout, r)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected an image")
+}
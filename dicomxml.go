@@ -0,0 +1,387 @@
+package dicom
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// XMLOptions controls MarshalXMLWithOptions and UnmarshalXMLWithOptions, as
+// JSONOptions does for JSON: BulkDataURI gives the uri of BulkData elements,
+// and BulkData reads them.
+type XMLOptions = JSONOptions
+
+// Native DICOM Model (P3.19 A.1) 的XML elements
+type xmlDataSet struct {
+	XMLName    xml.Name       `xml:"NativeDicomModel"`
+	Space      string         `xml:"xml:space,attr,omitempty"`
+	Attributes []xmlAttribute `xml:"DicomAttribute"`
+}
+
+type xmlAttribute struct {
+	Tag            string          `xml:"tag,attr"`
+	VR             string          `xml:"vr,attr"`
+	Keyword        string          `xml:"keyword,attr,omitempty"`
+	PrivateCreator string          `xml:"privateCreator,attr,omitempty"`
+	Values         []xmlValue      `xml:"Value"`
+	PersonNames    []xmlPersonName `xml:"PersonName"`
+	Items          []xmlItem       `xml:"Item"`
+	InlineBinary   string          `xml:"InlineBinary,omitempty"`
+	BulkData       *xmlBulkData    `xml:"BulkData"`
+}
+
+type xmlValue struct {
+	Number int    `xml:"number,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type xmlItem struct {
+	Number     int            `xml:"number,attr"`
+	Attributes []xmlAttribute `xml:"DicomAttribute"`
+}
+
+type xmlPersonName struct {
+	Number      int           `xml:"number,attr"`
+	Alphabetic  *xmlNameGroup `xml:"Alphabetic"`
+	Ideographic *xmlNameGroup `xml:"Ideographic"`
+	Phonetic    *xmlNameGroup `xml:"Phonetic"`
+}
+
+// xmlNameGroup 是PN的一个component group, 各个component按"^"分隔的顺序
+type xmlNameGroup struct {
+	FamilyName string `xml:"FamilyName,omitempty"`
+	GivenName  string `xml:"GivenName,omitempty"`
+	MiddleName string `xml:"MiddleName,omitempty"`
+	NamePrefix string `xml:"NamePrefix,omitempty"`
+	NameSuffix string `xml:"NameSuffix,omitempty"`
+}
+
+type xmlBulkData struct {
+	URI string `xml:"uri,attr"`
+}
+
+// MarshalXML encodes "ds" in the Native DICOM Model (P3.19 A.1), the XML
+// representation read by, e.g., dcm4che's xml2dcm. As with MarshalJSON, the
+// file meta group and group lengths are omitted, and binary values are
+// inlined in base64.
+func MarshalXML(ds *DataSet) ([]byte, error) {
+	return MarshalXMLWithOptions(ds, XMLOptions{})
+}
+
+// MarshalXMLWithOptions is the same as MarshalXML, but binary values may be
+// replaced with BulkData URIs, as configured by "options".
+func MarshalXMLWithOptions(ds *DataSet, options XMLOptions) ([]byte, error) {
+	m := &xmlMarshaler{options: options, byteOrder: dataSetByteOrder(ds)}
+	var elems []*Element
+	for _, elem := range ds.Elements {
+		if elem.Tag.Group != dicomtag.MetadataGroup {
+			elems = append(elems, elem)
+		}
+	}
+	attrs, err := m.attributes(nil, elems)
+	if err != nil {
+		return nil, err
+	}
+	data, err := xml.MarshalIndent(xmlDataSet{Space: "preserve", Attributes: attrs}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+type xmlMarshaler struct {
+	options   JSONOptions
+	byteOrder binary.ByteOrder
+}
+
+func (m *xmlMarshaler) attributes(path []dicomtag.Tag, elems []*Element) ([]xmlAttribute, error) {
+	var attrs []xmlAttribute
+	for _, elem := range elems {
+		if elem.Tag.Element == 0 {
+			continue // group length
+		}
+		attr, err := m.attribute(append(path, elem.Tag), elem, elems)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+// attribute 返回elem的DicomAttribute. siblings是同一层的elements, 用来找private creator
+func (m *xmlMarshaler) attribute(path []dicomtag.Tag, elem *Element, siblings []*Element) (xmlAttribute, error) {
+	attr := xmlAttribute{Tag: fmt.Sprintf("%04X%04X", elem.Tag.Group, elem.Tag.Element), VR: elem.VR}
+	if dicomtag.IsPrivate(elem.Tag.Group) {
+		if elem.Tag.Element >= 0x1000 {
+			creatorTag := dicomtag.Tag{Group: elem.Tag.Group, Element: elem.Tag.Element >> 8}
+			if creator, err := FindElementByTag(siblings, creatorTag); err == nil {
+				attr.PrivateCreator, _ = creator.GetString()
+			}
+		}
+	} else if info, err := dicomtag.Find(elem.Tag); err == nil {
+		attr.Keyword = info.Name
+	}
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("dicom.MarshalXML: %s: %s", dicomtag.DebugString(elem.Tag), fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case elem.Tag == dicomtag.PixelData || jsonBinaryVRs[elem.VR]:
+		if m.options.BulkDataURI != nil {
+			if uri := m.options.BulkDataURI(path, elem); uri != "" {
+				attr.BulkData = &xmlBulkData{URI: uri}
+				return attr, nil
+			}
+		}
+		data, err := littleEndianBytes(elem, m.byteOrder)
+		if err != nil {
+			return attr, errorf("%v", err)
+		}
+		attr.InlineBinary = base64.StdEncoding.EncodeToString(data)
+	case elem.VR == "SQ":
+		for i, value := range elem.Value {
+			item, ok := value.(*Element)
+			if !ok {
+				return attr, errorf("sequence value is not an item: %v", value)
+			}
+			attrs, err := m.attributes(path, itemElements(item))
+			if err != nil {
+				return attr, err
+			}
+			attr.Items = append(attr.Items, xmlItem{Number: i + 1, Attributes: attrs})
+		}
+	case elem.VR == "PN":
+		for i, value := range elem.Value {
+			s, ok := value.(string)
+			if !ok {
+				return attr, errorf("unexpected value %v", value)
+			}
+			if s != "" {
+				attr.PersonNames = append(attr.PersonNames, xmlPersonNameOf(i+1, s))
+			}
+		}
+	default:
+		for i, value := range elem.Value {
+			s, err := xmlValueString(value)
+			if err != nil {
+				return attr, errorf("%v", err)
+			}
+			if s != "" {
+				attr.Values = append(attr.Values, xmlValue{Number: i + 1, Value: s})
+			}
+		}
+	}
+	return attr, nil
+}
+
+// xmlValueString 返回一个value在XML中的字符串
+func xmlValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case uint16, int16, uint32, int32, uint64, int64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case dicomtag.Tag:
+		return fmt.Sprintf("%04X%04X", v.Group, v.Element), nil
+	}
+	return "", fmt.Errorf("unexpected value %v", value)
+}
+
+func xmlPersonNameOf(number int, name string) xmlPersonName {
+	pn := xmlPersonName{Number: number}
+	for i, group := range strings.SplitN(name, "=", 3) {
+		if group == "" {
+			continue
+		}
+		components := strings.SplitN(group, "^", 5)
+		for len(components) < 5 {
+			components = append(components, "")
+		}
+		g := &xmlNameGroup{components[0], components[1], components[2], components[3], components[4]}
+		switch i {
+		case 0:
+			pn.Alphabetic = g
+		case 1:
+			pn.Ideographic = g
+		case 2:
+			pn.Phonetic = g
+		}
+	}
+	return pn
+}
+
+// String 返回"^"分隔的component group, 去掉末尾空的components
+func (g *xmlNameGroup) String() string {
+	if g == nil {
+		return ""
+	}
+	return strings.TrimRight(strings.Join([]string{g.FamilyName, g.GivenName, g.MiddleName, g.NamePrefix, g.NameSuffix}, "^"), "^")
+}
+
+// UnmarshalXML parses a data set in the Native DICOM Model (P3.19 A.1).
+// BulkData elements are errors; see UnmarshalXMLWithOptions. As with
+// UnmarshalJSON, file meta elements are added if the data set has
+// SOPClassUID and SOPInstanceUID.
+func UnmarshalXML(data []byte) (*DataSet, error) {
+	return UnmarshalXMLWithOptions(data, XMLOptions{})
+}
+
+// UnmarshalXMLWithOptions is the same as UnmarshalXML, but BulkData uris
+// are read with options.BulkData.
+func UnmarshalXMLWithOptions(data []byte, options XMLOptions) (*DataSet, error) {
+	var model xmlDataSet
+	if err := xml.Unmarshal(data, &model); err != nil {
+		return nil, fmt.Errorf("dicom.UnmarshalXML: %v", err)
+	}
+	u := &xmlUnmarshaler{options: options}
+	elems, err := u.elements(nil, model.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	ds := &DataSet{Elements: elems}
+	if err := addModelFileMeta(ds); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+type xmlUnmarshaler struct {
+	options JSONOptions
+}
+
+// elements 返回一个data set或item的elements, 按tag排序
+func (u *xmlUnmarshaler) elements(path []dicomtag.Tag, attrs []xmlAttribute) ([]*Element, error) {
+	var elems []*Element
+	for _, attr := range attrs {
+		tag, err := parseJSONTag(attr.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.UnmarshalXML: %v", err)
+		}
+		elem, err := u.element(append(path, tag), tag, attr)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	sort.Slice(elems, func(i, j int) bool { return elems[i].Tag.Compare(elems[j].Tag) < 0 })
+	return elems, nil
+}
+
+func (u *xmlUnmarshaler) element(path []dicomtag.Tag, tag dicomtag.Tag, attr xmlAttribute) (*Element, error) {
+	elem := &Element{Tag: tag, VR: attr.VR}
+	if elem.VR == "" {
+		if info, err := dicomtag.Find(tag); err == nil {
+			elem.VR = info.VR
+		} else {
+			elem.VR = "UN"
+		}
+	}
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("dicom.UnmarshalXML: %s: %s", dicomtag.DebugString(tag), fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case attr.InlineBinary != "" || attr.BulkData != nil:
+		var data []byte
+		var err error
+		if attr.BulkData == nil {
+			data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(attr.InlineBinary))
+		} else if u.options.BulkData == nil {
+			err = fmt.Errorf("BulkData %s, but XMLOptions.BulkData is nil", attr.BulkData.URI)
+		} else {
+			data, err = u.options.BulkData(path, attr.BulkData.URI)
+		}
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		if err := setBinaryValue(elem, data); err != nil {
+			return nil, errorf("%v", err)
+		}
+	case elem.VR == "SQ":
+		for _, item := range attr.Items {
+			elems, err := u.elements(path, item.Attributes)
+			if err != nil {
+				return nil, err
+			}
+			elem.Value = append(elem.Value, NewItem(elems...))
+		}
+	case elem.VR == "PN":
+		values := make([]string, 0, len(attr.PersonNames))
+		for _, pn := range attr.PersonNames {
+			name := strings.TrimRight(strings.Join([]string{pn.Alphabetic.String(), pn.Ideographic.String(), pn.Phonetic.String()}, "="), "=")
+			values = setNumberedValue(values, pn.Number, name)
+		}
+		for _, v := range values {
+			elem.Value = append(elem.Value, v)
+		}
+	default:
+		values := make([]string, 0, len(attr.Values))
+		for _, v := range attr.Values {
+			values = setNumberedValue(values, v.Number, v.Value)
+		}
+		for _, s := range values {
+			v, err := xmlParseValue(elem.VR, s)
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			elem.Value = append(elem.Value, v)
+		}
+	}
+	return elem, nil
+}
+
+// setNumberedValue 把value放在values的第number个位置 (从1开始). number为0时追加在最后.
+// 中间缺少的value是空字符串
+func setNumberedValue(values []string, number int, value string) []string {
+	if number <= 0 {
+		return append(values, value)
+	}
+	for len(values) < number {
+		values = append(values, "")
+	}
+	values[number-1] = value
+	return values
+}
+
+// xmlParseValue 把XML中的字符串转换成VR对应的value类型
+func xmlParseValue(vr, s string) (interface{}, error) {
+	s0 := s
+	s = strings.TrimSpace(s)
+	switch vr {
+	case "AT":
+		return parseJSONTag(s)
+	case "US":
+		n, err := strconv.ParseUint(s, 10, 16)
+		return uint16(n), err
+	case "SS":
+		n, err := strconv.ParseInt(s, 10, 16)
+		return int16(n), err
+	case "UL":
+		n, err := strconv.ParseUint(s, 10, 32)
+		return uint32(n), err
+	case "SL":
+		n, err := strconv.ParseInt(s, 10, 32)
+		return int32(n), err
+	case "UV":
+		return strconv.ParseUint(s, 10, 64)
+	case "SV":
+		return strconv.ParseInt(s, 10, 64)
+	case "FL":
+		f, err := strconv.ParseFloat(s, 32)
+		return float32(f), err
+	case "FD":
+		return strconv.ParseFloat(s, 64)
+	}
+	return s0, nil
+}
@@ -0,0 +1,68 @@
+package dicom_test
+
+import (
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXML(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Put(dicom.MustNewElement(dicomtag.PatientName, "Zhang^San=张^三"))
+	ds.Put(dicom.MustNewElement(dicomtag.SOPClassUID, "1.2.840.10008.5.1.4.1.1.7"))
+	require.NoError(t, ds.PutString(dicomtag.PixelSpacing, "0.5", "0.25"))
+	require.NoError(t, ds.PutUInt16(dicomtag.Rows, 2))
+	ds.Put(dicom.MustNewElement(dicomtag.FrameIncrementPointer, dicomtag.FrameTime))
+	ds.Put(dicom.MustNewElement(dicomtag.RequestAttributesSequence,
+		dicom.MustNewElement(dicomtag.Item, dicom.MustNewElement(dicomtag.RequestedProcedureID, "R1"))))
+	ds.Put(&dicom.Element{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x0010}, VR: "LO", Value: []interface{}{"ACME"}})
+	ds.Put(&dicom.Element{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1001}, VR: "LO", Value: []interface{}{"private"}})
+	ds.Put(&dicom.Element{Tag: dicomtag.PixelData, VR: "OW",
+		Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{{1, 2, 3, 4}}}}})
+
+	data, err := dicom.MarshalXML(ds)
+	require.NoError(t, err)
+	xml := string(data)
+	for _, s := range []string{
+		`<NativeDicomModel xml:space="preserve">`,
+		`<DicomAttribute tag="00100010" vr="PN" keyword="PatientName">`,
+		`<Alphabetic>`, `<FamilyName>Zhang</FamilyName>`, `<GivenName>三</GivenName>`,
+		`<Value number="2">0.25</Value>`,
+		`<Value number="1">00181063</Value>`,
+		`<Item number="1">`,
+		`<DicomAttribute tag="00091001" vr="LO" privateCreator="ACME">`,
+		`<InlineBinary>AQIDBA==</InlineBinary>`,
+	} {
+		require.Contains(t, xml, s)
+	}
+	require.NotContains(t, xml, `tag="00020010"`)
+
+	parsed, err := dicom.UnmarshalXML(data)
+	require.NoError(t, err)
+	require.NoError(t, parsed.CheckStructure())
+	parsed = mustReadBytes(mustWriteDataSet(parsed), dicom.ReadOptions{})
+	for _, tag := range []dicomtag.Tag{dicomtag.MediaStorageSOPInstanceUID, dicomtag.PatientName, dicomtag.PixelSpacing,
+		dicomtag.Rows, dicomtag.FrameIncrementPointer, {Group: 0x0009, Element: 0x1001}, dicomtag.PixelData} {
+		expected, err := ds.FindElementByTag(tag)
+		require.NoError(t, err)
+		elem, err := parsed.FindElementByTag(tag)
+		require.NoError(t, err)
+		require.Equal(t, expected.Value, elem.Value, dicomtag.DebugString(tag))
+	}
+
+	// BulkData is read with XMLOptions.BulkData.
+	data = []byte(`<NativeDicomModel><DicomAttribute tag="7FE00010" vr="OB"><BulkData uri="file:///pixels"/></DicomAttribute>` +
+		`<DicomAttribute tag="00200013" vr="IS"><Value number="2">7</Value></DicomAttribute></NativeDicomModel>`)
+	_, err = dicom.UnmarshalXML(data)
+	require.Error(t, err)
+	parsed, err = dicom.UnmarshalXMLWithOptions(data, dicom.XMLOptions{BulkData: func(path []dicomtag.Tag, uri string) ([]byte, error) {
+		require.Equal(t, "file:///pixels", uri)
+		return []byte{5, 6}, nil
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"", "7"}, parsed.Elements[0].Value)
+	require.Equal(t, []interface{}{dicom.PixelDataInfo{Frames: [][]byte{{5, 6}}}}, parsed.Elements[1].Value)
+}
@@ -0,0 +1,28 @@
+// Package dicom reads and writes DICOM files (P3.10) and data sets (P3.5).
+//
+// The module path is "github.com/odincare/odicom"; the package name is
+// "dicom". The module is laid out as:
+//
+//  github.com/odincare/odicom           DataSet/Element, reading and writing
+//  github.com/odincare/odicom/dicomio   low-level encoder and decoder
+//  github.com/odincare/odicom/dicomtag  tag dictionary
+//  github.com/odincare/odicom/dicomuid  UID dictionary
+//  github.com/odincare/odicom/dicomlog  logging knobs
+//  github.com/odincare/odicom/dicomimage images derived from pixel data
+//  github.com/odincare/odicom/dicomtest synthesized DICOM files for tests
+//  github.com/odincare/odicom/anonymize de-identification helpers
+//  github.com/odincare/odicom/dicomqc   quality-control checks
+//  github.com/odincare/odicom/dicomref  instance reference sequences
+//  github.com/odincare/odicom/dicomdir  DICOMDIR (media directory) records
+//  github.com/odincare/odicom/dicomsig  digital signatures, without verification
+//  github.com/odincare/odicom/netdicom  network protocol
+//  github.com/odincare/odicom/cmd/odicom command-line tool, e.g., bulk anonymization
+//
+// Packages outside internal/ directories follow semantic versioning as a v1
+// module: exported identifiers keep their signatures within the major
+// version. api_test.go pins the signatures that downstream users depend on;
+// a change that breaks it requires a new major version (and a /v2 module
+// path). New reading and writing features are added as functional options
+// of Read and Write (e.g., WithDropPixelData, WithLogger) rather than as
+// ReadOptions fields.
+package dicom // import "github.com/odincare/odicom"
@@ -2,17 +2,18 @@ package dicom
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomlog"
 	"github.com/odincare/odicom/dicomtag"
-
-	"github.com/sirupsen/logrus"
 )
 
 // Element represents a single DICOM element. Use NewElement() to create a
@@ -33,14 +34,17 @@ type Element struct {
 	// Else if VR=="DA", then len(Value)==1, and Value[0] is string. Use ParseDate() to parse the date string.
 	// Else if VR=="US", Value[] is a list of uint16s (len(Value) matches VM of the Tag; PS 3.5 6.4)
 	// Else if VR=="UL", Value[] is a list of uint32s (len(Value) matches VM of the Tag; PS 3.5 6.4)
+	// Else if VR=="UV", Value[] is a list of uint64s (len(Value) matches VM of the Tag; PS 3.5 6.4)
 	// Else if VR=="SS", Value[] is a list of int16s (len(Value) matches VM of the Tag; PS 3.5 6.4)
 	// Else if VR=="SL", Value[] is a list of int32s (len(Value) matches VM of the Tag; PS 3.5 6.4)
+	// Else if VR=="SV", Value[] is a list of int64s (len(Value) matches VM of the Tag; PS 3.5 6.4)
 	// Else if VR=="FL", Value[] is a list of float32s (len(Value) matches VM of the Tag; PS 3.5 6.4)
 	// Else if VR=="FD", Value[] is a list of float64s (len(Value) matches VM of the Tag; PS 3.5 6.4)
 	// Else if VR=="AT", Value[] is a list of Tag's. (len(Value) matches VM of the Tag; PS 3.5 6.4)
 	// Else if VR=="OF", Value[] is a list of float32s
 	// Else if VR=="OD", Value[] is a list of float64s
-	// Else if VR=="OW" or "OB", len(Value)==1, and Value[0] is []byte.
+	// Else if VR=="OL", Value[] is a list of uint32s
+	// Else if VR=="OW", "OB", "OV" or "UN", len(Value)==1, and Value[0] is []byte.
 	// Else, Value[] is a list of strings.
 	//
 	// Note: Use GetVRKind() to map VR string to the go representation of
@@ -78,23 +82,122 @@ type Element struct {
 type DataSet struct {
 	// 与pydicom不同， Elements扔包含元数据（Tag.Group==2的)
 	Elements []*Element
+
+	// TrailingData 保存最后一个element之后无法解析的bytes(padding或vendor垃圾数据)
+	// 只有ReadOptions.AllowTrailingData为true时才会被填充
+	TrailingData []byte
+
+	// ChangeLog 如果不为nil, 通过DataSet的方法(例如Replace)做的修改会被记录下来
+	ChangeLog *ChangeLog
+
+	// diagnostics 是ReadDataSet或Salvage读取时发现的问题, 见Diagnostics
+	diagnostics []Diagnostic
 }
 
 // ReadOptions定义DataSets和Element的读取格式
 type ReadOptions struct {
-	// DropPixelData会让ReadDataSet跳过PixelData(bulk image)
+	// DropPixelData会让ReadDataSet跳过PixelData(bulk image). 读取在PixelData处停止,
+	// 所以PixelData之后的element (不符合标准的文件中可能有) 也不会被读取
 	DropPixelData bool
 
 	// ReturnTags 会返回一系列tag白名单
 	ReturnTags []dicomtag.Tag
 
-	//TODO (翻译有点问题) StopAtTag 使在读取时或value超过最大值时，程序会停止读取dicom file
+	// StopAtTag 使ReadDataSet在第一个tag不小于StopAtTag (按Tag.Compare) 的top-level element处停止读取,
+	// 这个element不被返回
 	StopAtTag *dicomtag.Tag
+
+	// StopAtTagInclusive 使StopAtTag本身也被读取和返回, 读取在它之后的element处停止
+	StopAtTagInclusive bool
+
+	// StopAfterGroup 如果不为nil, ReadDataSet在第一个group大于*StopAfterGroup的top-level element处停止读取,
+	// 例如0x0010表示读完patient的attributes之后停止
+	StopAfterGroup *uint16
+
+	// SkipTags 中的element不会被返回. 与ReturnTags相同, 这些element的value
+	// 会被直接跳过, 不做字符集解码也不分配内存
+	SkipTags []dicomtag.Tag
+
+	// SkipPrivateTags 使奇数group的top-level elements (private attributes, 包括它们的creator和group length)
+	// 不被返回, value被直接跳过. 厂商的private blobs常常占大部分内存. 解码pixel data需要的attributes都不是private的
+	SkipPrivateTags bool
+
+	// ElementFilter 如果不为nil, 只有ElementFilter(tag)返回true的top-level element会被返回,
+	// 其他element的value会被直接跳过
+	ElementFilter func(tag dicomtag.Tag) bool
+
+	// AllowTrailingData 使ReadDataSet在遇到不像element header的数据时停止读取
+	// （比如全0的padding，explicit VR的VR不合法，或者VL超过了剩下的输入），
+	// 而不是把它们当作element解析然后报错。剩下的bytes会被保存在DataSet.TrailingData
+	AllowTrailingData bool
+
+	// InternStrings 使ReadDataSet对重复的短string value(UID, code meaning等)共享同一份内存.
+	// 读取大量同一study/series的文件并保留DataSet时(例如建索引)能明显减少heap. 见internStrings
+	InternStrings bool
+
+	// Duplicates 决定ReadDataSet遇到重复的top-level tag (例如两个PixelData) 时的行为.
+	// 默认保留第一个
+	Duplicates DuplicatePolicy
+
+	// FramePool 如果不为nil, PixelData的frames读进从它取得的buffers.
+	// 用完之后调用PixelDataInfo.Release把buffers还回去. 见FramePool
+	FramePool *FramePool
+
+	// CheckFrameCount 使ReadDataSet检查encapsulated PixelData的frame个数与NumberOfFrames和offset tables一致,
+	// 不一致时返回错误和读到的data set. 见DataSet.CheckFrameCount
+	CheckFrameCount bool
+
+	// MaxElementSize 如果大于0, 是一个element的value (或encapsulated PixelData的一个fragment) 最多的bytes.
+	// 损坏的VL (例如0xFFFFFFF0) 不会导致巨大的内存分配: ReadDataSet跳过更长的top-level element
+	// 并记录一个Diagnostic, sequence里面的element则是读取错误. SQ和Item本身的长度不受限制
+	MaxElementSize int64
+
+	// OnProgress 如果不为nil, 在读取时被定期调用 (大约每1MB, 以及读到结尾时), 例如给UI或者
+	// 上传服务显示大的enhanced multi-frame文件的进度. bytesRead是从输入读出的bytes,
+	// totalBytes是输入的长度, 只有输入有Len()或者是io.Seeker (例如os.File) 时才知道, 否则是-1
+	OnProgress func(bytesRead, totalBytes int64)
+
+	// diagnose 如果不为nil, readElement用它报告读取时发现的问题. 由Parser设置
+	diagnose func(Diagnostic)
+
+	// logger 是读取时warnings的输出, 见WithLogger
+	logger dicomlog.Logger
+
+	// ctx 如果不为nil, Parser在每个top-level element之前检查它是否被取消, 见WithContext
+	ctx context.Context
 }
 
+// DuplicatePolicy tells ReadDataSet what to do with top-level elements that
+// appear more than once, which non-conformant generators sometimes write,
+// e.g., two PixelData elements.
+type DuplicatePolicy int
+
+const (
+	// KeepFirstDuplicate keeps the first element with the tag, and logs a
+	// warning. FindElementByTag and Salvage also use the first one.
+	KeepFirstDuplicate DuplicatePolicy = iota
+	// KeepLastDuplicate keeps the last element with the tag, and logs a
+	// warning.
+	KeepLastDuplicate
+	// KeepAllDuplicates keeps all the elements; those with the same tag are
+	// in the order they appear. The DataSet fails CheckStructure, and is
+	// written with the duplicates.
+	KeepAllDuplicates
+	// RejectDuplicates makes ReadDataSet return an error, along with the
+	// data set read with KeepFirstDuplicate.
+	RejectDuplicates
+)
+
+// PixelDataInfo 是PixelData element的value. Encapsulated pixel data的每个fragment是一个frame;
+// ReadDataSet读取的native pixel data每个frame是一个entry
 type PixelDataInfo struct {
 	Offsets []uint32 // BasicOffsetTable
 	Frames  [][]byte // Parsed images
+
+	// pool 是Frames的buffers来自的FramePool, buffers是要还给它的buffers.
+	// Native multi-frame的Frames是同一个buffer的slices
+	pool    *FramePool
+	buffers [][]byte
 }
 
 const UndefinedLength uint32 = 0xffffffff
@@ -104,6 +207,7 @@ const ItemSeqGroup = 0xFFFE
 // NewElement用传入的tag和values来创建一个新的Element
 // 每个传入的值必须符合 tag 的 VR
 // 详情-> tag_definition.go
+// 超过VR最大长度的值会输出warning, 见CheckValueLengths
 func NewElement(tag dicomtag.Tag, values ...interface{}) (*Element, error) {
 	ti, err := dicomtag.Find(tag)
 	if err != nil {
@@ -134,6 +238,10 @@ func NewElement(tag dicomtag.Tag, values ...interface{}) (*Element, error) {
 			_, ok = v.(int16)
 		case dicomtag.VRInt32List:
 			_, ok = v.(int32)
+		case dicomtag.VRUInt64List:
+			_, ok = v.(uint64)
+		case dicomtag.VRInt64List:
+			_, ok = v.(int64)
 		case dicomtag.VRFloat32List:
 			_, ok = v.(float32)
 		case dicomtag.VRFloat64List:
@@ -159,10 +267,60 @@ func NewElement(tag dicomtag.Tag, values ...interface{}) (*Element, error) {
 
 		e.Value[i] = v
 	}
+	warnValueLengths(&e, e.VR)
 
 	return &e, nil
 }
 
+// CheckValueLengths returns an error if a string value of "e" is longer
+// than dicomtag.MaxValueLength of its VR (e.g., an LO value of more than 64
+// characters), which remote archives often reject. DA, DT and TM values
+// that are ranges ("from-to", in queries) may be twice as long. Values of
+// nested elements are not checked; DataSet.Flatten returns them.
+func (e *Element) CheckValueLengths() error {
+	return checkValueLengths(e, e.VR)
+}
+
+// checkValueLengths 按vr检查e的values的长度
+func checkValueLengths(e *Element, vr string) error {
+	maxLength := dicomtag.MaxValueLength(vr)
+	if maxLength == 0 {
+		return nil
+	}
+	for i, v := range e.Value {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		s = strings.TrimRight(s, " \x00")
+		length, limit := utf8.RuneCountInString(s), maxLength
+		switch {
+		case vr == "PN":
+			// 每个component group (alphabetic, ideographic, phonetic) 分别计算
+			length = 0
+			for _, group := range strings.Split(s, "=") {
+				if n := utf8.RuneCountInString(group); n > length {
+					length = n
+				}
+			}
+		case (vr == "DA" || vr == "DT" || vr == "TM") && strings.Contains(s, "-"):
+			limit = 2*maxLength + 2
+		}
+		if length > limit {
+			return fmt.Errorf("%v: value #%d has %d characters, %s allows at most %d: %q",
+				dicomtag.DebugString(e.Tag), i, length, vr, limit, s)
+		}
+	}
+	return nil
+}
+
+// warnValueLengths 在e的value超过vr的最大长度时输出warning
+func warnValueLengths(e *Element, vr string) {
+	if err := checkValueLengths(e, vr); err != nil {
+		dicomlog.Warn("dicom: value too long", dicomlog.Fields{dicomlog.TagKey: dicomtag.DebugString(e.Tag), "error": err.Error()})
+	}
+}
+
 // MustNewElement is similar to NewElement, but it crashes the process on any error
 func MustNewElement(tag dicomtag.Tag, values ...interface{}) *Element {
 
@@ -174,6 +332,55 @@ func MustNewElement(tag dicomtag.Tag, values ...interface{}) *Element {
 	return elem
 }
 
+// NewItem 把elems包装成一个Item element, 用作SQ element的value.
+// elems为空时创建一个空的Item
+func NewItem(elems ...*Element) *Element {
+	item := &Element{Tag: dicomtag.Item, VR: "NA", Value: make([]interface{}, len(elems))}
+	for i, elem := range elems {
+		item.Value[i] = elem
+	}
+	return item
+}
+
+// itemElements 返回Item element里的sub elements
+func itemElements(item *Element) []*Element {
+	var elems []*Element
+	for _, v := range item.Value {
+		if elem, ok := v.(*Element); ok {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// NewSequence creates an SQ element. Each of "items" is the list of elements
+// in one item, so NewSequence(tag, elems) creates a single-item sequence, and
+// NewSequence(tag) creates an empty sequence, e.g., for a Type 2 SQ
+// attribute. Returns an error if the tag's VR isn't SQ.
+func NewSequence(tag dicomtag.Tag, items ...[]*Element) (*Element, error) {
+	values := make([]interface{}, len(items))
+	for i, elems := range items {
+		values[i] = NewItem(elems...)
+	}
+	elem, err := NewElement(tag, values...)
+	if err != nil {
+		return nil, err
+	}
+	if elem.VR != "SQ" {
+		return nil, fmt.Errorf("%v: NewSequence: tag has VR %s, not SQ", dicomtag.DebugString(tag), elem.VR)
+	}
+	return elem, nil
+}
+
+// MustNewSequence is similar to NewSequence, but it crashes the process on any error
+func MustNewSequence(tag dicomtag.Tag, items ...[]*Element) *Element {
+	elem, err := NewSequence(tag, items...)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create sequence with tag %v: %v", tag, err))
+	}
+	return elem
+}
+
 // GetUInt32 gets a uint32 value from an element.  It returns an error if the
 // element contains zero or >1 values, or the value is not a uint32.
 func (e *Element) GetUInt32() (uint32, error) {
@@ -305,8 +512,10 @@ func (e *Element) MustGetUint16s() []uint16 {
 }
 
 func elementString(e *Element, nestLevel int) string {
-	dicomio.DoAssert(nestLevel < 10)
 	indent := strings.Repeat(" ", nestLevel)
+	if nestLevel >= 10 {
+		return indent + " ..."
+	}
 	s := indent
 	sVl := ""
 	if e.UndefinedLength {
@@ -341,7 +550,8 @@ func (e *Element) String() string {
 
 // 读取一个Item object的元数据，w/o 读取它们进DataElement.
 // 它是用来读取 pixel data的
-func readRawItem(d *dicomio.Decoder) ([]byte, bool) {
+// maxSize如果大于0, 是item最多的bytes, 见ReadOptions.MaxElementSize
+func readRawItem(d *dicomio.Decoder, pool *FramePool, maxSize int64) ([]byte, bool) {
 
 	tag := readTag(d)
 
@@ -374,14 +584,19 @@ func readRawItem(d *dicomio.Decoder) ([]byte, bool) {
 		return nil, true
 	}
 
-	return d.ReadBytes(int(vl)), false
+	if maxSize > 0 && int64(vl) > maxSize {
+		d.SetErrorf("PixelData fragment length %d exceeds MaxElementSize %d", vl, maxSize)
+		return nil, true
+	}
+
+	return readPooledBytes(d, int(vl), pool), false
 }
 
 // 读取 basic offset table。 这是PixelData内的第一个 embedded 对象
 // P3.5 8.2 P3.5 A4 有更好的示例
 func readBasicOffsetTable(d *dicomio.Decoder) []uint32 {
 
-	data, endOfData := readRawItem(d)
+	data, endOfData := readRawItem(d, nil, 0)
 	if endOfData {
 		d.SetErrorf("basic offset table not found")
 	}
@@ -406,6 +621,7 @@ func readBasicOffsetTable(d *dicomio.Decoder) []uint32 {
 // ParseFileHeader从Dicom文件读取DICOM头和元数据(element的tag group == 2的)
 // 报错会通过d.Error()传入
 func ParseFileHeader(d *dicomio.Decoder) []*Element {
+	defer dicomio.RecoverDecoder(d)
 
 	d.PushTransferSyntax(binary.LittleEndian, dicomio.ExplicitVR)
 	defer d.PopTransferSyntax()
@@ -449,7 +665,11 @@ func ParseFileHeader(d *dicomio.Decoder) []*Element {
 			break
 		}
 		metaElems = append(metaElems, elem)
-		logrus.Infof("dicom.ParseFileHeader: Meta element: %v, pos %v", elem.String(), d.BytesRead())
+		dicomlog.V(1, "dicom.ParseFileHeader: meta element", dicomlog.Fields{
+			dicomlog.TagKey:    dicomtag.DebugString(elem.Tag),
+			dicomlog.OffsetKey: d.BytesRead(),
+			"value":            elem.String(),
+		})
 	}
 	return metaElems
 }
@@ -457,6 +677,9 @@ func ParseFileHeader(d *dicomio.Decoder) []*Element {
 // endElement 是一个伪元素来导致caller停止读取input
 var endOfDataElement = &Element{Tag: dicomtag.Tag{Group: 0x7fff, Element: 0x7fff}}
 
+// skippedElement 是一个伪元素, 表示element的value没有被解码就被跳过了
+var skippedElement = &Element{Tag: dicomtag.Tag{Group: 0x7fff, Element: 0x7ffe}}
+
 // ReadElement 读取一个DICOM data element，返回三种值.
 //
 // - 读取错误时，返回nil和d.Error()错误的集合
@@ -466,14 +689,21 @@ var endOfDataElement = &Element{Tag: dicomtag.Tag{Group: 0x7fff, Element: 0x7fff
 //
 // - 读取成功时，返回一个non-nil 和 non-endOfDataElement 值
 func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
+	defer dicomio.RecoverDecoder(d)
+	return readElement(d, options, nil)
+}
+
+// readElement 与ReadElement相同, 但如果wanted(tag)返回false且element是定义了长度的,
+// 会直接跳过value的bytes（不做字符集解码也不分配内存）并返回skippedElement.
+// wanted只作用于这一层的element, 不作用于SQ内部的element
+func readElement(d *dicomio.Decoder, options ReadOptions, wanted func(dicomtag.Tag) bool) *Element {
 
 	tag := readTag(d)
 	if tag == dicomtag.PixelData && options.DropPixelData {
 		return endOfDataElement
 	}
 
-	// 如果有StopAtTag且tag比StopAtTag大
-	if options.StopAtTag != nil && tag.Group >= options.StopAtTag.Group && tag.Element >= options.StopAtTag.Element {
+	if options.stopsAt(tag) {
 		return endOfDataElement
 	}
 
@@ -495,6 +725,25 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 		vr, vl = readExplicit(d, tag)
 	}
 
+	if wanted != nil && vl != UndefinedLength && d.Error() == nil && !wanted(tag) {
+		d.Skip(int(vl))
+		return skippedElement
+	}
+
+	if options.MaxElementSize > 0 && vl != UndefinedLength && int64(vl) > options.MaxElementSize &&
+		vr != "SQ" && tag != dicomtag.Item && d.Error() == nil {
+		if wanted == nil {
+			d.SetErrorf("dicom.ReadElement: %v: length %d exceeds MaxElementSize %d", dicomtag.DebugString(tag), vl, options.MaxElementSize)
+			return nil
+		}
+		if options.diagnose != nil {
+			options.diagnose(newDiagnostic(DiagnosticWarning, tag, d.BytesRead(),
+				"length %d exceeds MaxElementSize %d; element skipped", vl, options.MaxElementSize))
+		}
+		d.Skip(int(vl))
+		return skippedElement
+	}
+
 	var data []interface{}
 
 	elem := &Element{
@@ -511,6 +760,9 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 		// <UN, undefinedLength> == <SQ, undefinedLength>
 		vr = "SQ"
 		elem.VR = vr
+		if options.diagnose != nil {
+			options.diagnose(newDiagnostic(DiagnosticRepair, tag, d.BytesRead(), "UN element with undefined length read as a sequence"))
+		}
 	}
 
 	if tag == dicomtag.PixelData {
@@ -536,11 +788,14 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 			image.Offsets = readBasicOffsetTable(d)
 
 			if len(image.Offsets) > 1 {
-				logrus.Warnf("ReadElement: Multiple images not supported yet, Combining them into a byte sequence: %v", image.Offsets)
+				options.logger.Warn("ReadElement: multiple images not supported yet, combining them into a byte sequence", dicomlog.Fields{
+					dicomlog.OffsetKey: d.BytesRead(),
+					"offsets":          image.Offsets,
+				})
 			}
 
 			for !d.EOF() {
-				chunk, endOfItems := readRawItem(d)
+				chunk, endOfItems := readRawItem(d, options.FramePool, options.MaxElementSize)
 				if d.Error() != nil {
 					break
 				}
@@ -550,18 +805,20 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 				}
 
 				image.Frames = append(image.Frames, chunk)
+				image.addBuffer(options.FramePool, chunk)
 			}
 
 			data = append(data, image)
 		} else {
-			logrus.Warnf("ReadElement: Defined-length pixel data not supported: tag %v, VR=%v, VL=%v", tag.String(), vr, vl)
-
+			// Native pixel data. ReadElement不知道frame的大小, 所以整个value是一个frame;
+			// Parser(ReadDataSet)会按NumberOfFrames拆开, 见splitNativeFrames
 			var image PixelDataInfo
 
-			image.Frames = append(image.Frames, d.ReadBytes(int(vl)))
+			frame := readPooledBytes(d, int(vl), options.FramePool)
+			image.Frames = append(image.Frames, frame)
+			image.addBuffer(options.FramePool, frame)
 			data = append(data, image)
 		}
-		// TODO 处理多帧图片
 	} else if vr == "SQ" {
 		// Note: when reading subitems inside sequence or item, we ignore
 		// DropPixelData and other shortcircuiting options. If we honored them, we'd
@@ -573,7 +830,7 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 			//             Item Any*N                     (when Item.VL has a defined value)
 			for {
 				// Makes sure to return all sub elements even if the tag is not in the return tags list of options or is greater than the Stop At Tag
-				item := ReadElement(d, ReadOptions{})
+				item := ReadElement(d, ReadOptions{MaxElementSize: options.MaxElementSize})
 				if d.Error() != nil {
 					break
 				}
@@ -593,7 +850,7 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 			d.PushLimit(int64(vl))
 			for !d.EOF() {
 				// Makes sure to return all sub elements even if the tag is not in the return tags list of options or is greater than the Stop At Tag
-				item := ReadElement(d, ReadOptions{})
+				item := ReadElement(d, ReadOptions{MaxElementSize: options.MaxElementSize})
 				if d.Error() != nil {
 					break
 				}
@@ -610,7 +867,7 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 			// Format: Item Any* ItemDelimitationItem
 			for {
 				// Makes sure to return all sub elements even if the tag is not in the return tags list of options or is greater than the Stop At Tag
-				subelem := ReadElement(d, ReadOptions{})
+				subelem := ReadElement(d, ReadOptions{MaxElementSize: options.MaxElementSize})
 				if d.Error() != nil {
 					break
 				}
@@ -624,7 +881,7 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 			d.PushLimit(int64(vl))
 			for !d.EOF() {
 				// Makes sure to return all sub elements even if the tag is not in the return tags list of options or is greater than the Stop At Tag
-				subelem := ReadElement(d, ReadOptions{})
+				subelem := ReadElement(d, ReadOptions{MaxElementSize: options.MaxElementSize})
 				if d.Error() != nil {
 					break
 				}
@@ -646,32 +903,33 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 		} else if vr == "AT" {
 			// (2byte group, 2byte elem)
 			for !d.EOF() {
-				tag := dicomtag.Tag{d.ReadUInt16(), d.ReadUInt16()}
+				tag := dicomtag.Tag{Group: d.ReadUInt16(), Element: d.ReadUInt16()}
 				data = append(data, tag)
 			}
 		} else if vr == "OW" {
 			if vl%2 != 0 {
 				d.SetErrorf("dicom.ReadElement: tag %v: OW requires even length, but found %v", dicomtag.DebugString(tag), vl)
 			} else {
-				n := int(vl / 2)
-				e := dicomio.NewBytesEncoder(dicomio.NativeByteOrder, dicomio.UnknownVR)
-				for i := 0; i < n; i++ {
-					v := d.ReadUInt16()
-					e.WriteUInt16(v)
+				// 一次读出整个value, 再转换成native byte order. ReadBytes在输入不够时返回错误,
+				// 不会为损坏的VL分配几个GB
+				value := d.ReadBytes(int(vl))
+				if byteOrder, _ := d.TransferSyntax(); byteOrder != dicomio.NativeByteOrder {
+					for i := 0; i+1 < len(value); i += 2 {
+						value[i], value[i+1] = value[i+1], value[i]
+					}
 				}
-				dicomio.DoAssert(e.Error() == nil, e.Error())
-				// TODO Check that size is even. Byte swap??
-				// TODO If OB's length is odd, is VL odd too? Need to check!
-				data = append(data, e.Bytes())
+				data = append(data, value)
 			}
-		} else if vr == "OB" {
+		} else if vr == "OB" || vr == "OV" || vr == "UN" {
+			// OV (例如ExtendedOffsetTable) 保持little endian的bytes.
+			// UN的value保持原样, 这样才能原封不动地写回去(不管输出是implicit还是explicit VR)
 			// TODO Check that size is even. Byte swap??
 			// TODO If OB's length is odd, is VL odd too? Need to check!
 			data = append(data, d.ReadBytes(int(vl)))
 		} else if vr == "LT" || vr == "UT" {
 			str := d.ReadString(int(vl))
 			data = append(data, str)
-		} else if vr == "UL" {
+		} else if vr == "UL" || vr == "OL" {
 			for !d.EOF() {
 				data = append(data, d.ReadUInt32())
 			}
@@ -679,6 +937,14 @@ func ReadElement(d *dicomio.Decoder, options ReadOptions) *Element {
 			for !d.EOF() {
 				data = append(data, d.ReadInt32())
 			}
+		} else if vr == "UV" {
+			for !d.EOF() {
+				data = append(data, d.ReadUInt64())
+			}
+		} else if vr == "SV" {
+			for !d.EOF() {
+				data = append(data, d.ReadInt64())
+			}
 		} else if vr == "US" {
 			for !d.EOF() {
 				data = append(data, d.ReadUInt16())
@@ -717,7 +983,7 @@ func readTag(buffer *dicomio.Decoder) dicomtag.Tag {
 
 	element := buffer.ReadUInt16()
 
-	return dicomtag.Tag{group, element}
+	return dicomtag.Tag{Group: group, Element: element}
 }
 
 // 从DICOM字典中读取VR，VL是32比特无符号数字
@@ -737,6 +1003,46 @@ func readImplicit(buffer *dicomio.Decoder, tag dicomtag.Tag) (string, uint32) {
 	return vr, vl
 }
 
+// usOrSSTags 是VR为"US or SS"的tag. 字典里它们的VR是US, 真正的VR取决于
+// PixelRepresentation (0028,0103): 0是US, 1是SS. P3.5 A.1(c), P3.6 6
+var usOrSSTags = map[dicomtag.Tag]bool{
+	dicomtag.SmallestImagePixelValue:        true,
+	dicomtag.LargestImagePixelValue:         true,
+	dicomtag.SmallestPixelValueInSeries:     true,
+	dicomtag.LargestPixelValueInSeries:      true,
+	{Group: 0x0028, Element: 0x0110}:        true, // SmallestImagePixelValueInPlane (retired)
+	{Group: 0x0028, Element: 0x0111}:        true, // LargestImagePixelValueInPlane (retired)
+	dicomtag.PixelPaddingValue:              true,
+	dicomtag.PixelPaddingRangeLimit:         true,
+	dicomtag.RealWorldValueLastValueMapped:  true,
+	dicomtag.RealWorldValueFirstValueMapped: true,
+	dicomtag.HistogramFirstBinValue:         true,
+	dicomtag.HistogramLastBinValue:          true,
+}
+
+// resolveUSOrSS 在PixelRepresentation为1(有符号)时, 把用implicit VR读成US的
+// "US or SS" element转换成SS, 否则-1000这样的padding value会被读成64536.
+// SQ里的element也会被转换
+func resolveUSOrSS(elem *Element) {
+	if elem.VR == "SQ" || elem.Tag == dicomtag.Item {
+		for _, value := range elem.Value {
+			if child, ok := value.(*Element); ok {
+				resolveUSOrSS(child)
+			}
+		}
+		return
+	}
+	if elem.VR != "US" || !usOrSSTags[elem.Tag] {
+		return
+	}
+	for i, value := range elem.Value {
+		if v, ok := value.(uint16); ok {
+			elem.Value[i] = int16(v)
+		}
+	}
+	elem.VR = "SS"
+}
+
 // VR由下两个连续的bytes代表
 // VL根据VR的值
 // PS3.5 7.1.2
@@ -748,7 +1054,7 @@ func readExplicit(buffer *dicomio.Decoder, tag dicomtag.Tag) (string, uint32) {
 	switch vr {
 	// TODO 下列情况与 PS3.5的7.1.1有区别
 	// (http://dicom.nema.org/Dicom/2013/output/chtml/part05/chapter_7.html#table_7.1-1).
-	case "NA", "OB", "OD", "OF", "OL", "OW", "SQ", "UN", "UC", "UR", "UT":
+	case "NA", "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UN", "UC", "UR", "UT", "UV":
 		buffer.Skip(2) // 忽略两个bytes，给未来用(0000H)
 		vl = buffer.ReadUInt32()
 		if vl == UndefinedLength && (vr == "UC" || vr == "UR" || vr == "VI") {
@@ -772,74 +1078,116 @@ func readExplicit(buffer *dicomio.Decoder, tag dicomtag.Tag) (string, uint32) {
 }
 
 // ReadDataSet用io读取dicom file
-// 当读取错误时，这个函数可能会返回部分可读取文件和读取时发现的第一个错误
-func ReadDataSet(in io.Reader, options ReadOptions) (*DataSet, error) {
-
-	buffer := dicomio.NewDecoder(in, binary.LittleEndian, dicomio.ExplicitVR)
-
-	metaElements := ParseFileHeader(buffer)
-
-	if buffer.Error() != nil {
-		return nil, buffer.Error()
-	}
-
-	file := &DataSet{Elements: metaElements}
-
-	// 改变剩余文件的 transfer syntax
-	endian, implicit, err := getTransferSyntax(file)
+// 当读取错误时，这个函数可能会返回部分可读取文件和读取时发现的第一个错误.
+// 不是按tag顺序出现的top-level elements (例如不符合标准的文件中PixelData之后的element) 会被
+// 按tag排序, 重复的tag按options.Duplicates处理
+func ReadDataSet(in io.Reader, options ReadOptions) (ds *DataSet, err error) {
+	defer dicomio.Recover(&err)
+	p, err := NewParser(in, options)
 	if err != nil {
 		return nil, err
 	}
 
-	buffer.PushTransferSyntax(endian, implicit)
-	defer buffer.PopTransferSyntax()
-
-	// 读取elements数组
-	for !buffer.EOF() {
-		startLen := buffer.BytesRead()
-
-		elem := ReadElement(buffer, options)
-
-		if buffer.BytesRead() <= startLen { // 避免无限循环
-			panic(fmt.Sprintf("ReadElement 读取data失败：position：%d: %v", startLen, buffer.Error()))
+	file := &DataSet{}
+	for {
+		elem, err := p.Next()
+		if err != nil {
+			file.TrailingData = p.TrailingData()
+			var dupErr error
+			file.Elements, dupErr = p.normalizeElementOrder(file.Elements)
+			file.diagnostics = p.diagnostics
+			if err == io.EOF {
+				err = dupErr
+			}
+			if err == nil && options.CheckFrameCount {
+				err = file.CheckFrameCount()
+			}
+			return file, err
 		}
+		file.Elements = append(file.Elements, elem)
+	}
+}
 
-		if elem == endOfDataElement {
-			// element 是一个被options丢弃的pixel data
-			break
+// stopsAt 检查读取是否应该在tag处停止, 见StopAtTag和StopAfterGroup
+func (options *ReadOptions) stopsAt(tag dicomtag.Tag) bool {
+	if options.StopAtTag != nil {
+		c := tag.Compare(*options.StopAtTag)
+		if c > 0 || c == 0 && !options.StopAtTagInclusive {
+			return true
 		}
+	}
+	return options.StopAfterGroup != nil && tag.Group > *options.StopAfterGroup
+}
 
-		if elem == nil {
-			// 读取错误
-			continue
-		}
+// wantsTag 检查tag是否应该被ReadDataSet返回
+func (options *ReadOptions) wantsTag(tag dicomtag.Tag) bool {
+	if options.ReturnTags != nil && !tagInList(tag, options.ReturnTags) {
+		return false
+	}
+	if tagInList(tag, options.SkipTags) {
+		return false
+	}
+	if options.SkipPrivateTags && dicomtag.IsPrivate(tag.Group) {
+		return false
+	}
+	if options.ElementFilter != nil && !options.ElementFilter(tag) {
+		return false
+	}
+	return true
+}
 
-		if elem.Tag == dicomtag.SpecificCharacterSet {
-			// 将剩余文件设为[]byte -> string decoder
-			// It's sad that SpecificCharacterSet isn't part
-			// of metadata, but is part of regular attrs, so we need
-			// to watch out for multiple occurrences of this type of
-			// elements.
-			encodingNames, err := elem.GetStrings()
-			if err != nil {
-				buffer.SetError(err)
-			} else {
-				// SpecificCharacterSet 也许会出现在一个SQ/NA中，在这种情况下,
-				// 这个charset是被固定在SQ/NA内，所以我们需要一个stack来记录？这些charset
-				cs, err := dicomio.ParseSpecificCharacterSet(encodingNames)
-				if err != nil {
-					buffer.SetError(err)
-				} else {
-					buffer.SetCodingSystem(cs)
-				}
+// isPlausibleElementHeader 检查decoder接下来的bytes是否像一个top-level element的header:
+// group至少是0x0008, explicit VR的VR是合法的VR, VL是偶数, 而且不超过剩下的输入.
+// remaining是剩下的bytes, 不知道时是-1; 这时implicit VR的tag必须在字典里或者像一个
+// private tag. 不检查tag的顺序: 顺序不对或者重复的element由normalizeElementOrder和
+// ReadOptions.Duplicates处理
+func isPlausibleElementHeader(d *dicomio.Decoder, remaining int64) bool {
+	header := d.Peek(8)
+	if len(header) < 8 {
+		return false
+	}
+	byteOrder, implicit := d.TransferSyntax()
+	tag := dicomtag.Tag{Group: byteOrder.Uint16(header[0:]), Element: byteOrder.Uint16(header[2:])}
+	// 文件里的data set不会有group 0-7的element, 也不会有top-level的item
+	if tag.Group < 0x0008 || tag.Group == ItemSeqGroup {
+		return false
+	}
+	headerLen, vl := int64(8), byteOrder.Uint32(header[4:])
+	if implicit == dicomio.ExplicitVR {
+		vr := string(header[4:6])
+		if !salvageVRs[vr] {
+			return false
+		}
+		switch vr {
+		case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UN", "UC", "UR", "UT", "UV":
+			if header = d.Peek(12); len(header) < 12 {
+				return false
+			}
+			headerLen, vl = 12, byteOrder.Uint32(header[8:])
+		default:
+			vl = uint32(byteOrder.Uint16(header[6:]))
+			if vl == 0xffff {
+				vl = UndefinedLength
 			}
 		}
-
-		if options.ReturnTags == nil || (options.ReturnTags != nil && tagInList(elem.Tag, options.ReturnTags)) {
-			file.Elements = append(file.Elements, elem)
+	} else if remaining < 0 {
+		if _, err := dicomtag.Find(tag); err != nil && !plausiblePrivateTag(tag, vl) {
+			return false
 		}
 	}
-	return file, buffer.Error()
+	if vl == UndefinedLength {
+		return true
+	}
+	return vl%2 == 0 && (remaining < 0 || headerLen+int64(vl) <= remaining)
+}
+
+// ReadDataSetWithContext is ReadDataSet, but stops reading when "ctx" is
+// canceled, between top-level elements, e.g., to abort parsing a large
+// multi-frame file when the deadline of a request expires. It then returns
+// the elements read so far and ctx.Err().
+func ReadDataSetWithContext(ctx context.Context, in io.Reader, options ReadOptions) (*DataSet, error) {
+	options.ctx = ctx
+	return ReadDataSet(in, options)
 }
 
 func ReadDataSetInBytes(data []byte, options ReadOptions) (*DataSet, error) {
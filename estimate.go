@@ -0,0 +1,224 @@
+package dicom
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// compressionRatios 是压缩后的pixel data相对于native pixel data的粗略经验值.
+// 实际的比例取决于图像内容和编码参数, 所以只能用来估算
+var compressionRatios = map[string]float64{
+	"1.2.840.10008.1.2.4.50": 0.10, // JPEG Baseline
+	"1.2.840.10008.1.2.4.51": 0.10, // JPEG Extended
+	"1.2.840.10008.1.2.4.57": 0.50, // JPEG Lossless
+	"1.2.840.10008.1.2.4.70": 0.50, // JPEG Lossless SV1
+	"1.2.840.10008.1.2.4.80": 0.40, // JPEG-LS Lossless
+	"1.2.840.10008.1.2.4.81": 0.20, // JPEG-LS Near-Lossless
+	"1.2.840.10008.1.2.4.90": 0.40, // JPEG 2000 Lossless
+	"1.2.840.10008.1.2.4.91": 0.10, // JPEG 2000
+	"1.2.840.10008.1.2.5":    0.65, // RLE Lossless
+}
+
+// defaultCompressionRatio 用于compressionRatios里没有的encapsulated transfer syntax
+const defaultCompressionRatio = 0.5
+
+// deflateRatio 是Deflated Explicit VR Little Endian压缩后的dataset(不包括file meta)的典型大小
+const deflateRatio = 0.6
+
+// byteCounter 是只计算写入了多少bytes的io.Writer
+type byteCounter int64
+
+func (c *byteCounter) Write(data []byte) (int, error) {
+	*c += byteCounter(len(data))
+	return len(data), nil
+}
+
+// encodedSize 返回把elems写成DICOM文件后的大小
+func encodedSize(elems []*Element) (int64, error) {
+	var n byteCounter
+	if err := WriteDataSet(&n, &DataSet{Elements: elems}); err != nil {
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+// EstimateTranscodedSize estimates the size in bytes of the DICOM file that
+// "ds" would become if transcoded to "targetSyntax".
+//
+// The size is exact if neither the source nor the target transfer syntax
+// compresses pixel data, or if they are the same. Otherwise the elements
+// other than PixelData are still encoded exactly, but the size of PixelData
+// is estimated from the native size (Rows*Columns*SamplesPerPixel*
+// BitsAllocated/8*NumberOfFrames) and a typical compression ratio of the
+// target codec. ds is not modified.
+func EstimateTranscodedSize(ds *DataSet, targetSyntax string) (int64, error) {
+	entry, err := dicomuid.Lookup(targetSyntax)
+	if err != nil {
+		return 0, err
+	}
+	if entry.Type != dicomuid.TypeTransferSyntax {
+		return 0, fmt.Errorf("dicom.EstimateTranscodedSize: '%s' is not a transfer syntax (is %s)", targetSyntax, entry.Type)
+	}
+	elem, err := ds.FindElementByTag(dicomtag.TransferSyntaxUID)
+	if err != nil {
+		return 0, err
+	}
+	sourceSyntax, err := elem.GetString()
+	if err != nil {
+		return 0, err
+	}
+	deflate := targetSyntax == dicomuid.DeflatedExplicitVRLittleEndian
+	if sourceSyntax == targetSyntax && !deflate {
+		return encodedSize(ds.Elements)
+	}
+
+	target := &DataSet{Elements: append([]*Element(nil), ds.Elements...)}
+	target.setElement(MustNewElement(dicomtag.TransferSyntaxUID, targetSyntax))
+	pixelData, err := target.FindElementByTag(dicomtag.PixelData)
+	if err != nil {
+		// 没有pixel data, 只有deflate会改变大小
+		size, err := encodedSize(target.Elements)
+		if err != nil || !deflate {
+			return size, err
+		}
+		return deflateSize(target, size)
+	}
+	if !pixelData.UndefinedLength && !isEncapsulatedSyntax(targetSyntax) && !deflate {
+		return encodedSize(target.Elements)
+	}
+
+	var nonPixel []*Element
+	for _, elem := range target.Elements {
+		if elem.Tag != dicomtag.PixelData {
+			nonPixel = append(nonPixel, elem)
+		}
+	}
+	size, err := encodedSize(nonPixel)
+	if err != nil {
+		return 0, err
+	}
+	frameSize, numFrames, err := nativeFrameSize(ds, pixelData)
+	if err != nil {
+		return 0, err
+	}
+	// Element header: tag(4) + VR(2) + reserved(2) + VL(4)
+	const headerSize = 12
+	if !isEncapsulatedSyntax(targetSyntax) {
+		size += headerSize + evenSize(frameSize*numFrames)
+		if deflate {
+			return deflateSize(target, size)
+		}
+		return size, nil
+	}
+	ratio, ok := compressionRatios[targetSyntax]
+	if !ok {
+		ratio = defaultCompressionRatio
+	}
+	// Encapsulated: header, basic offset table item, 每个frame一个item, sequence delimiter. P3.5 A.4
+	const itemHeaderSize = 8
+	size += headerSize + itemHeaderSize + 4*numFrames
+	size += numFrames * (itemHeaderSize + evenSize(int64(float64(frameSize)*ratio)))
+	size += itemHeaderSize
+	return size, nil
+}
+
+// isEncapsulatedSyntax 检查transfer syntax是不是用encapsulated(压缩的)pixel data.
+// 除了几个native transfer syntax, 其余的transfer syntax都是encapsulated
+func isEncapsulatedSyntax(uid string) bool {
+	switch uid {
+	case dicomuid.ImplicitVRLittleEndian, dicomuid.ExplicitVRLittleEndian,
+		dicomuid.ExplicitVRBigEndian, dicomuid.DeflatedExplicitVRLittleEndian:
+		return false
+	}
+	return true
+}
+
+// deflateSize 根据未压缩的大小估算deflate后的大小. file meta不压缩
+func deflateSize(ds *DataSet, size int64) (int64, error) {
+	var meta []*Element
+	for _, elem := range ds.Elements {
+		if elem.Tag.Group == dicomtag.MetadataGroup {
+			meta = append(meta, elem)
+		}
+	}
+	metaSize, err := encodedSize(meta)
+	if err != nil {
+		return 0, err
+	}
+	return metaSize + evenSize(int64(float64(size-metaSize)*deflateRatio)), nil
+}
+
+// nativeFrameSize 返回一个frame在native格式下的大小和frame的个数
+func nativeFrameSize(ds *DataSet, pixelData *Element) (frameSize, numFrames int64, err error) {
+	var rows, columns, bitsAllocated, samplesPerPixel int64
+	for _, v := range []struct {
+		tag          dicomtag.Tag
+		value        *int64
+		defaultValue int64
+	}{
+		{dicomtag.Rows, &rows, 0},
+		{dicomtag.Columns, &columns, 0},
+		{dicomtag.BitsAllocated, &bitsAllocated, 0},
+		{dicomtag.SamplesPerPixel, &samplesPerPixel, 1},
+		{dicomtag.NumberOfFrames, &numFrames, 1},
+	} {
+		if *v.value, err = intValue(ds, v.tag, v.defaultValue); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(pixelData.Value) > 0 {
+		if image, ok := pixelData.Value[0].(PixelDataInfo); ok && !pixelData.UndefinedLength && len(image.Frames) > 1 {
+			// 多个native frame, 见WriteDataSetToBytes
+			numFrames = int64(len(image.Frames))
+		}
+	}
+	if rows == 0 || columns == 0 || bitsAllocated == 0 {
+		return 0, 0, fmt.Errorf("dicom.EstimateTranscodedSize: PixelData requires Rows, Columns and BitsAllocated")
+	}
+	// 损坏的文件中这些值可能是负数或者很大, 乘积不能溢出
+	bits := int64(1)
+	for _, n := range []int64{rows, columns, samplesPerPixel, bitsAllocated} {
+		if n <= 0 || bits > math.MaxInt64/n {
+			return 0, 0, fmt.Errorf("dicom.EstimateTranscodedSize: invalid frame size: Rows %d, Columns %d, SamplesPerPixel %d, BitsAllocated %d",
+				rows, columns, samplesPerPixel, bitsAllocated)
+		}
+		bits *= n
+	}
+	frameSize = bits / 8
+	if bits%8 != 0 {
+		frameSize++
+	}
+	if numFrames <= 0 || frameSize > math.MaxInt64/numFrames {
+		return 0, 0, fmt.Errorf("dicom.EstimateTranscodedSize: invalid NumberOfFrames %d for frames of %d bytes", numFrames, frameSize)
+	}
+	return frameSize, numFrames, nil
+}
+
+// intValue 返回US或IS element的值. element不存在或者没有值时返回defaultValue
+func intValue(ds *DataSet, tag dicomtag.Tag, defaultValue int64) (int64, error) {
+	elem, err := ds.FindElementByTag(tag)
+	if err != nil || len(elem.Value) == 0 {
+		return defaultValue, nil
+	}
+	switch v := elem.Value[0].(type) {
+	case uint16:
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%v: %v", dicomtag.DebugString(tag), err)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("%v: unexpected value %v", dicomtag.DebugString(tag), elem.Value[0])
+}
+
+// evenSize 返回补齐为偶数后的大小
+func evenSize(n int64) int64 {
+	return n + n%2
+}
@@ -0,0 +1,59 @@
+package dicom_test
+
+import (
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateTranscodedSize(t *testing.T) {
+	spec := dicomtest.Spec{Rows: 16, Columns: 16, BitsAllocated: 16, NumberOfFrames: 3}
+	explicit := dicomtest.MustBytes(spec)
+	ds, err := dicom.ReadDataSetInBytes(explicit, dicom.ReadOptions{})
+	require.NoError(t, err)
+
+	size, err := dicom.EstimateTranscodedSize(ds, dicomuid.ExplicitVRLittleEndian)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(explicit)), size)
+
+	spec.TransferSyntaxUID = dicomuid.ImplicitVRLittleEndian
+	size, err = dicom.EstimateTranscodedSize(ds, dicomuid.ImplicitVRLittleEndian)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(dicomtest.MustBytes(spec))), size)
+
+	// Decompressing gives the native size exactly.
+	spec.TransferSyntaxUID = dicomtest.JPEG2000
+	jpeg, err := dicom.ReadDataSetInBytes(dicomtest.MustBytes(spec), dicom.ReadOptions{})
+	require.NoError(t, err)
+	size, err = dicom.EstimateTranscodedSize(jpeg, dicomuid.ExplicitVRLittleEndian)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(explicit)), size)
+
+	nativeSize := int64(16 * 16 * 2 * 3)
+	lossless, err := dicom.EstimateTranscodedSize(ds, dicomtest.JPEGLossless)
+	require.NoError(t, err)
+	lossy, err := dicom.EstimateTranscodedSize(ds, dicomtest.JPEGBaseline)
+	require.NoError(t, err)
+	assert.True(t, lossy < lossless, "%d < %d", lossy, lossless)
+	assert.True(t, lossless < int64(len(explicit)), "%d < %d", lossless, len(explicit))
+	assert.True(t, lossy > int64(len(explicit))-nativeSize, "%d > %d", lossy, int64(len(explicit))-nativeSize)
+
+	deflated, err := dicom.EstimateTranscodedSize(ds, dicomuid.DeflatedExplicitVRLittleEndian)
+	require.NoError(t, err)
+	assert.True(t, deflated < int64(len(explicit)), "%d < %d", deflated, len(explicit))
+
+	_, err = dicom.EstimateTranscodedSize(ds, dicomuid.VerificationSOPClass)
+	assert.Error(t, err)
+
+	// A frame size that overflows int64 is an error, not a panic.
+	ds, err = dicom.ReadDataSetInBytes(newFrameSizeOverflowData(), dicom.ReadOptions{})
+	require.NoError(t, err)
+	for _, uid := range []string{dicomtest.RLELossless, dicomuid.DeflatedExplicitVRLittleEndian} {
+		_, err = dicom.EstimateTranscodedSize(ds, uid)
+		assert.Error(t, err, uid)
+	}
+}
@@ -0,0 +1,96 @@
+package dicom
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// FileNameSanitizer derives file and directory names from attribute values,
+// e.g., a directory per PatientName or a file per SOPInstanceUID, that are
+// valid on Linux, macOS and Windows.
+type FileNameSanitizer struct {
+	// Replacements 指定某些字符的替换, 例如PN的'^'. 替换成""表示删除.
+	// 不在这里的不安全字符被替换成Default
+	Replacements map[rune]string
+
+	// Default 替换其他不安全的字符: 控制字符和 / \ : * ? " < > |
+	Default string
+
+	// MaxLength 是结果的最大长度(bytes, UTF-8). 0表示不限制
+	MaxLength int
+}
+
+// DefaultFileNameSanitizer replaces '^' (the PN component separator) and
+// spaces with '_', '/' with '-', other unsafe characters with '_', and limits
+// names to 255 bytes.
+var DefaultFileNameSanitizer = &FileNameSanitizer{
+	Replacements: map[rune]string{'^': "_", ' ': "_", '/': "-"},
+	Default:      "_",
+	MaxLength:    255,
+}
+
+// windowsReservedNames 在Windows上不能作为文件名(不管扩展名)
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Sanitize returns a file name derived from "value". DICOM padding
+// (trailing spaces and NULs) is removed first. Leading and trailing dots and
+// spaces, which Windows drops, are removed; "." and "..", reserved Windows
+// device names (e.g., "CON") and names that become empty get a '_' added.
+// Invalid UTF-8 bytes count as unsafe characters.
+func (s *FileNameSanitizer) Sanitize(value string) string {
+	value = strings.TrimRight(value, " \x00")
+	var b strings.Builder
+	for i, r := range value {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(value[i:]); size == 1 {
+				b.WriteString(s.Default)
+				continue
+			}
+		}
+		if replacement, ok := s.Replacements[r]; ok {
+			b.WriteString(replacement)
+		} else if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			b.WriteString(s.Default)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	name := strings.Trim(b.String(), ". ")
+	if s.MaxLength > 0 && len(name) > s.MaxLength {
+		n := s.MaxLength
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = strings.TrimRight(name[:n], ". ")
+	}
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if name == "" || windowsReservedNames[strings.ToUpper(base)] {
+		name += "_"
+	}
+	return name
+}
+
+// SanitizeElement returns a file name derived from the string values of
+// "tag" in "ds", joined with '_' if there are several. It returns an error if
+// ds has no such element or it doesn't hold strings.
+func (s *FileNameSanitizer) SanitizeElement(ds *DataSet, tag dicomtag.Tag) (string, error) {
+	elem, err := ds.FindElementByTag(tag)
+	if err != nil {
+		return "", err
+	}
+	values, err := elem.GetStrings()
+	if err != nil {
+		return "", err
+	}
+	return s.Sanitize(strings.Join(values, "_")), nil
+}
@@ -0,0 +1,115 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// CheckFrameCount checks that encapsulated PixelData holds NumberOfFrames
+// (0028,0008) frames, which some generators get wrong, e.g., cine loops
+// whose NumberOfFrames counts frames that were dropped from PixelData:
+//
+//   - a non-empty Basic Offset Table and the ExtendedOffsetTable
+//     (7FE0,0001), if present, must have one entry per frame, each at the
+//     start of a fragment, in increasing order;
+//   - ExtendedOffsetTableLengths (7FE0,0002) must have as many entries as
+//     the ExtendedOffsetTable;
+//   - without offset tables, a multi-frame image must have one fragment per
+//     frame, since the frames can't be told apart otherwise.
+//
+// Native PixelData and data sets without PixelData pass.
+func (f *DataSet) CheckFrameCount() error {
+	pixelData, err := f.FindElementByTag(dicomtag.PixelData)
+	if err != nil || !pixelData.UndefinedLength || len(pixelData.Value) != 1 {
+		return nil
+	}
+	image, ok := pixelData.Value[0].(PixelDataInfo)
+	if !ok {
+		return nil
+	}
+	numFrames, err := intValue(f, dicomtag.NumberOfFrames, 1)
+	if err != nil {
+		return fmt.Errorf("dicom.CheckFrameCount: %v", err)
+	}
+	if len(image.Frames) == 0 {
+		return fmt.Errorf("dicom.CheckFrameCount: no fragments in encapsulated PixelData, but NumberOfFrames is %d", numFrames)
+	}
+	// offset是从第一个fragment的item header开始算的, 每个item有8 bytes的header
+	starts := map[uint64]bool{}
+	var pos uint64
+	for _, fragment := range image.Frames {
+		starts[pos] = true
+		pos += 8 + uint64(len(fragment))
+	}
+
+	// 空的basic offset table被读成[]uint32{0}
+	hasOffsetTable := len(image.Offsets) > 1
+	if hasOffsetTable {
+		offsets := make([]uint64, len(image.Offsets))
+		for i, offset := range image.Offsets {
+			offsets[i] = uint64(offset)
+		}
+		if err := checkFrameOffsets("basic offset table", offsets, numFrames, starts); err != nil {
+			return err
+		}
+	}
+	if elem, err := f.FindElementByTag(dicomtag.ExtendedOffsetTable); err == nil {
+		offsets, err := uint64Values(elem)
+		if err != nil {
+			return err
+		}
+		if err := checkFrameOffsets("ExtendedOffsetTable", offsets, numFrames, starts); err != nil {
+			return err
+		}
+		if elem, err := f.FindElementByTag(dicomtag.ExtendedOffsetTableLengths); err == nil {
+			lengths, err := uint64Values(elem)
+			if err != nil {
+				return err
+			}
+			if len(lengths) != len(offsets) {
+				return fmt.Errorf("dicom.CheckFrameCount: ExtendedOffsetTableLengths has %d entries, but ExtendedOffsetTable has %d",
+					len(lengths), len(offsets))
+			}
+		}
+		hasOffsetTable = true
+	}
+	if !hasOffsetTable && numFrames > 1 && int64(len(image.Frames)) != numFrames {
+		return fmt.Errorf("dicom.CheckFrameCount: found %d fragments in encapsulated PixelData, but NumberOfFrames is %d",
+			len(image.Frames), numFrames)
+	}
+	return nil
+}
+
+// checkFrameOffsets 检查offset table有numFrames个entry, 从0开始递增, 每个都是一个fragment的起点
+func checkFrameOffsets(name string, offsets []uint64, numFrames int64, starts map[uint64]bool) error {
+	if int64(len(offsets)) != numFrames {
+		return fmt.Errorf("dicom.CheckFrameCount: %s has %d entries, but NumberOfFrames is %d", name, len(offsets), numFrames)
+	}
+	for i, offset := range offsets {
+		if (i == 0 && offset != 0) || (i > 0 && offset <= offsets[i-1]) {
+			return fmt.Errorf("dicom.CheckFrameCount: %s entry %d (%d) is out of order", name, i, offset)
+		}
+		if !starts[offset] {
+			return fmt.Errorf("dicom.CheckFrameCount: %s entry %d (%d) is not at the start of a fragment", name, i, offset)
+		}
+	}
+	return nil
+}
+
+// uint64Values 解析一个OV element (little endian, 与encapsulated transfer syntaxes相同)
+func uint64Values(elem *Element) ([]uint64, error) {
+	if len(elem.Value) == 0 {
+		return nil, nil
+	}
+	data, ok := elem.Value[0].([]byte)
+	if len(elem.Value) != 1 || !ok || len(data)%8 != 0 {
+		return nil, fmt.Errorf("dicom.CheckFrameCount: %s: value is not a multiple of 8 bytes", dicomtag.DebugString(elem.Tag))
+	}
+	values := make([]uint64, len(data)/8)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return values, nil
+}
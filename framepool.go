@@ -0,0 +1,125 @@
+package dicom
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+
+	"github.com/odincare/odicom/dicomio"
+)
+
+// FramePool recycles the buffers of pixel data frames. With
+// ReadOptions.FramePool, ReadDataSet reads PixelData into buffers from the
+// pool, and PixelDataInfo.Release returns them, so that a service that
+// reads and transcodes many large images reuses a few multi-megabyte
+// buffers instead of allocating new ones for the garbage collector.
+//
+// Buffers are kept per power-of-two capacity, so a buffer wastes at most
+// half of its size. The garbage collector may still free idle buffers, as
+// with sync.Pool. FramePool is safe for concurrent use; the zero value is
+// ready to use.
+type FramePool struct {
+	// classes[i] 保存容量至少1<<i的buffers
+	classes [64]sync.Pool
+
+	gets, hits, puts int64
+	allocated        int64
+}
+
+// FramePoolStats are counters of a FramePool since it was created.
+type FramePoolStats struct {
+	// Gets is the number of buffers requested, and Hits the number of them
+	// that were reused.
+	Gets, Hits int64
+	// Puts is the number of buffers returned.
+	Puts int64
+	// AllocatedBytes is the total capacity of the buffers allocated because
+	// none could be reused.
+	AllocatedBytes int64
+}
+
+// sizeClass 返回容量至少为size的buffer的class
+func sizeClass(size int) int {
+	if size <= 1 {
+		return 0
+	}
+	return bits.Len(uint(size - 1))
+}
+
+// Get returns a buffer of length "size". Its contents are undefined.
+func (p *FramePool) Get(size int) []byte {
+	atomic.AddInt64(&p.gets, 1)
+	class := sizeClass(size)
+	if buf, ok := p.classes[class].Get().(*[]byte); ok {
+		atomic.AddInt64(&p.hits, 1)
+		return (*buf)[:size]
+	}
+	atomic.AddInt64(&p.allocated, int64(1)<<uint(class))
+	return make([]byte, size, 1<<uint(class))
+}
+
+// Put returns "buf" to the pool. It must not be used afterwards.
+func (p *FramePool) Put(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	atomic.AddInt64(&p.puts, 1)
+	// 放进容量不超过cap(buf)的最大的class, 这样Get的buffer总是足够大
+	class := bits.Len(uint(cap(buf))) - 1
+	buf = buf[:0]
+	p.classes[class].Put(&buf)
+}
+
+// Stats returns the counters of the pool.
+func (p *FramePool) Stats() FramePoolStats {
+	return FramePoolStats{
+		Gets:           atomic.LoadInt64(&p.gets),
+		Hits:           atomic.LoadInt64(&p.hits),
+		Puts:           atomic.LoadInt64(&p.puts),
+		AllocatedBytes: atomic.LoadInt64(&p.allocated),
+	}
+}
+
+// readPooledBytes 与d.ReadBytes相同, 但pool不为nil时buffer来自pool
+func readPooledBytes(d *dicomio.Decoder, length int, pool *FramePool) []byte {
+	if pool == nil {
+		return d.ReadBytes(length)
+	}
+	buf := d.ReadBytesAlloc(length, pool.Get)
+	if buf != nil && d.Error() != nil {
+		pool.Put(buf)
+		return nil
+	}
+	return buf
+}
+
+// addBuffer 记录来自pool的buffer, 以便Release
+func (p *PixelDataInfo) addBuffer(pool *FramePool, buf []byte) {
+	if pool != nil && buf != nil {
+		p.pool = pool
+		p.buffers = append(p.buffers, buf)
+	}
+}
+
+// Size returns the number of bytes of the frames, e.g., to account for the
+// memory that a DataSet holds.
+func (p PixelDataInfo) Size() int64 {
+	var n int64
+	for _, frame := range p.Frames {
+		n += int64(len(frame))
+	}
+	return n
+}
+
+// Release returns the frame buffers to the FramePool that ReadDataSet read
+// them into, see ReadOptions.FramePool. Afterwards neither the frames nor
+// other copies of this PixelDataInfo may be used. Without a pool it does
+// nothing, and the garbage collector frees the frames as usual.
+func (p PixelDataInfo) Release() {
+	if p.pool == nil {
+		return
+	}
+	for _, buf := range p.buffers {
+		p.pool.Put(buf)
+	}
+}
@@ -7,4 +7,5 @@ require (
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.2.2
 	golang.org/x/text v0.3.6
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,172 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// IterateHeaders walks the element headers of the DICOM file "r", seeking
+// past the values without reading them, and calls fn for each element: its
+// tag, VR, value length (UndefinedLength for sequences and encapsulated
+// PixelData without a length) and the offset of its tag in the file. It is
+// meant for index builders that need the tag, offset and length of every
+// element of large files, at the speed of reading the headers.
+//
+// The file meta elements are included. Elements in the items of sequences
+// are also reported, after their sequence; items, delimiters and the
+// fragments of encapsulated PixelData are not. The VR of an implicit VR
+// element is the dictionary's, or "UN". IterateHeaders stops without an
+// error when fn returns false. Deflated files can't be walked, since their
+// data set can't be seeked.
+func IterateHeaders(r io.ReadSeeker, fn func(tag dicomtag.Tag, vr string, vl uint32, offset int64) bool) error {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("dicom.IterateHeaders: %v", err)
+	}
+	// Seek可以超过文件的结尾, 所以value的结尾要和size比较
+	size, err := r.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = r.Seek(start, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("dicom.IterateHeaders: %v", err)
+	}
+	d := dicomio.NewDecoder(r, binary.LittleEndian, dicomio.ExplicitVR)
+	metaElems := ParseFileHeader(d)
+	if d.Error() != nil {
+		return fmt.Errorf("dicom.IterateHeaders: %v", d.Error())
+	}
+	meta := &DataSet{Elements: metaElems}
+	byteOrder, implicit, err := getTransferSyntax(meta)
+	if err != nil {
+		return fmt.Errorf("dicom.IterateHeaders: %v", err)
+	}
+	if uid, _ := meta.transferSyntaxUID(); uid == dicomuid.DeflatedExplicitVRLittleEndian {
+		return fmt.Errorf("dicom.IterateHeaders: deflated transfer syntax is not supported")
+	}
+
+	w := headerWalker{r: r, size: size, fn: fn}
+	// 重新读meta elements的headers, 文件的开头是128 bytes前言和"DICM"
+	if err := w.walk(start, start+132, d.BytesRead()+start, binary.LittleEndian, dicomio.ExplicitVR); err != nil || w.stopped {
+		return err
+	}
+	return w.walk(start, d.BytesRead()+start, -1, byteOrder, implicit)
+}
+
+// headerWalker 读element headers, 跳过values
+type headerWalker struct {
+	r       io.ReadSeeker
+	size    int64
+	fn      func(tag dicomtag.Tag, vr string, vl uint32, offset int64) bool
+	stopped bool
+}
+
+// walk 从pos开始读headers直到end (-1表示文件结束). offset相对于start
+func (w *headerWalker) walk(start, pos, end int64, byteOrder binary.ByteOrder, implicit dicomio.IsImplicitVR) error {
+	if _, err := w.r.Seek(pos, io.SeekStart); err != nil {
+		return fmt.Errorf("dicom.IterateHeaders: %v", err)
+	}
+	// 每个header最多12 bytes
+	buf := make([]byte, 12)
+	for end < 0 || pos < end {
+		n, err := io.ReadFull(w.r, buf)
+		if n == 0 && err == io.EOF && end < 0 {
+			return nil
+		}
+		if n < 8 {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("dicom.IterateHeaders: offset %d: %v", pos-start, err)
+		}
+		tag, vr, vl, headerLen, err := readHeader(buf[:n], byteOrder, implicit)
+		if err != nil {
+			return fmt.Errorf("dicom.IterateHeaders: offset %d: %v", pos-start, err)
+		}
+		valuePos := pos + headerLen
+
+		switch {
+		case tag == dicomtag.Item || tag == dicomtag.ItemDelimitationItem || tag == dicomtag.SequenceDelimitationItem:
+			// 进入item, 或者item/sequence结束: 继续读下一个header
+			pos = valuePos
+		case tag == dicomtag.PixelData && vl == UndefinedLength:
+			if !w.fn(tag, vr, vl, pos-start) {
+				w.stopped = true
+				return nil
+			}
+			if pos, err = w.skipFragments(valuePos, byteOrder); err != nil {
+				return fmt.Errorf("dicom.IterateHeaders: offset %d: %v", valuePos-start, err)
+			}
+		default:
+			if !w.fn(tag, vr, vl, pos-start) {
+				w.stopped = true
+				return nil
+			}
+			pos = valuePos
+			// SQ和undefined length的UN的items在后面, 否则跳过value
+			if vr != "SQ" && vl != UndefinedLength {
+				pos += int64(vl)
+			}
+			if pos > w.size {
+				return fmt.Errorf("dicom.IterateHeaders: %v: value ends at %d, after the end of the file",
+					dicomtag.DebugString(tag), pos-start)
+			}
+		}
+		if _, err := w.r.Seek(pos, io.SeekStart); err != nil {
+			return fmt.Errorf("dicom.IterateHeaders: %v", err)
+		}
+	}
+	return nil
+}
+
+// skipFragments 跳过encapsulated PixelData的items, 返回SequenceDelimitationItem之后的位置
+func (w *headerWalker) skipFragments(pos int64, byteOrder binary.ByteOrder) (int64, error) {
+	buf := make([]byte, 8)
+	for {
+		if _, err := w.r.Seek(pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(w.r, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		tag, _, vl, headerLen, err := readHeader(buf, byteOrder, dicomio.ImplicitVR)
+		if err != nil {
+			return 0, err
+		}
+		pos += headerLen
+		switch tag {
+		case dicomtag.SequenceDelimitationItem:
+			return pos, nil
+		case dicomtag.Item:
+			pos += int64(vl)
+		default:
+			return 0, fmt.Errorf("found %v in encapsulated PixelData, expected an item", dicomtag.DebugString(tag))
+		}
+	}
+}
+
+// readHeader 解码data开头的element header, 返回header的长度
+func readHeader(data []byte, byteOrder binary.ByteOrder, implicit dicomio.IsImplicitVR) (dicomtag.Tag, string, uint32, int64, error) {
+	d := dicomio.NewBytesDecoder(data, byteOrder, implicit)
+	tag := readTag(d)
+	var vr string
+	var vl uint32
+	if tag.Group == ItemSeqGroup || implicit == dicomio.ImplicitVR {
+		// Items和delimiters总是implicit VR
+		vr, vl = readImplicit(d, tag)
+		if tag.Group == ItemSeqGroup {
+			vr = "NA"
+		}
+	} else {
+		vr, vl = readExplicit(d, tag)
+	}
+	return tag, vr, vl, d.BytesRead(), d.Error()
+}
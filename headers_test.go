@@ -0,0 +1,95 @@
+package dicom_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// elementTags 返回elems和它们的SQ items里的tags, 按文件中的顺序
+func elementTags(elems []*dicom.Element) []dicomtag.Tag {
+	var tags []dicomtag.Tag
+	for _, elem := range elems {
+		tags = append(tags, elem.Tag)
+		if elem.VR != "SQ" {
+			continue
+		}
+		for _, v := range elem.Value {
+			item := v.(*dicom.Element)
+			var children []*dicom.Element
+			for _, c := range item.Value {
+				children = append(children, c.(*dicom.Element))
+			}
+			tags = append(tags, elementTags(children)...)
+		}
+	}
+	return tags
+}
+
+func TestIterateHeaders(t *testing.T) {
+	for _, uid := range []string{
+		dicomuid.ImplicitVRLittleEndian,
+		dicomuid.ExplicitVRLittleEndian,
+		dicomuid.ExplicitVRBigEndian,
+		dicomtest.JPEGBaseline,
+	} {
+		ds, err := dicomtest.NewDataSet(dicomtest.Spec{TransferSyntaxUID: uid})
+		require.NoError(t, err)
+		ds.Put(dicom.MustNewSequence(dicomtag.ReferencedImageSequence, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.ReferencedSOPClassUID, dicomuid.CTImageStorage),
+			dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, "1.2.3.4"),
+		}))
+		data := mustWriteDataSet(ds)
+		ds, err = dicom.ReadDataSetInBytes(data, dicom.ReadOptions{})
+		require.NoError(t, err)
+
+		var tags []dicomtag.Tag
+		err = dicom.IterateHeaders(bytes.NewReader(data), func(tag dicomtag.Tag, vr string, vl uint32, offset int64) bool {
+			tags = append(tags, tag)
+			// offset是tag在文件中的位置
+			var byteOrder binary.ByteOrder = binary.LittleEndian
+			if uid == dicomuid.ExplicitVRBigEndian && tag.Group != dicomtag.MetadataGroup {
+				byteOrder = binary.BigEndian
+			}
+			assert.Equal(t, tag, dicomtag.Tag{
+				Group:   byteOrder.Uint16(data[offset:]),
+				Element: byteOrder.Uint16(data[offset+2:])}, uid)
+			switch tag {
+			case dicomtag.ReferencedImageSequence:
+				assert.Equal(t, "SQ", vr, uid)
+			case dicomtag.PixelData:
+				if uid == dicomtest.JPEGBaseline {
+					assert.Equal(t, dicom.UndefinedLength, vl)
+				} else {
+					assert.Equal(t, uint32(16), vl, uid)
+				}
+			case dicomtag.PatientName:
+				assert.Equal(t, "PN", vr, uid)
+			}
+			return true
+		})
+		require.NoError(t, err, uid)
+		assert.Equal(t, elementTags(ds.Elements), tags, uid)
+	}
+
+	data := mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian))
+	var last dicomtag.Tag
+	require.NoError(t, dicom.IterateHeaders(bytes.NewReader(data), func(tag dicomtag.Tag, vr string, vl uint32, offset int64) bool {
+		last = tag
+		return tag != dicomtag.TransferSyntaxUID
+	}))
+	assert.Equal(t, dicomtag.TransferSyntaxUID, last, "stops after TransferSyntaxUID")
+
+	err := dicom.IterateHeaders(bytes.NewReader(data[:len(data)-3]), func(dicomtag.Tag, string, uint32, int64) bool { return true })
+	assert.Error(t, err)
+	err = dicom.IterateHeaders(bytes.NewReader(mustWriteDataSet(newTestDataSet(dicomuid.DeflatedExplicitVRLittleEndian))),
+		func(dicomtag.Tag, string, uint32, int64) bool { return true })
+	assert.Error(t, err)
+}
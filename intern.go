@@ -0,0 +1,59 @@
+package dicom
+
+import (
+	"sync"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// maxInternedLength 是被intern的string的最大长度. UI, LO最长64个字符;
+// 更长的value(LT, UT等)很少重复
+const maxInternedLength = 64
+
+// maxInternedStrings 限制intern table的大小. 满了之后table被清空, 重新开始.
+// 这样读取再多的文件, 内存也是有限的
+const maxInternedStrings = 1 << 20
+
+// uniqueTags 的value对每个instance都不同, intern它们只会浪费table的空间
+var uniqueTags = map[dicomtag.Tag]bool{
+	dicomtag.SOPInstanceUID:             true,
+	dicomtag.MediaStorageSOPInstanceUID: true,
+}
+
+// stringInterner 让相等的string共享同一份内存. 可以被多个goroutine使用
+type stringInterner struct {
+	mu      sync.Mutex
+	strings map[string]string
+}
+
+var defaultInterner = &stringInterner{strings: map[string]string{}}
+
+func (in *stringInterner) intern(s string) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if interned, ok := in.strings[s]; ok {
+		return interned
+	}
+	if len(in.strings) >= maxInternedStrings {
+		in.strings = map[string]string{}
+	}
+	in.strings[s] = s
+	return s
+}
+
+// internStrings 把elem(包括SQ里的element)中的短string value换成intern table里的string
+func internStrings(elem *Element) {
+	if uniqueTags[elem.Tag] {
+		return
+	}
+	for i, value := range elem.Value {
+		switch v := value.(type) {
+		case string:
+			if len(v) <= maxInternedLength {
+				elem.Value[i] = defaultInterner.intern(v)
+			}
+		case *Element:
+			internStrings(v)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package dicom_test
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadInternStrings(t *testing.T) {
+	data := dicomtest.MustBytes(dicomtest.Spec{})
+	stringData := func(ds *dicom.DataSet, tag dicomtag.Tag) uintptr {
+		elem, err := ds.FindElementByTag(tag)
+		require.NoError(t, err)
+		s := elem.MustGetString()
+		return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+	}
+
+	ds0 := mustReadBytes(data, dicom.ReadOptions{InternStrings: true})
+	ds1 := mustReadBytes(data, dicom.ReadOptions{InternStrings: true})
+	require.Equal(t, mustWriteDataSet(ds0), mustWriteDataSet(ds1))
+	require.Equal(t, stringData(ds0, dicomtag.StudyInstanceUID), stringData(ds1, dicomtag.StudyInstanceUID))
+	require.Equal(t, stringData(ds0, dicomtag.PatientID), stringData(ds1, dicomtag.PatientID))
+	require.NotEqual(t, stringData(ds0, dicomtag.SOPInstanceUID), stringData(ds1, dicomtag.SOPInstanceUID))
+
+	ds2 := mustReadBytes(data, dicom.ReadOptions{})
+	require.NotEqual(t, stringData(ds0, dicomtag.StudyInstanceUID), stringData(ds2, dicomtag.StudyInstanceUID))
+}
@@ -0,0 +1,79 @@
+package dicom
+
+import (
+	"fmt"
+	"image"
+)
+
+// DecodeFrame decodes the i-th frame (starting at 0) of encapsulated JPEG
+// Baseline (1.2.840.10008.1.2.4.50) pixel data into an image.Image: an
+// *image.Gray for MONOCHROME2 and an *image.YCbCr for YBR_FULL_422 frames.
+//
+// A frame may span several fragments if Offsets (the basic offset table)
+// has an entry per frame; otherwise each fragment is one frame, since
+// PixelDataInfo doesn't know the NumberOfFrames. DataSet.DecodeFrame uses
+// the NumberOfFrames, and decodes a single frame split into several
+// fragments. Frames that are not JPEG (native pixel data, RLE, JPEG 2000,
+// ...) and JPEG processes that image/jpeg doesn't support (e.g. JPEG
+// Lossless) return an error; use DataSet.DecodeFrame for other transfer
+// syntaxes.
+func (p PixelDataInfo) DecodeFrame(i int) (image.Image, error) {
+	frame, err := p.frameData(i, 0)
+	if err != nil {
+		return nil, err
+	}
+	img, err := jpegBaselineCodec{}.DecodeFrame(nil, frame)
+	if err != nil {
+		return nil, fmt.Errorf("dicom.DecodeFrame: frame %d: %v", i, err)
+	}
+	return img, nil
+}
+
+// numFrames 返回encapsulated pixel data的frame个数, 与frameData一致.
+// declared是data set的NumberOfFrames, 不知道时是0
+func (p PixelDataInfo) numFrames(declared int64) int {
+	if len(p.Offsets) > 1 && len(p.Offsets) < len(p.Frames) {
+		return len(p.Offsets)
+	}
+	if declared == 1 && len(p.Frames) > 1 {
+		return 1
+	}
+	return len(p.Frames)
+}
+
+// frameData 返回第i个frame的bytes. 按basic offset table把属于同一个frame的fragments拼起来.
+// 空的offset table被读成[]uint32{0}, 这时如果只有一个frame (declared是1), 所有fragments
+// 都属于它; 否则每个fragment是一个frame. declared是data set的NumberOfFrames, 不知道时是0
+func (p PixelDataInfo) frameData(i int, declared int64) ([]byte, error) {
+	if n := p.numFrames(declared); i < 0 || i >= n {
+		return nil, fmt.Errorf("dicom.DecodeFrame: frame %d out of range, found %d frames", i, n)
+	}
+	if len(p.Offsets) <= 1 || len(p.Offsets) >= len(p.Frames) {
+		if declared == 1 && len(p.Frames) > 1 {
+			var frame []byte
+			for _, fragment := range p.Frames {
+				frame = append(frame, fragment...)
+			}
+			return frame, nil
+		}
+		return p.Frames[i], nil
+	}
+	start := int64(p.Offsets[i])
+	end := int64(-1)
+	if i+1 < len(p.Offsets) {
+		end = int64(p.Offsets[i+1])
+	}
+	// offset是从第一个fragment的item header开始算的, 每个item有8 bytes的header
+	var frame []byte
+	var pos int64
+	for _, fragment := range p.Frames {
+		if pos >= start && (end < 0 || pos < end) {
+			frame = append(frame, fragment...)
+		}
+		pos += 8 + int64(len(fragment))
+	}
+	if frame == nil {
+		return nil, fmt.Errorf("dicom.DecodeFrame: no fragment at offset %d for frame %d", start, i)
+	}
+	return frame, nil
+}
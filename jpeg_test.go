@@ -0,0 +1,80 @@
+package dicom_test
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeFrame(t *testing.T) {
+	newFrame := func(level uint8) []byte {
+		img := image.NewGray(image.Rect(0, 0, 8, 8))
+		for i := range img.Pix {
+			img.Pix[i] = level
+		}
+		var buf bytes.Buffer
+		require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+		if buf.Len()%2 != 0 {
+			buf.WriteByte(0) // fragments have even length
+		}
+		return buf.Bytes()
+	}
+	frame0, frame1 := newFrame(10), newFrame(200)
+
+	ds := newTestDataSet(dicomtest.JPEGBaseline)
+	ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+		Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{frame0, frame1}}}})
+	elem, err := mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{}).FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	pixels := elem.Value[0].(dicom.PixelDataInfo)
+	for i, level := range []uint8{10, 200} {
+		img, err := pixels.DecodeFrame(i)
+		require.NoError(t, err)
+		require.Equal(t, 8, img.Bounds().Dx())
+		gray, ok := img.(*image.Gray)
+		require.True(t, ok)
+		require.InDelta(t, level, gray.GrayAt(3, 3).Y, 2)
+	}
+	_, err = pixels.DecodeFrame(2)
+	require.Error(t, err)
+
+	// A frame split into fragments, located by the basic offset table.
+	split := dicom.PixelDataInfo{
+		Offsets: []uint32{0, uint32(8 + 100 + 8 + len(frame0) - 100)},
+		Frames:  [][]byte{frame0[:100], frame0[100:], frame1},
+	}
+	img, err := split.DecodeFrame(1)
+	require.NoError(t, err)
+	require.InDelta(t, 200, img.(*image.Gray).GrayAt(3, 3).Y, 2)
+	img, err = split.DecodeFrame(0)
+	require.NoError(t, err)
+	require.InDelta(t, 10, img.(*image.Gray).GrayAt(3, 3).Y, 2)
+
+	// A single frame split into fragments, without a basic offset table.
+	ds = newTestDataSet(dicomtest.JPEGBaseline)
+	ds.Elements = append(ds.Elements,
+		dicom.MustNewElement(dicomtag.Rows, uint16(8)),
+		dicom.MustNewElement(dicomtag.Columns, uint16(8)),
+		&dicom.Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+			Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{frame1[:50], frame1[50:100], frame1[100:]}}}})
+	ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	img, err = ds.DecodeFrame(0)
+	require.NoError(t, err)
+	require.InDelta(t, 200, img.(*image.Gray).GrayAt(3, 3).Y, 2)
+	_, err = ds.DecodeFrame(1)
+	require.Error(t, err)
+	require.NoError(t, dicom.Transcode(ds, dicomuid.ExplicitVRLittleEndian))
+	elem, err = ds.FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	require.Len(t, elem.Value[0].(dicom.PixelDataInfo).Frames, 1)
+
+	_, err = dicom.PixelDataInfo{Frames: [][]byte{{1, 2, 3, 4}}}.DecodeFrame(0)
+	require.Error(t, err)
+}
@@ -0,0 +1,678 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// JPEG-LS (ITU-T T.87), lossless (1.2.840.10008.1.2.4.80) and near-lossless
+// (1.2.840.10008.1.2.4.81). 支持所有的interleave模式和LSE marker的preset coding parameters,
+// 不支持mapping tables和restart intervals
+
+// jpegLSCodec 是JPEG-LS的Codec, 解码出的图像见nativeImage. 精度大于8 bit的图像按16 bit解码
+type jpegLSCodec struct{}
+
+func (jpegLSCodec) DecodeFrame(ds *DataSet, frame []byte) (image.Image, error) {
+	rows, err := intValue(ds, dicomtag.Rows, 0)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := intValue(ds, dicomtag.Columns, 0)
+	if err != nil {
+		return nil, err
+	}
+	f, err := decodeJPEGLS(frame, int(rows), int(columns))
+	if err != nil {
+		return nil, err
+	}
+	bitsAllocated := 8
+	if f.precision > 8 {
+		bitsAllocated = 16
+	}
+	return nativeImage(f.native(), f.rows, f.columns, len(f.components), bitsAllocated)
+}
+
+// jlsFrame 是一个JPEG-LS图像
+type jlsFrame struct {
+	precision, rows, columns int
+	// components 是SOF中的component IDs
+	components []int
+	// samples 是每个component解码出的samples, nil表示还没有被scan解码
+	samples [][]int
+
+	// LSE marker中的preset coding parameters, 0表示默认值
+	maxVal, t1, t2, t3, reset int
+}
+
+// native 返回little endian, 每个pixel的samples交错排列的pixel data
+func (f *jlsFrame) native() []byte {
+	bytesPerSample := 1
+	if f.precision > 8 {
+		bytesPerSample = 2
+	}
+	nc := len(f.components)
+	native := make([]byte, f.rows*f.columns*nc*bytesPerSample)
+	for c, samples := range f.samples {
+		for i, v := range samples {
+			if bytesPerSample == 1 {
+				native[i*nc+c] = byte(v)
+			} else {
+				binary.LittleEndian.PutUint16(native[2*(i*nc+c):], uint16(v))
+			}
+		}
+	}
+	return native
+}
+
+// maxJPEGLSSamples 是一个JPEG-LS图像最多的samples (rows*columns*components).
+// 每个sample解码成一个int, 所以损坏的SOF不会导致几十GB的内存分配
+const maxJPEGLSSamples = 1 << 27
+
+// decodeJPEGLS 解析markers并解码所有的scans. rows和columns如果不为0, 是data set的
+// Rows和Columns, SOF中的大小必须与它们相同
+func decodeJPEGLS(data []byte, rows, columns int) (*jlsFrame, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG-LS image (no SOI marker)")
+	}
+	var f *jlsFrame
+	var preset jlsFrame // SOF之前的LSE
+	for pos := 2; ; {
+		if pos+2 > len(data) {
+			return nil, errors.New("JPEG-LS: missing EOI marker")
+		}
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("JPEG-LS: expect a marker at byte %d, found 0x%02x", pos, data[pos])
+		}
+		marker := data[pos+1]
+		pos += 2
+		if marker == 0xff { // fill byte
+			pos--
+			continue
+		}
+		if marker == 0xd9 { // EOI
+			break
+		}
+		if pos+2 > len(data) {
+			return nil, fmt.Errorf("JPEG-LS: truncated marker segment 0xff%02x", marker)
+		}
+		n := int(binary.BigEndian.Uint16(data[pos:]))
+		if n < 2 || pos+n > len(data) {
+			return nil, fmt.Errorf("JPEG-LS: invalid length %d of marker segment 0xff%02x", n, marker)
+		}
+		segment := data[pos+2 : pos+n]
+		pos += n
+		var err error
+		switch {
+		case marker == 0xf7: // SOF55
+			if f != nil {
+				return nil, errors.New("JPEG-LS: found several SOF markers")
+			}
+			if f, err = parseJPEGLSFrameHeader(segment); err != nil {
+				return nil, err
+			}
+			if (rows != 0 && f.rows != rows) || (columns != 0 && f.columns != columns) {
+				return nil, fmt.Errorf("JPEG-LS: image size %dx%d doesn't match Columns %d and Rows %d", f.columns, f.rows, columns, rows)
+			}
+			f.maxVal, f.t1, f.t2, f.t3, f.reset = preset.maxVal, preset.t1, preset.t2, preset.t3, preset.reset
+		case marker == 0xf8: // LSE
+			p := &preset
+			if f != nil {
+				p = f
+			}
+			err = p.parsePresetParameters(segment)
+		case marker == 0xda: // SOS
+			if f == nil {
+				return nil, errors.New("JPEG-LS: SOS marker before SOF")
+			}
+			end := jlsScanEnd(data[pos:])
+			err = f.decodeScan(segment, data[pos:pos+end])
+			pos += end
+		case marker == 0xdd: // DRI
+			if len(segment) >= 2 && binary.BigEndian.Uint16(segment) != 0 {
+				err = errors.New("JPEG-LS: restart intervals are not supported")
+			}
+		case marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			err = fmt.Errorf("JPEG-LS: found SOF marker 0xff%02x of another JPEG process", marker)
+		}
+		// 其他的markers (APPn, COM, ...) 被忽略
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f == nil {
+		return nil, errors.New("JPEG-LS: no SOF marker")
+	}
+	for c, samples := range f.samples {
+		if samples == nil {
+			return nil, fmt.Errorf("JPEG-LS: component %d is not in any scan", f.components[c])
+		}
+	}
+	return f, nil
+}
+
+func parseJPEGLSFrameHeader(segment []byte) (*jlsFrame, error) {
+	if len(segment) < 6 {
+		return nil, errors.New("JPEG-LS: SOF marker segment too short")
+	}
+	f := &jlsFrame{
+		precision: int(segment[0]),
+		rows:      int(binary.BigEndian.Uint16(segment[1:])),
+		columns:   int(binary.BigEndian.Uint16(segment[3:])),
+	}
+	nc := int(segment[5])
+	if f.precision < 2 || f.precision > 16 {
+		return nil, fmt.Errorf("JPEG-LS: invalid precision %d", f.precision)
+	}
+	if f.rows == 0 || f.columns == 0 {
+		return nil, fmt.Errorf("JPEG-LS: image size %dx%d is not supported", f.columns, f.rows)
+	}
+	if nc == 0 || len(segment) < 6+3*nc {
+		return nil, fmt.Errorf("JPEG-LS: invalid SOF marker segment for %d components", nc)
+	}
+	if f.rows*f.columns*nc > maxJPEGLSSamples {
+		return nil, fmt.Errorf("JPEG-LS: image size %dx%d with %d components is too large", f.columns, f.rows, nc)
+	}
+	for i := 0; i < nc; i++ {
+		if sampling := segment[7+3*i]; sampling != 0x11 {
+			return nil, fmt.Errorf("JPEG-LS: subsampling 0x%02x is not supported", sampling)
+		}
+		f.components = append(f.components, int(segment[6+3*i]))
+	}
+	f.samples = make([][]int, nc)
+	return f, nil
+}
+
+func (f *jlsFrame) parsePresetParameters(segment []byte) error {
+	if len(segment) == 0 {
+		return errors.New("JPEG-LS: empty LSE marker segment")
+	}
+	if segment[0] != 1 {
+		return fmt.Errorf("JPEG-LS: LSE marker of type %d (mapping tables or oversize images) is not supported", segment[0])
+	}
+	if len(segment) < 11 {
+		return errors.New("JPEG-LS: LSE marker segment too short")
+	}
+	v := func(i int) int { return int(binary.BigEndian.Uint16(segment[1+2*i:])) }
+	f.maxVal, f.t1, f.t2, f.t3, f.reset = v(0), v(1), v(2), v(3), v(4)
+	return nil
+}
+
+// jlsScanEnd 返回scan的entropy coded data的长度, 即下一个marker的位置.
+// 在scan data中0xff之后的byte的最高位总是0
+func jlsScanEnd(data []byte) int {
+	for i := 0; i+1 < len(data); i++ {
+		if data[i] == 0xff && data[i+1] >= 0x80 {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// jlsJ 是run mode使用的J[RUNindex] (T.87 A.7.1.2)
+var jlsJ = [32]int{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// jlsScan 是解码一个scan的状态
+type jlsScan struct {
+	r jlsBitReader
+
+	near, maxVal, rangeVal, qbpp, limit, reset int
+	t1, t2, t3                                 int
+
+	// regular mode的365个contexts
+	a, b, c, n [365]int
+	// run interruption的两个contexts, 按RItype
+	riA, riN, riNn [2]int
+}
+
+func (f *jlsFrame) decodeScan(segment, data []byte) error {
+	if len(segment) < 1 {
+		return errors.New("JPEG-LS: SOS marker segment too short")
+	}
+	ns := int(segment[0])
+	if ns == 0 || len(segment) < 4+2*ns {
+		return fmt.Errorf("JPEG-LS: invalid SOS marker segment for %d components", ns)
+	}
+	var comps []int
+	for i := 0; i < ns; i++ {
+		id := int(segment[1+2*i])
+		c := -1
+		for j, cid := range f.components {
+			if cid == id {
+				c = j
+			}
+		}
+		if c < 0 {
+			return fmt.Errorf("JPEG-LS: scan has unknown component %d", id)
+		}
+		if f.samples[c] != nil {
+			return fmt.Errorf("JPEG-LS: component %d is in several scans", id)
+		}
+		if segment[2+2*i] != 0 {
+			return errors.New("JPEG-LS: mapping tables are not supported")
+		}
+		comps = append(comps, c)
+	}
+	near, ilv, pt := int(segment[1+2*ns]), int(segment[2+2*ns]), segment[3+2*ns]
+	if pt != 0 {
+		return errors.New("JPEG-LS: point transform is not supported")
+	}
+	if ilv > 2 || (ilv == 0 && ns != 1) {
+		return fmt.Errorf("JPEG-LS: invalid interleave mode %d for %d components", ilv, ns)
+	}
+
+	s := &jlsScan{r: jlsBitReader{data: data}, near: near}
+	if err := s.init(f); err != nil {
+		return err
+	}
+	lines := make([][2][]int, ns)
+	for i, c := range comps {
+		lines[i] = [2][]int{make([]int, f.columns+2), make([]int, f.columns+2)}
+		f.samples[c] = make([]int, f.rows*f.columns)
+	}
+	runIndex := make([]int, ns)
+	prev, cur := make([][]int, ns), make([][]int, ns)
+	for y := 0; y < f.rows; y++ {
+		for i := range comps {
+			prev[i], cur[i] = lines[i][y%2], lines[i][(y+1)%2]
+			// 行首的Ra等于Rb, Rc是上一行的Ra; 行末的Rd等于Rb
+			cur[i][0] = prev[i][1]
+			prev[i][f.columns+1] = prev[i][f.columns]
+		}
+		if ilv == 2 {
+			s.decodeSampleInterleavedLine(prev, cur, f.columns, &runIndex[0])
+		} else {
+			for i := range comps {
+				s.decodeLine(prev[i], cur[i], f.columns, &runIndex[i])
+			}
+		}
+		if s.r.err != nil {
+			return fmt.Errorf("JPEG-LS: line %d: %v", y, s.r.err)
+		}
+		for i, c := range comps {
+			copy(f.samples[c][y*f.columns:], cur[i][1:f.columns+1])
+		}
+	}
+	return nil
+}
+
+// init 计算coding parameters (T.87 A.2.1, C.2.4.1.1) 并初始化contexts
+func (s *jlsScan) init(f *jlsFrame) error {
+	s.maxVal = f.maxVal
+	if s.maxVal == 0 {
+		s.maxVal = 1<<uint(f.precision) - 1
+	}
+	if s.near > 255 || 2*s.near > s.maxVal {
+		return fmt.Errorf("JPEG-LS: invalid NEAR %d", s.near)
+	}
+	s.rangeVal = (s.maxVal+2*s.near)/(2*s.near+1) + 1
+	for 1<<uint(s.qbpp) < s.rangeVal {
+		s.qbpp++
+	}
+	bpp := 2
+	for 1<<uint(bpp) < s.maxVal+1 {
+		bpp++
+	}
+	if bpp < 8 {
+		s.limit = 2 * (bpp + 8)
+	} else {
+		s.limit = 4 * bpp
+	}
+	s.reset = f.reset
+	if s.reset == 0 {
+		s.reset = 64
+	}
+
+	// 默认的thresholds
+	clamp := func(i, j int) int {
+		if i > s.maxVal || i < j {
+			return j
+		}
+		return i
+	}
+	if s.maxVal >= 128 {
+		m := s.maxVal
+		if m > 4095 {
+			m = 4095
+		}
+		factor := (m + 128) / 256
+		s.t1 = clamp(factor*(3-2)+2+3*s.near, s.near+1)
+		s.t2 = clamp(factor*(7-3)+3+5*s.near, s.t1)
+		s.t3 = clamp(factor*(21-4)+4+7*s.near, s.t2)
+	} else {
+		factor := 256 / (s.maxVal + 1)
+		s.t1 = clamp(maxInt(2, 3/factor+3*s.near), s.near+1)
+		s.t2 = clamp(maxInt(3, 7/factor+5*s.near), s.t1)
+		s.t3 = clamp(maxInt(4, 21/factor+7*s.near), s.t2)
+	}
+	if f.t1 != 0 {
+		s.t1 = f.t1
+	}
+	if f.t2 != 0 {
+		s.t2 = f.t2
+	}
+	if f.t3 != 0 {
+		s.t3 = f.t3
+	}
+
+	a := maxInt(2, (s.rangeVal+32)/64)
+	for i := range s.a {
+		s.a[i], s.n[i] = a, 1
+	}
+	for i := range s.riA {
+		s.riA[i], s.riN[i] = a, 1
+	}
+	return nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func (s *jlsScan) quantize(d int) int {
+	switch {
+	case d <= -s.t3:
+		return -4
+	case d <= -s.t2:
+		return -3
+	case d <= -s.t1:
+		return -2
+	case d < -s.near:
+		return -1
+	case d <= s.near:
+		return 0
+	case d < s.t1:
+		return 1
+	case d < s.t2:
+		return 2
+	case d < s.t3:
+		return 3
+	}
+	return 4
+}
+
+// context 返回带符号的context index Q, 0表示run mode
+func (s *jlsScan) context(ra, rb, rc, rd int) int {
+	return (s.quantize(rd-rb)*9+s.quantize(rb-rc))*9 + s.quantize(rc-ra)
+}
+
+// medPredict 是median edge detector (T.87 A.4.1)
+func medPredict(ra, rb, rc int) int {
+	lo, hi := ra, rb
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	switch {
+	case rc >= hi:
+		return lo
+	case rc <= lo:
+		return hi
+	}
+	return ra + rb - rc
+}
+
+func (s *jlsScan) clampSample(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > s.maxVal {
+		return s.maxVal
+	}
+	return v
+}
+
+// reconstruct 返回预测值px加上(quantized) errval后的sample (T.87 A.4.4, A.5.4)
+func (s *jlsScan) reconstruct(px, errval int) int {
+	rx := px + errval*(2*s.near+1)
+	if rx < -s.near {
+		rx += s.rangeVal * (2*s.near + 1)
+	} else if rx > s.maxVal+s.near {
+		rx -= s.rangeVal * (2*s.near + 1)
+	}
+	return s.clampSample(rx)
+}
+
+// decodeValue 读一个limited length Golomb code (T.87 A.5.3)
+func (s *jlsScan) decodeValue(k, limit int) int {
+	high := 0
+	for s.r.bit() == 0 {
+		if s.r.err != nil {
+			return 0
+		}
+		if high++; high > limit-s.qbpp-1 {
+			s.r.err = errors.New("invalid Golomb code")
+			return 0
+		}
+	}
+	if high == limit-s.qbpp-1 {
+		return s.r.bits(s.qbpp) + 1
+	}
+	return high<<uint(k) | s.r.bits(k)
+}
+
+// decodeRegular 解码regular mode的一个sample (T.87 A.4-A.6)
+func (s *jlsScan) decodeRegular(q, px int) int {
+	sign := 1
+	if q < 0 {
+		sign, q = -1, -q
+	}
+	px = s.clampSample(px + sign*s.c[q])
+	k := 0
+	for s.n[q]<<uint(k) < s.a[q] {
+		k++
+	}
+	m := s.decodeValue(k, s.limit)
+	errval := m >> 1
+	if m&1 != 0 {
+		errval = -errval - 1
+	}
+	if s.near == 0 && k == 0 && 2*s.b[q] <= -s.n[q] {
+		errval = -errval - 1
+	}
+
+	// 更新context (A.6)
+	s.b[q] += errval * (2*s.near + 1)
+	s.a[q] += absInt(errval)
+	if s.n[q] == s.reset {
+		s.a[q] >>= 1
+		s.b[q] >>= 1
+		s.n[q] >>= 1
+	}
+	s.n[q]++
+	if s.b[q] <= -s.n[q] {
+		s.b[q] += s.n[q]
+		if s.c[q] > -128 {
+			s.c[q]--
+		}
+		if s.b[q] <= -s.n[q] {
+			s.b[q] = -s.n[q] + 1
+		}
+	} else if s.b[q] > 0 {
+		s.b[q] -= s.n[q]
+		if s.c[q] < 127 {
+			s.c[q]++
+		}
+		if s.b[q] > 0 {
+			s.b[q] = 0
+		}
+	}
+	return s.reconstruct(px, sign*errval)
+}
+
+// decodeRunLength 读run的长度, 最多remaining (T.87 A.7.1.2)
+func (s *jlsScan) decodeRunLength(remaining int, runIndex *int) int {
+	n := 0
+	for s.r.bit() == 1 {
+		count := 1 << uint(jlsJ[*runIndex])
+		if count > remaining-n {
+			count = remaining - n
+		}
+		n += count
+		if count == 1<<uint(jlsJ[*runIndex]) && *runIndex < 31 {
+			*runIndex++
+		}
+		if n == remaining {
+			return n
+		}
+	}
+	n += s.r.bits(jlsJ[*runIndex])
+	if n > remaining {
+		s.r.err = fmt.Errorf("run of %d samples overflows the line", n)
+		return remaining
+	}
+	return n
+}
+
+// decodeRunInterruption 解码run interruption sample的error (T.87 A.7.2)
+func (s *jlsScan) decodeRunInterruption(riType, runIndex int) int {
+	temp := s.riA[riType] + (s.riN[riType]>>1)*riType
+	k := 0
+	for s.riN[riType]<<uint(k) < temp {
+		k++
+	}
+	m := s.decodeValue(k, s.limit-jlsJ[runIndex]-1)
+	t := m + riType
+	mapBit := t & 1
+	errval := (t + mapBit) / 2
+	if (k != 0 || 2*s.riNn[riType] >= s.riN[riType]) == (mapBit == 1) {
+		errval = -errval
+	}
+	if errval < 0 {
+		s.riNn[riType]++
+	}
+	s.riA[riType] += (m + 1 - riType) >> 1
+	if s.riN[riType] == s.reset {
+		s.riA[riType] >>= 1
+		s.riN[riType] >>= 1
+		s.riNn[riType] >>= 1
+	}
+	s.riN[riType]++
+	return errval
+}
+
+// decodeLine 解码一个component的一行. prev和cur是上一行和当前行, cur[x+1]是第x个sample,
+// cur[0], prev[0]和prev[width+1]是边界的值
+func (s *jlsScan) decodeLine(prev, cur []int, width int, runIndex *int) {
+	for x := 0; x < width && s.r.err == nil; {
+		ra, rb, rc, rd := cur[x], prev[x+1], prev[x], prev[x+2]
+		if q := s.context(ra, rb, rc, rd); q != 0 {
+			cur[x+1] = s.decodeRegular(q, medPredict(ra, rb, rc))
+			x++
+			continue
+		}
+		n := s.decodeRunLength(width-x, runIndex)
+		for i := 0; i < n; i++ {
+			cur[x+1+i] = ra
+		}
+		if x += n; x == width {
+			break
+		}
+		rb = prev[x+1]
+		if absInt(ra-rb) <= s.near {
+			cur[x+1] = s.reconstruct(ra, s.decodeRunInterruption(1, *runIndex))
+		} else {
+			errval := s.decodeRunInterruption(0, *runIndex)
+			if ra > rb {
+				errval = -errval
+			}
+			cur[x+1] = s.reconstruct(rb, errval)
+		}
+		if *runIndex > 0 {
+			*runIndex--
+		}
+		x++
+	}
+}
+
+// decodeSampleInterleavedLine 解码ILV=2的一行, 所有components的samples交错排列.
+// Run mode比较整个pixel, run interruption的每个sample都使用RItype 0的context
+func (s *jlsScan) decodeSampleInterleavedLine(prev, cur [][]int, width int, runIndex *int) {
+	qs := make([]int, len(cur))
+	for x := 0; x < width && s.r.err == nil; {
+		run := true
+		for c := range cur {
+			qs[c] = s.context(cur[c][x], prev[c][x+1], prev[c][x], prev[c][x+2])
+			run = run && qs[c] == 0
+		}
+		if !run {
+			for c := range cur {
+				cur[c][x+1] = s.decodeRegular(qs[c], medPredict(cur[c][x], prev[c][x+1], prev[c][x]))
+			}
+			x++
+			continue
+		}
+		n := s.decodeRunLength(width-x, runIndex)
+		for c := range cur {
+			for i := 0; i < n; i++ {
+				cur[c][x+1+i] = cur[c][x]
+			}
+		}
+		if x += n; x == width {
+			break
+		}
+		for c := range cur {
+			ra, rb := cur[c][x], prev[c][x+1]
+			errval := s.decodeRunInterruption(0, *runIndex)
+			if ra > rb {
+				errval = -errval
+			}
+			cur[c][x+1] = s.reconstruct(rb, errval)
+		}
+		if *runIndex > 0 {
+			*runIndex--
+		}
+		x++
+	}
+}
+
+// jlsBitReader 读scan data的bits. 0xff之后的byte的最高位是填充的0 bit.
+// 出错后err被设置, 之后读到的bits都是0
+type jlsBitReader struct {
+	data   []byte
+	pos    int
+	cur    byte
+	n      uint
+	prevFF bool
+	err    error
+}
+
+func (r *jlsBitReader) bit() int {
+	if r.n == 0 {
+		if r.pos >= len(r.data) {
+			if r.err == nil {
+				r.err = errors.New("unexpected end of scan data")
+			}
+			return 0
+		}
+		r.cur = r.data[r.pos]
+		r.pos++
+		r.n = 8
+		if r.prevFF {
+			r.n = 7
+		}
+		r.prevFF = r.cur == 0xff
+	}
+	r.n--
+	return int(r.cur>>r.n) & 1
+}
+
+func (r *jlsBitReader) bits(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
@@ -0,0 +1,62 @@
+package dicom_test
+
+import (
+	"image"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJPEGLS(t *testing.T) {
+	// The example of ITU-T T.87 H.3: regular mode, run mode and run
+	// interruption, 8 bits, lossless.
+	frame := []byte{
+		0xff, 0xd8, 0xff, 0xf7, 0x00, 0x0b, 0x08, 0x00, 0x04, 0x00, 0x04, 0x01, 0x01, 0x11, 0x00,
+		0xff, 0xda, 0x00, 0x08, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0xc0, 0x00, 0x00, 0x6c, 0x80, 0x20, 0x8e, 0x01, 0xc0, 0x00, 0x00, 0x57, 0x40, 0x00, 0x00, 0x6e,
+		0xe6, 0x00, 0x00, 0x01, 0xbc, 0x18, 0x00, 0x00, 0x05, 0xd8, 0x00, 0x00, 0x91, 0x60,
+		0xff, 0xd9,
+	}
+	expected := []byte{
+		0, 0, 90, 74,
+		68, 50, 43, 205,
+		64, 145, 145, 145,
+		100, 145, 145, 145,
+	}
+	for _, uid := range []string{"1.2.840.10008.1.2.4.80", "1.2.840.10008.1.2.4.81"} {
+		ds := newTestDataSet(uid)
+		ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+			Value: []interface{}{dicom.PixelDataInfo{Offsets: []uint32{0}, Frames: [][]byte{frame}}}})
+		ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+		img, err := ds.DecodeFrame(0)
+		require.NoError(t, err)
+		require.Equal(t, expected, img.(*image.Gray).Pix)
+	}
+
+	// Truncated scan data.
+	ds := newTestDataSet("1.2.840.10008.1.2.4.80")
+	truncated := append(append([]byte{}, frame[:40]...), 0xff, 0xd9)
+	ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+		Value: []interface{}{dicom.PixelDataInfo{Offsets: []uint32{0}, Frames: [][]byte{truncated}}}})
+	_, err := ds.DecodeFrame(0)
+	require.Error(t, err)
+
+	// SOF的大小与Rows和Columns不同
+	ds = newTestDataSet("1.2.840.10008.1.2.4.80")
+	ds.Elements = append(ds.Elements, dicom.MustNewElement(dicomtag.Rows, uint16(8)), dicom.MustNewElement(dicomtag.Columns, uint16(4)),
+		&dicom.Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+			Value: []interface{}{dicom.PixelDataInfo{Offsets: []uint32{0}, Frames: [][]byte{frame}}}})
+	_, err = ds.DecodeFrame(0)
+	require.Error(t, err)
+
+	// 损坏的SOF: 65535x65535. 不能分配几十GB
+	huge := append([]byte{}, frame...)
+	copy(huge[7:11], []byte{0xff, 0xff, 0xff, 0xff})
+	ds = newTestDataSet("1.2.840.10008.1.2.4.80")
+	ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+		Value: []interface{}{dicom.PixelDataInfo{Offsets: []uint32{0}, Frames: [][]byte{huge}}}})
+	_, err = ds.DecodeFrame(0)
+	require.Error(t, err)
+}
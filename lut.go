@@ -0,0 +1,170 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// DecodeUint16s returns the values of "e" as 16-bit words, whether they
+// were read as US or SS values, or as an OW, OB or UN payload ([]byte),
+// which is how LUT data and some pixel-related attributes usually arrive.
+// OW payloads are in dicomio.NativeByteOrder, as ReadElement stores them;
+// OB and UN payloads, which are kept as in the file, are read as little
+// endian.
+func (e *Element) DecodeUint16s() ([]uint16, error) {
+	var values []uint16
+	for _, v := range e.Value {
+		switch v := v.(type) {
+		case uint16:
+			values = append(values, v)
+		case int16:
+			values = append(values, uint16(v))
+		case []byte:
+			if len(v)%2 != 0 {
+				return nil, fmt.Errorf("dicom.DecodeUint16s: %s: odd length %d", dicomtag.DebugString(e.Tag), len(v))
+			}
+			d := dicomio.NewBytesDecoder(v, dicomio.NativeByteOrder, dicomio.UnknownVR)
+			if e.VR == "OB" || e.VR == "UN" {
+				d = dicomio.NewBytesDecoder(v, binary.LittleEndian, dicomio.UnknownVR)
+			}
+			for i := 0; i < len(v)/2; i++ {
+				values = append(values, d.ReadUInt16())
+			}
+		default:
+			return nil, fmt.Errorf("dicom.DecodeUint16s: %s: not a 16-bit value: %v", dicomtag.DebugString(e.Tag), v)
+		}
+	}
+	return values, nil
+}
+
+// DecodeInt16s is DecodeUint16s for signed values, e.g., the padding value
+// of images with PixelRepresentation 1.
+func (e *Element) DecodeInt16s() ([]int16, error) {
+	words, err := e.DecodeUint16s()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int16, len(words))
+	for i, w := range words {
+		values[i] = int16(w)
+	}
+	return values, nil
+}
+
+// PixelPaddingRange returns the range of padding pixel values (P3.3
+// C.7.5.1.1.2): PixelPaddingValue (0028,0120) and PixelPaddingRangeLimit
+// (0028,0121), in increasing order; without a range limit, both are the
+// padding value. They are signed if PixelRepresentation (0028,0103) is 1.
+// "ok" is false if there is no PixelPaddingValue.
+func (f *DataSet) PixelPaddingRange() (low, high int, ok bool, err error) {
+	elem, err := f.FindElementByTag(dicomtag.PixelPaddingValue)
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	pixelRepresentation, err := intValue(f, dicomtag.PixelRepresentation, 0)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("dicom.PixelPaddingRange: %v", err)
+	}
+	value := func(elem *Element) (int, error) {
+		words, err := elem.DecodeUint16s()
+		if err != nil {
+			return 0, err
+		}
+		if len(words) != 1 {
+			return 0, fmt.Errorf("dicom.PixelPaddingRange: %s: expect 1 value, found %d", dicomtag.DebugString(elem.Tag), len(words))
+		}
+		if pixelRepresentation == 1 {
+			return int(int16(words[0])), nil
+		}
+		return int(words[0]), nil
+	}
+	if low, err = value(elem); err != nil {
+		return 0, 0, false, err
+	}
+	high = low
+	if elem, err := f.FindElementByTag(dicomtag.PixelPaddingRangeLimit); err == nil {
+		if high, err = value(elem); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	if high < low {
+		low, high = high, low
+	}
+	return low, high, true, nil
+}
+
+// LUT is a lookup table decoded from its descriptor and data, e.g.,
+// LUTDescriptor (0028,3002) and LUTData (0028,3006) of a Modality or VOI
+// LUT, or a palette color lookup table (P3.3 C.11.1.1, C.7.6.3.1.5).
+type LUT struct {
+	// FirstMapped 是映射到Data[0]的第一个输入值. 对有符号的pixel data可能是负数
+	FirstMapped int
+	// Bits 是每个entry的bit数, 通常是8或16
+	Bits int
+	// Data 有descriptor指定的entry个数
+	Data []uint16
+}
+
+// Lookup returns the entry for "value". Values outside the table map to
+// its first or last entry.
+func (l *LUT) Lookup(value int) uint16 {
+	i := value - l.FirstMapped
+	if i < 0 {
+		i = 0
+	} else if i >= len(l.Data) {
+		i = len(l.Data) - 1
+	}
+	return l.Data[i]
+}
+
+// DecodeLUT decodes the lookup table with descriptor "descriptorTag" and
+// data "dataTag" in "elems", which are the elements of a sequence item
+// (e.g., of ModalityLUTSequence) or of a data set. The first descriptor
+// value is the number of entries, 0 meaning 65536; the second is the first
+// mapped value, which is signed if the descriptor was read as SS or if
+// "signed" is true (PixelRepresentation 1); the third is the number of
+// bits per entry. 8-bit entries packed two per OW word, as some generators
+// write palettes, are unpacked.
+func DecodeLUT(elems []*Element, descriptorTag, dataTag dicomtag.Tag, signed bool) (*LUT, error) {
+	descriptor, err := FindElementByTag(elems, descriptorTag)
+	if err != nil {
+		return nil, fmt.Errorf("dicom.DecodeLUT: %v", err)
+	}
+	values, err := descriptor.DecodeUint16s()
+	if err != nil || len(values) != 3 {
+		return nil, fmt.Errorf("dicom.DecodeLUT: %s: expect 3 values, found %v", dicomtag.DebugString(descriptorTag), descriptor.Value)
+	}
+	numEntries := int(values[0])
+	if numEntries == 0 {
+		numEntries = 65536
+	}
+	lut := &LUT{FirstMapped: int(values[1]), Bits: int(values[2])}
+	if signed || descriptor.VR == "SS" {
+		lut.FirstMapped = int(int16(values[1]))
+	}
+	dataElem, err := FindElementByTag(elems, dataTag)
+	if err != nil {
+		return nil, fmt.Errorf("dicom.DecodeLUT: %v", err)
+	}
+	data, err := dataElem.DecodeUint16s()
+	if err != nil {
+		return nil, err
+	}
+	if lut.Bits <= 8 && len(data) == (numEntries+1)/2 && len(data) < numEntries {
+		// 两个8-bit entry放在一个word里, 低字节在前
+		unpacked := make([]uint16, 0, numEntries)
+		for _, w := range data {
+			unpacked = append(unpacked, w&0xff, w>>8)
+		}
+		data = unpacked[:numEntries]
+	}
+	if len(data) < numEntries {
+		return nil, fmt.Errorf("dicom.DecodeLUT: %s has %d entries, but %s says %d",
+			dicomtag.DebugString(dataTag), len(data), dicomtag.DebugString(descriptorTag), numEntries)
+	}
+	lut.Data = data[:numEntries]
+	return lut, nil
+}
@@ -0,0 +1,118 @@
+package dicom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Manifest lists the instances of one or more studies, for reconciling
+// archives without reading the instances again: each archive builds a
+// manifest, and the manifests are compared. It is meant to be stored as
+// JSON, with encoding/json.
+type Manifest struct {
+	Studies []ManifestStudy `json:"studies"`
+}
+
+// ManifestStudy is a study in a Manifest.
+type ManifestStudy struct {
+	StudyInstanceUID  string           `json:"studyInstanceUID"`
+	NumberOfInstances int              `json:"numberOfInstances"`
+	Series            []ManifestSeries `json:"series"`
+}
+
+// ManifestSeries is a series in a ManifestStudy.
+type ManifestSeries struct {
+	SeriesInstanceUID string             `json:"seriesInstanceUID"`
+	Modality          string             `json:"modality,omitempty"`
+	NumberOfInstances int                `json:"numberOfInstances"`
+	Instances         []ManifestInstance `json:"instances"`
+}
+
+// ManifestInstance is an instance in a ManifestSeries.
+type ManifestInstance struct {
+	SOPClassUID       string `json:"sopClassUID"`
+	SOPInstanceUID    string `json:"sopInstanceUID"`
+	TransferSyntaxUID string `json:"transferSyntaxUID"`
+	// Size 是WriteDataSet写出的文件的大小(bytes)
+	Size int64 `json:"size"`
+	// Digest 是WriteDataSet写出的文件的SHA-256, 格式为"sha256:<hex>"
+	Digest string `json:"digest"`
+}
+
+// BuildManifest builds the manifest of "datasets". Studies, series and
+// instances are listed in the order they first appear in datasets. The
+// size and digest of an instance are those of the file that WriteDataSet
+// writes, which is computed in memory; they match the file an archive
+// stores only if the archive wrote it with WriteDataSet.
+//
+// It returns an error if a data set lacks StudyInstanceUID,
+// SeriesInstanceUID, SOPInstanceUID or TransferSyntaxUID, or if two data
+// sets have the same SOPInstanceUID.
+func BuildManifest(datasets []*DataSet) (*Manifest, error) {
+	m := &Manifest{}
+	studies := map[string]int{}   // StudyInstanceUID -> m.Studies的index
+	series := map[string][2]int{} // SeriesInstanceUID -> Studies和Series的index
+	instances := map[string]bool{}
+	for i, ds := range datasets {
+		var studyUID, seriesUID, modality string
+		var instance ManifestInstance
+		for _, field := range []struct {
+			tag      dicomtag.Tag
+			value    *string
+			required bool
+		}{
+			{dicomtag.StudyInstanceUID, &studyUID, true},
+			{dicomtag.SeriesInstanceUID, &seriesUID, true},
+			{dicomtag.Modality, &modality, false},
+			{dicomtag.SOPClassUID, &instance.SOPClassUID, false},
+			{dicomtag.SOPInstanceUID, &instance.SOPInstanceUID, true},
+			{dicomtag.TransferSyntaxUID, &instance.TransferSyntaxUID, true},
+		} {
+			if elem, err := ds.FindElementByTag(field.tag); err == nil {
+				*field.value, _ = elem.GetString()
+			}
+			if *field.value == "" && field.required {
+				return nil, fmt.Errorf("dicom.BuildManifest: data set #%d has no %s", i, dicomtag.DebugString(field.tag))
+			}
+		}
+		if instances[instance.SOPInstanceUID] {
+			return nil, fmt.Errorf("dicom.BuildManifest: data set #%d: duplicate SOPInstanceUID %s", i, instance.SOPInstanceUID)
+		}
+		instances[instance.SOPInstanceUID] = true
+
+		h := sha256.New()
+		var n byteCounter
+		if err := WriteDataSet(io.MultiWriter(h, &n), ds); err != nil {
+			return nil, fmt.Errorf("dicom.BuildManifest: data set #%d: %v", i, err)
+		}
+		instance.Size = int64(n)
+		instance.Digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+
+		studyIndex, ok := studies[studyUID]
+		if !ok {
+			studyIndex = len(m.Studies)
+			studies[studyUID] = studyIndex
+			m.Studies = append(m.Studies, ManifestStudy{StudyInstanceUID: studyUID})
+		}
+		study := &m.Studies[studyIndex]
+		index, ok := series[seriesUID]
+		if !ok {
+			index = [2]int{studyIndex, len(study.Series)}
+			series[seriesUID] = index
+			study.Series = append(study.Series, ManifestSeries{SeriesInstanceUID: seriesUID, Modality: modality})
+		}
+		if index[0] != studyIndex {
+			return nil, fmt.Errorf("dicom.BuildManifest: data set #%d: series %s is in studies %s and %s",
+				i, seriesUID, m.Studies[index[0]].StudyInstanceUID, studyUID)
+		}
+		s := &study.Series[index[1]]
+		s.Instances = append(s.Instances, instance)
+		s.NumberOfInstances++
+		study.NumberOfInstances++
+	}
+	return m, nil
+}
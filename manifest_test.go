@@ -0,0 +1,56 @@
+package dicom_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildManifest(t *testing.T) {
+	newInstance := func(seriesUID, sopInstanceUID string) *dicom.DataSet {
+		ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+		ds.Replace(dicom.MustNewElement(dicomtag.SeriesInstanceUID, seriesUID), "")
+		ds.Replace(dicom.MustNewElement(dicomtag.SOPInstanceUID, sopInstanceUID), "")
+		ds.Replace(dicom.MustNewElement(dicomtag.Modality, "CT"), "")
+		return ds
+	}
+	datasets := []*dicom.DataSet{
+		newInstance("1.2.3.4.1", "1.2.3.4.1.1"),
+		newInstance("1.2.3.4.2", "1.2.3.4.2.1"),
+		newInstance("1.2.3.4.1", "1.2.3.4.1.2"),
+	}
+	m, err := dicom.BuildManifest(datasets)
+	require.NoError(t, err)
+	require.Len(t, m.Studies, 1)
+	study := m.Studies[0]
+	require.Equal(t, "1.2.3.4", study.StudyInstanceUID)
+	require.Equal(t, 3, study.NumberOfInstances)
+	require.Len(t, study.Series, 2)
+	require.Equal(t, "1.2.3.4.1", study.Series[0].SeriesInstanceUID)
+	require.Equal(t, "CT", study.Series[0].Modality)
+	require.Equal(t, 2, study.Series[0].NumberOfInstances)
+	require.Equal(t, "1.2.3.4.1.2", study.Series[0].Instances[1].SOPInstanceUID)
+
+	// The size and digest are those of the written file.
+	data := mustWriteDataSet(datasets[0])
+	sum := sha256.Sum256(data)
+	instance := study.Series[0].Instances[0]
+	require.Equal(t, dicomuid.ExplicitVRLittleEndian, instance.TransferSyntaxUID)
+	require.Equal(t, int64(len(data)), instance.Size)
+	require.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), instance.Digest)
+
+	encoded, err := json.Marshal(m)
+	require.NoError(t, err)
+	var decoded dicom.Manifest
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	require.Equal(t, m, &decoded)
+
+	_, err = dicom.BuildManifest(append(datasets, newInstance("1.2.3.4.2", "1.2.3.4.1.1")))
+	require.Error(t, err)
+}
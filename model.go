@@ -0,0 +1,183 @@
+package dicom
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Patient, Study, Series and Instance are struct views of the common
+// attributes of the four levels of the DICOM information model, e.g., for
+// storing them in a database. They cover only a few attributes each; use the
+// element-level API for everything else.
+//
+// Date (DA) and time (TM) attributes are kept as the strings found in the
+// dataset. Integer strings (IS) are converted to int.
+
+// Patient holds the patient-level attributes.
+type Patient struct {
+	ID                string // PatientID (0010,0020)
+	IssuerOfPatientID string // IssuerOfPatientID (0010,0021)
+	Name              string // PatientName (0010,0010)
+	BirthDate         string // PatientBirthDate (0010,0030)
+	Sex               string // PatientSex (0010,0040)
+}
+
+// Study holds the study-level attributes.
+type Study struct {
+	InstanceUID            string // StudyInstanceUID (0020,000D)
+	ID                     string // StudyID (0020,0010)
+	Date                   string // StudyDate (0008,0020)
+	Time                   string // StudyTime (0008,0030)
+	AccessionNumber        string // AccessionNumber (0008,0050)
+	Description            string // StudyDescription (0008,1030)
+	ReferringPhysicianName string // ReferringPhysicianName (0008,0090)
+}
+
+// Series holds the series-level attributes.
+type Series struct {
+	InstanceUID      string // SeriesInstanceUID (0020,000E)
+	Number           int    // SeriesNumber (0020,0011)
+	Modality         string // Modality (0008,0060)
+	Description      string // SeriesDescription (0008,103E)
+	BodyPartExamined string // BodyPartExamined (0018,0015)
+	Date             string // SeriesDate (0008,0021)
+	Time             string // SeriesTime (0008,0031)
+}
+
+// Instance holds the instance-level attributes.
+type Instance struct {
+	SOPClassUID    string // SOPClassUID (0008,0016)
+	SOPInstanceUID string // SOPInstanceUID (0008,0018)
+	Number         int    // InstanceNumber (0020,0013)
+	ContentDate    string // ContentDate (0008,0023)
+	ContentTime    string // ContentTime (0008,0033)
+	NumberOfFrames int    // NumberOfFrames (0028,0008)
+}
+
+// modelField 把struct的一个field映射到一个tag. str和num只有一个不为nil
+type modelField struct {
+	tag dicomtag.Tag
+	str *string
+	num *int
+}
+
+func (p *Patient) fields() []modelField {
+	return []modelField{
+		{tag: dicomtag.PatientID, str: &p.ID},
+		{tag: dicomtag.IssuerOfPatientID, str: &p.IssuerOfPatientID},
+		{tag: dicomtag.PatientName, str: &p.Name},
+		{tag: dicomtag.PatientBirthDate, str: &p.BirthDate},
+		{tag: dicomtag.PatientSex, str: &p.Sex},
+	}
+}
+
+func (s *Study) fields() []modelField {
+	return []modelField{
+		{tag: dicomtag.StudyInstanceUID, str: &s.InstanceUID},
+		{tag: dicomtag.StudyID, str: &s.ID},
+		{tag: dicomtag.StudyDate, str: &s.Date},
+		{tag: dicomtag.StudyTime, str: &s.Time},
+		{tag: dicomtag.AccessionNumber, str: &s.AccessionNumber},
+		{tag: dicomtag.StudyDescription, str: &s.Description},
+		{tag: dicomtag.ReferringPhysicianName, str: &s.ReferringPhysicianName},
+	}
+}
+
+func (s *Series) fields() []modelField {
+	return []modelField{
+		{tag: dicomtag.SeriesInstanceUID, str: &s.InstanceUID},
+		{tag: dicomtag.SeriesNumber, num: &s.Number},
+		{tag: dicomtag.Modality, str: &s.Modality},
+		{tag: dicomtag.SeriesDescription, str: &s.Description},
+		{tag: dicomtag.BodyPartExamined, str: &s.BodyPartExamined},
+		{tag: dicomtag.SeriesDate, str: &s.Date},
+		{tag: dicomtag.SeriesTime, str: &s.Time},
+	}
+}
+
+func (i *Instance) fields() []modelField {
+	return []modelField{
+		{tag: dicomtag.SOPClassUID, str: &i.SOPClassUID},
+		{tag: dicomtag.SOPInstanceUID, str: &i.SOPInstanceUID},
+		{tag: dicomtag.InstanceNumber, num: &i.Number},
+		{tag: dicomtag.ContentDate, str: &i.ContentDate},
+		{tag: dicomtag.ContentTime, str: &i.ContentTime},
+		{tag: dicomtag.NumberOfFrames, num: &i.NumberOfFrames},
+	}
+}
+
+// FromDataSet fills p from "ds". Attributes missing in ds, or present but
+// empty, leave the zero value. It returns an error if an attribute can't be
+// parsed, e.g., an IS value that isn't an integer.
+func (p *Patient) FromDataSet(ds *DataSet) error { return fromDataSet(ds, p.fields()) }
+
+// ApplyTo writes the non-zero fields of p into "ds", replacing the existing
+// elements through DataSet.Replace. Zero-valued fields leave ds untouched.
+func (p *Patient) ApplyTo(ds *DataSet) error { return applyTo(ds, p.fields()) }
+
+// FromDataSet fills s from "ds". See Patient.FromDataSet.
+func (s *Study) FromDataSet(ds *DataSet) error { return fromDataSet(ds, s.fields()) }
+
+// ApplyTo writes the non-zero fields of s into "ds". See Patient.ApplyTo.
+func (s *Study) ApplyTo(ds *DataSet) error { return applyTo(ds, s.fields()) }
+
+// FromDataSet fills s from "ds". See Patient.FromDataSet.
+func (s *Series) FromDataSet(ds *DataSet) error { return fromDataSet(ds, s.fields()) }
+
+// ApplyTo writes the non-zero fields of s into "ds". See Patient.ApplyTo.
+func (s *Series) ApplyTo(ds *DataSet) error { return applyTo(ds, s.fields()) }
+
+// FromDataSet fills i from "ds". See Patient.FromDataSet.
+func (i *Instance) FromDataSet(ds *DataSet) error { return fromDataSet(ds, i.fields()) }
+
+// ApplyTo writes the non-zero fields of i into "ds". See Patient.ApplyTo.
+func (i *Instance) ApplyTo(ds *DataSet) error { return applyTo(ds, i.fields()) }
+
+func fromDataSet(ds *DataSet, fields []modelField) error {
+	for _, field := range fields {
+		elem, err := ds.FindElementByTag(field.tag)
+		if err != nil || len(elem.Value) == 0 {
+			continue
+		}
+		s, ok := elem.Value[0].(string)
+		if !ok {
+			return fmt.Errorf("dicom.FromDataSet: %v: found non-string value %v", dicomtag.DebugString(field.tag), elem.Value[0])
+		}
+		if field.str != nil {
+			*field.str = s
+			continue
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("dicom.FromDataSet: %v: %v", dicomtag.DebugString(field.tag), err)
+		}
+		*field.num = v
+	}
+	return nil
+}
+
+func applyTo(ds *DataSet, fields []modelField) error {
+	for _, field := range fields {
+		var value string
+		if field.str != nil {
+			value = *field.str
+		} else if *field.num != 0 {
+			value = strconv.Itoa(*field.num)
+		}
+		if value == "" {
+			continue
+		}
+		elem, err := NewElement(field.tag, value)
+		if err != nil {
+			return err
+		}
+		ds.Replace(elem, "")
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package dicom
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// UIDGenerator creates new, globally unique UIDs, e.g., for the instances
+// derived from an existing one.
+type UIDGenerator interface {
+	NewUID() (string, error)
+}
+
+// enhancedToClassic 把enhanced multi-frame SOP class映射到对应的classic single-frame SOP class
+var enhancedToClassic = map[string]string{
+	dicomuid.EnhancedCTImageStorage:  dicomuid.CTImageStorage,
+	dicomuid.EnhancedMRImageStorage:  dicomuid.MRImageStorage,
+	"1.2.840.10008.5.1.4.1.1.128.1": "1.2.840.10008.5.1.4.1.1.128", // Enhanced PET -> PET
+}
+
+// splitFramesDropped 是SplitFrames不复制到single-frame instance的top-level element
+var splitFramesDropped = map[dicomtag.Tag]bool{
+	dicomtag.SharedFunctionalGroupsSequence:   true,
+	dicomtag.PerFrameFunctionalGroupsSequence: true,
+	dicomtag.NumberOfFrames:                   true,
+	dicomtag.PixelData:                        true,
+}
+
+// SplitFrames converts the multi-frame image "ds" into one single-frame
+// instance per frame, for viewers that can't display multi-frame objects.
+//
+// Each instance gets a new SOPInstanceUID from "uidGen", an InstanceNumber
+// starting at 1, and one frame of PixelData. The attributes of the
+// functional groups that apply to the frame, from
+// SharedFunctionalGroupsSequence and then PerFrameFunctionalGroupsSequence,
+// are copied to the top level, e.g., PixelSpacing from PixelMeasuresSequence.
+// Enhanced CT, MR and PET images become CT, MR and PET images; other SOP
+// classes are kept.
+//
+// Encapsulated PixelData must hold one fragment per frame. The elements of
+// the result share values with ds; ds itself is not modified.
+func SplitFrames(ds *DataSet, uidGen UIDGenerator) ([]*DataSet, error) {
+	pixelData, err := ds.FindElementByTag(dicomtag.PixelData)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := pixelDataFrames(ds, pixelData)
+	if err != nil {
+		return nil, err
+	}
+	var shared []*Element
+	if seq, err := ds.FindElementByTag(dicomtag.SharedFunctionalGroupsSequence); err == nil && len(seq.Value) > 0 {
+		if item, ok := seq.Value[0].(*Element); ok {
+			shared = itemElements(item)
+		}
+	}
+	var perFrame []interface{}
+	if seq, err := ds.FindElementByTag(dicomtag.PerFrameFunctionalGroupsSequence); err == nil {
+		if len(seq.Value) != len(frames) {
+			return nil, fmt.Errorf("dicom.SplitFrames: PerFrameFunctionalGroupsSequence has %d items, but found %d frames", len(seq.Value), len(frames))
+		}
+		perFrame = seq.Value
+	}
+
+	var result []*DataSet
+	for i, frame := range frames {
+		instance := &DataSet{}
+		for _, elem := range ds.Elements {
+			if !splitFramesDropped[elem.Tag] {
+				instance.Elements = append(instance.Elements, elem)
+			}
+		}
+		copyFunctionalGroups(instance, shared)
+		if perFrame != nil {
+			item, ok := perFrame[i].(*Element)
+			if !ok {
+				return nil, fmt.Errorf("dicom.SplitFrames: PerFrameFunctionalGroupsSequence item %d: not an item", i)
+			}
+			copyFunctionalGroups(instance, itemElements(item))
+		}
+
+		uid, err := uidGen.NewUID()
+		if err != nil {
+			return nil, err
+		}
+		instance.setElement(MustNewElement(dicomtag.SOPInstanceUID, uid))
+		instance.setElement(MustNewElement(dicomtag.MediaStorageSOPInstanceUID, uid))
+		instance.setElement(MustNewElement(dicomtag.InstanceNumber, strconv.Itoa(i+1)))
+		for _, tag := range []dicomtag.Tag{dicomtag.SOPClassUID, dicomtag.MediaStorageSOPClassUID} {
+			elem, err := ds.FindElementByTag(tag)
+			if err != nil {
+				continue
+			}
+			if sopClass, err := elem.GetString(); err == nil && enhancedToClassic[sopClass] != "" {
+				instance.setElement(MustNewElement(tag, enhancedToClassic[sopClass]))
+			}
+		}
+		instance.setElement(&Element{
+			Tag:             dicomtag.PixelData,
+			VR:              pixelData.VR,
+			UndefinedLength: pixelData.UndefinedLength,
+			Value:           []interface{}{PixelDataInfo{Frames: [][]byte{frame}}},
+		})
+		result = append(result, instance)
+	}
+	return result, nil
+}
+
+// copyFunctionalGroups 把一个functional groups item里的attribute复制到ds的top level.
+// 每个functional group是一个只有一个item的SQ(例如PixelMeasuresSequence),
+// 复制的是这个item里的element
+func copyFunctionalGroups(ds *DataSet, groups []*Element) {
+	for _, group := range groups {
+		if group.VR != "SQ" {
+			ds.setElement(group)
+			continue
+		}
+		if len(group.Value) == 0 {
+			continue
+		}
+		if item, ok := group.Value[0].(*Element); ok {
+			for _, elem := range itemElements(item) {
+				ds.setElement(elem)
+			}
+		}
+	}
+}
+
+// pixelDataFrames 返回pixel data的每一个frame. Native pixel data按frame的大小拆开,
+// encapsulated pixel data要求每个frame正好一个fragment
+func pixelDataFrames(ds *DataSet, pixelData *Element) ([][]byte, error) {
+	if len(pixelData.Value) != 1 {
+		return nil, fmt.Errorf("dicom: PixelData must have one value of type PixelDataInfo")
+	}
+	image, ok := pixelData.Value[0].(PixelDataInfo)
+	if !ok {
+		return nil, fmt.Errorf("dicom: PixelData must have one value of type PixelDataInfo")
+	}
+	numFrames, err := intValue(ds, dicomtag.NumberOfFrames, 1)
+	if err != nil {
+		return nil, err
+	}
+	if pixelData.UndefinedLength {
+		if int64(len(image.Frames)) != numFrames {
+			return nil, fmt.Errorf("dicom: found %d fragments in encapsulated PixelData, but NumberOfFrames is %d", len(image.Frames), numFrames)
+		}
+		return image.Frames, nil
+	}
+	if len(image.Frames) != 1 {
+		return image.Frames, nil
+	}
+	if bitsAllocated, err := intValue(ds, dicomtag.BitsAllocated, 0); err != nil || (bitsAllocated < 8 && numFrames > 1) {
+		return nil, fmt.Errorf("dicom: can't split native PixelData with BitsAllocated %d into frames", bitsAllocated)
+	}
+	frameSize, numFrames, err := nativeFrameSize(ds, pixelData)
+	if err != nil {
+		return nil, err
+	}
+	// 不用frameSize*numFrames, 以防损坏的header导致溢出
+	size := int64(len(image.Frames[0]))
+	if frameSize <= 0 || numFrames <= 0 || numFrames > size/frameSize {
+		return nil, fmt.Errorf("dicom: native PixelData has %d bytes, expect %d frames of %d bytes", size, numFrames, frameSize)
+	}
+	var frames [][]byte
+	for i := int64(0); i < numFrames; i++ {
+		frames = append(frames, image.Frames[0][i*frameSize:(i+1)*frameSize])
+	}
+	return frames, nil
+}
+
+// functionalGroup 是MergeFrames生成的一个functional group macro:
+// sequence tag和从classic instance的top level移进去的attributes
+type functionalGroup struct {
+	seq  dicomtag.Tag
+	tags []dicomtag.Tag
+}
+
+// mergeFunctionalGroups 按tag顺序排列. FrameContentSequence单独处理, 见frameContent
+var mergeFunctionalGroups = []functionalGroup{
+	{dicomtag.PlanePositionSequence, []dicomtag.Tag{dicomtag.ImagePositionPatient}},
+	{dicomtag.PlaneOrientationSequence, []dicomtag.Tag{dicomtag.ImageOrientationPatient}},
+	{dicomtag.PixelMeasuresSequence, []dicomtag.Tag{dicomtag.SliceThickness, dicomtag.SpacingBetweenSlices, dicomtag.PixelSpacing}},
+	{dicomtag.FrameVOILUTSequence, []dicomtag.Tag{dicomtag.WindowCenter, dicomtag.WindowWidth, dicomtag.WindowCenterWidthExplanation}},
+	{dicomtag.PixelValueTransformationSequence, []dicomtag.Tag{dicomtag.RescaleIntercept, dicomtag.RescaleSlope, dicomtag.RescaleType}},
+}
+
+// mergeFramesMustMatch 是所有instance必须相同的attributes
+var mergeFramesMustMatch = []dicomtag.Tag{
+	dicomtag.TransferSyntaxUID,
+	dicomtag.SOPClassUID,
+	dicomtag.SeriesInstanceUID,
+	dicomtag.SamplesPerPixel,
+	dicomtag.Rows,
+	dicomtag.Columns,
+	dicomtag.BitsAllocated,
+}
+
+// MergeFrames combines the single-frame CT or MR images "instances", e.g.,
+// a classic series, into one Enhanced CT or Enhanced MR image with a frame
+// per instance, in the given order. It is the inverse of SplitFrames.
+//
+// The instances must share TransferSyntaxUID, SOPClassUID,
+// SeriesInstanceUID and the pixel data dimensions. The attributes of each
+// functional group supported here (plane position and orientation, pixel
+// measures, VOI LUT and pixel value transformation) go into
+// SharedFunctionalGroupsSequence if they are the same in all instances, and
+// into PerFrameFunctionalGroupsSequence otherwise; FrameContentSequence is
+// always per-frame. The other attributes are taken from the first instance.
+// The result gets a new SOPInstanceUID from "uidGen". The instances are not
+// modified.
+func MergeFrames(instances []*DataSet, uidGen UIDGenerator) (*DataSet, error) {
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("dicom.MergeFrames: no instances")
+	}
+	first := instances[0]
+	for _, tag := range mergeFramesMustMatch {
+		want, err := first.FindElementByTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.MergeFrames: instance 0: %v", err)
+		}
+		for i, instance := range instances[1:] {
+			elem, err := instance.FindElementByTag(tag)
+			if err != nil {
+				return nil, fmt.Errorf("dicom.MergeFrames: instance %d: %v", i+1, err)
+			}
+			if !reflect.DeepEqual(elem.Value, want.Value) {
+				return nil, fmt.Errorf("dicom.MergeFrames: instance %d: %v is %v, but %v in instance 0",
+					i+1, dicomtag.DebugString(tag), elem.Value, want.Value)
+			}
+		}
+	}
+	sopClass, err := first.FindElementByTag(dicomtag.SOPClassUID)
+	if err != nil {
+		return nil, err
+	}
+	var enhancedSOPClass string
+	for enhanced, classic := range enhancedToClassic {
+		if len(sopClass.Value) == 1 && sopClass.Value[0] == classic {
+			enhancedSOPClass = enhanced
+		}
+	}
+	if enhancedSOPClass == "" {
+		return nil, fmt.Errorf("dicom.MergeFrames: SOP class %v has no enhanced multi-frame counterpart", sopClass.Value)
+	}
+
+	// Pixel data: 每个instance一个frame
+	var pixelData *Element
+	var frames [][]byte
+	for i, instance := range instances {
+		elem, err := instance.FindElementByTag(dicomtag.PixelData)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.MergeFrames: instance %d: %v", i, err)
+		}
+		instanceFrames, err := pixelDataFrames(instance, elem)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.MergeFrames: instance %d: %v", i, err)
+		}
+		if len(instanceFrames) != 1 {
+			return nil, fmt.Errorf("dicom.MergeFrames: instance %d has %d frames, expect 1", i, len(instanceFrames))
+		}
+		frames = append(frames, instanceFrames[0])
+		pixelData = elem
+	}
+
+	// 每个instance的functional groups. frameGroups[i]是instance i的per-frame functional groups
+	frameGroups := make([][]*Element, len(instances))
+	for i, instance := range instances {
+		if content := frameContent(instance); content != nil {
+			frameGroups[i] = append(frameGroups[i], content)
+		}
+	}
+	var sharedGroups []*Element
+	moved := map[dicomtag.Tag]bool{}
+	for _, group := range mergeFunctionalGroups {
+		items := make([][]*Element, len(instances))
+		found := false
+		for i, instance := range instances {
+			for _, tag := range group.tags {
+				if elem, err := instance.FindElementByTag(tag); err == nil {
+					items[i] = append(items[i], elem)
+					found = true
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+		for _, tag := range group.tags {
+			moved[tag] = true
+		}
+		same := true
+		for _, item := range items[1:] {
+			if !sameElements(item, items[0]) {
+				same = false
+				break
+			}
+		}
+		if same {
+			sharedGroups = append(sharedGroups, MustNewSequence(group.seq, items[0]))
+			continue
+		}
+		for i, item := range items {
+			frameGroups[i] = append(frameGroups[i], MustNewSequence(group.seq, item))
+		}
+	}
+
+	uid, err := uidGen.NewUID()
+	if err != nil {
+		return nil, err
+	}
+	result := &DataSet{}
+	for _, elem := range first.Elements {
+		if !moved[elem.Tag] && elem.Tag != dicomtag.PixelData {
+			result.Elements = append(result.Elements, elem)
+		}
+	}
+	result.setElement(MustNewElement(dicomtag.MediaStorageSOPClassUID, enhancedSOPClass))
+	result.setElement(MustNewElement(dicomtag.MediaStorageSOPInstanceUID, uid))
+	result.setElement(MustNewElement(dicomtag.SOPClassUID, enhancedSOPClass))
+	result.setElement(MustNewElement(dicomtag.SOPInstanceUID, uid))
+	result.setElement(MustNewElement(dicomtag.InstanceNumber, "1"))
+	result.setElement(MustNewElement(dicomtag.NumberOfFrames, strconv.Itoa(len(frames))))
+	result.setElement(MustNewSequence(dicomtag.SharedFunctionalGroupsSequence, sharedGroups))
+	result.setElement(MustNewSequence(dicomtag.PerFrameFunctionalGroupsSequence, frameGroups...))
+	result.setElement(&Element{
+		Tag:             dicomtag.PixelData,
+		VR:              pixelData.VR,
+		UndefinedLength: pixelData.UndefinedLength,
+		Value:           []interface{}{PixelDataInfo{Frames: frames}},
+	})
+	return result, nil
+}
+
+// frameContent 返回instance的FrameContentSequence: AcquisitionNumber变成FrameAcquisitionNumber,
+// AcquisitionDateTime(或者AcquisitionDate加AcquisitionTime)变成FrameAcquisitionDateTime.
+// 都没有时返回nil
+func frameContent(instance *DataSet) *Element {
+	var elems []*Element
+	dateTime := ""
+	if elem, err := instance.FindElementByTag(dicomtag.AcquisitionDateTime); err == nil {
+		dateTime, _ = elem.GetString()
+	} else if date, err := instance.FindElementByTag(dicomtag.AcquisitionDate); err == nil {
+		dateTime, _ = date.GetString()
+		if t, err := instance.FindElementByTag(dicomtag.AcquisitionTime); err == nil && dateTime != "" {
+			s, _ := t.GetString()
+			dateTime += s
+		}
+	}
+	if dateTime != "" {
+		elems = append(elems, MustNewElement(dicomtag.FrameAcquisitionDateTime, dateTime))
+	}
+	if n, err := intValue(instance, dicomtag.AcquisitionNumber, -1); err == nil && n >= 0 && n <= math.MaxUint16 {
+		elems = append(elems, MustNewElement(dicomtag.FrameAcquisitionNumber, uint16(n)))
+	}
+	if len(elems) == 0 {
+		return nil
+	}
+	return MustNewSequence(dicomtag.FrameContentSequence, elems)
+}
+
+// sameElements 检查两组element的tag和value是否都相同
+func sameElements(a, b []*Element) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Tag != b[i].Tag || !reflect.DeepEqual(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
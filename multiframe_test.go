@@ -0,0 +1,200 @@
+package dicom_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testUIDGenerator struct{ n int }
+
+func (g *testUIDGenerator) NewUID() (string, error) {
+	g.n++
+	return fmt.Sprintf("1.2.826.0.1.3680043.2.1143.99.%d", g.n), nil
+}
+
+func mustFindString(t *testing.T, ds *dicom.DataSet, tag dicomtag.Tag) []string {
+	elem, err := ds.FindElementByTag(tag)
+	require.NoError(t, err, dicomtag.DebugString(tag))
+	values, err := elem.GetStrings()
+	require.NoError(t, err)
+	return values
+}
+
+func newEnhancedDataSet(t *testing.T, transferSyntaxUID string) *dicom.DataSet {
+	spec := dicomtest.Spec{TransferSyntaxUID: transferSyntaxUID, Rows: 4, Columns: 4, BitsAllocated: 16, NumberOfFrames: 3}
+	ds, err := dicom.ReadDataSetInBytes(dicomtest.MustBytes(spec), dicom.ReadOptions{})
+	require.NoError(t, err)
+	ds.Replace(dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, dicomuid.EnhancedCTImageStorage), "")
+	ds.Replace(dicom.MustNewElement(dicomtag.SOPClassUID, dicomuid.EnhancedCTImageStorage), "")
+	ds.Replace(dicom.MustNewSequence(dicomtag.SharedFunctionalGroupsSequence, []*dicom.Element{
+		dicom.MustNewSequence(dicomtag.PixelMeasuresSequence, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.PixelSpacing, "0.5", "0.5"),
+		}),
+	}), "")
+	var perFrame [][]*dicom.Element
+	for i := 0; i < 3; i++ {
+		perFrame = append(perFrame, []*dicom.Element{
+			dicom.MustNewSequence(dicomtag.PlanePositionSequence, []*dicom.Element{
+				dicom.MustNewElement(dicomtag.ImagePositionPatient, "0", "0", fmt.Sprint(i*5)),
+			}),
+		})
+	}
+	ds.Replace(dicom.MustNewSequence(dicomtag.PerFrameFunctionalGroupsSequence, perFrame...), "")
+	return ds
+}
+
+func TestSplitFrames(t *testing.T) {
+	for _, uid := range []string{dicomuid.ExplicitVRLittleEndian, dicomtest.RLELossless} {
+		ds := newEnhancedDataSet(t, uid)
+		n := len(ds.Elements)
+		instances, err := dicom.SplitFrames(ds, &testUIDGenerator{})
+		require.NoError(t, err, uid)
+		assert.Len(t, ds.Elements, n, "ds must not be modified")
+		require.Len(t, instances, 3)
+
+		for i, instance := range instances {
+			sopInstanceUID := fmt.Sprintf("1.2.826.0.1.3680043.2.1143.99.%d", i+1)
+			assert.Equal(t, []string{sopInstanceUID}, mustFindString(t, instance, dicomtag.SOPInstanceUID))
+			assert.Equal(t, []string{sopInstanceUID}, mustFindString(t, instance, dicomtag.MediaStorageSOPInstanceUID))
+			assert.Equal(t, []string{dicomuid.CTImageStorage}, mustFindString(t, instance, dicomtag.SOPClassUID))
+			assert.Equal(t, []string{fmt.Sprint(i + 1)}, mustFindString(t, instance, dicomtag.InstanceNumber))
+			assert.Equal(t, []string{"0.5", "0.5"}, mustFindString(t, instance, dicomtag.PixelSpacing))
+			assert.Equal(t, []string{"0", "0", fmt.Sprint(i * 5)}, mustFindString(t, instance, dicomtag.ImagePositionPatient))
+			_, err := instance.FindElementByTag(dicomtag.NumberOfFrames)
+			assert.Error(t, err)
+			_, err = instance.FindElementByTag(dicomtag.PerFrameFunctionalGroupsSequence)
+			assert.Error(t, err)
+			require.NoError(t, instance.CheckStructure())
+
+			// The instance can be written, and holds its frame.
+			instance2, err := dicom.ReadDataSetInBytes(mustWriteDataSet(instance), dicom.ReadOptions{})
+			require.NoError(t, err)
+			pixelData, err := instance2.FindElementByTag(dicomtag.PixelData)
+			require.NoError(t, err)
+			frame := pixelData.Value[0].(dicom.PixelDataInfo).Frames[0]
+			assert.Equal(t, dicomtest.FramePixels(dicomtest.Spec{Rows: 4, Columns: 4, BitsAllocated: 16}, i), frame, uid)
+		}
+	}
+
+	ds := newEnhancedDataSet(t, dicomuid.ExplicitVRLittleEndian)
+	ds.Replace(dicom.MustNewSequence(dicomtag.PerFrameFunctionalGroupsSequence, []*dicom.Element{}), "")
+	_, err := dicom.SplitFrames(ds, &testUIDGenerator{})
+	assert.Error(t, err)
+}
+
+func TestMergeFrames(t *testing.T) {
+	for _, uid := range []string{dicomuid.ExplicitVRLittleEndian, dicomtest.RLELossless} {
+		instances, err := dicom.SplitFrames(newEnhancedDataSet(t, uid), &testUIDGenerator{})
+		require.NoError(t, err)
+		for i, instance := range instances {
+			instance.Replace(dicom.MustNewElement(dicomtag.AcquisitionNumber, fmt.Sprint(i+1)), "")
+		}
+
+		merged, err := dicom.MergeFrames(instances, &testUIDGenerator{n: 10})
+		require.NoError(t, err, uid)
+		require.NoError(t, merged.CheckStructure())
+		assert.Equal(t, []string{dicomuid.EnhancedCTImageStorage}, mustFindString(t, merged, dicomtag.SOPClassUID))
+		assert.Equal(t, []string{"1.2.826.0.1.3680043.2.1143.99.11"}, mustFindString(t, merged, dicomtag.SOPInstanceUID))
+		assert.Equal(t, []string{"3"}, mustFindString(t, merged, dicomtag.NumberOfFrames))
+		_, err = merged.FindElementByTag(dicomtag.ImagePositionPatient)
+		assert.Error(t, err, "moved to the functional groups")
+
+		// Splitting again gives the original attributes and frames.
+		merged2, err := dicom.ReadDataSetInBytes(mustWriteDataSet(merged), dicom.ReadOptions{})
+		require.NoError(t, err)
+		values := map[string]string{}
+		for _, flat := range merged2.Flatten() {
+			if s, err := flat.Element.GetStrings(); err == nil {
+				values[flat.Path] = fmt.Sprint(s)
+			}
+		}
+		assert.Equal(t, "[0.5 0.5]", values["5200,9229[0]/0028,9110[0]/0028,0030"])
+		assert.Equal(t, "[0 0 10]", values["5200,9230[2]/0020,9113[0]/0020,0032"])
+		elem, err := merged2.FindElementByTag(dicomtag.PerFrameFunctionalGroupsSequence)
+		require.NoError(t, err)
+		require.Len(t, elem.Value, 3)
+
+		split, err := dicom.SplitFrames(merged2, &testUIDGenerator{})
+		require.NoError(t, err)
+		require.Len(t, split, 3)
+		for i, instance := range split {
+			assert.Equal(t, []string{"0", "0", fmt.Sprint(i * 5)}, mustFindString(t, instance, dicomtag.ImagePositionPatient))
+			pixelData, err := instance.FindElementByTag(dicomtag.PixelData)
+			require.NoError(t, err)
+			assert.Equal(t, dicomtest.FramePixels(dicomtest.Spec{Rows: 4, Columns: 4, BitsAllocated: 16}, i),
+				pixelData.Value[0].(dicom.PixelDataInfo).Frames[0])
+		}
+	}
+
+	instances, err := dicom.SplitFrames(newEnhancedDataSet(t, dicomuid.ExplicitVRLittleEndian), &testUIDGenerator{})
+	require.NoError(t, err)
+	instances[1].Replace(dicom.MustNewElement(dicomtag.Rows, uint16(8)), "")
+	_, err = dicom.MergeFrames(instances, &testUIDGenerator{})
+	assert.Error(t, err)
+	_, err = dicom.MergeFrames(nil, &testUIDGenerator{})
+	assert.Error(t, err)
+}
+
+func TestCheckFrameCount(t *testing.T) {
+	spec := dicomtest.Spec{TransferSyntaxUID: dicomtest.JPEGBaseline, NumberOfFrames: 3}
+	ds, err := dicom.ReadDataSetInBytes(dicomtest.MustBytes(spec), dicom.ReadOptions{CheckFrameCount: true})
+	require.NoError(t, err)
+	pixelData, err := ds.FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	image := pixelData.Value[0].(dicom.PixelDataInfo)
+
+	ds.Replace(dicom.MustNewElement(dicomtag.NumberOfFrames, "4"), "")
+	err = ds.CheckFrameCount()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "basic offset table has 3 entries, but NumberOfFrames is 4")
+
+	// 没有basic offset table时每个fragment是一个frame
+	pixelData.Value[0] = dicom.PixelDataInfo{Offsets: []uint32{0}, Frames: image.Frames}
+	err = ds.CheckFrameCount()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "found 3 fragments")
+	ds.Replace(dicom.MustNewElement(dicomtag.NumberOfFrames, "3"), "")
+	require.NoError(t, ds.CheckFrameCount())
+
+	// Extended offset table
+	var offsets, lengths []byte
+	for i, offset := range image.Offsets {
+		offsets = append(offsets, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(offsets[i*8:], uint64(offset))
+		lengths = append(lengths, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(lengths[i*8:], uint64(len(image.Frames[i])))
+	}
+	ds.Replace(dicom.MustNewElement(dicomtag.ExtendedOffsetTable, offsets), "")
+	ds.Replace(dicom.MustNewElement(dicomtag.ExtendedOffsetTableLengths, lengths[:16]), "")
+	err = ds.CheckFrameCount()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ExtendedOffsetTableLengths has 2 entries")
+	ds.Replace(dicom.MustNewElement(dicomtag.ExtendedOffsetTableLengths, lengths), "")
+	require.NoError(t, ds.CheckFrameCount())
+	binary.LittleEndian.PutUint64(offsets[8:], 1)
+	err = ds.CheckFrameCount()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ExtendedOffsetTable entry 1 (1) is not at the start of a fragment")
+	binary.LittleEndian.PutUint64(offsets[8:], uint64(image.Offsets[1]))
+
+	// OV elements survive a round trip, and ReadDataSet returns the data set along with the error
+	ds.Replace(dicom.MustNewElement(dicomtag.NumberOfFrames, "5"), "")
+	var buf bytes.Buffer
+	require.NoError(t, dicom.WriteDataSet(&buf, ds))
+	read, err := dicom.ReadDataSetInBytes(buf.Bytes(), dicom.ReadOptions{CheckFrameCount: true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ExtendedOffsetTable has 3 entries, but NumberOfFrames is 5")
+	elem, err := read.FindElementByTag(dicomtag.ExtendedOffsetTable)
+	require.NoError(t, err)
+	assert.Equal(t, "OV", elem.VR)
+	assert.Equal(t, offsets, elem.Value[0])
+}
@@ -0,0 +1,124 @@
+package dicom
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/gobwas/glob"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameMatcher compares person names (PN) for fuzzy semantic matching, as
+// requested by FuzzySemanticMatchingOfPersonNames in C-FIND (P3.4
+// C.2.2.2.1.3) or fuzzymatching=true in QIDO-RS. What counts as a match is
+// up to the implementation.
+type NameMatcher interface {
+	// MatchName reports whether the PN value "value" matches the PN filter
+	// "pattern", which may contain '*' and '?' wildcards.
+	MatchName(pattern, value string) bool
+}
+
+// DefaultNameMatcher ignores case, accents and punctuation ("Müller" matches
+// "MULLER", "O'Brien" matches "OBRIEN"), and matches components that sound
+// alike (Soundex; "Smyth" matches "Smith"). Each non-empty component of the
+// filter (family name, given name, ...) must match the same component of the
+// value; a filter with only a family name matches any given name. Component
+// groups (alphabetic, ideographic, phonetic) are tried separately.
+var DefaultNameMatcher NameMatcher = transliterationNameMatcher{}
+
+type transliterationNameMatcher struct{}
+
+func (transliterationNameMatcher) MatchName(pattern, value string) bool {
+	for _, p := range strings.Split(pattern, "=") {
+		if strings.Trim(p, "^ ") == "" {
+			continue
+		}
+		for _, v := range strings.Split(value, "=") {
+			if matchNameGroup(p, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchNameGroup 比较一个component group ("family^given^middle^prefix^suffix")
+func matchNameGroup(pattern, value string) bool {
+	values := strings.Split(value, "^")
+	for i, p := range strings.Split(pattern, "^") {
+		p = normalizeName(p)
+		if p == "" || p == "*" {
+			continue
+		}
+		if i >= len(values) {
+			return false
+		}
+		if !matchNameComponent(p, normalizeName(values[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchNameComponent(pattern, value string) bool {
+	if strings.ContainsAny(pattern, "*?") {
+		g, err := glob.Compile(pattern)
+		return err == nil && g.Match(value)
+	}
+	if pattern == value {
+		return true
+	}
+	code := soundex(pattern)
+	return code != "" && code == soundex(value)
+}
+
+// normalizeName 去掉重音符号和标点, 转成大写. 通配符'*'和'?'保留
+func normalizeName(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	s, _, err := transform.String(t, s)
+	if err != nil {
+		return ""
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '*' || r == '?' {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
+
+// soundexCodes 是American Soundex中每个字母的数字. 0表示元音(以及H, W, Y)
+var soundexCodes = [26]byte{
+	// A  B    C    D    E  F    G    H  I  J    K    L    M    N    O  P    Q    R    S    T    U  V    W  X    Y  Z
+	0, '1', '2', '3', 0, '1', '2', 0, 0, '2', '2', '4', '5', '5', 0, '1', '2', '6', '2', '3', 0, '1', 0, '2', 0, '2',
+}
+
+// soundex 返回一个大写名字的American Soundex, 例如 "ROBERT" -> "R163".
+// 名字不是以A-Z开头时返回""
+func soundex(name string) string {
+	if name == "" || name[0] < 'A' || name[0] > 'Z' {
+		return ""
+	}
+	code := []byte{name[0]}
+	last := soundexCodes[name[0]-'A']
+	for i := 1; i < len(name) && len(code) < 4; i++ {
+		c := name[i]
+		if c < 'A' || c > 'Z' {
+			continue
+		}
+		digit := soundexCodes[c-'A']
+		if digit != 0 && digit != last {
+			code = append(code, digit)
+		}
+		// H和W不隔开相同的数字, 元音会
+		if c != 'H' && c != 'W' {
+			last = digit
+		}
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
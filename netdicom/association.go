@@ -0,0 +1,78 @@
+package netdicom
+
+import (
+	"fmt"
+
+	"github.com/odincare/odicom/netdicom/pdu"
+)
+
+// AcceptedContext is a presentation context that the peer accepted during
+// association negotiation, with the one transfer syntax that both sides
+// use for it.
+type AcceptedContext struct {
+	ID             byte
+	AbstractSyntax string
+	TransferSyntax string
+}
+
+// AssociationInfo is what was negotiated for an association (P3.8 7.1.1):
+// the AE titles, the presentation contexts and the user information of the
+// peer.
+type AssociationInfo struct {
+	CallingAETitle string
+	CalledAETitle  string
+
+	// Contexts are the accepted presentation contexts, in the proposed
+	// order.
+	Contexts []AcceptedContext
+
+	// PeerMaxPDUSize is the largest P-DATA-TF PDU that the peer accepts,
+	// 0 for no limit. Larger messages are fragmented.
+	PeerMaxPDUSize uint32
+
+	// PeerImplementationClassUID and PeerImplementationVersionName identify
+	// the software of the peer, e.g., for logs.
+	PeerImplementationClassUID    string
+	PeerImplementationVersionName string
+}
+
+// Info returns what was negotiated for the association.
+func (a *ClientAssociation) Info() AssociationInfo {
+	info := a.info
+	info.PeerMaxPDUSize = a.maxPDUSize
+	for _, c := range a.contexts {
+		info.Contexts = append(info.Contexts, AcceptedContext{ID: c.id, AbstractSyntax: c.abstractSyntax, TransferSyntax: c.transferSyntax})
+	}
+	return info
+}
+
+// Abort aborts the association (A-ABORT, P3.8 7.3) and closes the
+// connection, without waiting for the peer, e.g., after an operation failed
+// in a way that leaves the association in an unknown state. Operations in
+// progress fail.
+func (a *ClientAssociation) Abort() error {
+	// service-user initiated, reason not specified
+	err := a.send(&pdu.AAbort{Source: 0, Reason: 0})
+	if cerr := a.conn.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("netdicom.Abort: %w", err)
+	}
+	return nil
+}
+
+// peerUserInformation 从A-ASSOCIATE-AC或RQ的user information item读取对方的信息
+func peerUserInformation(item *pdu.UserInformationItem, info *AssociationInfo) (maxPDUSize uint32) {
+	for _, sub := range item.Items {
+		switch v := sub.(type) {
+		case *pdu.UserInformationMaximumLengthItem:
+			maxPDUSize = v.MaximumLengthReceived
+		case *pdu.ImplementationClassUIDSubItem:
+			info.PeerImplementationClassUID = v.Name
+		case *pdu.ImplementationVersionNameSubItem:
+			info.PeerImplementationVersionName = v.Name
+		}
+	}
+	return maxPDUSize
+}
@@ -0,0 +1,122 @@
+package netdicom_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/odincare/odicom/netdicom/pdu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acceptPeer 接受一个连接, 用reply回答A-ASSOCIATE-RQ, 然后把收到的PDUs送到返回的channel,
+// 直到连接结束. A-RELEASE-RQ被回答
+func acceptPeer(t *testing.T, reply func(rq *pdu.AAssociate) pdu.PDU) (addr string, received <-chan pdu.PDU) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ch := make(chan pdu.PDU, 16)
+	go func() {
+		defer close(ch)
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		p, err := pdu.ReadPDU(conn, pdu.DefaultMaxPDUSize)
+		if err != nil {
+			return
+		}
+		conn.Write(mustEncodePDU(t, reply(p.(*pdu.AAssociate))))
+		for {
+			p, err := pdu.ReadPDU(conn, pdu.DefaultMaxPDUSize)
+			if err != nil {
+				return
+			}
+			ch <- p
+			if _, ok := p.(*pdu.AReleaseRq); ok {
+				conn.Write(mustEncodePDU(t, &pdu.AReleaseRp{}))
+			}
+		}
+	}()
+	return listener.Addr().String(), ch
+}
+
+// acceptFirst 接受第一个presentation context, 用它的第一个transfer syntax
+func acceptFirst(rq *pdu.AAssociate) pdu.PDU {
+	ac := &pdu.AAssociate{
+		PDUType:         pdu.TypeAAssociateAc,
+		ProtocolVersion: 1,
+		CalledAETitle:   rq.CalledAETitle,
+		CallingAETitle:  rq.CallingAETitle,
+		Items:           []pdu.SubItem{&pdu.ApplicationContextItem{Name: "1.2.840.10008.3.1.1.1"}},
+	}
+	for _, item := range rq.Items {
+		pc, ok := item.(*pdu.PresentationContextItem)
+		if !ok {
+			continue
+		}
+		result := &pdu.PresentationContextItem{
+			ItemType: pdu.ItemTypePresentationContextResponse, ContextID: pc.ContextID, Result: 3}
+		if pc.ContextID == 1 {
+			result.Result = pdu.PresentationContextAccepted
+			result.Items = []pdu.SubItem{pc.Items[1]}
+		}
+		ac.Items = append(ac.Items, result)
+	}
+	ac.Items = append(ac.Items, &pdu.UserInformationItem{Items: []pdu.SubItem{
+		&pdu.UserInformationMaximumLengthItem{MaximumLengthReceived: 8192},
+		&pdu.ImplementationClassUIDSubItem{Name: "1.2.3.4"},
+		&pdu.ImplementationVersionNameSubItem{Name: "TESTSCP_1"},
+	}})
+	return ac
+}
+
+func TestDial(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr, received := acceptPeer(t, acceptFirst)
+	a, err := netdicom.Dial(ctx, addr, "TESTSCU", "TESTSCP", []string{dicomuid.VerificationSOPClass, dicomuid.CTImageStorage})
+	require.NoError(t, err)
+	assert.Equal(t, netdicom.AssociationInfo{
+		CallingAETitle:                "TESTSCU",
+		CalledAETitle:                 "TESTSCP",
+		Contexts:                      []netdicom.AcceptedContext{{ID: 1, AbstractSyntax: dicomuid.VerificationSOPClass, TransferSyntax: dicomuid.ImplicitVRLittleEndian}},
+		PeerMaxPDUSize:                8192,
+		PeerImplementationClassUID:    "1.2.3.4",
+		PeerImplementationVersionName: "TESTSCP_1",
+	}, a.Info())
+	require.NoError(t, a.Close())
+	assert.IsType(t, &pdu.AReleaseRq{}, <-received)
+
+	// 没有被接受的presentation context
+	addr, _ = acceptPeer(t, acceptFirst)
+	_, err = netdicom.Dial(ctx, addr, "TESTSCU", "TESTSCP", []string{})
+	require.Error(t, err)
+
+	addr, _ = acceptPeer(t, func(*pdu.AAssociate) pdu.PDU {
+		return &pdu.AAssociateRj{Result: 1, Source: 1, Reason: 7}
+	})
+	_, err = netdicom.Dial(ctx, addr, "TESTSCU", "TESTSCP", []string{dicomuid.VerificationSOPClass})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "A-ASSOCIATE-RJ")
+}
+
+func TestAbort(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr, received := acceptPeer(t, acceptFirst)
+	a, err := netdicom.Dial(ctx, addr, "TESTSCU", "TESTSCP", []string{dicomuid.VerificationSOPClass})
+	require.NoError(t, err)
+	require.NoError(t, a.Abort())
+	assert.IsType(t, &pdu.AAbort{}, <-received)
+	_, ok := <-received
+	assert.False(t, ok, "connection not closed")
+	assert.Error(t, a.Close())
+}
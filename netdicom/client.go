@@ -0,0 +1,228 @@
+package netdicom
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom/pdu"
+)
+
+// proposedTransferSyntaxes 是Dial为每个abstract syntax提议的transfer syntaxes
+var proposedTransferSyntaxes = []string{dicomuid.ImplicitVRLittleEndian, dicomuid.ExplicitVRLittleEndian}
+
+// PresentationContext is a presentation context proposed by
+// DialContexts: an abstract syntax, e.g., a SOP Class UID, and the
+// transfer syntaxes that this application entity can use for it. The peer
+// accepts at most one of the transfer syntaxes.
+type PresentationContext struct {
+	AbstractSyntax   string
+	TransferSyntaxes []string
+}
+
+// acceptedContext 是对方接受的presentation context
+type acceptedContext struct {
+	id             byte
+	abstractSyntax string
+	transferSyntax string
+}
+
+// ClientAssociation is an association requested by this application
+// entity (as SCU) with Dial. It implements Association, so it can be used
+// with a Pool. It is not safe for concurrent use.
+type ClientAssociation struct {
+	dimseConn
+
+	// contexts 是被接受的presentation contexts, 按提议的顺序
+	contexts []acceptedContext
+	// info 是AE titles和对方的implementation, 见Info
+	info AssociationInfo
+
+	messageID uint16
+}
+
+// Dial connects to the application entity "calledAE" at "addr"
+// ("host:port") as "callingAE", and negotiates an association with one
+// presentation context per abstract syntax in "abstractSyntaxes", e.g.,
+// dicomuid.VerificationSOPClass, each proposing Implicit and Explicit VR
+// Little Endian. It is an error if the association is rejected, or if none
+// of the abstract syntaxes is accepted. The deadline of "ctx" applies to
+// the negotiation.
+func Dial(ctx context.Context, addr, callingAE, calledAE string, abstractSyntaxes []string) (*ClientAssociation, error) {
+	var contexts []PresentationContext
+	for _, abstractSyntax := range abstractSyntaxes {
+		contexts = append(contexts, PresentationContext{AbstractSyntax: abstractSyntax, TransferSyntaxes: proposedTransferSyntaxes})
+	}
+	return DialContexts(ctx, addr, callingAE, calledAE, contexts)
+}
+
+// DialContexts is similar to Dial, but proposes the given presentation
+// contexts, e.g., to send compressed images with Store.
+func DialContexts(ctx context.Context, addr, callingAE, calledAE string, contexts []PresentationContext) (*ClientAssociation, error) {
+	if len(contexts) == 0 || len(contexts) > 128 {
+		return nil, fmt.Errorf("netdicom.Dial: %d presentation contexts; need 1 to 128", len(contexts))
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	a := &ClientAssociation{dimseConn: dimseConn{conn: conn}}
+	err = a.withContext(ctx, func() error { return a.associate(callingAE, calledAE, contexts) })
+	if err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("netdicom.Dial: %s@%s: %w", calledAE, addr, err)
+	}
+	return a, nil
+}
+
+func (a *ClientAssociation) associate(callingAE, calledAE string, contexts []PresentationContext) error {
+	rq := &pdu.AAssociate{
+		PDUType:         pdu.TypeAAssociateRq,
+		ProtocolVersion: 1,
+		CalledAETitle:   calledAE,
+		CallingAETitle:  callingAE,
+		Items:           []pdu.SubItem{&pdu.ApplicationContextItem{Name: dicomApplicationContextName}},
+	}
+	for i, pc := range contexts {
+		id := byte(2*i + 1) // context ID是奇数, P3.8 9.3.2.2
+		items := []pdu.SubItem{&pdu.AbstractSyntaxSubItem{Name: pc.AbstractSyntax}}
+		for _, ts := range pc.TransferSyntaxes {
+			items = append(items, &pdu.TransferSyntaxSubItem{Name: ts})
+		}
+		rq.Items = append(rq.Items, &pdu.PresentationContextItem{
+			ItemType: pdu.ItemTypePresentationContextRequest, ContextID: id, Items: items})
+	}
+	rq.Items = append(rq.Items, &pdu.UserInformationItem{Items: []pdu.SubItem{
+		&pdu.UserInformationMaximumLengthItem{MaximumLengthReceived: pdu.DefaultMaxPDUSize},
+		&pdu.ImplementationClassUIDSubItem{Name: dicom.GoDICOMImplementationClassUID},
+		&pdu.ImplementationVersionNameSubItem{Name: dicom.GoDICOMImplementationVersionName},
+	}})
+	if err := a.send(rq); err != nil {
+		return err
+	}
+
+	reply, err := a.receive()
+	if err != nil {
+		return err
+	}
+	if rj, ok := reply.(*pdu.AAssociateRj); ok {
+		return &RejectedError{Result: rj.Result, Source: rj.Source, Reason: rj.Reason}
+	}
+	ac, ok := reply.(*pdu.AAssociate)
+	if !ok || ac.PDUType != pdu.TypeAAssociateAc {
+		return fmt.Errorf("association not accepted: %v", reply)
+	}
+	for _, item := range ac.Items {
+		switch v := item.(type) {
+		case *pdu.PresentationContextItem:
+			i := int(v.ContextID-1) / 2
+			if v.ContextID%2 == 0 || i >= len(contexts) || v.Result != pdu.PresentationContextAccepted {
+				continue
+			}
+			for _, sub := range v.Items {
+				if ts, ok := sub.(*pdu.TransferSyntaxSubItem); ok {
+					a.contexts = append(a.contexts, acceptedContext{
+						id: v.ContextID, abstractSyntax: contexts[i].AbstractSyntax, transferSyntax: ts.Name})
+				}
+			}
+		case *pdu.UserInformationItem:
+			a.maxPDUSize = peerUserInformation(v, &a.info)
+		}
+	}
+	a.info.CallingAETitle, a.info.CalledAETitle = callingAE, calledAE
+	if len(a.contexts) == 0 {
+		return fmt.Errorf("no presentation context accepted")
+	}
+	return nil
+}
+
+// findContext 返回abstractSyntax的第一个被接受的, transfer syntax满足ok的presentation context
+func (a *ClientAssociation) findContext(abstractSyntax string, ok func(transferSyntax string) bool) (acceptedContext, bool) {
+	for _, c := range a.contexts {
+		if c.abstractSyntax == abstractSyntax && ok(c.transferSyntax) {
+			return c, true
+		}
+	}
+	return acceptedContext{}, false
+}
+
+// Echo sends a C-ECHO request and waits for the response (P3.7 9.1.5). It
+// returns an error if the Verification SOP Class wasn't accepted, or the
+// response status isn't Success. The deadline of "ctx" applies.
+func (a *ClientAssociation) Echo(ctx context.Context) error {
+	pc, ok := a.findContext(dicomuid.VerificationSOPClass, func(string) bool { return true })
+	if !ok {
+		return fmt.Errorf("netdicom.Echo: the Verification SOP Class was not accepted")
+	}
+	a.messageID++
+	messageID := a.messageID
+	err := a.withContext(ctx, func() error {
+		err := a.sendCommand(pc.id, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.AffectedSOPClassUID, dicomuid.VerificationSOPClass),
+			dicom.MustNewElement(dicomtag.CommandField, uint16(commandCEchoRq)),
+			dicom.MustNewElement(dicomtag.MessageID, messageID),
+			dicom.MustNewElement(dicomtag.CommandDataSetType, uint16(commandDataSetTypeNull)),
+		})
+		if err != nil {
+			return err
+		}
+		rsp, err := a.receiveCommand()
+		if err != nil {
+			return err
+		}
+		return checkResponse(rsp, commandCEchoRsp, messageID)
+	})
+	if err != nil {
+		return fmt.Errorf("netdicom.Echo: %w", err)
+	}
+	return nil
+}
+
+// Close releases the association (A-RELEASE) and closes the connection. If
+// the peer doesn't reply to the release request within 10 seconds, the
+// connection is closed anyway.
+func (a *ClientAssociation) Close() error {
+	err := a.withTimeout(10*time.Second, func() error {
+		if err := a.send(&pdu.AReleaseRq{}); err != nil {
+			return err
+		}
+		for {
+			p, err := a.receive()
+			if err != nil {
+				return err
+			}
+			if _, ok := p.(*pdu.AReleaseRp); ok {
+				return nil
+			}
+		}
+	})
+	if cerr := a.conn.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("netdicom.Close: %w", err)
+	}
+	return nil
+}
+
+// Echo opens an association with "calledAE" at "addr" as "callingAE",
+// sends a C-ECHO, and releases the association. It returns nil if the peer
+// is reachable and answers the C-ECHO with Success, e.g., to check the
+// connection to a PACS. The deadline of "ctx" applies to the whole
+// exchange.
+func Echo(ctx context.Context, addr, callingAE, calledAE string) error {
+	a, err := Dial(ctx, addr, callingAE, calledAE, []string{dicomuid.VerificationSOPClass})
+	if err != nil {
+		return err
+	}
+	if err := a.Echo(ctx); err != nil {
+		a.Close() // nolint: errcheck
+		return err
+	}
+	return a.Close()
+}
@@ -0,0 +1,292 @@
+package netdicom_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/odincare/odicom/netdicom/pdu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSCP 接受一个association并回答C-ECHO和C-STORE. reject为true时拒绝association
+type fakeSCP struct {
+	reject bool
+	status uint16
+	// findResults 是C-FIND的pending responses的identifiers
+	findResults []*dicom.DataSet
+
+	listener net.Listener
+	echoes   int
+	cancels  int
+	stored   []*dicom.DataSet
+	released bool
+	done     chan struct{}
+}
+
+func newFakeSCP(t *testing.T, reject bool, status uint16) *fakeSCP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSCP{reject: reject, status: status, listener: listener, done: make(chan struct{})}
+	go s.serve(t)
+	return s
+}
+
+func (s *fakeSCP) addr() string { return s.listener.Addr().String() }
+
+func (s *fakeSCP) wait() {
+	<-s.done
+	s.listener.Close()
+}
+
+func (s *fakeSCP) send(t *testing.T, conn net.Conn, p pdu.PDU) {
+	data, err := pdu.EncodePDU(p)
+	require.NoError(t, err)
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+}
+
+func (s *fakeSCP) serve(t *testing.T) {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	p, err := pdu.ReadPDU(conn, pdu.DefaultMaxPDUSize)
+	require.NoError(t, err)
+	assoc := p.(*pdu.AAssociate)
+	assert.Equal(t, "TESTSCU", assoc.CallingAETitle)
+	assert.Equal(t, "ANY-SCP", assoc.CalledAETitle)
+	if s.reject {
+		s.send(t, conn, &pdu.AAssociateRj{Result: 1, Source: 1, Reason: 7})
+		return
+	}
+	ac := &pdu.AAssociate{
+		PDUType:         pdu.TypeAAssociateAc,
+		ProtocolVersion: 1,
+		CalledAETitle:   assoc.CalledAETitle,
+		CallingAETitle:  assoc.CallingAETitle,
+	}
+	// 每个presentation context接受提议的第一个transfer syntax
+	transferSyntaxes := map[byte]string{}
+	for _, item := range assoc.Items {
+		switch v := item.(type) {
+		case *pdu.ApplicationContextItem:
+			ac.Items = append(ac.Items, v)
+		case *pdu.PresentationContextItem:
+			for _, sub := range v.Items {
+				if ts, ok := sub.(*pdu.TransferSyntaxSubItem); ok && transferSyntaxes[v.ContextID] == "" {
+					transferSyntaxes[v.ContextID] = ts.Name
+				}
+			}
+			ac.Items = append(ac.Items, &pdu.PresentationContextItem{
+				ItemType:  pdu.ItemTypePresentationContextResponse,
+				ContextID: v.ContextID,
+				Result:    pdu.PresentationContextAccepted,
+				Items:     []pdu.SubItem{&pdu.TransferSyntaxSubItem{Name: transferSyntaxes[v.ContextID]}},
+			})
+		}
+	}
+	ac.Items = append(ac.Items, &pdu.UserInformationItem{Items: []pdu.SubItem{
+		&pdu.UserInformationMaximumLengthItem{MaximumLengthReceived: 1024}}})
+	s.send(t, conn, ac)
+
+	var command, data []byte
+	var rq map[dicomtag.Tag]*dicom.Element
+	for {
+		p, err := pdu.ReadPDU(conn, pdu.DefaultMaxPDUSize)
+		if err != nil {
+			return
+		}
+		switch v := p.(type) {
+		case *pdu.AReleaseRq:
+			s.released = true
+			s.send(t, conn, &pdu.AReleaseRp{})
+			return
+		case *pdu.PDataTf:
+			require.Len(t, v.Items, 1)
+			item := v.Items[0]
+			assert.True(t, len(item.Value)+6 <= 1024)
+			if item.Command {
+				command = append(command, item.Value...)
+				if !item.Last {
+					continue
+				}
+				rq = decodeTestCommand(t, command)
+				command = nil
+				if rq[dicomtag.CommandDataSetType].MustGetUInt16() != 0x0101 {
+					continue
+				}
+			} else {
+				data = append(data, item.Value...)
+				if !item.Last {
+					continue
+				}
+				d := dicomio.NewBytesDecoderWithTransferSyntax(data, transferSyntaxes[item.ContextID])
+				ds := &dicom.DataSet{}
+				for !d.EOF() {
+					ds.Elements = append(ds.Elements, dicom.ReadElement(d, dicom.ReadOptions{}))
+				}
+				require.NoError(t, d.Finish())
+				s.stored = append(s.stored, ds)
+				data = nil
+			}
+			s.respond(t, conn, item.ContextID, rq)
+		default:
+			t.Errorf("unexpected PDU %v", p)
+			return
+		}
+	}
+}
+
+func decodeTestCommand(t *testing.T, data []byte) map[dicomtag.Tag]*dicom.Element {
+	d := dicomio.NewBytesDecoder(data, binary.LittleEndian, dicomio.ImplicitVR)
+	rq := map[dicomtag.Tag]*dicom.Element{}
+	for !d.EOF() {
+		elem := dicom.ReadElement(d, dicom.ReadOptions{})
+		rq[elem.Tag] = elem
+	}
+	require.NoError(t, d.Finish())
+	assert.Equal(t, uint32(len(data)-12), rq[dicomtag.CommandGroupLength].MustGetUInt32())
+	return rq
+}
+
+func (s *fakeSCP) respond(t *testing.T, conn net.Conn, contextID byte, rq map[dicomtag.Tag]*dicom.Element) {
+	commandField := rq[dicomtag.CommandField].MustGetUInt16()
+	switch commandField {
+	case 0x0030:
+		assert.Equal(t, dicomuid.VerificationSOPClass, rq[dicomtag.AffectedSOPClassUID].MustGetString())
+		s.echoes++
+	case 0x0001:
+		assert.Equal(t, uint16(0x0000), rq[dicomtag.CommandDataSetType].MustGetUInt16())
+	case 0x0020:
+		for _, result := range s.findResults {
+			e := dicomio.NewBytesEncoderWithTransferSyntax(dicomuid.ImplicitVRLittleEndian)
+			for _, elem := range result.Elements {
+				dicom.WriteElement(e, elem)
+			}
+			require.NoError(t, e.Error())
+			s.sendCommand(t, conn, contextID, rq, commandField, 0x0000, 0xff00)
+			s.send(t, conn, &pdu.PDataTf{Items: []pdu.PresentationDataValueItem{
+				{ContextID: contextID, Last: true, Value: e.Bytes()}}})
+		}
+	case 0x0021:
+		// 2个sub-operations: 一个pending response, 然后最后的response
+		assert.Equal(t, "DEST", rq[dicomtag.MoveDestination].MustGetString())
+		s.sendCommand(t, conn, contextID, rq, commandField, 0x0101, 0xff00,
+			dicom.MustNewElement(dicomtag.NumberOfRemainingSuboperations, uint16(1)),
+			dicom.MustNewElement(dicomtag.NumberOfCompletedSuboperations, uint16(1)),
+			dicom.MustNewElement(dicomtag.NumberOfFailedSuboperations, uint16(0)),
+			dicom.MustNewElement(dicomtag.NumberOfWarningSuboperations, uint16(0)))
+		failed := uint16(0)
+		if s.status == 0xb000 {
+			failed = 1
+		}
+		counts := []*dicom.Element{
+			dicom.MustNewElement(dicomtag.NumberOfCompletedSuboperations, 2-failed),
+			dicom.MustNewElement(dicomtag.NumberOfFailedSuboperations, failed),
+			dicom.MustNewElement(dicomtag.NumberOfWarningSuboperations, uint16(0)),
+		}
+		if failed == 0 {
+			s.sendCommand(t, conn, contextID, rq, commandField, 0x0101, s.status, counts...)
+			return
+		}
+		s.sendCommand(t, conn, contextID, rq, commandField, 0x0000, s.status, counts...)
+		e := dicomio.NewBytesEncoderWithTransferSyntax(dicomuid.ImplicitVRLittleEndian)
+		dicom.WriteElement(e, dicom.MustNewElement(dicomtag.FailedSOPInstanceUIDList, "1.2.3.4"))
+		require.NoError(t, e.Error())
+		s.send(t, conn, &pdu.PDataTf{Items: []pdu.PresentationDataValueItem{
+			{ContextID: contextID, Last: true, Value: e.Bytes()}}})
+		return
+	case 0x0fff:
+		// C-CANCEL没有response. fakeSCP已经发送了所有的结果
+		s.cancels++
+		return
+	default:
+		t.Errorf("unexpected command 0x%04x", commandField)
+	}
+	s.sendCommand(t, conn, contextID, rq, commandField, 0x0101, s.status)
+}
+
+func (s *fakeSCP) sendCommand(t *testing.T, conn net.Conn, contextID byte, rq map[dicomtag.Tag]*dicom.Element, commandField, dataSetType, status uint16, extra ...*dicom.Element) {
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ImplicitVR)
+	for _, elem := range append([]*dicom.Element{
+		dicom.MustNewElement(dicomtag.CommandGroupLength, uint32(0)),
+		dicom.MustNewElement(dicomtag.AffectedSOPClassUID, rq[dicomtag.AffectedSOPClassUID].MustGetString()),
+		dicom.MustNewElement(dicomtag.CommandField, commandField|0x8000),
+		dicom.MustNewElement(dicomtag.MessageIDBeingRespondedTo, rq[dicomtag.MessageID].MustGetUInt16()),
+		dicom.MustNewElement(dicomtag.CommandDataSetType, dataSetType),
+		dicom.MustNewElement(dicomtag.Status, status),
+	}, extra...) {
+		dicom.WriteElement(e, elem)
+	}
+	require.NoError(t, e.Error())
+	s.send(t, conn, &pdu.PDataTf{Items: []pdu.PresentationDataValueItem{
+		{ContextID: contextID, Command: true, Last: true, Value: e.Bytes()}}})
+}
+
+func TestEcho(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newFakeSCP(t, false, 0)
+	require.NoError(t, netdicom.Echo(ctx, s.addr(), "TESTSCU", "ANY-SCP"))
+	s.wait()
+	assert.Equal(t, 1, s.echoes)
+	assert.True(t, s.released)
+
+	// 一个association上可以发多个C-ECHO
+	s = newFakeSCP(t, false, 0)
+	a, err := netdicom.Dial(ctx, s.addr(), "TESTSCU", "ANY-SCP", []string{dicomuid.VerificationSOPClass})
+	require.NoError(t, err)
+	var _ netdicom.Association = a
+	require.NoError(t, a.Echo(ctx))
+	require.NoError(t, a.Echo(ctx))
+	require.NoError(t, a.Close())
+	s.wait()
+	assert.Equal(t, 2, s.echoes)
+
+	s = newFakeSCP(t, false, 0xc000)
+	err = netdicom.Echo(ctx, s.addr(), "TESTSCU", "ANY-SCP")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0xc000")
+	s.wait()
+	assert.True(t, s.released)
+
+	s = newFakeSCP(t, true, 0)
+	err = netdicom.Echo(ctx, s.addr(), "TESTSCU", "ANY-SCP")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "A-ASSOCIATE-RJ")
+	s.wait()
+}
+
+func TestEchoTimeout(t *testing.T) {
+	// 接受TCP连接但不回答A-ASSOCIATE-RQ
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = netdicom.Echo(ctx, listener.Addr().String(), "TESTSCU", "ANY-SCP")
+	require.Error(t, err)
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
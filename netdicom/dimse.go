@@ -0,0 +1,361 @@
+package netdicom
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/netdicom/pdu"
+)
+
+// dicomApplicationContextName 是A-ASSOCIATE-RQ的application context, P3.7 A.2.1
+const dicomApplicationContextName = "1.2.840.10008.3.1.1.1"
+
+// DIMSE command fields, P3.7 E.1
+const (
+	commandCEchoRq  = 0x0030
+	commandCEchoRsp = 0x8030
+)
+
+// commandDataSetTypeNull 表示command后面没有data set, P3.7 E.1
+const commandDataSetTypeNull = 0x0101
+
+// DIMSE statuses, P3.7 C
+const (
+	StatusSuccess             = 0x0000
+	StatusProcessingFailure   = 0x0110
+	StatusUnrecognizedCommand = 0x0211
+	StatusOutOfResources      = 0xa700
+	StatusCannotUnderstand    = 0xc000
+)
+
+// StatusError is an error with a DIMSE status, e.g., StatusOutOfResources.
+// The client operations return it, possibly wrapped, when the peer responds
+// with a failure status, and a StorageHandler returns it to choose the
+// status of the C-STORE response.
+type StatusError struct {
+	Status  uint16
+	Comment string
+}
+
+func (e *StatusError) Error() string {
+	if e.Comment == "" {
+		return fmt.Sprintf("netdicom: status 0x%04x", e.Status)
+	}
+	return fmt.Sprintf("netdicom: status 0x%04x: %s", e.Status, e.Comment)
+}
+
+// RejectedError is returned, possibly wrapped, by Dial when the peer
+// rejects the association with an A-ASSOCIATE-RJ, P3.8 9.3.4.
+type RejectedError struct {
+	// Result 1是rejected-permanent, 2是rejected-transient
+	Result byte
+	Source byte
+	Reason byte
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("association rejected: A-ASSOCIATE-RJ{result:%d source:%d reason:%d}", e.Result, e.Source, e.Reason)
+}
+
+// abortedError 是对方发送A-ABORT时的error
+type abortedError struct {
+	abort *pdu.AAbort
+}
+
+func (e *abortedError) Error() string {
+	return fmt.Sprintf("association aborted: %v", e.abort)
+}
+
+// dimseConn 是ClientAssociation和serverAssociation共用的PDU和DIMSE message层
+type dimseConn struct {
+	conn net.Conn
+
+	// maxPDUSize 是对方能接受的最大PDU payload, 0表示不限制
+	maxPDUSize uint32
+
+	// maxReceive 是自己能接受的最大PDU payload, 0表示pdu.DefaultMaxPDUSize
+	maxReceive uint32
+
+	asm assembler
+	// received 是已经组合好但还没有被receiveMessage返回的messages
+	received []*message
+}
+
+// withContext 在ctx的deadline或cancel时中断对conn的读写
+func (c *dimseConn) withContext(ctx context.Context, op func() error) error {
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Unix(1, 0)) // nolint: errcheck
+		case <-done:
+		}
+	}()
+	err := op()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (c *dimseConn) withTimeout(timeout time.Duration, op func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.withContext(ctx, op)
+}
+
+func (c *dimseConn) send(p pdu.PDU) error {
+	data, err := pdu.EncodePDU(p)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(data)
+	return err
+}
+
+// receive 读取下一个PDU. A-ABORT变成error
+func (c *dimseConn) receive() (pdu.PDU, error) {
+	maxReceive := int(c.maxReceive)
+	if maxReceive == 0 {
+		maxReceive = pdu.DefaultMaxPDUSize
+	}
+	p, err := pdu.ReadPDU(c.conn, maxReceive)
+	if err != nil {
+		return nil, err
+	}
+	if abort, ok := p.(*pdu.AAbort); ok {
+		return nil, &abortedError{abort}
+	}
+	return p, nil
+}
+
+// sendCommand 编码并发送一个command
+func (c *dimseConn) sendCommand(contextID byte, elems []*dicom.Element) error {
+	data, err := encodeCommand(elems)
+	if err != nil {
+		return err
+	}
+	return c.sendPDVs(contextID, true, data)
+}
+
+// sendPDVs 发送command或data set, 分成不超过对方最大PDU的fragments
+func (c *dimseConn) sendPDVs(contextID byte, command bool, data []byte) error {
+	// 每个PDV有6 bytes的header (length和message control header)
+	fragmentSize := len(data)
+	if c.maxPDUSize > 6 && int(c.maxPDUSize)-6 < fragmentSize {
+		fragmentSize = int(c.maxPDUSize) - 6
+	}
+	if len(data) == 0 {
+		// 空的data也要发送一个PDV, 对方才知道message结束了
+		item := pdu.PresentationDataValueItem{ContextID: contextID, Command: command, Last: true}
+		return c.send(&pdu.PDataTf{Items: []pdu.PresentationDataValueItem{item}})
+	}
+	for len(data) > 0 {
+		n := fragmentSize
+		if n > len(data) {
+			n = len(data)
+		}
+		item := pdu.PresentationDataValueItem{ContextID: contextID, Command: command, Last: n == len(data), Value: data[:n]}
+		if err := c.send(&pdu.PDataTf{Items: []pdu.PresentationDataValueItem{item}}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// receiveCommand 读取下一个完整的message, 返回它的command set
+func (c *dimseConn) receiveCommand() (*dicom.DataSet, error) {
+	msg, err := c.receiveMessage()
+	if err != nil {
+		return nil, err
+	}
+	return msg.command, nil
+}
+
+// receiveMessage 读取下一个完整的message
+func (c *dimseConn) receiveMessage() (*message, error) {
+	for len(c.received) == 0 {
+		p, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.(*pdu.AReleaseRq); ok {
+			// 对方要结束association, 不会再回答. P3.8 7.2
+			c.send(&pdu.AReleaseRp{}) // nolint: errcheck
+			return nil, fmt.Errorf("association released by the peer")
+		}
+		pdata, ok := p.(*pdu.PDataTf)
+		if !ok {
+			return nil, fmt.Errorf("expected P-DATA-TF, got %v", p)
+		}
+		msgs, err := c.asm.add(pdata)
+		if err != nil {
+			return nil, err
+		}
+		c.received = msgs
+	}
+	msg := c.received[0]
+	c.received = c.received[1:]
+	return msg, nil
+}
+
+// message 是一个DIMSE message, P3.7 6.3
+type message struct {
+	contextID byte
+	command   *dicom.DataSet
+	// data 是编码的data set, 没有data set时为nil
+	data []byte
+}
+
+// assembler 把P-DATA-TF的fragments组合成messages
+type assembler struct {
+	command []byte
+	data    []byte
+	// pending 是已经收到command set, 还在等data set的message
+	pending *message
+	// maxSize 是一个command set或data set最多的bytes, 0表示不限制.
+	// 对方可以一直发送没有Last的fragments, 所以需要这个限制
+	maxSize int
+}
+
+// checkSize 检查把n bytes的fragment加到已有的size bytes之后是否超过maxSize
+func (a *assembler) checkSize(size, n int) error {
+	if a.maxSize > 0 && n > a.maxSize-size {
+		return fmt.Errorf("message exceeds the limit of %d bytes", a.maxSize)
+	}
+	return nil
+}
+
+// add 加上pdata的PDVs, 返回因此完整的messages
+func (a *assembler) add(pdata *pdu.PDataTf) ([]*message, error) {
+	var msgs []*message
+	for _, item := range pdata.Items {
+		if item.Command {
+			if a.pending != nil {
+				return nil, fmt.Errorf("command fragment while waiting for a data set")
+			}
+			if err := a.checkSize(len(a.command), len(item.Value)); err != nil {
+				return nil, err
+			}
+			a.command = append(a.command, item.Value...)
+			if !item.Last {
+				continue
+			}
+			command, err := decodeCommand(a.command)
+			a.command = nil
+			if err != nil {
+				return nil, err
+			}
+			msg := &message{contextID: item.ContextID, command: command}
+			if v, err := commandUInt16(command, dicomtag.CommandDataSetType); err == nil && v == commandDataSetTypeNull {
+				msgs = append(msgs, msg)
+			} else {
+				a.pending = msg
+			}
+			continue
+		}
+		if a.pending == nil {
+			return nil, fmt.Errorf("data set fragment without a command")
+		}
+		if item.ContextID != a.pending.contextID {
+			return nil, fmt.Errorf("data set fragment for presentation context %d, expected %d", item.ContextID, a.pending.contextID)
+		}
+		if err := a.checkSize(len(a.data), len(item.Value)); err != nil {
+			return nil, err
+		}
+		a.data = append(a.data, item.Value...)
+		if item.Last {
+			a.pending.data = a.data
+			if a.pending.data == nil {
+				a.pending.data = []byte{}
+			}
+			msgs = append(msgs, a.pending)
+			a.pending, a.data = nil, nil
+		}
+	}
+	return msgs, nil
+}
+
+// encodeCommand 把command elements编码成Implicit VR Little Endian (P3.7 6.3.1),
+// 前面加上CommandGroupLength
+func encodeCommand(elems []*dicom.Element) ([]byte, error) {
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ImplicitVR)
+	for _, elem := range elems {
+		dicom.WriteElement(e, elem)
+	}
+	if e.Error() != nil {
+		return nil, e.Error()
+	}
+	body := e.Bytes()
+	e = dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ImplicitVR)
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.CommandGroupLength, uint32(len(body))))
+	e.WriteBytes(body)
+	if e.Error() != nil {
+		return nil, e.Error()
+	}
+	return e.Bytes(), nil
+}
+
+func decodeCommand(data []byte) (*dicom.DataSet, error) {
+	d := dicomio.NewBytesDecoder(data, binary.LittleEndian, dicomio.ImplicitVR)
+	ds := &dicom.DataSet{}
+	for !d.EOF() {
+		elem := dicom.ReadElement(d, dicom.ReadOptions{})
+		if elem == nil {
+			break
+		}
+		ds.Elements = append(ds.Elements, elem)
+	}
+	if err := d.Finish(); err != nil {
+		return nil, fmt.Errorf("invalid command set: %v", err)
+	}
+	return ds, nil
+}
+
+func commandUInt16(command *dicom.DataSet, tag dicomtag.Tag) (uint16, error) {
+	elem, err := command.FindElementByTag(tag)
+	if err != nil {
+		return 0, err
+	}
+	return elem.GetUInt16()
+}
+
+func commandString(command *dicom.DataSet, tag dicomtag.Tag) (string, error) {
+	elem, err := command.FindElementByTag(tag)
+	if err != nil {
+		return "", err
+	}
+	return elem.GetString()
+}
+
+// checkResponse 检查response的CommandField, MessageIDBeingRespondedTo和Status.
+// Warning (0x0001, 0xbxxx) 也算成功
+func checkResponse(rsp *dicom.DataSet, commandField, messageID uint16) error {
+	if v, err := commandUInt16(rsp, dicomtag.CommandField); err != nil || v != commandField {
+		return fmt.Errorf("unexpected response: CommandField 0x%04x (%v)", v, err)
+	}
+	if v, err := commandUInt16(rsp, dicomtag.MessageIDBeingRespondedTo); err != nil || v != messageID {
+		return fmt.Errorf("response to message %d, expected %d (%v)", v, messageID, err)
+	}
+	status, err := commandUInt16(rsp, dicomtag.Status)
+	if err != nil {
+		return err
+	}
+	if status != StatusSuccess && status != 0x0001 && status&0xf000 != 0xb000 {
+		comment, _ := commandString(rsp, dicomtag.ErrorComment)
+		return &StatusError{Status: status, Comment: comment}
+	}
+	return nil
+}
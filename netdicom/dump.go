@@ -0,0 +1,217 @@
+// Package netdicom implements the DICOM network protocol (P3.7, P3.8) on top
+// of the dataset codec in package dicom.
+package netdicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom/pdu"
+)
+
+// commandFieldNames maps the CommandField (0000,0100) values to names. P3.7 E.1.
+var commandFieldNames = map[uint16]string{
+	0x0001: "C-STORE-RQ",
+	0x8001: "C-STORE-RSP",
+	0x0010: "C-GET-RQ",
+	0x8010: "C-GET-RSP",
+	0x0020: "C-FIND-RQ",
+	0x8020: "C-FIND-RSP",
+	0x0021: "C-MOVE-RQ",
+	0x8021: "C-MOVE-RSP",
+	0x0030: "C-ECHO-RQ",
+	0x8030: "C-ECHO-RSP",
+	0x0100: "N-EVENT-REPORT-RQ",
+	0x8100: "N-EVENT-REPORT-RSP",
+	0x0110: "N-GET-RQ",
+	0x8110: "N-GET-RSP",
+	0x0120: "N-SET-RQ",
+	0x8120: "N-SET-RSP",
+	0x0130: "N-ACTION-RQ",
+	0x8130: "N-ACTION-RSP",
+	0x0140: "N-CREATE-RQ",
+	0x8140: "N-CREATE-RSP",
+	0x0150: "N-DELETE-RQ",
+	0x8150: "N-DELETE-RSP",
+	0x0FFF: "C-CANCEL-RQ",
+}
+
+// StreamDumper decodes the PDUs exchanged in one association and prints
+// them in a human-readable form. It remembers the presentation contexts
+// negotiated by A-ASSOCIATE-RQ/AC, so that datasets carried in P-DATA-TF are
+// decoded with the right transfer syntax. Command and data fragments are
+// reassembled before being printed.
+//
+// StreamDumper is meant for troubleshooting; it never fails on malformed
+// input, but prints what it could not decode instead.
+type StreamDumper struct {
+	out io.Writer
+
+	// Abstract syntax and transfer syntax per presentation context ID. The
+	// transfer syntax is the first proposed one until A-ASSOCIATE-AC is seen.
+	abstractSyntaxes map[byte]string
+	transferSyntaxes map[byte]string
+
+	// Fragments of command and data sets not yet terminated by a PDV with the
+	// "last" bit set, per presentation context ID.
+	commands map[byte][]byte
+	datasets map[byte][]byte
+}
+
+// NewStreamDumper creates a StreamDumper that writes to "out".
+func NewStreamDumper(out io.Writer) *StreamDumper {
+	return &StreamDumper{
+		out:              out,
+		abstractSyntaxes: make(map[byte]string),
+		transferSyntaxes: make(map[byte]string),
+		commands:         make(map[byte][]byte),
+		datasets:         make(map[byte][]byte),
+	}
+}
+
+// DumpPDU prints one PDU. "label" is printed in front of every line, and is
+// typically used to show the direction of the PDU, e.g., "10.0.0.1:4242 ->
+// 10.0.0.2:104". It may be empty.
+func (s *StreamDumper) DumpPDU(label string, p pdu.PDU) {
+	prefix := ""
+	if label != "" {
+		prefix = "[" + label + "] "
+	}
+
+	switch v := p.(type) {
+	case *pdu.AAssociate:
+		s.printf("%s%v called:'%s' calling:'%s'\n", prefix, v.PDUType, v.CalledAETitle, v.CallingAETitle)
+		for _, item := range v.Items {
+			s.printf("%s  %v\n", prefix, item)
+			if pc, ok := item.(*pdu.PresentationContextItem); ok {
+				s.notePresentationContext(pc)
+			}
+		}
+	case *pdu.PDataTf:
+		s.printf("%s%v\n", prefix, v)
+		for _, item := range v.Items {
+			s.addFragment(prefix, item)
+		}
+	default:
+		s.printf("%s%v\n", prefix, p)
+	}
+}
+
+// DumpError prints a decoding error in the same format as DumpPDU.
+func (s *StreamDumper) DumpError(label string, err error) {
+	prefix := ""
+	if label != "" {
+		prefix = "[" + label + "] "
+	}
+	s.printf("%sERROR: %v\n", prefix, err)
+}
+
+func (s *StreamDumper) printf(format string, args ...interface{}) {
+	fmt.Fprintf(s.out, format, args...)
+}
+
+func (s *StreamDumper) notePresentationContext(pc *pdu.PresentationContextItem) {
+	for _, item := range pc.Items {
+		switch v := item.(type) {
+		case *pdu.AbstractSyntaxSubItem:
+			s.abstractSyntaxes[pc.ContextID] = v.Name
+		case *pdu.TransferSyntaxSubItem:
+			if pc.ItemType == pdu.ItemTypePresentationContextResponse {
+				s.transferSyntaxes[pc.ContextID] = v.Name
+			} else if _, ok := s.transferSyntaxes[pc.ContextID]; !ok {
+				s.transferSyntaxes[pc.ContextID] = v.Name
+			}
+		}
+	}
+}
+
+func (s *StreamDumper) addFragment(prefix string, item pdu.PresentationDataValueItem) {
+	if item.Command {
+		s.commands[item.ContextID] = append(s.commands[item.ContextID], item.Value...)
+		if !item.Last {
+			return
+		}
+		data := s.commands[item.ContextID]
+		delete(s.commands, item.ContextID)
+		s.printf("%s  command (context %d, %s):\n", prefix, item.ContextID,
+			dicomuid.UIDString(s.abstractSyntaxes[item.ContextID]))
+		// Command set 总是 Implicit VR Little Endian, P3.7 6.3.1
+		s.dumpElements(prefix, data, dicomuid.ImplicitVRLittleEndian, true)
+		return
+	}
+
+	s.datasets[item.ContextID] = append(s.datasets[item.ContextID], item.Value...)
+	if !item.Last {
+		return
+	}
+	data := s.datasets[item.ContextID]
+	delete(s.datasets, item.ContextID)
+	transferSyntaxUID, ok := s.transferSyntaxes[item.ContextID]
+	if !ok {
+		transferSyntaxUID = dicomuid.ImplicitVRLittleEndian
+	}
+	s.printf("%s  dataset (context %d, %s):\n", prefix, item.ContextID, dicomuid.UIDString(transferSyntaxUID))
+	s.dumpElements(prefix, data, transferSyntaxUID, false)
+}
+
+func (s *StreamDumper) dumpElements(prefix string, data []byte, transferSyntaxUID string, command bool) {
+	d := dicomio.NewBytesDecoderWithTransferSyntax(data, transferSyntaxUID)
+	for !d.EOF() {
+		elem := dicom.ReadElement(d, dicom.ReadOptions{})
+		if elem == nil {
+			break
+		}
+		line := elem.String()
+		if command && elem.Tag == dicomtag.CommandField {
+			if v, err := elem.GetUInt16(); err == nil {
+				if name, ok := commandFieldNames[v]; ok {
+					line += " " + name
+				}
+			}
+		}
+		s.printf("%s   %s\n", prefix, strings.TrimSpace(line))
+	}
+	if d.Error() != nil {
+		s.printf("%s   ERROR: %v\n", prefix, d.Error())
+	}
+}
+
+// maxDumpPDUSize is the largest PDU payload decoded by DumpStream and
+// DumpPCAP. A larger length is most likely garbage, e.g., a stream that
+// doesn't start at a PDU boundary.
+const maxDumpPDUSize = 1 << 26
+
+// DumpStream decodes a raw byte stream of concatenated PDUs (e.g., one
+// direction of a TCP connection saved by "tcpflow", or both directions
+// interleaved at PDU boundaries) and prints them to "out". It stops at the
+// first PDU that can't be read, including one longer than 64 MiB, and prints
+// and returns the error.
+func DumpStream(out io.Writer, in io.Reader) error {
+	s := NewStreamDumper(out)
+	for {
+		p, err := pdu.ReadPDU(in, maxDumpPDUSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			s.DumpError("", err)
+			return err
+		}
+		s.DumpPDU("", p)
+	}
+}
+
+// pduLength returns the total size of the PDU at the start of "data",
+// including the header, or -1 if "data" is shorter than a header.
+func pduLength(data []byte) int64 {
+	if len(data) < pdu.HeaderSize {
+		return -1
+	}
+	return int64(pdu.HeaderSize) + int64(binary.BigEndian.Uint32(data[2:]))
+}
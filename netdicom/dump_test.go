@@ -0,0 +1,179 @@
+package netdicom_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/odincare/odicom/netdicom/pdu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustEncodePDU(t *testing.T, p pdu.PDU) []byte {
+	data, err := pdu.EncodePDU(p)
+	require.NoError(t, err)
+	return data
+}
+
+// echoExchange returns A-ASSOCIATE-RQ, A-ASSOCIATE-AC and a P-DATA-TF
+// carrying a C-ECHO-RQ command split in two fragments.
+func echoExchange(t *testing.T) [][]byte {
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ImplicitVR)
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.AffectedSOPClassUID, dicomuid.VerificationSOPClass))
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.CommandField, uint16(0x0030)))
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.MessageID, uint16(1)))
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.CommandDataSetType, uint16(0x0101)))
+	command := e.Bytes()
+
+	rq := &pdu.AAssociate{
+		PDUType:        pdu.TypeAAssociateRq,
+		CalledAETitle:  "PACS",
+		CallingAETitle: "GOSCU",
+		Items: []pdu.SubItem{&pdu.PresentationContextItem{
+			ItemType:  pdu.ItemTypePresentationContextRequest,
+			ContextID: 1,
+			Items: []pdu.SubItem{
+				&pdu.AbstractSyntaxSubItem{Name: dicomuid.VerificationSOPClass},
+				&pdu.TransferSyntaxSubItem{Name: dicomuid.ImplicitVRLittleEndian},
+			},
+		}},
+	}
+	ac := &pdu.AAssociate{
+		PDUType:        pdu.TypeAAssociateAc,
+		CalledAETitle:  "PACS",
+		CallingAETitle: "GOSCU",
+		Items: []pdu.SubItem{&pdu.PresentationContextItem{
+			ItemType:  pdu.ItemTypePresentationContextResponse,
+			ContextID: 1,
+			Items:     []pdu.SubItem{&pdu.TransferSyntaxSubItem{Name: dicomuid.ImplicitVRLittleEndian}},
+		}},
+	}
+	data := &pdu.PDataTf{Items: []pdu.PresentationDataValueItem{
+		{ContextID: 1, Command: true, Value: command[:10]},
+		{ContextID: 1, Command: true, Last: true, Value: command[10:]},
+	}}
+	return [][]byte{mustEncodePDU(t, rq), mustEncodePDU(t, ac), mustEncodePDU(t, data), mustEncodePDU(t, &pdu.AReleaseRq{})}
+}
+
+func TestDumpStream(t *testing.T) {
+	out := &bytes.Buffer{}
+	require.NoError(t, netdicom.DumpStream(out, bytes.NewReader(bytes.Join(echoExchange(t), nil))))
+	s := out.String()
+	assert.Contains(t, s, "A-ASSOCIATE-RQ called:'PACS' calling:'GOSCU'")
+	assert.Contains(t, s, "A-ASSOCIATE-AC")
+	assert.Contains(t, s, "C-ECHO-RQ")
+	assert.Contains(t, s, "A-RELEASE-RQ")
+
+	// 太长的PDU是error, 不会按length分配内存
+	out.Reset()
+	bogus := []byte{0x04, 0, 0xff, 0xff, 0xff, 0xf0, 1, 2, 3, 4}
+	err := netdicom.DumpStream(out, bytes.NewReader(append(echoExchange(t)[0], bogus...)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds the limit")
+	assert.Contains(t, out.String(), "A-ASSOCIATE-RQ")
+	assert.Contains(t, out.String(), "ERROR")
+}
+
+// pcapWriter builds a little-endian pcap file with Ethernet/IPv4/TCP frames.
+type pcapWriter struct {
+	buf bytes.Buffer
+}
+
+func newPCAPWriter() *pcapWriter {
+	w := &pcapWriter{}
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	w.buf.Write(header)
+	return w
+}
+
+func (w *pcapWriter) segment(srcIP, dstIP byte, srcPort, dstPort uint16, seq uint32, syn bool, payload []byte) {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 5 << 4
+	if syn {
+		tcp[13] = 0x02
+	}
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)+len(payload)))
+	ip[9] = 6
+	copy(ip[12:], []byte{10, 0, 0, srcIP})
+	copy(ip[16:], []byte{10, 0, 0, dstIP})
+	frame := make([]byte, 14)
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	frame = append(append(append(frame, ip...), tcp...), payload...)
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+	w.buf.Write(record)
+	w.buf.Write(frame)
+}
+
+func TestDumpPCAP(t *testing.T) {
+	pdus := echoExchange(t)
+	w := newPCAPWriter()
+	w.segment(1, 2, 4000, 104, 100, true, nil)
+	w.segment(2, 1, 104, 4000, 500, true, nil)
+	// A-ASSOCIATE-RQ split into two segments, delivered out of order.
+	rq := pdus[0]
+	w.segment(1, 2, 4000, 104, 101+10, false, rq[10:])
+	w.segment(1, 2, 4000, 104, 101, false, rq[:10])
+	w.segment(2, 1, 104, 4000, 501, false, pdus[1])
+	seq := uint32(101 + len(rq))
+	w.segment(1, 2, 4000, 104, seq, false, pdus[2])
+	// Retransmission of the P-DATA-TF.
+	w.segment(1, 2, 4000, 104, seq, false, pdus[2])
+	// Unrelated traffic.
+	w.segment(1, 3, 5000, 80, 1, false, []byte("GET / HTTP/1.0\r\n"))
+
+	out := &bytes.Buffer{}
+	require.NoError(t, netdicom.DumpPCAP(out, &w.buf, 104))
+	s := out.String()
+	assert.Contains(t, s, "[10.0.0.1:4000 -> 10.0.0.2:104] A-ASSOCIATE-RQ")
+	assert.Contains(t, s, "[10.0.0.2:104 -> 10.0.0.1:4000] A-ASSOCIATE-AC")
+	assert.Equal(t, 1, strings.Count(s, "C-ECHO-RQ"))
+	assert.NotContains(t, s, "ERROR")
+}
+
+func TestDumpPCAPGap(t *testing.T) {
+	pdus := echoExchange(t)
+	w := newPCAPWriter()
+	w.segment(1, 2, 4000, 104, 100, true, nil)
+	w.segment(1, 2, 4000, 104, 101, false, pdus[0])
+	// The next 10 bytes were not captured; the later segments are buffered
+	// only up to a limit.
+	seq := uint32(101 + len(pdus[0]) + 10)
+	chunk := make([]byte, 60000)
+	for i := 0; i < 300; i++ {
+		w.segment(1, 2, 4000, 104, seq, false, chunk)
+		seq += uint32(len(chunk))
+	}
+	// Another connection with a bogus PDU length.
+	w.segment(3, 2, 4001, 104, 100, true, nil)
+	w.segment(3, 2, 4001, 104, 101, false, []byte{0x04, 0, 0xff, 0xff, 0xff, 0xf0, 1, 2})
+	w.segment(3, 2, 4001, 104, 109, false, pdus[0])
+
+	out := &bytes.Buffer{}
+	require.NoError(t, netdicom.DumpPCAP(out, &w.buf, 104))
+	s := out.String()
+	assert.Contains(t, s, "[10.0.0.1:4000 -> 10.0.0.2:104] A-ASSOCIATE-RQ")
+	assert.Contains(t, s, "[10.0.0.1:4000 -> 10.0.0.2:104] ERROR: capture gap: 10 bytes missing")
+	assert.Equal(t, 1, strings.Count(s, "capture gap"))
+	assert.Contains(t, s, "[10.0.0.3:4001 -> 10.0.0.2:104] ERROR: PDU length 4294967280 exceeds the limit")
+	assert.Equal(t, 1, strings.Count(s, "A-ASSOCIATE-RQ"))
+}
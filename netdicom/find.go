@@ -0,0 +1,243 @@
+package netdicom
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// DIMSE command fields, P3.7 E.1
+const (
+	commandCFindRq   = 0x0020
+	commandCCancelRq = 0x0fff
+)
+
+// DIMSE statuses of C-FIND and C-MOVE, P3.4 C.4.1.1.4
+const (
+	StatusPending                = 0xff00
+	StatusPendingWarning         = 0xff01
+	StatusCancel                 = 0xfe00
+	StatusIdentifierDoesNotMatch = 0xa900
+)
+
+// QueryLevel is QueryRetrieveLevel (0008,0052) of a query, P3.4 C.3.
+type QueryLevel string
+
+const (
+	QueryLevelPatient QueryLevel = "PATIENT"
+	QueryLevelStudy   QueryLevel = "STUDY"
+	QueryLevelSeries  QueryLevel = "SERIES"
+	QueryLevelImage   QueryLevel = "IMAGE"
+)
+
+// queryModels 返回level可以使用的Query/Retrieve information models, 按优先顺序.
+// PATIENT level只有Patient Root有
+func queryModels(level QueryLevel, studyRoot, patientRoot string) []string {
+	if level == QueryLevelPatient {
+		return []string{patientRoot}
+	}
+	return []string{studyRoot, patientRoot}
+}
+
+// NewIdentifier returns a C-FIND identifier with the given matching and
+// return keys. A nil or "" value is a return key: it matches anything, and
+// asks the peer to return the attribute. Other values must have the types
+// accepted by dicom.NewElement, e.g., "Doe^*" for PatientName or
+// "20200101-20201231" for StudyDate.
+func NewIdentifier(keys map[dicomtag.Tag]interface{}) (*dicom.DataSet, error) {
+	ds := &dicom.DataSet{}
+	for tag, value := range keys {
+		var values []interface{}
+		if value != nil && value != "" {
+			values = []interface{}{value}
+		}
+		elem, err := dicom.NewElement(tag, values...)
+		if err != nil {
+			return nil, fmt.Errorf("netdicom.NewIdentifier: %v", err)
+		}
+		ds.Elements = append(ds.Elements, elem)
+	}
+	sort.Slice(ds.Elements, func(i, j int) bool { return ds.Elements[i].Tag.Compare(ds.Elements[j].Tag) < 0 })
+	return ds, nil
+}
+
+// queryIdentifier 返回identifier的副本: 去掉file meta group, 设置QueryRetrieveLevel
+func queryIdentifier(identifier *dicom.DataSet, level QueryLevel) *dicom.DataSet {
+	ds := &dicom.DataSet{}
+	if identifier != nil {
+		for _, elem := range identifier.Elements {
+			if elem.Tag.Group != dicomtag.MetadataGroup && elem.Tag != dicomtag.QueryRetrieveLevel {
+				ds.Elements = append(ds.Elements, elem)
+			}
+		}
+	}
+	ds.Elements = append(ds.Elements, dicom.MustNewElement(dicomtag.QueryRetrieveLevel, string(level)))
+	sort.SliceStable(ds.Elements, func(i, j int) bool { return ds.Elements[i].Tag.Compare(ds.Elements[j].Tag) < 0 })
+	return ds
+}
+
+// encodeIdentifier 用transferSyntaxUID编码identifier
+func encodeIdentifier(identifier *dicom.DataSet, transferSyntaxUID string) ([]byte, error) {
+	e := dicomio.NewBytesEncoderWithTransferSyntax(transferSyntaxUID)
+	for _, elem := range identifier.Elements {
+		dicom.WriteElement(e, elem)
+	}
+	if err := e.Error(); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// decodeIdentifier 解码response的identifier, SpecificCharacterSet用于之后的strings
+func decodeIdentifier(data []byte, transferSyntaxUID string) (*dicom.DataSet, error) {
+	d := dicomio.NewBytesDecoderWithTransferSyntax(data, transferSyntaxUID)
+	ds := &dicom.DataSet{}
+	for !d.EOF() {
+		elem := dicom.ReadElement(d, dicom.ReadOptions{})
+		if elem == nil {
+			break
+		}
+		if elem.Tag == dicomtag.SpecificCharacterSet {
+			if names, err := elem.GetStrings(); err == nil {
+				if cs, err := dicomio.ParseSpecificCharacterSet(names); err == nil {
+					d.SetCodingSystem(cs)
+				}
+			}
+		}
+		ds.Elements = append(ds.Elements, elem)
+	}
+	if err := d.Finish(); err != nil {
+		return nil, fmt.Errorf("invalid identifier: %v", err)
+	}
+	return ds, nil
+}
+
+// Find sends a C-FIND request (P3.4 C.4.1) at "level" with "identifier",
+// and calls "fn" with the identifier of each match as it arrives. The
+// QueryRetrieveLevel of "identifier" is replaced by "level"; see
+// NewIdentifier to build one. The Study Root information model is used if
+// it was accepted, else Patient Root, which is the only one for
+// QueryLevelPatient. Dial with dicomuid.StudyRootQRFind and/or
+// dicomuid.PatientRootQRFind.
+//
+// If "fn" returns an error, Find sends a C-CANCEL, waits for the final
+// response, and returns the error. Otherwise it returns nil when the peer
+// reports that the query is complete. The deadline of "ctx" applies to the
+// whole query.
+func (a *ClientAssociation) Find(ctx context.Context, level QueryLevel, identifier *dicom.DataSet, fn func(*dicom.DataSet) error) error {
+	pc, ok := a.queryContext(level, dicomuid.StudyRootQRFind, dicomuid.PatientRootQRFind)
+	if !ok {
+		return fmt.Errorf("netdicom.Find: no Query/Retrieve FIND model accepted for level %s", level)
+	}
+	_, err := a.query(ctx, "netdicom.Find", pc, commandCFindRq, nil, level, identifier, func(msg *message) error {
+		if msg.data == nil {
+			return nil
+		}
+		match, err := decodeIdentifier(msg.data, pc.transferSyntax)
+		if err != nil {
+			return fmt.Errorf("netdicom.Find: %v", err)
+		}
+		return fn(match)
+	})
+	return err
+}
+
+// queryContext 返回level可以使用的第一个被接受的information model的presentation context
+func (a *ClientAssociation) queryContext(level QueryLevel, studyRoot, patientRoot string) (acceptedContext, bool) {
+	for _, model := range queryModels(level, studyRoot, patientRoot) {
+		if pc, ok := a.findContext(model, func(string) bool { return true }); ok {
+			return pc, true
+		}
+	}
+	return acceptedContext{}, false
+}
+
+// query 发送C-FIND或C-MOVE request, 对每个pending response调用pending, 返回最后的
+// response message. pending返回error时发送C-CANCEL, 等待最后的response, 然后原样返回这个error.
+// 其他error的前面加上name. extra是request的其他command elements
+func (a *ClientAssociation) query(ctx context.Context, name string, pc acceptedContext, commandField uint16, extra []*dicom.Element,
+	level QueryLevel, identifier *dicom.DataSet, pending func(*message) error) (*message, error) {
+	data, err := encodeIdentifier(queryIdentifier(identifier, level), pc.transferSyntax)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	a.messageID++
+	messageID := a.messageID
+	var final *message
+	var pendingErr error
+	err = a.withContext(ctx, func() error {
+		err := a.sendCommand(pc.id, append([]*dicom.Element{
+			dicom.MustNewElement(dicomtag.AffectedSOPClassUID, pc.abstractSyntax),
+			dicom.MustNewElement(dicomtag.CommandField, commandField),
+			dicom.MustNewElement(dicomtag.MessageID, messageID),
+			dicom.MustNewElement(dicomtag.Priority, uint16(0)),
+			dicom.MustNewElement(dicomtag.CommandDataSetType, uint16(commandDataSetTypePresent)),
+		}, extra...))
+		if err != nil {
+			return err
+		}
+		if err := a.sendPDVs(pc.id, false, data); err != nil {
+			return err
+		}
+		for {
+			msg, err := a.receiveMessage()
+			if err != nil {
+				return err
+			}
+			status, err := commandUInt16(msg.command, dicomtag.Status)
+			if err != nil {
+				return err
+			}
+			if status != StatusPending && status != StatusPendingWarning {
+				final = msg
+				if status == StatusCancel && pendingErr != nil {
+					return nil
+				}
+				return checkResponse(msg.command, commandField|0x8000, messageID)
+			}
+			if pendingErr != nil {
+				// 已经取消了, 忽略剩下的pending responses
+				continue
+			}
+			if pendingErr = pending(msg); pendingErr != nil {
+				err := a.sendCommand(pc.id, []*dicom.Element{
+					dicom.MustNewElement(dicomtag.CommandField, uint16(commandCCancelRq)),
+					dicom.MustNewElement(dicomtag.MessageIDBeingRespondedTo, messageID),
+					dicom.MustNewElement(dicomtag.CommandDataSetType, uint16(commandDataSetTypeNull)),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+	if pendingErr != nil {
+		return final, pendingErr
+	}
+	if err != nil {
+		return final, fmt.Errorf("%s: %w", name, err)
+	}
+	return final, nil
+}
+
+// Find opens an association with "calledAE" at "addr" as "callingAE",
+// sends a C-FIND, and releases the association. It proposes the Patient
+// Root information model for QueryLevelPatient and the Study Root one
+// otherwise. See ClientAssociation.Find.
+func Find(ctx context.Context, addr, callingAE, calledAE string, level QueryLevel, identifier *dicom.DataSet, fn func(*dicom.DataSet) error) error {
+	a, err := Dial(ctx, addr, callingAE, calledAE, queryModels(level, dicomuid.StudyRootQRFind, dicomuid.PatientRootQRFind)[:1])
+	if err != nil {
+		return err
+	}
+	if err := a.Find(ctx, level, identifier, fn); err != nil {
+		a.Close() // nolint: errcheck
+		return err
+	}
+	return a.Close()
+}
@@ -0,0 +1,99 @@
+package netdicom_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIdentifier(t *testing.T) {
+	ds, err := netdicom.NewIdentifier(map[dicomtag.Tag]interface{}{
+		dicomtag.StudyInstanceUID: nil,
+		dicomtag.PatientName:      "Doe^*",
+		dicomtag.StudyDate:        "",
+	})
+	require.NoError(t, err)
+	require.Len(t, ds.Elements, 3)
+	assert.Equal(t, dicomtag.StudyDate, ds.Elements[0].Tag)
+	assert.Empty(t, ds.Elements[0].Value)
+	assert.Equal(t, "Doe^*", ds.Elements[1].MustGetString())
+	assert.Equal(t, dicomtag.StudyInstanceUID, ds.Elements[2].Tag)
+
+	_, err = netdicom.NewIdentifier(map[dicomtag.Tag]interface{}{dicomtag.Rows: "x"})
+	assert.Error(t, err)
+}
+
+func TestFind(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	identifier, err := netdicom.NewIdentifier(map[dicomtag.Tag]interface{}{
+		dicomtag.PatientName:      "Doe^*",
+		dicomtag.StudyInstanceUID: nil,
+	})
+	require.NoError(t, err)
+	s := newFakeSCP(t, false, 0)
+	for _, uid := range []string{"1.2.3", "1.2.4", "1.2.5"} {
+		s.findResults = append(s.findResults, &dicom.DataSet{Elements: []*dicom.Element{
+			dicom.MustNewElement(dicomtag.SpecificCharacterSet, "ISO_IR 192"),
+			dicom.MustNewElement(dicomtag.QueryRetrieveLevel, "STUDY"),
+			dicom.MustNewElement(dicomtag.PatientName, "Doe^John"),
+			dicom.MustNewElement(dicomtag.StudyInstanceUID, uid),
+		}})
+	}
+	var uids []string
+	err = netdicom.Find(ctx, s.addr(), "TESTSCU", "ANY-SCP", netdicom.QueryLevelStudy, identifier, func(ds *dicom.DataSet) error {
+		elem, err := ds.FindElementByTag(dicomtag.StudyInstanceUID)
+		require.NoError(t, err)
+		uids = append(uids, elem.MustGetString())
+		return nil
+	})
+	require.NoError(t, err)
+	s.wait()
+	assert.Equal(t, []string{"1.2.3", "1.2.4", "1.2.5"}, uids)
+	assert.True(t, s.released)
+	// 发送的identifier加上了QueryRetrieveLevel
+	require.Len(t, s.stored, 1)
+	elem, err := s.stored[0].FindElementByTag(dicomtag.QueryRetrieveLevel)
+	require.NoError(t, err)
+	assert.Equal(t, "STUDY", elem.MustGetString())
+	elem, err = s.stored[0].FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Doe^*", elem.MustGetString())
+
+	// fn的error取消query
+	results := s.findResults
+	s = newFakeSCP(t, false, 0)
+	s.findResults = results
+	stop := errors.New("enough")
+	n := 0
+	a, err := netdicom.Dial(ctx, s.addr(), "TESTSCU", "ANY-SCP", []string{dicomuid.StudyRootQRFind})
+	require.NoError(t, err)
+	err = a.Find(ctx, netdicom.QueryLevelSeries, identifier, func(ds *dicom.DataSet) error {
+		n++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, n)
+	// association还可以用
+	require.NoError(t, a.Find(ctx, netdicom.QueryLevelStudy, identifier, func(*dicom.DataSet) error { return nil }))
+	// PATIENT level需要Patient Root
+	require.Error(t, a.Find(ctx, netdicom.QueryLevelPatient, identifier, func(*dicom.DataSet) error { return nil }))
+	require.NoError(t, a.Close())
+	s.wait()
+	assert.Equal(t, 1, s.cancels)
+
+	s = newFakeSCP(t, false, 0xa900)
+	err = netdicom.Find(ctx, s.addr(), "TESTSCU", "ANY-SCP", netdicom.QueryLevelPatient, identifier, func(*dicom.DataSet) error { return nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0xa900")
+	s.wait()
+}
@@ -0,0 +1,106 @@
+package netdicom
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// DIMSE command fields, P3.7 E.1
+const commandCMoveRq = 0x0021
+
+// MoveProgress is the state of a C-MOVE, from a pending or the final
+// response, P3.4 C.4.2.1.
+type MoveProgress struct {
+	// Status of the response, e.g., StatusPending.
+	Status uint16
+
+	// The numbers of C-STORE sub-operations. Remaining is only in pending
+	// responses; -1 means that the peer didn't send the number.
+	Remaining, Completed, Failed, Warning int
+
+	// FailedSOPInstanceUIDs is FailedSOPInstanceUIDList (0008,0058) of the
+	// final response, if the peer sent it.
+	FailedSOPInstanceUIDs []string
+}
+
+// moveProgress 从C-MOVE response读取MoveProgress
+func moveProgress(msg *message, transferSyntaxUID string) MoveProgress {
+	p := MoveProgress{}
+	p.Status, _ = commandUInt16(msg.command, dicomtag.Status)
+	for _, f := range []struct {
+		tag dicomtag.Tag
+		n   *int
+	}{
+		{dicomtag.NumberOfRemainingSuboperations, &p.Remaining},
+		{dicomtag.NumberOfCompletedSuboperations, &p.Completed},
+		{dicomtag.NumberOfFailedSuboperations, &p.Failed},
+		{dicomtag.NumberOfWarningSuboperations, &p.Warning},
+	} {
+		if v, err := commandUInt16(msg.command, f.tag); err == nil {
+			*f.n = int(v)
+		} else {
+			*f.n = -1
+		}
+	}
+	if msg.data != nil {
+		if ds, err := decodeIdentifier(msg.data, transferSyntaxUID); err == nil {
+			if elem, err := ds.FindElementByTag(dicomtag.FailedSOPInstanceUIDList); err == nil {
+				p.FailedSOPInstanceUIDs, _ = elem.GetStrings()
+			}
+		}
+	}
+	return p
+}
+
+// Move sends a C-MOVE request (P3.4 C.4.2) that asks the peer to send the
+// instances matching "identifier" at "level" to the application entity
+// "destination" with C-STOREs, and waits until the peer reports that the
+// transfer is done. "fn", if not nil, is called with the progress in each
+// pending response, e.g., to display it. The Study Root information model
+// is used if it was accepted, else Patient Root, which is the only one for
+// QueryLevelPatient. Dial with dicomuid.StudyRootQRMove and/or
+// dicomuid.PatientRootQRMove.
+//
+// It returns the progress in the final response. If some sub-operations
+// failed, the status is a warning (0xB000) and Move returns no error; check
+// Failed and FailedSOPInstanceUIDs. If "fn" returns an error, Move sends a
+// C-CANCEL, waits for the final response, and returns the error. The
+// deadline of "ctx" applies to the whole transfer.
+func (a *ClientAssociation) Move(ctx context.Context, destination string, level QueryLevel, identifier *dicom.DataSet, fn func(MoveProgress) error) (MoveProgress, error) {
+	pc, ok := a.queryContext(level, dicomuid.StudyRootQRMove, dicomuid.PatientRootQRMove)
+	if !ok {
+		return MoveProgress{}, fmt.Errorf("netdicom.Move: no Query/Retrieve MOVE model accepted for level %s", level)
+	}
+	extra := []*dicom.Element{dicom.MustNewElement(dicomtag.MoveDestination, destination)}
+	final, err := a.query(ctx, "netdicom.Move", pc, commandCMoveRq, extra, level, identifier, func(msg *message) error {
+		if fn == nil {
+			return nil
+		}
+		return fn(moveProgress(msg, pc.transferSyntax))
+	})
+	if final == nil {
+		return MoveProgress{}, err
+	}
+	return moveProgress(final, pc.transferSyntax), err
+}
+
+// Move opens an association with "calledAE" at "addr" as "callingAE",
+// sends a C-MOVE to "destination", and releases the association. It
+// proposes the Patient Root information model for QueryLevelPatient and
+// the Study Root one otherwise. See ClientAssociation.Move.
+func Move(ctx context.Context, addr, callingAE, calledAE, destination string, level QueryLevel, identifier *dicom.DataSet, fn func(MoveProgress) error) (MoveProgress, error) {
+	a, err := Dial(ctx, addr, callingAE, calledAE, queryModels(level, dicomuid.StudyRootQRMove, dicomuid.PatientRootQRMove)[:1])
+	if err != nil {
+		return MoveProgress{}, err
+	}
+	progress, err := a.Move(ctx, destination, level, identifier, fn)
+	if err != nil {
+		a.Close() // nolint: errcheck
+		return progress, err
+	}
+	return progress, a.Close()
+}
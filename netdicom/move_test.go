@@ -0,0 +1,53 @@
+package netdicom_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMove(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	identifier, err := netdicom.NewIdentifier(map[dicomtag.Tag]interface{}{dicomtag.StudyInstanceUID: "1.2.3"})
+	require.NoError(t, err)
+
+	s := newFakeSCP(t, false, 0)
+	var pending []netdicom.MoveProgress
+	progress, err := netdicom.Move(ctx, s.addr(), "TESTSCU", "ANY-SCP", "DEST", netdicom.QueryLevelStudy, identifier,
+		func(p netdicom.MoveProgress) error {
+			pending = append(pending, p)
+			return nil
+		})
+	require.NoError(t, err)
+	s.wait()
+	assert.True(t, s.released)
+	assert.Equal(t, []netdicom.MoveProgress{
+		{Status: netdicom.StatusPending, Remaining: 1, Completed: 1, Failed: 0, Warning: 0}}, pending)
+	assert.Equal(t, netdicom.MoveProgress{Status: 0, Remaining: -1, Completed: 2}, progress)
+	require.Len(t, s.stored, 1)
+	elem, err := s.stored[0].FindElementByTag(dicomtag.QueryRetrieveLevel)
+	require.NoError(t, err)
+	assert.Equal(t, "STUDY", elem.MustGetString())
+
+	// 部分失败是warning, 不是error
+	s = newFakeSCP(t, false, 0xb000)
+	progress, err = netdicom.Move(ctx, s.addr(), "TESTSCU", "ANY-SCP", "DEST", netdicom.QueryLevelPatient, identifier, nil)
+	require.NoError(t, err)
+	s.wait()
+	assert.Equal(t, netdicom.MoveProgress{Status: 0xb000, Remaining: -1, Completed: 1, Failed: 1,
+		FailedSOPInstanceUIDs: []string{"1.2.3.4"}}, progress)
+
+	// Move destination unknown
+	s = newFakeSCP(t, false, 0xa801)
+	progress, err = netdicom.Move(ctx, s.addr(), "TESTSCU", "ANY-SCP", "DEST", netdicom.QueryLevelSeries, identifier, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0xa801")
+	assert.Equal(t, uint16(0xa801), progress.Status)
+	s.wait()
+}
@@ -0,0 +1,307 @@
+package netdicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/odincare/odicom/netdicom/pdu"
+)
+
+// pcap link types we know how to strip. http://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+)
+
+// tcpEndpoint is one side of a TCP connection.
+type tcpEndpoint struct {
+	ip   string
+	port uint16
+}
+
+func (e tcpEndpoint) String() string {
+	return net.JoinHostPort(e.ip, fmt.Sprint(e.port))
+}
+
+// tcpFlowKey identifies one direction of a TCP connection.
+type tcpFlowKey struct {
+	src, dst tcpEndpoint
+}
+
+// maxPendingSize is the number of bytes that a tcpFlow holds ahead of a
+// missing segment. Beyond that, the segment is assumed not to have been
+// captured at all.
+const maxPendingSize = 1 << 24
+
+// tcpFlow reassembles the payload of one direction of a TCP connection.
+type tcpFlow struct {
+	label   string
+	started bool
+	nextSeq uint32
+	// Segments that arrived ahead of nextSeq, keyed by sequence number, and
+	// their total size.
+	pending     map[uint32][]byte
+	pendingSize int
+	// Reassembled bytes not yet consumed as complete PDUs.
+	buf []byte
+	// lost is set once the PDU boundaries are lost, because of a capture gap
+	// or a bogus PDU length. The rest of the flow is ignored.
+	lost bool
+}
+
+// tcpConnection holds both directions of one association.
+type tcpConnection struct {
+	dumper *StreamDumper
+	flows  map[tcpFlowKey]*tcpFlow
+}
+
+// DumpPCAP decodes the DICOM associations found in a libpcap capture file
+// (as produced by "tcpdump -w") and prints them to "out". TCP streams are
+// reassembled per connection; each connection is decoded by its own
+// StreamDumper, so the presentation contexts of concurrent associations
+// don't get mixed up.
+//
+// Only TCP segments with source or destination port "port" are decoded. If
+// port is 0, every TCP segment is assumed to carry DICOM traffic.
+//
+// Supported link types are Ethernet, Linux cooked capture, BSD loopback, and
+// raw IP. The pcapng format is not supported.
+func DumpPCAP(out io.Writer, in io.Reader, port uint16) error {
+	var header [24]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		return fmt.Errorf("netdicom.DumpPCAP: failed to read file header: %v", err)
+	}
+
+	var byteOrder binary.ByteOrder
+	switch binary.LittleEndian.Uint32(header[0:]) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		byteOrder = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		byteOrder = binary.BigEndian
+	default:
+		return fmt.Errorf("netdicom.DumpPCAP: not a pcap file (magic %x)", header[0:4])
+	}
+	linkType := byteOrder.Uint32(header[20:])
+
+	connections := make(map[tcpFlowKey]*tcpConnection)
+	var record [16]byte
+	for {
+		if _, err := io.ReadFull(in, record[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("netdicom.DumpPCAP: failed to read record header: %v", err)
+		}
+		capturedLength := byteOrder.Uint32(record[8:])
+		if capturedLength > 1<<26 {
+			return fmt.Errorf("netdicom.DumpPCAP: corrupt record length %d", capturedLength)
+		}
+		packet := make([]byte, capturedLength)
+		if _, err := io.ReadFull(in, packet); err != nil {
+			return fmt.Errorf("netdicom.DumpPCAP: failed to read packet: %v", err)
+		}
+
+		src, dst, seq, syn, payload, ok := parseTCPPacket(linkType, packet)
+		if !ok || (port != 0 && src.port != port && dst.port != port) {
+			continue
+		}
+
+		key := tcpFlowKey{src, dst}
+		connKey := key
+		if _, found := connections[tcpFlowKey{dst, src}]; found {
+			connKey = tcpFlowKey{dst, src}
+		}
+		conn, found := connections[connKey]
+		if !found {
+			conn = &tcpConnection{dumper: NewStreamDumper(out), flows: make(map[tcpFlowKey]*tcpFlow)}
+			connections[connKey] = conn
+		}
+		flow, found := conn.flows[key]
+		if !found {
+			flow = &tcpFlow{label: src.String() + " -> " + dst.String(), pending: make(map[uint32][]byte)}
+			conn.flows[key] = flow
+		}
+		if syn {
+			flow.started = true
+			flow.nextSeq = seq + 1
+			continue
+		}
+		if len(payload) == 0 {
+			continue
+		}
+		if !flow.started {
+			// The capture started in the middle of the connection.
+			flow.started = true
+			flow.nextSeq = seq
+		}
+		if err := flow.addSegment(seq, payload); err != nil {
+			conn.dumper.DumpError(flow.label, err)
+		}
+		flow.drainPDUs(conn.dumper)
+	}
+}
+
+// addSegment appends the segment to the reassembled stream, handling
+// retransmissions and out-of-order delivery. It returns an error when it
+// gives up waiting for a missing segment.
+func (f *tcpFlow) addSegment(seq uint32, payload []byte) error {
+	if f.lost {
+		return nil
+	}
+	for {
+		delta := int32(seq - f.nextSeq)
+		switch {
+		case delta > 0:
+			// Arrived early; wait for the gap to be filled.
+			if _, ok := f.pending[seq]; !ok {
+				f.pending[seq] = payload
+				f.pendingSize += len(payload)
+			}
+			if f.pendingSize <= maxPendingSize {
+				return nil
+			}
+			missing := delta
+			for pendingSeq := range f.pending {
+				if d := int32(pendingSeq - f.nextSeq); d < missing {
+					missing = d
+				}
+			}
+			f.lose()
+			return fmt.Errorf("capture gap: %d bytes missing at sequence number %d; ignoring the rest of the stream", missing, f.nextSeq)
+		case delta < 0:
+			// (Partial) retransmission.
+			if int64(-delta) >= int64(len(payload)) {
+				payload = nil
+			} else {
+				payload = payload[-delta:]
+			}
+		}
+		f.buf = append(f.buf, payload...)
+		f.nextSeq += uint32(len(payload))
+
+		next, ok := f.pending[f.nextSeq]
+		if !ok {
+			return nil
+		}
+		delete(f.pending, f.nextSeq)
+		f.pendingSize -= len(next)
+		seq, payload = f.nextSeq, next
+	}
+}
+
+// lose drops the buffered data once the PDU boundaries are lost.
+func (f *tcpFlow) lose() {
+	f.lost = true
+	f.pending, f.pendingSize, f.buf = nil, 0, nil
+}
+
+// drainPDUs dumps every complete PDU found in the reassembled stream.
+func (f *tcpFlow) drainPDUs(dumper *StreamDumper) {
+	for {
+		n := pduLength(f.buf)
+		if n > pdu.HeaderSize+maxDumpPDUSize {
+			dumper.DumpError(f.label, fmt.Errorf("PDU length %d exceeds the limit %d; ignoring the rest of the stream",
+				n-pdu.HeaderSize, maxDumpPDUSize))
+			f.lose()
+			return
+		}
+		if n < 0 || int64(len(f.buf)) < n {
+			return
+		}
+		p, err := pdu.DecodePDU(f.buf[:n])
+		if err != nil {
+			dumper.DumpError(f.label, err)
+		} else {
+			dumper.DumpPDU(f.label, p)
+		}
+		f.buf = f.buf[n:]
+	}
+}
+
+// parseTCPPacket strips the link, IP and TCP headers from a captured packet.
+// ok is false if the packet isn't a TCP segment over IPv4 or IPv6.
+func parseTCPPacket(linkType uint32, packet []byte) (src, dst tcpEndpoint, seq uint32, syn bool, payload []byte, ok bool) {
+	var ipPacket []byte
+	switch linkType {
+	case linkTypeEthernet:
+		if len(packet) < 14 {
+			return
+		}
+		etherType := binary.BigEndian.Uint16(packet[12:])
+		ipPacket = packet[14:]
+		for etherType == 0x8100 && len(ipPacket) >= 4 { // 802.1Q VLAN tag
+			etherType = binary.BigEndian.Uint16(ipPacket[2:])
+			ipPacket = ipPacket[4:]
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return
+		}
+	case linkTypeLinuxSLL:
+		if len(packet) < 16 {
+			return
+		}
+		ipPacket = packet[16:]
+	case linkTypeNull:
+		if len(packet) < 4 {
+			return
+		}
+		ipPacket = packet[4:]
+	case linkTypeRaw:
+		ipPacket = packet
+	default:
+		return
+	}
+	if len(ipPacket) < 1 {
+		return
+	}
+
+	var segment []byte
+	switch ipPacket[0] >> 4 {
+	case 4:
+		if len(ipPacket) < 20 {
+			return
+		}
+		headerLength := int(ipPacket[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(ipPacket[2:]))
+		if ipPacket[9] != 6 || headerLength < 20 || totalLength < headerLength || len(ipPacket) < headerLength {
+			return
+		}
+		if totalLength > len(ipPacket) {
+			totalLength = len(ipPacket)
+		}
+		src.ip = net.IP(ipPacket[12:16]).String()
+		dst.ip = net.IP(ipPacket[16:20]).String()
+		segment = ipPacket[headerLength:totalLength]
+	case 6:
+		if len(ipPacket) < 40 || ipPacket[6] != 6 {
+			// Extension headers aren't supported.
+			return
+		}
+		payloadLength := int(binary.BigEndian.Uint16(ipPacket[4:]))
+		if 40+payloadLength > len(ipPacket) {
+			payloadLength = len(ipPacket) - 40
+		}
+		src.ip = net.IP(ipPacket[8:24]).String()
+		dst.ip = net.IP(ipPacket[24:40]).String()
+		segment = ipPacket[40 : 40+payloadLength]
+	default:
+		return
+	}
+
+	if len(segment) < 20 {
+		return
+	}
+	dataOffset := int(segment[12]>>4) * 4
+	if dataOffset < 20 || dataOffset > len(segment) {
+		return
+	}
+	src.port = binary.BigEndian.Uint16(segment[0:])
+	dst.port = binary.BigEndian.Uint16(segment[2:])
+	seq = binary.BigEndian.Uint32(segment[4:])
+	syn = segment[13]&0x02 != 0
+	return src, dst, seq, syn, segment[dataOffset:], true
+}
@@ -0,0 +1,309 @@
+package pdu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// Variable item types used in A-ASSOCIATE-RQ/AC. P3.8 9.3.2.
+const (
+	ItemTypeApplicationContext                = 0x10
+	ItemTypePresentationContextRequest        = 0x20
+	ItemTypePresentationContextResponse       = 0x21
+	ItemTypeAbstractSyntax                    = 0x30
+	ItemTypeTransferSyntax                    = 0x40
+	ItemTypeUserInformation                   = 0x50
+	ItemTypeUserInformationMaximumLength      = 0x51
+	ItemTypeImplementationClassUID            = 0x52
+	ItemTypeAsynchronousOperationsWindow      = 0x53
+	ItemTypeRoleSelection                     = 0x54
+	ItemTypeImplementationVersionName         = 0x55
+	ItemTypeSOPClassExtendedNegotiation       = 0x56
+	ItemTypeSOPClassCommonExtendedNegotiation = 0x57
+)
+
+// Presentation context results in A-ASSOCIATE-AC. P3.8 9.3.3.2.
+const (
+	PresentationContextAccepted                          = 0
+	PresentationContextUserRejection                     = 1
+	PresentationContextProviderRejectionNoReason         = 2
+	PresentationContextProviderRejectionAbstractSyntax   = 3
+	PresentationContextProviderRejectionTransferSyntaxes = 4
+)
+
+// SubItem is one variable item in an A-ASSOCIATE-RQ/AC PDU.
+type SubItem interface {
+	fmt.Stringer
+
+	// Write encodes the item, including its 4-byte item header.
+	Write(e *dicomio.Encoder)
+}
+
+// ApplicationContextItem P3.8 9.3.2.1
+type ApplicationContextItem struct {
+	Name string
+}
+
+func (v *ApplicationContextItem) Write(e *dicomio.Encoder) {
+	writeStringItem(e, ItemTypeApplicationContext, v.Name)
+}
+
+func (v *ApplicationContextItem) String() string {
+	return fmt.Sprintf("ApplicationContext{%s}", v.Name)
+}
+
+// PresentationContextItem P3.8 9.3.2.2 (request) and 9.3.3.2 (response).
+type PresentationContextItem struct {
+	ItemType  byte // ItemTypePresentationContext{Request,Response}
+	ContextID byte
+	// Result is meaningful only in a response.
+	Result byte
+	Items  []SubItem
+}
+
+func (v *PresentationContextItem) Write(e *dicomio.Encoder) {
+	sube := dicomio.NewBytesEncoder(binary.BigEndian, dicomio.UnknownVR)
+	sube.WriteByte(v.ContextID)
+	sube.WriteZeros(1)
+	sube.WriteByte(v.Result)
+	sube.WriteZeros(1)
+	for _, item := range v.Items {
+		item.Write(sube)
+	}
+	if sube.Error() != nil {
+		e.SetError(sube.Error())
+		return
+	}
+	writeItem(e, v.ItemType, sube.Bytes())
+}
+
+func (v *PresentationContextItem) String() string {
+	kind := "rq"
+	if v.ItemType == ItemTypePresentationContextResponse {
+		kind = fmt.Sprintf("ac result:%d", v.Result)
+	}
+	return fmt.Sprintf("PresentationContext{id:%d %s items:%s}", v.ContextID, kind, subItemListString(v.Items))
+}
+
+// AbstractSyntaxSubItem P3.8 9.3.2.2.1
+type AbstractSyntaxSubItem struct {
+	Name string
+}
+
+func (v *AbstractSyntaxSubItem) Write(e *dicomio.Encoder) {
+	writeStringItem(e, ItemTypeAbstractSyntax, v.Name)
+}
+
+func (v *AbstractSyntaxSubItem) String() string {
+	return fmt.Sprintf("AbstractSyntax{%s}", dicomuid.UIDString(v.Name))
+}
+
+// TransferSyntaxSubItem P3.8 9.3.2.2.2
+type TransferSyntaxSubItem struct {
+	Name string
+}
+
+func (v *TransferSyntaxSubItem) Write(e *dicomio.Encoder) {
+	writeStringItem(e, ItemTypeTransferSyntax, v.Name)
+}
+
+func (v *TransferSyntaxSubItem) String() string {
+	return fmt.Sprintf("TransferSyntax{%s}", dicomuid.UIDString(v.Name))
+}
+
+// UserInformationItem P3.8 9.3.2.3
+type UserInformationItem struct {
+	Items []SubItem
+}
+
+func (v *UserInformationItem) Write(e *dicomio.Encoder) {
+	sube := dicomio.NewBytesEncoder(binary.BigEndian, dicomio.UnknownVR)
+	for _, item := range v.Items {
+		item.Write(sube)
+	}
+	if sube.Error() != nil {
+		e.SetError(sube.Error())
+		return
+	}
+	writeItem(e, ItemTypeUserInformation, sube.Bytes())
+}
+
+func (v *UserInformationItem) String() string {
+	return fmt.Sprintf("UserInformation{items:%s}", subItemListString(v.Items))
+}
+
+// UserInformationMaximumLengthItem P3.8 D.1
+type UserInformationMaximumLengthItem struct {
+	MaximumLengthReceived uint32
+}
+
+func (v *UserInformationMaximumLengthItem) Write(e *dicomio.Encoder) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], v.MaximumLengthReceived)
+	writeItem(e, ItemTypeUserInformationMaximumLength, data[:])
+}
+
+func (v *UserInformationMaximumLengthItem) String() string {
+	return fmt.Sprintf("MaximumLength{%d}", v.MaximumLengthReceived)
+}
+
+// ImplementationClassUIDSubItem P3.7 D.3.3.2
+type ImplementationClassUIDSubItem struct {
+	Name string
+}
+
+func (v *ImplementationClassUIDSubItem) Write(e *dicomio.Encoder) {
+	writeStringItem(e, ItemTypeImplementationClassUID, v.Name)
+}
+
+func (v *ImplementationClassUIDSubItem) String() string {
+	return fmt.Sprintf("ImplementationClassUID{%s}", v.Name)
+}
+
+// AsynchronousOperationsWindowSubItem P3.7 D.3.3.3
+type AsynchronousOperationsWindowSubItem struct {
+	MaxOpsInvoked   uint16
+	MaxOpsPerformed uint16
+}
+
+func (v *AsynchronousOperationsWindowSubItem) Write(e *dicomio.Encoder) {
+	var data [4]byte
+	binary.BigEndian.PutUint16(data[0:], v.MaxOpsInvoked)
+	binary.BigEndian.PutUint16(data[2:], v.MaxOpsPerformed)
+	writeItem(e, ItemTypeAsynchronousOperationsWindow, data[:])
+}
+
+func (v *AsynchronousOperationsWindowSubItem) String() string {
+	return fmt.Sprintf("AsynchronousOperationsWindow{invoked:%d performed:%d}", v.MaxOpsInvoked, v.MaxOpsPerformed)
+}
+
+// RoleSelectionSubItem P3.7 D.3.3.4
+type RoleSelectionSubItem struct {
+	SOPClassUID string
+	SCURole     byte
+	SCPRole     byte
+}
+
+func (v *RoleSelectionSubItem) Write(e *dicomio.Encoder) {
+	sube := dicomio.NewBytesEncoder(binary.BigEndian, dicomio.UnknownVR)
+	sube.WriteUInt16(uint16(len(v.SOPClassUID)))
+	sube.WriteString(v.SOPClassUID)
+	sube.WriteByte(v.SCURole)
+	sube.WriteByte(v.SCPRole)
+	writeItem(e, ItemTypeRoleSelection, sube.Bytes())
+}
+
+func (v *RoleSelectionSubItem) String() string {
+	return fmt.Sprintf("RoleSelection{%s scu:%d scp:%d}", dicomuid.UIDString(v.SOPClassUID), v.SCURole, v.SCPRole)
+}
+
+// ImplementationVersionNameSubItem P3.7 D.3.3.2
+type ImplementationVersionNameSubItem struct {
+	Name string
+}
+
+func (v *ImplementationVersionNameSubItem) Write(e *dicomio.Encoder) {
+	writeStringItem(e, ItemTypeImplementationVersionName, v.Name)
+}
+
+func (v *ImplementationVersionNameSubItem) String() string {
+	return fmt.Sprintf("ImplementationVersionName{%s}", v.Name)
+}
+
+// RawSubItem holds an item whose type this package doesn't interpret, such
+// as SOP class extended negotiation. The payload is kept verbatim.
+type RawSubItem struct {
+	ItemType byte
+	Data     []byte
+}
+
+func (v *RawSubItem) Write(e *dicomio.Encoder) {
+	writeItem(e, v.ItemType, v.Data)
+}
+
+func (v *RawSubItem) String() string {
+	return fmt.Sprintf("Item(0x%02x){bytes:%d}", v.ItemType, len(v.Data))
+}
+
+func writeItem(e *dicomio.Encoder, itemType byte, data []byte) {
+	if len(data) > 0xffff {
+		e.SetErrorf("pdu: item 0x%02x too large: %d bytes", itemType, len(data))
+		return
+	}
+	e.WriteByte(itemType)
+	e.WriteZeros(1)
+	e.WriteUInt16(uint16(len(data)))
+	e.WriteBytes(data)
+}
+
+func writeStringItem(e *dicomio.Encoder, itemType byte, value string) {
+	writeItem(e, itemType, []byte(value))
+}
+
+// decodeSubItem 读取一个 variable item, 包括4 byte的item header
+func decodeSubItem(d *dicomio.Decoder) SubItem {
+	itemType := d.ReadByte()
+	d.Skip(1)
+	length := d.ReadUInt16()
+	d.PushLimit(int64(length))
+	defer d.PopLimit()
+
+	switch itemType {
+	case ItemTypeApplicationContext:
+		return &ApplicationContextItem{Name: d.ReadString(int(length))}
+	case ItemTypePresentationContextRequest, ItemTypePresentationContextResponse:
+		v := &PresentationContextItem{ItemType: itemType}
+		v.ContextID = d.ReadByte()
+		d.Skip(1)
+		v.Result = d.ReadByte()
+		d.Skip(1)
+		for !d.EOF() {
+			v.Items = append(v.Items, decodeSubItem(d))
+		}
+		return v
+	case ItemTypeAbstractSyntax:
+		return &AbstractSyntaxSubItem{Name: trimUID(d.ReadString(int(length)))}
+	case ItemTypeTransferSyntax:
+		return &TransferSyntaxSubItem{Name: trimUID(d.ReadString(int(length)))}
+	case ItemTypeUserInformation:
+		v := &UserInformationItem{}
+		for !d.EOF() {
+			v.Items = append(v.Items, decodeSubItem(d))
+		}
+		return v
+	case ItemTypeUserInformationMaximumLength:
+		return &UserInformationMaximumLengthItem{MaximumLengthReceived: d.ReadUInt32()}
+	case ItemTypeImplementationClassUID:
+		return &ImplementationClassUIDSubItem{Name: trimUID(d.ReadString(int(length)))}
+	case ItemTypeAsynchronousOperationsWindow:
+		return &AsynchronousOperationsWindowSubItem{MaxOpsInvoked: d.ReadUInt16(), MaxOpsPerformed: d.ReadUInt16()}
+	case ItemTypeRoleSelection:
+		v := &RoleSelectionSubItem{}
+		n := d.ReadUInt16()
+		v.SOPClassUID = trimUID(d.ReadString(int(n)))
+		v.SCURole = d.ReadByte()
+		v.SCPRole = d.ReadByte()
+		return v
+	case ItemTypeImplementationVersionName:
+		return &ImplementationVersionNameSubItem{Name: d.ReadString(int(length))}
+	default:
+		return &RawSubItem{ItemType: itemType, Data: d.ReadBytes(int(length))}
+	}
+}
+
+// 有些实现会在UID尾部加上'\0'
+func trimUID(v string) string {
+	return strings.TrimRight(v, " \000")
+}
+
+func subItemListString(items []SubItem) string {
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = item.String()
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
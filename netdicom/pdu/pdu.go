@@ -0,0 +1,326 @@
+// Package pdu implements encoding and decoding of the DICOM upper layer
+// protocol data units (PDUs).
+//
+// http://dicom.nema.org/medical/dicom/current/output/html/part08.html#sect_9.3
+package pdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/odincare/odicom/dicomio"
+)
+
+// Type 是PDU header的第一个byte, P3.8 9.3.1
+type Type byte
+
+const (
+	TypeAAssociateRq Type = 0x01
+	TypeAAssociateAc Type = 0x02
+	TypeAAssociateRj Type = 0x03
+	TypePDataTf      Type = 0x04
+	TypeAReleaseRq   Type = 0x05
+	TypeAReleaseRp   Type = 0x06
+	TypeAAbort       Type = 0x07
+)
+
+// HeaderSize is the size of the fixed PDU header (type, reserved, length).
+const HeaderSize = 6
+
+// DefaultMaxPDUSize is the max PDU size used when the peer doesn't
+// specify one.
+const DefaultMaxPDUSize = 4 << 20
+
+func (t Type) String() string {
+	switch t {
+	case TypeAAssociateRq:
+		return "A-ASSOCIATE-RQ"
+	case TypeAAssociateAc:
+		return "A-ASSOCIATE-AC"
+	case TypeAAssociateRj:
+		return "A-ASSOCIATE-RJ"
+	case TypePDataTf:
+		return "P-DATA-TF"
+	case TypeAReleaseRq:
+		return "A-RELEASE-RQ"
+	case TypeAReleaseRp:
+		return "A-RELEASE-RP"
+	case TypeAAbort:
+		return "A-ABORT"
+	}
+	return fmt.Sprintf("PDU(0x%02x)", byte(t))
+}
+
+// PDU is implemented by every PDU type defined in this package.
+type PDU interface {
+	fmt.Stringer
+
+	// Type returns the PDU type stored in the first byte of the header.
+	Type() Type
+
+	// WritePayload encodes the PDU body, excluding the 6-byte header.
+	// Errors are reported through e.Error().
+	WritePayload(e *dicomio.Encoder)
+}
+
+// AAssociate is either an A-ASSOCIATE-RQ or an A-ASSOCIATE-AC PDU. The two
+// share the same layout. P3.8 9.3.2, 9.3.3.
+type AAssociate struct {
+	PDUType         Type // TypeAAssociateRq or TypeAAssociateAc
+	ProtocolVersion uint16
+	CalledAETitle   string
+	CallingAETitle  string
+	Items           []SubItem
+}
+
+func (p *AAssociate) Type() Type { return p.PDUType }
+
+func (p *AAssociate) WritePayload(e *dicomio.Encoder) {
+	e.WriteUInt16(p.ProtocolVersion)
+	e.WriteZeros(2)
+	e.WriteString(fillString(p.CalledAETitle, 16))
+	e.WriteString(fillString(p.CallingAETitle, 16))
+	e.WriteZeros(32)
+	for _, item := range p.Items {
+		item.Write(e)
+	}
+}
+
+func (p *AAssociate) String() string {
+	return fmt.Sprintf("%v{version:%d called:'%s' calling:'%s' items:%s}",
+		p.PDUType, p.ProtocolVersion, p.CalledAETitle, p.CallingAETitle, subItemListString(p.Items))
+}
+
+// AAssociateRj is the A-ASSOCIATE-RJ PDU. P3.8 9.3.4.
+type AAssociateRj struct {
+	Result byte
+	Source byte
+	Reason byte
+}
+
+func (p *AAssociateRj) Type() Type { return TypeAAssociateRj }
+
+func (p *AAssociateRj) WritePayload(e *dicomio.Encoder) {
+	e.WriteZeros(1)
+	e.WriteByte(p.Result)
+	e.WriteByte(p.Source)
+	e.WriteByte(p.Reason)
+}
+
+func (p *AAssociateRj) String() string {
+	return fmt.Sprintf("A-ASSOCIATE-RJ{result:%d source:%d reason:%d}", p.Result, p.Source, p.Reason)
+}
+
+// PresentationDataValueItem is one PDV inside a P-DATA-TF PDU. P3.8 9.3.5.1.
+type PresentationDataValueItem struct {
+	ContextID byte
+
+	// Command is true if Value is (a fragment of) a command set; false if it
+	// is a data set.
+	Command bool
+
+	// Last is true if this is the last fragment of the command or data set.
+	Last bool
+
+	Value []byte
+}
+
+func (v *PresentationDataValueItem) String() string {
+	kind := "data"
+	if v.Command {
+		kind = "command"
+	}
+	return fmt.Sprintf("PDV{context:%d %s last:%v bytes:%d}", v.ContextID, kind, v.Last, len(v.Value))
+}
+
+// PDataTf is the P-DATA-TF PDU. P3.8 9.3.5.
+type PDataTf struct {
+	Items []PresentationDataValueItem
+}
+
+func (p *PDataTf) Type() Type { return TypePDataTf }
+
+func (p *PDataTf) WritePayload(e *dicomio.Encoder) {
+	for _, item := range p.Items {
+		e.WriteUInt32(uint32(2 + len(item.Value)))
+		e.WriteByte(item.ContextID)
+		var header byte
+		if item.Command {
+			header |= 1
+		}
+		if item.Last {
+			header |= 2
+		}
+		e.WriteByte(header)
+		e.WriteBytes(item.Value)
+	}
+}
+
+func (p *PDataTf) String() string {
+	items := make([]string, len(p.Items))
+	for i := range p.Items {
+		items[i] = p.Items[i].String()
+	}
+	return fmt.Sprintf("P-DATA-TF{%s}", strings.Join(items, " "))
+}
+
+// AReleaseRq is the A-RELEASE-RQ PDU. P3.8 9.3.6.
+type AReleaseRq struct{}
+
+func (p *AReleaseRq) Type() Type                      { return TypeAReleaseRq }
+func (p *AReleaseRq) WritePayload(e *dicomio.Encoder) { e.WriteZeros(4) }
+func (p *AReleaseRq) String() string                  { return "A-RELEASE-RQ" }
+
+// AReleaseRp is the A-RELEASE-RP PDU. P3.8 9.3.7.
+type AReleaseRp struct{}
+
+func (p *AReleaseRp) Type() Type                      { return TypeAReleaseRp }
+func (p *AReleaseRp) WritePayload(e *dicomio.Encoder) { e.WriteZeros(4) }
+func (p *AReleaseRp) String() string                  { return "A-RELEASE-RP" }
+
+// AAbort is the A-ABORT PDU. P3.8 9.3.8.
+type AAbort struct {
+	Source byte
+	Reason byte
+}
+
+func (p *AAbort) Type() Type { return TypeAAbort }
+
+func (p *AAbort) WritePayload(e *dicomio.Encoder) {
+	e.WriteZeros(2)
+	e.WriteByte(p.Source)
+	e.WriteByte(p.Reason)
+}
+
+func (p *AAbort) String() string {
+	return fmt.Sprintf("A-ABORT{source:%d reason:%d}", p.Source, p.Reason)
+}
+
+// EncodePDU serializes a PDU, including the 6-byte header.
+func EncodePDU(p PDU) ([]byte, error) {
+	e := dicomio.NewBytesEncoder(binary.BigEndian, dicomio.UnknownVR)
+	p.WritePayload(e)
+	if e.Error() != nil {
+		return nil, e.Error()
+	}
+	payload := e.Bytes()
+
+	header := make([]byte, HeaderSize, HeaderSize+len(payload))
+	header[0] = byte(p.Type())
+	binary.BigEndian.PutUint32(header[2:], uint32(len(payload)))
+	return append(header, payload...), nil
+}
+
+// ReadPDU reads one PDU from "in". If maxPDUSize > 0, a PDU whose payload is
+// larger than that is rejected without reading its body. Returns io.EOF if
+// "in" is at the end of stream before the first byte of the header.
+func ReadPDU(in io.Reader, maxPDUSize int) (PDU, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		return nil, err
+	}
+
+	pduType := Type(header[0])
+	length := binary.BigEndian.Uint32(header[2:])
+	if maxPDUSize > 0 && int64(length) > int64(maxPDUSize) {
+		return nil, fmt.Errorf("pdu.ReadPDU: %v: length %d exceeds the limit %d", pduType, length, maxPDUSize)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(in, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return DecodePayload(pduType, payload)
+}
+
+// DecodePDU parses a PDU stored in "data", which must include the 6-byte
+// header. Bytes beyond the length recorded in the header are ignored.
+func DecodePDU(data []byte) (PDU, error) {
+	if len(data) >= HeaderSize {
+		// 不按header里的length分配内存
+		if length := binary.BigEndian.Uint32(data[2:]); int64(length) > int64(len(data)-HeaderSize) {
+			return nil, fmt.Errorf("pdu.DecodePDU: %v: length %d exceeds the %d bytes of data",
+				Type(data[0]), length, len(data)-HeaderSize)
+		}
+	}
+	return ReadPDU(bytes.NewReader(data), 0)
+}
+
+// DecodePayload parses a PDU body of the given type.
+func DecodePayload(pduType Type, payload []byte) (PDU, error) {
+	d := dicomio.NewBytesDecoder(payload, binary.BigEndian, dicomio.UnknownVR)
+
+	var p PDU
+	switch pduType {
+	case TypeAAssociateRq, TypeAAssociateAc:
+		p = decodeAAssociate(d, pduType)
+	case TypeAAssociateRj:
+		d.Skip(1)
+		p = &AAssociateRj{Result: d.ReadByte(), Source: d.ReadByte(), Reason: d.ReadByte()}
+	case TypePDataTf:
+		p = decodePDataTf(d)
+	case TypeAReleaseRq:
+		d.Skip(4)
+		p = &AReleaseRq{}
+	case TypeAReleaseRp:
+		d.Skip(4)
+		p = &AReleaseRp{}
+	case TypeAAbort:
+		d.Skip(2)
+		p = &AAbort{Source: d.ReadByte(), Reason: d.ReadByte()}
+	default:
+		return nil, fmt.Errorf("pdu.DecodePayload: unknown PDU type 0x%02x", byte(pduType))
+	}
+
+	if err := d.Finish(); err != nil {
+		return nil, fmt.Errorf("pdu.DecodePayload: %v: %v", pduType, err)
+	}
+	return p, nil
+}
+
+func decodeAAssociate(d *dicomio.Decoder, pduType Type) *AAssociate {
+	p := &AAssociate{PDUType: pduType}
+	p.ProtocolVersion = d.ReadUInt16()
+	d.Skip(2)
+	p.CalledAETitle = strings.TrimRight(d.ReadString(16), " \000")
+	p.CallingAETitle = strings.TrimRight(d.ReadString(16), " \000")
+	d.Skip(32)
+	for !d.EOF() {
+		p.Items = append(p.Items, decodeSubItem(d))
+	}
+	return p
+}
+
+func decodePDataTf(d *dicomio.Decoder) *PDataTf {
+	p := &PDataTf{}
+	for !d.EOF() {
+		length := d.ReadUInt32()
+		if length < 2 {
+			d.SetErrorf("PDV item too short: %d", length)
+			break
+		}
+		item := PresentationDataValueItem{}
+		item.ContextID = d.ReadByte()
+		header := d.ReadByte()
+		item.Command = header&1 != 0
+		item.Last = header&2 != 0
+		item.Value = d.ReadBytes(int(length - 2))
+		p.Items = append(p.Items, item)
+	}
+	return p
+}
+
+// fillString pads "v" with spaces to "length" bytes, truncating if needed.
+func fillString(v string, length int) string {
+	if len(v) > length {
+		return v[:length]
+	}
+	return v + strings.Repeat(" ", length-len(v))
+}
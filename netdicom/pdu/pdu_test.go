@@ -0,0 +1,79 @@
+package pdu_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom/pdu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAAssociateRoundTrip(t *testing.T) {
+	rq := &pdu.AAssociate{
+		PDUType:         pdu.TypeAAssociateRq,
+		ProtocolVersion: 1,
+		CalledAETitle:   "PACS",
+		CallingAETitle:  "GOSCU",
+		Items: []pdu.SubItem{
+			&pdu.ApplicationContextItem{Name: "1.2.840.10008.3.1.1.1"},
+			&pdu.PresentationContextItem{
+				ItemType:  pdu.ItemTypePresentationContextRequest,
+				ContextID: 1,
+				Items: []pdu.SubItem{
+					&pdu.AbstractSyntaxSubItem{Name: dicomuid.VerificationSOPClass},
+					&pdu.TransferSyntaxSubItem{Name: dicomuid.ImplicitVRLittleEndian},
+				},
+			},
+			&pdu.UserInformationItem{Items: []pdu.SubItem{
+				&pdu.UserInformationMaximumLengthItem{MaximumLengthReceived: 16384},
+				&pdu.ImplementationClassUIDSubItem{Name: "1.2.3"},
+				&pdu.RawSubItem{ItemType: pdu.ItemTypeSOPClassExtendedNegotiation, Data: []byte{1, 2}},
+			}},
+		},
+	}
+	data, err := pdu.EncodePDU(rq)
+	require.NoError(t, err)
+
+	p, err := pdu.ReadPDU(bytes.NewReader(data), 0)
+	require.NoError(t, err)
+	assert.Equal(t, rq, p)
+
+	_, err = pdu.ReadPDU(bytes.NewReader(data), 10)
+	assert.Error(t, err)
+}
+
+func TestPDataTfRoundTrip(t *testing.T) {
+	in := &pdu.PDataTf{Items: []pdu.PresentationDataValueItem{
+		{ContextID: 3, Command: true, Last: true, Value: []byte{1, 2, 3, 4}},
+		{ContextID: 3, Command: false, Last: false, Value: []byte{5, 6}},
+	}}
+	data, err := pdu.EncodePDU(in)
+	require.NoError(t, err)
+	p, err := pdu.DecodePDU(data)
+	require.NoError(t, err)
+	assert.Equal(t, in, p)
+}
+
+func TestShortPDUs(t *testing.T) {
+	for _, in := range []pdu.PDU{
+		&pdu.AAssociateRj{Result: 1, Source: 2, Reason: 3},
+		&pdu.AReleaseRq{},
+		&pdu.AReleaseRp{},
+		&pdu.AAbort{Source: 2, Reason: 6},
+	} {
+		data, err := pdu.EncodePDU(in)
+		require.NoError(t, err)
+		p, err := pdu.DecodePDU(data)
+		require.NoError(t, err)
+		assert.Equal(t, in, p)
+	}
+
+	_, err := pdu.DecodePDU([]byte{0x42, 0, 0, 0, 0, 0})
+	assert.Error(t, err)
+	// length比data长: error, 不会分配4 GiB
+	_, err = pdu.DecodePDU([]byte{0x04, 0, 0xff, 0xff, 0xff, 0xff, 1, 2})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds the 2 bytes of data")
+}
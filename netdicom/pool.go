@@ -0,0 +1,196 @@
+package netdicom
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/odincare/odicom"
+)
+
+// Association is an established association that a Pool can hand out again
+// after an operation, e.g., a series of C-STOREs, is done with it.
+// *ClientAssociation implements it.
+type Association interface {
+	// Echo checks that the association is still usable, normally with a
+	// C-ECHO. Pool calls it before reusing an association that has been
+	// idle for longer than PoolOptions.HealthCheckAfter.
+	Echo(ctx context.Context) error
+
+	// Store sends "ds" with a C-STORE, see ClientAssociation.Store.
+	Store(ctx context.Context, ds *dicom.DataSet) error
+
+	// Find sends a C-FIND, see ClientAssociation.Find.
+	Find(ctx context.Context, level QueryLevel, identifier *dicom.DataSet, fn func(*dicom.DataSet) error) error
+
+	// Close releases the association (A-RELEASE) and closes the connection.
+	Close() error
+}
+
+var _ Association = (*ClientAssociation)(nil)
+
+// DialFunc establishes a new association with the application entity "ae".
+// The meaning of ae, e.g., "AETITLE@host:port", is up to the DialFunc; Pool
+// only uses it as a key.
+type DialFunc func(ctx context.Context, ae string) (Association, error)
+
+// PoolOptions configures a Pool. The zero value is usable.
+type PoolOptions struct {
+	// MaxIdle 是每个AE最多保留的空闲association数. 0表示2
+	MaxIdle int
+
+	// MaxLifetime 是association从建立起最多被使用的时间. 超过的association在
+	// Get或Release时被关闭. 0表示不限制
+	MaxLifetime time.Duration
+
+	// HealthCheckAfter 是association空闲多久之后, Get在重用前要先Echo. 0表示每次都Echo
+	HealthCheckAfter time.Duration
+}
+
+// ErrPoolClosed is returned by Pool.Get after Pool.Close.
+var ErrPoolClosed = errors.New("netdicom: pool is closed")
+
+// Pool keeps associations open after use, so that later operations with the
+// same AE skip association negotiation. Negotiating per instance dominates
+// the time to send a large study instance by instance.
+//
+// Pool is safe for concurrent use. An association is used by one caller at
+// a time: between Get and Release it is not shared.
+type Pool struct {
+	dial    DialFunc
+	options PoolOptions
+
+	mu     sync.Mutex
+	idle   map[string][]*PooledAssociation
+	closed bool
+}
+
+// PooledAssociation is an Association handed out by Pool.Get.
+type PooledAssociation struct {
+	Association
+
+	ae       string
+	created  time.Time
+	lastUsed time.Time
+}
+
+// NewPool creates a Pool that opens new associations with "dial".
+func NewPool(dial DialFunc, options PoolOptions) *Pool {
+	if options.MaxIdle <= 0 {
+		options.MaxIdle = 2
+	}
+	return &Pool{dial: dial, options: options, idle: map[string][]*PooledAssociation{}}
+}
+
+// expired 检查association是否超过了MaxLifetime
+func (p *Pool) expired(a *PooledAssociation, now time.Time) bool {
+	return p.options.MaxLifetime > 0 && now.Sub(a.created) >= p.options.MaxLifetime
+}
+
+// Get returns an idle association with "ae", or dials a new one if there is
+// none. Idle associations that are too old or fail the health check are
+// closed and skipped. Call Release when done with the association.
+func (p *Pool) Get(ctx context.Context, ae string) (*PooledAssociation, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		idle := p.idle[ae]
+		if len(idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		// 用最近用过的, 它最可能还活着
+		a := idle[len(idle)-1]
+		p.idle[ae] = idle[:len(idle)-1]
+		p.mu.Unlock()
+
+		now := time.Now()
+		if p.expired(a, now) {
+			a.Close() // nolint: errcheck
+			continue
+		}
+		if now.Sub(a.lastUsed) >= p.options.HealthCheckAfter {
+			if err := a.Echo(ctx); err != nil {
+				a.Close() // nolint: errcheck
+				continue
+			}
+		}
+		return a, nil
+	}
+
+	assoc, err := p.dial(ctx, ae)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &PooledAssociation{Association: assoc, ae: ae, created: now, lastUsed: now}, nil
+}
+
+// Release returns "a" to the pool. "err" is the result of the operation
+// that used a: a failure status from the peer (a *StatusError, possibly
+// wrapped) leaves the association usable, but after any other error, e.g.,
+// a network error or an interrupted operation, the association may be in an
+// unknown state, so it is closed instead. It is also closed if the pool
+// already has MaxIdle idle associations with the AE, a is too old, or the
+// pool is closed.
+func (p *Pool) Release(a *PooledAssociation, err error) {
+	now := time.Now()
+	a.lastUsed = now
+	if reusable(err) && !p.expired(a, now) {
+		p.mu.Lock()
+		if !p.closed && len(p.idle[a.ae]) < p.options.MaxIdle {
+			p.idle[a.ae] = append(p.idle[a.ae], a)
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+	a.Close() // nolint: errcheck
+}
+
+// reusable 检查一个操作返回err之后association是否还能用: 对方回复了DIMSE status
+// 的操作已经完整结束了
+func reusable(err error) bool {
+	var status *StatusError
+	return err == nil || errors.As(err, &status)
+}
+
+// Do runs "op" with an association with "ae" from the pool, and releases it
+// with op's result.
+func (p *Pool) Do(ctx context.Context, ae string, op func(Association) error) error {
+	a, err := p.Get(ctx, ae)
+	if err != nil {
+		return err
+	}
+	err = op(a.Association)
+	p.Release(a, err)
+	return err
+}
+
+// Close closes all idle associations. Associations that are in use are
+// closed when they are released. It returns the first error from closing an
+// association.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = map[string][]*PooledAssociation{}
+	p.closed = true
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, list := range idle {
+		for _, a := range list {
+			if err := a.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
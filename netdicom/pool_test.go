@@ -0,0 +1,124 @@
+package netdicom_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAssociation struct {
+	echoErr error
+	echoes  int
+	closed  bool
+}
+
+func (a *fakeAssociation) Echo(ctx context.Context) error {
+	a.echoes++
+	return a.echoErr
+}
+
+func (a *fakeAssociation) Store(ctx context.Context, ds *dicom.DataSet) error {
+	return nil
+}
+
+func (a *fakeAssociation) Find(ctx context.Context, level netdicom.QueryLevel, identifier *dicom.DataSet, fn func(*dicom.DataSet) error) error {
+	return nil
+}
+
+func (a *fakeAssociation) Close() error {
+	a.closed = true
+	return nil
+}
+
+type fakeDialer struct {
+	dialed []*fakeAssociation
+}
+
+func (d *fakeDialer) dial(ctx context.Context, ae string) (netdicom.Association, error) {
+	if ae == "UNREACHABLE" {
+		return nil, errors.New("connection refused")
+	}
+	a := &fakeAssociation{}
+	d.dialed = append(d.dialed, a)
+	return a, nil
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	d := &fakeDialer{}
+	pool := netdicom.NewPool(d.dial, netdicom.PoolOptions{MaxIdle: 1, HealthCheckAfter: time.Hour})
+
+	// Sequential operations with the same AE share one association.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pool.Do(ctx, "STORESCP", func(a netdicom.Association) error { return nil }))
+	}
+	require.Len(t, d.dialed, 1)
+	assert.Equal(t, 0, d.dialed[0].echoes, "not idle long enough for a health check")
+
+	// Concurrent users get separate associations; only MaxIdle are kept.
+	a0, err := pool.Get(ctx, "STORESCP")
+	require.NoError(t, err)
+	a1, err := pool.Get(ctx, "STORESCP")
+	require.NoError(t, err)
+	require.Len(t, d.dialed, 2)
+	pool.Release(a0, nil)
+	pool.Release(a1, nil)
+	assert.False(t, d.dialed[0].closed)
+	assert.True(t, d.dialed[1].closed)
+
+	// A failure status from the peer doesn't close the association.
+	statusErr := fmt.Errorf("netdicom.Store: %w", &netdicom.StatusError{Status: netdicom.StatusOutOfResources})
+	assert.Equal(t, statusErr, pool.Do(ctx, "STORESCP", func(a netdicom.Association) error { return statusErr }))
+	assert.False(t, d.dialed[0].closed)
+	require.Len(t, d.dialed, 2)
+
+	// A failed operation closes the association.
+	opErr := errors.New("C-STORE failed")
+	assert.Equal(t, opErr, pool.Do(ctx, "STORESCP", func(a netdicom.Association) error { return opErr }))
+	assert.True(t, d.dialed[0].closed)
+	require.NoError(t, pool.Do(ctx, "OTHER", func(a netdicom.Association) error { return nil }))
+	require.Len(t, d.dialed, 3)
+
+	_, err = pool.Get(ctx, "UNREACHABLE")
+	assert.Error(t, err)
+
+	require.NoError(t, pool.Close())
+	assert.True(t, d.dialed[2].closed)
+	_, err = pool.Get(ctx, "OTHER")
+	assert.Equal(t, netdicom.ErrPoolClosed, err)
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	d := &fakeDialer{}
+	pool := netdicom.NewPool(d.dial, netdicom.PoolOptions{})
+	defer pool.Close()
+
+	require.NoError(t, pool.Do(ctx, "STORESCP", func(a netdicom.Association) error { return nil }))
+	d.dialed[0].echoErr = errors.New("A-ABORT")
+	a, err := pool.Get(ctx, "STORESCP")
+	require.NoError(t, err)
+	assert.Equal(t, 1, d.dialed[0].echoes)
+	assert.True(t, d.dialed[0].closed, "failed the health check")
+	assert.Equal(t, d.dialed[1], a.Association)
+	pool.Release(a, nil)
+
+	// Expired associations are not reused.
+	pool = netdicom.NewPool(d.dial, netdicom.PoolOptions{MaxLifetime: time.Nanosecond})
+	require.NoError(t, pool.Do(ctx, "STORESCP", func(a netdicom.Association) error { return nil }))
+	require.NoError(t, pool.Do(ctx, "STORESCP", func(a netdicom.Association) error { return nil }))
+	require.Len(t, d.dialed, 4)
+	assert.True(t, d.dialed[2].closed)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = pool.Get(cancelled, "STORESCP")
+	assert.Equal(t, context.Canceled, err)
+}
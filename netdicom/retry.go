@@ -0,0 +1,147 @@
+package netdicom
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomlog"
+)
+
+// RetryPolicy retries SCU operations that fail transiently, e.g., when a
+// busy PACS rejects the association or runs out of resources. The zero
+// value makes 3 attempts, waiting 1s and then 2s between them.
+type RetryPolicy struct {
+	// MaxAttempts 是最多尝试的次数, 包括第一次. 0表示3
+	MaxAttempts int
+
+	// InitialBackoff 是第一次失败后等待的时间, 之后每次加倍. 0表示1秒
+	InitialBackoff time.Duration
+
+	// MaxBackoff 是等待时间的上限. 0表示30秒
+	MaxBackoff time.Duration
+
+	// Retryable decides whether an error is transient. nil means
+	// IsRetryable.
+	Retryable func(err error) bool
+}
+
+// IsRetryable reports whether "err", returned by an operation of this
+// package, is likely transient:
+//
+//   - a rejection of the association with result rejected-transient,
+//   - a status of the Out of Resources class (0xA7xx),
+//   - a network error, or the peer closing or aborting the connection.
+//
+// Other failure statuses, permanent rejections, invalid data sets and
+// context errors are not.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		return rejected.Result == 2
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Status&0xff00 == 0xa700
+	}
+	var aborted *abortedError
+	var netErr net.Error
+	return errors.As(err, &aborted) || errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Do calls "op" until it succeeds, returns an error that isn't retryable,
+// or MaxAttempts is reached, and returns its last error. It stops waiting
+// and returns ctx.Err() when "ctx" is done.
+func (p RetryPolicy) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	backoff := p.InitialBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	retryable := p.retryable()
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil || attempt >= maxAttempts || !retryable(err) {
+			return err
+		}
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		dicomlog.V(1, "netdicom: retrying", dicomlog.Fields{"attempt": attempt, "backoff": backoff, "error": err})
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (p RetryPolicy) retryable() func(error) bool {
+	if p.Retryable == nil {
+		return IsRetryable
+	}
+	return p.Retryable
+}
+
+// Store is Store with retries. Sending an instance again is safe: a
+// storage SCP keeps one copy per SOP Instance UID.
+func (p RetryPolicy) Store(ctx context.Context, addr, callingAE, calledAE string, ds *dicom.DataSet) error {
+	return p.Do(ctx, func(ctx context.Context) error {
+		return Store(ctx, addr, callingAE, calledAE, ds)
+	})
+}
+
+// StoreFiles is StoreFiles with retries. A retry opens a new association
+// and resumes from the file that failed, so the files already stored are
+// not sent again. MaxAttempts applies to each failure separately: the
+// count restarts after a retry that makes progress.
+func (p RetryPolicy) StoreFiles(ctx context.Context, addr, callingAE, calledAE string, paths []string) error {
+	retryable := p.retryable()
+	for {
+		var stored int
+		err := p.Do(ctx, func(ctx context.Context) error {
+			var err error
+			stored, err = storeFiles(ctx, addr, callingAE, calledAE, paths)
+			if stored == len(paths) || (stored > 0 && retryable(err)) {
+				// 都发送了 (只有Close失败), 或者有进展: 让外面的循环用新的MaxAttempts继续
+				return nil
+			}
+			return err
+		})
+		if err != nil || stored == len(paths) {
+			return err
+		}
+		paths = paths[stored:]
+	}
+}
+
+// Find is Find with retries. Since "fn" must not see a match twice, it is
+// retried only if it failed before the first match was passed to "fn".
+func (p RetryPolicy) Find(ctx context.Context, addr, callingAE, calledAE string, level QueryLevel, identifier *dicom.DataSet, fn func(*dicom.DataSet) error) error {
+	matched := false
+	retryable := p.retryable()
+	p.Retryable = func(err error) bool { return !matched && retryable(err) }
+	return p.Do(ctx, func(ctx context.Context) error {
+		return Find(ctx, addr, callingAE, calledAE, level, identifier, func(ds *dicom.DataSet) error {
+			matched = true
+			return fn(ds)
+		})
+	})
+}
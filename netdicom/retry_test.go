@@ -0,0 +1,105 @@
+package netdicom_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&netdicom.RejectedError{Result: 2, Source: 1, Reason: 1}, true},
+		{&netdicom.RejectedError{Result: 1, Source: 1, Reason: 7}, false},
+		{fmt.Errorf("netdicom.Store: 1.2.3: %w", &netdicom.StatusError{Status: netdicom.StatusOutOfResources}), true},
+		{&netdicom.StatusError{Status: netdicom.StatusCannotUnderstand}, false},
+		{fmt.Errorf("netdicom.Dial: %w", io.EOF), true},
+		{context.DeadlineExceeded, false},
+		{errors.New("invalid data set"), false},
+	} {
+		assert.Equal(t, test.want, netdicom.IsRetryable(test.err), test.err.Error())
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dir, err := ioutil.TempDir("", "netdicom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// handler让每个instance (按PatientName区分) 的前failures次C-STORE失败
+	failures := 0
+	attempts := map[string]int{}
+	handler := netdicom.StorageHandlerFunc(func(ctx context.Context, req *netdicom.StoreRequest) error {
+		elem, err := req.DataSet.FindElementByTag(dicomtag.PatientName)
+		if err != nil {
+			return err
+		}
+		name := elem.MustGetString()
+		attempts[name]++
+		if attempts[name] <= failures {
+			return &netdicom.StatusError{Status: netdicom.StatusOutOfResources, Comment: "busy"}
+		}
+		return nil
+	})
+	addr, stop := startServer(t, &netdicom.Server{Handler: handler})
+	defer stop()
+	policy := netdicom.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	ds, err := dicomtest.NewDataSet(dicomtest.Spec{})
+	require.NoError(t, err)
+	failures = 2
+	require.NoError(t, policy.Store(ctx, addr, "TESTSCU", "ANY-SCP", ds))
+	assert.Len(t, attempts, 1)
+	for _, n := range attempts {
+		assert.Equal(t, 3, n)
+	}
+
+	attempts = map[string]int{}
+	failures = 3
+	err = policy.Store(ctx, addr, "TESTSCU", "ANY-SCP", ds)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "busy")
+	for _, n := range attempts {
+		assert.Equal(t, 3, n)
+	}
+
+	// 不能重试的error
+	calls := 0
+	err = policy.Do(ctx, func(context.Context) error {
+		calls++
+		return &netdicom.StatusError{Status: netdicom.StatusCannotUnderstand}
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	// StoreFiles从失败的文件继续
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.dcm", i))
+		require.NoError(t, dicomtest.WriteFile(path, dicomtest.Spec{PatientName: fmt.Sprintf("Patient^%d", i)}))
+		paths = append(paths, path)
+	}
+	attempts = map[string]int{}
+	failures = 1
+	require.NoError(t, policy.StoreFiles(ctx, addr, "TESTSCU", "ANY-SCP", paths))
+	require.Len(t, attempts, 3)
+	for _, n := range attempts {
+		assert.Equal(t, 2, n, "each file is sent once more after its failure")
+	}
+}
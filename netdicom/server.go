@@ -0,0 +1,413 @@
+package netdicom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomlog"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom/pdu"
+)
+
+// StoreRequest is an instance received by a Server with C-STORE.
+type StoreRequest struct {
+	// CallingAETitle and CalledAETitle of the association.
+	CallingAETitle string
+	CalledAETitle  string
+	RemoteAddr     net.Addr
+
+	SOPClassUID       string
+	SOPInstanceUID    string
+	TransferSyntaxUID string
+
+	// DataSet is the received data set. It has a file meta group, so that
+	// it can be saved as is with dicom.WriteDataSetToFile.
+	DataSet *dicom.DataSet
+}
+
+// StorageHandler stores the instances received by a Server.
+type StorageHandler interface {
+	// HandleStore is called for each C-STORE request, one at a time per
+	// association. A nil error is answered with Success, a *StatusError
+	// with its status, and any other error with StatusProcessingFailure.
+	// "ctx" is cancelled when the association ends.
+	HandleStore(ctx context.Context, req *StoreRequest) error
+}
+
+// StorageHandlerFunc is a function that implements StorageHandler.
+type StorageHandlerFunc func(ctx context.Context, req *StoreRequest) error
+
+// HandleStore calls f(ctx, req).
+func (f StorageHandlerFunc) HandleStore(ctx context.Context, req *StoreRequest) error {
+	return f(ctx, req)
+}
+
+// Server is a Storage SCP: it accepts associations, answers C-ECHO, and
+// passes the data sets of C-STORE requests to Handler.
+type Server struct {
+	// AETitle is the called AE title that the server answers to. Empty
+	// means any.
+	AETitle string
+
+	// Handler stores the received instances. If nil, the server only
+	// accepts the Verification SOP Class.
+	Handler StorageHandler
+
+	// SOPClasses are the SOP Classes accepted for C-STORE. nil means all.
+	SOPClasses []string
+
+	// TransferSyntaxes are the accepted transfer syntaxes, in order of
+	// preference. nil means Explicit and Implicit VR Little Endian, then
+	// any other standard transfer syntax proposed.
+	TransferSyntaxes []string
+
+	// MaxPDUSize is the largest PDU that the server accepts. 0 means
+	// pdu.DefaultMaxPDUSize.
+	MaxPDUSize uint32
+
+	// IdleTimeout aborts an association when no PDU arrives for this long.
+	// 0 means no timeout.
+	IdleTimeout time.Duration
+
+	// MaxMessageSize is the largest command set or data set, in bytes, that
+	// the server assembles from P-DATA-TF fragments. A larger message aborts
+	// the association. 0 means DefaultMaxMessageSize.
+	MaxMessageSize int
+}
+
+// DefaultMaxMessageSize is the default of Server.MaxMessageSize.
+const DefaultMaxMessageSize = 1 << 30
+
+// Serve runs a Storage SCP on "listener" that passes the received data
+// sets to "handler", with the default settings of Server.
+func Serve(listener net.Listener, handler StorageHandler) error {
+	return (&Server{Handler: handler}).Serve(listener)
+}
+
+// Serve accepts connections on "listener" and handles each association in
+// its own goroutine. It returns the error of listener.Accept, e.g., after
+// the listener is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serverAssociation 是Server接受的一个association
+type serverAssociation struct {
+	dimseConn
+	server *Server
+	req    StoreRequest // 只填了association的字段
+	// contexts 是接受的presentation contexts: context ID -> abstract syntax和transfer syntax
+	contexts map[byte]acceptedContext
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close() // nolint: errcheck
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := &serverAssociation{
+		dimseConn: dimseConn{conn: conn, maxReceive: s.MaxPDUSize},
+		server:    s,
+		contexts:  map[byte]acceptedContext{},
+	}
+	a.req.RemoteAddr = conn.RemoteAddr()
+	if err := a.serveRecover(ctx); err != nil {
+		dicomlog.V(1, "netdicom.Server: association ended", dicomlog.Fields{
+			"remote": conn.RemoteAddr().String(), "calling": a.req.CallingAETitle, "error": err})
+		// 对方已经abort了association时不用再回A-ABORT
+		var aborted *abortedError
+		if !errors.As(err, &aborted) {
+			a.send(&pdu.AAbort{}) // nolint: errcheck
+		}
+	}
+}
+
+// serveRecover 调用serve, 把panic (比如解析对端发来的坏data set, 或者Handler
+// 里的panic) 变成error, 这样一个坏的对端只会abort它自己的association,
+// 不会让整个server挂掉
+func (a *serverAssociation) serveRecover(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return a.serve(ctx)
+}
+
+// readPDU 读取下一个PDU, 最多等IdleTimeout
+func (a *serverAssociation) readPDU() (pdu.PDU, error) {
+	var deadline time.Time
+	if a.server.IdleTimeout > 0 {
+		deadline = time.Now().Add(a.server.IdleTimeout)
+	}
+	if err := a.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	return a.receive()
+}
+
+// serve 处理association直到release. 返回error时association被abort
+func (a *serverAssociation) serve(ctx context.Context) error {
+	p, err := a.readPDU()
+	if err != nil {
+		return err
+	}
+	rq, ok := p.(*pdu.AAssociate)
+	if !ok || rq.PDUType != pdu.TypeAAssociateRq {
+		return fmt.Errorf("expected A-ASSOCIATE-RQ, got %v", p)
+	}
+	if ok, err := a.associate(rq); !ok || err != nil {
+		return err
+	}
+
+	asm := assembler{maxSize: a.server.MaxMessageSize}
+	if asm.maxSize <= 0 {
+		asm.maxSize = DefaultMaxMessageSize
+	}
+	for {
+		p, err := a.readPDU()
+		if err != nil {
+			return err
+		}
+		switch v := p.(type) {
+		case *pdu.AReleaseRq:
+			return a.send(&pdu.AReleaseRp{})
+		case *pdu.PDataTf:
+			msgs, err := asm.add(v)
+			if err != nil {
+				return err
+			}
+			for _, msg := range msgs {
+				if err := a.handle(ctx, msg); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unexpected PDU %v", p)
+		}
+	}
+}
+
+// associate 回答A-ASSOCIATE-RQ. 拒绝association时返回false
+func (a *serverAssociation) associate(rq *pdu.AAssociate) (bool, error) {
+	s := a.server
+	a.req.CallingAETitle = strings.TrimSpace(rq.CallingAETitle)
+	a.req.CalledAETitle = strings.TrimSpace(rq.CalledAETitle)
+	if s.AETitle != "" && a.req.CalledAETitle != s.AETitle {
+		// rejected-permanent, DICOM UL service-user, called-AE-title-not-recognized
+		return false, a.send(&pdu.AAssociateRj{Result: 1, Source: 1, Reason: 7})
+	}
+
+	maxReceive := s.MaxPDUSize
+	if maxReceive == 0 {
+		maxReceive = pdu.DefaultMaxPDUSize
+	}
+	ac := &pdu.AAssociate{
+		PDUType:         pdu.TypeAAssociateAc,
+		ProtocolVersion: 1,
+		CalledAETitle:   rq.CalledAETitle,
+		CallingAETitle:  rq.CallingAETitle,
+		Items:           []pdu.SubItem{&pdu.ApplicationContextItem{Name: dicomApplicationContextName}},
+	}
+	for _, item := range rq.Items {
+		switch v := item.(type) {
+		case *pdu.PresentationContextItem:
+			var abstractSyntax string
+			var transferSyntaxes []string
+			for _, sub := range v.Items {
+				switch sub := sub.(type) {
+				case *pdu.AbstractSyntaxSubItem:
+					abstractSyntax = sub.Name
+				case *pdu.TransferSyntaxSubItem:
+					transferSyntaxes = append(transferSyntaxes, sub.Name)
+				}
+			}
+			result := byte(pdu.PresentationContextAccepted)
+			transferSyntax := ""
+			if !s.acceptsAbstractSyntax(abstractSyntax) {
+				result = pdu.PresentationContextProviderRejectionAbstractSyntax
+			} else if transferSyntax = s.chooseTransferSyntax(transferSyntaxes); transferSyntax == "" {
+				result = pdu.PresentationContextProviderRejectionTransferSyntaxes
+			} else {
+				a.contexts[v.ContextID] = acceptedContext{id: v.ContextID, abstractSyntax: abstractSyntax, transferSyntax: transferSyntax}
+			}
+			// 拒绝时transfer syntax sub-item没有意义, 但是必须有, P3.8 9.3.3.2
+			if transferSyntax == "" {
+				transferSyntax = dicomuid.ImplicitVRLittleEndian
+			}
+			ac.Items = append(ac.Items, &pdu.PresentationContextItem{
+				ItemType:  pdu.ItemTypePresentationContextResponse,
+				ContextID: v.ContextID,
+				Result:    result,
+				Items:     []pdu.SubItem{&pdu.TransferSyntaxSubItem{Name: transferSyntax}},
+			})
+		case *pdu.UserInformationItem:
+			for _, sub := range v.Items {
+				if m, ok := sub.(*pdu.UserInformationMaximumLengthItem); ok {
+					a.maxPDUSize = m.MaximumLengthReceived
+				}
+			}
+		}
+	}
+	ac.Items = append(ac.Items, &pdu.UserInformationItem{Items: []pdu.SubItem{
+		&pdu.UserInformationMaximumLengthItem{MaximumLengthReceived: maxReceive},
+		&pdu.ImplementationClassUIDSubItem{Name: dicom.GoDICOMImplementationClassUID},
+		&pdu.ImplementationVersionNameSubItem{Name: dicom.GoDICOMImplementationVersionName},
+	}})
+	return true, a.send(ac)
+}
+
+func (s *Server) acceptsAbstractSyntax(uid string) bool {
+	if uid == dicomuid.VerificationSOPClass {
+		return true
+	}
+	if s.Handler == nil {
+		return false
+	}
+	if s.SOPClasses == nil {
+		return uid != ""
+	}
+	for _, sopClass := range s.SOPClasses {
+		if sopClass == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseTransferSyntax 返回proposed中最优先的可以接受的transfer syntax, 没有时返回""
+func (s *Server) chooseTransferSyntax(proposed []string) string {
+	isProposed := func(uid string) bool {
+		for _, p := range proposed {
+			if p == uid {
+				return true
+			}
+		}
+		return false
+	}
+	if s.TransferSyntaxes != nil {
+		for _, uid := range s.TransferSyntaxes {
+			if isProposed(uid) {
+				return uid
+			}
+		}
+		return ""
+	}
+	for _, uid := range proposedTransferSyntaxesPreference {
+		if isProposed(uid) {
+			return uid
+		}
+	}
+	for _, uid := range proposed {
+		if e, err := dicomuid.Lookup(uid); err == nil && e.Type == dicomuid.TypeTransferSyntax {
+			return uid
+		}
+	}
+	return ""
+}
+
+// proposedTransferSyntaxesPreference 是Server默认最优先接受的transfer syntaxes
+var proposedTransferSyntaxesPreference = []string{dicomuid.ExplicitVRLittleEndian, dicomuid.ImplicitVRLittleEndian}
+
+// handle 回答一个DIMSE message. 返回error时association被abort
+func (a *serverAssociation) handle(ctx context.Context, msg *message) error {
+	pc, ok := a.contexts[msg.contextID]
+	if !ok {
+		return fmt.Errorf("message on unknown presentation context %d", msg.contextID)
+	}
+	commandField, err := commandUInt16(msg.command, dicomtag.CommandField)
+	if err != nil {
+		return err
+	}
+	messageID, err := commandUInt16(msg.command, dicomtag.MessageID)
+	if err != nil {
+		return err
+	}
+	sopClassUID, _ := commandString(msg.command, dicomtag.AffectedSOPClassUID)
+	rsp := []*dicom.Element{
+		dicom.MustNewElement(dicomtag.AffectedSOPClassUID, sopClassUID),
+		dicom.MustNewElement(dicomtag.CommandField, commandField|0x8000),
+		dicom.MustNewElement(dicomtag.MessageIDBeingRespondedTo, messageID),
+		dicom.MustNewElement(dicomtag.CommandDataSetType, uint16(commandDataSetTypeNull)),
+	}
+	status, comment := uint16(StatusSuccess), ""
+	switch {
+	case commandField == commandCEchoRq:
+	case commandField == commandCStoreRq && pc.abstractSyntax != dicomuid.VerificationSOPClass:
+		sopInstanceUID, _ := commandString(msg.command, dicomtag.AffectedSOPInstanceUID)
+		rsp = append(rsp, dicom.MustNewElement(dicomtag.AffectedSOPInstanceUID, sopInstanceUID))
+		status, comment = a.store(ctx, pc, sopClassUID, sopInstanceUID, msg.data)
+	default:
+		status, comment = StatusUnrecognizedCommand, fmt.Sprintf("command 0x%04x not supported", commandField)
+	}
+	rsp = append(rsp, dicom.MustNewElement(dicomtag.Status, status))
+	if comment != "" {
+		// ErrorComment是LO, 最多64个字符
+		if len(comment) > 64 {
+			comment = comment[:64]
+		}
+		rsp = append(rsp, dicom.MustNewElement(dicomtag.ErrorComment, comment))
+	}
+	return a.sendCommand(msg.contextID, rsp)
+}
+
+// store 解析C-STORE的data set并交给Handler, 返回response的status和ErrorComment
+func (a *serverAssociation) store(ctx context.Context, pc acceptedContext, sopClassUID, sopInstanceUID string, data []byte) (uint16, string) {
+	ds, err := decodeDataSet(data, sopClassUID, sopInstanceUID, pc.transferSyntax, a.req.CallingAETitle)
+	if err != nil {
+		return StatusCannotUnderstand, err.Error()
+	}
+	req := a.req
+	req.SOPClassUID = sopClassUID
+	req.SOPInstanceUID = sopInstanceUID
+	req.TransferSyntaxUID = pc.transferSyntax
+	req.DataSet = ds
+	if err := a.server.Handler.HandleStore(ctx, &req); err != nil {
+		if serr, ok := err.(*StatusError); ok {
+			return serr.Status, serr.Comment
+		}
+		return StatusProcessingFailure, err.Error()
+	}
+	return StatusSuccess, ""
+}
+
+// decodeDataSet 加上file meta group之后用dicom.ReadDataSet解析data, 这样
+// deflate, SpecificCharacterSet和pixel data都和读文件时一样处理
+func decodeDataSet(data []byte, sopClassUID, sopInstanceUID, transferSyntaxUID, callingAE string) (*dicom.DataSet, error) {
+	meta := []*dicom.Element{
+		dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, sopClassUID),
+		dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, sopInstanceUID),
+		dicom.MustNewElement(dicomtag.TransferSyntaxUID, transferSyntaxUID),
+	}
+	if callingAE != "" {
+		meta = append(meta, dicom.MustNewElement(dicomtag.SourceApplicationEntityTitle, callingAE))
+	}
+	var buf bytes.Buffer
+	e := dicomio.NewEncoder(&buf, nil, dicomio.UnknownVR)
+	dicom.WriteFileHeader(e, meta)
+	if err := e.Error(); err != nil {
+		return nil, err
+	}
+	buf.Write(data)
+	// 没有压缩时一个element不可能比收到的data长; deflate的data set解压后可能
+	// 更长, 只靠ReadBytes按实际读到的bytes分配内存
+	var maxSize int64
+	if transferSyntaxUID != dicomuid.DeflatedExplicitVRLittleEndian {
+		maxSize = int64(len(data))
+	}
+	return dicom.ReadDataSet(&buf, dicom.ReadOptions{MaxElementSize: maxSize})
+}
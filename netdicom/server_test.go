@@ -0,0 +1,286 @@
+package netdicom_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/odincare/odicom/netdicom/pdu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorage 记录收到的C-STORE requests
+type testStorage struct {
+	mu       sync.Mutex
+	requests []*netdicom.StoreRequest
+	err      error
+}
+
+func (s *testStorage) HandleStore(ctx context.Context, req *netdicom.StoreRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+	return s.err
+}
+
+func startServer(t *testing.T, s *netdicom.Server) (addr string, stop func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(listener) // nolint: errcheck
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+func TestServerStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dir, err := ioutil.TempDir("", "netdicom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	storage := &testStorage{}
+	addr, stop := startServer(t, &netdicom.Server{AETitle: "TESTSCP", Handler: storage, MaxPDUSize: 4096})
+	defer stop()
+
+	require.NoError(t, netdicom.Echo(ctx, addr, "TESTSCU", "TESTSCP"))
+
+	specs := []dicomtest.Spec{
+		{TransferSyntaxUID: dicomuid.ImplicitVRLittleEndian, Rows: 32, Columns: 32, BitsAllocated: 16, PatientName: "Server^Test"},
+		{TransferSyntaxUID: dicomtest.JPEGBaseline, NumberOfFrames: 2},
+	}
+	var paths []string
+	for i, spec := range specs {
+		path := filepath.Join(dir, spec.TransferSyntaxUID+".dcm")
+		require.NoError(t, dicomtest.WriteFile(path, spec), i)
+		paths = append(paths, path)
+	}
+	require.NoError(t, netdicom.StoreFiles(ctx, addr, "TESTSCU", "TESTSCP", paths))
+
+	require.Len(t, storage.requests, 2)
+	req := storage.requests[0]
+	assert.Equal(t, "TESTSCU", req.CallingAETitle)
+	assert.Equal(t, "TESTSCP", req.CalledAETitle)
+	// native data set用Explicit VR Little Endian发送
+	assert.Equal(t, dicomuid.ExplicitVRLittleEndian, req.TransferSyntaxUID)
+	elem, err := req.DataSet.FindElementByTag(dicomtag.MediaStorageSOPInstanceUID)
+	require.NoError(t, err)
+	assert.Equal(t, req.SOPInstanceUID, elem.MustGetString())
+	elem, err = req.DataSet.FindElementByTag(dicomtag.SourceApplicationEntityTitle)
+	require.NoError(t, err)
+	assert.Equal(t, "TESTSCU", elem.MustGetString())
+	elem, err = req.DataSet.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Server^Test", elem.MustGetString())
+	elem, err = req.DataSet.FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	assert.Equal(t, dicomtest.FramePixels(specs[0], 0), elem.Value[0].(dicom.PixelDataInfo).Frames[0])
+
+	req = storage.requests[1]
+	assert.Equal(t, dicomtest.JPEGBaseline, req.TransferSyntaxUID)
+	elem, err = req.DataSet.FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	image := elem.Value[0].(dicom.PixelDataInfo)
+	require.Len(t, image.Frames, 2)
+	for i, frame := range image.Frames {
+		assert.Equal(t, dicomtest.FramePixels(specs[1], i), frame)
+	}
+	// 收到的data set可以直接保存
+	path := filepath.Join(dir, "received.dcm")
+	require.NoError(t, dicom.WriteDataSetToFile(path, req.DataSet))
+	_, err = dicom.ReadDataSetFromFile(path, dicom.ReadOptions{})
+	require.NoError(t, err)
+}
+
+func TestServerErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ds, err := dicomtest.NewDataSet(dicomtest.Spec{})
+	require.NoError(t, err)
+
+	storage := &testStorage{err: &netdicom.StatusError{Status: netdicom.StatusOutOfResources, Comment: "disk full"}}
+	addr, stop := startServer(t, &netdicom.Server{AETitle: "TESTSCP", Handler: storage})
+	defer stop()
+
+	err = netdicom.Echo(ctx, addr, "TESTSCU", "OTHERSCP")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "A-ASSOCIATE-RJ")
+
+	err = netdicom.Store(ctx, addr, "TESTSCU", "TESTSCP", ds)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0xa700: disk full")
+
+	storage.err = errors.New("failed")
+	err = netdicom.Store(ctx, addr, "TESTSCU", "TESTSCP", ds)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0x0110: failed")
+	require.Len(t, storage.requests, 2)
+
+	// SOPClasses之外的SOP Class被拒绝
+	addr2, stop2 := startServer(t, &netdicom.Server{Handler: storage, SOPClasses: []string{"1.2.840.10008.5.1.4.1.1.4"}})
+	defer stop2()
+	require.NoError(t, netdicom.Echo(ctx, addr2, "TESTSCU", "ANY-SCP"))
+	require.Error(t, netdicom.Store(ctx, addr2, "TESTSCU", "ANY-SCP", ds))
+	assert.Len(t, storage.requests, 2)
+
+	// 没有Handler时只接受Verification
+	addr3, stop3 := startServer(t, &netdicom.Server{})
+	defer stop3()
+	require.NoError(t, netdicom.Echo(ctx, addr3, "TESTSCU", "ANY-SCP"))
+	require.Error(t, netdicom.Store(ctx, addr3, "TESTSCU", "ANY-SCP", ds))
+}
+
+func TestAssociationInfoAndAbort(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	addr, stop := startServer(t, &netdicom.Server{AETitle: "TESTSCP", MaxPDUSize: 8192})
+	defer stop()
+
+	a, err := netdicom.Dial(ctx, addr, "TESTSCU", "TESTSCP", []string{dicomuid.VerificationSOPClass, dicomuid.CTImageStorage})
+	require.NoError(t, err)
+	info := a.Info()
+	assert.Equal(t, "TESTSCU", info.CallingAETitle)
+	assert.Equal(t, "TESTSCP", info.CalledAETitle)
+	assert.Equal(t, uint32(8192), info.PeerMaxPDUSize)
+	assert.Equal(t, dicom.GoDICOMImplementationClassUID, info.PeerImplementationClassUID)
+	// Handler为nil的Server只接受Verification
+	assert.Equal(t, []netdicom.AcceptedContext{
+		{ID: 1, AbstractSyntax: dicomuid.VerificationSOPClass, TransferSyntax: dicomuid.ExplicitVRLittleEndian}}, info.Contexts)
+
+	require.NoError(t, a.Echo(ctx))
+	require.NoError(t, a.Abort())
+	assert.Error(t, a.Echo(ctx))
+}
+
+// sendRaw 把p编码后写到conn
+func sendRaw(t *testing.T, conn net.Conn, p pdu.PDU) {
+	b, err := pdu.EncodePDU(p)
+	require.NoError(t, err)
+	_, err = conn.Write(b)
+	require.NoError(t, err)
+}
+
+// associateRaw 像一个SCU一样associate, 只提议CT Image Storage (Explicit VR Little Endian)
+func associateRaw(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+	sendRaw(t, conn, &pdu.AAssociate{
+		PDUType:         pdu.TypeAAssociateRq,
+		ProtocolVersion: 1,
+		CalledAETitle:   "TESTSCP",
+		CallingAETitle:  "TESTSCU",
+		Items: []pdu.SubItem{
+			&pdu.ApplicationContextItem{Name: "1.2.840.10008.3.1.1.1"},
+			&pdu.PresentationContextItem{ItemType: pdu.ItemTypePresentationContextRequest, ContextID: 1, Items: []pdu.SubItem{
+				&pdu.AbstractSyntaxSubItem{Name: dicomuid.CTImageStorage},
+				&pdu.TransferSyntaxSubItem{Name: dicomuid.ExplicitVRLittleEndian}}},
+			&pdu.UserInformationItem{Items: []pdu.SubItem{
+				&pdu.UserInformationMaximumLengthItem{MaximumLengthReceived: pdu.DefaultMaxPDUSize}}},
+		},
+	})
+	p, err := pdu.ReadPDU(conn, pdu.DefaultMaxPDUSize)
+	require.NoError(t, err)
+	ac, ok := p.(*pdu.AAssociate)
+	require.True(t, ok, "%v", p)
+	require.Equal(t, pdu.TypeAAssociateAc, ac.PDUType)
+	return conn
+}
+
+// storeCommand 编码一个CT Image Storage的C-STORE-RQ command set
+func storeCommand(t *testing.T) []byte {
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ImplicitVR)
+	for _, elem := range []*dicom.Element{
+		dicom.MustNewElement(dicomtag.CommandGroupLength, uint32(0)),
+		dicom.MustNewElement(dicomtag.AffectedSOPClassUID, dicomuid.CTImageStorage),
+		dicom.MustNewElement(dicomtag.CommandField, uint16(0x0001)),
+		dicom.MustNewElement(dicomtag.MessageID, uint16(1)),
+		dicom.MustNewElement(dicomtag.Priority, uint16(0)),
+		dicom.MustNewElement(dicomtag.CommandDataSetType, uint16(0x0000)),
+		dicom.MustNewElement(dicomtag.AffectedSOPInstanceUID, "1.2.3.4"),
+	} {
+		dicom.WriteElement(e, elem)
+	}
+	require.NoError(t, e.Error())
+	return e.Bytes()
+}
+
+// storeRaw 像一个SCU一样associate, 然后用C-STORE发送data (Explicit VR Little Endian),
+// 返回response的Status
+func storeRaw(t *testing.T, addr string, data []byte) uint16 {
+	conn := associateRaw(t, addr)
+	defer conn.Close()
+	send := func(p pdu.PDU) { sendRaw(t, conn, p) }
+	send(&pdu.PDataTf{Items: []pdu.PresentationDataValueItem{{ContextID: 1, Command: true, Last: true, Value: storeCommand(t)}}})
+	send(&pdu.PDataTf{Items: []pdu.PresentationDataValueItem{{ContextID: 1, Last: true, Value: data}}})
+
+	p, err := pdu.ReadPDU(conn, pdu.DefaultMaxPDUSize)
+	require.NoError(t, err)
+	pdata, ok := p.(*pdu.PDataTf)
+	require.True(t, ok, "%v", p)
+	require.Len(t, pdata.Items, 1)
+	rsp := decodeTestCommand(t, pdata.Items[0].Value)
+	return rsp[dicomtag.Status].MustGetUInt16()
+}
+
+func TestServerBadPeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	storage := &testStorage{}
+	addr, stop := startServer(t, &netdicom.Server{AETitle: "TESTSCP", Handler: storage})
+	defer stop()
+
+	// 被截断的OW element: VL远远超过收到的data, 回答StatusCannotUnderstand, 不会分配VL那么多的内存
+	truncated := []byte{0x00, 0x40, 0x00, 0x10, 'O', 'W', 0, 0, 0xf0, 0xff, 0xff, 0x7f, 1, 2, 3, 4}
+	assert.Equal(t, uint16(netdicom.StatusCannotUnderstand), storeRaw(t, addr, truncated))
+	assert.Len(t, storage.requests, 0)
+
+	// 对方发送A-ABORT之后server直接关闭连接, 不回A-ABORT
+	conn := associateRaw(t, addr)
+	defer conn.Close()
+	sendRaw(t, conn, &pdu.AAbort{})
+	p, err := pdu.ReadPDU(conn, pdu.DefaultMaxPDUSize)
+	require.Error(t, err, "%v", p)
+
+	// Handler里的panic只abort这一个association
+	ds, err := dicomtest.NewDataSet(dicomtest.Spec{})
+	require.NoError(t, err)
+	addr2, stop2 := startServer(t, &netdicom.Server{AETitle: "TESTSCP", Handler: netdicom.StorageHandlerFunc(
+		func(ctx context.Context, req *netdicom.StoreRequest) error { panic("bad handler") })})
+	defer stop2()
+	require.Error(t, netdicom.Store(ctx, addr2, "TESTSCU", "TESTSCP", ds))
+	require.NoError(t, netdicom.Echo(ctx, addr2, "TESTSCU", "TESTSCP"))
+
+	require.NoError(t, netdicom.Echo(ctx, addr, "TESTSCU", "TESTSCP"))
+}
+
+func TestServerMaxMessageSize(t *testing.T) {
+	storage := &testStorage{}
+	addr, stop := startServer(t, &netdicom.Server{AETitle: "TESTSCP", Handler: storage, MaxMessageSize: 4096})
+	defer stop()
+
+	// 一直发送没有Last的data set fragments, 超过MaxMessageSize时server abort
+	conn := associateRaw(t, addr)
+	defer conn.Close()
+	sendRaw(t, conn, &pdu.PDataTf{Items: []pdu.PresentationDataValueItem{{ContextID: 1, Command: true, Last: true, Value: storeCommand(t)}}})
+	fragment := make([]byte, 1000)
+	for i := 0; i < 5; i++ {
+		sendRaw(t, conn, &pdu.PDataTf{Items: []pdu.PresentationDataValueItem{{ContextID: 1, Value: fragment}}})
+	}
+	p, err := pdu.ReadPDU(conn, pdu.DefaultMaxPDUSize)
+	require.NoError(t, err)
+	assert.IsType(t, &pdu.AAbort{}, p)
+	assert.Len(t, storage.requests, 0)
+}
@@ -0,0 +1,213 @@
+package netdicom
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// DIMSE command fields, P3.7 E.1
+const (
+	commandCStoreRq  = 0x0001
+	commandCStoreRsp = 0x8001
+)
+
+// commandDataSetTypePresent 表示command后面有data set (0x0101以外的任何值)
+const commandDataSetTypePresent = 0x0000
+
+// nativeTransferSyntaxes 之间可以直接转换, 不需要解码pixel data
+var nativeTransferSyntaxes = map[string]bool{
+	dicomuid.ImplicitVRLittleEndian:         true,
+	dicomuid.ExplicitVRLittleEndian:         true,
+	dicomuid.ExplicitVRBigEndian:            true,
+	dicomuid.DeflatedExplicitVRLittleEndian: true,
+}
+
+// storeInfo 返回ds的SOP Class UID, SOP Instance UID和transfer syntax
+func storeInfo(ds *dicom.DataSet) (sopClassUID, sopInstanceUID, transferSyntaxUID string, err error) {
+	get := func(tags ...dicomtag.Tag) (string, error) {
+		for _, tag := range tags {
+			if elem, err := ds.FindElementByTag(tag); err == nil {
+				return elem.GetString()
+			}
+		}
+		return "", fmt.Errorf("%v not found", dicomtag.DebugString(tags[0]))
+	}
+	if sopClassUID, err = get(dicomtag.SOPClassUID, dicomtag.MediaStorageSOPClassUID); err != nil {
+		return "", "", "", err
+	}
+	if sopInstanceUID, err = get(dicomtag.SOPInstanceUID, dicomtag.MediaStorageSOPInstanceUID); err != nil {
+		return "", "", "", err
+	}
+	if transferSyntaxUID, err = get(dicomtag.TransferSyntaxUID); err != nil {
+		transferSyntaxUID = dicomuid.ImplicitVRLittleEndian
+	}
+	return sopClassUID, sopInstanceUID, transferSyntaxUID, nil
+}
+
+// storeContext 返回发送SOP Class为sopClassUID, transfer syntax为transferSyntaxUID的
+// data set需要的presentation context. Native的data set可以用任何native transfer
+// syntax发送, 压缩的只能用原来的transfer syntax
+func storeContext(sopClassUID, transferSyntaxUID string) PresentationContext {
+	if nativeTransferSyntaxes[transferSyntaxUID] {
+		return PresentationContext{AbstractSyntax: sopClassUID, TransferSyntaxes: []string{
+			dicomuid.ExplicitVRLittleEndian, dicomuid.ImplicitVRLittleEndian}}
+	}
+	return PresentationContext{AbstractSyntax: sopClassUID, TransferSyntaxes: []string{transferSyntaxUID}}
+}
+
+// encodeDataSet 用transferSyntaxUID编码ds, 不包括preamble和file meta group
+func encodeDataSet(ds *dicom.DataSet, sopClassUID, sopInstanceUID, transferSyntaxUID string) ([]byte, error) {
+	elems := []*dicom.Element{
+		dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, sopClassUID),
+		dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, sopInstanceUID),
+		dicom.MustNewElement(dicomtag.TransferSyntaxUID, transferSyntaxUID),
+	}
+	for _, elem := range ds.Elements {
+		if elem.Tag.Group != dicomtag.MetadataGroup {
+			elems = append(elems, elem)
+		}
+	}
+	var buf bytes.Buffer
+	if err := dicom.WriteDataSet(&buf, &dicom.DataSet{Elements: elems}); err != nil {
+		return nil, err
+	}
+	// WriteDataSet先写128 bytes的preamble, "DICM"和FileMetaInformationGroupLength (12 bytes)
+	const headerSize = 128 + 4 + 12
+	data := buf.Bytes()
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("invalid file header")
+	}
+	metaSize := int(binary.LittleEndian.Uint32(data[headerSize-4:]))
+	if len(data) < headerSize+metaSize {
+		return nil, fmt.Errorf("invalid file header")
+	}
+	return data[headerSize+metaSize:], nil
+}
+
+// Store sends "ds" to the peer with a C-STORE request (P3.7 9.1.1) and
+// waits for the response. The association must have accepted a
+// presentation context for the SOP Class of "ds" (SOPClassUID), with its
+// transfer syntax; a data set in a native (uncompressed) transfer syntax
+// may also be sent in another native one. Warning statuses count as
+// success. The deadline of "ctx" applies.
+func (a *ClientAssociation) Store(ctx context.Context, ds *dicom.DataSet) error {
+	sopClassUID, sopInstanceUID, transferSyntaxUID, err := storeInfo(ds)
+	if err != nil {
+		return fmt.Errorf("netdicom.Store: %v", err)
+	}
+	pc, ok := a.findContext(sopClassUID, func(ts string) bool { return ts == transferSyntaxUID })
+	if !ok && nativeTransferSyntaxes[transferSyntaxUID] {
+		pc, ok = a.findContext(sopClassUID, func(ts string) bool { return nativeTransferSyntaxes[ts] })
+	}
+	if !ok {
+		return fmt.Errorf("netdicom.Store: no presentation context accepted for %s with %s",
+			dicomuid.UIDString(sopClassUID), dicomuid.UIDString(transferSyntaxUID))
+	}
+	data, err := encodeDataSet(ds, sopClassUID, sopInstanceUID, pc.transferSyntax)
+	if err != nil {
+		return fmt.Errorf("netdicom.Store: %v", err)
+	}
+
+	a.messageID++
+	messageID := a.messageID
+	err = a.withContext(ctx, func() error {
+		err := a.sendCommand(pc.id, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.AffectedSOPClassUID, sopClassUID),
+			dicom.MustNewElement(dicomtag.CommandField, uint16(commandCStoreRq)),
+			dicom.MustNewElement(dicomtag.MessageID, messageID),
+			dicom.MustNewElement(dicomtag.Priority, uint16(0)),
+			dicom.MustNewElement(dicomtag.CommandDataSetType, uint16(commandDataSetTypePresent)),
+			dicom.MustNewElement(dicomtag.AffectedSOPInstanceUID, sopInstanceUID),
+		})
+		if err != nil {
+			return err
+		}
+		if err := a.sendPDVs(pc.id, false, data); err != nil {
+			return err
+		}
+		rsp, err := a.receiveCommand()
+		if err != nil {
+			return err
+		}
+		return checkResponse(rsp, commandCStoreRsp, messageID)
+	})
+	if err != nil {
+		return fmt.Errorf("netdicom.Store: %s: %w", sopInstanceUID, err)
+	}
+	return nil
+}
+
+// Store opens an association with "calledAE" at "addr" as "callingAE",
+// sends "ds" with a C-STORE, and releases the association. See
+// ClientAssociation.Store.
+func Store(ctx context.Context, addr, callingAE, calledAE string, ds *dicom.DataSet) error {
+	sopClassUID, _, transferSyntaxUID, err := storeInfo(ds)
+	if err != nil {
+		return fmt.Errorf("netdicom.Store: %v", err)
+	}
+	a, err := DialContexts(ctx, addr, callingAE, calledAE, []PresentationContext{storeContext(sopClassUID, transferSyntaxUID)})
+	if err != nil {
+		return err
+	}
+	if err := a.Store(ctx, ds); err != nil {
+		a.Close() // nolint: errcheck
+		return err
+	}
+	return a.Close()
+}
+
+// StoreFiles sends the DICOM files at "paths" to "calledAE" at "addr" on
+// one association, proposing a presentation context for each combination
+// of SOP Class and transfer syntax found in the files. The files are read
+// twice, first without pixel data to negotiate the association, so that
+// only one file is in memory at a time. It stops at the first error.
+func StoreFiles(ctx context.Context, addr, callingAE, calledAE string, paths []string) error {
+	_, err := storeFiles(ctx, addr, callingAE, calledAE, paths)
+	return err
+}
+
+// storeFiles 与StoreFiles相同, 另外返回成功发送的文件数
+func storeFiles(ctx context.Context, addr, callingAE, calledAE string, paths []string) (int, error) {
+	var contexts []PresentationContext
+	proposed := map[string]bool{}
+	for _, path := range paths {
+		ds, err := dicom.ReadDataSetFromFile(path, dicom.ReadOptions{DropPixelData: true})
+		if err != nil {
+			return 0, fmt.Errorf("netdicom.StoreFiles: %s: %v", path, err)
+		}
+		sopClassUID, _, transferSyntaxUID, err := storeInfo(ds)
+		if err != nil {
+			return 0, fmt.Errorf("netdicom.StoreFiles: %s: %v", path, err)
+		}
+		pc := storeContext(sopClassUID, transferSyntaxUID)
+		key := fmt.Sprint(pc.AbstractSyntax, pc.TransferSyntaxes)
+		if !proposed[key] {
+			proposed[key] = true
+			contexts = append(contexts, pc)
+		}
+	}
+	if len(contexts) == 0 {
+		return 0, nil
+	}
+	a, err := DialContexts(ctx, addr, callingAE, calledAE, contexts)
+	if err != nil {
+		return 0, err
+	}
+	for i, path := range paths {
+		ds, err := dicom.ReadDataSetFromFile(path, dicom.ReadOptions{})
+		if err == nil {
+			err = a.Store(ctx, ds)
+		}
+		if err != nil {
+			a.Close() // nolint: errcheck
+			return i, fmt.Errorf("netdicom.StoreFiles: %s: %w", path, err)
+		}
+	}
+	return len(paths), a.Close()
+}
@@ -0,0 +1,98 @@
+package netdicom_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/odincare/odicom/netdicom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// 16x16x16bit的pixel data需要分成多个PDU
+	spec := dicomtest.Spec{Rows: 16, Columns: 16, BitsAllocated: 16, PatientName: "Store^Test"}
+	ds, err := dicomtest.NewDataSet(spec)
+	require.NoError(t, err)
+
+	s := newFakeSCP(t, false, 0)
+	require.NoError(t, netdicom.Store(ctx, s.addr(), "TESTSCU", "ANY-SCP", ds))
+	s.wait()
+	assert.True(t, s.released)
+	require.Len(t, s.stored, 1)
+	elem, err := s.stored[0].FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	assert.Equal(t, "Store^Test", elem.MustGetString())
+	_, err = s.stored[0].FindElementByTag(dicomtag.TransferSyntaxUID)
+	assert.Error(t, err, "file meta group must not be sent")
+	elem, err = s.stored[0].FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	assert.Len(t, elem.Value[0].(dicom.PixelDataInfo).Frames[0], 16*16*2)
+
+	// Warning也算成功
+	s = newFakeSCP(t, false, 0xb000)
+	require.NoError(t, netdicom.Store(ctx, s.addr(), "TESTSCU", "ANY-SCP", ds))
+	s.wait()
+
+	s = newFakeSCP(t, false, 0xa700)
+	err = netdicom.Store(ctx, s.addr(), "TESTSCU", "ANY-SCP", ds)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0xa700")
+	s.wait()
+
+	// 没有被接受的presentation context
+	s = newFakeSCP(t, false, 0)
+	a, err := netdicom.Dial(ctx, s.addr(), "TESTSCU", "ANY-SCP", []string{"1.2.840.10008.1.1"})
+	require.NoError(t, err)
+	require.Error(t, a.Store(ctx, ds))
+	require.NoError(t, a.Close())
+	s.wait()
+	assert.Empty(t, s.stored)
+}
+
+func TestStoreFiles(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dir, err := ioutil.TempDir("", "netdicom")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	specs := []dicomtest.Spec{
+		{TransferSyntaxUID: dicomuid.ImplicitVRLittleEndian},
+		{TransferSyntaxUID: dicomuid.ExplicitVRBigEndian, BitsAllocated: 16},
+		{TransferSyntaxUID: dicomtest.JPEGBaseline, NumberOfFrames: 3},
+	}
+	var paths []string
+	for i, spec := range specs {
+		path := filepath.Join(dir, spec.TransferSyntaxUID+".dcm")
+		require.NoError(t, dicomtest.WriteFile(path, spec), i)
+		paths = append(paths, path)
+	}
+
+	s := newFakeSCP(t, false, 0)
+	require.NoError(t, netdicom.StoreFiles(ctx, s.addr(), "TESTSCU", "ANY-SCP", paths))
+	s.wait()
+	require.Len(t, s.stored, 3)
+	// 压缩的data set用原来的transfer syntax发送
+	elem, err := s.stored[2].FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	image := elem.Value[0].(dicom.PixelDataInfo)
+	require.Len(t, image.Frames, 3)
+	for i, frame := range image.Frames {
+		assert.Equal(t, dicomtest.FramePixels(specs[2], i), frame)
+	}
+
+	err = netdicom.StoreFiles(ctx, s.addr(), "TESTSCU", "ANY-SCP", []string{filepath.Join(dir, "missing.dcm")})
+	require.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package dicom
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomlog"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/sirupsen/logrus"
+)
+
+// ReadOption configures Read and ReadFile. Options are applied in order to
+// the zero ReadOptions, so a later option overrides an earlier one. New
+// reading features are added as options; use them instead of ReadOptions
+// literals in new code.
+type ReadOption interface {
+	applyRead(*ReadOptions)
+}
+
+// WriteOption configures Write and WriteFile.
+type WriteOption interface {
+	applyWrite(*WriteOptions)
+}
+
+// Option is accepted by both Read and Write, e.g., WithLogger.
+type Option interface {
+	ReadOption
+	WriteOption
+}
+
+// WriteOptions 是Write的设置, 只能通过WriteOption修改
+type WriteOptions struct {
+	// logger 是写入时warnings的输出, 见WithLogger
+	logger dicomlog.Logger
+
+	// ctx 如果不为nil, 在每个element之前检查它是否被取消, 见WithContext
+	ctx context.Context
+}
+
+type readOptionFunc func(*ReadOptions)
+
+func (f readOptionFunc) applyRead(o *ReadOptions) { f(o) }
+
+// NewReadOptions returns the ReadOptions configured by "opts", for APIs
+// that still take a ReadOptions, e.g., NewParser.
+func NewReadOptions(opts ...ReadOption) ReadOptions {
+	var o ReadOptions
+	for _, opt := range opts {
+		opt.applyRead(&o)
+	}
+	return o
+}
+
+// WithReadOptions sets all the fields of ReadOptions at once, to migrate
+// code that builds a ReadOptions. Later options still apply.
+func WithReadOptions(options ReadOptions) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) {
+		logger := o.logger
+		*o = options
+		o.logger = logger
+	})
+}
+
+// WithDropPixelData sets ReadOptions.DropPixelData.
+func WithDropPixelData() ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.DropPixelData = true })
+}
+
+// WithStopAtTag sets ReadOptions.StopAtTag.
+func WithStopAtTag(tag dicomtag.Tag) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.StopAtTag = &tag })
+}
+
+// WithStopAtTagInclusive sets ReadOptions.StopAtTagInclusive.
+func WithStopAtTagInclusive() ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.StopAtTagInclusive = true })
+}
+
+// WithStopAfterGroup sets ReadOptions.StopAfterGroup.
+func WithStopAfterGroup(group uint16) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.StopAfterGroup = &group })
+}
+
+// WithReturnTags sets ReadOptions.ReturnTags.
+func WithReturnTags(tags ...dicomtag.Tag) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.ReturnTags = tags })
+}
+
+// WithSkipTags adds to ReadOptions.SkipTags.
+func WithSkipTags(tags ...dicomtag.Tag) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.SkipTags = append(o.SkipTags, tags...) })
+}
+
+// WithSkipPrivateTags sets ReadOptions.SkipPrivateTags.
+func WithSkipPrivateTags() ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.SkipPrivateTags = true })
+}
+
+// WithElementFilter sets ReadOptions.ElementFilter.
+func WithElementFilter(filter func(tag dicomtag.Tag) bool) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.ElementFilter = filter })
+}
+
+// WithAllowTrailingData sets ReadOptions.AllowTrailingData.
+func WithAllowTrailingData() ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.AllowTrailingData = true })
+}
+
+// WithInternStrings sets ReadOptions.InternStrings.
+func WithInternStrings() ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.InternStrings = true })
+}
+
+// WithDuplicates sets ReadOptions.Duplicates.
+func WithDuplicates(policy DuplicatePolicy) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.Duplicates = policy })
+}
+
+// WithFramePool sets ReadOptions.FramePool.
+func WithFramePool(pool *FramePool) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.FramePool = pool })
+}
+
+// WithCheckFrameCount sets ReadOptions.CheckFrameCount.
+func WithCheckFrameCount() ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.CheckFrameCount = true })
+}
+
+// WithMaxElementSize sets ReadOptions.MaxElementSize.
+func WithMaxElementSize(n int64) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.MaxElementSize = n })
+}
+
+// WithOnProgress sets ReadOptions.OnProgress.
+func WithOnProgress(fn func(bytesRead, totalBytes int64)) ReadOption {
+	return readOptionFunc(func(o *ReadOptions) { o.OnProgress = fn })
+}
+
+type loggerOption struct {
+	logger dicomlog.Logger
+}
+
+func (o loggerOption) applyRead(r *ReadOptions)   { r.logger = o.logger }
+func (o loggerOption) applyWrite(w *WriteOptions) { w.logger = o.logger }
+
+// WithLogger writes the warnings of one Read or Write to "l" instead of the
+// logger set by dicomlog.SetLogger, e.g., to add the path or request ID of
+// the file with l = logger.WithField(...). The dicomlog level and sampling
+// still apply.
+func WithLogger(l logrus.FieldLogger) Option {
+	return loggerOption{logger: dicomlog.To(l)}
+}
+
+type contextOption struct {
+	ctx context.Context
+}
+
+func (o contextOption) applyRead(r *ReadOptions)   { r.ctx = o.ctx }
+func (o contextOption) applyWrite(w *WriteOptions) { w.ctx = o.ctx }
+
+// WithContext makes Read and Write stop with ctx.Err() when "ctx" is
+// canceled. Cancellation is checked between top-level elements, so a
+// single element, e.g., a large PixelData, is still read or written whole.
+func WithContext(ctx context.Context) Option {
+	return contextOption{ctx: ctx}
+}
+
+// Read is ReadDataSet configured with "opts":
+//
+//	ds, err := dicom.Read(in, dicom.WithDropPixelData(), dicom.WithLogger(l))
+func Read(in io.Reader, opts ...ReadOption) (*DataSet, error) {
+	return ReadDataSet(in, NewReadOptions(opts...))
+}
+
+// ReadFile is ReadDataSetFromFile configured with "opts".
+func ReadFile(path string, opts ...ReadOption) (*DataSet, error) {
+	return ReadDataSetFromFile(path, NewReadOptions(opts...))
+}
+
+// Write is WriteDataSet configured with "opts".
+func Write(out io.Writer, ds *DataSet, opts ...WriteOption) error {
+	var o WriteOptions
+	for _, opt := range opts {
+		opt.applyWrite(&o)
+	}
+	return writeDataSet(dicomio.NewBufferedEncoder(out, nil, dicomio.UnknownVR), ds, o)
+}
+
+// WriteFile is WriteDataSetToFile configured with "opts".
+func WriteFile(path string, ds *DataSet, opts ...WriteOption) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(out, ds, opts...); err != nil {
+		out.Close() // nolint: errcheck
+		return err
+	}
+	return out.Close()
+}
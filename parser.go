@@ -0,0 +1,318 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomlog"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// Parser reads a DICOM file one element at a time, so that large files can
+// be processed without keeping the whole DataSet in memory. ReadDataSet is a
+// Parser that collects all the elements.
+//
+// Example:
+//
+//  p, err := dicom.NewParser(in, dicom.ReadOptions{DropPixelData: true})
+//  if err != nil {
+//    return err
+//  }
+//  for {
+//    elem, err := p.Next()
+//    if err == io.EOF {
+//      break
+//    }
+//    if err != nil {
+//      return err
+//    }
+//    ...
+//  }
+type Parser struct {
+	d       *dicomio.Decoder
+	options ReadOptions
+
+	// meta 是还没有被Next返回的meta elements
+	meta []*Element
+
+	// wanted 决定哪些element被读取, 见ReadDataSet
+	wanted   func(tag dicomtag.Tag) bool
+	implicit dicomio.IsImplicitVR
+	// size 是输入的bytes, 不知道时是-1. 用来检查trailing data, 见isPlausibleElementHeader
+	size int64
+
+	// PixelRepresentation决定了"US or SS" element的VR, 见resolveUSOrSS
+	pixelRepresentation uint16
+
+	// imageAttrs 保存nativeFrameTags的element, 用来把native PixelData拆成frames
+	imageAttrs DataSet
+
+	// stopped 在遇到options.StopAtTag或者被丢弃的PixelData后为true
+	stopped      bool
+	trailingData []byte
+
+	// diagnostics 是读取时发现的问题, 见DataSet.Diagnostics
+	diagnostics []Diagnostic
+}
+
+// NewParser reads the file meta header from "in" and returns a Parser for
+// the rest of the file. "options" has the same meaning as for ReadDataSet.
+func NewParser(in io.Reader, options ReadOptions) (p *Parser, err error) {
+	defer dicomio.Recover(&err)
+	size := inputSize(in)
+	if options.OnProgress != nil {
+		in = newProgressReader(in, options.OnProgress)
+	}
+	d := dicomio.NewDecoder(in, binary.LittleEndian, dicomio.ExplicitVR)
+	metaElements := ParseFileHeader(d)
+	if d.Error() != nil {
+		return nil, d.Error()
+	}
+
+	// 改变剩余文件的 transfer syntax. Deflated的文件从这里开始inflate
+	meta := &DataSet{Elements: metaElements}
+	if _, _, err := getTransferSyntax(meta); err != nil {
+		return nil, err
+	}
+	uid, _ := meta.transferSyntaxUID()
+	if uid == dicomuid.DeflatedExplicitVRLittleEndian {
+		// inflate之后的长度不知道
+		size = -1
+	}
+	d.PushTransferSyntaxByUID(uid)
+	_, implicit := d.TransferSyntax()
+
+	p = &Parser{
+		d:        d,
+		options:  options,
+		meta:     metaElements,
+		implicit: implicit,
+		size:     size,
+		// 不需要的element直接跳过. SpecificCharacterSet总是要读, 因为后面的string需要它来解码.
+		// PixelRepresentation决定"US or SS"的VR, 见resolveUSOrSS. nativeFrameTags也总是要读,
+		// 见splitNativeFrames
+		wanted: func(tag dicomtag.Tag) bool {
+			return tag == dicomtag.SpecificCharacterSet || tag == dicomtag.PixelRepresentation ||
+				nativeFrameTags[tag] || options.wantsTag(tag)
+		},
+	}
+	p.options.diagnose = func(d Diagnostic) { p.diagnostics = append(p.diagnostics, d) }
+	return p, nil
+}
+
+// Next returns the next element in the file: first the file meta elements,
+// then the top-level elements of the data set, with their sequences fully
+// read, in the order they appear in the file, including duplicates (see
+// ReadOptions.Duplicates for ReadDataSet). Native multi-frame PixelData is split into one PixelDataInfo.Frames
+// entry per frame, using the Rows, Columns, SamplesPerPixel, BitsAllocated
+// and NumberOfFrames read before it. Elements excluded by the ReadOptions are skipped. Next returns
+// io.EOF after the last element. Once Next returns an error, it keeps
+// returning it.
+func (p *Parser) Next() (elem *Element, err error) {
+	defer p.keepError(&err)
+	defer dicomio.Recover(&err)
+	if len(p.meta) > 0 {
+		elem := p.meta[0]
+		p.meta = p.meta[1:]
+		return elem, nil
+	}
+	for {
+		if p.options.ctx != nil {
+			if err := p.options.ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if p.d.EOF() || p.stopped {
+			if err := p.d.Error(); err != nil {
+				if err == io.EOF {
+					// 文件在element中间结束了, 不能和正常的结束混淆
+					return nil, io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		remaining := int64(-1)
+		if p.size >= 0 {
+			remaining = p.size - p.d.BytesRead()
+		}
+		if p.options.AllowTrailingData && !isPlausibleElementHeader(p.d, remaining) {
+			offset := p.d.BytesRead()
+			data, err := ioutil.ReadAll(p.d)
+			if err != nil {
+				p.d.SetError(err)
+			}
+			p.trailingData = data
+			if len(data) > 0 {
+				p.diagnostics = append(p.diagnostics, newDiagnostic(DiagnosticWarning, dicomtag.Tag{}, offset,
+					"%d bytes of trailing data after the last element", len(data)))
+			}
+			p.stopped = true
+			continue
+		}
+
+		startLen := p.d.BytesRead()
+
+		elem := readElement(p.d, p.options, p.wanted)
+
+		if p.d.BytesRead() <= startLen { // 避免无限循环
+			p.d.SetErrorf("ReadElement 读取data失败：position：%d: %v", startLen, p.d.Error())
+			continue
+		}
+
+		if elem == endOfDataElement {
+			// element 是一个被options丢弃的pixel data
+			p.stopped = true
+			continue
+		}
+
+		if elem == skippedElement || elem == nil {
+			// nil是读取错误, 下一次循环会返回它
+			continue
+		}
+
+		if elem.Tag == dicomtag.PixelRepresentation {
+			if v, err := elem.GetUInt16(); err == nil {
+				p.pixelRepresentation = v
+			}
+		}
+		if p.implicit == dicomio.ImplicitVR && p.pixelRepresentation == 1 {
+			resolveUSOrSS(elem)
+		}
+		if nativeFrameTags[elem.Tag] {
+			p.imageAttrs.setElement(elem)
+		}
+		if elem.Tag == dicomtag.PixelData {
+			if err := splitNativeFrames(&p.imageAttrs, elem); err != nil {
+				p.options.logger.Warn("dicom.Parser: can't split native PixelData into frames", dicomlog.Fields{"error": err.Error()})
+				p.diagnostics = append(p.diagnostics, newDiagnostic(DiagnosticWarning, elem.Tag, startLen,
+					"can't split native PixelData into frames: %v", err))
+			}
+		}
+
+		if elem.Tag == dicomtag.SpecificCharacterSet {
+			// 将剩余文件设为[]byte -> string decoder
+			// It's sad that SpecificCharacterSet isn't part
+			// of metadata, but is part of regular attrs, so we need
+			// to watch out for multiple occurrences of this type of
+			// elements.
+			encodingNames, err := elem.GetStrings()
+			if err != nil {
+				p.d.SetError(err)
+			} else {
+				// SpecificCharacterSet 也许会出现在一个SQ/NA中，在这种情况下,
+				// 这个charset是被固定在SQ/NA内，所以我们需要一个stack来记录？这些charset
+				cs, err := dicomio.ParseSpecificCharacterSet(encodingNames)
+				if err != nil {
+					p.d.SetError(err)
+				} else {
+					p.d.SetCodingSystem(cs)
+				}
+			}
+		}
+
+		// 读到一半出错的element(例如没有结束的SQ)也会被返回, 错误在下一次Next时返回
+		if p.options.wantsTag(elem.Tag) {
+			if p.options.InternStrings {
+				internStrings(elem)
+			}
+			return elem, nil
+		}
+	}
+}
+
+// keepError 让Next从panic恢复的error在之后的调用中也被返回
+func (p *Parser) keepError(err *error) {
+	if *err != nil && *err != io.EOF && p.d.Error() == nil {
+		p.d.SetError(*err)
+	}
+}
+
+// normalizeElementOrder 把不是按tag顺序出现的top-level elements (例如PixelData之后还有element)
+// 按tag排序(stable), 并按p.options.Duplicates处理重复的tag. 顺序不对或有重复时输出warning
+func (p *Parser) normalizeElementOrder(elems []*Element) ([]*Element, error) {
+	policy := p.options.Duplicates
+	outOfOrder, duplicated := false, false
+	for i := 1; i < len(elems); i++ {
+		switch elems[i].Tag.Compare(elems[i-1].Tag) {
+		case -1:
+			outOfOrder = true
+		case 0:
+			duplicated = true
+		}
+	}
+	if outOfOrder {
+		p.options.logger.Warn("dicom.ReadDataSet: elements not in tag order", dicomlog.Fields{})
+		p.diagnostics = append(p.diagnostics, newDiagnostic(DiagnosticRepair, dicomtag.Tag{}, -1, "elements not in tag order, sorted"))
+		sort.SliceStable(elems, func(i, j int) bool { return elems[i].Tag.Compare(elems[j].Tag) < 0 })
+		duplicated = true // 排序之后才能知道
+	}
+	if !duplicated || policy == KeepAllDuplicates {
+		return elems, nil
+	}
+	var err error
+	result := elems[:0]
+	for _, elem := range elems {
+		if len(result) == 0 || result[len(result)-1].Tag != elem.Tag {
+			result = append(result, elem)
+			continue
+		}
+		p.options.logger.Warn("dicom.ReadDataSet: duplicate element", dicomlog.Fields{dicomlog.TagKey: dicomtag.DebugString(elem.Tag)})
+		kept := "first"
+		if policy == KeepLastDuplicate {
+			kept = "last"
+		}
+		p.diagnostics = append(p.diagnostics, newDiagnostic(DiagnosticRepair, elem.Tag, -1, "duplicate element, kept the %s one", kept))
+		switch policy {
+		case KeepLastDuplicate:
+			result[len(result)-1] = elem
+		case RejectDuplicates:
+			if err == nil {
+				err = fmt.Errorf("dicom.ReadDataSet: duplicate element %s", dicomtag.DebugString(elem.Tag))
+			}
+		}
+	}
+	return result, err
+}
+
+// TrailingData returns the bytes after the last element that don't look
+// like an element, once Next has returned io.EOF. It is always nil unless
+// ReadOptions.AllowTrailingData is set.
+func (p *Parser) TrailingData() []byte {
+	return p.trailingData
+}
+
+// nativeFrameTags 是拆分native PixelData需要的element, 见splitNativeFrames
+var nativeFrameTags = map[dicomtag.Tag]bool{
+	dicomtag.Rows:            true,
+	dicomtag.Columns:         true,
+	dicomtag.SamplesPerPixel: true,
+	dicomtag.BitsAllocated:   true,
+	dicomtag.NumberOfFrames:  true,
+}
+
+// splitNativeFrames 把defined length的PixelData按"attrs"里的NumberOfFrames等拆成多个frame.
+// 只有一个frame, 或者不能拆(例如BitsAllocated为1, 或者数据比NumberOfFrames个frame短)时,
+// PixelData保持为一个frame. 不能拆时返回原因
+func splitNativeFrames(attrs *DataSet, pixelData *Element) error {
+	if pixelData.UndefinedLength {
+		return nil
+	}
+	if numFrames, err := intValue(attrs, dicomtag.NumberOfFrames, 1); err != nil || numFrames <= 1 {
+		return nil
+	}
+	frames, err := pixelDataFrames(attrs, pixelData)
+	if err != nil {
+		return err
+	}
+	// 保留pool和buffers, 以便Release还回整个buffer
+	image := pixelData.Value[0].(PixelDataInfo)
+	image.Frames = frames
+	pixelData.Value = []interface{}{image}
+	return nil
+}
@@ -1,14 +1,22 @@
 package dicom_test
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/odincare/odicom"
 	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomlog"
 	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
 	"github.com/odincare/odicom/dicomuid"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -19,13 +27,13 @@ func testWriteDataElement(t *testing.T, bo binary.ByteOrder, implicit dicomio.Is
 	var values []interface{}
 	values = append(values, string("FooHah"))
 	dicom.WriteElement(e, &dicom.Element{
-		Tag:   dicomtag.Tag{0x0018, 0x9755},
+		Tag:   dicomtag.Tag{Group: 0x0018, Element: 0x9755},
 		Value: values})
 	values = nil
 	values = append(values, uint32(1234))
 	values = append(values, uint32(2345))
 	dicom.WriteElement(e, &dicom.Element{
-		Tag:   dicomtag.Tag{0x0020, 0x9057},
+		Tag:   dicomtag.Tag{Group: 0x0020, Element: 0x9057},
 		Value: values})
 	data := e.Bytes()
 	// Read them back.
@@ -33,7 +41,7 @@ func testWriteDataElement(t *testing.T, bo binary.ByteOrder, implicit dicomio.Is
 	elem0 := dicom.ReadElement(d, dicom.ReadOptions{})
 
 	require.NoError(t, d.Error())
-	tag := dicomtag.Tag{0x18, 0x9755}
+	tag := dicomtag.Tag{Group: 0x18, Element: 0x9755}
 	assert.Equal(t, elem0.Tag, tag)
 	assert.Equal(t, len(elem0.Value), 1)
 	assert.Equal(t, elem0.Value[0].(string), "FooHah")
@@ -82,6 +90,31 @@ func TestReadWriteFileHeader(t *testing.T) {
 	elem, err = dicom.FindElementByTag(elems, dicomtag.MediaStorageSOPInstanceUID)
 	require.NoError(t, err)
 	assert.Equal(t, elem.MustGetString(), "1.2.3.4.5.6.7")
+	elem, err = dicom.FindElementByTag(elems, dicomtag.FileMetaInformationVersion)
+	require.NoError(t, err)
+	assert.Equal(t, "OB", elem.VR)
+	assert.Equal(t, []interface{}{[]byte{0x00, 0x01}}, elem.Value)
+}
+
+func TestFileMetaInformationVersion(t *testing.T) {
+	version := func(v []byte) interface{} {
+		e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+		dicom.WriteFileHeader(e, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.FileMetaInformationVersion, v),
+			dicom.MustNewElement(dicomtag.TransferSyntaxUID, dicomuid.ExplicitVRLittleEndian),
+			dicom.MustNewElement(dicomtag.MediaStorageSOPClassUID, "1.2.840.10008.5.1.4.1.1.1.2"),
+			dicom.MustNewElement(dicomtag.MediaStorageSOPInstanceUID, "1.2.3.4.5.6.7"),
+		})
+		require.NoError(t, e.Error())
+		d := dicomio.NewBytesDecoder(e.Bytes(), binary.LittleEndian, dicomio.ExplicitVR)
+		elem, err := dicom.FindElementByTag(dicom.ParseFileHeader(d), dicomtag.FileMetaInformationVersion)
+		require.NoError(t, err)
+		return elem.Value[0]
+	}
+	// A valid value is preserved.
+	assert.Equal(t, []byte{0x00, 0x02}, version([]byte{0x00, 0x02}))
+	// The string "0 1", written by old versions, is replaced.
+	assert.Equal(t, []byte{0x00, 0x01}, version([]byte("0 1\x00")))
 }
 
 func TestNewElement(t *testing.T) {
@@ -94,3 +127,565 @@ func TestNewElement(t *testing.T) {
 	elem, err = dicom.NewElement(dicomtag.TriggerSamplePosition, "foo")
 	require.Error(t, err)
 }
+
+func TestWriteOddLengthValues(t *testing.T) {
+	for _, undefinedLength := range []bool{false, true} {
+		e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+		dicom.WriteElement(e, dicom.MustNewElement(dicomtag.EncapsulatedDocument, []byte{1}))
+		pixelData := dicom.MustNewElement(dicomtag.PixelData, dicom.PixelDataInfo{Frames: [][]byte{{1, 2, 3}}})
+		pixelData.UndefinedLength = undefinedLength
+		dicom.WriteElement(e, pixelData)
+		require.NoError(t, e.Error())
+
+		d := dicomio.NewBytesDecoder(e.Bytes(), binary.LittleEndian, dicomio.ExplicitVR)
+		elem := dicom.ReadElement(d, dicom.ReadOptions{})
+		require.NoError(t, d.Error())
+		assert.Equal(t, []byte{1, 0}, elem.Value[0])
+		elem = dicom.ReadElement(d, dicom.ReadOptions{})
+		require.NoError(t, d.Finish())
+		assert.Equal(t, [][]byte{{1, 2, 3, 0}}, elem.Value[0].(dicom.PixelDataInfo).Frames)
+	}
+
+	// A PixelData element without a value is reported as an error, not a crash.
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+	dicom.WriteElement(e, &dicom.Element{Tag: dicomtag.PixelData, VR: "OB"})
+	require.Error(t, e.Error())
+}
+
+func TestReadOutOfOrderAndDuplicates(t *testing.T) {
+	// A non-conformant file: an element after PixelData, and PixelData and
+	// PatientName twice.
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+	for _, elem := range []*dicom.Element{
+		{Tag: dicomtag.PixelData, VR: "OB", Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{{1, 2}}}}},
+		dicom.MustNewElement(dicomtag.StudyDescription, "after pixels"),
+		{Tag: dicomtag.PixelData, VR: "OB", Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{{3, 4}}}}},
+		dicom.MustNewElement(dicomtag.PatientName, "Li^Si"),
+	} {
+		dicom.WriteElement(e, elem)
+	}
+	require.NoError(t, e.Error())
+	data := append(mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian)), e.Bytes()...)
+
+	read := func(policy dicom.DuplicatePolicy) (*dicom.DataSet, error) {
+		return dicom.ReadDataSetInBytes(data, dicom.ReadOptions{Duplicates: policy})
+	}
+	values := func(ds *dicom.DataSet) (string, []byte) {
+		name, err := ds.FindElementByTag(dicomtag.PatientName)
+		require.NoError(t, err)
+		pixelData, err := ds.FindElementByTag(dicomtag.PixelData)
+		require.NoError(t, err)
+		return name.MustGetString(), pixelData.Value[0].(dicom.PixelDataInfo).Frames[0]
+	}
+
+	ds, err := read(dicom.KeepFirstDuplicate)
+	require.NoError(t, err)
+	require.NoError(t, ds.CheckStructure())
+	_, err = ds.FindElementByTag(dicomtag.StudyDescription)
+	require.NoError(t, err, "elements after PixelData are read")
+	name, pixels := values(ds)
+	assert.Equal(t, "Zhang^San", name)
+	assert.Equal(t, []byte{1, 2}, pixels)
+
+	ds, err = read(dicom.KeepLastDuplicate)
+	require.NoError(t, err)
+	require.NoError(t, ds.CheckStructure())
+	name, pixels = values(ds)
+	assert.Equal(t, "Li^Si", name)
+	assert.Equal(t, []byte{3, 4}, pixels)
+
+	ds, err = read(dicom.KeepAllDuplicates)
+	require.NoError(t, err)
+	assert.Error(t, ds.CheckStructure())
+	assert.Equal(t, dicomtag.PixelData, ds.Elements[len(ds.Elements)-1].Tag)
+
+	ds, err = read(dicom.RejectDuplicates)
+	assert.Error(t, err)
+	name, _ = values(ds)
+	assert.Equal(t, "Zhang^San", name)
+}
+
+func TestNewSequence(t *testing.T) {
+	empty := dicom.MustNewSequence(dicomtag.ReferencedImageSequence)
+	single := dicom.MustNewSequence(dicomtag.RequestAttributesSequence, []*dicom.Element{
+		dicom.MustNewElement(dicomtag.RequestedProcedureID, "RP1"),
+	})
+	_, err := dicom.NewSequence(dicomtag.PatientName)
+	require.Error(t, err)
+
+	for _, implicit := range []dicomio.IsImplicitVR{dicomio.ImplicitVR, dicomio.ExplicitVR} {
+		for _, undefinedLength := range []bool{false, true} {
+			empty.UndefinedLength = undefinedLength
+			single.UndefinedLength = undefinedLength
+			e := dicomio.NewBytesEncoder(binary.LittleEndian, implicit)
+			dicom.WriteElement(e, empty)
+			dicom.WriteElement(e, single)
+			require.NoError(t, e.Error())
+
+			d := dicomio.NewBytesDecoder(e.Bytes(), binary.LittleEndian, implicit)
+			elem := dicom.ReadElement(d, dicom.ReadOptions{})
+			require.NoError(t, d.Error())
+			assert.Equal(t, "SQ", elem.VR)
+			assert.Len(t, elem.Value, 0)
+			elem = dicom.ReadElement(d, dicom.ReadOptions{})
+			require.NoError(t, d.Finish())
+			require.Len(t, elem.Value, 1)
+			item := elem.Value[0].(*dicom.Element)
+			assert.Equal(t, dicomtag.Item, item.Tag)
+			assert.Equal(t, "RP1", item.Value[0].(*dicom.Element).MustGetString())
+		}
+	}
+}
+
+func newPrivateTagDataSet(transferSyntaxUID string) *dicom.DataSet {
+	ds := newTestDataSet(transferSyntaxUID)
+	private := []*dicom.Element{
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x0010}, VR: "LO", Value: []interface{}{"ACME 1.0"}},
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1001}, VR: "UN", Value: []interface{}{[]byte{1, 2, 3}}},
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1002}, VR: "LO", Value: []interface{}{"hello"}},
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1003}, Value: []interface{}{"abc", "de"}},
+		{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1004}, VR: "US", Value: []interface{}{uint16(7)}},
+	}
+	// SOPInstanceUID之后, PatientName之前
+	elems := append([]*dicom.Element{}, ds.Elements[:4]...)
+	elems = append(elems, private...)
+	ds.Elements = append(elems, ds.Elements[4:]...)
+	ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.Tag{Group: 0x0029, Element: 0x1010}, VR: "UN", Value: []interface{}{[]byte("70")}})
+	return ds
+}
+
+func TestWritePrivateTags(t *testing.T) {
+	for _, uid := range []string{dicomuid.ImplicitVRLittleEndian, dicomuid.ExplicitVRLittleEndian, dicomuid.ExplicitVRBigEndian} {
+		data := mustWriteDataSet(newPrivateTagDataSet(uid))
+		ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{})
+		require.NoError(t, err, uid)
+
+		find := func(group, element uint16) *dicom.Element {
+			elem, err := ds.FindElementByTag(dicomtag.Tag{Group: group, Element: element})
+			require.NoError(t, err, uid)
+			return elem
+		}
+		if uid == dicomuid.ImplicitVRLittleEndian {
+			// 字典里没有的tag读出来是UN, value是原始bytes
+			assert.Equal(t, "UN", find(0x0009, 0x0010).VR)
+			assert.Equal(t, []byte("ACME 1.0"), find(0x0009, 0x0010).Value[0])
+			assert.Equal(t, []byte{1, 2, 3, 0}, find(0x0009, 0x1001).Value[0])
+			assert.Equal(t, []byte("hello "), find(0x0009, 0x1002).Value[0])
+			assert.Equal(t, []byte("abc\\de"), find(0x0009, 0x1003).Value[0])
+			assert.Equal(t, []byte{7, 0}, find(0x0009, 0x1004).Value[0])
+		} else {
+			// 文件里的VR优先
+			assert.Equal(t, "LO", find(0x0009, 0x0010).VR)
+			assert.Equal(t, "ACME 1.0", find(0x0009, 0x0010).MustGetString())
+			assert.Equal(t, "UN", find(0x0009, 0x1001).VR)
+			assert.Equal(t, []byte{1, 2, 3, 0}, find(0x0009, 0x1001).Value[0])
+			assert.Equal(t, "UN", find(0x0009, 0x1003).VR)
+			assert.Equal(t, "US", find(0x0009, 0x1004).VR)
+			assert.Equal(t, uint16(7), find(0x0009, 0x1004).MustGetUInt16())
+		}
+		assert.Equal(t, []byte("70"), find(0x0029, 0x1010).Value[0])
+
+		// 读出来的dataset写回去应该一个byte都不差
+		assert.Equal(t, data, mustWriteDataSet(ds), uid)
+	}
+}
+
+func TestStopAtTag(t *testing.T) {
+	data := mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian))
+	for _, test := range []struct {
+		opts          []dicom.ReadOption
+		last, stopped dicomtag.Tag
+	}{
+		{[]dicom.ReadOption{dicom.WithStopAtTag(dicomtag.PatientID)}, dicomtag.PatientName, dicomtag.PatientID},
+		{[]dicom.ReadOption{dicom.WithStopAtTag(dicomtag.PatientID), dicom.WithStopAtTagInclusive()}, dicomtag.PatientID, dicomtag.StudyInstanceUID},
+		// (0020,000D)的element比(0010,0030)的小, 但是tag更大
+		{[]dicom.ReadOption{dicom.WithStopAtTag(dicomtag.PatientBirthDate)}, dicomtag.PatientID, dicomtag.StudyInstanceUID},
+		{[]dicom.ReadOption{dicom.WithStopAfterGroup(0x0010)}, dicomtag.PatientID, dicomtag.StudyInstanceUID},
+		{[]dicom.ReadOption{dicom.WithStopAfterGroup(0x0008)}, dicomtag.SOPInstanceUID, dicomtag.PatientName},
+	} {
+		ds, err := dicom.Read(bytes.NewReader(data), test.opts...)
+		require.NoError(t, err)
+		last := ds.Elements[len(ds.Elements)-1]
+		assert.Equal(t, test.last, last.Tag, "%v", test.stopped)
+		_, err = ds.FindElementByTag(test.stopped)
+		assert.Error(t, err)
+	}
+}
+
+func TestSkipPrivateTags(t *testing.T) {
+	for _, uid := range []string{dicomuid.ImplicitVRLittleEndian, dicomuid.ExplicitVRLittleEndian} {
+		data := mustWriteDataSet(newPrivateTagDataSet(uid))
+		ds, err := dicom.Read(bytes.NewReader(data), dicom.WithSkipPrivateTags())
+		require.NoError(t, err, uid)
+		for _, elem := range ds.Elements {
+			assert.Equal(t, uint16(0), elem.Tag.Group%2, "%s: %v", uid, elem.Tag)
+		}
+		// private blocks之后的elements也被读到
+		_, err = ds.FindElementByTag(dicomtag.SeriesInstanceUID)
+		assert.NoError(t, err, uid)
+	}
+}
+
+func TestPrivateGroupLength(t *testing.T) {
+	tag := dicomtag.Tag{Group: 0x0009, Element: 0x0000}
+	info, err := dicomtag.Find(tag)
+	require.NoError(t, err)
+	assert.Equal(t, "UL", info.VR)
+	assert.Equal(t, "GenericGroupLength", info.Name)
+
+	for _, uid := range []string{dicomuid.ImplicitVRLittleEndian, dicomuid.ExplicitVRLittleEndian} {
+		ds := newPrivateTagDataSet(uid)
+		elems := append([]*dicom.Element{}, ds.Elements[:4]...)
+		elems = append(elems, dicom.MustNewElement(tag, uint32(60)))
+		ds.Elements = append(elems, ds.Elements[4:]...)
+		data := mustWriteDataSet(ds)
+
+		ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{})
+		require.NoError(t, err, uid)
+		elem, err := ds.FindElementByTag(tag)
+		require.NoError(t, err, uid)
+		// implicit VR也按UL读, 不是UN
+		assert.Equal(t, "UL", elem.VR, uid)
+		assert.Equal(t, uint32(60), elem.MustGetUInt32(), uid)
+		assert.Equal(t, data, mustWriteDataSet(ds), uid)
+	}
+}
+
+func TestWriteNativeMultiFrame(t *testing.T) {
+	newDataSet := func(frames ...[]byte) *dicom.DataSet {
+		ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+		ds.Elements = append(ds.Elements,
+			dicom.MustNewElement(dicomtag.SamplesPerPixel, uint16(1)),
+			dicom.MustNewElement(dicomtag.Rows, uint16(2)),
+			dicom.MustNewElement(dicomtag.Columns, uint16(2)),
+			dicom.MustNewElement(dicomtag.BitsAllocated, uint16(8)),
+			&dicom.Element{Tag: dicomtag.PixelData, VR: "OB", Value: []interface{}{dicom.PixelDataInfo{Frames: frames}}})
+		return ds
+	}
+	ds := newDataSet([]byte{1, 2, 3, 4}, []byte{5, 6, 7, 8}, []byte{9, 10, 11, 12})
+	n := len(ds.Elements)
+	ds2, err := dicom.ReadDataSetInBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	require.NoError(t, err)
+	assert.Len(t, ds.Elements, n, "ds must not be modified")
+
+	elem, err := ds2.FindElementByTag(dicomtag.NumberOfFrames)
+	require.NoError(t, err)
+	assert.Equal(t, "3", elem.MustGetString())
+	elem, err = ds2.FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{1, 2, 3, 4}, {5, 6, 7, 8}, {9, 10, 11, 12}}, elem.Value[0].(dicom.PixelDataInfo).Frames)
+
+	err = dicom.WriteDataSet(&bytes.Buffer{}, newDataSet([]byte{1, 2, 3, 4}, []byte{5, 6}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "frame 1 has 2 bytes, expect 4")
+}
+
+func TestReadUSOrSS(t *testing.T) {
+	newDataSet := func(transferSyntaxUID string, pixelRepresentation uint16) *dicom.DataSet {
+		ds := newTestDataSet(transferSyntaxUID)
+		ds.Elements = append(ds.Elements,
+			dicom.MustNewElement(dicomtag.PixelRepresentation, pixelRepresentation),
+			dicom.MustNewElement(dicomtag.PixelPaddingValue, uint16(0xfc18)), // -1000
+			dicom.MustNewSequence(dicomtag.RealWorldValueMappingSequence, []*dicom.Element{
+				dicom.MustNewElement(dicomtag.RealWorldValueFirstValueMapped, uint16(0xfc18)),
+			}))
+		return ds
+	}
+	readPadding := func(ds *dicom.DataSet) (*dicom.Element, *dicom.Element) {
+		ds2, err := dicom.ReadDataSetInBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+		require.NoError(t, err)
+		padding, err := ds2.FindElementByTag(dicomtag.PixelPaddingValue)
+		require.NoError(t, err)
+		seq, err := ds2.FindElementByTag(dicomtag.RealWorldValueMappingSequence)
+		require.NoError(t, err)
+		item := seq.Value[0].(*dicom.Element)
+		return padding, item.Value[0].(*dicom.Element)
+	}
+
+	padding, first := readPadding(newDataSet(dicomuid.ImplicitVRLittleEndian, 1))
+	assert.Equal(t, "SS", padding.VR)
+	assert.Equal(t, []interface{}{int16(-1000)}, padding.Value)
+	assert.Equal(t, []interface{}{int16(-1000)}, first.Value)
+
+	padding, first = readPadding(newDataSet(dicomuid.ImplicitVRLittleEndian, 0))
+	assert.Equal(t, "US", padding.VR)
+	assert.Equal(t, []interface{}{uint16(0xfc18)}, padding.Value)
+	assert.Equal(t, []interface{}{uint16(0xfc18)}, first.Value)
+
+	// PixelRepresentation is read even if it is not returned.
+	ds, err := dicom.ReadDataSetInBytes(mustWriteDataSet(newDataSet(dicomuid.ImplicitVRLittleEndian, 1)),
+		dicom.ReadOptions{ReturnTags: []dicomtag.Tag{dicomtag.PixelPaddingValue}})
+	require.NoError(t, err)
+	padding, err = ds.FindElementByTag(dicomtag.PixelPaddingValue)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{int16(-1000)}, padding.Value)
+	_, err = ds.FindElementByTag(dicomtag.PixelRepresentation)
+	assert.Error(t, err)
+
+	// Explicit VR files carry the VR, which is used as is.
+	padding, _ = readPadding(newDataSet(dicomuid.ExplicitVRLittleEndian, 1))
+	assert.Equal(t, "US", padding.VR)
+	assert.Equal(t, []interface{}{uint16(0xfc18)}, padding.Value)
+}
+
+// newFrameSizeOverflowData 返回一个Rows, Columns, SamplesPerPixel和BitsAllocated都是65535的文件,
+// frame的大小溢出int64
+func newFrameSizeOverflowData() []byte {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	for _, tag := range []dicomtag.Tag{dicomtag.SamplesPerPixel, dicomtag.Rows, dicomtag.Columns, dicomtag.BitsAllocated} {
+		ds.Put(dicom.MustNewElement(tag, uint16(65535)))
+	}
+	ds.Put(dicom.MustNewElement(dicomtag.NumberOfFrames, "2"))
+	ds.Put(&dicom.Element{Tag: dicomtag.PixelData, VR: "OW",
+		Value: []interface{}{dicom.PixelDataInfo{Frames: [][]byte{make([]byte, 16)}}}})
+	return mustWriteDataSet(ds)
+}
+
+func TestFrameSizeOverflow(t *testing.T) {
+	data := newFrameSizeOverflowData()
+	ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{})
+	require.NoError(t, err)
+	// PixelData不能拆成frames, 保持原样
+	elem, err := ds.FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	assert.Len(t, elem.Value[0].(dicom.PixelDataInfo).Frames, 1)
+	assert.Equal(t, 1, ds.Diagnostics().Count(dicom.DiagnosticWarning))
+}
+
+func TestParser(t *testing.T) {
+	data := dicomtest.MustBytes(dicomtest.Spec{})
+	ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{})
+	require.NoError(t, err)
+
+	// Next returns the same elements as ReadDataSet, one at a time.
+	p, err := dicom.NewParser(bytes.NewReader(data), dicom.ReadOptions{})
+	require.NoError(t, err)
+	var elems []*dicom.Element
+	for {
+		elem, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		elems = append(elems, elem)
+	}
+	assert.Equal(t, ds.Elements, elems)
+	_, err = p.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// The caller can stop early.
+	p, err = dicom.NewParser(bytes.NewReader(data), dicom.ReadOptions{ReturnTags: []dicomtag.Tag{dicomtag.Modality}})
+	require.NoError(t, err)
+	var modality *dicom.Element
+	for modality == nil {
+		elem, err := p.Next()
+		require.NoError(t, err)
+		if elem.Tag == dicomtag.Modality {
+			modality = elem
+		}
+	}
+	assert.Equal(t, "OT", modality.MustGetString())
+
+	// Errors are sticky.
+	_, err = dicom.NewParser(bytes.NewReader(data[:100]), dicom.ReadOptions{})
+	assert.Error(t, err)
+	p, err = dicom.NewParser(bytes.NewReader(data[:len(data)-3]), dicom.ReadOptions{})
+	require.NoError(t, err)
+	for err == nil {
+		_, err = p.Next()
+	}
+	assert.NotEqual(t, io.EOF, err)
+	_, err2 := p.Next()
+	assert.Equal(t, err, err2)
+}
+
+func TestFramePool(t *testing.T) {
+	pool := &dicom.FramePool{}
+	buf := pool.Get(1000)
+	assert.Len(t, buf, 1000)
+	assert.Equal(t, 1024, cap(buf))
+	pool.Put(buf)
+
+	for _, spec := range []dicomtest.Spec{
+		{Rows: 4, Columns: 4, BitsAllocated: 16, NumberOfFrames: 3},
+		{TransferSyntaxUID: dicomtest.RLELossless, Rows: 4, Columns: 4, BitsAllocated: 16, NumberOfFrames: 3},
+	} {
+		data := dicomtest.MustBytes(spec)
+		for i := 0; i < 2; i++ {
+			ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{FramePool: pool})
+			require.NoError(t, err)
+			elem, err := ds.FindElementByTag(dicomtag.PixelData)
+			require.NoError(t, err)
+			image := elem.Value[0].(dicom.PixelDataInfo)
+			require.Len(t, image.Frames, 3)
+			if spec.TransferSyntaxUID == "" {
+				assert.Equal(t, int64(3*4*4*2), image.Size())
+				for j, frame := range image.Frames {
+					assert.Equal(t, dicomtest.FramePixels(spec, j), frame)
+				}
+			}
+			image.Release()
+		}
+	}
+	stats := pool.Stats()
+	// native: 一个buffer; RLE: 每个frame一个buffer
+	assert.Equal(t, int64(1+2*1+2*3), stats.Gets)
+	assert.Equal(t, stats.Gets, stats.Puts)
+
+	// 没有pool时Release什么都不做
+	ds, err := dicom.ReadDataSetInBytes(dicomtest.MustBytes(dicomtest.Spec{}), dicom.ReadOptions{})
+	require.NoError(t, err)
+	elem, err := ds.FindElementByTag(dicomtag.PixelData)
+	require.NoError(t, err)
+	elem.Value[0].(dicom.PixelDataInfo).Release()
+	assert.Equal(t, stats, pool.Stats())
+}
+
+func TestMaxElementSize(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Put(dicom.MustNewElement(dicomtag.StudyDescription, strings.Repeat("x", 60)))
+	data := mustWriteDataSet(ds)
+
+	// 太长的top-level element被跳过
+	ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{MaxElementSize: 32})
+	require.NoError(t, err)
+	_, err = ds.FindElementByTag(dicomtag.StudyDescription)
+	assert.Error(t, err)
+	_, err = ds.FindElementByTag(dicomtag.SeriesInstanceUID)
+	assert.NoError(t, err)
+	report := ds.Diagnostics()
+	require.Len(t, report.Diagnostics, 1, "%v", report.Diagnostics)
+	assert.Equal(t, dicom.DiagnosticWarning, report.Diagnostics[0].Kind)
+	assert.Equal(t, dicomtag.StudyDescription, *report.Diagnostics[0].Tag)
+
+	// 损坏的VL: sequence item里的element说它有0xFFFFFFF0 bytes
+	corrupt := append(mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian)),
+		0x08, 0x00, 0x40, 0x11, 'S', 'Q', 0, 0, 0xff, 0xff, 0xff, 0xff, // ReferencedImageSequence
+		0xfe, 0xff, 0x00, 0xe0, 0xff, 0xff, 0xff, 0xff, // Item
+		0x20, 0x00, 0x00, 0x40, 'U', 'N', 0, 0, 0xf0, 0xff, 0xff, 0xff) // ImageComments
+	_, err = dicom.Read(bytes.NewReader(corrupt), dicom.WithMaxElementSize(1<<20))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds MaxElementSize")
+
+	// 被截断的OW element: 没有MaxElementSize也是读错误, 不会分配VL那么多的内存
+	truncated := append(mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian)),
+		0x00, 0x40, 0x00, 0x10, 'O', 'W', 0, 0, 0xf0, 0xff, 0xff, 0x7f, 1, 2, 3, 4) // (4000,1000)
+	_, err = dicom.ReadDataSetInBytes(truncated, dicom.ReadOptions{})
+	assert.Error(t, err)
+	_, err = dicom.Read(struct{ io.Reader }{bytes.NewReader(truncated)})
+	assert.Error(t, err)
+}
+
+func TestOnProgress(t *testing.T) {
+	data := dicomtest.MustBytes(dicomtest.Spec{Rows: 1024, Columns: 1024, BitsAllocated: 16, NumberOfFrames: 2})
+	var reads, totals []int64
+	onProgress := func(bytesRead, totalBytes int64) {
+		reads = append(reads, bytesRead)
+		totals = append(totals, totalBytes)
+	}
+	_, err := dicom.Read(bytes.NewReader(data), dicom.WithOnProgress(onProgress))
+	require.NoError(t, err)
+	require.True(t, len(reads) >= 4, "%v", reads)
+	for i := 1; i < len(reads); i++ {
+		assert.True(t, reads[i] > reads[i-1], "%v", reads)
+		assert.Equal(t, int64(len(data)), totals[i])
+	}
+	assert.Equal(t, int64(len(data)), reads[len(reads)-1])
+
+	// 不知道输入的长度
+	reads, totals = nil, nil
+	_, err = dicom.Read(struct{ io.Reader }{bytes.NewReader(data)}, dicom.WithOnProgress(onProgress))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), reads[len(reads)-1])
+	assert.Equal(t, int64(-1), totals[0])
+}
+
+func TestDiagnostics(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Replace(dicom.MustNewElement(dicomtag.AccessionNumber, "12345678901234567"), "")
+	// PatientName又出现了一次, 在SeriesInstanceUID之后
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.PatientName, "Li^Si"))
+	require.NoError(t, e.Error())
+	data := append(mustWriteDataSet(ds), e.Bytes()...)
+
+	ds, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{})
+	require.NoError(t, err)
+	report := ds.Diagnostics()
+	assert.Equal(t, "1.2.3.4.5", report.SOPInstanceUID)
+	require.Len(t, report.Diagnostics, 3, "%v", report.Diagnostics)
+	assert.Equal(t, dicom.Diagnostic{Kind: dicom.DiagnosticRepair, Offset: -1,
+		Message: "elements not in tag order, sorted"}, report.Diagnostics[0])
+	assert.Equal(t, "repair: (0010,0010)[PatientName]: duplicate element, kept the first one", report.Diagnostics[1].String())
+	assert.Equal(t, dicom.DiagnosticFinding, report.Diagnostics[2].Kind)
+	assert.Equal(t, dicomtag.AccessionNumber, *report.Diagnostics[2].Tag)
+	assert.Equal(t, 2, report.Count(dicom.DiagnosticRepair))
+
+	encoded, err := json.Marshal(report)
+	require.NoError(t, err)
+	var decoded struct {
+		SOPInstanceUID              string
+		Warnings, Repairs, Findings int
+		Diagnostics                 []map[string]interface{}
+	}
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, "1.2.3.4.5", decoded.SOPInstanceUID)
+	assert.Equal(t, []int{0, 2, 1}, []int{decoded.Warnings, decoded.Repairs, decoded.Findings})
+	assert.Equal(t, map[string]interface{}{"kind": "repair", "message": "elements not in tag order, sorted"}, decoded.Diagnostics[0])
+	assert.Equal(t, "00080050", decoded.Diagnostics[2]["tag"])
+
+	// 修改之后检查结果跟着变, 读取时的问题不变
+	ds.Replace(dicom.MustNewElement(dicomtag.AccessionNumber, "A1"), "")
+	assert.Len(t, ds.Diagnostics().Diagnostics, 2)
+
+	data = append(mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian)), make([]byte, 16)...)
+	ds, err = dicom.ReadDataSetInBytes(data, dicom.ReadOptions{AllowTrailingData: true})
+	require.NoError(t, err)
+	encoded, err = json.Marshal(ds.Diagnostics())
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"sopInstanceUID": "1.2.3.4.5", "warnings": 1, "repairs": 0, "findings": 0, "diagnostics": [
+		{"kind": "warning", "offset": %d, "message": "16 bytes of trailing data after the last element"}]}`, len(data)-16), string(encoded))
+	encoded, err = json.Marshal((&dicom.DataSet{}).Diagnostics())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"warnings": 0, "repairs": 0, "findings": 0, "diagnostics": []}`, string(encoded))
+}
+
+func TestReadWithOptions(t *testing.T) {
+	dicomlog.SetSampling(dicomlog.Sampling{})
+	defer dicomlog.SetSampling(dicomlog.DefaultSampling)
+	logger, hook := logtest.NewNullLogger()
+
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	e := dicomio.NewBytesEncoder(binary.LittleEndian, dicomio.ExplicitVR)
+	dicom.WriteElement(e, dicom.MustNewElement(dicomtag.AccessionNumber, "A1"))
+	require.NoError(t, e.Error())
+	data := append(mustWriteDataSet(ds), e.Bytes()...)
+
+	ds, err := dicom.Read(bytes.NewReader(data),
+		dicom.WithSkipTags(dicomtag.PatientID),
+		dicom.WithLogger(logger.WithField(dicomlog.FileKey, "a.dcm")))
+	require.NoError(t, err)
+	_, err = ds.FindElementByTag(dicomtag.PatientID)
+	assert.Error(t, err)
+	_, err = ds.FindElementByTag(dicomtag.AccessionNumber)
+	assert.NoError(t, err)
+	require.Len(t, hook.AllEntries(), 1)
+	assert.Equal(t, "dicom.ReadDataSet: elements not in tag order", hook.LastEntry().Message)
+	assert.Equal(t, "a.dcm", hook.LastEntry().Data[dicomlog.FileKey])
+
+	ds, err = dicom.Read(bytes.NewReader(data), dicom.WithStopAtTag(dicomtag.StudyInstanceUID))
+	require.NoError(t, err)
+	_, err = ds.FindElementByTag(dicomtag.StudyInstanceUID)
+	assert.Error(t, err)
+	assert.Equal(t, dicom.ReadOptions{DropPixelData: true, Duplicates: dicom.RejectDuplicates},
+		dicom.NewReadOptions(dicom.WithReadOptions(dicom.ReadOptions{Duplicates: dicom.RejectDuplicates}), dicom.WithDropPixelData()))
+
+	hook.Reset()
+	ds = newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Replace(dicom.MustNewElement(dicomtag.FileMetaInformationVersion, []byte{1}), "")
+	var buf bytes.Buffer
+	require.NoError(t, dicom.Write(&buf, ds, dicom.WithLogger(logger)))
+	require.Len(t, hook.AllEntries(), 1)
+	assert.Equal(t, "dicom: replacing invalid FileMetaInformationVersion", hook.LastEntry().Message)
+	assert.Equal(t, mustWriteDataSet(ds), buf.Bytes())
+}
@@ -0,0 +1,58 @@
+package dicom
+
+import "io"
+
+// progressInterval 是两次OnProgress调用之间最少读的bytes
+const progressInterval = 1 << 20
+
+// progressReader 计数从r读出的bytes, 调用ReadOptions.OnProgress
+type progressReader struct {
+	r          io.Reader
+	fn         func(bytesRead, totalBytes int64)
+	read       int64
+	total      int64
+	lastReport int64
+}
+
+// newProgressReader 返回报告in的读取进度的reader. totalBytes是in剩下的长度, 不知道时是-1
+func newProgressReader(in io.Reader, fn func(bytesRead, totalBytes int64)) *progressReader {
+	return &progressReader{r: in, fn: fn, total: inputSize(in), lastReport: -1}
+}
+
+func (p *progressReader) Read(data []byte) (int, error) {
+	// 读PixelData这样的大value时, 一次Read可能读几百MB; 分开读才能定期报告
+	if len(data) > progressInterval {
+		data = data[:progressInterval]
+	}
+	n, err := p.r.Read(data)
+	p.read += int64(n)
+	if p.read-p.lastReport >= progressInterval || err == io.EOF && p.lastReport != p.read {
+		p.lastReport = p.read
+		p.fn(p.read, p.total)
+	}
+	return n, err
+}
+
+// inputSize 返回in剩下的bytes, 不知道时返回-1
+func inputSize(in io.Reader) int64 {
+	switch r := in.(type) {
+	case interface{ Len() int }:
+		// bytes.Reader, bytes.Buffer, strings.Reader
+		return int64(r.Len())
+	case io.Seeker:
+		// os.File等
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return -1
+		}
+		return end - cur
+	}
+	return -1
+}
@@ -5,21 +5,56 @@ import (
 
 	"github.com/odincare/odicom"
 	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestParse0(t *testing.T) {
-	ds, err := dicom.ReadDataSetFromFile("examples/I_000013.dcm", dicom.ReadOptions{})
+	ds, err := dicom.ReadDataSetInBytes(dicomtest.MustBytes(dicomtest.Spec{}), dicom.ReadOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	studyUID := "1.2.840.113857.1907.192833.1115.220048"
+	studyUID := "1.2.826.0.1.3680043.2.1143.1.2"
 	match, elem, err := dicom.Query(ds, dicom.MustNewElement(dicomtag.StudyInstanceUID, studyUID))
 	assert.True(t, match)
 	assert.NoError(t, err)
 	assert.Equal(t, elem.MustGetString(), studyUID)
 }
 
+func TestFuzzyNameMatching(t *testing.T) {
+	m := dicom.DefaultNameMatcher
+	for _, test := range []struct {
+		pattern, value string
+		match          bool
+	}{
+		{"MULLER", "Müller^Hans", true},
+		{"obrien^sean", "O'Brien^Seán", true},
+		{"Smyth", "Smith^John", true},
+		{"Robert^J*", "Rupert^John", true},
+		{"^JOHN", "Doe^John", true},
+		{"Sm*", "Smith^John", true},
+		{"Smith^Mary", "Smith^John", false},
+		{"Jones", "Smith^John", false},
+		{"Smith^John", "Smith", false},
+		{"张^三", "Zhang^San=张^三=ㄓㄤ^ㄙㄢ", true},
+		{"Zhang", "Zhang^San=张^三", true},
+		{"张^四", "Zhang^San=张^三", false},
+		{"", "Smith", false},
+	} {
+		assert.Equal(t, test.match, m.MatchName(test.pattern, test.value), "%q vs %q", test.pattern, test.value)
+	}
+
+	ds := &dicom.DataSet{Elements: []*dicom.Element{dicom.MustNewElement(dicomtag.PatientName, "Müller^Hans")}}
+	filter := dicom.MustNewElement(dicomtag.PatientName, "MULLER^HANS")
+	match, _, err := dicom.Query(ds, filter)
+	assert.NoError(t, err)
+	assert.False(t, match)
+	match, elem, err := dicom.QueryWithOptions(ds, filter, dicom.QueryOptions{NameMatcher: dicom.DefaultNameMatcher})
+	assert.NoError(t, err)
+	assert.True(t, match)
+	assert.Equal(t, "Müller^Hans", elem.MustGetString())
+}
+
 //
 //func TestParseDate(t *testing.T) {
 //	goodDateRanges := []struct {
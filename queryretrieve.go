@@ -13,6 +13,19 @@ import (
 // 如果 "filter" 要求一个通用匹配(universal match) i.e. 空查询 empty query value 且 element的filter.Tag不存在，函数返回<true, nil, nil>
 // 如果”filter“有误(malformed)，函数返回<false, nil, err reason>
 func Query(ds *DataSet, f *Element) (match bool, matchedElement *Element, err error) {
+	return QueryWithOptions(ds, f, QueryOptions{})
+}
+
+// QueryOptions 控制QueryWithOptions的匹配方式. zero value与Query相同
+type QueryOptions struct {
+	// NameMatcher 如果不为nil, PN的filter用它做fuzzy semantic matching,
+	// 而不是区分大小写的wildcard匹配. 见DefaultNameMatcher
+	NameMatcher NameMatcher
+}
+
+// QueryWithOptions is the same as Query, but matches as configured by
+// "options", e.g., person names fuzzily.
+func QueryWithOptions(ds *DataSet, f *Element, options QueryOptions) (match bool, matchedElement *Element, err error) {
 
 	if len(f.Value) > 1 {
 		// 过滤器不能包含多个值 P3.4 C2.2.2.1
@@ -29,7 +42,7 @@ func Query(ds *DataSet, f *Element) (match bool, matchedElement *Element, err er
 		elem = nil
 	}
 
-	match, err = queryElement(elem, f)
+	match, err = queryElement(elem, f, options)
 
 	if match {
 		return true, elem, nil
@@ -38,7 +51,7 @@ func Query(ds *DataSet, f *Element) (match bool, matchedElement *Element, err er
 	return false, nil, err
 }
 
-func queryElement(elem *Element, f *Element) (match bool, err error) {
+func queryElement(elem *Element, f *Element, options QueryOptions) (match bool, err error) {
 
 	if isEmptyQuery(f) {
 		// 通用匹配 一个空格代表通配符
@@ -115,6 +128,14 @@ func queryElement(elem *Element, f *Element) (match bool, err error) {
 			}
 		}
 	case string:
+		if f.VR == "PN" && options.NameMatcher != nil {
+			for _, value := range elem.Value {
+				if options.NameMatcher.MatchName(v, value.(string)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
 		for _, value := range elem.Value {
 			ok, err := matchString(v, value.(string))
 			if err != nil {
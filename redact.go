@@ -0,0 +1,177 @@
+package dicom
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// RedactAction is what a RedactRule does to the elements with its tag.
+type RedactAction int
+
+const (
+	// RedactRemove removes the element.
+	RedactRemove RedactAction = iota
+
+	// RedactEmpty keeps the element with an empty value, e.g., for Type 2
+	// attributes that must be present.
+	RedactEmpty
+
+	// RedactReplace replaces the value of the element with
+	// RedactRule.Values.
+	RedactReplace
+)
+
+// RedactRule tells a RedactingWriter what to do with the elements with
+// the given tag, at the top level and in the items of sequences.
+type RedactRule struct {
+	Tag    dicomtag.Tag
+	Action RedactAction
+
+	// Values 是RedactReplace的新values, 类型与Element.Value相同, 例如string VRs用string
+	Values []interface{}
+}
+
+// RedactingWriter is an io.WriteCloser that reads a DICOM file written to
+// it, applies RedactRules, and writes the result to another io.Writer as it
+// goes, e.g., in a proxy that forwards instances. It combines a Parser and
+// a Writer, so it keeps one element in memory at a time, not the whole
+// file.
+//
+// Group length elements (gggg,0000) outside the file meta group are
+// dropped, since redaction changes the lengths they record.
+type RedactingWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+
+	closed bool
+	err    error
+}
+
+// NewRedactingWriter returns a RedactingWriter that writes to "dst". Close
+// must be called after the last Write; it returns the first error found
+// while parsing, redacting or writing. Write returns that error early if
+// possible, so that the caller can stop sending.
+func NewRedactingWriter(dst io.Writer, rules []RedactRule) *RedactingWriter {
+	pr, pw := io.Pipe()
+	w := &RedactingWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := redact(pr, dst, rules)
+		if err == nil {
+			// 最后一个element之后写的bytes被忽略
+			_, err = io.Copy(ioutil.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+// Write writes the next part of the input file.
+func (w *RedactingWriter) Write(data []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("dicom.RedactingWriter: Write called after Close")
+	}
+	return w.pw.Write(data)
+}
+
+// Close ends the input, waits until the output is written, and returns the
+// first error. It doesn't close "dst".
+func (w *RedactingWriter) Close() error {
+	if !w.closed {
+		w.closed = true
+		w.pw.Close()
+		w.err = <-w.done
+	}
+	return w.err
+}
+
+// redact 从in一个一个地读element, 应用rules之后写到out
+func redact(in io.Reader, out io.Writer, rules []RedactRule) error {
+	byTag := map[dicomtag.Tag]RedactRule{}
+	for _, rule := range rules {
+		if rule.Action < RedactRemove || rule.Action > RedactReplace {
+			return fmt.Errorf("dicom.RedactingWriter: %v: unknown action %d", dicomtag.DebugString(rule.Tag), rule.Action)
+		}
+		byTag[rule.Tag] = rule
+	}
+	p, err := NewParser(in, ReadOptions{})
+	if err != nil {
+		return err
+	}
+	w := NewWriter(out)
+	var meta []*Element
+	metaWritten := false
+	for {
+		elem, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if elem.Tag.Group != dicomtag.MetadataGroup && elem.Tag.Element == 0x0000 {
+			continue // group length
+		}
+		if elem = redactElement(elem, byTag); elem == nil {
+			continue
+		}
+		if elem.Tag.Group == dicomtag.MetadataGroup {
+			meta = append(meta, elem)
+			continue
+		}
+		if !metaWritten {
+			if err := w.WriteMeta(meta); err != nil {
+				return err
+			}
+			metaWritten = true
+		}
+		if err := w.WriteNext(elem); err != nil {
+			return err
+		}
+	}
+	if !metaWritten {
+		if err := w.WriteMeta(meta); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// redactElement 返回应用rules之后的elem, 被删除时返回nil. SQ的items也被处理
+func redactElement(elem *Element, rules map[dicomtag.Tag]RedactRule) *Element {
+	if rule, ok := rules[elem.Tag]; ok {
+		switch rule.Action {
+		case RedactRemove:
+			return nil
+		case RedactEmpty:
+			return &Element{Tag: elem.Tag, VR: elem.VR}
+		case RedactReplace:
+			return &Element{Tag: elem.Tag, VR: elem.VR, Value: rule.Values}
+		}
+	}
+	if elem.VR != "SQ" {
+		return elem
+	}
+	for _, value := range elem.Value {
+		item, ok := value.(*Element)
+		if !ok {
+			continue
+		}
+		var kept []interface{}
+		for _, v := range item.Value {
+			child, ok := v.(*Element)
+			if !ok {
+				kept = append(kept, v)
+				continue
+			}
+			if child = redactElement(child, rules); child != nil {
+				kept = append(kept, child)
+			}
+		}
+		item.Value = kept
+	}
+	return elem
+}
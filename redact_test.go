@@ -0,0 +1,68 @@
+package dicom_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactingWriter(t *testing.T) {
+	ds, err := dicomtest.NewDataSet(dicomtest.Spec{Rows: 64, Columns: 64, NumberOfFrames: 2})
+	require.NoError(t, err)
+	ds.Put(dicom.MustNewSequence(dicomtag.OtherPatientIDsSequence, []*dicom.Element{
+		dicom.MustNewElement(dicomtag.PatientID, "OTHER-1"),
+		dicom.MustNewElement(dicomtag.IssuerOfPatientID, "Other Hospital"),
+	}))
+	ds.Put(dicom.MustNewElement(dicomtag.Tag{Group: 0x0010, Element: 0x0000}, uint32(100)))
+	in := mustWriteDataSet(ds)
+
+	var out bytes.Buffer
+	w := dicom.NewRedactingWriter(&out, []dicom.RedactRule{
+		{Tag: dicomtag.PatientName, Action: dicom.RedactReplace, Values: []interface{}{"Anonymous"}},
+		{Tag: dicomtag.PatientID, Action: dicom.RedactEmpty},
+		{Tag: dicomtag.InstitutionName, Action: dicom.RedactRemove},
+		{Tag: dicomtag.IssuerOfPatientID, Action: dicom.RedactRemove},
+	})
+	// 一次写一小段, 像proxy转发一样
+	_, err = io.CopyBuffer(w, bytes.NewReader(in), make([]byte, 100))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	redacted := mustReadBytes(out.Bytes(), dicom.ReadOptions{})
+	elem, err := redacted.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+	require.Equal(t, "Anonymous", elem.MustGetString())
+	elem, err = redacted.FindElementByTag(dicomtag.PatientID)
+	require.NoError(t, err)
+	require.Empty(t, elem.Value)
+	_, err = redacted.FindElementByTag(dicomtag.InstitutionName)
+	require.Error(t, err)
+	_, err = redacted.FindElementByTag(dicomtag.Tag{Group: 0x0010, Element: 0x0000})
+	require.Error(t, err)
+	elem, err = redacted.FindElementByTag(dicomtag.OtherPatientIDsSequence)
+	require.NoError(t, err)
+	item := elem.Value[0].(*dicom.Element)
+	require.Len(t, item.Value, 1)
+	require.Empty(t, item.Value[0].(*dicom.Element).Value)
+
+	// 其他的elements不变
+	want := mustReadBytes(in, dicom.ReadOptions{})
+	for _, tag := range []dicomtag.Tag{dicomtag.SOPInstanceUID, dicomtag.StudyDate, dicomtag.PixelData} {
+		a, err := want.FindElementByTag(tag)
+		require.NoError(t, err)
+		b, err := redacted.FindElementByTag(tag)
+		require.NoError(t, err)
+		require.Equal(t, a.Value, b.Value, dicomtag.DebugString(tag))
+	}
+
+	// 不是DICOM的输入
+	w = dicom.NewRedactingWriter(ioutil.Discard, nil)
+	w.Write([]byte("not a DICOM file")) // nolint: errcheck
+	require.Error(t, w.Close())
+}
@@ -0,0 +1,202 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// RLE Lossless (P3.5 Annex G). 每个frame是一个64 bytes的header(segment的个数和
+// 15个offset, little endian uint32), 后面是segments. 每个segment是一个byte plane:
+// 对每个sample, 从最高位的byte到最低位的byte各一个segment, 每个segment用PackBits压缩
+const (
+	rleHeaderSize  = 64
+	rleMaxSegments = 15
+)
+
+// maxRLEBytes 是一个RLE frame解码后最多的bytes (rows*columns*samples*bytes).
+// 损坏的Rows/Columns不会导致几十GB的内存分配
+const maxRLEBytes = 1 << 28
+
+// rleLayout 检查图像的参数, 返回每个sample的bytes数和segment的个数
+func rleLayout(rows, columns, samplesPerPixel, bitsAllocated int) (bytesPerSample, numSegments int, err error) {
+	if rows <= 0 || columns <= 0 || samplesPerPixel <= 0 {
+		return 0, 0, fmt.Errorf("invalid image size %dx%d, %d samples", columns, rows, samplesPerPixel)
+	}
+	if bitsAllocated <= 0 || bitsAllocated%8 != 0 {
+		return 0, 0, fmt.Errorf("BitsAllocated %d is not supported", bitsAllocated)
+	}
+	bytesPerSample = bitsAllocated / 8
+	numSegments = bytesPerSample * samplesPerPixel
+	if numSegments > rleMaxSegments {
+		return 0, 0, fmt.Errorf("%d samples of %d bits need %d segments, at most %d are allowed", samplesPerPixel, bitsAllocated, numSegments, rleMaxSegments)
+	}
+	return bytesPerSample, numSegments, nil
+}
+
+// DecodeRLE decodes an RLE Lossless (1.2.840.10008.1.2.5) frame into native
+// pixel data: little endian, with the samples of each pixel interleaved
+// (PlanarConfiguration 0), whatever the PlanarConfiguration of the data set.
+func DecodeRLE(frame []byte, rows, columns, samplesPerPixel, bitsAllocated int) ([]byte, error) {
+	bytesPerSample, numSegments, err := rleLayout(rows, columns, samplesPerPixel, bitsAllocated)
+	if err != nil {
+		return nil, fmt.Errorf("dicom.DecodeRLE: %v", err)
+	}
+	if len(frame) < rleHeaderSize {
+		return nil, fmt.Errorf("dicom.DecodeRLE: frame has %d bytes, too short for the RLE header", len(frame))
+	}
+	if n := int(binary.LittleEndian.Uint32(frame)); n != numSegments {
+		return nil, fmt.Errorf("dicom.DecodeRLE: found %d segments, expect %d", n, numSegments)
+	}
+	pixelSize := bytesPerSample * samplesPerPixel
+	if rows > maxRLEBytes/columns/pixelSize {
+		return nil, fmt.Errorf("dicom.DecodeRLE: image size %dx%d with %d bytes per pixel is too large", columns, rows, pixelSize)
+	}
+	numPixels := rows * columns
+	// PackBits的一个replicate run用2 bytes最多解码出128 bytes, 所以每个segment
+	// 至少需要这么多bytes才能填满numPixels
+	if minSegment := 2 * ((numPixels + 127) / 128); len(frame)-rleHeaderSize < numSegments*minSegment {
+		return nil, fmt.Errorf("dicom.DecodeRLE: %d bytes of segments cannot fill a %dx%d image", len(frame)-rleHeaderSize, columns, rows)
+	}
+	native := make([]byte, numPixels*pixelSize)
+	segment := make([]byte, numPixels)
+	for s := 0; s < numSegments; s++ {
+		start := int(binary.LittleEndian.Uint32(frame[4+4*s:]))
+		end := len(frame)
+		if s+1 < numSegments {
+			end = int(binary.LittleEndian.Uint32(frame[4+4*(s+1):]))
+		}
+		if start < rleHeaderSize || start > end || end > len(frame) {
+			return nil, fmt.Errorf("dicom.DecodeRLE: segment %d has invalid offsets %d-%d", s, start, end)
+		}
+		if err := unpackBits(frame[start:end], segment); err != nil {
+			return nil, fmt.Errorf("dicom.DecodeRLE: segment %d: %v", s, err)
+		}
+		// segment s是sample s/bytesPerSample的第s%bytesPerSample个byte, 从最高位开始
+		sample, byteIndex := s/bytesPerSample, bytesPerSample-1-s%bytesPerSample
+		for i, b := range segment {
+			native[i*pixelSize+sample*bytesPerSample+byteIndex] = b
+		}
+	}
+	return native, nil
+}
+
+// unpackBits 把PackBits压缩的data解压到out, out必须被填满. 填满之后的padding被忽略
+func unpackBits(data []byte, out []byte) error {
+	pos := 0
+	for i := 0; pos < len(out); {
+		if i >= len(data) {
+			return fmt.Errorf("decoded %d bytes, expect %d", pos, len(out))
+		}
+		n := int(int8(data[i]))
+		i++
+		switch {
+		case n >= 0: // 接下来n+1个byte原样复制
+			if i+n+1 > len(data) || pos+n+1 > len(out) {
+				return fmt.Errorf("literal run overflows at byte %d", i-1)
+			}
+			copy(out[pos:], data[i:i+n+1])
+			i += n + 1
+			pos += n + 1
+		case n > -128: // 下一个byte重复-n+1次
+			if i >= len(data) || pos-n+1 > len(out) {
+				return fmt.Errorf("replicate run overflows at byte %d", i-1)
+			}
+			for k := 0; k < -n+1; k++ {
+				out[pos+k] = data[i]
+			}
+			i++
+			pos += -n + 1
+		}
+		// -128: no-op
+	}
+	return nil
+}
+
+// EncodeRLE encodes native pixel data, laid out as DecodeRLE returns it,
+// into an RLE Lossless frame.
+func EncodeRLE(native []byte, rows, columns, samplesPerPixel, bitsAllocated int) ([]byte, error) {
+	bytesPerSample, numSegments, err := rleLayout(rows, columns, samplesPerPixel, bitsAllocated)
+	if err != nil {
+		return nil, fmt.Errorf("dicom.EncodeRLE: %v", err)
+	}
+	numPixels := rows * columns
+	pixelSize := bytesPerSample * samplesPerPixel
+	if len(native) < numPixels*pixelSize {
+		return nil, fmt.Errorf("dicom.EncodeRLE: native data has %d bytes, expect %d", len(native), numPixels*pixelSize)
+	}
+	frame := make([]byte, rleHeaderSize)
+	binary.LittleEndian.PutUint32(frame, uint32(numSegments))
+	plane := make([]byte, numPixels)
+	for s := 0; s < numSegments; s++ {
+		binary.LittleEndian.PutUint32(frame[4+4*s:], uint32(len(frame)))
+		sample, byteIndex := s/bytesPerSample, bytesPerSample-1-s%bytesPerSample
+		for i := range plane {
+			plane[i] = native[i*pixelSize+sample*bytesPerSample+byteIndex]
+		}
+		// 每一行分别压缩, run不能跨过行 (P3.5 G.3.1)
+		for r := 0; r < rows; r++ {
+			frame = packBits(frame, plane[r*columns:(r+1)*columns])
+		}
+		if len(frame)%2 != 0 {
+			frame = append(frame, 0) // segment的长度必须是偶数
+		}
+	}
+	return frame, nil
+}
+
+// packBits 把row用PackBits压缩后追加到out
+func packBits(out []byte, row []byte) []byte {
+	for i := 0; i < len(row); {
+		run := 1
+		for i+run < len(row) && run < 128 && row[i+run] == row[i] {
+			run++
+		}
+		if run >= 2 {
+			out = append(out, byte(int8(1-run)), row[i])
+			i += run
+			continue
+		}
+		// literal run, 到下一个至少3个相同byte的run为止
+		start := i
+		for i < len(row) && i-start < 128 {
+			if i+2 < len(row) && row[i] == row[i+1] && row[i+1] == row[i+2] {
+				break
+			}
+			i++
+		}
+		out = append(out, byte(i-start-1))
+		out = append(out, row[start:i]...)
+	}
+	return out
+}
+
+// rleCodec 是RLE Lossless的Codec, 解码出的图像见nativeImage
+type rleCodec struct{}
+
+func (rleCodec) DecodeFrame(ds *DataSet, frame []byte) (image.Image, error) {
+	var dims [4]int64
+	for i, tag := range []dicomtag.Tag{dicomtag.Rows, dicomtag.Columns, dicomtag.SamplesPerPixel, dicomtag.BitsAllocated} {
+		var err error
+		if dims[i], err = intValue(ds, tag, 0); err != nil {
+			return nil, err
+		}
+	}
+	rows, columns, samplesPerPixel, bitsAllocated := int(dims[0]), int(dims[1]), int(dims[2]), int(dims[3])
+	if samplesPerPixel == 0 {
+		samplesPerPixel = 1
+	}
+	native, err := DecodeRLE(frame, rows, columns, samplesPerPixel, bitsAllocated)
+	if err != nil {
+		return nil, err
+	}
+	return nativeImage(native, rows, columns, samplesPerPixel, bitsAllocated)
+}
+
+// EncodeFrame 编码imageNative转换出的native pixel data
+func (rleCodec) EncodeFrame(ds *DataSet, img image.Image) ([]byte, error) {
+	native, samplesPerPixel, bitsAllocated := imageNative(img)
+	return EncodeRLE(native, img.Bounds().Dy(), img.Bounds().Dx(), samplesPerPixel, bitsAllocated)
+}
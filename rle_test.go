@@ -0,0 +1,67 @@
+package dicom_test
+
+import (
+	"image"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRLE(t *testing.T) {
+	// P3.5 G.3.1's example: a literal run, a replicate run, and a no-op.
+	frame := make([]byte, 64)
+	frame[0], frame[4] = 1, 64
+	frame = append(frame, 2, 1, 2, 3, 0xfd, 9, 0x80, 0)
+	native, err := dicom.DecodeRLE(frame, 1, 7, 1, 8)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3, 9, 9, 9, 9}, native)
+	_, err = dicom.DecodeRLE(frame, 2, 7, 1, 8)
+	require.Error(t, err, "segment too short")
+	_, err = dicom.DecodeRLE(frame, 1, 7, 3, 8)
+	require.Error(t, err, "wrong number of segments")
+	_, err = dicom.DecodeRLE(frame, 65535, 65535, 1, 8)
+	require.Error(t, err, "too large")
+	_, err = dicom.DecodeRLE(frame, 100, 100, 1, 8)
+	require.Error(t, err, "segments cannot fill the image")
+
+	for _, spec := range []dicomtest.Spec{
+		{Rows: 8, Columns: 8, BitsAllocated: 8, SamplesPerPixel: 1},
+		{Rows: 8, Columns: 8, BitsAllocated: 16, SamplesPerPixel: 1},
+		{Rows: 8, Columns: 8, BitsAllocated: 8, SamplesPerPixel: 3},
+		{Rows: 3, Columns: 200, BitsAllocated: 16, SamplesPerPixel: 3},
+	} {
+		rows, columns := int(spec.Rows), int(spec.Columns)
+		samples, bits := int(spec.SamplesPerPixel), int(spec.BitsAllocated)
+		pixels := dicomtest.FramePixels(spec, 1)
+		// Long runs, to exercise replicate runs across several rows.
+		for i := len(pixels) / 2; i < len(pixels); i++ {
+			pixels[i] = 5
+		}
+		frame, err := dicom.EncodeRLE(pixels, rows, columns, samples, bits)
+		require.NoError(t, err)
+		require.Equal(t, 0, len(frame)%2)
+		native, err := dicom.DecodeRLE(frame, rows, columns, samples, bits)
+		require.NoError(t, err)
+		require.Equal(t, pixels, native, "%+v", spec)
+	}
+
+	// Through the registered codec.
+	gray16 := image.NewGray16(image.Rect(0, 0, 5, 3))
+	for i := range gray16.Pix {
+		gray16.Pix[i] = byte(i / 4)
+	}
+	ds := newTestDataSet(dicomuid.ExplicitVRLittleEndian)
+	ds.Replace(dicom.MustNewElement(dicomtag.Rows, uint16(3)), "")
+	ds.Replace(dicom.MustNewElement(dicomtag.Columns, uint16(5)), "")
+	ds.Replace(dicom.MustNewElement(dicomtag.SamplesPerPixel, uint16(1)), "")
+	ds.Replace(dicom.MustNewElement(dicomtag.BitsAllocated, uint16(16)), "")
+	require.NoError(t, ds.EncodeFrames(dicomtest.RLELossless, []image.Image{gray16}))
+	ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	img, err := ds.DecodeFrame(0)
+	require.NoError(t, err)
+	require.Equal(t, gray16, img)
+}
@@ -0,0 +1,365 @@
+package dicom
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// SalvageProblem describes damage that Salvage worked around.
+type SalvageProblem struct {
+	// Offset 是问题在输入中的位置(bytes)
+	Offset int64
+
+	Message string
+}
+
+// SalvageError is returned by Salvage, along with the recovered data set,
+// when the input is damaged.
+type SalvageError struct {
+	Problems []SalvageProblem
+}
+
+func (e *SalvageError) Error() string {
+	p := e.Problems[0]
+	return fmt.Sprintf("dicom.Salvage: %d problem(s) found, first at offset %d: %s", len(e.Problems), p.Offset, p.Message)
+}
+
+// Salvage reads a DICOM file that ReadDataSet can't read, e.g., from
+// partially corrupted media, and returns every element it can recover. When
+// it finds bytes that don't parse, it skips ahead to the next plausible
+// element header: a tag that is in the dictionary (or private), a valid VR
+// for explicit VR syntaxes, and a length that fits in the input. Sequences
+// and items with missing delimiters are closed at the first element that
+// can't belong to them, and the elements read so far are kept. Elements
+// after such a point are returned at the top level, even if they belonged
+// to the sequence.
+//
+// Salvage returns a *SalvageError listing the damage, together with the
+// recovered data set, if the input is not well formed. If the file meta
+// header is damaged, the transfer syntax is guessed from the data. Elements
+// are returned in tag order; if a tag is recovered more than once, the
+// first one is kept. Salvage returns a nil data set only if reading "r"
+// fails.
+func Salvage(r io.Reader) (*DataSet, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &salvager{data: data}
+	ds := &DataSet{}
+
+	start := s.readFileHeader(ds)
+	data = s.data // deflated的data set已经被inflate
+	lastGood := start
+	for pos := start; pos < len(data); {
+		elem, next, ok := s.readElement(pos, len(data))
+		if !ok {
+			pos++
+			continue
+		}
+		if pos > lastGood {
+			s.problemf(lastGood, "skipped %d unparsable bytes", pos-lastGood)
+		}
+		if _, err := ds.FindElementByTag(elem.Tag); err == nil {
+			s.problemf(pos, "duplicate element %s dropped", dicomtag.DebugString(elem.Tag))
+		} else {
+			ds.setElement(elem)
+		}
+		if elem.Tag == dicomtag.SpecificCharacterSet {
+			if names, err := elem.GetStrings(); err == nil {
+				if cs, err := dicomio.ParseSpecificCharacterSet(names); err == nil {
+					s.cs = cs
+				}
+			}
+		}
+		pos, lastGood = next, next
+	}
+	if lastGood < len(data) {
+		s.problemf(lastGood, "skipped %d unparsable bytes", len(data)-lastGood)
+	}
+
+	for _, problem := range s.problems {
+		ds.diagnostics = append(ds.diagnostics, newDiagnostic(DiagnosticRepair, dicomtag.Tag{}, problem.Offset, "%s", problem.Message))
+	}
+	if len(s.problems) > 0 {
+		return ds, &SalvageError{Problems: s.problems}
+	}
+	return ds, nil
+}
+
+// salvager 在内存中解析整个文件, 这样可以在出错后回到任意位置重新开始
+type salvager struct {
+	data      []byte
+	byteOrder binary.ByteOrder
+	implicit  dicomio.IsImplicitVR
+	cs        dicomio.CodingSystem
+	problems  []SalvageProblem
+	guessing  bool // guessTransferSyntax的candidate
+}
+
+func (s *salvager) problemf(offset int, format string, args ...interface{}) {
+	s.problems = append(s.problems, SalvageProblem{Offset: int64(offset), Message: fmt.Sprintf(format, args...)})
+}
+
+// readFileHeader 读取meta elements并设置transfer syntax, 返回data set开始的位置.
+// meta header损坏时, 从"DICM"之后(或者文件开头)开始, 并猜测transfer syntax
+func (s *salvager) readFileHeader(ds *DataSet) int {
+	d := dicomio.NewBytesDecoder(s.data, binary.LittleEndian, dicomio.ExplicitVR)
+	metaElems := ParseFileHeader(d)
+	if d.Error() == nil {
+		ds.Elements = metaElems
+		byteOrder, implicit, err := getTransferSyntax(ds)
+		if err == nil {
+			s.byteOrder, s.implicit = byteOrder, implicit
+			if uid, _ := ds.transferSyntaxUID(); uid == dicomuid.DeflatedExplicitVRLittleEndian {
+				s.inflate(int(d.BytesRead()))
+			}
+			return int(d.BytesRead())
+		}
+		s.problemf(0, "invalid transfer syntax: %v", err)
+		s.guessTransferSyntax(int(d.BytesRead()))
+		return int(d.BytesRead())
+	}
+	s.problemf(0, "damaged file meta header: %v", d.Error())
+	start := 0
+	if len(s.data) >= 132 && string(s.data[128:132]) == "DICM" {
+		start = 132
+	}
+	s.guessTransferSyntax(start)
+	return start
+}
+
+// maxSalvageInflateRatio 限制inflate之后的数据最多是deflated数据的多少倍,
+// 这样一个很小的损坏或恶意的文件不会解压出几十GB
+const maxSalvageInflateRatio = 64
+
+// inflate 把start之后的数据换成inflate之后的数据. 之后的offset都是inflate之后的位置.
+// Deflated stream损坏或者超过maxSalvageInflateRatio时, 保留能inflate出来的部分
+func (s *salvager) inflate(start int) {
+	limit := int64(len(s.data)-start) * maxSalvageInflateRatio
+	inflated, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(s.data[start:])), limit+1))
+	if err != nil {
+		s.problemf(start, "damaged deflated data set, inflated %d bytes: %v", len(inflated), err)
+	}
+	if int64(len(inflated)) > limit {
+		inflated = inflated[:limit]
+		s.problemf(start, "deflated data set inflates to more than %d bytes, keeping the first %d", limit, limit)
+	}
+	s.data = append(s.data[:start:start], inflated...)
+}
+
+// guessTransferSyntax 选择能在"start"之后最早解析出一个element的transfer syntax.
+// explicit VR的检查更严格, 所以位置相同时选它. Explicit VR header里的VR, 比如"UL",
+// 按implicit VR读是一个odd group, 所以猜的时候implicit VR只接受字典里的tag
+func (s *salvager) guessTransferSyntax(start int) {
+	bestPos := len(s.data) + 1
+	for _, syntax := range []struct {
+		byteOrder binary.ByteOrder
+		implicit  dicomio.IsImplicitVR
+	}{
+		{binary.LittleEndian, dicomio.ExplicitVR},
+		{binary.LittleEndian, dicomio.ImplicitVR},
+		{binary.BigEndian, dicomio.ExplicitVR},
+	} {
+		candidate := &salvager{data: s.data, byteOrder: syntax.byteOrder, implicit: syntax.implicit, guessing: true}
+		for pos := start; pos < bestPos && pos < len(s.data); pos++ {
+			if _, _, ok := candidate.readElement(pos, len(s.data)); ok {
+				bestPos = pos
+				s.byteOrder, s.implicit = syntax.byteOrder, syntax.implicit
+				break
+			}
+		}
+	}
+	if s.byteOrder == nil {
+		s.byteOrder, s.implicit = binary.LittleEndian, dicomio.ExplicitVR
+	}
+}
+
+// salvageVRs 是explicit VR里合法的VR
+var salvageVRs = map[string]bool{
+	"AE": true, "AS": true, "AT": true, "CS": true, "DA": true, "DS": true, "DT": true, "FD": true,
+	"FL": true, "IS": true, "LO": true, "LT": true, "OB": true, "OD": true, "OF": true, "OL": true,
+	"OV": true, "OW": true, "PN": true, "SH": true, "SL": true, "SQ": true, "SS": true, "ST": true,
+	"SV": true, "TM": true, "UC": true, "UI": true, "UL": true, "UN": true, "UR": true, "US": true,
+	"UT": true, "UV": true,
+}
+
+// elementHeader 是一个element的tag, VR, VL和header的长度
+type elementHeader struct {
+	tag dicomtag.Tag
+	vr  string
+	vl  uint32
+	len int
+}
+
+// readHeader 读取"pos"处的element header. 如果header不像一个element
+// (见Salvage)或者value超出了"end", 返回false
+func (s *salvager) readHeader(pos, end int) (h elementHeader, ok bool) {
+	if pos+8 > end {
+		return h, false
+	}
+	b := s.data[pos:end]
+	h.tag = dicomtag.Tag{Group: s.byteOrder.Uint16(b[0:]), Element: s.byteOrder.Uint16(b[2:])}
+	if h.tag.Group == ItemSeqGroup {
+		h.vr, h.vl, h.len = "NA", s.byteOrder.Uint32(b[4:]), 8
+		switch h.tag {
+		case dicomtag.Item:
+			return h, h.vl == UndefinedLength || pos+8+int(h.vl) <= end
+		case dicomtag.ItemDelimitationItem, dicomtag.SequenceDelimitationItem:
+			return h, h.vl == 0
+		}
+		return h, false
+	}
+	// 文件里的data set不会有group 0-7的element
+	if h.tag.Group < 0x0008 {
+		return h, false
+	}
+	entry, err := dicomtag.Find(h.tag)
+	known := err == nil
+	if s.implicit == dicomio.ImplicitVR {
+		// implicit VR没有VR可以检查, 所以只接受字典里的tag和private tag. 和Parser一样,
+		// 字典里没有的private tag读成UN
+		h.vr, h.vl, h.len = "UN", s.byteOrder.Uint32(b[4:]), 8
+		if known {
+			h.vr = entry.VR
+		} else if s.guessing || !plausiblePrivateTag(h.tag, h.vl) {
+			return h, false
+		}
+	} else {
+		if !known && h.tag.Group%2 == 0 {
+			return h, false
+		}
+		h.vr = string(b[4:6])
+		if !salvageVRs[h.vr] {
+			return h, false
+		}
+		switch h.vr {
+		case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UN", "UC", "UR", "UT", "UV":
+			if pos+12 > end {
+				return h, false
+			}
+			h.vl, h.len = s.byteOrder.Uint32(b[8:]), 12
+		default:
+			h.vl, h.len = uint32(s.byteOrder.Uint16(b[6:])), 8
+		}
+	}
+	if h.vl == UndefinedLength {
+		return h, h.vr == "SQ" || h.vr == "UN" || h.tag == dicomtag.PixelData
+	}
+	return h, h.vl%2 == 0 && pos+h.len+int(h.vl) <= end
+}
+
+// plausiblePrivateTag 返回tag是否可能是一个字典里没有的private element: odd group的
+// private creator (gggg,0010-00FF), 最多64个字符的LO, 或者private data element
+// (gggg,1000-FFFF). P3.5 7.8.1
+func plausiblePrivateTag(tag dicomtag.Tag, vl uint32) bool {
+	if tag.Group%2 == 0 {
+		return false
+	}
+	if tag.Element >= 0x0010 && tag.Element <= 0x00ff {
+		return vl <= 64
+	}
+	return tag.Element >= 0x1000
+}
+
+// decoder 返回读取data[pos:end]的decoder
+func (s *salvager) decoder(pos, end int) *dicomio.Decoder {
+	d := dicomio.NewBytesDecoder(s.data[pos:end], s.byteOrder, s.implicit)
+	d.SetCodingSystem(s.cs)
+	return d
+}
+
+// readElement 读取"pos"处的element, 返回element和它后面的位置. header不合理
+// 或者value不能解码时返回false. SQ总是成功的: 损坏的item会被截断并记录下来
+func (s *salvager) readElement(pos, end int) (*Element, int, bool) {
+	h, ok := s.readHeader(pos, end)
+	if !ok || h.tag.Group == ItemSeqGroup {
+		return nil, 0, false
+	}
+	if h.vr == "SQ" || (h.vr == "UN" && h.vl == UndefinedLength) {
+		elem, next := s.readSequence(h, pos, end)
+		return elem, next, true
+	}
+	valueEnd := end
+	if h.vl != UndefinedLength {
+		valueEnd = pos + h.len + int(h.vl)
+	}
+	d := s.decoder(pos, valueEnd)
+	elem := ReadElement(d, ReadOptions{})
+	if d.Error() != nil || elem == nil {
+		return nil, 0, false
+	}
+	return elem, pos + int(d.BytesRead()), true
+}
+
+// readSequence 读取一个SQ的items. 缺少SequenceDelimitationItem时, SQ在第一个不是Item的element前结束
+func (s *salvager) readSequence(h elementHeader, pos, end int) (*Element, int) {
+	elem := &Element{Tag: h.tag, VR: "SQ", UndefinedLength: h.vl == UndefinedLength}
+	seqEnd := end
+	if !elem.UndefinedLength {
+		seqEnd = pos + h.len + int(h.vl)
+	}
+	p := pos + h.len
+	for p < seqEnd {
+		ih, ok := s.readHeader(p, seqEnd)
+		if ok && ih.tag == dicomtag.SequenceDelimitationItem {
+			return elem, p + ih.len
+		}
+		if !ok || ih.tag != dicomtag.Item {
+			break
+		}
+		var item *Element
+		item, p = s.readItem(ih, p, seqEnd)
+		elem.Value = append(elem.Value, item)
+	}
+	if elem.UndefinedLength {
+		s.problemf(p, "missing sequence delimiter for %s", dicomtag.DebugString(h.tag))
+		return elem, p
+	}
+	if p != seqEnd {
+		s.problemf(p, "damaged item in %s", dicomtag.DebugString(h.tag))
+	}
+	return elem, seqEnd
+}
+
+// readItem 读取一个Item的elements. 缺少ItemDelimitationItem时, undefined length的item
+// 在下一个Item, SequenceDelimitationItem, 不能解析的element或者tag不递增的element前结束
+func (s *salvager) readItem(h elementHeader, pos, end int) (*Element, int) {
+	item := &Element{Tag: dicomtag.Item, VR: "NA", UndefinedLength: h.vl == UndefinedLength}
+	itemEnd := end
+	if !item.UndefinedLength {
+		itemEnd = pos + h.len + int(h.vl)
+	}
+	p := pos + h.len
+	var lastTag dicomtag.Tag
+	for p < itemEnd {
+		if ch, ok := s.readHeader(p, itemEnd); ok && ch.tag == dicomtag.ItemDelimitationItem {
+			return item, p + ch.len
+		} else if ok && (ch.tag.Group == ItemSeqGroup || ch.tag.Compare(lastTag) <= 0) {
+			break
+		}
+		child, next, ok := s.readElement(p, itemEnd)
+		if !ok {
+			break
+		}
+		item.Value = append(item.Value, child)
+		lastTag = child.Tag
+		p = next
+	}
+	if item.UndefinedLength {
+		s.problemf(p, "missing item delimiter")
+		return item, p
+	}
+	if p != itemEnd {
+		s.problemf(p, "damaged element in item")
+	}
+	return item, itemEnd
+}
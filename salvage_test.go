@@ -0,0 +1,90 @@
+package dicom_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSalvage(t *testing.T) {
+	newDamagedDataSet := func(transferSyntaxUID string) []byte {
+		ds := newTestDataSet(transferSyntaxUID)
+		seq := dicom.MustNewSequence(dicomtag.ReferencedImageSequence, []*dicom.Element{
+			dicom.MustNewElement(dicomtag.ReferencedSOPClassUID, "1.2.840.10008.5.1.4.1.1.2"),
+			dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, "1.2.3.4.5.6"),
+		})
+		seq.UndefinedLength = true
+		private := []*dicom.Element{
+			seq,
+			{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x0010}, VR: "LO", Value: []interface{}{"ACME"}},
+			{Tag: dicomtag.Tag{Group: 0x0009, Element: 0x1001}, VR: "LO", Value: []interface{}{"Zhang^San"}},
+		}
+		ds.Elements = append(ds.Elements[:4], append(private, ds.Elements[4:]...)...)
+		ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.ExtendedOffsetTable, VR: "OV",
+			Value: []interface{}{[]byte{0, 0, 0, 0, 0, 0, 0, 0}}})
+		data := mustWriteDataSet(ds)
+		// Drop the sequence delimiter, and put junk before PatientID.
+		seqDelimiter := []byte{0xfe, 0xff, 0xdd, 0xe0, 0, 0, 0, 0}
+		i := bytes.Index(data, seqDelimiter)
+		require.True(t, i > 0)
+		data = append(data[:i:i], data[i+len(seqDelimiter):]...)
+		i = bytes.Index(data, []byte{0x10, 0x00, 0x20, 0x00})
+		require.True(t, i > 0)
+		return append(data[:i:i], append([]byte("\x13junk\x00\xff"), data[i:]...)...)
+	}
+	checkSalvaged := func(ds *dicom.DataSet, err error) {
+		require.NotNil(t, ds)
+		salvageErr, ok := err.(*dicom.SalvageError)
+		require.True(t, ok, "error: %v", err)
+		require.NotEmpty(t, salvageErr.Problems)
+		for tag, value := range map[dicomtag.Tag]string{
+			dicomtag.SOPInstanceUID:    "1.2.3.4.5",
+			dicomtag.PatientName:       "Zhang^San",
+			dicomtag.PatientID:         "P0001",
+			dicomtag.StudyInstanceUID:  "1.2.3.4",
+			dicomtag.SeriesInstanceUID: "1.2.3.4.1",
+		} {
+			elem, err := ds.FindElementByTag(tag)
+			require.NoError(t, err, dicomtag.DebugString(tag))
+			require.Equal(t, value, elem.MustGetString())
+		}
+		_, err = ds.FindElementByTag(dicomtag.ExtendedOffsetTable)
+		require.NoError(t, err)
+		// Private tags, which are UN in implicit VR.
+		for _, tag := range []dicomtag.Tag{{Group: 0x0009, Element: 0x0010}, {Group: 0x0009, Element: 0x1001}} {
+			_, err := ds.FindElementByTag(tag)
+			require.NoError(t, err, dicomtag.DebugString(tag))
+		}
+		seq, err := ds.FindElementByTag(dicomtag.ReferencedImageSequence)
+		require.NoError(t, err)
+		require.Len(t, seq.Value, 1)
+		var itemElems []*dicom.Element
+		for _, v := range seq.Value[0].(*dicom.Element).Value {
+			itemElems = append(itemElems, v.(*dicom.Element))
+		}
+		ref, err := dicom.FindElementByTag(itemElems, dicomtag.ReferencedSOPInstanceUID)
+		require.NoError(t, err)
+		require.Equal(t, "1.2.3.4.5.6", ref.MustGetString())
+	}
+
+	for _, ts := range []string{dicomuid.ExplicitVRLittleEndian, dicomuid.ImplicitVRLittleEndian} {
+		data := newDamagedDataSet(ts)
+		_, err := dicom.ReadDataSetInBytes(data, dicom.ReadOptions{})
+		require.Error(t, err)
+		checkSalvaged(dicom.Salvage(bytes.NewReader(data)))
+
+		// Without a usable meta header, the transfer syntax is guessed.
+		copy(data[128:], "XXXX")
+		checkSalvaged(dicom.Salvage(bytes.NewReader(data)))
+	}
+
+	// Well-formed files are read as ReadDataSet does.
+	data := mustWriteDataSet(newTestDataSet(dicomuid.ExplicitVRLittleEndian))
+	ds, err := dicom.Salvage(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, data, mustWriteDataSet(ds))
+}
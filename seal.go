@@ -0,0 +1,152 @@
+package dicom
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Sealed container 格式 (所有整数都是big endian):
+//
+//  magic      8 bytes "ODCMSEAL"
+//  version    1 byte, 1
+//  headerLen  4 bytes
+//  header     headerLen bytes, SealedHeader的JSON, 明文
+//  nonce      12 bytes
+//  ciphertext AES-GCM(key, nonce, DICOM file, additional data = 前面所有的bytes)
+//
+// header是明文的, 但是作为additional data被认证, 所以不能被修改
+const (
+	sealMagic     = "ODCMSEAL"
+	sealVersion   = 1
+	sealNonceSize = 12
+)
+
+// SealedHeader is the cleartext part of a sealed data set, for indexing
+// sealed instances without decrypting them.
+type SealedHeader struct {
+	SOPClassUID       string `json:"sopClassUID,omitempty"`
+	SOPInstanceUID    string `json:"sopInstanceUID,omitempty"`
+	StudyInstanceUID  string `json:"studyInstanceUID,omitempty"`
+	SeriesInstanceUID string `json:"seriesInstanceUID,omitempty"`
+}
+
+// ErrSealAuthentication is returned by OpenDataSet when the key is wrong or
+// the sealed data was modified.
+var ErrSealAuthentication = errors.New("dicom.OpenDataSet: authentication failed (wrong key or corrupted data)")
+
+// SealDataSet encrypts "ds", written as a DICOM file, with AES-GCM, for
+// storage on untrusted media. "key" must be 16, 24 or 32 bytes long
+// (AES-128, AES-192 or AES-256). The SOP class and the study, series and
+// instance UIDs are kept in cleartext, see ReadSealedHeader; they are
+// authenticated along with the encrypted data. Each call uses a new random
+// nonce.
+func SealDataSet(ds *DataSet, key []byte) ([]byte, error) {
+	aead, err := newSealAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	var header SealedHeader
+	for _, field := range []struct {
+		tag   dicomtag.Tag
+		value *string
+	}{
+		{dicomtag.SOPClassUID, &header.SOPClassUID},
+		{dicomtag.SOPInstanceUID, &header.SOPInstanceUID},
+		{dicomtag.StudyInstanceUID, &header.StudyInstanceUID},
+		{dicomtag.SeriesInstanceUID, &header.SeriesInstanceUID},
+	} {
+		if elem, err := ds.FindElementByTag(field.tag); err == nil {
+			*field.value, _ = elem.GetString()
+		}
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var plaintext bytes.Buffer
+	if err := WriteDataSet(&plaintext, ds); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sealMagic)
+	out.WriteByte(sealVersion)
+	binary.Write(&out, binary.BigEndian, uint32(len(headerBytes))) // nolint: errcheck
+	out.Write(headerBytes)
+	additionalData := append([]byte(nil), out.Bytes()...)
+
+	nonce := make([]byte, sealNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out.Write(nonce)
+	return aead.Seal(out.Bytes(), nonce, plaintext.Bytes(), additionalData), nil
+}
+
+// OpenDataSet decrypts data produced by SealDataSet with the same key, and
+// parses the data set in it with "options".
+func OpenDataSet(data []byte, key []byte, options ReadOptions) (*DataSet, error) {
+	aead, err := newSealAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	_, headerEnd, err := parseSealedHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerEnd+sealNonceSize {
+		return nil, fmt.Errorf("dicom.OpenDataSet: sealed data is truncated")
+	}
+	nonce := data[headerEnd : headerEnd+sealNonceSize]
+	plaintext, err := aead.Open(nil, nonce, data[headerEnd+sealNonceSize:], data[:headerEnd])
+	if err != nil {
+		return nil, ErrSealAuthentication
+	}
+	return ReadDataSetInBytes(plaintext, options)
+}
+
+// ReadSealedHeader returns the cleartext header of data produced by
+// SealDataSet, without a key. The header is not authenticated until the
+// data is opened with OpenDataSet.
+func ReadSealedHeader(data []byte) (SealedHeader, error) {
+	header, _, err := parseSealedHeader(data)
+	return header, err
+}
+
+// parseSealedHeader 返回header和header结束的位置
+func parseSealedHeader(data []byte) (SealedHeader, int, error) {
+	var header SealedHeader
+	const fixedSize = len(sealMagic) + 1 + 4
+	if len(data) < fixedSize || string(data[:len(sealMagic)]) != sealMagic {
+		return header, 0, fmt.Errorf("dicom: not a sealed data set")
+	}
+	if version := data[len(sealMagic)]; version != sealVersion {
+		return header, 0, fmt.Errorf("dicom: unsupported sealed data set version %d", version)
+	}
+	headerLen := int(binary.BigEndian.Uint32(data[len(sealMagic)+1:]))
+	if len(data)-fixedSize < headerLen {
+		return header, 0, fmt.Errorf("dicom: sealed data set header is truncated")
+	}
+	if err := json.Unmarshal(data[fixedSize:fixedSize+headerLen], &header); err != nil {
+		return header, 0, fmt.Errorf("dicom: invalid sealed data set header: %v", err)
+	}
+	return header, fixedSize + headerLen, nil
+}
+
+func newSealAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, sealNonceSize)
+}
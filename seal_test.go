@@ -0,0 +1,48 @@
+package dicom_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealDataSet(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	ds := mustReadBytes(dicomtest.MustBytes(dicomtest.Spec{}), dicom.ReadOptions{})
+	sealed, err := dicom.SealDataSet(ds, key)
+	require.NoError(t, err)
+
+	header, err := dicom.ReadSealedHeader(sealed)
+	require.NoError(t, err)
+	elem, err := ds.FindElementByTag(dicomtag.SOPInstanceUID)
+	require.NoError(t, err)
+	require.Equal(t, elem.MustGetString(), header.SOPInstanceUID)
+	require.NotEmpty(t, header.StudyInstanceUID)
+	require.False(t, bytes.Contains(sealed, []byte("DICOMTEST-1")), "PatientID must be encrypted")
+
+	opened, err := dicom.OpenDataSet(sealed, key, dicom.ReadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, mustWriteDataSet(ds), mustWriteDataSet(opened))
+
+	sealed2, err := dicom.SealDataSet(ds, key)
+	require.NoError(t, err)
+	require.NotEqual(t, sealed, sealed2, "nonces must differ")
+
+	_, err = dicom.OpenDataSet(sealed, []byte("fedcba9876543210fedcba9876543210"), dicom.ReadOptions{})
+	require.Equal(t, dicom.ErrSealAuthentication, err)
+	// The cleartext header is authenticated too.
+	tampered := append([]byte(nil), sealed...)
+	i := bytes.Index(tampered, []byte(header.SOPInstanceUID))
+	tampered[i] = '9'
+	_, err = dicom.OpenDataSet(tampered, key, dicom.ReadOptions{})
+	require.Equal(t, dicom.ErrSealAuthentication, err)
+
+	_, err = dicom.ReadSealedHeader([]byte("DICM"))
+	require.Error(t, err)
+	_, err = dicom.SealDataSet(ds, []byte("short"))
+	require.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package dicom
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// PixelSpacingSource tells where a PixelSpacing comes from, and so what a
+// distance measured with it means.
+type PixelSpacingSource int
+
+const (
+	// PixelSpacingPatient is PixelSpacing (0028,0030), at the top level or
+	// in the PixelMeasuresSequence of SharedFunctionalGroupsSequence. For
+	// projection radiography it may still be calibrated at the detector,
+	// see PixelSpacingCalibrationType (0028,0A02).
+	PixelSpacingPatient PixelSpacingSource = iota + 1
+
+	// PixelSpacingEstimated is ImagerPixelSpacing (0018,1164) divided by the
+	// magnification: EstimatedRadiographicMagnificationFactor (0018,1114),
+	// or DistanceSourceToDetector / DistanceSourceToPatient. It estimates
+	// the spacing at the isocenter of the patient.
+	PixelSpacingEstimated
+
+	// PixelSpacingDetector is ImagerPixelSpacing, uncorrected: the spacing
+	// at the detector plane. Distances in the patient are smaller.
+	PixelSpacingDetector
+)
+
+func (s PixelSpacingSource) String() string {
+	switch s {
+	case PixelSpacingPatient:
+		return "patient"
+	case PixelSpacingEstimated:
+		return "estimated"
+	case PixelSpacingDetector:
+		return "detector"
+	}
+	return fmt.Sprintf("PixelSpacingSource(%d)", int(s))
+}
+
+// PixelSpacing is the physical distance between the centers of adjacent
+// pixels, in mm.
+type PixelSpacing struct {
+	// Row 是相邻两行的距离, Column 是相邻两列的距离 (与PixelSpacing的值的顺序相同)
+	Row, Column float64
+
+	Source PixelSpacingSource
+}
+
+// ErrNoPixelSpacing is returned by DataSet.PixelSpacing when the data set
+// has none of the attributes it uses.
+var ErrNoPixelSpacing = errors.New("dicom: no PixelSpacing or ImagerPixelSpacing")
+
+// PixelSpacing returns the pixel spacing of the image, using the first of
+// these that is present:
+//
+//	PixelSpacing, see PixelSpacingPatient
+//	ImagerPixelSpacing and the magnification, see PixelSpacingEstimated
+//	ImagerPixelSpacing, see PixelSpacingDetector
+//
+// Check Source before measuring: only PixelSpacingPatient and
+// PixelSpacingEstimated give distances in the patient.
+func (f *DataSet) PixelSpacing() (PixelSpacing, error) {
+	elem, err := f.FindElementByTag(dicomtag.PixelSpacing)
+	if err != nil {
+		elem, err = sharedPixelSpacing(f)
+	}
+	if err == nil {
+		return parsePixelSpacing(elem, PixelSpacingPatient)
+	}
+
+	elem, err = f.FindElementByTag(dicomtag.ImagerPixelSpacing)
+	if err != nil {
+		return PixelSpacing{}, ErrNoPixelSpacing
+	}
+	spacing, err := parsePixelSpacing(elem, PixelSpacingDetector)
+	if err != nil {
+		return PixelSpacing{}, err
+	}
+	magnification, err := f.radiographicMagnification()
+	if err != nil {
+		return PixelSpacing{}, err
+	}
+	if magnification > 0 {
+		spacing.Row /= magnification
+		spacing.Column /= magnification
+		spacing.Source = PixelSpacingEstimated
+	}
+	return spacing, nil
+}
+
+// sharedPixelSpacing 返回enhanced multi-frame图像所有frame共用的PixelSpacing
+func sharedPixelSpacing(f *DataSet) (*Element, error) {
+	elems := f.Elements
+	for _, tag := range []dicomtag.Tag{dicomtag.SharedFunctionalGroupsSequence, dicomtag.PixelMeasuresSequence} {
+		seq, err := FindElementByTag(elems, tag)
+		if err != nil {
+			return nil, err
+		}
+		if len(seq.Value) == 0 {
+			return nil, fmt.Errorf("%v: empty sequence", dicomtag.DebugString(tag))
+		}
+		item, ok := seq.Value[0].(*Element)
+		if !ok {
+			return nil, fmt.Errorf("%v: not an item", dicomtag.DebugString(tag))
+		}
+		elems = itemElements(item)
+	}
+	return FindElementByTag(elems, dicomtag.PixelSpacing)
+}
+
+// radiographicMagnification 返回projection radiography的放大倍数, 不知道时返回0
+func (f *DataSet) radiographicMagnification() (float64, error) {
+	if elem, err := f.FindElementByTag(dicomtag.EstimatedRadiographicMagnificationFactor); err == nil {
+		return parseDecimalString(elem)
+	}
+	sid, err := f.FindElementByTag(dicomtag.DistanceSourceToDetector)
+	if err != nil {
+		return 0, nil
+	}
+	sod, err := f.FindElementByTag(dicomtag.DistanceSourceToPatient)
+	if err != nil {
+		return 0, nil
+	}
+	toDetector, err := parseDecimalString(sid)
+	if err != nil {
+		return 0, err
+	}
+	toPatient, err := parseDecimalString(sod)
+	if err != nil {
+		return 0, err
+	}
+	if toPatient <= 0 {
+		return 0, fmt.Errorf("%v: invalid value %v", dicomtag.DebugString(dicomtag.DistanceSourceToPatient), toPatient)
+	}
+	return toDetector / toPatient, nil
+}
+
+func parsePixelSpacing(elem *Element, source PixelSpacingSource) (PixelSpacing, error) {
+	values, err := parseDecimalStrings(elem)
+	if err != nil {
+		return PixelSpacing{}, err
+	}
+	if len(values) != 2 || values[0] <= 0 || values[1] <= 0 {
+		return PixelSpacing{}, fmt.Errorf("%v: invalid value %v", dicomtag.DebugString(elem.Tag), values)
+	}
+	return PixelSpacing{Row: values[0], Column: values[1], Source: source}, nil
+}
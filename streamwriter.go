@@ -0,0 +1,120 @@
+package dicom
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// Writer writes a DICOM file one element at a time, so that large data
+// sets, e.g., a segmentation whose frames are generated on the fly, can be
+// written without assembling a DataSet first. Call WriteMeta once, then
+// WriteNext for each top-level element in increasing tag order, then Close.
+// Parser reads such a file back one element at a time.
+//
+// Errors are sticky: after a method returns an error, later calls return
+// the same error.
+type Writer struct {
+	e   *dicomio.Encoder
+	err error
+
+	metaWritten bool
+	closed      bool
+
+	// lastTag 是最后写入的top-level element的tag
+	lastTag dicomtag.Tag
+}
+
+// NewWriter creates a Writer that writes to "out". The output is buffered
+// until Close.
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{e: dicomio.NewBufferedEncoder(out, nil, dicomio.UnknownVR)}
+}
+
+// setErr 记录第一个错误
+func (w *Writer) setErr(err error) error {
+	if w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// WriteMeta writes the preamble and the file meta information (group 0002)
+// elements in "meta", which must include MediaStorageSOPClassUID,
+// MediaStorageSOPInstanceUID and TransferSyntaxUID, see WriteFileHeader.
+// The transfer syntax applies to the elements written by WriteNext.
+func (w *Writer) WriteMeta(meta []*Element) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.metaWritten {
+		return w.setErr(errors.New("dicom.Writer: WriteMeta called twice"))
+	}
+	for _, elem := range meta {
+		if elem.Tag.Group != dicomtag.MetadataGroup {
+			return w.setErr(fmt.Errorf("dicom.Writer: %s is not a file meta element", dicomtag.DebugString(elem.Tag)))
+		}
+	}
+	ds := &DataSet{Elements: meta}
+	if _, _, err := getTransferSyntax(ds); err != nil {
+		return w.setErr(err)
+	}
+	WriteFileHeader(w.e, meta)
+	if err := w.e.Error(); err != nil {
+		return w.setErr(err)
+	}
+	uid, _ := ds.transferSyntaxUID()
+	w.e.PushTransferSyntaxByUID(uid)
+	w.metaWritten = true
+	return nil
+}
+
+// WriteNext writes the top-level element "elem". Its tag must be larger
+// than the tag of the previous element (P3.5 7.1), and not in the file meta
+// group. Sequences are written with their items. Multi-frame native
+// PixelData is written as with WriteDataSet, but NumberOfFrames must have
+// been written before it.
+func (w *Writer) WriteNext(elem *Element) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.metaWritten {
+		return w.setErr(errors.New("dicom.Writer: WriteNext called before WriteMeta"))
+	}
+	if w.closed {
+		return w.setErr(errors.New("dicom.Writer: WriteNext called after Close"))
+	}
+	if elem.Tag.Group == dicomtag.MetadataGroup {
+		return w.setErr(fmt.Errorf("dicom.Writer: file meta element %s must be written by WriteMeta", dicomtag.DebugString(elem.Tag)))
+	}
+	if elem.Tag.Compare(w.lastTag) <= 0 {
+		return w.setErr(fmt.Errorf("dicom.Writer: %s written after %s; elements must be in increasing tag order",
+			dicomtag.DebugString(elem.Tag), dicomtag.DebugString(w.lastTag)))
+	}
+	WriteElement(w.e, elem)
+	if err := w.e.Error(); err != nil {
+		return w.setErr(err)
+	}
+	w.lastTag = elem.Tag
+	return nil
+}
+
+// Close flushes the buffered output. It doesn't close the underlying
+// io.Writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.metaWritten {
+		return w.setErr(errors.New("dicom.Writer: Close called before WriteMeta"))
+	}
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.e.PopTransferSyntax()
+	return w.setErr(w.e.Flush())
+}
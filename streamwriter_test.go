@@ -0,0 +1,57 @@
+package dicom_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	ds := newTestDataSet(dicomuid.ImplicitVRLittleEndian)
+	ds.Elements = append(ds.Elements, dicom.MustNewSequence(dicomtag.ReferencedImageSequence, []*dicom.Element{
+		dicom.MustNewElement(dicomtag.ReferencedSOPInstanceUID, "1.2.3.4.6"),
+	}))
+	var meta, body []*dicom.Element
+	for _, elem := range ds.Elements {
+		if elem.Tag.Group == dicomtag.MetadataGroup {
+			meta = append(meta, elem)
+		} else {
+			body = append(body, elem)
+		}
+	}
+
+	// ReferencedImageSequence (0008,1140) goes right after SOPInstanceUID.
+	body = append([]*dicom.Element{body[0], body[5]}, body[1:5]...)
+	ds.Elements = append(meta, body...)
+
+	// The output is the same as WriteDataSet's.
+	var buf bytes.Buffer
+	w := dicom.NewWriter(&buf)
+	require.NoError(t, w.WriteMeta(meta))
+	for _, elem := range body {
+		require.NoError(t, w.WriteNext(elem))
+	}
+	require.NoError(t, w.Close())
+	require.Equal(t, mustWriteDataSet(ds), buf.Bytes())
+
+	// Ordering is enforced, and errors are sticky.
+	w = dicom.NewWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteNext(body[0]), "before WriteMeta")
+	w = dicom.NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteMeta(meta))
+	require.Error(t, w.WriteNext(meta[0]))
+	w = dicom.NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteMeta(meta))
+	require.NoError(t, w.WriteNext(body[2]))
+	err := w.WriteNext(body[1])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "increasing tag order")
+	require.Equal(t, err, w.WriteNext(body[3]))
+	require.Equal(t, err, w.Close())
+
+	require.Error(t, dicom.NewWriter(&bytes.Buffer{}).WriteMeta(body))
+}
@@ -0,0 +1,340 @@
+package dicom
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomuid"
+)
+
+// Transcode converts "ds" in place to the transfer syntax
+// "targetTransferSyntaxUID", so that WriteDataSet writes it in that syntax.
+//
+// Elements other than PixelData are kept as decoded values and take the
+// target byte order and VR encoding when written. Native PixelData is byte
+// swapped if the byte order changes. Encapsulated PixelData is decoded, and
+// PixelData is encoded for an encapsulated target, with the codecs
+// registered for the transfer syntaxes (see RegisterCodec); decoding updates
+// SamplesPerPixel, BitsAllocated, PhotometricInterpretation (RGB for color)
+// and PlanarConfiguration to describe the decoded pixels, and encoding to a
+// lossy syntax sets the Lossy Image Compression attributes, see
+// SetLossyImageCompression. The changes are recorded in ds.ChangeLog.
+func Transcode(ds *DataSet, targetTransferSyntaxUID string) error {
+	return TranscodeWithContext(context.Background(), ds, targetTransferSyntaxUID)
+}
+
+// TranscodeWithContext is Transcode, but stops with ctx.Err() when "ctx" is
+// canceled, between the frames decoded or converted, and before encoding.
+// "ds" is then unchanged.
+func TranscodeWithContext(ctx context.Context, ds *DataSet, targetTransferSyntaxUID string) (err error) {
+	defer dicomio.Recover(&err)
+	target := targetTransferSyntaxUID
+	entry, err := dicomuid.Lookup(target)
+	if err != nil {
+		return err
+	}
+	if entry.Type != dicomuid.TypeTransferSyntax {
+		return fmt.Errorf("dicom.Transcode: '%s' is not a transfer syntax (is %s)", target, entry.Type)
+	}
+	source, err := ds.transferSyntaxUID()
+	if err != nil {
+		return err
+	}
+	if source == target {
+		return nil
+	}
+	if pixelData, err := ds.FindElementByTag(dicomtag.PixelData); err == nil {
+		if err := transcodePixelData(ctx, ds, pixelData, source, target); err != nil {
+			if err == ctx.Err() {
+				return err
+			}
+			return fmt.Errorf("dicom.Transcode: %v", err)
+		}
+	}
+	ds.Replace(MustNewElement(dicomtag.TransferSyntaxUID, target), "")
+	return nil
+}
+
+// lossyTransferSyntaxes 是有损压缩的transfer syntaxes和它们的LossyImageCompressionMethod
+// (P3.3 C.7.6.1.1.5.1)
+var lossyTransferSyntaxes = map[string]string{
+	"1.2.840.10008.1.2.4.50": "ISO_10918_1", // JPEG Baseline
+	"1.2.840.10008.1.2.4.51": "ISO_10918_1", // JPEG Extended
+	"1.2.840.10008.1.2.4.81": "ISO_14495_1", // JPEG-LS Near-Lossless
+	"1.2.840.10008.1.2.4.91": "ISO_15444_1", // JPEG 2000
+}
+
+// maxDerivationDescriptionLength 是ST的最大长度
+const maxDerivationDescriptionLength = 1024
+
+// SetLossyImageCompression records in "ds" that its pixel data was
+// compressed with the lossy transfer syntax "transferSyntaxUID", with the
+// compression ratio "ratio" (uncompressed size / compressed size), as
+// required by P3.3 C.7.6.1.1.5. It sets LossyImageCompression to "01",
+// appends the ratio and the method to LossyImageCompressionRatio and
+// LossyImageCompressionMethod, keeping the values of earlier lossy
+// compressions, and appends a description of the compression to
+// DerivationDescription. Transcode calls it; callers of EncodeFrames with a
+// lossy syntax should call it too. The changes are recorded in
+// ds.ChangeLog.
+func SetLossyImageCompression(ds *DataSet, transferSyntaxUID string, ratio float64) error {
+	method, ok := lossyTransferSyntaxes[transferSyntaxUID]
+	if !ok {
+		return fmt.Errorf("dicom.SetLossyImageCompression: %s is not a lossy transfer syntax", transferSyntaxUID)
+	}
+	if !(ratio > 0) {
+		return fmt.Errorf("dicom.SetLossyImageCompression: invalid compression ratio %v", ratio)
+	}
+	var ratios, methods []interface{}
+	if elem, err := ds.FindElementByTag(dicomtag.LossyImageCompression); err == nil {
+		if v, err := elem.GetString(); err == nil && v == "01" {
+			// 以前的有损压缩, 按顺序保留
+			if elem, err := ds.FindElementByTag(dicomtag.LossyImageCompressionRatio); err == nil {
+				ratios = append(ratios, elem.Value...)
+			}
+			if elem, err := ds.FindElementByTag(dicomtag.LossyImageCompressionMethod); err == nil {
+				methods = append(methods, elem.Value...)
+			}
+		}
+	}
+	ratioString := strconv.FormatFloat(ratio, 'f', 2, 64)
+	ratios = append(ratios, ratioString)
+	methods = append(methods, method)
+
+	description := fmt.Sprintf("Lossy compression with %s, compression ratio %s:1", dicomuid.UIDString(transferSyntaxUID), ratioString)
+	if elem, err := ds.FindElementByTag(dicomtag.DerivationDescription); err == nil {
+		if v, err := elem.GetString(); err == nil && strings.TrimSpace(v) != "" {
+			description = strings.TrimSpace(v) + "; " + description
+		}
+	}
+	if len(description) > maxDerivationDescriptionLength {
+		description = description[:maxDerivationDescriptionLength]
+	}
+
+	ds.Replace(MustNewElement(dicomtag.LossyImageCompression, "01"), "")
+	ds.Replace(MustNewElement(dicomtag.LossyImageCompressionRatio, ratios...), "")
+	ds.Replace(MustNewElement(dicomtag.LossyImageCompressionMethod, methods...), "")
+	ds.Replace(MustNewElement(dicomtag.DerivationDescription, description), "")
+	return nil
+}
+
+// imageNativeSize 是imageNative转换出的native frame的大小
+func imageNativeSize(img image.Image) int64 {
+	b := img.Bounds()
+	size := int64(b.Dx()) * int64(b.Dy())
+	switch img.(type) {
+	case *image.Gray:
+		return size
+	case *image.Gray16:
+		return 2 * size
+	}
+	return 3 * size
+}
+
+func transcodePixelData(ctx context.Context, ds *DataSet, pixelData *Element, source, target string) error {
+	sourceOrder, targetOrder := binary.ByteOrder(binary.LittleEndian), binary.ByteOrder(binary.LittleEndian)
+	if !isEncapsulatedSyntax(source) {
+		sourceOrder, _, _ = dicomio.ParseTransferSyntaxUID(source)
+	}
+	if !isEncapsulatedSyntax(target) {
+		targetOrder, _, _ = dicomio.ParseTransferSyntaxUID(target)
+	}
+	bitsAllocated, err := intValue(ds, dicomtag.BitsAllocated, 0)
+	if err != nil {
+		return err
+	}
+
+	if !isEncapsulatedSyntax(source) && !isEncapsulatedSyntax(target) {
+		if sourceOrder == targetOrder || bitsAllocated <= 8 {
+			return nil
+		}
+		frames, err := pixelDataFrames(ds, pixelData)
+		if err != nil {
+			return err
+		}
+		for i := range frames {
+			frames[i] = swapBytes(frames[i], int(bitsAllocated/8))
+		}
+		ds.Replace(&Element{Tag: dicomtag.PixelData, VR: pixelData.VR, Value: []interface{}{PixelDataInfo{Frames: frames}}}, "")
+		return nil
+	}
+
+	// 至少一边是encapsulated: 先转换成image.Image
+	var images []image.Image
+	if isEncapsulatedSyntax(source) {
+		info, ok := pixelData.Value[0].(PixelDataInfo)
+		if len(pixelData.Value) != 1 || !ok {
+			return fmt.Errorf("PixelData must have one value of type PixelDataInfo")
+		}
+		numFrames, err := intValue(ds, dicomtag.NumberOfFrames, 1)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < info.numFrames(numFrames); i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			img, err := ds.DecodeFrame(i)
+			if err != nil {
+				return err
+			}
+			images = append(images, img)
+		}
+	} else {
+		frames, err := pixelDataFrames(ds, pixelData)
+		if err != nil {
+			return err
+		}
+		var dims [3]int64
+		for i, tag := range []dicomtag.Tag{dicomtag.Rows, dicomtag.Columns, dicomtag.SamplesPerPixel} {
+			if dims[i], err = intValue(ds, tag, 1); err != nil {
+				return err
+			}
+		}
+		planar, err := intValue(ds, dicomtag.PlanarConfiguration, 0)
+		if err != nil {
+			return err
+		}
+		for _, frame := range frames {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if sourceOrder != binary.LittleEndian && bitsAllocated > 8 {
+				frame = swapBytes(frame, int(bitsAllocated/8))
+			}
+			if planar == 1 {
+				frame = interleaveSamples(frame, int(dims[2]))
+			}
+			img, err := nativeImage(frame, int(dims[0]), int(dims[1]), int(dims[2]), int(bitsAllocated))
+			if err != nil {
+				return err
+			}
+			images = append(images, img)
+		}
+	}
+
+	if len(images) == 0 {
+		return fmt.Errorf("PixelData has no frames")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if isEncapsulatedSyntax(target) {
+		if target == "1.2.840.10008.1.2.4.50" {
+			// image/jpeg只能编码8 bit samples, 16 bit的值会被截断
+			bitsStored, err := intValue(ds, dicomtag.BitsStored, bitsAllocated)
+			if err != nil {
+				return err
+			}
+			if _, ok := images[0].(*image.Gray16); ok || bitsStored > 8 {
+				return fmt.Errorf("JPEG Baseline supports only 8 bit samples, BitsStored is %d", bitsStored)
+			}
+		}
+		if err := ds.EncodeFrames(target, images); err != nil {
+			return err
+		}
+		// 和native target一样, Image Pixel attributes要描述编码的pixel data, 而不是source的
+		samplesPerPixel, bits := encodedImageFormat(target, images[0])
+		setImagePixelAttributes(ds, samplesPerPixel, bits)
+		if _, ok := lossyTransferSyntaxes[target]; ok {
+			var uncompressed, compressed int64
+			for _, img := range images {
+				uncompressed += imageNativeSize(img)
+			}
+			if elem, err := ds.FindElementByTag(dicomtag.PixelData); err == nil {
+				for _, frame := range elem.Value[0].(PixelDataInfo).Frames {
+					compressed += int64(len(frame))
+				}
+			}
+			if compressed == 0 {
+				return fmt.Errorf("PixelData is empty after encoding")
+			}
+			if err := SetLossyImageCompression(ds, target, float64(uncompressed)/float64(compressed)); err != nil {
+				return err
+			}
+			if _, ok := images[0].(*image.Gray); !ok && target == "1.2.840.10008.1.2.4.50" {
+				// image/jpeg把彩色图像编码成YCbCr
+				ds.Replace(MustNewElement(dicomtag.PhotometricInterpretation, "YBR_FULL_422"), "")
+			}
+		}
+		return nil
+	}
+
+	// Encapsulated -> native
+	var frames [][]byte
+	samplesPerPixel, bits := 0, 0
+	for _, img := range images {
+		native, s, b := imageNative(img)
+		if b > 8 && targetOrder != binary.LittleEndian {
+			native = swapBytes(native, b/8)
+		}
+		frames = append(frames, native)
+		samplesPerPixel, bits = s, b
+	}
+	setImagePixelAttributes(ds, samplesPerPixel, bits)
+	vr := "OW"
+	if bits == 8 {
+		vr = "OB"
+	}
+	ds.Replace(&Element{Tag: dicomtag.PixelData, VR: vr, Value: []interface{}{PixelDataInfo{Frames: frames}}}, "")
+	return nil
+}
+
+// encodedImageFormat 返回target的codec编码img时的samples per pixel和bits allocated,
+// 和imageNative一致, 除了JPEG Baseline
+func encodedImageFormat(target string, img image.Image) (samplesPerPixel, bitsAllocated int) {
+	switch img.(type) {
+	case *image.Gray:
+		return 1, 8
+	case *image.Gray16:
+		if target != "1.2.840.10008.1.2.4.50" {
+			return 1, 16
+		}
+	}
+	// image/jpeg把其他图像编码成3个8 bit components
+	return 3, 8
+}
+
+// setImagePixelAttributes 更新Image Pixel module的attributes, 使之描述imageNative转换出的native pixel data
+func setImagePixelAttributes(ds *DataSet, samplesPerPixel, bitsAllocated int) {
+	ds.Replace(MustNewElement(dicomtag.SamplesPerPixel, uint16(samplesPerPixel)), "")
+	ds.Replace(MustNewElement(dicomtag.BitsAllocated, uint16(bitsAllocated)), "")
+	if bitsStored, err := intValue(ds, dicomtag.BitsStored, 0); err != nil || bitsStored == 0 || bitsStored > int64(bitsAllocated) {
+		ds.Replace(MustNewElement(dicomtag.BitsStored, uint16(bitsAllocated)), "")
+		ds.Replace(MustNewElement(dicomtag.HighBit, uint16(bitsAllocated-1)), "")
+	}
+	if samplesPerPixel == 3 {
+		ds.Replace(MustNewElement(dicomtag.PhotometricInterpretation, "RGB"), "")
+		ds.Replace(MustNewElement(dicomtag.PlanarConfiguration, uint16(0)), "")
+	}
+}
+
+// swapBytes 返回把data中每个size bytes的word的byte order反转后的拷贝
+func swapBytes(data []byte, size int) []byte {
+	swapped := append([]byte(nil), data...)
+	for i := 0; i+size <= len(data); i += size {
+		for j := 0; j < size; j++ {
+			swapped[i+j] = data[i+size-1-j]
+		}
+	}
+	return swapped
+}
+
+// interleaveSamples 把PlanarConfiguration 1 (每个sample一个plane) 的8 bit frame转换成
+// 每个pixel的samples交错排列
+func interleaveSamples(frame []byte, samplesPerPixel int) []byte {
+	n := len(frame) / samplesPerPixel
+	interleaved := make([]byte, len(frame))
+	for s := 0; s < samplesPerPixel; s++ {
+		for i := 0; i < n; i++ {
+			interleaved[i*samplesPerPixel+s] = frame[s*n+i]
+		}
+	}
+	return interleaved
+}
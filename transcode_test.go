@@ -0,0 +1,120 @@
+package dicom_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/odincare/odicom/dicomuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscode(t *testing.T) {
+	spec := dicomtest.Spec{TransferSyntaxUID: dicomuid.ImplicitVRLittleEndian, BitsAllocated: 16, NumberOfFrames: 3}
+	checkFrames := func(ds *dicom.DataSet) {
+		elem, err := ds.FindElementByTag(dicomtag.PixelData)
+		require.NoError(t, err)
+		frames := elem.Value[0].(dicom.PixelDataInfo).Frames
+		require.Len(t, frames, 3)
+		for i, frame := range frames {
+			require.Equal(t, dicomtest.FramePixels(spec, i), frame)
+		}
+	}
+	ds := mustReadBytes(dicomtest.MustBytes(spec), dicom.ReadOptions{})
+	name, err := ds.FindElementByTag(dicomtag.PatientName)
+	require.NoError(t, err)
+
+	// Implicit VR LE -> Explicit VR BE -> RLE Lossless -> Explicit VR LE.
+	for _, uid := range []string{dicomuid.ExplicitVRBigEndian, dicomtest.RLELossless, dicomuid.ExplicitVRLittleEndian} {
+		require.NoError(t, dicom.Transcode(ds, uid))
+		ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+		elem, err := ds.FindElementByTag(dicomtag.TransferSyntaxUID)
+		require.NoError(t, err)
+		require.Equal(t, uid, elem.MustGetString())
+		elem, err = ds.FindElementByTag(dicomtag.PatientName)
+		require.NoError(t, err)
+		require.Equal(t, name.Value, elem.Value, uid)
+		if uid == dicomuid.ExplicitVRBigEndian {
+			elem, err := ds.FindElementByTag(dicomtag.PixelData)
+			require.NoError(t, err)
+			frame := elem.Value[0].(dicom.PixelDataInfo).Frames[0]
+			require.Equal(t, dicomtest.FramePixels(spec, 0)[:2], []byte{frame[1], frame[0]})
+		}
+	}
+	checkFrames(ds)
+	require.NoError(t, dicom.Transcode(ds, dicomuid.ExplicitVRLittleEndian))
+	checkFrames(ds)
+
+	// Lossy: decodable, and marked as lossy.
+	ds = mustReadBytes(dicomtest.MustBytes(dicomtest.Spec{SamplesPerPixel: 3}), dicom.ReadOptions{})
+	require.NoError(t, dicom.Transcode(ds, dicomtest.JPEGBaseline))
+	ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	elem, err := ds.FindElementByTag(dicomtag.LossyImageCompression)
+	require.NoError(t, err)
+	require.Equal(t, "01", elem.MustGetString())
+	elem, err = ds.FindElementByTag(dicomtag.LossyImageCompressionMethod)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"ISO_10918_1"}, elem.Value)
+	elem, err = ds.FindElementByTag(dicomtag.LossyImageCompressionRatio)
+	require.NoError(t, err)
+	require.Len(t, elem.Value, 1)
+	ratio, err := strconv.ParseFloat(elem.MustGetString(), 64)
+	require.NoError(t, err)
+	require.True(t, ratio > 0, "ratio %v", ratio)
+	elem, err = ds.FindElementByTag(dicomtag.DerivationDescription)
+	require.NoError(t, err)
+	require.Contains(t, elem.MustGetString(), "JPEG Baseline")
+	require.NoError(t, dicom.Transcode(ds, dicomuid.ImplicitVRLittleEndian))
+	elem, err = ds.FindElementByTag(dicomtag.PhotometricInterpretation)
+	require.NoError(t, err)
+	require.Equal(t, "RGB", elem.MustGetString())
+
+	// A second lossy compression is appended to the first.
+	require.NoError(t, dicom.Transcode(ds, dicomtest.JPEGBaseline))
+	elem, err = ds.FindElementByTag(dicomtag.LossyImageCompressionMethod)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"ISO_10918_1", "ISO_10918_1"}, elem.Value)
+	elem, err = ds.FindElementByTag(dicomtag.LossyImageCompressionRatio)
+	require.NoError(t, err)
+	require.Len(t, elem.Value, 2)
+	elem, err = ds.FindElementByTag(dicomtag.DerivationDescription)
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(elem.MustGetString(), "Lossy compression"))
+	require.NoError(t, dicom.Transcode(ds, dicomuid.ImplicitVRLittleEndian))
+	require.Error(t, dicom.SetLossyImageCompression(ds, dicomtest.RLELossless, 2))
+	require.Error(t, dicom.SetLossyImageCompression(ds, dicomtest.JPEGBaseline, 0))
+
+	// Encapsulated -> encapsulated: the attributes describe the new pixel data.
+	ds = mustReadBytes(dicomtest.MustBytes(dicomtest.Spec{SamplesPerPixel: 3}), dicom.ReadOptions{})
+	require.NoError(t, dicom.Transcode(ds, dicomtest.JPEGBaseline))
+	elem, err = ds.FindElementByTag(dicomtag.PhotometricInterpretation)
+	require.NoError(t, err)
+	require.Equal(t, "YBR_FULL_422", elem.MustGetString())
+	require.NoError(t, dicom.Transcode(ds, dicomtest.RLELossless))
+	ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	for tag, value := range map[dicomtag.Tag]interface{}{
+		dicomtag.PhotometricInterpretation: "RGB",
+		dicomtag.SamplesPerPixel:           uint16(3),
+		dicomtag.BitsAllocated:             uint16(8),
+		dicomtag.PlanarConfiguration:       uint16(0),
+	} {
+		elem, err = ds.FindElementByTag(tag)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{value}, elem.Value, dicomtag.DebugString(tag))
+	}
+	_, err = ds.DecodeFrame(0)
+	require.NoError(t, err)
+
+	// JPEG Baseline can't hold 16 bit samples.
+	ds = mustReadBytes(dicomtest.MustBytes(dicomtest.Spec{BitsAllocated: 16}), dicom.ReadOptions{})
+	require.Error(t, dicom.Transcode(ds, dicomtest.JPEGBaseline))
+	elem, err = ds.FindElementByTag(dicomtag.BitsAllocated)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{uint16(16)}, elem.Value)
+
+	require.Error(t, dicom.Transcode(ds, "1.2.3.4"))
+	require.Error(t, dicom.Transcode(ds, dicomtest.JPEGLossless), "no codec")
+}
@@ -0,0 +1,62 @@
+package dicom
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/odincare/odicom/dicomtag"
+)
+
+// videoTransferSyntaxes 是MPEG2, MPEG-4 AVC/H.264和HEVC/H.265的transfer syntaxes.
+// 它们的encapsulated pixel data是一个被切成fragments的视频流, 而不是每个fragment一个frame
+var videoTransferSyntaxes = map[string]bool{
+	"1.2.840.10008.1.2.4.100": true, // MPEG2 Main Profile / Main Level
+	"1.2.840.10008.1.2.4.101": true, // MPEG2 Main Profile / High Level
+	"1.2.840.10008.1.2.4.102": true, // MPEG-4 AVC/H.264 High Profile / Level 4.1
+	"1.2.840.10008.1.2.4.103": true, // MPEG-4 AVC/H.264 BD-compatible High Profile / Level 4.1
+	"1.2.840.10008.1.2.4.104": true, // MPEG-4 AVC/H.264 High Profile / Level 4.2 For 2D Video
+	"1.2.840.10008.1.2.4.105": true, // MPEG-4 AVC/H.264 High Profile / Level 4.2 For 3D Video
+	"1.2.840.10008.1.2.4.106": true, // MPEG-4 AVC/H.264 Stereo High Profile / Level 4.2
+	"1.2.840.10008.1.2.4.107": true, // HEVC/H.265 Main Profile / Level 5.1
+	"1.2.840.10008.1.2.4.108": true, // HEVC/H.265 Main 10 Profile / Level 5.1
+}
+
+// IsVideoTransferSyntax reports whether "transferSyntaxUID" is an MPEG2,
+// MPEG-4 AVC/H.264 or HEVC/H.265 transfer syntax, whose PixelData is one
+// video stream rather than a sequence of frames.
+func IsVideoTransferSyntax(transferSyntaxUID string) bool {
+	return videoTransferSyntaxes[transferSyntaxUID]
+}
+
+// VideoStream returns the video stream in the PixelData of "f": the
+// fragments of the encapsulated PixelData concatenated, without the basic
+// offset table, e.g., to pipe to a media player or transcoder. A fragment
+// may end with the padding byte that made its length even; MPEG decoders
+// ignore it. Returns an error if the transfer syntax of f is not a video
+// transfer syntax (see IsVideoTransferSyntax).
+func (f *DataSet) VideoStream() (io.Reader, error) {
+	uid, err := f.transferSyntaxUID()
+	if err != nil {
+		return nil, err
+	}
+	if !IsVideoTransferSyntax(uid) {
+		return nil, fmt.Errorf("dicom.VideoStream: transfer syntax %s is not a video transfer syntax", uid)
+	}
+	pixelData, err := f.FindElementByTag(dicomtag.PixelData)
+	if err != nil {
+		return nil, err
+	}
+	if len(pixelData.Value) != 1 || !pixelData.UndefinedLength {
+		return nil, fmt.Errorf("dicom.VideoStream: PixelData is not encapsulated")
+	}
+	image, ok := pixelData.Value[0].(PixelDataInfo)
+	if !ok {
+		return nil, fmt.Errorf("dicom.VideoStream: PixelData must have one value of type PixelDataInfo")
+	}
+	readers := make([]io.Reader, len(image.Frames))
+	for i, fragment := range image.Frames {
+		readers[i] = bytes.NewReader(fragment)
+	}
+	return io.MultiReader(readers...), nil
+}
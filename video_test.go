@@ -0,0 +1,32 @@
+package dicom_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/odincare/odicom"
+	"github.com/odincare/odicom/dicomtag"
+	"github.com/odincare/odicom/dicomtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoStream(t *testing.T) {
+	const h264 = "1.2.840.10008.1.2.4.102"
+	require.True(t, dicom.IsVideoTransferSyntax(h264))
+	require.False(t, dicom.IsVideoTransferSyntax(dicomtest.JPEGBaseline))
+
+	stream := []byte("\x00\x00\x00\x01\x67 not really an H.264 stream, but long enough for several fragments")
+	ds := newTestDataSet(h264)
+	ds.Elements = append(ds.Elements, &dicom.Element{Tag: dicomtag.PixelData, VR: "OB", UndefinedLength: true,
+		Value: []interface{}{dicom.PixelDataInfo{Offsets: []uint32{0}, Frames: [][]byte{stream[:32], stream[32:64], stream[64:]}}}})
+	ds = mustReadBytes(mustWriteDataSet(ds), dicom.ReadOptions{})
+	r, err := ds.VideoStream()
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	// The last fragment was padded to an even length.
+	require.Equal(t, append(stream, 0), data)
+
+	_, err = mustReadBytes(dicomtest.MustBytes(dicomtest.Spec{TransferSyntaxUID: dicomtest.JPEGBaseline}), dicom.ReadOptions{}).VideoStream()
+	require.Error(t, err)
+}
@@ -1,15 +1,49 @@
 package dicom
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 
 	"github.com/odincare/odicom/dicomio"
+	"github.com/odincare/odicom/dicomlog"
 	"github.com/odincare/odicom/dicomtag"
 )
 
+// defaultFileMetaInformationVersion 是P3.10 7.1规定的FileMetaInformationVersion
+var defaultFileMetaInformationVersion = []byte{0x00, 0x01}
+
+// fileMetaInformationVersion 返回metaElements中的FileMetaInformationVersion.
+// 没有或者不是2个bytes的OB时返回defaultFileMetaInformationVersion, 不是2个bytes时会输出warning.
+// 老版本的WriteFileHeader写的是字符串"0 1"
+func fileMetaInformationVersion(metaElements []*Element, logger dicomlog.Logger) []byte {
+	elem, err := FindElementByTag(metaElements, dicomtag.FileMetaInformationVersion)
+	if err != nil {
+		return defaultFileMetaInformationVersion
+	}
+	if err := checkFileMetaInformationVersion(elem); err != nil {
+		logger.Warn("dicom: replacing invalid FileMetaInformationVersion", dicomlog.Fields{
+			dicomlog.TagKey: dicomtag.DebugString(elem.Tag), "error": err.Error()})
+		return defaultFileMetaInformationVersion
+	}
+	return elem.Value[0].([]byte)
+}
+
+// checkFileMetaInformationVersion 检查elem是否是一个2 bytes的OB value
+func checkFileMetaInformationVersion(elem *Element) error {
+	if elem.VR != "OB" || len(elem.Value) != 1 {
+		return fmt.Errorf("expect one OB value, found VR %s with %d values", elem.VR, len(elem.Value))
+	}
+	v, ok := elem.Value[0].([]byte)
+	if !ok || len(v) != 2 {
+		return fmt.Errorf("expect 2 bytes, found %v", elem.Value[0])
+	}
+	return nil
+}
+
 // WriteFileHeader produces a Dicom file header. metaElements[] is be a list of
 // elements to be embedded in the header part. Every element in metaElements[]
 // must have Tag.Group==2. It must contain at least the following three elements:
@@ -17,11 +51,21 @@ import (
 // The list may contain other meta elements as long as their Tag.Group==2;
 // they are added to the header
 //
+// FileMetaInformationVersion is written as the two bytes 00H 01H unless
+// metaElements has a valid (two-byte OB) value, which is preserved. An
+// invalid value is replaced, with a warning.
+//
 // Errors are reported via e.Error().
 //
 // Consult the following page for the Dicom file header format
 // http://dicom.nema.org/dicom/2013/output/chtml/part10/chapter_7.html
 func WriteFileHeader(e *dicomio.Encoder, metaElements []*Element) {
+	writeFileHeader(e, metaElements, WriteOptions{})
+}
+
+// writeFileHeader 是WriteFileHeader, warning输出到options的logger
+func writeFileHeader(e *dicomio.Encoder, metaElements []*Element, options WriteOptions) {
+	defer dicomio.RecoverEncoder(e)
 
 	e.PushTransferSyntax(binary.LittleEndian, dicomio.ExplicitVR)
 	defer e.PopTransferSyntax()
@@ -46,14 +90,24 @@ func WriteFileHeader(e *dicomio.Encoder, metaElements []*Element) {
 		if elem, err := FindElementByTag(metaElements, tag); err == nil {
 			WriteElement(subEncoder, elem)
 		} else {
-			WriteElement(subEncoder, MustNewElement(tag, defaultValue))
+			elem, err := NewElement(tag, defaultValue)
+			if err != nil {
+				subEncoder.SetError(err)
+			} else {
+				WriteElement(subEncoder, elem)
+			}
 		}
 
 		tagsUsed[tag] = true
 	}
 
-	// TODO ?
-	writeOptionalMetaElement(dicomtag.FileMetaInformationVersion, []byte("0 1"))
+	version, err := NewElement(dicomtag.FileMetaInformationVersion, fileMetaInformationVersion(metaElements, options.logger))
+	if err != nil {
+		e.SetError(err)
+		return
+	}
+	WriteElement(subEncoder, version)
+	tagsUsed[dicomtag.FileMetaInformationVersion] = true
 	writeRequiredMetaElement(dicomtag.MediaStorageSOPClassUID)
 	writeRequiredMetaElement(dicomtag.MediaStorageSOPInstanceUID)
 	writeRequiredMetaElement(dicomtag.TransferSyntaxUID)
@@ -78,14 +132,32 @@ func WriteFileHeader(e *dicomio.Encoder, metaElements []*Element) {
 	e.WriteZeros(128)
 	e.WriteString("DICM")
 
-	WriteElement(e, MustNewElement(dicomtag.FileMetaInformationGroupLength, uint32(len(metaBytes))))
+	groupLength, err := NewElement(dicomtag.FileMetaInformationGroupLength, uint32(len(metaBytes)))
+	if err != nil {
+		e.SetError(err)
+		return
+	}
+	WriteElement(e, groupLength)
 
 	e.WriteBytes(metaBytes)
 }
 
 func writeRawItem(e *dicomio.Encoder, data []byte) {
-	encodeElementHeader(e, dicomtag.Item, "NA", uint32(len(data)))
+	encodeElementHeader(e, dicomtag.Item, "NA", paddedLength(data))
+	writePaddedBytes(e, data)
+}
+
+// paddedLength 返回补齐为偶数后的长度, P3.5 7.1.1 要求所有value length都是偶数
+func paddedLength(data []byte) uint32 {
+	return uint32(len(data) + len(data)%2)
+}
+
+// writePaddedBytes 写入data, 如果长度是奇数就在末尾补一个0
+func writePaddedBytes(e *dicomio.Encoder, data []byte) {
 	e.WriteBytes(data)
+	if len(data)%2 == 1 {
+		e.WriteByte(0)
+	}
 }
 
 func writeBasicOffsetTable(e *dicomio.Encoder, offsets []uint32) {
@@ -101,8 +173,11 @@ func writeBasicOffsetTable(e *dicomio.Encoder, offsets []uint32) {
 }
 
 func encodeElementHeader(e *dicomio.Encoder, tag dicomtag.Tag, vr string, vl uint32) {
-	// TODO ??? 这里是what
-	dicomio.DoAssert(vl == UndefinedLength || vl%2 == 0, vl)
+	if vl != UndefinedLength && vl%2 != 0 {
+		// 调用者应该已经补齐了value, 走到这里说明是一个bug, 不要让它crash整个进程
+		e.SetErrorf("dicom.WriteElement: %v: odd value length %d", dicomtag.DebugString(tag), vl)
+		return
+	}
 
 	e.WriteUInt16(tag.Group)
 	e.WriteUInt16(tag.Element)
@@ -113,11 +188,14 @@ func encodeElementHeader(e *dicomio.Encoder, tag dicomtag.Tag, vr string, vl uin
 	}
 
 	if implicit == dicomio.ExplicitVR {
-		dicomio.DoAssert(len(vr) == 2, vr)
+		if len(vr) != 2 {
+			e.SetErrorf("%v: invalid VR '%s'", dicomtag.DebugString(tag), vr)
+			return
+		}
 		e.WriteString(vr)
 
 		switch vr {
-		case "NA", "OB", "OD", "OF", "OL", "OW", "SQ", "UN", "UC", "UR", "UT":
+		case "NA", "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UN", "UC", "UR", "UT", "UV":
 			e.WriteZeros(2) // 2 bytes for "future use" (0000H)
 			e.WriteUInt32(vl)
 		default:
@@ -134,7 +212,18 @@ func encodeElementHeader(e *dicomio.Encoder, tag dicomtag.Tag, vr string, vl uin
 //
 // Requires: Each value in values[] must match the VR of the tag.
 // e.g. if tag is for UL, then each value must be uint32
+//
+// The VR used for encoding is, in order of precedence: elem.VR (i.e., the VR
+// found in the file the element was read from), the VR in the tag
+// dictionary, and "UN". This mirrors ReadElement, so an element read from
+// any file, including private and unknown tags, can be written back in either
+// implicit or explicit VR. A "UN" element's value is a single []byte and is
+// written as-is; for convenience, a list of strings is also accepted.
+//
+// Values longer than the VR allows (see Element.CheckValueLengths) are
+// written, but logged as warnings.
 func WriteElement(e *dicomio.Encoder, elem *Element) {
+	defer dicomio.RecoverEncoder(e)
 
 	vr := elem.VR
 
@@ -160,16 +249,34 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 	// 			dicomtag.DebugString(elem.Tag), vr, entry.VR)
 	// 	}
 	// }
+	warnValueLengths(elem, vr)
+
+	if elem.Tag == dicomtag.SpecificCharacterSet {
+		// 之后的string values用这个character set编码, 和Parser.Next读的时候一样
+		names, err := elem.GetStrings()
+		if err == nil {
+			var cs dicomio.CodingSystem
+			if cs, err = dicomio.ParseSpecificCharacterSet(names); err == nil {
+				e.SetCodingSystem(cs)
+			}
+		}
+		if err != nil {
+			e.SetErrorf("dicom.WriteElement: %v", err)
+			return
+		}
+	}
 
 	if elem.Tag == dicomtag.PixelData {
 		if len(elem.Value) != 1 {
 			// TODO 暂时用PixelDataInfo()
 			e.SetError(fmt.Errorf("PixelData element must have one value of type PixelDataInfo"))
+			return
 		}
 
 		image, ok := elem.Value[0].(PixelDataInfo)
 		if !ok {
 			e.SetError(fmt.Errorf("PixelData的子元素的类型必须是PixelDataInfo"))
+			return
 		}
 
 		if elem.UndefinedLength {
@@ -182,9 +289,14 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 
 			encodeElementHeader(e, dicomtag.SequenceDelimitationItem, "" /*未使用*/, 0)
 		} else {
-			dicomio.DoAssert(len(image.Frames) == 1, image.Frames) // TODO ?
-			encodeElementHeader(e, elem.Tag, vr, uint32(len(image.Frames[0])))
-			e.WriteBytes(image.Frames[0])
+			// Native pixel data: 多个frame直接拼接起来
+			data, err := concatFrames(image.Frames)
+			if err != nil {
+				e.SetError(err)
+				return
+			}
+			encodeElementHeader(e, elem.Tag, vr, paddedLength(data))
+			writePaddedBytes(e, data)
 		}
 
 		return
@@ -208,6 +320,7 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 		} else {
 
 			sube := dicomio.NewBytesEncoder(e.TransferSyntax())
+			sube.SetCodingSystem(e.CodingSystem())
 
 			for _, value := range elem.Value {
 				subelem, ok := value.(*Element)
@@ -235,6 +348,8 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 		if elem.UndefinedLength {
 			encodeElementHeader(e, elem.Tag, vr, UndefinedLength)
 
+			// item里的SpecificCharacterSet只对这个item有效
+			defer e.SetCodingSystem(e.CodingSystem())
 			for _, value := range elem.Value {
 				subelem, ok := value.(*Element)
 
@@ -249,6 +364,7 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 			encodeElementHeader(e, dicomtag.ItemDelimitationItem, "" /*未使用*/, 0)
 		} else {
 			sube := dicomio.NewBytesEncoder(e.TransferSyntax())
+			sube.SetCodingSystem(e.CodingSystem())
 
 			for _, value := range elem.Value {
 
@@ -291,7 +407,7 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 
 				sube.WriteUInt16(v)
 			}
-		case "UL":
+		case "UL", "OL":
 			for _, value := range elem.Value {
 				v, ok := value.(uint32)
 				if !ok {
@@ -311,6 +427,26 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 				}
 				sube.WriteInt32(v)
 			}
+		case "UV":
+			for _, value := range elem.Value {
+				v, ok := value.(uint64)
+				if !ok {
+					e.SetErrorf("%v: 需要是uint64类型, 而不是: %v",
+						dicomtag.DebugString(elem.Tag), value)
+					continue
+				}
+				sube.WriteUInt64(v)
+			}
+		case "SV":
+			for _, value := range elem.Value {
+				v, ok := value.(int64)
+				if !ok {
+					e.SetErrorf("%v: 需要是int64类型, 而不是: %v",
+						dicomtag.DebugString(elem.Tag), value)
+					continue
+				}
+				sube.WriteInt64(v)
+			}
 		case "SS":
 			for _, value := range elem.Value {
 				v, ok := value.(int16)
@@ -345,7 +481,18 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 				}
 				sube.WriteFloat64(v)
 			}
-		case "OW", "OB": // TODO 检查大小是不是均衡（even）. Byte swap??
+		case "UN":
+			if len(elem.Value) == 1 {
+				if bytes, ok := elem.Value[0].([]byte); ok {
+					sube.WriteBytes(bytes)
+					if len(bytes)%2 == 1 {
+						sube.WriteByte(0)
+					}
+					break
+				}
+			}
+			writeStringValues(e, sube, elem, vr, ' ')
+		case "OW", "OB", "OV": // TODO 检查大小是不是均衡（even）. Byte swap??
 			if len(elem.Value) != 1 {
 				e.SetErrorf("%v: 需要单个value, 而不是: %v",
 					dicomtag.DebugString(elem.Tag), elem.Value)
@@ -370,48 +517,29 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 					sube.WriteUInt16(v)
 				}
 				dicomio.DoAssert(d.Finish() == nil, d.Error())
-			} else { // vr=="OB"
+			} else { // vr=="OB" or "OV"
 				sube.WriteBytes(bytes)
 				if len(bytes)%2 == 1 {
 					sube.WriteByte(0)
 				}
 			}
 		case "UI":
-			s := ""
-			for i, value := range elem.Value {
-				substr, ok := value.(string)
+			writeStringValues(e, sube, elem, vr, 0)
+		case "AT":
+			for _, value := range elem.Value {
+				v, ok := value.(dicomtag.Tag)
 				if !ok {
-					e.SetErrorf("%v: 非字符串的值", dicomtag.DebugString(elem.Tag))
+					e.SetErrorf("%v: 需要是dicomtag.Tag类型, 而不是: %v",
+						dicomtag.DebugString(elem.Tag), value)
 					continue
 				}
-				if i > 0 {
-					s += "\\"
-				}
-				s += substr
+				sube.WriteUInt16(v.Group)
+				sube.WriteUInt16(v.Element)
 			}
-			sube.WriteString(s)
-			if len(s)%2 == 1 {
-				sube.WriteByte(0)
-			}
-		case "AT", "NA":
+		case "NA":
 			fallthrough
 		default:
-			s := ""
-			for i, value := range elem.Value {
-				substr, ok := value.(string)
-				if !ok {
-					e.SetErrorf("%v: 非字符串的值", dicomtag.DebugString(elem.Tag))
-					continue
-				}
-				if i > 0 {
-					s += "\\"
-				}
-				s += substr
-			}
-			sube.WriteString(s)
-			if len(s)%2 == 1 {
-				sube.WriteByte(' ')
-			}
+			writeStringValues(e, sube, elem, vr, ' ')
 		}
 
 		if sube.Error() != nil {
@@ -425,64 +553,175 @@ func WriteElement(e *dicomio.Encoder, elem *Element) {
 	}
 }
 
+// textVRs 是受SpecificCharacterSet影响的VRs, P3.5 6.1.2.3
+var textVRs = map[string]bool{"SH": true, "LO": true, "ST": true, "LT": true, "UT": true, "UC": true, "PN": true}
+
+// writeStringValues 把elem的string values用'\\'连接起来写入sube, 奇数长度时用padding补齐.
+// textVRs的值用e的coding system编码. 错误报告给e
+func writeStringValues(e, sube *dicomio.Encoder, elem *Element, vr string, padding byte) {
+	var s []byte
+	for i, value := range elem.Value {
+		substr, ok := value.(string)
+		if !ok {
+			e.SetErrorf("%v: 非字符串的值", dicomtag.DebugString(elem.Tag))
+			continue
+		}
+		if i > 0 {
+			s = append(s, '\\')
+		}
+		if !textVRs[vr] {
+			s = append(s, substr...)
+			continue
+		}
+		encoded, err := e.CodingSystem().Encode(substr, vr == "PN")
+		if err != nil {
+			e.SetErrorf("%v: %v", dicomtag.DebugString(elem.Tag), err)
+			continue
+		}
+		s = append(s, encoded...)
+	}
+	sube.WriteBytes(s)
+	if len(s)%2 == 1 {
+		sube.WriteByte(padding)
+	}
+}
+
+// concatFrames 把native pixel data的frames拼接成一个value. 所有frame的大小必须相同
+func concatFrames(frames [][]byte) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("dicom.WriteElement: native PixelData has no frames")
+	}
+	if len(frames) == 1 {
+		return frames[0], nil
+	}
+	data := make([]byte, 0, len(frames)*len(frames[0]))
+	for i, frame := range frames {
+		if len(frame) != len(frames[0]) {
+			return nil, fmt.Errorf("dicom.WriteElement: native PixelData frame %d has %d bytes, but frame 0 has %d",
+				i, len(frame), len(frames[0]))
+		}
+		data = append(data, frame...)
+	}
+	return data, nil
+}
+
+// prepareNativeFrames checks the frames of defined-length (native)
+// PixelData against Rows, Columns, SamplesPerPixel and BitsAllocated. If
+// there's more than one frame, it returns a copy of ds's elements with
+// NumberOfFrames set to the number of frames; otherwise ds.Elements itself.
+func prepareNativeFrames(ds *DataSet) ([]*Element, error) {
+	pixelData, err := ds.FindElementByTag(dicomtag.PixelData)
+	if err != nil || pixelData.UndefinedLength || len(pixelData.Value) != 1 {
+		return ds.Elements, nil
+	}
+	image, ok := pixelData.Value[0].(PixelDataInfo)
+	if !ok || len(image.Frames) <= 1 {
+		return ds.Elements, nil
+	}
+
+	var dims [4]uint16
+	for i, tag := range []dicomtag.Tag{dicomtag.Rows, dicomtag.Columns, dicomtag.SamplesPerPixel, dicomtag.BitsAllocated} {
+		elem, err := ds.FindElementByTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("dicom.WriteDataSet: multi-frame PixelData requires %v", dicomtag.DebugString(tag))
+		}
+		if dims[i], err = elem.GetUInt16(); err != nil {
+			return nil, err
+		}
+	}
+	bits := int(dims[0]) * int(dims[1]) * int(dims[2]) * int(dims[3])
+	if bits%8 != 0 {
+		return nil, fmt.Errorf("dicom.WriteDataSet: frames of %d bits are not byte aligned; pack them into a single frame", bits)
+	}
+	for i, frame := range image.Frames {
+		if len(frame) != bits/8 {
+			return nil, fmt.Errorf("dicom.WriteDataSet: PixelData frame %d has %d bytes, expect %d (Rows*Columns*SamplesPerPixel*BitsAllocated/8)",
+				i, len(frame), bits/8)
+		}
+	}
+
+	copied := &DataSet{Elements: append([]*Element{}, ds.Elements...)}
+	copied.setElement(MustNewElement(dicomtag.NumberOfFrames, strconv.Itoa(len(image.Frames))))
+	return copied.Elements, nil
+}
+
 // WriteDataSet writes the dataset into the stream in DICOM file format,
 // complete with the magic header and metadata elements.
 //
 // The transfer syntax (byte order, etc) of the file is determined by the
 // TransferSyntax element in "ds". If ds is missing that or a few other
-// essential elements, this function returns an error.
+// essential elements, this function returns an error. For Deflated
+// Explicit VR Little Endian, everything after the file meta group is
+// deflated (P3.5 A.5).
 //
 //  ds := ... read or create dicom.Dataset ...
 //  out, err := os.Create("test.dcm")
 //  err := dicom.Write(out, ds)
 func WriteDataSet(out io.Writer, ds *DataSet) error {
-	e := dicomio.NewEncoder(out, nil, dicomio.UnknownVR)
-	var metaElems []*Element
-	for _, elem := range ds.Elements {
-		if elem.Tag.Group == dicomtag.MetadataGroup {
-			metaElems = append(metaElems, elem)
-		}
-	}
-	WriteFileHeader(e, metaElems)
-	if e.Error() != nil {
-		return e.Error()
-	}
-	endian, implicit, err := getTransferSyntax(ds)
+	e := dicomio.NewBufferedEncoder(out, nil, dicomio.UnknownVR)
+	return WriteDataSetToBytes(e, ds)
+}
+
+// WriteDataSetToBytes is similar to WriteDataSet, but writes to an existing
+// encoder.
+//
+// The encoder is flushed before returning.
+//
+// Native (defined-length) PixelData may hold several frames of the same
+// size; they are concatenated, and NumberOfFrames is set in the output.
+// Registered ComputedElements with OnWrite set are added if missing.
+// "ds" itself isn't modified.
+func WriteDataSetToBytes(e *dicomio.Encoder, ds *DataSet) (err error) {
+	return writeDataSet(e, ds, WriteOptions{})
+}
+
+// writeDataSet 是WriteDataSetToBytes, 按options写
+func writeDataSet(e *dicomio.Encoder, ds *DataSet, options WriteOptions) (err error) {
+	defer dicomio.Recover(&err)
+	elems, err := prepareNativeFrames(ds)
 	if err != nil {
 		return err
 	}
-	e.PushTransferSyntax(endian, implicit)
-	for _, elem := range ds.Elements {
-		if elem.Tag.Group != dicomtag.MetadataGroup {
-			WriteElement(e, elem)
-		}
+	// 加上OnWrite的ComputedElements. 复制一份, ds本身不被修改
+	written := &DataSet{Elements: append([]*Element{}, elems...)}
+	if err := written.addComputedElements(true); err != nil {
+		return err
 	}
-	e.PopTransferSyntax()
-	return e.Error()
-}
-func WriteDataSetToBytes(e *dicomio.Encoder, ds *DataSet) error {
+	elems = written.Elements
 	var metaElems []*Element
-	for _, elem := range ds.Elements {
+	for _, elem := range elems {
 		if elem.Tag.Group == dicomtag.MetadataGroup {
 			metaElems = append(metaElems, elem)
 		}
 	}
-	WriteFileHeader(e, metaElems)
+	writeFileHeader(e, metaElems, options)
 	if e.Error() != nil {
 		return e.Error()
 	}
-	endian, implicit, err := getTransferSyntax(ds)
-	if err != nil {
+	if _, _, err := getTransferSyntax(ds); err != nil {
 		return err
 	}
-	e.PushTransferSyntax(endian, implicit)
-	for _, elem := range ds.Elements {
+	uid, _ := ds.transferSyntaxUID()
+	e.PushTransferSyntaxByUID(uid)
+	for _, elem := range elems {
+		if options.ctx != nil {
+			if err := options.ctx.Err(); err != nil {
+				return err
+			}
+		}
 		if elem.Tag.Group != dicomtag.MetadataGroup {
 			WriteElement(e, elem)
 		}
 	}
 	e.PopTransferSyntax()
-	return e.Error()
+	return e.Flush()
+}
+
+// WriteDataSetWithContext is WriteDataSet, but stops writing with ctx.Err()
+// when "ctx" is canceled, between elements. The output is then incomplete.
+func WriteDataSetWithContext(ctx context.Context, out io.Writer, ds *DataSet) error {
+	e := dicomio.NewBufferedEncoder(out, nil, dicomio.UnknownVR)
+	return writeDataSet(e, ds, WriteOptions{ctx: ctx})
 }
 
 // WriteDataSetToFile writes "ds" to the given file. If the file already exists,